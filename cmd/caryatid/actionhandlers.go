@@ -1,11 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/mrled/caryatid/internal/util"
 	"github.com/mrled/caryatid/pkg/caryatid"
 )
 
@@ -18,124 +31,1086 @@ func testValidUri(uri string) bool {
 	return matched
 }
 
+// convertLocalPathToUri converts a local path, like '~/boxes/testbox.json' or 'C:\boxes\testbox.json', to an absolute file:// URI
+// A leading '~' and environment variables are expanded; see caryatid.ExpandLocalPath() and caryatid.LocalPathToUri()
 func convertLocalPathToUri(path string) (uri string, err error) {
+	if path, err = caryatid.ExpandLocalPath(path); err != nil {
+		return
+	}
 	abspath, err := filepath.Abs(path)
-	uri = fmt.Sprintf("file://%v", abspath)
+	if err != nil {
+		return
+	}
+	uri = caryatid.LocalPathToUri(abspath)
 	return
 }
 
+// stdinReader is where a box file passed as '-box -' is read from
+var stdinReader io.Reader = os.Stdin
+
+// getManager returns a BackendManager for the catalog at catalogUri, configured by the flags; see newManagerFromFlags()
+// If -update-index was passed, the manager also rebuilds the catalog index under that directory whenever it changes the catalog
 func getManager(catalogUri string) (manager *caryatid.BackendManager, err error) {
+	if manager, err = newManagerFromFlags(catalogUri); err != nil || updateIndexFlag == "" {
+		return
+	}
+	if manager.IndexManager, err = newManagerFromFlags(updateIndexFlag); err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager for the -update-index directory")
+	}
+	return
+}
+
+// newManagerFromFlags returns a BackendManager for catalogUri, which may also be a local path, with its settings taken from the flags
+func newManagerFromFlags(catalogUri string) (manager *caryatid.BackendManager, err error) {
 	var uri string
 	if testValidUri(catalogUri) {
-		uri = catalogUri
+		// Expand file:// URIs here, so that the box URLs recorded in the catalog are expanded too
+		if uri, err = caryatid.ExpandFileUri(catalogUri); err != nil {
+			caryatid.LogErrorf("Error expanding catalog URI '%v': %v", catalogUri, err)
+			return
+		}
+		uri = caryatid.NormalizeFileUri(uri)
 	} else {
 		// Handle a special case where the -catalog is a local path, rather than a file:// URI
 		uri, err = convertLocalPathToUri(catalogUri)
 		if err != nil {
-			log.Printf("Error converting catalog path '%v' to URI: %v", catalogUri, err)
+			caryatid.LogErrorf("Error converting catalog path '%v' to URI: %v", catalogUri, err)
 			return
 		}
 	}
-	log.Printf("Using catalog URI of '%v'", uri)
+	caryatid.LogInfof("Using catalog URI of '%v'", uri)
 
 	backend, err := caryatid.NewBackendFromUri(uri)
 	if err != nil {
-		log.Printf("Error retrieving backend: %v\n", err)
+		caryatid.LogErrorf("Error retrieving backend: %v\n", err)
 		return
 	}
 
-	manager = caryatid.NewBackendManager(uri, &backend)
+	if timeoutFlag > 0 && backend.Scheme() != "file" {
+		backend = caryatid.NewTimeoutBackend(backend, timeoutFlag)
+	}
+	if retriesFlag > 0 {
+		backend = caryatid.NewRetryBackend(backend, retriesFlag+1)
+	}
+	if readOnlyFlag {
+		backend = caryatid.NewReadOnlyBackend(backend)
+	}
+
+	manager = caryatid.NewBackendManagerWithLogger(uri, &backend, caryatid.WithLogField(caryatid.GetLogger(), "catalog", uri))
+	manager.Context = interruptContext
+	manager.LockTimeout = lockTimeoutFlag
+	manager.UrlPrefix = urlPrefixFlag
+	manager.RelativeUrls = relativeUrlsFlag
+	manager.FilenameTemplate = filenameTemplateFlag
+	manager.AuditLogPath = auditLogFlag
+	manager.AuditLogRequired = auditLogRequiredFlag
+	if webhookFlag != "" {
+		if err = caryatid.ValidateWebhookUrl(webhookFlag); err != nil {
+			return
+		}
+		manager.WebhookUrl = webhookFlag
+		manager.WebhookTimeout = webhookTimeoutFlag
+	}
+	manager.LatestAlias = latestAliasFlag
+	manager.VerifyAfterCopy = verifyAfterCopyFlag
+	if checksumCacheFlag != "" {
+		manager.ChecksumCache = caryatid.NewChecksumCache(checksumCacheFlag)
+		manager.ChecksumCache.Refresh = noCacheFlag
+	}
+	if manager.FileMode, err = caryatid.ParseFileMode(fileModeFlag); err != nil {
+		return
+	}
+	if manager.DirMode, err = caryatid.ParseDirMode(dirModeFlag); err != nil {
+		return
+	}
+	if jsonStyleFlag != "" {
+		if manager.JSONStyle, err = caryatid.ParseJSONStyle(jsonStyleFlag); err != nil {
+			return
+		}
+	}
+	if backupFlag {
+		manager.CatalogBackups = backupCountFlag
+	} else {
+		manager.CatalogBackups = 0
+	}
 	return
 }
 
-func showAction(catalogUri string) (result string, err error) {
+// newProgressPrinter returns a function that renders copy progress as a single line,
+// updated at most a couple of times per second
+func newProgressPrinter(writer io.Writer) caryatid.CopyProgressFunc {
+	var lastPrinted time.Time
+	return func(transferred int64, total int64) {
+		done := total > 0 && transferred >= total
+		if !done && time.Since(lastPrinted) < 500*time.Millisecond {
+			return
+		}
+		lastPrinted = time.Now()
+		if total > 0 {
+			fmt.Fprintf(writer, "\rCopied %v of %v bytes (%.0f%%)", transferred, total, 100*float64(transferred)/float64(total))
+		} else {
+			fmt.Fprintf(writer, "\rCopied %v bytes", transferred)
+		}
+		if done {
+			fmt.Fprintf(writer, "\n")
+		}
+	}
+}
+
+// showAction returns the whole catalog, as a table if output is 'table', or as text otherwise
+func showAction(catalogUri string, output string) (result string, err error) {
 	manager, err := getManager(catalogUri)
 	if err != nil {
 		return "", err
 	}
 	catalog, err := manager.GetCatalog()
-	if err != nil {
+	if errors.Is(err, caryatid.ErrCatalogNotFound) {
+		err = nil
+	} else if err != nil {
 		return "", err
 	}
-	result = fmt.Sprintf("%v\n", catalog)
+	if output == "table" {
+		sorted := catalog.Sorted()
+		return sorted.TableString(), nil
+	}
+	result = catalog.DisplayString()
 	return
 }
 
 func createTestBoxAction(boxName string, providerName string) (err error) {
 	err = caryatid.CreateTestBoxFile(boxName, providerName, true)
 	if err != nil {
-		log.Printf("Error creating a test box file: %v", err)
+		caryatid.LogErrorf("Error creating a test box file: %v", err)
 		return
 	} else {
-		log.Printf("Box file created at '%v'", boxName)
+		caryatid.LogInfof("Box file created at '%v'", boxName)
 	}
 	return
 }
 
-func addAction(boxPath string, boxName string, boxDescription string, boxVersion string, catalogUri string) (err error) {
-	// TODO: Reduce code duplication between here and packer-post-processor-caryatid
-	digestType, digest, provider, err := caryatid.DeriveArtifactInfoFromBoxFile(boxPath)
-	if err != nil {
-		panic(fmt.Sprintf("Could not determine artifact info: %v", err))
+// addParams describes the boxes that addAction() adds
+type addParams struct {
+	Name        string
+	Description string
+	Version     string
+
+	// If set, recorded as the display name of the catalog
+	DisplayName string
+
+	// If set, recorded as the description of Version
+	VersionDescription string
+
+	// If empty, the architecture is read from each box's metadata, if it has one
+	Architecture string
+
+	// Recorded for each box in the catalog, replacing any labels it already had
+	Labels map[string]string
+
+	// One or more comma-separated checksum types to record
+	ChecksumType string
+
+	// If set, an uncompressed box file is compressed with gzip first, and the catalog records the checksum of the compressed file
+	Compress bool
+
+	// If set, the box file must have this checksum, or nothing is added
+	// ExpectedChecksumType is its type; if empty, it is the first type in ChecksumType
+	ExpectedChecksum     string
+	ExpectedChecksumType string
+}
+
+// addAction adds box files to the catalog
+// All the box files are added to the same version at once, so the catalog never has only some of them; see BackendManager.AddBoxes()
+// If a box path is "-", the box file is read from stdin
+func addAction(boxPaths []string, catalogUri string, params addParams) (err error) {
+	artifacts := []caryatid.BoxArtifact{}
+	for _, boxPath := range boxPaths {
+		if boxPath == "-" {
+			var cleanupStdin func()
+			if boxPath, cleanupStdin, err = caryatid.BufferBoxFile(stdinReader); err != nil {
+				err = fmt.Errorf("Could not read box file from stdin: %v", err)
+				return
+			}
+			defer cleanupStdin()
+		}
+
+		if params.ExpectedChecksum != "" {
+			expectedType := params.ExpectedChecksumType
+			if expectedType == "" {
+				expectedType = strings.TrimSpace(strings.Split(params.ChecksumType, ",")[0])
+			}
+			if err = caryatid.VerifyFileChecksum(boxPath, expectedType, params.ExpectedChecksum); err != nil {
+				err = fmt.Errorf("Not adding box file: %w", err)
+				return
+			}
+		}
+
+		artifact, cleanup, prepareErr := caryatid.PrepareBoxArtifact(boxPath, params.ChecksumType, params.Compress)
+		if prepareErr != nil {
+			err = prepareErr
+			return
+		}
+		defer cleanup()
+		artifact.Name = params.Name
+		artifact.DisplayName = params.DisplayName
+		artifact.Description = params.Description
+		artifact.Version = params.Version
+		artifact.VersionDescription = params.VersionDescription
+		if params.Architecture != "" {
+			artifact.Architecture = params.Architecture
+		}
+		if len(params.Labels) > 0 {
+			artifact.Labels = params.Labels
+		}
+		artifacts = append(artifacts, artifact)
 	}
 
 	manager, err := getManager(catalogUri)
 	if err != nil {
-		log.Printf("Error getting a BackendManager")
+		caryatid.LogErrorf("Error getting a BackendManager")
 		return
 	}
 
-	err = manager.AddBox(boxPath, boxName, boxDescription, boxVersion, provider, digestType, digest)
+	if !quietFlag {
+		manager.CopyProgress = newProgressPrinter(os.Stderr)
+		manager.ProgressThreshold = progressThresholdFlag
+	}
+	manager.UploadPartSize = partSizeFlag
+	manager.Logger = caryatid.WithLogField(caryatid.WithLogField(manager.Logger, "box", params.Name), "version", params.Version)
+	manager.AllowNonstandardVersion = allowNonstandardVersionFlag
+	manager.NormalizeVersions = normalizeVersionsFlag
+	manager.Overwrite = overwriteFlag || forceFlag
+
+	err = manager.AddBoxes(artifacts)
 	if err != nil {
-		log.Printf("Error adding box metadata to catalog: %v\n", err)
+		caryatid.LogErrorf("Error adding box metadata to catalog: %v\n", err)
 		return
 	}
-	log.Println("Box successfully added to backend")
+	caryatid.LogInfof("Boxes successfully added to backend\n")
 
 	catalog, err := manager.GetCatalog()
 	if err != nil {
-		log.Printf("Error getting catalog: %v\n", err)
+		caryatid.LogErrorf("Error getting catalog: %v\n", err)
 		return
 	}
-	log.Printf("New catalog is:\n%v\n", catalog)
+	caryatid.LogDebugf("New catalog is:\n%v\n", catalog)
 
 	return
 }
 
-func queryAction(catalogUri string, versionQuery string, providerQuery string) (result caryatid.Catalog, err error) {
+// importAction adds each box file in dir whose name matches -filename-template to the catalog, with the name and version from its file name
+// The provider and architecture come from the box's own metadata, as with addAction(), and the boxes for each version are added together
+// Files for a box other than boxName, or the catalog's box if boxName is empty, are skipped with a warning
+// With dryRun, it lists the boxes it would import, without computing their checksums or changing the catalog
+func importAction(dir string, catalogUri string, boxName string, displayName string, boxDescription string, checksumType string, dryRun bool) (result string, err error) {
+	found, err := caryatid.FindBoxFiles(dir, filenameTemplateFlag)
+	if err != nil {
+		caryatid.LogErrorf("Error finding box files in '%v': %v\n", dir, err)
+		return
+	}
+
 	manager, err := getManager(catalogUri)
 	if err != nil {
-		log.Printf("Error getting a BackendManager")
+		caryatid.LogErrorf("Error getting a BackendManager")
 		return
 	}
+	if !quietFlag {
+		manager.CopyProgress = newProgressPrinter(os.Stderr)
+		manager.ProgressThreshold = progressThresholdFlag
+	}
+	manager.UploadPartSize = partSizeFlag
+	manager.AllowNonstandardVersion = allowNonstandardVersionFlag
+	manager.NormalizeVersions = normalizeVersionsFlag
+	manager.Overwrite = overwriteFlag
 
 	catalog, err := manager.GetCatalog()
+	if errors.Is(err, caryatid.ErrCatalogNotFound) {
+		err = nil
+	} else if err != nil {
+		caryatid.LogErrorf("Error getting catalog: %v\n", err)
+		return
+	}
+	if boxName == "" {
+		boxName = catalog.Name
+	}
+	if boxDescription == "" {
+		boxDescription = catalog.Description
+	}
+
+	versions := []string{}
+	byVersion := make(map[string][]caryatid.BoxArtifact)
+	for _, artifact := range found {
+		if artifact.Name == "" {
+			// The filename template does not include the name
+			artifact.Name = boxName
+		} else if boxName == "" {
+			boxName = artifact.Name
+		}
+		if artifact.Name != boxName {
+			caryatid.LogWarnf("Skipping '%v', which is for the box '%v' rather than '%v'\n", artifact.Path, artifact.Name, boxName)
+			continue
+		}
+		if _, ok := byVersion[artifact.Version]; !ok {
+			versions = append(versions, artifact.Version)
+		}
+		byVersion[artifact.Version] = append(byVersion[artifact.Version], artifact)
+	}
+	if len(versions) == 0 {
+		err = fmt.Errorf("No box files in '%v' to import", dir)
+		return
+	} else if boxName == "" {
+		err = fmt.Errorf("The filename template does not include the name of the box, so it must be passed with -name")
+		return
+	}
+
+	for _, version := range versions {
+		if dryRun {
+			for _, artifact := range byVersion[version] {
+				result += fmt.Sprintf("WOULD IMPORT %v %v %v (%v)\n", boxName, version, artifact.Provider, artifact.Path)
+			}
+			continue
+		}
+
+		artifacts := []caryatid.BoxArtifact{}
+		for _, found := range byVersion[version] {
+			artifact, deriveErr := caryatid.DeriveArtifactInfoFromBoxFile(found.Path, checksumType)
+			if deriveErr != nil {
+				err = fmt.Errorf("Could not determine artifact info for '%v': %v", found.Path, deriveErr)
+				return
+			}
+			artifact.Name = boxName
+			artifact.DisplayName = displayName
+			artifact.Description = boxDescription
+			artifact.Version = version
+			artifacts = append(artifacts, artifact)
+		}
+		if err = manager.AddBoxes(artifacts); err != nil {
+			caryatid.LogErrorf("Error importing version '%v': %v\n", version, err)
+			return
+		}
+		for _, artifact := range artifacts {
+			result += fmt.Sprintf("IMPORTED %v %v %v (%v)\n", boxName, version, artifact.Provider, artifact.Path)
+		}
+	}
+	return
+}
+
+func queryAction(catalogUri string, queryParams caryatid.CatalogQueryParams) (result caryatid.Catalog, err error) {
+	manager, err := getManager(catalogUri)
 	if err != nil {
-		log.Printf("Error getting catalog: %v\n", err)
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+
+	catalog, err := manager.GetCatalog()
+	if errors.Is(err, caryatid.ErrCatalogNotFound) {
+		err = nil
+	} else if err != nil {
+		caryatid.LogErrorf("Error getting catalog: %v\n", err)
 		return
 	}
 
-	queryParams := caryatid.CatalogQueryParams{Version: versionQuery, Provider: providerQuery}
 	result, err = catalog.QueryCatalog(queryParams)
 	if err != nil {
-		log.Printf("Error querying catalog: %v\n", err)
+		caryatid.LogErrorf("Error querying catalog: %v\n", err)
+		return
+	}
+
+	return
+}
+
+// errNoMatches is returned by actions whose output scripts test, like 'query -count', when the query matches nothing
+// The caryatid command exits with status 1 for it, without printing an error
+var errNoMatches = errors.New("No boxes matched the query")
+
+// errVerificationFailed is wrapped by the errors that actions which check boxes or the catalog, like 'verify' and 'check', return when they find a problem
+var errVerificationFailed = errors.New("Verification failed")
+
+// countQueryResult returns the number of providers in the result of a query
+func countQueryResult(catalog caryatid.Catalog) (result string, err error) {
+	count := len(catalog.BoxReferences())
+	result = fmt.Sprintf("%v\n", count)
+	if count == 0 {
+		err = errNoMatches
+	}
+	return
+}
+
+// urlQueryResult returns the URL of each provider in the result of a query, one per line
+// If nothing matched, the result is empty
+func urlQueryResult(catalog caryatid.Catalog) (result string) {
+	for _, ref := range catalog.BoxReferences() {
+		result += fmt.Sprintf("%v\n", ref.Uri)
+	}
+	return
+}
+
+// groupedQueryResult returns the result of a query grouped by provider name,
+// with a header line for each provider in alphabetical order, and its matching versions under it in order; see caryatid.Catalog.SortedBy()
+// If nothing matched, the result is empty
+func groupedQueryResult(catalog caryatid.Catalog, order caryatid.SortOrder) (result string, err error) {
+	sorted, err := catalog.SortedBy(order)
+	if err != nil {
 		return
 	}
+	names := []string{}
+	groups := make(map[string]string)
+	for _, v := range sorted.Versions {
+		for _, p := range v.Providers {
+			if _, ok := groups[p.Name]; !ok {
+				names = append(names, p.Name)
+			}
+			line := fmt.Sprintf("  v%v", v.Version)
+			if p.Architecture != "" {
+				line += fmt.Sprintf(" %v", p.Architecture)
+			}
+			groups[p.Name] += fmt.Sprintf("%v %v:%v <%v>\n", line, p.ChecksumType, p.Checksum, p.Url)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result += fmt.Sprintf("%v\n%v", name, groups[name])
+	}
+	return
+}
 
+// deleteAction deletes the boxes matched by the query, along with their box files
+// With dryRun, it lists the box files it would delete and the space that would free, without changing the backend
+func deleteAction(catalogUri string, queryParams caryatid.CatalogQueryParams, dryRun bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+	manager.DryRun = dryRun
+
+	deleted, err := manager.DeleteBox(queryParams)
+	if err != nil {
+		return
+	}
+
+	action := "DELETED"
+	if dryRun {
+		action = "WOULD DELETE"
+	}
+	result = deletionSummary(manager, action, deleted, dryRun)
 	return
 }
 
-func deleteAction(catalogUri string, versionQuery string, providerQuery string) (err error) {
+// deletionSummary lists the box files that an action like 'delete' or 'prune' deleted, with the URI of each one in the backend
+// For a dry run, it also totals the bytes that deleting them would free, counting only the boxes whose size the catalog records
+func deletionSummary(manager *caryatid.BackendManager, action string, refs caryatid.BoxReferenceList, dryRun bool) (result string) {
+	var (
+		freed   int64
+		unsized int
+	)
+	for _, ref := range refs {
+		result += fmt.Sprintf("%v %v %v (%v)\n", action, ref.Version, ref.ProviderName, manager.BoxFileUri(ref))
+		if ref.Size > 0 {
+			freed += ref.Size
+		} else {
+			unsized++
+		}
+	}
+	if !dryRun {
+		return
+	}
+	result += fmt.Sprintf("Would free %v bytes across %v files", freed, len(refs)-unsized)
+	if unsized > 0 {
+		result += fmt.Sprintf(", plus %v files of unknown size", unsized)
+	}
+	result += "\n"
+	return
+}
+
+// verifyAction checks that each box matched by the query exists and matches its checksum
+// The result is a PASS/FAIL summary for each box;
+// err is set if any box failed, so that the caller can exit nonzero
+func verifyAction(catalogUri string, queryParams caryatid.CatalogQueryParams, concurrency int) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+	manager.Concurrency = concurrency
+
+	verifications, err := manager.VerifyBoxes(queryParams)
+	if err != nil {
+		return
+	}
+
+	failed := 0
+	for _, v := range verifications {
+		if v.Passed() {
+			result += fmt.Sprintf("PASS %v %v\n", v.Version, v.ProviderName)
+		} else {
+			failed += 1
+			result += fmt.Sprintf("FAIL %v %v (%v): %v\n", v.Version, v.ProviderName, v.Uri, v.Err)
+		}
+	}
+	result += fmt.Sprintf("%v of %v boxes passed verification\n", len(verifications)-failed, len(verifications))
+
+	if failed > 0 {
+		err = fmt.Errorf("%w: %v of %v boxes failed", errVerificationFailed, failed, len(verifications))
+	}
+	return
+}
+
+// doctorAction checks that the backend for the catalog is reachable, and writable unless it is read-only, without changing the catalog
+// Each step is reported, and err wraps errVerificationFailed if any failed
+func doctorAction(catalogUri string) (result string, err error) {
 	manager, err := getManager(catalogUri)
 	if err != nil {
-		log.Printf("Error getting a BackendManager")
+		result = fmt.Sprintf("FAIL resolve backend: %v\n", err)
+		err = fmt.Errorf("%w: could not resolve the backend", errVerificationFailed)
 		return
 	}
+	result = fmt.Sprintf("PASS resolve backend: %v\n", manager.CatalogUri)
+
+	failed := 0
+	for _, step := range manager.HealthCheck() {
+		switch {
+		case !step.Passed():
+			failed += 1
+			result += fmt.Sprintf("FAIL %v: %v\n", step.Name, step.Err)
+		case step.Skipped:
+			result += fmt.Sprintf("SKIP %v: %v\n", step.Name, step.Detail)
+		default:
+			result += fmt.Sprintf("PASS %v: %v\n", step.Name, step.Detail)
+		}
+	}
 
-	queryParams := caryatid.CatalogQueryParams{Version: versionQuery, Provider: providerQuery}
-	if err = manager.DeleteBox(queryParams); err != nil {
+	if failed > 0 {
+		err = fmt.Errorf("%w: %v health checks failed", errVerificationFailed, failed)
+	}
+	return
+}
+
+// recomputeChecksumsAction rehashes each box matched by the query with checksumType and updates the catalog
+// Boxes that cannot be read are reported and skipped
+func recomputeChecksumsAction(catalogUri string, queryParams caryatid.CatalogQueryParams, checksumType string, dryRun bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
 		return
 	}
+	manager.DryRun = dryRun
+
+	updates, err := manager.RecomputeChecksums(queryParams, checksumType)
+	if err != nil {
+		return
+	}
+
+	updated := "UPDATED"
+	if dryRun {
+		updated = "WOULD UPDATE"
+	}
+	for _, u := range updates {
+		if u.Err != nil {
+			result += fmt.Sprintf("SKIPPED %v %v (%v): %v\n", u.Version, u.ProviderName, u.Uri, u.Err)
+		} else if u.Changed() {
+			result += fmt.Sprintf("%v %v %v: %v:%v -> %v:%v", updated, u.Version, u.ProviderName, u.OldChecksumType, u.OldChecksum, u.NewChecksumType, u.NewChecksum)
+			if u.NewSize > 0 {
+				result += fmt.Sprintf(", size %v bytes", u.NewSize)
+			}
+			result += "\n"
+		} else {
+			result += fmt.Sprintf("UNCHANGED %v %v\n", u.Version, u.ProviderName)
+		}
+	}
+	return
+}
+
+// pruneAction deletes all but the keep newest versions of the boxes matched by the query
+func pruneAction(catalogUri string, queryParams caryatid.CatalogQueryParams, keep int, prunePrereleases bool, dryRun bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+	manager.DryRun = dryRun
+
+	pruned, err := manager.PruneVersions(queryParams, keep, prunePrereleases)
+	if err != nil {
+		return
+	}
+
+	action := "PRUNED"
+	if dryRun {
+		action = "WOULD PRUNE"
+	}
+	result = deletionSummary(manager, action, pruned, dryRun)
+	return
+}
+
+// tagAction adds the tags in add to, and removes the tags in remove from, the versions matched by the query
+func tagAction(catalogUri string, queryParams caryatid.CatalogQueryParams, add []string, remove []string, dryRun bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+	manager.DryRun = dryRun
+
+	tagged, err := manager.TagVersions(queryParams, add, remove)
+	if err != nil {
+		return
+	}
+
+	action := "TAGGED"
+	if dryRun {
+		action = "WOULD TAG"
+	}
+	for _, version := range tagged {
+		result += fmt.Sprintf("%v %v\n", action, version)
+	}
+	if len(tagged) == 0 {
+		result = "UNCHANGED\n"
+	}
+	return
+}
+
+// pruneToSizeAction deletes the oldest versions of the boxes matched by the query until the rest fit in maxTotalSize bytes
+// The result ends with the total size of the boxes that are kept
+func pruneToSizeAction(catalogUri string, queryParams caryatid.CatalogQueryParams, maxTotalSize int64, prunePrereleases bool, dryRun bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+	manager.DryRun = dryRun
+
+	pruned, remaining, err := manager.PruneToSize(queryParams, maxTotalSize, prunePrereleases)
+	if errors.Is(err, caryatid.ErrUnknownSize) {
+		err = fmt.Errorf("%w; run 'caryatid recompute-checksums -catalog %v' first to record the sizes of boxes added by older versions of caryatid", err, catalogUri)
+		return
+	} else if err != nil {
+		return
+	}
+
+	action := "PRUNED"
+	if dryRun {
+		action = "WOULD PRUNE"
+	}
+	result = deletionSummary(manager, action, pruned, dryRun)
+	total := "Total size is now"
+	if dryRun {
+		total = "Total size would be"
+	}
+	result += fmt.Sprintf("%v %v bytes (%v), within the budget of %v bytes (%v)\n", total, remaining, util.FormatSize(remaining), maxTotalSize, util.FormatSize(maxTotalSize))
+	return
+}
 
+// mergeAction merges the catalog at sourceUri into the catalog at catalogUri
+// If copyBoxes is set, box files are copied into the destination backend as well
+func mergeAction(catalogUri string, sourceUri string, copyBoxes bool, overwrite bool, dryRun bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+	sourceManager, err := getManager(sourceUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager for the source catalog")
+		return
+	}
+	if !quietFlag {
+		manager.CopyProgress = newProgressPrinter(os.Stderr)
+		manager.ProgressThreshold = progressThresholdFlag
+	}
+	manager.UploadPartSize = partSizeFlag
+	manager.DryRun = dryRun
+
+	merged, err := manager.MergeCatalog(sourceManager, copyBoxes, overwrite)
+	if err != nil {
+		return
+	}
+	verb := "MERGED"
+	if dryRun {
+		verb = "WOULD MERGE"
+	}
+	for _, ref := range merged {
+		result += fmt.Sprintf("%v %v %v\n", verb, ref.Version, ref.ProviderName)
+	}
+	return
+}
+
+// copyAction copies the boxes matched by queryParams from the catalog at sourceUri into the catalog at catalogUri, along with their box files
+// If move is set, they are deleted from the source catalog afterwards
+func copyAction(catalogUri string, sourceUri string, queryParams caryatid.CatalogQueryParams, move bool, dryRun bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+	sourceManager, err := getManager(sourceUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager for the source catalog")
+		return
+	}
+	if !quietFlag {
+		manager.CopyProgress = newProgressPrinter(os.Stderr)
+		manager.ProgressThreshold = progressThresholdFlag
+	}
+	manager.UploadPartSize = partSizeFlag
+	manager.Overwrite = overwriteFlag
+	manager.DryRun = dryRun
+
+	copied, err := manager.CopyBoxes(sourceManager, queryParams, move)
+	if err != nil {
+		return
+	}
+	verb := "COPIED"
+	switch {
+	case move && dryRun:
+		verb = "WOULD MOVE"
+	case dryRun:
+		verb = "WOULD COPY"
+	case move:
+		verb = "MOVED"
+	}
+	for _, ref := range copied {
+		result += fmt.Sprintf("%v %v %v\n", verb, ref.Version, ref.ProviderName)
+	}
+	return
+}
+
+// dedupAction removes duplicate provider entries from the catalog
+func dedupAction(catalogUri string, dryRun bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+	manager.DryRun = dryRun
+
+	removed, err := manager.Deduplicate()
+	if err != nil {
+		return
+	}
+	if dryRun {
+		result = fmt.Sprintf("Would remove %v duplicate provider entries\n", removed)
+	} else {
+		result = fmt.Sprintf("Removed %v duplicate provider entries\n", removed)
+	}
+	return
+}
+
+// renameAction changes the name of the box in the catalog and moves its box files to match
+func renameAction(catalogUri string, newName string, dryRun bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+	manager.DryRun = dryRun
+
+	moves, err := manager.RenameCatalog(newName)
+	for _, move := range moves {
+		if dryRun {
+			result += fmt.Sprintf("WOULD MOVE %v %v: %v -> %v\n", move.Version, move.ProviderName, move.OldUri, move.NewUri)
+		} else {
+			result += fmt.Sprintf("MOVED %v %v: %v -> %v\n", move.Version, move.ProviderName, move.OldUri, move.NewUri)
+		}
+	}
+	return
+}
+
+// gcAction finds box files in the backend that the catalog does not refer to
+// They are only deleted if force is set and dryRun is not
+// With removeLatestAlias set, the aliases kept by -latest-alias are deleted too
+func gcAction(catalogUri string, force bool, dryRun bool, removeLatestAlias bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+	manager.DryRun = dryRun || !force
+
+	orphans, err := manager.CollectGarbage()
+	if err != nil {
+		return
+	}
+	if removeLatestAlias {
+		var aliases []string
+		if aliases, err = manager.RemoveLatestAliases(); err != nil {
+			return
+		}
+		orphans = append(orphans, aliases...)
+	}
+	for _, uri := range orphans {
+		if manager.DryRun {
+			result += fmt.Sprintf("WOULD DELETE %v\n", uri)
+		} else {
+			result += fmt.Sprintf("DELETED %v\n", uri)
+		}
+	}
+	if !force && !dryRun && len(orphans) > 0 {
+		result += "Pass -force to delete these files\n"
+	}
+	return
+}
+
+// rollbackAction restores the catalog from the backup with the timestamp to, or from the most recent backup if to is empty
+func rollbackAction(catalogUri string, to string, dryRun bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+	manager.DryRun = dryRun
+
+	rollback, err := manager.RollbackCatalog(to)
+	if err != nil {
+		return
+	}
+
+	if dryRun {
+		result += fmt.Sprintf("WOULD RESTORE %v\n", rollback.BackupUri)
+	} else {
+		result += fmt.Sprintf("RESTORED %v\n", rollback.BackupUri)
+	}
+	for _, ref := range rollback.Missing {
+		result += fmt.Sprintf("MISSING %v %v %v\n", ref.Version, ref.ProviderName, ref.Uri)
+	}
+	if len(rollback.Missing) > 0 {
+		result += fmt.Sprintf("The catalog refers to %v box files that were deleted; rolling back does not restore box files\n", len(rollback.Missing))
+	}
+	return
+}
+
+// checkAction validates the structure of the catalog, and with deep set, that its box files exist
+// The result lists each problem; err is set if there were any, so that the caller can exit nonzero
+func checkAction(catalogUri string, deep bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+
+	problems, err := manager.CheckCatalog(deep)
+	if err != nil {
+		return
+	}
+	for _, problem := range problems {
+		result += fmt.Sprintf("PROBLEM %v\n", problem)
+	}
+	if len(problems) > 0 {
+		err = fmt.Errorf("%w: found %v problems with the catalog", errVerificationFailed, len(problems))
+	} else {
+		result = "No problems found\n"
+	}
+	return
+}
+
+// validateAction checks that a catalog is one Vagrant can parse, and lists each violation with its JSON path
+func validateAction(catalogUri string, schema bool) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+
+	format := "Vagrant's catalog format"
+	var violations []caryatid.SchemaViolation
+	if schema {
+		format = "the catalog schema"
+		violations, err = manager.ValidateCatalogSchema()
+	} else {
+		violations, err = manager.ValidateCatalog()
+	}
+	if err != nil {
+		return
+	}
+	for _, violation := range violations {
+		result += fmt.Sprintf("VIOLATION %v\n", violation)
+	}
+	if len(violations) > 0 {
+		err = fmt.Errorf("%w: found %v violations of %v", errVerificationFailed, len(violations), format)
+	} else if schema {
+		result = "The catalog matches the catalog schema\n"
+	} else {
+		result = "The catalog is valid for Vagrant\n"
+	}
+	return
+}
+
+// diffAction compares two catalogs, and lists each difference between them
+// If ignoreUrls is set, providers whose URLs differ are not reported, as long as their checksums match
+func diffAction(catalogUri string, otherUri string, ignoreUrls bool) (result string, err error) {
+	catalog, err := queryAction(catalogUri, caryatid.CatalogQueryParams{})
+	if err != nil {
+		return
+	}
+	other, err := queryAction(otherUri, caryatid.CatalogQueryParams{})
+	if err != nil {
+		return
+	}
+
+	differences := catalog.Diff(&other, caryatid.CatalogFuzzyEqualsParams{SkipProviderUrl: ignoreUrls})
+	for _, difference := range differences {
+		result += fmt.Sprintf("DIFFERENCE %v\n", difference)
+	}
+	if len(differences) > 0 {
+		err = fmt.Errorf("Found %v differences between the catalogs", len(differences))
+	} else {
+		result = "No differences found\n"
+	}
+	return
+}
+
+// statsAction summarizes the boxes matched by the query, as text or as JSON
+func statsAction(catalogUri string, queryParams caryatid.CatalogQueryParams, output string) (result string, err error) {
+	catalog, err := queryAction(catalogUri, queryParams)
+	if err != nil {
+		return
+	}
+	stats := catalog.Stats()
+	if output != "json" {
+		result = stats.DisplayString()
+		return
+	}
+	jsonData, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return
+	}
+	result = string(jsonData) + "\n"
+	return
+}
+
+// listCatalogsAction lists the catalogs under the directory at rootUri, with the name, number of versions, and URI of each
+func listCatalogsAction(rootUri string) (result string, err error) {
+	manager, err := getManager(rootUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+
+	catalogs, err := manager.DiscoverCatalogs()
+	if err != nil {
+		return
+	}
+	for _, found := range catalogs {
+		result += fmt.Sprintf("%v: %v versions (%v)\n", found.Catalog.Name, len(found.Catalog.Versions), found.Uri)
+	}
+	if len(catalogs) == 0 {
+		result = fmt.Sprintf("No catalogs found under '%v'\n", manager.CatalogUri)
+	}
+	return
+}
+
+// indexAction writes the index of the catalogs under the directory at rootUri, and returns a summary of it
+func indexAction(rootUri string) (result string, err error) {
+	manager, err := getManager(rootUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+
+	index, err := manager.WriteCatalogIndex()
+	if err != nil {
+		return
+	}
+	result = fmt.Sprintf("Indexed %v catalogs in '%v'\n", len(index.Boxes), caryatid.CatalogIndexUri(manager.CatalogUri))
+	return
+}
+
+// exportAction writes the boxes matched by the query in an export format like "csv"
+// If templatePath is set, the boxes are rendered with the html/template in that file instead; see caryatid.ExportCatalogHtml()
+// If urlTtl is set, box URLs are replaced with signed URLs that expire after urlTtl, on backends that support them
+func exportAction(catalogUri string, queryParams caryatid.CatalogQueryParams, format string, templatePath string, urlTtl time.Duration) (result string, err error) {
+	var buffer bytes.Buffer
+
+	catalog, err := queryAction(catalogUri, queryParams)
+	if err != nil {
+		return
+	}
+	if urlTtl > 0 {
+		manager, err := getManager(catalogUri)
+		if err != nil {
+			caryatid.LogErrorf("Error getting a BackendManager")
+			return "", err
+		}
+		if err = manager.SignCatalogUrls(&catalog, urlTtl); err != nil {
+			return "", err
+		}
+	}
+	if templatePath != "" {
+		var htmlTemplate []byte
+		if htmlTemplate, err = ioutil.ReadFile(templatePath); err != nil {
+			return
+		}
+		err = caryatid.ExportCatalogHtml(&catalog, string(htmlTemplate), &buffer)
+	} else {
+		err = caryatid.ExportCatalog(&catalog, format, &buffer)
+	}
+	if err != nil {
+		return
+	}
+	result = buffer.String()
+	return
+}
+
+// vagrantfileAction prints a Vagrantfile snippet, and a 'vagrant box add' command, that use the highest version matched by the query
+// The snippet refers to the catalog by its URI, which Vagrant can only download for some backends, like local files;
+// if -url-prefix is set, it refers to the catalog under that prefix instead, next to the box URLs that 'add -url-prefix' records
+func vagrantfileAction(catalogUri string, queryParams caryatid.CatalogQueryParams) (result string, err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		caryatid.LogErrorf("Error getting catalog: %v\n", err)
+		return
+	}
+	matched, err := catalog.QueryCatalog(queryParams)
+	if err != nil {
+		caryatid.LogErrorf("Error querying catalog: %v\n", err)
+		return
+	}
+	catalogUrl := manager.CatalogUri
+	if manager.UrlPrefix != "" {
+		catalogUrl = fmt.Sprintf("%v/%v", strings.TrimSuffix(manager.UrlPrefix, "/"), path.Base(manager.CatalogUri))
+	}
+	return caryatid.VagrantfileSnippet(&matched, catalogUrl)
+}
+
+// serveAction serves the catalog and its box files over HTTP on listener until a value is received from stop
+// It shuts down cleanly, waiting a little while for downloads in progress to finish
+// If urlTtl is set and the backend supports it, the served catalog refers to signed URLs that expire after urlTtl
+// If authUser is set, clients must use HTTP basic authentication with authUser and authPass
+func serveAction(catalogUri string, listener net.Listener, stop <-chan os.Signal, urlTtl time.Duration, authUser string, authPass string) (err error) {
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		caryatid.LogErrorf("Error getting a BackendManager")
+		return
+	}
+
+	catalogServer := caryatid.NewCatalogServer(manager)
+	catalogServer.UrlTtl = urlTtl
+	catalogServer.AuthUser = authUser
+	catalogServer.AuthPass = authPass
+	httpServer := &http.Server{Handler: catalogServer}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.Serve(listener)
+	}()
+	caryatid.LogInfof("Serving catalog at http://%v%v\n", listener.Addr(), catalogServer.CatalogPath())
+
+	select {
+	case err = <-serveErr:
+		return
+	case <-stop:
+		caryatid.LogInfof("Shutting down\n")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err = httpServer.Shutdown(ctx); err != nil {
+		return
+	}
+	if err = <-serveErr; err == http.ErrServerClosed {
+		err = nil
+	}
 	return
 }
@@ -1,15 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"path"
+	"reflect"
 	"runtime"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mrled/caryatid/internal/util"
 	"github.com/mrled/caryatid/pkg/caryatid"
@@ -64,23 +76,26 @@ func TestShowAction(t *testing.T) {
 	)
 
 	catalog := caryatid.Catalog{
-		boxName,
-		boxDesc,
-		[]caryatid.Version{
+		Name:        boxName,
+		Description: boxDesc,
+		Versions: []caryatid.Version{
 			caryatid.Version{
-				"1.5.3",
-				[]caryatid.Provider{
+				Version: "1.5.3",
+				Providers: []caryatid.Provider{
 					caryatid.Provider{
-						"test-provider",
-						"test:///asdf/asdfqwer/something.box",
-						"FakeChecksum",
-						"0xDECAFBAD",
+						Name:         "test-provider",
+						Url:          "test:///asdf/asdfqwer/something.box",
+						ChecksumType: "FakeChecksum",
+						Checksum:     "0xDECAFBAD",
+						Size:         1572864,
 					},
 				},
 			},
 		},
 	}
-	expectedCatalogString := `{TestShowActionBox TestShowActionBox Description [{1.5.3 [{test-provider test:///asdf/asdfqwer/something.box FakeChecksum 0xDECAFBAD}]}]}
+	expectedCatalogString := `TestShowActionBox (TestShowActionBox Description)
+  v1.5.3
+    test-provider FakeChecksum:0xDECAFBAD <test:///asdf/asdfqwer/something.box> (1.5 MiB)
 `
 
 	jsonCatalog, err := json.MarshalIndent(catalog, "", "  ")
@@ -93,7 +108,7 @@ func TestShowAction(t *testing.T) {
 		t.Fatalf("Error trying to write catalog: %v\n", err)
 	}
 
-	result, err = showAction(catalogUri)
+	result, err = showAction(catalogUri, "text")
 	if err != nil {
 		t.Fatalf("showAction() error: %v\n", err)
 	}
@@ -143,7 +158,7 @@ func TestAddAction(t *testing.T) {
 	}
 
 	// Test adding to an empty catalog
-	err = addAction(boxPath, boxName, boxDesc, boxVersion, catalogUri)
+	err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: boxVersion, ChecksumType: "sha256"})
 	if err != nil {
 		t.Fatalf("addAction() failed with error: %v\n", err)
 	}
@@ -162,15 +177,23 @@ func TestAddAction(t *testing.T) {
 		ExpectedMatch{"catalog description", catalog.Description, boxDesc},
 		ExpectedMatch{"box provider", catalog.Versions[0].Providers[0].Name, boxProvider},
 		ExpectedMatch{"box version", catalog.Versions[0].Version, boxVersion},
+		ExpectedMatch{"box checksum type", catalog.Versions[0].Providers[0].ChecksumType, "sha256"},
 	}
 	for _, match := range expectedMatches {
 		if match.In != match.Out {
 			t.Fatalf("Expected %v to match, but the expected value was %v while the actual value was %v", match.Name, match.In, match.Out)
 		}
 	}
+	boxInfo, err := os.Stat(boxPath)
+	if err != nil {
+		t.Fatalf("Could not stat test box file: %v\n", err)
+	}
+	if size := catalog.Versions[0].Providers[0].Size; size != boxInfo.Size() {
+		t.Fatalf("Expected the catalog to record a box size of %v bytes, but it recorded %v\n", boxInfo.Size(), size)
+	}
 
 	// Test adding another box to the same, now non-empty, catalog
-	err = addAction(boxPath, boxName, boxDesc, boxVersion2, catalogUri)
+	err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: boxVersion2, ChecksumType: "sha256"})
 	if err != nil {
 		t.Fatalf("addAction() failed with error: %v\n", err)
 	}
@@ -197,6 +220,510 @@ func TestAddAction(t *testing.T) {
 	}
 }
 
+func TestAddActionCompress(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestAddActionCompress.box")
+		boxProvider = "TestAddActionCompressProvider"
+		boxName     = "TestAddActionCompressBox"
+		boxDesc     = "TestAddActionCompressBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+		storedPath  = path.Join(integrationTestDir, boxName, fmt.Sprintf("%v_%v_%v.box", boxName, "1.0.0", boxProvider))
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, false); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha256", Compress: true}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+
+	if compressed, err := caryatid.IsCompressedBoxFile(storedPath); err != nil || !compressed {
+		t.Fatalf("Expected a compressed box at '%v', but got compressed %v and error %v\n", storedPath, compressed, err)
+	}
+	if compressed, err := caryatid.IsCompressedBoxFile(boxPath); err != nil || compressed {
+		t.Fatalf("Expected the original box at '%v' to be left uncompressed, but got compressed %v and error %v\n", boxPath, compressed, err)
+	}
+	if result, err = verifyAction(catalogUri, caryatid.CatalogQueryParams{}, 2); err != nil {
+		t.Fatalf("verifyAction() failed on a compressed box: %v\n%v", err, result)
+	}
+}
+
+func TestAddActionStdin(t *testing.T) {
+	var (
+		err error
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestAddActionStdin.box")
+		boxProvider = "TestAddActionStdinProvider"
+		boxName     = "TestAddActionStdinBox"
+		boxDesc     = "TestAddActionStdinBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+		storedPath  = path.Join(integrationTestDir, boxName, fmt.Sprintf("%v_%v_%v.box", boxName, "1.0.0", boxProvider))
+	)
+	defer func() { stdinReader = os.Stdin }()
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	boxFile, err := os.Open(boxPath)
+	if err != nil {
+		t.Fatalf("Error opening test box file: %v\n", err)
+	}
+	defer boxFile.Close()
+
+	stdinReader = boxFile
+	if err = addAction([]string{"-"}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+	origDigest, _ := util.Sha1sum(boxPath)
+	storedDigest, err := util.Sha1sum(storedPath)
+	if err != nil || storedDigest != origDigest {
+		t.Fatalf("Expected the box read from stdin to be stored at '%v', but got digest '%v' (error %v)\n", storedPath, storedDigest, err)
+	}
+
+	for _, invalidStdin := range []string{"", "this is not a box file"} {
+		stdinReader = strings.NewReader(invalidStdin)
+		if err = addAction([]string{"-"}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.1", ChecksumType: "sha256"}); err == nil {
+			t.Fatalf("Expected addAction() to fail when stdin is '%v'\n", invalidStdin)
+		}
+	}
+}
+
+func TestAddActionMultipleBoxes(t *testing.T) {
+	var (
+		err    error
+		result caryatid.Catalog
+
+		boxProviders = []string{"TestAddActionMultipleBoxesVirtualbox", "TestAddActionMultipleBoxesLibvirt"}
+		boxPaths     = []string{}
+		boxName      = "TestAddActionMultipleBoxesBox"
+		boxDesc      = "TestAddActionMultipleBoxesBox is a test box"
+		catalogUri   = fmt.Sprintf("file://%v/%v.json", integrationTestDir, boxName)
+	)
+
+	for _, provider := range boxProviders {
+		boxPath := path.Join(integrationTestDir, fmt.Sprintf("incoming-%v.box", provider))
+		if err = caryatid.CreateTestBoxFile(boxPath, provider, true); err != nil {
+			t.Fatalf("Error trying to create test box file: %v\n", err)
+		}
+		boxPaths = append(boxPaths, boxPath)
+	}
+	if err = addAction(boxPaths, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.2.3", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+
+	if result, err = queryAction(catalogUri, caryatid.CatalogQueryParams{}); err != nil {
+		t.Fatalf("queryAction() failed with error: %v\n", err)
+	}
+	if len(result.Versions) != 1 || result.Versions[0].Version != "1.2.3" || len(result.Versions[0].Providers) != 2 {
+		t.Fatalf("Expected both providers under version 1.2.3, but got:\n%v", result.DisplayString())
+	}
+	for idx, provider := range result.Versions[0].Providers {
+		if provider.Name != boxProviders[idx] {
+			t.Fatalf("Expected provider %v to be '%v', but got '%v'\n", idx, boxProviders[idx], provider.Name)
+		}
+	}
+
+	// Running the same add again does nothing
+	if err = addAction(boxPaths[1:], catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.2.3", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("Expected adding the same box again to do nothing, but got: %v\n", err)
+	}
+}
+
+func TestAddActionForce(t *testing.T) {
+	var (
+		err    error
+		result caryatid.Catalog
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestAddActionForce.box")
+		boxProvider = "TestAddActionForceProvider"
+		boxName     = "TestAddActionForceBox"
+		boxDesc     = "TestAddActionForceBox is a test box"
+		catalogUri  = fmt.Sprintf("file://%v/%v.json", integrationTestDir, boxName)
+		storedPath  = path.Join(integrationTestDir, boxName, fmt.Sprintf("%v_1.0.0_%v.box", boxName, boxProvider))
+	)
+	defer func(oldForce bool) { forceFlag = oldForce }(forceFlag)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+
+	// Rebuild the box, so that it has a different checksum
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, false); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	expectedDigest, err := util.HashFile(boxPath, sha256.New())
+	if err != nil {
+		t.Fatalf("Error hashing test box file: %v\n", err)
+	}
+	forceFlag = false
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha256"}); !errors.Is(err, caryatid.ErrBoxExists) {
+		t.Fatalf("Expected replacing a box without -force to fail with ErrBoxExists, but got: %v\n", err)
+	}
+	forceFlag = true
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() with -force failed with error: %v\n", err)
+	}
+
+	if result, err = queryAction(catalogUri, caryatid.CatalogQueryParams{}); err != nil {
+		t.Fatalf("queryAction() failed with error: %v\n", err)
+	}
+	if len(result.Versions) != 1 || len(result.Versions[0].Providers) != 1 {
+		t.Fatalf("Expected -force to replace the box rather than add another, but got:\n%v", result.DisplayString())
+	}
+	provider := result.Versions[0].Providers[0]
+	storedInfo, err := os.Stat(storedPath)
+	if err != nil {
+		t.Fatalf("Error trying to stat the stored box file: %v\n", err)
+	}
+	if provider.Checksum != expectedDigest || provider.Size != storedInfo.Size() {
+		t.Fatalf("Expected -force to record checksum '%v' and size %v, but got '%v' and %v\n", expectedDigest, storedInfo.Size(), provider.Checksum, provider.Size)
+	}
+	if storedDigest, _ := util.HashFile(storedPath, sha256.New()); storedDigest != expectedDigest {
+		t.Fatalf("Expected -force to replace the stored box file, but its checksum is '%v'\n", storedDigest)
+	}
+}
+
+func TestAddActionExpectedChecksum(t *testing.T) {
+	var (
+		err error
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestAddActionExpectedChecksum.box")
+		boxProvider = "TestAddActionExpectedChecksumProvider"
+		boxName     = "TestAddActionExpectedChecksumBox"
+		boxDesc     = "TestAddActionExpectedChecksumBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	expectedDigest, err := util.HashFile(boxPath, sha256.New())
+	if err != nil {
+		t.Fatalf("Error hashing test box file: %v\n", err)
+	}
+
+	wrongChecksum := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha256", ExpectedChecksum: wrongChecksum})
+	if !errors.Is(err, caryatid.ErrChecksumMismatch) {
+		t.Fatalf("Expected adding a box with the wrong checksum to fail with ErrChecksumMismatch, but got: %v\n", err)
+	}
+	if !strings.Contains(err.Error(), wrongChecksum) || !strings.Contains(err.Error(), expectedDigest) {
+		t.Fatalf("Expected the error to show both the expected and actual checksums, but got: %v\n", err)
+	}
+	if _, err = os.Stat(catalogPath); !os.IsNotExist(err) {
+		t.Fatalf("Expected a failed add not to create the catalog, but got: %v\n", err)
+	}
+
+	// The expected checksum may have a different type than the checksum recorded in the catalog
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha1", ExpectedChecksum: strings.ToUpper(expectedDigest), ExpectedChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() with the right expected checksum failed with error: %v\n", err)
+	}
+	result, err := queryAction(catalogUri, caryatid.CatalogQueryParams{})
+	if err != nil {
+		t.Fatalf("queryAction() failed with error: %v\n", err)
+	}
+	if len(result.Versions) != 1 || result.Versions[0].Providers[0].ChecksumType != "sha1" {
+		t.Fatalf("Expected the box to be added with a sha1 checksum, but got:\n%v", result.DisplayString())
+	}
+}
+
+func TestAddActionVersionDescription(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestAddActionVersionDescription.box")
+		boxProvider = "TestAddActionVersionDescriptionProvider"
+		boxName     = "TestAddActionVersionDescriptionBox"
+		boxDesc     = "TestAddActionVersionDescriptionBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.1", VersionDescription: "A hotfix release", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() with -version-description failed with error: %v\n", err)
+	}
+
+	if result, err = showAction(catalogUri, ""); err != nil {
+		t.Fatalf("showAction() failed with error: %v\n", err)
+	}
+	if !strings.Contains(result, "  v1.0.1 (A hotfix release)\n") {
+		t.Fatalf("Expected the version description next to v1.0.1, but got:\n%v", result)
+	}
+	if !strings.Contains(result, "  v1.0.0\n") {
+		t.Fatalf("Expected no version description next to v1.0.0, but got:\n%v", result)
+	}
+
+	catalogBytes, err := ioutil.ReadFile(catalogPath)
+	if err != nil {
+		t.Fatalf("Could not read catalog at '%v': %v\n", catalogPath, err)
+	}
+	if strings.Count(string(catalogBytes), `"description"`) != 2 {
+		t.Fatalf("Expected only the catalog and v1.0.1 to have a description, but got:\n%v\n", string(catalogBytes))
+	}
+}
+
+func TestAddActionMultipleChecksums(t *testing.T) {
+	var (
+		err error
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestAddActionMultipleChecksums.box")
+		boxProvider = "TestAddActionMultipleChecksumsProvider"
+		boxName     = "TestAddActionMultipleChecksumsBox"
+		boxDesc     = "TestAddActionMultipleChecksumsBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	boxBytes, err := ioutil.ReadFile(boxPath)
+	if err != nil {
+		t.Fatalf("Error reading test box file: %v\n", err)
+	}
+	sha256Digest := sha256.Sum256(boxBytes)
+	md5Digest := md5.Sum(boxBytes)
+	expectedChecksums := []caryatid.Checksum{
+		caryatid.Checksum{Type: "sha256", Value: hex.EncodeToString(sha256Digest[:])},
+		caryatid.Checksum{Type: "md5", Value: hex.EncodeToString(md5Digest[:])},
+	}
+
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha256,md5"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.1", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+
+	catalogBytes, err := ioutil.ReadFile(catalogPath)
+	if err != nil {
+		t.Fatalf("Could not read catalog at '%v': %v\n", catalogPath, err)
+	}
+	var catalog caryatid.Catalog
+	if err = json.Unmarshal(catalogBytes, &catalog); err != nil {
+		t.Fatalf("Could not parse catalog: %v\n", err)
+	}
+	if len(catalog.Versions) != 2 || len(catalog.Versions[0].Providers) != 1 || len(catalog.Versions[1].Providers) != 1 {
+		t.Fatalf("Expected two versions with one provider each, but got:\n%v\n", catalog.DisplayString())
+	}
+
+	provider := catalog.Versions[0].Providers[0]
+	if provider.ChecksumType != "sha256" || provider.Checksum != expectedChecksums[0].Value {
+		t.Fatalf("Expected the sha256 checksum to be the one Vagrant reads, but got %v:%v\n", provider.ChecksumType, provider.Checksum)
+	}
+	if !reflect.DeepEqual(provider.Checksums, expectedChecksums) {
+		t.Fatalf("Expected checksums %v, but got %v\n", expectedChecksums, provider.Checksums)
+	}
+	if single := catalog.Versions[1].Providers[0]; single.Checksums != nil || strings.Count(string(catalogBytes), `"checksums"`) != 1 {
+		t.Fatalf("Expected no list of checksums for a box added with a single checksum type, but got:\n%v\n", string(catalogBytes))
+	}
+}
+
+func TestAddActionJSONLogging(t *testing.T) {
+	var (
+		err    error
+		logBuf bytes.Buffer
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestAddActionJSONLogging.box")
+		boxProvider = "TestAddActionJSONLoggingProvider"
+		boxName     = "TestAddActionJSONLoggingBox"
+		catalogUri  = fmt.Sprintf("file://%v", path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName)))
+	)
+	caryatid.SetLogger(caryatid.NewJSONLogger(&logBuf))
+	defer caryatid.SetLogger(nil)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: "desc", Version: "1.0.0", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(logBuf.String()), "\n")
+	found := false
+	for _, line := range lines {
+		var event map[string]string
+		if err = json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Expected every log message to be JSON, but got: %v\n", line)
+		}
+		if event["catalog"] == catalogUri && event["box"] == boxName && event["version"] == "1.0.0" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Expected log messages with the catalog, box, and version, but got:\n%v", logBuf.String())
+	}
+}
+
+func TestReadOnlyFlag(t *testing.T) {
+	var (
+		err    error
+		result caryatid.Catalog
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestReadOnlyFlag.box")
+		boxProvider = "TestReadOnlyFlagProvider"
+		boxName     = "TestReadOnlyFlagBox"
+		boxDesc     = "TestReadOnlyFlagBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+	defer func(oldReadOnly bool) { readOnlyFlag = oldReadOnly }(readOnlyFlag)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	readOnlyFlag = false
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+	catalogBefore, err := ioutil.ReadFile(catalogPath)
+	if err != nil {
+		t.Fatalf("Error reading catalog: %v\n", err)
+	}
+
+	readOnlyFlag = true
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "2.0.0", ChecksumType: "sha256"}); !errors.Is(err, caryatid.ErrBackendReadOnly) {
+		t.Fatalf("Expected addAction() with -read-only to fail with ErrBackendReadOnly, but got: %v\n", err)
+	}
+	if _, err = deleteAction(catalogUri, caryatid.CatalogQueryParams{Version: "1.0.0"}, false); !errors.Is(err, caryatid.ErrBackendReadOnly) {
+		t.Fatalf("Expected deleteAction() with -read-only to fail with ErrBackendReadOnly, but got: %v\n", err)
+	}
+	if result, err = queryAction(catalogUri, caryatid.CatalogQueryParams{}); err != nil {
+		t.Fatalf("queryAction() with -read-only failed with error: %v\n", err)
+	}
+	if len(result.Versions) != 1 || result.Versions[0].Version != "1.0.0" {
+		t.Fatalf("Expected only version 1.0.0 in the catalog, but got:\n%v", result.DisplayString())
+	}
+
+	catalogAfter, err := ioutil.ReadFile(catalogPath)
+	if err != nil {
+		t.Fatalf("Error reading catalog: %v\n", err)
+	}
+	if string(catalogBefore) != string(catalogAfter) {
+		t.Fatalf("Expected -read-only to leave the catalog unchanged\n")
+	}
+	storedPath := path.Join(integrationTestDir, boxName, fmt.Sprintf("%v_1.0.0_%v.box", boxName, boxProvider))
+	if _, err = os.Stat(storedPath); err != nil {
+		t.Fatalf("Expected -read-only to leave the box file in place: %v\n", err)
+	}
+}
+
+func TestVagrantfileAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestVagrantfileAction.box")
+		boxProvider = "TestVagrantfileActionProvider"
+		boxName     = "TestVagrantfileActionBox"
+		boxDesc     = "TestVagrantfileActionBox is a test box"
+		catalogUri  = fmt.Sprintf("file://%v/%v.json", integrationTestDir, boxName)
+	)
+	defer func(oldPrefix string) { urlPrefixFlag = oldPrefix }(urlPrefixFlag)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+			t.Fatalf("addAction() failed with error: %v\n", err)
+		}
+	}
+
+	urlPrefixFlag = ""
+	if result, err = vagrantfileAction(catalogUri, caryatid.CatalogQueryParams{Version: "<1.1.0"}); err != nil {
+		t.Fatalf("vagrantfileAction() failed with error: %v\n", err)
+	}
+	for _, expected := range []string{
+		fmt.Sprintf("config.vm.box = %q", boxName),
+		fmt.Sprintf("config.vm.box_url = %q", catalogUri),
+		`config.vm.box_version = "1.0.0"`,
+		fmt.Sprintf("vagrant box add --box-version 1.0.0 --provider %v %v", boxProvider, catalogUri),
+	} {
+		if !strings.Contains(result, expected) {
+			t.Fatalf("Expected vagrantfileAction() result to contain '%v', but got:\n%v", expected, result)
+		}
+	}
+
+	urlPrefixFlag = "https://boxes.example.com/"
+	if result, err = vagrantfileAction(catalogUri, caryatid.CatalogQueryParams{}); err != nil {
+		t.Fatalf("vagrantfileAction() failed with error: %v\n", err)
+	}
+	expectedUrl := fmt.Sprintf("https://boxes.example.com/%v.json", boxName)
+	if !strings.Contains(result, fmt.Sprintf("config.vm.box_url = %q", expectedUrl)) || !strings.Contains(result, `config.vm.box_version = "1.1.0"`) {
+		t.Fatalf("Expected vagrantfileAction() with -url-prefix to use '%v' and the latest version, but got:\n%v", expectedUrl, result)
+	}
+
+	if _, err = vagrantfileAction(catalogUri, caryatid.CatalogQueryParams{Version: "9.9.9"}); err == nil {
+		t.Fatalf("Expected vagrantfileAction() to fail when no version matches\n")
+	}
+}
+
+func TestAddActionLabels(t *testing.T) {
+	var (
+		err    error
+		result caryatid.Catalog
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestAddActionLabels.box")
+		boxProvider = "TestAddActionLabelsProvider"
+		boxName     = "TestAddActionLabelsBox"
+		boxDesc     = "TestAddActionLabelsBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	labels := labelFlagValue{}
+	for _, label := range []string{"build=1234", "ci=https://ci.example.com/runs/1234?a=b"} {
+		if err = labels.Set(label); err != nil {
+			t.Fatalf("Error setting label '%v': %v\n", label, err)
+		}
+	}
+	if err = labels.Set("no-equals-sign"); err == nil {
+		t.Fatalf("Expected an error setting a label without a value\n")
+	}
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", Labels: labels, ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "2.0.0", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+
+	if result, err = queryAction(catalogUri, caryatid.CatalogQueryParams{LabelSelector: "build=1234"}); err != nil {
+		t.Fatalf("queryAction() failed with error: %v\n", err)
+	}
+	if len(result.Versions) != 1 || result.Versions[0].Version != "1.0.0" {
+		t.Fatalf("Expected only version 1.0.0 to match the label selector, but got:\n%v", result.DisplayString())
+	}
+	if ci := result.Versions[0].Providers[0].Labels["ci"]; ci != "https://ci.example.com/runs/1234?a=b" {
+		t.Fatalf("Expected the ci label to be recorded, but got '%v'\n", ci)
+	}
+
+	catalogBytes, err := ioutil.ReadFile(catalogPath)
+	if err != nil {
+		t.Fatalf("Error reading catalog: %v\n", err)
+	}
+	if strings.Count(string(catalogBytes), `"labels"`) != 1 {
+		t.Fatalf("Expected only the labeled box to have labels in the catalog, but got:\n%v\n", string(catalogBytes))
+	}
+}
+
 func TestQueryAction(t *testing.T) {
 	var (
 		err         error
@@ -234,13 +761,13 @@ func TestQueryAction(t *testing.T) {
 	// Now copy those boxes multiple times to the Catalog,
 	// as if they were different versions each time
 	for _, version := range boxVersions1 {
-		if err = manager.AddBox(boxPath1, boxName, boxDesc, version, boxProvider1, digestType, digest); err != nil {
+		if err = manager.AddBox(caryatid.BoxArtifact{Path: boxPath1, Name: boxName, Description: boxDesc, Version: version, Provider: boxProvider1, ChecksumType: digestType, Checksum: digest}); err != nil {
 			t.Fatalf("Error adding box metadata to catalog: %v\n", err)
 			return
 		}
 	}
 	for _, version := range boxVersions2 {
-		if err = manager.AddBox(boxPath2, boxName, boxDesc, version, boxProvider2, digestType, digest); err != nil {
+		if err = manager.AddBox(caryatid.BoxArtifact{Path: boxPath2, Name: boxName, Description: boxDesc, Version: version, Provider: boxProvider2, ChecksumType: digestType, Checksum: digest}); err != nil {
 			t.Fatalf("Error adding box metadata to catalog: %v\n", err)
 			return
 		}
@@ -255,113 +782,369 @@ func TestQueryAction(t *testing.T) {
 	testCases := []TestCase{
 		TestCase{ // Expect all items in catalog
 			"", "",
-			caryatid.Catalog{boxName, boxDesc, []caryatid.Version{
-				caryatid.Version{"0.3.5", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "0.3.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"0.3.5-BETA", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
-					caryatid.Provider{boxProvider2, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "0.3.5-BETA", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"1.0.0", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "1.0.0", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"1.0.0-PRE", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "1.0.0-PRE", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"1.4.5", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "1.4.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"1.2.3", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
-					caryatid.Provider{boxProvider2, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "1.2.3", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"1.2.4", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "1.2.4", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"0.3.4", []caryatid.Provider{
-					caryatid.Provider{boxProvider2, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "0.3.4", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"1.0.1", []caryatid.Provider{
-					caryatid.Provider{boxProvider2, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "1.0.1", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"2.0.0", []caryatid.Provider{
-					caryatid.Provider{boxProvider2, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "2.0.0", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"2.10.0", []caryatid.Provider{
-					caryatid.Provider{boxProvider2, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "2.10.0", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"2.11.1", []caryatid.Provider{
-					caryatid.Provider{boxProvider2, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "2.11.1", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
 			}},
 		},
 		TestCase{
 			"", "rongSap",
-			caryatid.Catalog{boxName, boxDesc, []caryatid.Version{
-				caryatid.Version{"0.3.5", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
-				}},
-				caryatid.Version{"0.3.5-BETA", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "0.3.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"1.0.0", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "0.3.5-BETA", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"1.0.0-PRE", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "1.0.0", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.0.0-PRE", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"1.4.5", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "1.4.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"1.2.3", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "1.2.3", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"1.2.4", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "1.2.4", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
 			}},
 		},
 		TestCase{
 			"<1", "",
-			caryatid.Catalog{boxName, boxDesc, []caryatid.Version{
-				caryatid.Version{"0.3.5", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "0.3.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"0.3.5-BETA", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
-					caryatid.Provider{boxProvider2, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "0.3.5-BETA", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"0.3.4", []caryatid.Provider{
-					caryatid.Provider{boxProvider2, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "0.3.4", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
 			}},
 		},
 		TestCase{
 			"<1", ".*rongSap.*",
-			caryatid.Catalog{boxName, boxDesc, []caryatid.Version{
-				caryatid.Version{"0.3.5", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "0.3.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "0.3.5-BETA", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+			}},
+		},
+		TestCase{
+			"~> 1.2", "",
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "1.4.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.2.3", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.2.4", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+			}},
+		},
+		TestCase{
+			"~> 1.2.3", "",
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "1.2.3", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.2.4", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+			}},
+		},
+		TestCase{
+			"~> 2.10", "",
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "2.10.0", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "2.11.1", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+			}},
+		},
+		TestCase{
+			">=1.0.0, <2.0.0", "",
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "1.0.0", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.0.0-PRE", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.4.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.2.3", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.2.4", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.0.1", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+			}},
+		},
+		TestCase{
+			">=1.0.0", ".*rongSap.*",
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "1.0.0", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.0.0-PRE", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.4.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.2.3", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.2.4", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+			}},
+		},
+		TestCase{
+			"latest", "",
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "2.11.1", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+			}},
+		},
+		TestCase{
+			"newest", ".*rongSap.*",
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "1.4.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+			}},
+		},
+		TestCase{
+			"~> 1.0", ".*rongSap.*",
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "1.0.0", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.0.0-PRE", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.4.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.2.3", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.2.4", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+			}},
+		},
+	}
+
+	// The same kinds of queries, but with prerelease versions excluded
+	excludePrereleaseTestCases := []TestCase{
+		TestCase{
+			">=1.0.0", "",
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "1.0.0", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.4.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.2.3", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.2.4", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "1.0.1", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "2.0.0", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "2.10.0", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+				caryatid.Version{Version: "2.11.1", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
+				}},
+			}},
+		},
+		TestCase{
+			"<1", "",
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{
+				caryatid.Version{Version: "0.3.5", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider1, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
-				caryatid.Version{"0.3.5-BETA", []caryatid.Provider{
-					caryatid.Provider{boxProvider1, "FAKEURI", digestType, digest},
+				caryatid.Version{Version: "0.3.4", Providers: []caryatid.Provider{
+					caryatid.Provider{Name: boxProvider2, Url: "FAKEURI", ChecksumType: digestType, Checksum: digest},
 				}},
 			}},
 		},
+		TestCase{
+			"=1.0.0-PRE", "",
+			caryatid.Catalog{Name: boxName, Description: boxDesc, Versions: []caryatid.Version{}},
+		},
 	}
 
 	fuzzyEqualsParams := caryatid.CatalogFuzzyEqualsParams{SkipProviderUrl: true}
 
-	for _, tc := range testCases {
-		// Join the array into a multi-line string, and add a trailing newline
-		result, err = queryAction(catalogUri, tc.VersionQuery, tc.ProviderQuery)
+	runTestCases := func(testCases []TestCase, prerelease caryatid.PrereleaseMode) {
+		for _, tc := range testCases {
+			queryParams := caryatid.CatalogQueryParams{Version: tc.VersionQuery, Provider: tc.ProviderQuery, Prerelease: prerelease}
+			result, err = queryAction(catalogUri, queryParams)
+			if err != nil {
+				t.Fatalf("queryAction(*, %v) returned an unexpected error: %v\n", queryParams, err)
+			} else if !result.FuzzyEquals(&tc.ExpectedResult, fuzzyEqualsParams) {
+				t.Fatalf(
+					"queryAction(*, %v) returned result:\n%v\nBut we expected:\n%v\n",
+					queryParams, result.DisplayString(), tc.ExpectedResult.DisplayString())
+			}
+		}
+	}
+	runTestCases(testCases, caryatid.PrereleaseDefault)
+	runTestCases(excludePrereleaseTestCases, caryatid.PrereleaseExclude)
+
+	// Name globs match the whole catalog or nothing
+	if result, err = queryAction(catalogUri, caryatid.CatalogQueryParams{Name: "*QueryAction*"}); err != nil {
+		t.Fatalf("queryAction() with a matching name glob returned an unexpected error: %v\n", err)
+	} else if !result.FuzzyEquals(&testCases[0].ExpectedResult, fuzzyEqualsParams) {
+		t.Fatalf("queryAction() with a matching name glob returned result:\n%v\n", result.DisplayString())
+	}
+	if result, err = queryAction(catalogUri, caryatid.CatalogQueryParams{Name: "*asdf*"}); err != nil {
+		t.Fatalf("queryAction() with a non-matching name glob returned an unexpected error: %v\n", err)
+	} else if len(result.Versions) != 0 {
+		t.Fatalf("queryAction() with a non-matching name glob returned result:\n%v\n", result.DisplayString())
+	}
+
+	// Counting a query counts its providers, not its versions
+	type CountTestCase struct {
+		VersionQuery  string
+		ProviderQuery string
+		Expected      string
+	}
+	countTestCases := []CountTestCase{
+		CountTestCase{"", "", "14\n"},
+		CountTestCase{"1.2.3", "", "2\n"},
+		CountTestCase{"1.2.3", boxProvider1, "1\n"},
+		CountTestCase{">=2", "", "3\n"},
+		CountTestCase{"3.0.0", "", "0\n"},
+	}
+	for _, tc := range countTestCases {
+		queryParams := caryatid.CatalogQueryParams{Version: tc.VersionQuery, Provider: tc.ProviderQuery}
+		if result, err = queryAction(catalogUri, queryParams); err != nil {
+			t.Fatalf("queryAction(*, %v) returned an unexpected error: %v\n", queryParams, err)
+		}
+		count, err := countQueryResult(result)
+		if count != tc.Expected {
+			t.Fatalf("countQueryResult() for %v returned '%v', but we expected '%v'\n", queryParams, count, tc.Expected)
+		}
+		if noMatches := tc.Expected == "0\n"; (err == errNoMatches) != noMatches {
+			t.Fatalf("countQueryResult() for %v returned error %v\n", queryParams, err)
+		}
+	}
+
+	// Printing only URLs prints exactly one line for the latest version of a single provider, and nothing for no matches
+	if result, err = queryAction(catalogUri, caryatid.CatalogQueryParams{Version: "latest", Provider: boxProvider2}); err != nil {
+		t.Fatalf("queryAction() for the latest version returned an unexpected error: %v\n", err)
+	}
+	expectedUrl := fmt.Sprintf("file://%v/%v/%v_2.11.1_%v.box\n", integrationTestDir, boxName, boxName, boxProvider2)
+	if urls := urlQueryResult(result); urls != expectedUrl {
+		t.Fatalf("urlQueryResult() for the latest version returned '%v', but we expected '%v'\n", urls, expectedUrl)
+	}
+	if urls := urlQueryResult(caryatid.Catalog{}); urls != "" {
+		t.Fatalf("urlQueryResult() for no matches returned '%v', but we expected nothing\n", urls)
+	}
+
+	// Grouping prints a header for each provider in alphabetical order, with only its matching versions under it
+	if result, err = queryAction(catalogUri, caryatid.CatalogQueryParams{Version: ">=1.2"}); err != nil {
+		t.Fatalf("queryAction() for grouped output returned an unexpected error: %v\n", err)
+	}
+	groupedLine := func(version string, provider string) string {
+		return fmt.Sprintf("  v%v %v:%v <file://%v/%v/%v_%v_%v.box>\n", version, digestType, digest, integrationTestDir, boxName, boxName, version, provider)
+	}
+	type GroupedTestCase struct {
+		Sort     caryatid.SortOrder
+		Expected string
+	}
+	groupedTestCases := []GroupedTestCase{
+		GroupedTestCase{caryatid.SortVersionAsc, boxProvider2 + "\n" +
+			groupedLine("1.2.3", boxProvider2) + groupedLine("2.0.0", boxProvider2) + groupedLine("2.10.0", boxProvider2) + groupedLine("2.11.1", boxProvider2) +
+			boxProvider1 + "\n" +
+			groupedLine("1.2.3", boxProvider1) + groupedLine("1.2.4", boxProvider1) + groupedLine("1.4.5", boxProvider1)},
+		GroupedTestCase{caryatid.SortVersionDesc, boxProvider2 + "\n" +
+			groupedLine("2.11.1", boxProvider2) + groupedLine("2.10.0", boxProvider2) + groupedLine("2.0.0", boxProvider2) + groupedLine("1.2.3", boxProvider2) +
+			boxProvider1 + "\n" +
+			groupedLine("1.4.5", boxProvider1) + groupedLine("1.2.4", boxProvider1) + groupedLine("1.2.3", boxProvider1)},
+	}
+	for _, tc := range groupedTestCases {
+		grouped, err := groupedQueryResult(result, tc.Sort)
 		if err != nil {
-			t.Fatalf("queryAction(*, *, '%v', '%v') returned an unexpected error: %v\n", tc.VersionQuery, tc.ProviderQuery, err)
-		} else if !result.FuzzyEquals(&tc.ExpectedResult, fuzzyEqualsParams) {
-			t.Fatalf(
-				"queryAction(*, *, '%v', '%v') returned result:\n%v\nBut we expected:\n%v\n",
-				tc.VersionQuery, tc.ProviderQuery, result.DisplayString(), tc.ExpectedResult.DisplayString())
+			t.Fatalf("groupedQueryResult() sorted by %v returned an unexpected error: %v\n", tc.Sort, err)
 		}
+		if grouped != tc.Expected {
+			t.Fatalf("groupedQueryResult() sorted by %v returned:\n%v\nbut we expected:\n%v\n", tc.Sort, grouped, tc.Expected)
+		}
+	}
+	if grouped, _ := groupedQueryResult(caryatid.Catalog{}, caryatid.DefaultSortOrder); grouped != "" {
+		t.Fatalf("groupedQueryResult() for no matches returned '%v', but we expected nothing\n", grouped)
 	}
 }
 
@@ -503,24 +1286,24 @@ func TestDeleteAction(t *testing.T) {
 		// Now copy those boxes multiple times to the Catalog,
 		// as if they were different versions each time
 		for _, version := range boxVersions1 {
-			if err = manager.AddBox(boxPath1, boxName, boxDesc, version, boxProvider1, digestType, digest); err != nil {
+			if err = manager.AddBox(caryatid.BoxArtifact{Path: boxPath1, Name: boxName, Description: boxDesc, Version: version, Provider: boxProvider1, ChecksumType: digestType, Checksum: digest}); err != nil {
 				t.Fatalf("Error adding box metadata to catalog: %v\n", err)
 				return
 			}
 		}
 		for _, version := range boxVersions2 {
-			if err = manager.AddBox(boxPath2, boxName, boxDesc, version, boxProvider2, digestType, digest); err != nil {
+			if err = manager.AddBox(caryatid.BoxArtifact{Path: boxPath2, Name: boxName, Description: boxDesc, Version: version, Provider: boxProvider2, ChecksumType: digestType, Checksum: digest}); err != nil {
 				t.Fatalf("Error adding box metadata to catalog: %v\n", err)
 				return
 			}
 		}
 
-		if err = deleteAction(catalogUri, tc.VersionQuery, tc.ProviderQuery); err != nil {
+		if _, err = deleteAction(catalogUri, caryatid.CatalogQueryParams{Version: tc.VersionQuery, Provider: tc.ProviderQuery}, false); err != nil {
 			t.Fatalf("deleteAction(*, *, '%v', '%v') returned an unexpected error: %v\n", tc.VersionQuery, tc.ProviderQuery, err)
 		}
 
 		fuzzyEqualsParams := caryatid.CatalogFuzzyEqualsParams{SkipProviderUrl: true, LogMismatch: true}
-		if result, err = queryAction(catalogUri, "", ""); err != nil {
+		if result, err = queryAction(catalogUri, caryatid.CatalogQueryParams{}); err != nil {
 			t.Fatalf("queryAction(*, *, '%v', '%v') returned an unexpected error: %v\n", tc.VersionQuery, tc.ProviderQuery, err)
 		} else if !result.FuzzyEquals(&tc.ExpectedResult, fuzzyEqualsParams) {
 			t.Fatalf(
@@ -541,3 +1324,1335 @@ func TestDeleteAction(t *testing.T) {
 		}
 	}
 }
+
+func TestDoctorAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		catalogUri = fmt.Sprintf("file://%v", path.Join(integrationTestDir, "TestDoctorActionBox.json"))
+	)
+	defer func(old bool) { readOnlyFlag = old }(readOnlyFlag)
+
+	if result, err = doctorAction(catalogUri); err != nil {
+		t.Fatalf("doctorAction() failed on a writable backend: %v\n%v", err, result)
+	}
+	if strings.Count(result, "PASS ") != 5 || !strings.Contains(result, "PASS read catalog: there is no catalog yet\n") {
+		t.Fatalf("Expected every step to pass, but got:\n%v", result)
+	}
+
+	readOnlyFlag = true
+	if result, err = doctorAction(catalogUri); err != nil {
+		t.Fatalf("doctorAction() failed on a read-only backend: %v\n%v", err, result)
+	}
+	if !strings.Contains(result, "SKIP write temporary file: the backend is read-only\n") {
+		t.Fatalf("Expected the write to be skipped for a read-only backend, but got:\n%v", result)
+	}
+	readOnlyFlag = false
+
+	result, err = doctorAction("nonexistent://bucket/TestDoctorActionBox.json")
+	if !errors.Is(err, errVerificationFailed) || !strings.HasPrefix(result, "FAIL resolve backend: ") {
+		t.Fatalf("Expected an unknown backend to fail, but got error %v and:\n%v", err, result)
+	}
+}
+
+func TestVerifyAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestVerifyAction.box")
+		boxProvider = "TestVerifyActionProvider"
+		boxName     = "TestVerifyActionBox"
+		boxDesc     = "TestVerifyActionBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+		corruptPath = path.Join(integrationTestDir, boxName, fmt.Sprintf("%v_%v_%v.box", boxName, "2.0.0", boxProvider))
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+			t.Fatalf("addAction() failed with error: %v\n", err)
+		}
+	}
+
+	if result, err = verifyAction(catalogUri, caryatid.CatalogQueryParams{}, 2); err != nil {
+		t.Fatalf("verifyAction() failed on an intact catalog: %v\n%v", err, result)
+	}
+
+	if err = ioutil.WriteFile(corruptPath, []byte("corrupted"), 0666); err != nil {
+		t.Fatalf("Error trying to corrupt box file: %v\n", err)
+	}
+	if result, err = verifyAction(catalogUri, caryatid.CatalogQueryParams{}, 2); err == nil {
+		t.Fatalf("verifyAction() did not fail on a corrupted box\n%v", result)
+	}
+	if !strings.Contains(result, "PASS 1.0.0") || !strings.Contains(result, "FAIL 2.0.0") {
+		t.Fatalf("Unexpected verifyAction() summary:\n%v", result)
+	}
+
+	// Scoping verification to the intact version should pass
+	if result, err = verifyAction(catalogUri, caryatid.CatalogQueryParams{Version: "<2"}, 2); err != nil {
+		t.Fatalf("verifyAction() failed when scoped to an intact version: %v\n%v", err, result)
+	}
+}
+
+func TestVerifyActionChecksumCache(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestVerifyActionChecksumCache.box")
+		boxProvider = "TestVerifyActionChecksumCacheProvider"
+		boxName     = "TestVerifyActionChecksumCacheBox"
+		boxDesc     = "TestVerifyActionChecksumCacheBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+		storedPath  = path.Join(integrationTestDir, boxName, fmt.Sprintf("%v_%v_%v.box", boxName, "1.0.0", boxProvider))
+		cachePath   = path.Join(integrationTestDir, "TestVerifyActionChecksumCache", "checksums.json")
+	)
+	defer func(oldCache string, oldNoCache bool) {
+		checksumCacheFlag, noCacheFlag = oldCache, oldNoCache
+	}(checksumCacheFlag, noCacheFlag)
+	checksumCacheFlag, noCacheFlag = cachePath, false
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+
+	if result, err = verifyAction(catalogUri, caryatid.CatalogQueryParams{}, 1); err != nil {
+		t.Fatalf("verifyAction() failed on an intact catalog: %v\n%v", err, result)
+	}
+	if _, err = os.Stat(cachePath); err != nil {
+		t.Fatalf("Expected verifyAction() to save a checksum cache at '%v', but got error: %v\n", cachePath, err)
+	}
+
+	// Replace the box with different contents of the same size and modification time,
+	// which the cache cannot tell apart from the original
+	stat, err := os.Stat(storedPath)
+	if err != nil {
+		t.Fatalf("Error trying to stat stored box file: %v\n", err)
+	}
+	if err = ioutil.WriteFile(storedPath, make([]byte, stat.Size()), 0666); err != nil {
+		t.Fatalf("Error trying to corrupt box file: %v\n", err)
+	}
+	if err = os.Chtimes(storedPath, stat.ModTime(), stat.ModTime()); err != nil {
+		t.Fatalf("Error trying to reset box file modification time: %v\n", err)
+	}
+
+	if result, err = verifyAction(catalogUri, caryatid.CatalogQueryParams{}, 1); err != nil {
+		t.Fatalf("Expected verifyAction() to use the cached checksum, but it failed: %v\n%v", err, result)
+	}
+	noCacheFlag = true
+	if result, err = verifyAction(catalogUri, caryatid.CatalogQueryParams{}, 1); err == nil {
+		t.Fatalf("Expected verifyAction() with -no-cache to hash the corrupted box and fail\n%v", result)
+	}
+
+	// The checksum computed with -no-cache replaces the stale one in the cache
+	noCacheFlag = false
+	if result, err = verifyAction(catalogUri, caryatid.CatalogQueryParams{}, 1); err == nil {
+		t.Fatalf("Expected verifyAction() to fail using the refreshed cache\n%v", result)
+	}
+}
+
+func TestRecomputeChecksumsAction(t *testing.T) {
+	var (
+		err     error
+		result  string
+		catalog caryatid.Catalog
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestRecomputeChecksumsAction.box")
+		boxProvider = "TestRecomputeChecksumsActionProvider"
+		boxName     = "TestRecomputeChecksumsActionBox"
+		boxDesc     = "TestRecomputeChecksumsActionBox is a test box"
+		boxVersion  = "1.0.0"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: boxVersion, ChecksumType: "md5"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+
+	readChecksumType := func() string {
+		catalogBytes, err := ioutil.ReadFile(catalogPath)
+		if err != nil {
+			t.Fatalf("Could not read catalog at '%v'\n", catalogPath)
+		}
+		if err = json.Unmarshal(catalogBytes, &catalog); err != nil {
+			t.Fatalf("Error trying to unmarshal the catalog: %v\n", err)
+		}
+		return catalog.Versions[0].Providers[0].ChecksumType
+	}
+
+	if result, err = recomputeChecksumsAction(catalogUri, caryatid.CatalogQueryParams{}, "sha512", true); err != nil {
+		t.Fatalf("recomputeChecksumsAction() failed during a dry run: %v\n", err)
+	}
+	if !strings.Contains(result, "WOULD UPDATE") {
+		t.Fatalf("Unexpected dry run summary:\n%v", result)
+	}
+	if checksumType := readChecksumType(); checksumType != "md5" {
+		t.Fatalf("A dry run changed the checksum type to '%v'\n", checksumType)
+	}
+
+	if result, err = recomputeChecksumsAction(catalogUri, caryatid.CatalogQueryParams{}, "sha512", false); err != nil {
+		t.Fatalf("recomputeChecksumsAction() failed: %v\n", err)
+	}
+	if checksumType := readChecksumType(); checksumType != "sha512" {
+		t.Fatalf("Expected checksum type to be 'sha512' but it was '%v'\n", checksumType)
+	}
+	if result, err = verifyAction(catalogUri, caryatid.CatalogQueryParams{}, 2); err != nil {
+		t.Fatalf("The catalog failed verification after recomputing checksums: %v\n%v", err, result)
+	}
+}
+
+func TestPruneAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestPruneAction.box")
+		boxProvider = "TestPruneActionProvider"
+		boxName     = "TestPruneActionBox"
+		boxDesc     = "TestPruneActionBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+
+	boxFilePath := func(version string) string {
+		return path.Join(integrationTestDir, boxName, fmt.Sprintf("%v_%v_%v.box", boxName, version, boxProvider))
+	}
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "1.10.0", "1.9.0"} {
+		if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+			t.Fatalf("addAction() failed with error: %v\n", err)
+		}
+	}
+
+	if result, err = pruneAction(catalogUri, caryatid.CatalogQueryParams{}, 2, false, true); err != nil {
+		t.Fatalf("pruneAction() failed during a dry run: %v\n", err)
+	}
+	if !strings.Contains(result, "WOULD PRUNE 1.0.0") || !strings.Contains(result, boxFilePath("1.0.0")) || !strings.Contains(result, "Would free ") || strings.Contains(result, "1.9.0") {
+		t.Fatalf("Unexpected dry run summary:\n%v", result)
+	}
+	if _, err = os.Stat(boxFilePath("1.0.0")); err != nil {
+		t.Fatalf("A dry run deleted a box file: %v\n", err)
+	}
+
+	if result, err = pruneAction(catalogUri, caryatid.CatalogQueryParams{}, 2, false, false); err != nil {
+		t.Fatalf("pruneAction() failed: %v\n", err)
+	}
+	if _, err = os.Stat(boxFilePath("1.0.0")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the pruned box file to be deleted, but got: %v\n", err)
+	}
+	for _, version := range []string{"1.9.0", "1.10.0"} {
+		if _, err = os.Stat(boxFilePath(version)); err != nil {
+			t.Fatalf("Expected the box file for %v to be kept: %v\n", version, err)
+		}
+	}
+	catalog, err := queryAction(catalogUri, caryatid.CatalogQueryParams{})
+	if err != nil {
+		t.Fatalf("queryAction() failed: %v\n", err)
+	}
+	if len(catalog.Versions) != 2 {
+		t.Fatalf("Expected 2 versions after pruning, but got:\n%v", catalog.DisplayString())
+	}
+}
+
+func TestMergeAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxProvider1 = "StrongSapling"
+		boxProvider2 = "FeebleFungus"
+		boxPath1     = path.Join(integrationTestDir, "incoming-TestMergeActionBox-1.box")
+		boxPath2     = path.Join(integrationTestDir, "incoming-TestMergeActionBox-2.box")
+		boxName      = "TestMergeActionBox"
+		boxDesc      = "TestMergeActionBox is a test box"
+		sourceDir    = path.Join(integrationTestDir, "TestMergeActionSource")
+		destDir      = path.Join(integrationTestDir, "TestMergeActionDest")
+		sourceUri    = fmt.Sprintf("file://%v/%v.json", sourceDir, boxName)
+		destUri      = fmt.Sprintf("file://%v/%v.json", destDir, boxName)
+	)
+
+	type BoxSpec struct {
+		Path     string
+		Version  string
+		Provider string
+		Checksum string
+	}
+	addBoxes := func(catalogUri string, description string, boxes []BoxSpec) {
+		manager, err := getManager(catalogUri)
+		if err != nil {
+			t.Fatalf("Error getting a BackendManager: %v\n", err)
+		}
+		for _, box := range boxes {
+			artifact := caryatid.BoxArtifact{Path: box.Path, Name: boxName, Description: description, Version: box.Version, Provider: box.Provider, ChecksumType: "sha256", Checksum: box.Checksum}
+			if err = manager.AddBox(artifact); err != nil {
+				t.Fatalf("Error adding box metadata to catalog: %v\n", err)
+			}
+		}
+	}
+
+	if err = caryatid.CreateTestBoxFile(boxPath1, boxProvider1, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err = caryatid.CreateTestBoxFile(boxPath2, boxProvider2, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+
+	// The catalogs overlap at 1.0.0 for boxProvider1, and only the checksum tells them apart
+	addBoxes(sourceUri, "Source description", []BoxSpec{
+		BoxSpec{boxPath1, "0.3.5", boxProvider1, "0xSOURCE"},
+		BoxSpec{boxPath1, "1.0.0", boxProvider1, "0xSOURCE"},
+		BoxSpec{boxPath1, "1.2.3", boxProvider1, "0xSOURCE"},
+		BoxSpec{boxPath2, "1.2.3", boxProvider2, "0xSOURCE"},
+		BoxSpec{boxPath2, "2.0.0", boxProvider2, "0xSOURCE"},
+	})
+	addBoxes(destUri, boxDesc, []BoxSpec{
+		BoxSpec{boxPath2, "0.3.4", boxProvider2, "0xDEST"},
+		BoxSpec{boxPath1, "1.0.0", boxProvider1, "0xDEST"},
+		BoxSpec{boxPath1, "1.4.5", boxProvider1, "0xDEST"},
+	})
+
+	if result, err = mergeAction(destUri, sourceUri, true, false, false); err != nil {
+		t.Fatalf("mergeAction() failed: %v\n", err)
+	}
+	if strings.Contains(result, "MERGED 1.0.0") {
+		t.Fatalf("Expected the conflicting box to be skipped without -overwrite, but got:\n%v", result)
+	}
+
+	merged, err := queryAction(destUri, caryatid.CatalogQueryParams{})
+	if err != nil {
+		t.Fatalf("queryAction() failed: %v\n", err)
+	}
+	if merged.Name != boxName || merged.Description != boxDesc {
+		t.Fatalf("Expected the merged catalog to keep the destination name and description, but got '%v' (%v)\n", merged.Name, merged.Description)
+	}
+	refs := merged.BoxReferences()
+	if len(refs) != 7 {
+		t.Fatalf("Expected 7 boxes in the merged catalog, but got:\n%v", merged.DisplayString())
+	}
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref.Uri, fmt.Sprintf("file://%v/", destDir)) {
+			t.Fatalf("Expected box URLs to be rewritten to the destination, but got '%v'\n", ref.Uri)
+		}
+		if _, err = os.Stat(strings.TrimPrefix(ref.Uri, "file://")); err != nil {
+			t.Fatalf("Expected the box file for %v %v to exist in the destination: %v\n", ref.Version, ref.ProviderName, err)
+		}
+	}
+
+	checksumOf100 := func() string {
+		catalog, err := queryAction(destUri, caryatid.CatalogQueryParams{Version: "=1.0.0"})
+		if err != nil {
+			t.Fatalf("queryAction() failed: %v\n", err)
+		}
+		return catalog.Versions[0].Providers[0].Checksum
+	}
+	if checksum := checksumOf100(); checksum != "0xDEST" {
+		t.Fatalf("Expected the destination box to win without -overwrite, but got checksum '%v'\n", checksum)
+	}
+
+	if result, err = mergeAction(destUri, sourceUri, true, true, false); err != nil {
+		t.Fatalf("mergeAction() with overwrite failed: %v\n", err)
+	}
+	if checksum := checksumOf100(); checksum != "0xSOURCE" {
+		t.Fatalf("Expected the source box to win with -overwrite, but got checksum '%v'\n", checksum)
+	}
+}
+
+func TestCopyAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxProvider1 = "StrongSapling"
+		boxProvider2 = "FeebleFungus"
+		boxPath1     = path.Join(integrationTestDir, "incoming-TestCopyActionBox-1.box")
+		boxPath2     = path.Join(integrationTestDir, "incoming-TestCopyActionBox-2.box")
+		boxName      = "TestCopyActionBox"
+		boxDesc      = "TestCopyActionBox is a test box"
+		sourceDir    = path.Join(integrationTestDir, "TestCopyActionSource")
+		destDir      = path.Join(integrationTestDir, "TestCopyActionDest")
+		sourceUri    = fmt.Sprintf("file://%v/%v.json", sourceDir, boxName)
+		destUri      = fmt.Sprintf("file://%v/%v.json", destDir, boxName)
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath1, boxProvider1, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err = caryatid.CreateTestBoxFile(boxPath2, boxProvider2, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		if err = addAction([]string{boxPath1, boxPath2}, sourceUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+			t.Fatalf("addAction() failed with error: %v\n", err)
+		}
+	}
+
+	// Copy only one provider of one version
+	if result, err = copyAction(destUri, sourceUri, caryatid.CatalogQueryParams{Version: "2.0.0", Provider: boxProvider1}, false, false); err != nil {
+		t.Fatalf("copyAction() failed: %v\n", err)
+	}
+	if result != fmt.Sprintf("COPIED 2.0.0 %v\n", boxProvider1) {
+		t.Fatalf("Unexpected copyAction() result:\n%v", result)
+	}
+	dest, err := queryAction(destUri, caryatid.CatalogQueryParams{})
+	if err != nil {
+		t.Fatalf("queryAction() failed: %v\n", err)
+	}
+	if dest.Name != boxName || len(dest.Versions) != 1 || len(dest.Versions[0].Providers) != 1 {
+		t.Fatalf("Expected only the copied box in the destination catalog, but got:\n%v", dest.DisplayString())
+	}
+	copiedPath := path.Join(destDir, boxName, fmt.Sprintf("%v_2.0.0_%v.box", boxName, boxProvider1))
+	if provider := dest.Versions[0].Providers[0]; provider.Url != "file://"+copiedPath {
+		t.Fatalf("Expected the copied box to point to '%v', but it points to '%v'\n", copiedPath, provider.Url)
+	}
+	if _, err = os.Stat(copiedPath); err != nil {
+		t.Fatalf("Expected the box file to be copied to the destination: %v\n", err)
+	}
+
+	// Moving the rest of version 2.0.0 skips the box already copied, but removes it from the source too
+	if result, err = copyAction(destUri, sourceUri, caryatid.CatalogQueryParams{Version: "2.0.0"}, true, false); err != nil {
+		t.Fatalf("copyAction() with move failed: %v\n", err)
+	}
+	if result != fmt.Sprintf("MOVED 2.0.0 %v\n", boxProvider2) {
+		t.Fatalf("Unexpected copyAction() result:\n%v", result)
+	}
+	source, err := queryAction(sourceUri, caryatid.CatalogQueryParams{})
+	if err != nil {
+		t.Fatalf("queryAction() failed: %v\n", err)
+	}
+	if len(source.Versions) != 1 || source.Versions[0].Version != "1.0.0" || len(source.Versions[0].Providers) != 2 {
+		t.Fatalf("Expected only version 1.0.0 to be left in the source catalog, but got:\n%v", source.DisplayString())
+	}
+	for _, provider := range []string{boxProvider1, boxProvider2} {
+		if _, err = os.Stat(path.Join(sourceDir, boxName, fmt.Sprintf("%v_2.0.0_%v.box", boxName, provider))); !os.IsNotExist(err) {
+			t.Fatalf("Expected the moved box file for %v to be deleted from the source, but got: %v\n", provider, err)
+		}
+		if _, err = os.Stat(path.Join(destDir, boxName, fmt.Sprintf("%v_2.0.0_%v.box", boxName, provider))); err != nil {
+			t.Fatalf("Expected the box file for %v to be in the destination: %v\n", provider, err)
+		}
+	}
+	if dest, err = queryAction(destUri, caryatid.CatalogQueryParams{}); err != nil {
+		t.Fatalf("queryAction() failed: %v\n", err)
+	}
+	if len(dest.Versions) != 1 || len(dest.Versions[0].Providers) != 2 {
+		t.Fatalf("Expected both providers of 2.0.0 in the destination catalog, but got:\n%v", dest.DisplayString())
+	}
+
+	if _, err = copyAction(sourceUri, sourceUri, caryatid.CatalogQueryParams{}, false, false); err == nil {
+		t.Fatalf("Expected copyAction() from a catalog into itself to fail\n")
+	}
+}
+
+func TestDedupAction(t *testing.T) {
+	var (
+		err     error
+		result  string
+		catalog caryatid.Catalog
+
+		boxName     = "TestDedupActionBox"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+		provider    = caryatid.Provider{Name: "StrongSapling", Url: "file:///example/box", ChecksumType: "sha256", Checksum: "0xB00B1E5"}
+	)
+
+	duplicated := caryatid.Catalog{Name: boxName, Description: "desc", Versions: []caryatid.Version{
+		caryatid.Version{Version: "1.0.0", Providers: []caryatid.Provider{provider, provider}},
+	}}
+	catalogBytes, err := json.Marshal(duplicated)
+	if err != nil {
+		t.Fatalf("Error trying to marshal the catalog: %v\n", err)
+	}
+	if err = ioutil.WriteFile(catalogPath, catalogBytes, 0666); err != nil {
+		t.Fatalf("Error trying to write the catalog: %v\n", err)
+	}
+
+	providerCount := func() int {
+		catalogBytes, err := ioutil.ReadFile(catalogPath)
+		if err != nil {
+			t.Fatalf("Could not read catalog at '%v'\n", catalogPath)
+		}
+		if err = json.Unmarshal(catalogBytes, &catalog); err != nil {
+			t.Fatalf("Error trying to unmarshal the catalog: %v\n", err)
+		}
+		return len(catalog.Versions[0].Providers)
+	}
+
+	if result, err = dedupAction(catalogUri, true); err != nil {
+		t.Fatalf("dedupAction() failed during a dry run: %v\n", err)
+	}
+	if count := providerCount(); count != 2 {
+		t.Fatalf("A dry run changed the catalog to have %v providers\n%v", count, result)
+	}
+
+	if result, err = dedupAction(catalogUri, false); err != nil {
+		t.Fatalf("dedupAction() failed: %v\n", err)
+	}
+	if !strings.Contains(result, "Removed 1 ") {
+		t.Fatalf("Unexpected dedupAction() summary:\n%v", result)
+	}
+	if count := providerCount(); count != 1 {
+		t.Fatalf("Expected 1 provider after deduplicating, but got %v\n", count)
+	}
+}
+
+func TestRenameAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestRenameAction.box")
+		boxProvider = "TestRenameActionProvider"
+		boxName     = "TestRenameActionBox"
+		newName     = "TestRenameActionBoxRenamed"
+		boxDesc     = "TestRenameActionBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+
+	boxFilePath := func(name string, version string) string {
+		return path.Join(integrationTestDir, name, fmt.Sprintf("%v_%v_%v.box", name, version, boxProvider))
+	}
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+			t.Fatalf("addAction() failed with error: %v\n", err)
+		}
+	}
+
+	if result, err = renameAction(catalogUri, newName, true); err != nil {
+		t.Fatalf("renameAction() failed during a dry run: %v\n", err)
+	}
+	if !strings.Contains(result, "WOULD MOVE 1.0.0") {
+		t.Fatalf("Unexpected dry run summary:\n%v", result)
+	}
+	if _, err = os.Stat(boxFilePath(boxName, "1.0.0")); err != nil {
+		t.Fatalf("A dry run moved a box file: %v\n", err)
+	}
+
+	if result, err = renameAction(catalogUri, newName, false); err != nil {
+		t.Fatalf("renameAction() failed: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		if _, err = os.Stat(boxFilePath(boxName, version)); !os.IsNotExist(err) {
+			t.Fatalf("Expected the old box file for %v to be gone, but got: %v\n", version, err)
+		}
+		if _, err = os.Stat(boxFilePath(newName, version)); err != nil {
+			t.Fatalf("Expected the new box file for %v to exist: %v\n", version, err)
+		}
+	}
+
+	catalog, err := queryAction(catalogUri, caryatid.CatalogQueryParams{})
+	if err != nil {
+		t.Fatalf("queryAction() failed: %v\n", err)
+	}
+	if catalog.Name != newName {
+		t.Fatalf("Expected the catalog to be named '%v', but it was '%v'\n", newName, catalog.Name)
+	}
+	if result, err = verifyAction(catalogUri, caryatid.CatalogQueryParams{}, 2); err != nil {
+		t.Fatalf("The renamed catalog failed verification: %v\n%v", err, result)
+	}
+}
+
+func TestGcAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestGcAction.box")
+		boxProvider = "TestGcActionProvider"
+		boxName     = "TestGcActionBox"
+		boxDesc     = "TestGcActionBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+		strayPath   = path.Join(integrationTestDir, boxName, fmt.Sprintf("%v_%v_%v.box", boxName, "0.9.0", boxProvider))
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+			t.Fatalf("addAction() failed with error: %v\n", err)
+		}
+	}
+	if err = ioutil.WriteFile(strayPath, []byte("stray"), 0666); err != nil {
+		t.Fatalf("Error trying to create a stray box file: %v\n", err)
+	}
+
+	if result, err = gcAction(catalogUri, false, false, false); err != nil {
+		t.Fatalf("gcAction() failed without -force: %v\n", err)
+	}
+	if !strings.Contains(result, "WOULD DELETE") || !strings.Contains(result, "0.9.0") {
+		t.Fatalf("Unexpected gcAction() summary without -force:\n%v", result)
+	}
+	if _, err = os.Stat(strayPath); err != nil {
+		t.Fatalf("gcAction() deleted a file without -force: %v\n", err)
+	}
+
+	if result, err = gcAction(catalogUri, true, true, false); err != nil {
+		t.Fatalf("gcAction() failed with -force and -dry-run: %v\n", err)
+	}
+	if !strings.Contains(result, "WOULD DELETE") {
+		t.Fatalf("Unexpected gcAction() summary with -force and -dry-run:\n%v", result)
+	}
+	if _, err = os.Stat(strayPath); err != nil {
+		t.Fatalf("gcAction() deleted a file with -dry-run: %v\n", err)
+	}
+
+	if result, err = gcAction(catalogUri, true, false, false); err != nil {
+		t.Fatalf("gcAction() failed: %v\n", err)
+	}
+	if strings.Contains(result, "1.0.0") || strings.Contains(result, "2.0.0") {
+		t.Fatalf("gcAction() deleted referenced box files:\n%v", result)
+	}
+	if _, err = os.Stat(strayPath); !os.IsNotExist(err) {
+		t.Fatalf("Expected the stray box file to be deleted, but got: %v\n", err)
+	}
+	if result, err = verifyAction(catalogUri, caryatid.CatalogQueryParams{}, 2); err != nil {
+		t.Fatalf("The catalog failed verification after collecting garbage: %v\n%v", err, result)
+	}
+}
+
+func TestDeleteActionDryRun(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestDeleteActionDryRun.box")
+		boxProvider = "TestDeleteActionDryRunProvider"
+		boxName     = "TestDeleteActionDryRunBox"
+		boxDesc     = "TestDeleteActionDryRunBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+		boxFilePath = path.Join(integrationTestDir, boxName, fmt.Sprintf("%v_%v_%v.box", boxName, "1.0.0", boxProvider))
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	boxInfo, err := os.Stat(boxPath)
+	if err != nil {
+		t.Fatalf("Error reading test box file: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "1.5.0", "2.0.0"} {
+		if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+			t.Fatalf("addAction() failed with error: %v\n", err)
+		}
+	}
+	catalogBefore, err := ioutil.ReadFile(catalogPath)
+	if err != nil {
+		t.Fatalf("Error reading catalog: %v\n", err)
+	}
+
+	query := caryatid.CatalogQueryParams{Version: "<2"}
+	if result, err = deleteAction(catalogUri, query, true); err != nil {
+		t.Fatalf("deleteAction() failed with -dry-run: %v\n", err)
+	}
+	if !strings.Contains(result, "WOULD DELETE 1.0.0") || !strings.Contains(result, boxFilePath) || strings.Contains(result, "2.0.0") {
+		t.Fatalf("Unexpected deleteAction() summary with -dry-run:\n%v", result)
+	}
+
+	// The dry run reports the box file of each provider that the query selects, and nothing else
+	selected, err := queryAction(catalogUri, query)
+	if err != nil {
+		t.Fatalf("queryAction() failed: %v\n", err)
+	}
+	expectedPaths := []string{}
+	for _, ref := range selected.BoxReferences() {
+		expectedPaths = append(expectedPaths, ref.Uri)
+	}
+	reportedPaths := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(result), "\n") {
+		if strings.HasPrefix(line, "WOULD DELETE ") {
+			reportedPaths = append(reportedPaths, line[strings.LastIndex(line, "(")+1:len(line)-1])
+		}
+	}
+	sort.Strings(expectedPaths)
+	sort.Strings(reportedPaths)
+	if len(expectedPaths) != 2 || !reflect.DeepEqual(reportedPaths, expectedPaths) {
+		t.Fatalf("Expected deleteAction() with -dry-run to report the paths %v, but it reported %v\n", expectedPaths, reportedPaths)
+	}
+	expectedTotal := fmt.Sprintf("Would free %v bytes across 2 files\n", 2*boxInfo.Size())
+	if !strings.HasSuffix(result, expectedTotal) {
+		t.Fatalf("Expected deleteAction() with -dry-run to end with '%v', but got:\n%v", strings.TrimSpace(expectedTotal), result)
+	}
+	catalogAfter, err := ioutil.ReadFile(catalogPath)
+	if err != nil {
+		t.Fatalf("Error reading catalog: %v\n", err)
+	}
+	if string(catalogAfter) != string(catalogBefore) {
+		t.Fatalf("deleteAction() changed the catalog with -dry-run:\n%v", string(catalogAfter))
+	}
+	if _, err = os.Stat(boxFilePath); err != nil {
+		t.Fatalf("deleteAction() deleted a box file with -dry-run: %v\n", err)
+	}
+
+	if result, err = deleteAction(catalogUri, caryatid.CatalogQueryParams{Version: "<2"}, false); err != nil {
+		t.Fatalf("deleteAction() failed: %v\n", err)
+	}
+	if !strings.Contains(result, "DELETED 1.0.0") {
+		t.Fatalf("Unexpected deleteAction() summary:\n%v", result)
+	}
+	if _, err = os.Stat(boxFilePath); !os.IsNotExist(err) {
+		t.Fatalf("Expected the box file to be deleted, but got: %v\n", err)
+	}
+}
+
+func TestDeleteActionExact(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestDeleteActionExact.box")
+		boxProvider = "TestDeleteActionExactProvider"
+		boxName     = "TestDeleteActionExactBox"
+		boxDesc     = "TestDeleteActionExactBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+		exactParams = caryatid.CatalogQueryParams{Version: "1.0.0", ExactVersion: true}
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "1.0.0-PRE"} {
+		if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+			t.Fatalf("addAction() failed with error: %v\n", err)
+		}
+	}
+
+	queried, err := queryAction(catalogUri, exactParams)
+	if err != nil {
+		t.Fatalf("queryAction() failed with an exact version: %v\n", err)
+	}
+	if len(queried.Versions) != 1 || queried.Versions[0].Version != "1.0.0" {
+		t.Fatalf("Expected queryAction() with an exact version to match only 1.0.0, but got:\n%v\n", queried)
+	}
+	if _, err = queryAction(catalogUri, caryatid.CatalogQueryParams{Version: ">=1.0.0", ExactVersion: true}); err == nil {
+		t.Fatalf("Expected queryAction() to fail with an exact version containing an operator\n")
+	}
+
+	if result, err = deleteAction(catalogUri, exactParams, false); err != nil {
+		t.Fatalf("deleteAction() failed with an exact version: %v\n", err)
+	}
+	if !strings.Contains(result, "DELETED 1.0.0 ") || strings.Contains(result, "1.0.0-PRE") {
+		t.Fatalf("Unexpected deleteAction() summary with an exact version:\n%v", result)
+	}
+	remaining, err := queryAction(catalogUri, caryatid.CatalogQueryParams{})
+	if err != nil {
+		t.Fatalf("queryAction() failed: %v\n", err)
+	}
+	if len(remaining.Versions) != 1 || remaining.Versions[0].Version != "1.0.0-PRE" {
+		t.Fatalf("Expected only 1.0.0-PRE to remain after deleting exactly 1.0.0, but got:\n%v\n", remaining)
+	}
+}
+
+func TestListCatalogsAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestListCatalogsAction.box")
+		boxProvider = "TestListCatalogsActionProvider"
+		rootPath    = path.Join(integrationTestDir, "TestListCatalogsAction")
+		rootUri     = fmt.Sprintf("file://%v", rootPath)
+	)
+
+	if err = os.RemoveAll(rootPath); err != nil {
+		t.Fatalf("Error removing old test directory: %v\n", err)
+	}
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+
+	// Each catalog, relative to the root, and the versions to add to it
+	catalogs := map[string][]string{
+		"alpha.json":             []string{"1.0.0"},
+		"team/beta.json":         []string{"1.0.0", "1.1.0"},
+		"team/nested/gamma.json": []string{"1.0.0", "2.0.0", "3.0.0"},
+	}
+	for relPath, versions := range catalogs {
+		name := strings.TrimSuffix(path.Base(relPath), ".json")
+		for _, version := range versions {
+			if err = addAction([]string{boxPath}, fmt.Sprintf("%v/%v", rootUri, relPath), addParams{Name: name, Description: "desc", Version: version, ChecksumType: "sha256"}); err != nil {
+				t.Fatalf("addAction() failed with error: %v\n", err)
+			}
+		}
+	}
+	if err = ioutil.WriteFile(path.Join(rootPath, "team", "package.json"), []byte(`{"name": "not-a-catalog"}`), 0666); err != nil {
+		t.Fatalf("Error writing a JSON file that is not a catalog: %v\n", err)
+	}
+
+	if result, err = listCatalogsAction(rootUri); err != nil {
+		t.Fatalf("listCatalogsAction() failed: %v\n", err)
+	}
+	lines := strings.Split(strings.TrimSpace(result), "\n")
+	if len(lines) != len(catalogs) {
+		t.Fatalf("Expected listCatalogsAction() to find %v catalogs, but got:\n%v", len(catalogs), result)
+	}
+	for relPath, versions := range catalogs {
+		expected := fmt.Sprintf("%v: %v versions (%v/%v)", strings.TrimSuffix(path.Base(relPath), ".json"), len(versions), rootUri, relPath)
+		if !strings.Contains(result, expected+"\n") {
+			t.Fatalf("Expected listCatalogsAction() to list '%v', but got:\n%v", expected, result)
+		}
+	}
+	if strings.Contains(result, "not-a-catalog") {
+		t.Fatalf("Expected listCatalogsAction() to skip a JSON file that is not a catalog, but got:\n%v", result)
+	}
+
+	if result, err = listCatalogsAction(rootUri + "/nonexistent"); err != nil {
+		t.Fatalf("listCatalogsAction() failed for an empty directory: %v\n", err)
+	}
+	if !strings.HasPrefix(result, "No catalogs found") {
+		t.Fatalf("Unexpected listCatalogsAction() result for an empty directory:\n%v", result)
+	}
+}
+
+func TestImportAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxName     = "TestImportActionBox"
+		importDir   = path.Join(integrationTestDir, "TestImportAction")
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+
+	if err = os.RemoveAll(importDir); err != nil {
+		t.Fatalf("Error removing old test directory: %v\n", err)
+	}
+	if err = os.MkdirAll(importDir, 0777); err != nil {
+		t.Fatalf("Error creating test directory: %v\n", err)
+	}
+	boxes := map[string]string{
+		fmt.Sprintf("%v_1.0.0_virtualbox.box", boxName): "virtualbox",
+		fmt.Sprintf("%v_1.1.0_libvirt.box", boxName):    "libvirt",
+	}
+	for name, provider := range boxes {
+		if err = caryatid.CreateTestBoxFile(path.Join(importDir, name), provider, true); err != nil {
+			t.Fatalf("Error trying to create test box file: %v\n", err)
+		}
+	}
+	if err = ioutil.WriteFile(path.Join(importDir, "build.log"), []byte("not a box"), 0666); err != nil {
+		t.Fatalf("Error writing a file that is not a box: %v\n", err)
+	}
+
+	if result, err = importAction(importDir, catalogUri, "", "", "Imported boxes", "sha256", true); err != nil {
+		t.Fatalf("importAction() failed with -dry-run: %v\n", err)
+	}
+	if !strings.Contains(result, "WOULD IMPORT TestImportActionBox 1.0.0 virtualbox") || !strings.Contains(result, "WOULD IMPORT TestImportActionBox 1.1.0 libvirt") {
+		t.Fatalf("Unexpected importAction() summary with -dry-run:\n%v", result)
+	}
+	if _, err = os.Stat(catalogPath); !os.IsNotExist(err) {
+		t.Fatalf("importAction() created the catalog with -dry-run: %v\n", err)
+	}
+
+	if result, err = importAction(importDir, catalogUri, "", "", "Imported boxes", "sha256", false); err != nil {
+		t.Fatalf("importAction() failed: %v\n", err)
+	}
+	if strings.Count(result, "IMPORTED") != 2 {
+		t.Fatalf("Expected importAction() to import 2 boxes, but got:\n%v", result)
+	}
+	catalog, err := queryAction(catalogUri, caryatid.CatalogQueryParams{})
+	if err != nil {
+		t.Fatalf("queryAction() failed: %v\n", err)
+	}
+	if catalog.Name != boxName || catalog.Description != "Imported boxes" {
+		t.Fatalf("Unexpected name or description of imported catalog:\n%v", catalog.DisplayString())
+	}
+	for _, box := range []struct{ Version, Provider string }{{"1.0.0", "virtualbox"}, {"1.1.0", "libvirt"}} {
+		found, err := catalog.QueryCatalog(caryatid.CatalogQueryParams{Version: box.Version, ExactVersion: true, Provider: box.Provider, ProviderMatch: caryatid.ProviderMatchGlob})
+		if err != nil {
+			t.Fatalf("QueryCatalog() failed: %v\n", err)
+		}
+		if len(found.BoxReferences()) != 1 {
+			t.Fatalf("Expected the imported catalog to have a %v box for %v, but got:\n%v", box.Provider, box.Version, catalog.DisplayString())
+		}
+	}
+}
+
+func TestCheckAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestCheckAction.box")
+		boxProvider = "TestCheckActionProvider"
+		boxName     = "TestCheckActionBox"
+		boxDesc     = "TestCheckActionBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+		missingPath = path.Join(integrationTestDir, boxName, fmt.Sprintf("%v_%v_%v.box", boxName, "2.0.0", boxProvider))
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+			t.Fatalf("addAction() failed with error: %v\n", err)
+		}
+	}
+
+	if result, err = checkAction(catalogUri, true); err != nil {
+		t.Fatalf("checkAction() failed on a valid catalog: %v\n%v", err, result)
+	}
+
+	if err = os.Remove(missingPath); err != nil {
+		t.Fatalf("Error trying to remove box file: %v\n", err)
+	}
+	if result, err = checkAction(catalogUri, false); err != nil {
+		t.Fatalf("checkAction() without -deep should not notice a missing box file: %v\n%v", err, result)
+	}
+	if result, err = checkAction(catalogUri, true); err == nil {
+		t.Fatalf("checkAction() with -deep did not fail on a missing box file\n%v", result)
+	}
+	if !strings.Contains(result, "PROBLEM 2.0.0") || strings.Contains(result, "1.0.0") {
+		t.Fatalf("Unexpected checkAction() summary:\n%v", result)
+	}
+}
+
+func TestDiffAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestDiffAction.box")
+		boxProvider = "TestDiffActionProvider"
+		boxName     = "TestDiffActionBox"
+		boxDesc     = "TestDiffActionBox is a test box"
+		catalogUri  = fmt.Sprintf("file://%v", path.Join(integrationTestDir, "TestDiffActionOld", fmt.Sprintf("%v.json", boxName)))
+		otherUri    = fmt.Sprintf("file://%v", path.Join(integrationTestDir, "TestDiffActionNew", fmt.Sprintf("%v.json", boxName)))
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	for _, uri := range []string{catalogUri, otherUri} {
+		if err = addAction([]string{boxPath}, uri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha256"}); err != nil {
+			t.Fatalf("addAction() failed with error: %v\n", err)
+		}
+	}
+
+	if result, err = diffAction(catalogUri, otherUri, false); err == nil {
+		t.Fatalf("diffAction() did not fail on catalogs with different URLs\n%v", result)
+	}
+	if !strings.Contains(result, "DIFFERENCE 1.0.0 TestDiffActionProvider: URL differs") {
+		t.Fatalf("Unexpected diffAction() summary:\n%v", result)
+	}
+	if result, err = diffAction(catalogUri, otherUri, true); err != nil {
+		t.Fatalf("diffAction() with -ignore-urls failed on catalogs that differ only by URL: %v\n%v", err, result)
+	}
+
+	if err = addAction([]string{boxPath}, otherUri, addParams{Name: boxName, Description: boxDesc, Version: "2.0.0", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+	if result, err = diffAction(catalogUri, otherUri, true); err == nil {
+		t.Fatalf("diffAction() did not fail on catalogs with different versions\n%v", result)
+	}
+	if result != "DIFFERENCE 2.0.0: Only in the second catalog\n" {
+		t.Fatalf("Unexpected diffAction() summary:\n%v", result)
+	}
+}
+
+func TestStatsAction(t *testing.T) {
+	var (
+		err    error
+		result string
+		stats  caryatid.CatalogStats
+
+		boxPath1    = path.Join(integrationTestDir, "incoming-TestStatsAction-1.box")
+		boxPath2    = path.Join(integrationTestDir, "incoming-TestStatsAction-2.box")
+		boxName     = "TestStatsActionBox"
+		boxDesc     = "TestStatsActionBox is a test box"
+		catalogUri  = fmt.Sprintf("file://%v/%v.json", integrationTestDir, boxName)
+		boxVersions = map[string][]string{
+			boxPath1: []string{"0.3.5", "1.0.0", "1.2.3"},
+			boxPath2: []string{"0.3.4", "1.2.3", "2.11.1"},
+		}
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath1, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err = caryatid.CreateTestBoxFile(boxPath2, "FeebleFungus", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	boxInfo, err := os.Stat(boxPath1)
+	if err != nil {
+		t.Fatalf("Error trying to stat test box file: %v\n", err)
+	}
+	for boxPath, versions := range boxVersions {
+		for _, version := range versions {
+			if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+				t.Fatalf("addAction() failed with error: %v\n", err)
+			}
+		}
+	}
+
+	if result, err = statsAction(catalogUri, caryatid.CatalogQueryParams{}, "json"); err != nil {
+		t.Fatalf("statsAction() failed with error: %v\n", err)
+	}
+	if err = json.Unmarshal([]byte(result), &stats); err != nil {
+		t.Fatalf("Error decoding statsAction() output: %v\n%v", err, result)
+	}
+	if stats.Versions != 5 || stats.Providers != 6 || len(stats.ProviderNames) != 2 || stats.OldestVersion != "0.3.4" || stats.NewestVersion != "2.11.1" {
+		t.Fatalf("Unexpected stats for the whole catalog: %+v\n", stats)
+	}
+	if stats.UnsizedProviders != 0 || stats.TotalSize == 0 {
+		t.Fatalf("Expected the stats to count the size of every box, but got %+v\n", stats)
+	}
+
+	if result, err = statsAction(catalogUri, caryatid.CatalogQueryParams{Provider: "StrongSapling", Version: "<1.2.3"}, "text"); err != nil {
+		t.Fatalf("statsAction() failed with error: %v\n", err)
+	}
+	expected := fmt.Sprintf("Versions: 2\nProviders: 2\nProvider names: StrongSapling\nOldest version: 0.3.5\nNewest version: 1.0.0\nTotal size: %v\n", util.FormatSize(2*boxInfo.Size()))
+	if result != expected {
+		t.Fatalf("Expected statsAction() to write:\n%v\nBut got:\n%v", expected, result)
+	}
+}
+
+func TestExportAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath1    = path.Join(integrationTestDir, "incoming-TestExportAction-1.box")
+		boxPath2    = path.Join(integrationTestDir, "incoming-TestExportAction-2.box")
+		boxName     = "TestExportActionBox"
+		boxDesc     = "TestExportActionBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath1, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err = caryatid.CreateTestBoxFile(boxPath2, "FeebleFungus", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		for _, boxPath := range []string{boxPath1, boxPath2} {
+			if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+				t.Fatalf("addAction() failed with error: %v\n", err)
+			}
+		}
+	}
+
+	if result, err = exportAction(catalogUri, caryatid.CatalogQueryParams{Version: ">=2", Provider: "Strong"}, "csv", "", 0); err != nil {
+		t.Fatalf("exportAction() failed: %v\n", err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(result)).ReadAll()
+	if err != nil {
+		t.Fatalf("exportAction() did not return valid CSV: %v\n%v", err, result)
+	}
+	if len(rows) != 2 || rows[1][0] != boxName || rows[1][1] != "2.0.0" || rows[1][2] != "StrongSapling" {
+		t.Fatalf("Expected a header and one row for StrongSapling 2.0.0, but got:\n%v", result)
+	}
+
+	if result, err = exportAction(catalogUri, caryatid.CatalogQueryParams{}, "json", "", 0); err != nil {
+		t.Fatalf("exportAction() failed: %v\n", err)
+	}
+	var exported caryatid.Catalog
+	if err = json.Unmarshal([]byte(result), &exported); err != nil {
+		t.Fatalf("exportAction() did not return valid JSON: %v\n%v", err, result)
+	}
+	if len(exported.BoxReferences()) != 4 {
+		t.Fatalf("Expected 4 boxes in the JSON export, but got:\n%v", result)
+	}
+
+	// The local file backend cannot sign URLs, so -url-ttl leaves them unchanged
+	signedResult, err := exportAction(catalogUri, caryatid.CatalogQueryParams{}, "json", "", time.Hour)
+	if err != nil {
+		t.Fatalf("exportAction() failed with a URL TTL: %v\n", err)
+	}
+	if signedResult != result {
+		t.Fatalf("Expected a URL TTL not to change the export from a local file backend, but got:\n%v", signedResult)
+	}
+
+	if result, err = exportAction(catalogUri, caryatid.CatalogQueryParams{Provider: "Strong"}, "html", "", 0); err != nil {
+		t.Fatalf("exportAction() failed: %v\n", err)
+	}
+	if rows := strings.Count(result, `<tr class="provider">`); rows != 2 || strings.Contains(result, "FeebleFungus") {
+		t.Fatalf("Expected a row for each StrongSapling box in the HTML export, but got:\n%v", result)
+	}
+	templatePath := path.Join(integrationTestDir, "TestExportAction.html.tmpl")
+	if err = ioutil.WriteFile(templatePath, []byte(`{{.Name}}:{{range .Versions}} {{.Version}}{{end}}`), 0666); err != nil {
+		t.Fatalf("Error writing template: %v\n", err)
+	}
+	if result, err = exportAction(catalogUri, caryatid.CatalogQueryParams{}, "html", templatePath, 0); err != nil {
+		t.Fatalf("exportAction() failed with a template: %v\n", err)
+	}
+	if expected := fmt.Sprintf("%v: 1.0.0 2.0.0", boxName); result != expected {
+		t.Fatalf("Expected the export rendered with a template to be '%v', but got:\n%v", expected, result)
+	}
+}
+
+func TestServeAction(t *testing.T) {
+	var (
+		err error
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestServeAction.box")
+		boxProvider = "TestServeActionProvider"
+		boxName     = "TestServeActionBox"
+		boxDesc     = "TestServeActionBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: "1.0.0", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+	boxContents, err := ioutil.ReadFile(boxPath)
+	if err != nil {
+		t.Fatalf("Error reading test box file: %v\n", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v\n", err)
+	}
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serveAction(catalogUri, listener, stop, time.Hour, "", "")
+	}()
+
+	baseUrl := fmt.Sprintf("http://%v", listener.Addr())
+	response, err := http.Get(fmt.Sprintf("%v/%v.json", baseUrl, boxName))
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	var catalog caryatid.Catalog
+	err = json.NewDecoder(response.Body).Decode(&catalog)
+	response.Body.Close()
+	if err != nil {
+		t.Fatalf("Error decoding served catalog: %v\n", err)
+	}
+	if len(catalog.Versions) != 1 || len(catalog.Versions[0].Providers) != 1 {
+		t.Fatalf("Unexpected served catalog:\n%v", catalog.DisplayString())
+	}
+	boxUrl := catalog.Versions[0].Providers[0].Url
+	if !strings.HasPrefix(boxUrl, baseUrl+"/") {
+		t.Fatalf("Expected the provider URL to point at the server, but got '%v'\n", boxUrl)
+	}
+
+	response, err = http.Get(boxUrl)
+	if err != nil {
+		t.Fatalf("Error getting box file: %v\n", err)
+	}
+	servedContents, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil || string(servedContents) != string(boxContents) {
+		t.Fatalf("Expected the served box file to match the original, but got %v bytes and error %v\n", len(servedContents), err)
+	}
+
+	stop <- os.Interrupt
+	select {
+	case err = <-served:
+		if err != nil {
+			t.Fatalf("serveAction() returned an error after being stopped: %v\n", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("serveAction() did not stop after an interrupt\n")
+	}
+}
+
+func TestValidateAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestValidateAction.box")
+		boxProvider = "TestValidateActionProvider"
+		boxName     = "TestValidateActionBox"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+
+	os.Remove(catalogPath)
+	if result, err = validateAction(catalogUri, false); !errors.Is(err, caryatid.ErrCatalogNotFound) {
+		t.Fatalf("Expected validateAction() on a missing catalog to fail with ErrCatalogNotFound, but got: %v\n%v", err, result)
+	}
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: "A test box", Version: "1.0.0", ChecksumType: "sha256"}); err != nil {
+		t.Fatalf("addAction() failed with error: %v\n", err)
+	}
+	if result, err = validateAction(catalogUri, false); err != nil {
+		t.Fatalf("validateAction() failed on a catalog caryatid wrote: %v\n%v", err, result)
+	}
+	if result, err = validateAction(catalogUri, true); err != nil {
+		t.Fatalf("validateAction() with -schema failed on a catalog caryatid wrote: %v\n%v", err, result)
+	}
+
+	brokenCatalog := `{"name":"TestValidateActionBox","versions":[{"version":"1.0.0","providers":[{"name":"virtualbox","url":"https://example.com/box","checksumType":"sha256","checksum":"0xB00B1E5"}]}]}`
+	if err = ioutil.WriteFile(catalogPath, []byte(brokenCatalog), 0644); err != nil {
+		t.Fatalf("Error writing broken catalog: %v\n", err)
+	}
+	if result, err = validateAction(catalogUri, false); !errors.Is(err, errVerificationFailed) {
+		t.Fatalf("Expected validateAction() on a broken catalog to fail with errVerificationFailed, but got: %v\n%v", err, result)
+	}
+	if !strings.Contains(result, "VIOLATION $.versions[0].providers[0].checksum_type") {
+		t.Fatalf("Unexpected validateAction() summary:\n%v", result)
+	}
+	if result, err = validateAction(catalogUri, true); !errors.Is(err, errVerificationFailed) {
+		t.Fatalf("Expected validateAction() with -schema on a broken catalog to fail with errVerificationFailed, but got: %v\n%v", err, result)
+	}
+	if !strings.Contains(result, "VIOLATION $.versions[0].providers[0].checksumType: Unknown field") {
+		t.Fatalf("Unexpected validateAction() summary with -schema:\n%v", result)
+	}
+}
+
+func TestRollbackAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestRollbackAction.box")
+		boxProvider = "TestRollbackActionProvider"
+		boxName     = "TestRollbackActionBox"
+		boxDesc     = "TestRollbackActionBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+	defer func(oldBackup bool, oldCount int) { backupFlag, backupCountFlag = oldBackup, oldCount }(backupFlag, backupCountFlag)
+	backupFlag, backupCountFlag = true, 5
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+		if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+			t.Fatalf("addAction() failed with error: %v\n", err)
+		}
+	}
+	if _, err = pruneAction(catalogUri, caryatid.CatalogQueryParams{}, 1, false, false); err != nil {
+		t.Fatalf("pruneAction() failed: %v\n", err)
+	}
+
+	if result, err = rollbackAction(catalogUri, "", true); err != nil {
+		t.Fatalf("rollbackAction() failed during a dry run: %v\n", err)
+	}
+	if !strings.HasPrefix(result, "WOULD RESTORE "+catalogUri+".bak.") {
+		t.Fatalf("Unexpected dry run result:\n%v", result)
+	}
+	if catalog, _ := queryAction(catalogUri, caryatid.CatalogQueryParams{}); len(catalog.Versions) != 1 {
+		t.Fatalf("A dry run restored the catalog:\n%v\n", catalog.DisplayString())
+	}
+
+	if result, err = rollbackAction(catalogUri, "", false); err != nil {
+		t.Fatalf("rollbackAction() failed: %v\n", err)
+	}
+	if !strings.HasPrefix(result, "RESTORED ") || !strings.Contains(result, "MISSING 1.0.0 "+boxProvider) || !strings.Contains(result, "MISSING 1.1.0 "+boxProvider) || strings.Contains(result, "MISSING 1.2.0") {
+		t.Fatalf("Expected the rollback to list the pruned box files as missing, but got:\n%v", result)
+	}
+	catalog, err := queryAction(catalogUri, caryatid.CatalogQueryParams{})
+	if err != nil {
+		t.Fatalf("queryAction() failed: %v\n", err)
+	}
+	if len(catalog.Versions) != 3 {
+		t.Fatalf("Expected the rollback to restore all 3 versions, but got:\n%v\n", catalog.DisplayString())
+	}
+}
+
+func TestPruneToSizeAction(t *testing.T) {
+	var (
+		err    error
+		result string
+
+		boxPath     = path.Join(integrationTestDir, "incoming-TestPruneToSizeAction.box")
+		boxProvider = "TestPruneToSizeActionProvider"
+		boxName     = "TestPruneToSizeActionBox"
+		boxDesc     = "TestPruneToSizeActionBox is a test box"
+		catalogPath = path.Join(integrationTestDir, fmt.Sprintf("%v.json", boxName))
+		catalogUri  = fmt.Sprintf("file://%v", catalogPath)
+	)
+
+	if err = caryatid.CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "1.10.0", "1.9.0"} {
+		if err = addAction([]string{boxPath}, catalogUri, addParams{Name: boxName, Description: boxDesc, Version: version, ChecksumType: "sha256"}); err != nil {
+			t.Fatalf("addAction() failed with error: %v\n", err)
+		}
+	}
+	boxInfo, err := os.Stat(boxPath)
+	if err != nil {
+		t.Fatalf("Error getting the size of the test box: %v\n", err)
+	}
+	boxSize := boxInfo.Size()
+
+	// Forget the sizes, as in a catalog written before caryatid recorded them
+	manager, err := getManager(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting a BackendManager: %v\n", err)
+	}
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	for vIdx := range catalog.Versions {
+		catalog.Versions[vIdx].Providers[0].Size = 0
+	}
+	if err = manager.SaveCatalog(catalog); err != nil {
+		t.Fatalf("Error saving catalog: %v\n", err)
+	}
+	if _, err = pruneToSizeAction(catalogUri, caryatid.CatalogQueryParams{}, 2*boxSize, false, false); !errors.Is(err, caryatid.ErrUnknownSize) || !strings.Contains(err.Error(), "recompute-checksums") {
+		t.Fatalf("Expected pruneToSizeAction() to say to recompute the sizes of the boxes, but got: %v\n", err)
+	}
+	if result, err = recomputeChecksumsAction(catalogUri, caryatid.CatalogQueryParams{}, "sha256", false); err != nil {
+		t.Fatalf("recomputeChecksumsAction() failed: %v\n", err)
+	}
+	if !strings.Contains(result, fmt.Sprintf("size %v bytes", boxSize)) {
+		t.Fatalf("Expected recomputeChecksumsAction() to record the sizes of the boxes, but got:\n%v", result)
+	}
+
+	if result, err = pruneToSizeAction(catalogUri, caryatid.CatalogQueryParams{}, 2*boxSize, false, true); err != nil {
+		t.Fatalf("pruneToSizeAction() failed during a dry run: %v\n", err)
+	}
+	expectedTotal := fmt.Sprintf("Total size would be %v bytes", 2*boxSize)
+	if !strings.Contains(result, "WOULD PRUNE 1.0.0") || strings.Contains(result, "1.9.0") || !strings.Contains(result, expectedTotal) {
+		t.Fatalf("Unexpected dry run summary:\n%v", result)
+	}
+
+	if result, err = pruneToSizeAction(catalogUri, caryatid.CatalogQueryParams{}, 2*boxSize, false, false); err != nil {
+		t.Fatalf("pruneToSizeAction() failed: %v\n", err)
+	}
+	if !strings.Contains(result, "PRUNED 1.0.0") || !strings.Contains(result, fmt.Sprintf("Total size is now %v bytes", 2*boxSize)) {
+		t.Fatalf("Unexpected summary:\n%v", result)
+	}
+	if _, err = os.Stat(path.Join(integrationTestDir, boxName, fmt.Sprintf("%v_1.0.0_%v.box", boxName, boxProvider))); !os.IsNotExist(err) {
+		t.Fatalf("Expected the pruned box file to be deleted, but got: %v\n", err)
+	}
+	if catalog, err = queryAction(catalogUri, caryatid.CatalogQueryParams{}); err != nil {
+		t.Fatalf("queryAction() failed: %v\n", err)
+	}
+	if len(catalog.Versions) != 2 {
+		t.Fatalf("Expected 2 versions to be kept, but got:\n%v\n", catalog.DisplayString())
+	}
+}
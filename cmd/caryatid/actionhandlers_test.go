@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -46,3 +48,35 @@ func TestGetManager(t *testing.T) {
 		}
 	}
 }
+
+func TestGetManagerExpandsPaths(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skipf("No home directory to expand to: %v\n", err)
+	}
+	defer os.Unsetenv("CARYATID_TEST_BOXES")
+	os.Setenv("CARYATID_TEST_BOXES", "/srv/boxes")
+
+	type TestCase struct {
+		Input    string
+		Expected string
+	}
+	testCases := []TestCase{
+		TestCase{"~/boxes/testbox.json", "file://" + filepath.Join(home, "boxes", "testbox.json")},
+		TestCase{"$CARYATID_TEST_BOXES/testbox.json", "file:///srv/boxes/testbox.json"},
+		TestCase{"file://~/boxes/testbox.json", "file://" + home + "/boxes/testbox.json"},
+		TestCase{"file://${CARYATID_TEST_BOXES}/testbox.json", "file:///srv/boxes/testbox.json"},
+	}
+	for _, tc := range testCases {
+		manager, err := getManager(tc.Input)
+		if err != nil {
+			t.Fatalf("getManager(%v) returned an error: %v\n", tc.Input, err)
+		}
+		if manager.CatalogUri != tc.Expected {
+			t.Fatalf("Expected getManager(%v) to use the catalog '%v', but it used '%v'\n", tc.Input, tc.Expected, manager.CatalogUri)
+		}
+	}
+	if _, err = getManager("file://$CARYATID_TEST_UNSET/testbox.json"); err == nil {
+		t.Fatalf("Expected getManager() to fail for a catalog with an unset environment variable\n")
+	}
+}
@@ -7,124 +7,1480 @@ A command line application for managing Vagrant catalogs
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/mrled/caryatid/internal/util"
 	"github.com/mrled/caryatid/pkg/caryatid"
 )
 
 var (
-	cFlag = flag.NewFlagSet("Caryatid", flag.PanicOnError)
-
-	actionFlag      string
-	catalogFlag     string
-	boxFlag         string
-	versionFlag     string
-	descriptionFlag string
-	providerFlag    string
-	nameFlag        string
+	configFlag        string
+	catalogFlag       string
+	boxFlag           stringsFlagValue
+	versionFlag       string
+	exactFlag         bool
+	descriptionFlag   string
+	providerFlag      string
+	archFlag          string
+	nameFlag          string
+	retriesFlag       int
+	quietFlag         bool
+	verboseFlag       bool
+	readOnlyFlag      bool
+	logFormatFlag     string
+	jsonStyleFlag     string
+	templateFlag      string
+	checksumFlag      string
+	dryRunFlag        bool
+	keepFlag          int
+	sourceFlag        string
+	overwriteFlag     bool
+	copyBoxesFlag     bool
+	moveFlag          bool
+	newNameFlag       string
+	forceFlag         bool
+	deepFlag          bool
+	schemaFlag        bool
+	formatFlag        string
+	addrFlag          string
+	compressFlag      bool
+	urlTtlFlag        time.Duration
+	lockTimeoutFlag   time.Duration
+	timeoutFlag       time.Duration
+	concurrencyFlag   int
+	noColorFlag       bool
+	auditLogFlag      string
+	backupFlag        bool
+	sortFlag          string
+	urlPrefixFlag     string
+	relativeUrlsFlag  bool
+	backupCountFlag   int
+	authUserFlag      string
+	authPassFlag      string
+	otherFlag         string
+	rootFlag          string
+	dirFlag           string
+	checksumCacheFlag string
+	noCacheFlag       bool
+	ignoreUrlsFlag    bool
+	outputFlag        string
+	countFlag         bool
+	limitFlag         int
+	offsetFlag        int
+
+	providerMatchFlag           string
+	includePrereleaseFlag       prereleaseFlagValue
+	allowNonstandardVersionFlag bool
+	normalizeVersionsFlag       bool
+	prunePrereleasesFlag        bool
+	filenameTemplateFlag        string
+	auditLogRequiredFlag        bool
+	webhookFlag                 string
+	webhookTimeoutFlag          time.Duration
+	updateIndexFlag             string
+	labelSelectorFlag           string
+	latestAliasFlag             bool
+	removeLatestAliasFlag       bool
+	verifyAfterCopyFlag         bool
+	fileModeFlag                string
+	dirModeFlag                 string
+	expectedChecksumFlag        string
+	expectedChecksumTypeFlag    string
+	versionDescriptionFlag      string
+	displayNameFlag             string
+	tagFlag                     string
+	addTagFlag                  stringsFlagValue
+	removeTagFlag               stringsFlagValue
+	rollbackToFlag              string
+
+	labelFlag = labelFlagValue{}
+
+	maxTotalSizeFlag sizeFlagValue = -1
+
+	progressThresholdFlag int64
+	partSizeFlag          int64
+)
+
+// prereleaseFlagValue is the caryatid.PrereleaseMode chosen by the boolean -include-prerelease flag
+// Prereleases are included unless it is false, but '-version latest' only returns a prerelease if it was passed explicitly,
+// so passing '-include-prerelease' is not the same as leaving it out
+type prereleaseFlagValue caryatid.PrereleaseMode
+
+func (mode *prereleaseFlagValue) String() string {
+	return strconv.FormatBool(mode == nil || caryatid.PrereleaseMode(*mode) != caryatid.PrereleaseExclude)
+}
+
+func (mode *prereleaseFlagValue) Set(value string) error {
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	if include {
+		*mode = prereleaseFlagValue(caryatid.PrereleaseInclude)
+	} else {
+		*mode = prereleaseFlagValue(caryatid.PrereleaseExclude)
+	}
+	return nil
+}
+
+// IsBoolFlag lets the flag be passed as '-include-prerelease', without a value
+func (mode *prereleaseFlagValue) IsBoolFlag() bool {
+	return true
+}
+
+// flagDefinitions adds a flag, by name, to the FlagSet of a subcommand
+// A flag means the same thing in every subcommand that accepts it
+var flagDefinitions = map[string]func(fs *flag.FlagSet){
+	"config": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&configFlag, "config", "",
+			fmt.Sprintf("Path to a JSON config file that sets defaults for other flags, like {\"catalog\": \"file:///srv/vagrant/testbox.json\", \"quiet\": true}. Flags passed on the command line, and environment variables like CARYATID_CATALOG, override the config file. Defaults to '%v', which is ignored if it does not exist.", defaultConfigPath()))
+	},
+	"catalog": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&catalogFlag, "catalog", "",
+			"URI for the Vagrant Catalog to operate on. Defaults to the CARYATID_CATALOG environment variable.")
+	},
+	"box": func(fs *flag.FlagSet) {
+		fs.Var(
+			&boxFlag, "box",
+			"Local path to a box file, or - to read it from stdin. When adding boxes, this may be passed more than once to add a box for each provider to the same version at once; either all of them are added, or none are.")
+	},
+	"version": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&versionFlag, "version", "",
+			"A version specifier. When querying, deleting, or verifying boxes, this restricts the query to only the versions matched, and its value may include specifiers such as less-than signs, like '<=1.2.3', or pessimistic constraints, like '~> 1.2' for any 1.x version at or above 1.2. Separate several constraints with commas to match only versions that satisfy all of them, like '>=1.0.0, <2.0.0'. The special value 'latest' (or 'newest') matches only the highest version that has a matching provider. When adding a box, the version must be an exact semantic version like '1.2.3' or '1.2.3-PRE', and such specifiers are not supported.")
+	},
+	"exact": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&exactFlag, "exact", false,
+			"Match only the version whose version string is exactly -version, like '1.0.0', rather than treating -version as a version specifier; '1.0.0' then does not match '1.0.0-PRE'. A leading 'v' is ignored, so 'v1.0.0' matches '1.0.0'. Specifiers like '>=' or commas are an error.")
+	},
+	"description": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&descriptionFlag, "description", "",
+			"A description for a box in the Vagrant catalog")
+	},
+	"display-name": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&displayNameFlag, "display-name", "",
+			"A name for people to read, like 'Test Box', shown by 'caryatid show' alongside -name. Vagrant still uses -name to find the box. If empty, an existing catalog keeps its display name")
+	},
+	"version-description": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&versionDescriptionFlag, "version-description", "",
+			"A description for this -version only, shown instead of the -description for it. If empty, an existing version keeps its description")
+	},
+	"provider": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&providerFlag, "provider", "",
+			"The name of a provider. When querying, deleting, verifying, or recomputing checksums of boxes, this restricts the query to only the providers matched, as determined by -provider-match. When creating a test box, this is the literal provider name to use.")
+	},
+	"provider-match": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&providerMatchFlag, "provider-match", string(caryatid.DefaultProviderMatchMode),
+			"How -provider is matched against provider names. 'regex' matches an unanchored regular expression, so 'iso' matches 'virtualbox-iso'. 'glob' must match the whole name, and an asterisk matches any sequence of characters, as in '*-iso'. 'substring' matches if -provider appears literally anywhere in the name.")
+	},
+	"architecture": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&archFlag, "architecture", "",
+			"The CPU architecture of a box, like 'amd64' or 'arm64'. When adding a box, this overrides any architecture in the box's own metadata; if neither is set, the catalog records no architecture, as with older versions of Vagrant. When querying, deleting, verifying, or recomputing checksums of boxes, this restricts the query to only providers with exactly this architecture.")
+	},
+	"label": func(fs *flag.FlagSet) {
+		fs.Var(
+			labelFlag, "label",
+			"A label to record for the box, like 'build=1234', which Vagrant ignores. May be passed more than once.")
+	},
+	"label-selector": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&labelSelectorFlag, "label-selector", "",
+			"Labels like 'env=prod,team=infra'. When querying, deleting, verifying, or recomputing checksums of boxes, this restricts the query to only providers with all of these labels.")
+	},
+	"tag": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&tagFlag, "tag", "",
+			"A version tag, like 'stable'. When querying, deleting, verifying, or recomputing checksums of boxes, this restricts the query to only versions with this tag. It applies before '-version latest', so '-tag stable -version latest' matches the latest stable version.")
+	},
+	"add-tag": func(fs *flag.FlagSet) {
+		fs.Var(
+			&addTagFlag, "add-tag",
+			"A tag to add to each matched version, like 'stable'. Tags are made of letters, numbers, '.', '_', and '-'. May be passed more than once.")
+	},
+	"remove-tag": func(fs *flag.FlagSet) {
+		fs.Var(
+			&removeTagFlag, "remove-tag",
+			"A tag to remove from each matched version. May be passed more than once.")
+	},
+	"name": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&nameFlag, "name", "",
+			"The name of the box tracked in the Vagrant catalog. When querying, this is matched against the name of the catalog, and may include asterisks for globbing; since each catalog holds a single box, the result is empty if the name does not match. When adding a box, globbing is not supported and an asterisk will be interpreted literally.")
+	},
+	"include-prerelease": func(fs *flag.FlagSet) {
+		includePrereleaseFlag = prereleaseFlagValue(caryatid.PrereleaseDefault)
+		fs.Var(
+			&includePrereleaseFlag, "include-prerelease",
+			"Include prerelease versions like '1.2.3-BETA'. Pass '-include-prerelease=false' to omit them. '-version latest' returns the latest non-prerelease version unless this flag is passed explicitly.")
+	},
+	"allow-nonstandard-version": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&allowNonstandardVersionFlag, "allow-nonstandard-version", false,
+			"Accept a version that is not a strict semantic version, like '1.2' or '1.2.3.4'. Versions must still be made of numeric components separated by dots.")
+	},
+	"normalize-versions": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&normalizeVersionsFlag, "normalize-versions", true,
+			"Strip a leading 'v' from the -version, like in 'v1.2.3', so that it is stored as '1.2.3'. Pass -normalize-versions=false to store such versions as they are; since they are not semantic versions, this also requires -allow-nonstandard-version. Either way, versions already in the catalog with a leading 'v' are treated the same as their plain form when querying and sorting.")
+	},
+	"retries": func(fs *flag.FlagSet) {
+		fs.IntVar(
+			&retriesFlag, "retries", 0,
+			"Retry failed backend operations up to this many times, with exponential backoff. Errors that retrying cannot fix, like authentication failures, are not retried.")
+	},
+	"checksum-cache": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&checksumCacheFlag, "checksum-cache", defaultChecksumCachePath(),
+			"A local file to cache the checksums of box files in, so that boxes that have not changed since they were last hashed are not hashed again. A box file has changed if its size or modification time has, or on S3, its ETag. A corrupt cache is ignored.")
+	},
+	"no-cache": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&noCacheFlag, "no-cache", false,
+			"Hash every box file, rather than using checksums from the -checksum-cache. The checksums computed are still saved to the cache.")
+	},
+	"concurrency": func(fs *flag.FlagSet) {
+		fs.IntVar(
+			&concurrencyFlag, "concurrency", runtime.NumCPU(),
+			"How many box files to check at once. Results are still reported in catalog order. Defaults to the number of CPUs.")
+	},
+	"timeout": func(fs *flag.FlagSet) {
+		fs.DurationVar(
+			&timeoutFlag, "timeout", 0,
+			"Give up on any single operation against a remote backend, like S3, that takes longer than this, like '30s'. This includes uploading a box, so allow enough time for the largest one. With -retries, each attempt gets its own timeout. The local file backend ignores this. Defaults to no timeout.")
+	},
+	"checksum-type": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&checksumFlag, "checksum-type", caryatid.DefaultChecksumType,
+			fmt.Sprintf("The type of checksum to record. With 'add', this may be a comma-separated list, like 'sha256,md5', to also record the others alongside the first, which is the one Vagrant reads. Defaults to the CARYATID_CHECKSUM_TYPE environment variable, if set. One of: %v", strings.Join(caryatid.ChecksumTypes(), ", ")))
+	},
+	"expected-checksum": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&expectedChecksumFlag, "expected-checksum", "",
+			"The checksum that the -box file should have, like one published alongside it. If the file's checksum is different, it was corrupted, and nothing is added. This is the checksum of the file as it is passed, before any -compress.")
+	},
+	"expected-checksum-type": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&expectedChecksumTypeFlag, "expected-checksum-type", "",
+			fmt.Sprintf("The type of the -expected-checksum. Defaults to the first -checksum-type. One of: %v", strings.Join(caryatid.ChecksumTypes(), ", ")))
+	},
+	"dry-run": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&dryRunFlag, "dry-run", false,
+			"Report what would change, including each box file that would be deleted or moved, without modifying the catalog or any box files")
+	},
+	"to": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&rollbackToFlag, "to", "",
+			"The timestamp of the backup to roll back to, like '20240101T120000', as in the name of the backup 'catalog.json.bak.20240101T120000'. Defaults to the most recent backup.")
+	},
+	"new-name": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&newNameFlag, "new-name", "",
+			"The new name of the box. Box files are moved to match, but the catalog itself stays at the -catalog URI.")
+	},
+	"force": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&forceFlag, "force", false,
+			"When collecting garbage, actually delete box files that the catalog does not refer to; without this, or with -dry-run, they are only listed. When adding a box, this is the same as -overwrite.")
+	},
+	"deep": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&deepFlag, "deep", false,
+			"Also check that each box file exists in the backend. Otherwise, only the catalog itself is checked. Unlike 'verify', this does not check checksums.")
+	},
+	"schema": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&schemaFlag, "schema", false,
+			"Validate the catalog against caryatid's JSON Schema for catalogs instead, which also reports fields of the wrong type and fields that neither Vagrant nor caryatid know about, like misspelled ones.")
+	},
+	"format": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&formatFlag, "format", caryatid.DefaultExportFormat,
+			fmt.Sprintf("The format to write. 'csv' writes one row per provider, with columns for the name, version, provider, architecture, checksum type, checksum, and URL. 'html' writes a page with a download link for each provider. One of: %v", strings.Join(caryatid.ExportFormats(), ", ")))
+	},
+	"template": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&templateFlag, "template", "",
+			"Path to a Go html/template file to render the catalog with instead of the built-in page, for '-format html'. The template is executed with the catalog, and can call 'formatSize .Size' and 'boxUrl .Url' for each provider.")
+	},
+	"count": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&countFlag, "count", false,
+			"Print only the number of matching providers, and exit with status 1 if there are none")
+	},
+	"limit": func(fs *flag.FlagSet) {
+		fs.IntVar(
+			&limitFlag, "limit", 0,
+			"Return at most this many matching versions, in the order of -sort. 0 means no limit.")
+	},
+	"offset": func(fs *flag.FlagSet) {
+		fs.IntVar(
+			&offsetFlag, "offset", 0,
+			"Skip this many matching versions, in the order of -sort, before returning any")
+	},
+	"output": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&outputFlag, "output", "text",
+			"How to write the result. 'text' is for people to read; 'json' (stats only) is for other programs; 'url' (query only) writes just the URL of each matching box, one per line; 'grouped' (query only) lists the matching versions of each provider under a header with its name; 'table' (query and show only) writes each box as a row of a table with aligned columns")
+	},
+	"url-prefix": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&urlPrefixFlag, "url-prefix", "",
+			"Record box URLs in the catalog as 'PREFIX/<name>/<name>_<version>_<provider>.box', like 'https://cdn.example.com/boxes', instead of the location in the backend. Box files are still stored in the backend next to the catalog; this only changes the URLs that Vagrant downloads them from. When printing a Vagrantfile, the catalog is assumed to be served under this prefix too, as 'PREFIX/<catalog file name>'.")
+	},
+	"relative-urls": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&relativeUrlsFlag, "relative-urls", false,
+			"Record box URLs in the catalog relative to the catalog, like '<name>/<name>_<version>_<provider>.box', so that the directory holding the catalog and its boxes can be moved. Vagrant cannot download boxes from relative URLs, so only use this for catalogs served with 'caryatid serve'.")
+	},
+	"filename-template": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&filenameTemplateFlag, "filename-template", "",
+			fmt.Sprintf("A Go text/template for the names of stored box files, which can use {{.Name}}, {{.Version}}, {{.Provider}}, and {{.Architecture}}. Names must be different for different versions, providers, and architectures. With 'import', this is also how the names of the box files to import are parsed. Defaults to '%v'.", caryatid.DefaultFilenameTemplate))
+	},
+	"sort": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&sortFlag, "sort", string(caryatid.DefaultSortOrder),
+			fmt.Sprintf("How to order the results. 'version-asc' and 'version-desc' sort versions semantically; 'date-asc' and 'date-desc' sort versions, and the providers of each version, by when they were added to the catalog, with boxes added before caryatid recorded that oldest. 'version' and 'released' are older names for 'version-asc' and 'date-asc'. One of: %v", caryatid.SortOrders()))
+	},
+	"addr": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&addrFlag, "addr", ":8099",
+			"The address to listen on, like ':8099' for port 8099 on every interface, or 'localhost:8099' for only local connections")
+	},
+	"lock-timeout": func(fs *flag.FlagSet) {
+		fs.DurationVar(
+			&lockTimeoutFlag, "lock-timeout", caryatid.DefaultLockTimeout,
+			"How long to wait for another process that is changing the catalog to finish before giving up, like '2m'. Only backends that support locking, like the local file backend, wait; the S3 backend instead refuses to save a catalog that another process changed after it was read.")
+	},
+	"audit-log": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&auditLogFlag, "audit-log", "",
+			"Append a line of JSON to the local file at this path for each box that is added, deleted, pruned, renamed, or tagged, recording when, by whom, and the box's checksum.")
+	},
+	"audit-log-required": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&auditLogRequiredFlag, "audit-log-required", false,
+			"Fail if the -audit-log cannot be written. By default, a failure to write it is only logged, after the catalog has already been changed.")
+	},
+	"webhook": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&webhookFlag, "webhook", "",
+			"POST a JSON object to this http:// or https:// URL for each box that is added, deleted, pruned, renamed, or tagged, with the same fields as a line of the -audit-log. A failure to deliver it is only logged, after the catalog has already been changed.")
+	},
+	"webhook-timeout": func(fs *flag.FlagSet) {
+		fs.DurationVar(
+			&webhookTimeoutFlag, "webhook-timeout", caryatid.DefaultWebhookTimeout,
+			"How long to wait for the -webhook to respond to each request, like '5s'")
+	},
+	"update-index": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&updateIndexFlag, "update-index", "",
+			"After changing the catalog, rebuild the index of the catalogs under this directory, like 'file:///srv/vagrant', as 'caryatid index -root' does. A failure to rebuild it is only logged, after the catalog has already been changed.")
+	},
+	"backup": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&backupFlag, "backup", true,
+			"Before changing the catalog, copy it to a timestamped file next to it, like 'catalog.json.bak.20240101T120000'. Pass '-backup=false' to turn this off.")
+	},
+	"backup-count": func(fs *flag.FlagSet) {
+		fs.IntVar(
+			&backupCountFlag, "backup-count", caryatid.DefaultCatalogBackups,
+			"How many backups of the catalog to keep when -backup is set. Older backups are deleted.")
+	},
+	"url-ttl": func(fs *flag.FlagSet) {
+		fs.DurationVar(
+			&urlTtlFlag, "url-ttl", 0,
+			"Replace box URLs with signed URLs that expire after this long, like '1h', so that clients can download boxes from a private backend. Only backends that can sign URLs, like S3, are affected; URLs for other backends are unchanged. When serving, signed URLs point clients directly at the backend rather than at the server.")
+	},
+	"auth-user": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&authUserFlag, "auth-user", "",
+			"Require HTTP basic authentication with this user name and -auth-pass for every request. Without this, anyone who can connect can download the catalog and its boxes.")
+	},
+	"auth-pass": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&authPassFlag, "auth-pass", "",
+			"The password for -auth-user. Defaults to the CARYATID_AUTH_PASS environment variable, which keeps the password out of the process list.")
+	},
+	"compress": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&compressFlag, "compress", false,
+			"Compress the box file with gzip before storing it, unless it is already compressed. The catalog records the checksum of the compressed file, which Vagrant can still verify.")
+	},
+	"dir": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&dirFlag, "dir", "",
+			"A local directory of box files to import, named as -filename-template names them, like 'testbox_1.0.0_virtualbox.box'. Files with other names are skipped with a warning, and subdirectories are not searched.")
+	},
+	"root": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&rootFlag, "root", "",
+			"The URI of a directory to search for catalogs at any depth, like 'file:///srv/vagrant' or 's3://bucket/boxes/'. Files ending in .json that are not catalogs are skipped with a warning.")
+	},
+	"source": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&sourceFlag, "source", "",
+			"The URI of the catalog to merge or copy boxes into the -catalog from")
+	},
+	"move": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&moveFlag, "move", false,
+			"Delete the boxes from the -source catalog, along with their box files, once they have been copied to the -catalog.")
+	},
+	"copy-boxes": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&copyBoxesFlag, "copy-boxes", false,
+			"Copy box files from the -source backend to the -catalog backend, and point the merged catalog at the copies. Otherwise, the merged catalog refers to the box files in the -source backend.")
+	},
+	"other": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&otherFlag, "other", "",
+			"The URI of the catalog to compare the -catalog to")
+	},
+	"ignore-urls": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&ignoreUrlsFlag, "ignore-urls", false,
+			"Do not report providers whose URLs differ, which is useful when comparing a catalog to a copy in another location")
+	},
+	"overwrite": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&overwriteFlag, "overwrite", false,
+			"Replace boxes in the -catalog with boxes of the same version, provider, and architecture, along with their box files. When adding or copying a box, one that is already in the catalog is otherwise skipped if its checksum is the same, and an error if not. When merging, the boxes already in the -catalog are otherwise kept.")
+	},
+	"latest-alias": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&latestAliasFlag, "latest-alias", false,
+			"Keep an alias next to the box files, like '<name>/<name>_latest_<provider>.box', that always points to the box file of the newest version of each provider, for clients that cannot read the catalog. The local file backend makes a symbolic link; other backends make a copy. When deleting or pruning boxes, this updates the aliases of the providers that were deleted.")
+	},
+	"verify-after-copy": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&verifyAfterCopyFlag, "verify-after-copy", false,
+			"Read each box file back from the backend after copying it there, and fail without changing the catalog if its checksum does not match, as when it was corrupted on the way. This costs a full read of each box file, except on S3 with an md5 -checksum-type, where the MD5 that S3 reports is used instead when it can be.")
+	},
+	"remove-latest-alias": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&removeLatestAliasFlag, "remove-latest-alias", false,
+			"Also delete the aliases made with -latest-alias, which are otherwise never collected.")
+	},
+	"keep": func(fs *flag.FlagSet) {
+		fs.IntVar(
+			&keepFlag, "keep", -1,
+			"The number of the newest versions to keep. Older versions are deleted from the catalog, and their box files are deleted from the backend.")
+	},
+	"prune-prereleases": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&prunePrereleasesFlag, "prune-prereleases", false,
+			"Delete all prerelease versions like '1.2.3-BETA', regardless of -keep or -max-total-size. Prereleases do not count towards either.")
+	},
+	"max-total-size": func(fs *flag.FlagSet) {
+		fs.Var(
+			&maxTotalSizeFlag, "max-total-size",
+			"Instead of -keep, keep the newest versions whose box files fit in this many bytes together, like '50GB' or '1.5TiB', and delete the older versions. GB and TB are powers of 1000; GiB and TiB are powers of 1024. Requires the catalog to record the size of every box; run 'recompute-checksums' to record the sizes of boxes added by older versions of caryatid.")
+	},
+	"quiet": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&quietFlag, "quiet", false,
+			"Only log errors, and do not show progress while copying box files. Results, like the output of 'query', are still printed.")
+	},
+	"no-color": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&noColorFlag, "no-color", false,
+			"Do not colorize output. Output is only colorized when it is written to a terminal, and never when the NO_COLOR environment variable is set.")
+	},
+	"verbose": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&verboseFlag, "verbose", false,
+			"Log debugging details, like which backend is used for a URI and operations on individual files")
+	},
+	"log-format": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&logFormatFlag, "log-format", "text",
+			"How to write log messages to stderr. 'text' is for people to read; 'json' writes each message as a line of JSON with its level, time, and fields like the catalog URI, for log aggregation. Defaults to the CARYATID_LOG_FORMAT environment variable.")
+	},
+	"json-style": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&jsonStyleFlag, "json-style", string(caryatid.DefaultJSONStyle),
+			fmt.Sprintf("How to format the catalog when saving it. 'pretty' is indented for people to read; 'compact' is all on one line, which is smaller and makes for smaller diffs when catalogs are kept in version control. Catalogs in either style can be read. One of: %v", caryatid.JSONStyles()))
+	},
+	"file-mode": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&fileModeFlag, "file-mode", "",
+			"For the file backend, the octal permissions to give the catalog and box files written, like 0640, regardless of the umask. By default, new files get 0666 less the umask, and replaced files keep their permissions.")
+	},
+	"dir-mode": func(fs *flag.FlagSet) {
+		fs.StringVar(
+			&dirModeFlag, "dir-mode", "",
+			"For the file backend, the octal permissions to give the directories created for the catalog and box files, like 0750, regardless of the umask. By default, they get 0777 less the umask.")
+	},
+	"read-only": func(fs *flag.FlagSet) {
+		fs.BoolVar(
+			&readOnlyFlag, "read-only", false,
+			"Refuse to change the catalog or any box files, even with subcommands that would, like 'delete'. Subcommands that only read, like 'show', 'query', and 'verify', work as usual, and so do dry runs. Defaults to the CARYATID_READ_ONLY environment variable.")
+	},
+	"progress-threshold": func(fs *flag.FlagSet) {
+		fs.Int64Var(
+			&progressThresholdFlag, "progress-threshold", 64*1024*1024,
+			"Only show progress when copying box files of at least this many bytes")
+	},
+	"part-size": func(fs *flag.FlagSet) {
+		fs.Int64Var(
+			&partSizeFlag, "part-size", caryatid.DefaultUploadPartSize,
+			"Upload box files larger than this many bytes to backends that support it, like S3, in parts of this size. Each part is retried on its own, so a failure near the end of a large upload does not start it over. Must be at least 5 MiB.")
+	},
+}
+
+// flagEnvironmentVariables maps flag names to environment variables that set them when the flag is not passed
+var flagEnvironmentVariables = map[string]string{
+	"catalog":       "CARYATID_CATALOG",
+	"checksum-type": "CARYATID_CHECKSUM_TYPE",
+	"auth-pass":     "CARYATID_AUTH_PASS",
+	"read-only":     "CARYATID_READ_ONLY",
+	"log-format":    "CARYATID_LOG_FORMAT",
+}
+
+// queryFlags are the flags accepted by subcommands that operate on the results of a catalog query
+var queryFlags = []string{"version", "provider", "provider-match", "architecture", "label-selector", "tag"}
+
+// queryParamsFromFlags returns the query parameters shared by subcommands that accept queryFlags
+func queryParamsFromFlags() caryatid.CatalogQueryParams {
+	return caryatid.CatalogQueryParams{
+		Version:       versionFlag,
+		ExactVersion:  exactFlag,
+		Provider:      providerFlag,
+		ProviderMatch: caryatid.ProviderMatchMode(providerMatchFlag),
+		Architecture:  archFlag,
+		LabelSelector: labelSelectorFlag,
+		Tag:           tagFlag,
+	}
+}
+
+// stringsFlagValue collects the values of a flag that may be passed more than once
+type stringsFlagValue []string
+
+func (values *stringsFlagValue) String() string {
+	return strings.Join(*values, ", ")
+}
+
+func (values *stringsFlagValue) Set(value string) error {
+	*values = append(*values, value)
+	return nil
+}
+
+// sizeFlagValue is a number of bytes, which may be passed with a unit like '50GB'; see util.ParseSize()
+// It is negative if the flag was not passed
+type sizeFlagValue int64
+
+func (size *sizeFlagValue) String() string {
+	if size == nil || *size < 0 {
+		return ""
+	}
+	return strconv.FormatInt(int64(*size), 10)
+}
+
+func (size *sizeFlagValue) Set(value string) error {
+	bytes, err := util.ParseSize(value)
+	if err != nil {
+		return err
+	}
+	*size = sizeFlagValue(bytes)
+	return nil
+}
+
+// labelFlagValue collects the labels passed with repeated -label flags
+type labelFlagValue map[string]string
+
+func (labels labelFlagValue) String() string {
+	provider := caryatid.Provider{Labels: labels}
+	return provider.LabelString()
+}
+
+func (labels labelFlagValue) Set(label string) error {
+	key, value, err := caryatid.ParseLabel(label)
+	if err != nil {
+		return err
+	}
+	labels[key] = value
+	return nil
+}
+
+// subcommandExample is an example invocation shown in the usage of a subcommand
+type subcommandExample struct {
+	Description string
+	Command     string
+}
+
+// subcommand is a single action the caryatid program can take, like 'add' or 'query'
+type subcommand struct {
+	Name        string
+	Description string
+
+	// The names of the flags this subcommand accepts, as keys of flagDefinitions
+	Flags []string
+
+	// Flags which must be passed with a non-empty value
+	Required []string
+
+	Examples []subcommandExample
+
+	// Validate is an optional check of the parsed flags which runs before Run
+	Validate func() error
+
+	// Run performs the action. Its result is printed to stdout, even if there was an error.
+	Run func() (result string, err error)
+
+	// If set, Run handles interrupts itself, like serve, which shuts down gracefully
+	// Otherwise, an interrupt rolls back any change in progress, removes any partially written files, and exits; see cancelOnInterrupt()
+	HandlesInterrupt bool
+}
+
+func withQueryFlags(flags ...string) []string {
+	return append(append([]string{}, queryFlags...), flags...)
+}
+
+// validateExactFlag checks that -exact is only passed with a -version that it can match literally
+func validateExactFlag() error {
+	if !exactFlag {
+		return nil
+	}
+	if versionFlag == "" {
+		return fmt.Errorf("-exact requires -version")
+	}
+	if caryatid.IsLatestVersionQuery(versionFlag) {
+		return fmt.Errorf("-exact cannot be used with -version %v", versionFlag)
+	}
+	return nil
+}
+
+// validateBoxFileFlags checks the flags that control where box files are stored and the URLs the catalog records for them
+func validateBoxFileFlags() error {
+	if urlPrefixFlag != "" && relativeUrlsFlag {
+		return fmt.Errorf("-url-prefix and -relative-urls cannot be used together")
+	}
+	if filenameTemplateFlag != "" {
+		return caryatid.ValidateFilenameTemplate(filenameTemplateFlag)
+	}
+	return nil
+}
+
+// validateUploadFlags checks the flags of subcommands that upload box files, along with validateBoxFileFlags()
+func validateUploadFlags() error {
+	if partSizeFlag < caryatid.MinUploadPartSize {
+		return fmt.Errorf("-part-size must be at least %v bytes", caryatid.MinUploadPartSize)
+	}
+	return validateBoxFileFlags()
+}
+
+var subcommands = []*subcommand{
+	{
+		Name:        "show",
+		Description: "Show the full contents of a catalog",
+		Flags:       []string{"catalog", "output", "no-color", "retries", "timeout"},
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Show a catalog as a table", "caryatid show -catalog uri:///path/to/catalog.json -output table"},
+		},
+		Validate: func() error {
+			if outputFlag != "text" && outputFlag != "table" {
+				return fmt.Errorf("-output must be 'text' or 'table', not '%v'", outputFlag)
+			}
+			return nil
+		},
+		Run: func() (result string, err error) {
+			result, err = showAction(catalogFlag, outputFlag)
+			if outputFlag == "table" {
+				return result, err
+			}
+			return colorizeCatalog(result) + "\n", err
+		},
+	},
+	{
+		Name:        "create-test-box",
+		Description: "Create a small box file suitable for testing",
+		Flags:       []string{"box", "provider"},
+		Required:    []string{"box", "provider"},
+		Validate: func() error {
+			if len(boxFlag) > 1 {
+				return fmt.Errorf("-box can only be passed once")
+			}
+			return nil
+		},
+		Run: func() (result string, err error) {
+			return "", createTestBoxAction(boxFlag[0], providerFlag)
+		},
+	},
+	{
+		Name:        "add",
+		Description: "Add a box file to a catalog, creating the catalog if necessary",
+		Flags: []string{
+			"catalog", "box", "name", "display-name", "description", "version-description", "version", "architecture", "label", "overwrite", "force", "latest-alias", "verify-after-copy",
+			"checksum-type", "expected-checksum", "expected-checksum-type", "allow-nonstandard-version", "normalize-versions", "compress", "url-prefix", "relative-urls", "filename-template", "progress-threshold", "part-size", "json-style", "file-mode", "dir-mode", "backup", "backup-count", "lock-timeout", "audit-log", "audit-log-required", "webhook", "webhook-timeout", "update-index", "retries", "timeout",
+		},
+		Required: []string{"box", "name", "description", "version", "catalog"},
+		Examples: []subcommandExample{
+			{"Add a box to a catalog", "caryatid add -catalog uri:///path/to/catalog.json -name testbox -description 'this is a test box' -box /local/path/to/name.box -version 1.2.5"},
+			{"Add a box read from stdin", "cat name.box | caryatid add -catalog uri:///path/to/catalog.json -name testbox -description 'this is a test box' -box - -version 1.2.6"},
+			{"Replace a box that is already in a catalog", "caryatid add -catalog uri:///path/to/catalog.json -name testbox -description 'this is a test box' -box /local/path/to/name.box -version 1.2.5 -force"},
+			{"Add boxes for two providers to the same version at once", "caryatid add -catalog uri:///path/to/catalog.json -name testbox -description 'this is a test box' -box virtualbox.box -box libvirt.box -version 1.2.7"},
+			{"Add a box with both sha256 and md5 checksums, for clients that still read md5", "caryatid add -catalog uri:///path/to/catalog.json -name testbox -description 'this is a test box' -box /local/path/to/name.box -version 1.2.5 -checksum-type sha256,md5"},
+			{"Add a box only if it has the checksum it was published with", "caryatid add -catalog uri:///path/to/catalog.json -name testbox -description 'this is a test box' -box /local/path/to/name.box -version 1.2.5 -expected-checksum d3597dccfdc6953d0a6eff4a9e1903f44f72ab94 -expected-checksum-type sha1"},
+			{"Add a box to a catalog that Vagrant knows as example/testbox, and people see as Test Box", "caryatid add -catalog uri:///path/to/catalog.json -name example/testbox -display-name 'Test Box' -description 'this is a test box' -box /local/path/to/name.box -version 1.2.5"},
+			{"Add a box, and point testbox/testbox_latest_virtualbox.box at it", "caryatid add -catalog file:///path/to/catalog.json -name testbox -description 'this is a test box' -box /local/path/to/name.box -version 1.2.8 -latest-alias"},
+		},
+		Validate: func() error {
+			stdinBoxes := 0
+			for _, boxPath := range boxFlag {
+				if boxPath == "-" {
+					stdinBoxes += 1
+				}
+			}
+			if stdinBoxes > 1 {
+				return fmt.Errorf("Only one -box can be read from stdin")
+			}
+			if expectedChecksumFlag != "" && len(boxFlag) > 1 {
+				return fmt.Errorf("-expected-checksum can only be used with a single -box")
+			}
+			if expectedChecksumTypeFlag != "" && expectedChecksumFlag == "" {
+				return fmt.Errorf("-expected-checksum-type requires -expected-checksum")
+			}
+			if _, err := caryatid.ParseChecksumTypes(checksumFlag); err != nil {
+				return err
+			}
+			return validateUploadFlags()
+		},
+		Run: func() (result string, err error) {
+			return "", addAction(boxFlag, catalogFlag, addParams{
+				Name:                 nameFlag,
+				Description:          descriptionFlag,
+				Version:              versionFlag,
+				DisplayName:          displayNameFlag,
+				VersionDescription:   versionDescriptionFlag,
+				Architecture:         archFlag,
+				Labels:               labelFlag,
+				ChecksumType:         checksumFlag,
+				Compress:             compressFlag,
+				ExpectedChecksum:     expectedChecksumFlag,
+				ExpectedChecksumType: expectedChecksumTypeFlag,
+			})
+		},
+	},
+	{
+		Name:        "import",
+		Description: "Add every box file in a directory to a catalog, with the name and version from each file's name",
+		Flags: []string{
+			"catalog", "dir", "name", "display-name", "description", "overwrite", "verify-after-copy", "checksum-type", "allow-nonstandard-version", "normalize-versions", "dry-run",
+			"url-prefix", "relative-urls", "filename-template", "progress-threshold", "part-size", "json-style", "file-mode", "dir-mode", "backup", "backup-count", "lock-timeout", "audit-log", "audit-log-required", "webhook", "webhook-timeout", "update-index", "retries", "timeout",
+		},
+		Required: []string{"catalog", "dir"},
+		Examples: []subcommandExample{
+			{"Import a directory of boxes named like testbox_1.0.0_virtualbox.box", "caryatid import -catalog uri:///path/to/catalog.json -dir /local/path/to/boxes"},
+			{"Show which boxes named like testbox-1.0.0-virtualbox.box would be imported", "caryatid import -catalog uri:///path/to/catalog.json -dir /local/path/to/boxes -filename-template '{{.Name}}-{{.Version}}-{{.Provider}}{{if .Architecture}}-{{.Architecture}}{{end}}.box' -dry-run"},
+		},
+		Validate: func() error {
+			if _, err := caryatid.ParseChecksumTypes(checksumFlag); err != nil {
+				return err
+			}
+			return validateUploadFlags()
+		},
+		Run: func() (result string, err error) {
+			return importAction(dirFlag, catalogFlag, nameFlag, displayNameFlag, descriptionFlag, checksumFlag, dryRunFlag)
+		},
+	},
+	{
+		Name:        "query",
+		Description: "List the versions and providers in a catalog",
+		Flags:       withQueryFlags("catalog", "exact", "name", "include-prerelease", "sort", "count", "output", "limit", "offset", "no-color", "retries", "timeout"),
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Query a catalog", "caryatid query -catalog uri:///path/to/catalog.json -version '>=1.2.5'"},
+			{"Show the latest version of a box for a provider", "caryatid query -catalog uri:///path/to/catalog.json -version latest -provider virtualbox"},
+			{"List boxes in the order they were added", "caryatid query -catalog uri:///path/to/catalog.json -sort date-asc"},
+			{"Show the three newest versions", "caryatid query -catalog uri:///path/to/catalog.json -sort version-desc -limit 3"},
+			{"Test whether a catalog has a box at a version", "caryatid query -catalog uri:///path/to/catalog.json -version 1.2.5 -count"},
+			{"Test whether a catalog has a box at exactly a version, not counting prereleases like 1.2.5-BETA", "caryatid query -catalog uri:///path/to/catalog.json -version 1.2.5 -exact -count"},
+			{"Show the second page of ten versions", "caryatid query -catalog uri:///path/to/catalog.json -limit 10 -offset 10"},
+			{"Print the URL of the latest virtualbox box", "caryatid query -catalog uri:///path/to/catalog.json -version latest -provider virtualbox -output url"},
+			{"List the boxes for a provider in a table", "caryatid query -catalog uri:///path/to/catalog.json -provider virtualbox -output table"},
+			{"List the versions of each provider since 1.0, grouped by provider", "caryatid query -catalog uri:///path/to/catalog.json -version '>=1.0' -output grouped"},
+		},
+		Validate: func() error {
+			if err := validateExactFlag(); err != nil {
+				return err
+			}
+			if _, err := caryatid.ParseSortOrder(sortFlag); err != nil {
+				return err
+			}
+			if outputFlag != "text" && outputFlag != "url" && outputFlag != "table" && outputFlag != "grouped" {
+				return fmt.Errorf("-output must be 'text', 'url', 'table', or 'grouped', not '%v'", outputFlag)
+			}
+			if countFlag && outputFlag != "text" {
+				return fmt.Errorf("-count and -output cannot be used together")
+			}
+			if limitFlag < 0 || offsetFlag < 0 {
+				return fmt.Errorf("-limit and -offset must not be negative")
+			}
+			return nil
+		},
+		Run: func() (result string, err error) {
+			queryParams := queryParamsFromFlags()
+			queryParams.Name = nameFlag
+			queryParams.Prerelease = caryatid.PrereleaseMode(includePrereleaseFlag)
+			queryParams.Offset = offsetFlag
+			queryParams.Limit = limitFlag
+			if queryParams.Sort, err = caryatid.ParseSortOrder(sortFlag); err != nil {
+				return
+			}
+			resultCata, err := queryAction(catalogFlag, queryParams)
+			switch {
+			case err != nil:
+				return "", err
+			case countFlag:
+				return countQueryResult(resultCata)
+			case outputFlag == "url":
+				return urlQueryResult(resultCata), nil
+			case outputFlag == "table":
+				return resultCata.TableString(), nil
+			case outputFlag == "grouped":
+				return groupedQueryResult(resultCata, queryParams.Sort)
+			}
+			result, err = resultCata.SortedDisplayString(queryParams.Sort)
+			return colorizeCatalog(result), err
+		},
+	},
+	{
+		Name:        "delete",
+		Description: "Delete boxes from a catalog, along with their box files",
+		Flags:       withQueryFlags("catalog", "exact", "dry-run", "latest-alias", "json-style", "file-mode", "dir-mode", "backup", "backup-count", "lock-timeout", "audit-log", "audit-log-required", "webhook", "webhook-timeout", "update-index", "retries", "timeout"),
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Show which virtualbox boxes older than 1.0 would be deleted, without deleting them", "caryatid delete -catalog uri:///path/to/catalog.json -provider virtualbox -version '<1.0' -dry-run"},
+		},
+		Validate: func() error {
+			if versionFlag == "" && providerFlag == "" && archFlag == "" {
+				return fmt.Errorf("without passing -version, -provider, or -architecture, you will delete the entire catalog!")
+			}
+			return validateExactFlag()
+		},
+		Run: func() (result string, err error) {
+			return deleteAction(catalogFlag, queryParamsFromFlags(), dryRunFlag)
+		},
+	},
+	{
+		Name:        "verify",
+		Description: "Check that the box files in a catalog exist and match their checksums",
+		Flags:       withQueryFlags("catalog", "concurrency", "checksum-cache", "no-cache", "no-color", "retries", "timeout"),
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Verify that the boxes in a catalog exist and match their checksums", "caryatid verify -catalog uri:///path/to/catalog.json -version '>=1.2.5'"},
+			{"Verify up to 8 boxes at once", "caryatid verify -catalog s3://bucket/catalog.json -concurrency 8"},
+		},
+		Run: func() (result string, err error) {
+			result, err = verifyAction(catalogFlag, queryParamsFromFlags(), concurrencyFlag)
+			return colorizeVerification(result), err
+		},
+	},
+	{
+		Name:        "doctor",
+		Description: "Check that the backend of a catalog is reachable and writable, without changing the catalog",
+		Flags:       []string{"catalog", "no-color", "retries", "timeout"},
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Check a backend before a build that adds to its catalog", "caryatid doctor -catalog s3://bucket/catalog.json"},
+			{"Check only that a catalog can be read", "caryatid doctor -catalog s3://bucket/catalog.json -read-only"},
+		},
+		Run: func() (result string, err error) {
+			result, err = doctorAction(catalogFlag)
+			return colorizeVerification(result), err
+		},
+	},
+	{
+		Name:        "recompute-checksums",
+		Description: "Recompute the checksums of the box files in a catalog",
+		Flags:       withQueryFlags("catalog", "checksum-type", "checksum-cache", "no-cache", "dry-run", "json-style", "file-mode", "dir-mode", "backup", "backup-count", "lock-timeout", "audit-log", "audit-log-required", "webhook", "webhook-timeout", "update-index", "retries", "timeout"),
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Replace the checksums of boxes in a catalog with sha512 checksums", "caryatid recompute-checksums -catalog uri:///path/to/catalog.json -checksum-type sha512 -dry-run"},
+		},
+		Run: func() (result string, err error) {
+			return recomputeChecksumsAction(catalogFlag, queryParamsFromFlags(), checksumFlag, dryRunFlag)
+		},
+	},
+	{
+		Name:        "prune",
+		Description: "Delete all but the newest versions of the boxes in a catalog",
+		Flags:       withQueryFlags("catalog", "keep", "max-total-size", "prune-prereleases", "dry-run", "latest-alias", "json-style", "file-mode", "dir-mode", "backup", "backup-count", "lock-timeout", "audit-log", "audit-log-required", "webhook", "webhook-timeout", "update-index", "retries", "timeout"),
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Delete all but the 3 newest versions of the virtualbox boxes in a catalog, and all prereleases", "caryatid prune -catalog uri:///path/to/catalog.json -provider virtualbox -keep 3 -prune-prereleases"},
+			{"Show which of the oldest versions would be deleted to keep a catalog under 50GB", "caryatid prune -catalog uri:///path/to/catalog.json -max-total-size 50GB -dry-run"},
+		},
+		Validate: func() error {
+			if keepFlag >= 0 && maxTotalSizeFlag >= 0 {
+				return fmt.Errorf("-keep and -max-total-size cannot be used together")
+			}
+			if keepFlag < 0 && maxTotalSizeFlag < 0 {
+				return fmt.Errorf("pass -keep with the number of versions to keep, or -max-total-size")
+			}
+			return nil
+		},
+		Run: func() (result string, err error) {
+			if maxTotalSizeFlag >= 0 {
+				return pruneToSizeAction(catalogFlag, queryParamsFromFlags(), int64(maxTotalSizeFlag), prunePrereleasesFlag, dryRunFlag)
+			}
+			return pruneAction(catalogFlag, queryParamsFromFlags(), keepFlag, prunePrereleasesFlag, dryRunFlag)
+		},
+	},
+	{
+		Name:        "tag",
+		Description: "Add or remove tags, like 'stable', on the versions of a box in a catalog",
+		Flags:       withQueryFlags("catalog", "exact", "add-tag", "remove-tag", "dry-run", "json-style", "file-mode", "dir-mode", "backup", "backup-count", "lock-timeout", "audit-log", "audit-log-required", "webhook", "webhook-timeout", "update-index", "retries", "timeout"),
+		Required:    []string{"catalog", "version"},
+		Examples: []subcommandExample{
+			{"Promote version 1.2.3 from canary to stable", "caryatid tag -catalog uri:///path/to/catalog.json -version 1.2.3 -exact -add-tag stable -remove-tag canary"},
+			{"Show the latest stable version", "caryatid query -catalog uri:///path/to/catalog.json -tag stable -version latest"},
+		},
+		Validate: func() error {
+			if len(addTagFlag) == 0 && len(removeTagFlag) == 0 {
+				return fmt.Errorf("pass -add-tag or -remove-tag")
+			}
+			for _, tag := range append(append([]string{}, addTagFlag...), removeTagFlag...) {
+				if err := caryatid.ValidateTag(tag); err != nil {
+					return err
+				}
+			}
+			return validateExactFlag()
+		},
+		Run: func() (result string, err error) {
+			return tagAction(catalogFlag, queryParamsFromFlags(), addTagFlag, removeTagFlag, dryRunFlag)
+		},
+	},
+	{
+		Name:        "merge",
+		Description: "Merge the boxes from one catalog into another",
+		Flags:       []string{"catalog", "source", "copy-boxes", "overwrite", "dry-run", "latest-alias", "url-prefix", "relative-urls", "filename-template", "progress-threshold", "part-size", "json-style", "file-mode", "dir-mode", "backup", "backup-count", "lock-timeout", "audit-log", "audit-log-required", "webhook", "webhook-timeout", "update-index", "retries", "timeout"},
+		Required:    []string{"catalog", "source"},
+		Examples: []subcommandExample{
+			{"Merge one catalog into another, copying its box files", "caryatid merge -source uri:///path/to/other.json -catalog uri:///path/to/catalog.json -copy-boxes"},
+		},
+		Validate: validateUploadFlags,
+		Run: func() (result string, err error) {
+			return mergeAction(catalogFlag, sourceFlag, copyBoxesFlag, overwriteFlag, dryRunFlag)
+		},
+	},
+	{
+		Name:        "copy",
+		Description: "Copy boxes from one catalog to another, along with their box files",
+		Flags:       withQueryFlags("catalog", "source", "move", "overwrite", "dry-run", "latest-alias", "url-prefix", "relative-urls", "filename-template", "progress-threshold", "part-size", "json-style", "file-mode", "dir-mode", "backup", "backup-count", "lock-timeout", "audit-log", "audit-log-required", "webhook", "webhook-timeout", "update-index", "retries", "timeout"),
+		Required:    []string{"catalog", "source"},
+		Examples: []subcommandExample{
+			{"Promote a version of a box from a staging catalog to a production one", "caryatid copy -source uri:///path/to/staging.json -catalog uri:///path/to/production.json -version 1.2.5"},
+			{"Move the virtualbox boxes from one catalog to another", "caryatid copy -source uri:///path/to/old.json -catalog uri:///path/to/new.json -provider virtualbox -move"},
+		},
+		Validate: validateUploadFlags,
+		Run: func() (result string, err error) {
+			return copyAction(catalogFlag, sourceFlag, queryParamsFromFlags(), moveFlag, dryRunFlag)
+		},
+	},
+	{
+		Name:        "diff",
+		Description: "Compare two catalogs, and fail if they differ",
+		Flags:       []string{"catalog", "other", "ignore-urls", "retries", "timeout"},
+		Required:    []string{"catalog", "other"},
+		Examples: []subcommandExample{
+			{"Check that a migrated catalog has the same boxes as the original", "caryatid diff -catalog uri:///path/to/catalog.json -other s3://bucket/catalog.json -ignore-urls"},
+		},
+		Run: func() (result string, err error) {
+			return diffAction(catalogFlag, otherFlag, ignoreUrlsFlag)
+		},
+	},
+	{
+		Name:        "dedup",
+		Description: "Remove duplicate versions and providers from a catalog",
+		Flags:       []string{"catalog", "dry-run", "latest-alias", "json-style", "file-mode", "dir-mode", "backup", "backup-count", "lock-timeout", "audit-log", "audit-log-required", "webhook", "webhook-timeout", "update-index", "retries", "timeout"},
+		Required:    []string{"catalog"},
+		Run: func() (result string, err error) {
+			return dedupAction(catalogFlag, dryRunFlag)
+		},
+	},
+	{
+		Name:        "rename",
+		Description: "Rename the box in a catalog and move its box files to match",
+		Flags:       []string{"catalog", "new-name", "dry-run", "latest-alias", "url-prefix", "relative-urls", "filename-template", "json-style", "file-mode", "dir-mode", "backup", "backup-count", "lock-timeout", "audit-log", "audit-log-required", "webhook", "webhook-timeout", "update-index", "retries", "timeout"},
+		Required:    []string{"catalog", "new-name"},
+		Examples: []subcommandExample{
+			{"Preview renaming the box in a catalog", "caryatid rename -catalog uri:///path/to/catalog.json -new-name web-baseline -dry-run"},
+		},
+		Validate: validateBoxFileFlags,
+		Run: func() (result string, err error) {
+			return renameAction(catalogFlag, newNameFlag, dryRunFlag)
+		},
+	},
+	{
+		Name:        "gc",
+		Description: "List, or with -force delete, box files that the catalog does not refer to",
+		Flags:       []string{"catalog", "force", "remove-latest-alias", "dry-run", "lock-timeout", "retries", "timeout"},
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Delete box files that the catalog does not refer to", "caryatid gc -catalog uri:///path/to/catalog.json -force"},
+			{"Delete the aliases made by 'add -latest-alias'", "caryatid gc -catalog uri:///path/to/catalog.json -remove-latest-alias -force"},
+		},
+		Run: func() (result string, err error) {
+			return gcAction(catalogFlag, forceFlag, dryRunFlag, removeLatestAliasFlag)
+		},
+	},
+	{
+		Name:        "rollback",
+		Description: "Restore a catalog from one of the backups made before it was changed. This only restores the catalog, not box files: boxes deleted since the backup stay deleted, and are listed with a warning",
+		Flags:       []string{"catalog", "to", "dry-run", "json-style", "file-mode", "dir-mode", "backup", "backup-count", "lock-timeout", "retries", "timeout"},
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Undo the last change to a catalog", "caryatid rollback -catalog uri:///path/to/catalog.json"},
+			{"Show what restoring a specific backup would do, without restoring it", "caryatid rollback -catalog uri:///path/to/catalog.json -to 20240101T120000 -dry-run"},
+		},
+		Run: func() (result string, err error) {
+			return rollbackAction(catalogFlag, rollbackToFlag, dryRunFlag)
+		},
+	},
+	{
+		Name:        "check",
+		Description: "Check that a catalog is well-formed",
+		Flags:       []string{"catalog", "deep", "retries", "timeout"},
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Check that a catalog is well-formed and that its box files exist", "caryatid check -catalog uri:///path/to/catalog.json -deep"},
+		},
+		Run: func() (result string, err error) {
+			return checkAction(catalogFlag, deepFlag)
+		},
+	},
+	{
+		Name:        "validate",
+		Description: "Check that Vagrant can parse a catalog",
+		Flags:       []string{"catalog", "schema", "retries", "timeout"},
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Check that a catalog has every field Vagrant requires", "caryatid validate -catalog uri:///path/to/catalog.json"},
+			{"Check a catalog against caryatid's JSON Schema, including optional fields", "caryatid validate -catalog uri:///path/to/catalog.json -schema"},
+		},
+		Run: func() (result string, err error) {
+			return validateAction(catalogFlag, schemaFlag)
+		},
+	},
+	{
+		Name:        "stats",
+		Description: "Summarize the boxes in a catalog",
+		Flags:       withQueryFlags("catalog", "output", "retries", "timeout"),
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Summarize the virtualbox boxes in a catalog as JSON", "caryatid stats -catalog uri:///path/to/catalog.json -provider virtualbox -output json"},
+		},
+		Validate: func() error {
+			if outputFlag != "text" && outputFlag != "json" {
+				return fmt.Errorf("-output must be 'text' or 'json', not '%v'", outputFlag)
+			}
+			return nil
+		},
+		Run: func() (result string, err error) {
+			return statsAction(catalogFlag, queryParamsFromFlags(), outputFlag)
+		},
+	},
+	{
+		Name:        "list-catalogs",
+		Description: "List the catalogs under a directory, with the number of versions in each",
+		Flags:       []string{"root", "retries", "timeout"},
+		Required:    []string{"root"},
+		Examples: []subcommandExample{
+			{"List the catalogs kept under one directory", "caryatid list-catalogs -root file:///srv/vagrant"},
+			{"List the catalogs under a prefix of an S3 bucket", "caryatid list-catalogs -root s3://bucket/boxes/"},
+		},
+		Run: func() (result string, err error) {
+			return listCatalogsAction(rootFlag)
+		},
+	},
+	{
+		Name:        "index",
+		Description: "Write an index.json under a directory, listing the catalogs in it with the number of versions in each",
+		Flags:       []string{"root", "json-style", "file-mode", "dir-mode", "retries", "timeout"},
+		Required:    []string{"root"},
+		Examples: []subcommandExample{
+			{"Index the catalogs kept under one directory, in /srv/vagrant/index.json", "caryatid index -root file:///srv/vagrant"},
+			{"Add a box, and update the index of the catalogs next to it", "caryatid add -catalog file:///srv/vagrant/testbox.json -name testbox -description 'this is a test box' -box /local/path/to/name.box -version 1.2.5 -update-index file:///srv/vagrant"},
+		},
+		Run: func() (result string, err error) {
+			return indexAction(rootFlag)
+		},
+	},
+	{
+		Name:        "export",
+		Description: "Write the boxes in a catalog as CSV or JSON",
+		Flags:       withQueryFlags("catalog", "format", "template", "url-ttl", "retries", "timeout"),
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Export the virtualbox boxes in a catalog as CSV", "caryatid export -catalog uri:///path/to/catalog.json -provider virtualbox -format csv"},
+			{"Write a web page listing the boxes in a catalog, with links that work for a day", "caryatid export -catalog s3://bucket/catalog.json -format html -url-ttl 24h > index.html"},
+		},
+		Validate: func() error {
+			if templateFlag != "" && strings.ToLower(formatFlag) != "html" {
+				return fmt.Errorf("-template can only be used with '-format html'")
+			}
+			return nil
+		},
+		Run: func() (result string, err error) {
+			return exportAction(catalogFlag, queryParamsFromFlags(), formatFlag, templateFlag, urlTtlFlag)
+		},
+	},
+	{
+		Name:        "vagrantfile",
+		Description: "Print a Vagrantfile, and a 'vagrant box add' command, that use a box from a catalog",
+		Flags:       withQueryFlags("catalog", "include-prerelease", "url-prefix", "retries", "timeout"),
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Show how to use the latest virtualbox box in a catalog served from a web server", "caryatid vagrantfile -catalog s3://bucket/testbox.json -url-prefix https://boxes.example.com -provider virtualbox"},
+			{"Show how to use a particular version of a box", "caryatid vagrantfile -catalog uri:///path/to/catalog.json -version 1.2.5"},
+		},
+		Run: func() (result string, err error) {
+			queryParams := queryParamsFromFlags()
+			queryParams.Prerelease = caryatid.PrereleaseMode(includePrereleaseFlag)
+			return vagrantfileAction(catalogFlag, queryParams)
+		},
+	},
+	{
+		Name:        "serve",
+		Description: "Serve a catalog and its box files over HTTP until interrupted",
+		Flags:       []string{"catalog", "addr", "url-ttl", "auth-user", "auth-pass", "retries", "timeout"},
+		Required:    []string{"catalog"},
+		Examples: []subcommandExample{
+			{"Serve a catalog so that Vagrant can use it as http://<host>:8099/catalog.json", "caryatid serve -catalog uri:///path/to/catalog.json -addr :8099"},
+			{"Serve a catalog only to clients that know a password", "CARYATID_AUTH_PASS=secret caryatid serve -catalog uri:///path/to/catalog.json -auth-user vagrant"},
+		},
+		Validate: func() error {
+			if (authUserFlag == "") != (authPassFlag == "") {
+				return fmt.Errorf("-auth-user and -auth-pass must be used together")
+			}
+			return nil
+		},
+		Run: func() (result string, err error) {
+			listener, err := net.Listen("tcp", addrFlag)
+			if err != nil {
+				return
+			}
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, os.Interrupt)
+			defer signal.Stop(stop)
+			return "", serveAction(catalogFlag, listener, stop, urlTtlFlag, authUserFlag, authPassFlag)
+		},
+		HandlesInterrupt: true,
+	},
+}
+
+// findSubcommand returns the subcommand with the given name, or nil if there is none
+func findSubcommand(name string) *subcommand {
+	for _, sub := range subcommands {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// globalFlags are accepted by every subcommand
+var globalFlags = []string{"config", "quiet", "verbose", "read-only", "log-format"}
+
+// FlagSet returns a new FlagSet containing the global flags and the flags of the subcommand
+func (sub *subcommand) FlagSet() (fs *flag.FlagSet) {
+	fs = flag.NewFlagSet(sub.Name, flag.ContinueOnError)
+	for _, name := range append(append([]string{}, globalFlags...), sub.Flags...) {
+		define, ok := flagDefinitions[name]
+		if !ok {
+			panic(fmt.Sprintf("Subcommand '%v' uses undefined flag '%v'", sub.Name, name))
+		}
+		define(fs)
+	}
+	// Parse errors and -h are handled by the caller, which prints the full usage of the subcommand
+	fs.SetOutput(ioutil.Discard)
+	fs.Usage = func() {}
+	return
+}
+
+// PrintUsage prints the usage of the subcommand, including the flags in its FlagSet
+func (sub *subcommand) PrintUsage(fs *flag.FlagSet) {
+	fmt.Printf("Usage: caryatid %v [flags]\n", sub.Name)
+	fmt.Printf("%v\n\n", sub.Description)
+	fmt.Printf("Flags:\n")
+	fs.SetOutput(os.Stdout)
+	fs.PrintDefaults()
+	fs.SetOutput(ioutil.Discard)
+	fmt.Printf("\n")
+	for _, example := range sub.Examples {
+		fmt.Printf("EXAMPLE: %v:\n", example.Description)
+		fmt.Printf("%v\n\n", example.Command)
+	}
+}
+
+// Parse parses the arguments that follow the subcommand name,
+// fills in flags that were not passed from the environment and then from the config file,
+// and returns the names of any required flags that were still not set
+func (sub *subcommand) Parse(fs *flag.FlagSet, args []string) (missing []string, err error) {
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+	if fs.NArg() > 0 {
+		err = fmt.Errorf("Unexpected arguments: %v", strings.Join(fs.Args(), " "))
+		return
+	}
+	passed := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+
+	for name, envVar := range flagEnvironmentVariables {
+		value := os.Getenv(envVar)
+		if value == "" || passed[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err = fs.Set(name, value); err != nil {
+			err = fmt.Errorf("Invalid value '%v' for '%v' in environment variable %v: %v", value, name, envVar, err)
+			return
+		}
+		passed[name] = true
+	}
+
+	var config map[string]string
+	if configFlag != "" {
+		config, err = readConfig(configFlag, true)
+	} else {
+		config, err = readConfig(defaultConfigPath(), false)
+	}
+	if err != nil {
+		return
+	}
+	for name, value := range config {
+		// Settings for flags the subcommand does not accept are ignored, so that one config file works for all subcommands
+		if passed[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err = fs.Set(name, value); err != nil {
+			err = fmt.Errorf("Invalid value '%v' for '%v' in config file: %v", value, name, err)
+			return
+		}
+		passed[name] = true
+	}
+	for _, name := range sub.Required {
+		if !passed[name] || fs.Lookup(name).Value.String() == "" {
+			missing = append(missing, name)
+		}
+	}
+	return
+}
+
+// Exit codes, so that scripts can tell kinds of failure apart; see exitCode()
+const (
+	exitOk                 = 0
+	exitError              = 1
+	exitUsage              = 2
+	exitCatalogNotFound    = 3
+	exitVerificationFailed = 4
+	exitCatalogLocked      = 5
+	exitTimeout            = 6
+	exitInterrupted        = 130
 )
 
-func init() {
-	cFlag.Usage = func() {
-		// What the fuck, people https://github.com/golang/go/issues/16955
-		fmt.Printf("Caryatid usage:\n")
-		cFlag.PrintDefaults()
-		fmt.Printf("\n")
-
-		fmt.Printf("EXAMPLE: Add a box to a catalog:\n")
-		fmt.Printf("caryatid add -catalog uri:///path/to/catalog.json -name testbox -description 'this is a test box' -box /local/path/to/name.box -version 1.2.5\n\n")
-
-		fmt.Printf("EXAMPLE: Query a catalog:\n")
-		fmt.Printf("caryatid query -catalog uri:///path/to/catalog.json -version '>=1.2.5'\n\n")
-	}
-
-	cFlag.StringVar(
-		&actionFlag, "action", "",
-		"One of 'show', 'create-test-box', 'query', 'add', or 'delete'.")
-	cFlag.StringVar(
-		&catalogFlag, "catalog", "",
-		"URI for the Vagrant Catalog to operate on")
-	cFlag.StringVar(
-		&boxFlag, "box", "", "Local path to a box file")
-	cFlag.StringVar(
-		&versionFlag, "version", "",
-		"A version specifier. When querying boxes or deleting a box, this restricts the query to only the versions matched, and its value may include specifiers such as less-than signs, like '<=1.2.3'. When adding a box, the version must be exact, and such specifiers are not supported.")
-	cFlag.StringVar(
-		&descriptionFlag, "description", "",
-		"A description for a box in the Vagrant catalog")
-	cFlag.StringVar(
-		&providerFlag, "provider", "",
-		"The name of a provider. When querying boxes or deleting a box, this restricts the query to only the providers matched, and its value may include asterisks to glob such as '*-iso'. When adding a box, globbing is not supported and an asterisk will be interpreted literally.")
-	cFlag.StringVar(
-		&nameFlag, "name", "",
-		"The name of the box tracked in the Vagrant catalog. When deleting a box, this restricts the query to only boxes matching this name, and may include asterisks for globbing. When adding a box, globbing is not supported and an asterisk will be interpreted literally.")
+// exitCodeDescriptions are listed by usage(), in order
+var exitCodeDescriptions = []struct {
+	Code        int
+	Description string
+}{
+	{exitOk, "Success"},
+	{exitError, "Any error not listed below, or a 'query -count' that matched nothing"},
+	{exitUsage, "Bad arguments, like an unknown subcommand, a missing flag, or an invalid flag value"},
+	{exitCatalogNotFound, "The catalog does not exist"},
+	{exitVerificationFailed, "'verify', 'check', or 'validate' found a problem"},
+	{exitCatalogLocked, "The catalog is locked by another process, even after waiting -lock-timeout"},
+	{exitTimeout, "A backend operation took longer than -timeout"},
+	{exitInterrupted, "Interrupted, after rolling back any change in progress and removing any box files and catalogs that were only partly written"},
+}
+
+// exitCode returns the exit code for an error returned by a subcommand
+func exitCode(err error) int {
+	var timeoutErr *caryatid.TimeoutError
+	switch {
+	case err == nil:
+		return exitOk
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.Is(err, caryatid.ErrCatalogNotFound):
+		return exitCatalogNotFound
+	case errors.Is(err, errVerificationFailed):
+		return exitVerificationFailed
+	case errors.Is(err, caryatid.ErrCatalogLocked):
+		return exitCatalogLocked
+	case errors.As(err, &timeoutErr):
+		return exitTimeout
+	}
+	return exitError
+}
+
+// interruptContext is the BackendManager.Context of every manager, and is cancelled by an interrupt; see cancelOnInterrupt()
+var interruptContext = context.Background()
+
+// cancelOnInterrupt waits for an interrupt or termination signal, and then cancels the running subcommand with cancel
+// If a catalog is being changed, the change stops copying box files, rolls back, and releases its lock, and then the subcommand returns;
+// otherwise, or on a second signal, it removes the files that were only partly written and exits right away
+func cancelOnInterrupt(signals chan os.Signal, cancel context.CancelFunc) {
+	sig := <-signals
+	cancel()
+	if caryatid.ChangesInProgress() {
+		fmt.Fprintf(os.Stderr, "Interrupted by %v; rolling back the change in progress, interrupt again to exit right away\n", sig)
+		sig = <-signals
+	}
+	for _, path := range caryatid.RemovePartialFiles() {
+		fmt.Fprintf(os.Stderr, "Removed partially written file '%v'\n", path)
+	}
+	fmt.Fprintf(os.Stderr, "Interrupted by %v\n", sig)
+	os.Exit(exitInterrupted)
+}
+
+func usage() {
+	fmt.Printf("Caryatid usage:\n")
+	fmt.Printf("caryatid <subcommand> [flags]\n\n")
+	fmt.Printf("Subcommands:\n")
+	for _, sub := range subcommands {
+		fmt.Printf("  %-20v %v\n", sub.Name, sub.Description)
+	}
+	fmt.Printf("\n")
+	fmt.Printf("Run 'caryatid help <subcommand>' to see the flags that a subcommand accepts.\n\n")
+	fmt.Printf("Exit codes:\n")
+	for _, exit := range exitCodeDescriptions {
+		fmt.Printf("  %-3v %v\n", exit.Code, exit.Description)
+	}
 }
 
 func main() {
 	var (
-		err    error
-		result string
+		err     error
+		result  string
+		missing []string
 	)
 
-	if err = cFlag.Parse(os.Args[1:]); err != nil {
-		panic(fmt.Sprintf("Flag parsing error: %v\n", err))
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
 	}
 
-	missingFlags := func(flags ...string) {
-		fmt.Printf("ERROR: Missing one or more flags: ")
-		for _, f := range flags {
-			fmt.Printf("-%v ", f)
+	for _, arg := range os.Args[1:] {
+		if arg == "-action" || strings.HasPrefix(arg, "-action=") {
+			fmt.Printf("ERROR: The -action flag has been replaced by subcommands; run 'caryatid <subcommand> [flags]' instead, like 'caryatid show -catalog ...'\n\n")
+			usage()
+			os.Exit(exitUsage)
 		}
-		fmt.Printf("\n\n")
-		cFlag.Usage()
-		os.Exit(1)
 	}
 
-	switch actionFlag {
-	case "show":
-		if catalogFlag == "" {
-			missingFlags("catalog")
-		}
-		result, err = showAction(catalogFlag)
-		fmt.Printf("%v\n", result)
-	case "create-test-box":
-		if boxFlag == "" || providerFlag == "" {
-			missingFlags("box", "provider")
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		if len(os.Args) > 2 {
+			if sub := findSubcommand(os.Args[2]); sub != nil {
+				sub.PrintUsage(sub.FlagSet())
+				os.Exit(exitOk)
+			}
+			fmt.Printf("Unknown subcommand: '%v'\n\n", os.Args[2])
+			usage()
+			os.Exit(exitUsage)
 		}
-		err = createTestBoxAction(boxFlag, providerFlag)
-	case "add":
-		if boxFlag == "" || nameFlag == "" || descriptionFlag == "" || versionFlag == "" || catalogFlag == "" {
-			missingFlags("box", "name", "description", "version", "catalog")
-		}
-		err = addAction(boxFlag, nameFlag, descriptionFlag, versionFlag, catalogFlag)
-	case "query":
-		if catalogFlag == "" {
-			missingFlags("catalog")
+		usage()
+		os.Exit(exitOk)
+	}
+
+	sub := findSubcommand(name)
+	if sub == nil {
+		fmt.Printf("Unknown subcommand: '%v'\n\n", name)
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	fs := sub.FlagSet()
+	if missing, err = sub.Parse(fs, os.Args[2:]); err != nil {
+		if err == flag.ErrHelp {
+			sub.PrintUsage(fs)
+			os.Exit(exitOk)
 		}
-		var resultCata caryatid.Catalog
-		resultCata, err = queryAction(catalogFlag, versionFlag, providerFlag)
-		fmt.Printf(resultCata.DisplayString())
-	case "delete":
-		if catalogFlag == "" {
-			missingFlags("catalog")
+		fmt.Printf("ERROR: %v\n\n", err)
+		sub.PrintUsage(fs)
+		os.Exit(exitUsage)
+	}
+	if len(missing) > 0 {
+		fmt.Printf("ERROR: Missing one or more flags: ")
+		for _, f := range missing {
+			if envVar, ok := flagEnvironmentVariables[f]; ok {
+				fmt.Printf("-%v (or set %v) ", f, envVar)
+			} else {
+				fmt.Printf("-%v ", f)
+			}
 		}
-		if versionFlag == "" && providerFlag == "" {
-			fmt.Printf("ERROR: without passing -version or -provider, you will delete the entire catalog!\n\n")
-			cFlag.Usage()
-			os.Exit(1)
+		fmt.Printf("\n\n")
+		sub.PrintUsage(fs)
+		os.Exit(exitUsage)
+	}
+	if sub.Validate != nil {
+		if err = sub.Validate(); err != nil {
+			fmt.Printf("ERROR: %v\n\n", err)
+			sub.PrintUsage(fs)
+			os.Exit(exitUsage)
 		}
-		err = deleteAction(catalogFlag, versionFlag, providerFlag)
+	}
+
+	if quietFlag && verboseFlag {
+		fmt.Printf("ERROR: -quiet and -verbose cannot be used together\n\n")
+		sub.PrintUsage(fs)
+		os.Exit(exitUsage)
+	} else if quietFlag {
+		caryatid.SetLogLevel(caryatid.LogLevelError)
+	} else if verboseFlag {
+		caryatid.SetLogLevel(caryatid.LogLevelDebug)
+	}
+	switch logFormatFlag {
+	case "text":
+	case "json":
+		caryatid.SetLogger(caryatid.NewJSONLogger(os.Stderr))
 	default:
-		fmt.Printf("Unknown (or missing) -action: '%v'\n", actionFlag)
-		cFlag.Usage()
+		fmt.Printf("ERROR: -log-format must be 'text' or 'json', not '%v'\n\n", logFormatFlag)
+		sub.PrintUsage(fs)
+		os.Exit(exitUsage)
 	}
 
-	if err != nil {
-		fmt.Printf("Error running '%v' action:\n%v\n", actionFlag, err)
-		os.Exit(1)
+	colorEnabled = shouldColor(os.Stdout, noColorFlag)
+	if !sub.HandlesInterrupt {
+		var cancel context.CancelFunc
+		interruptContext, cancel = context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go cancelOnInterrupt(signals, cancel)
 	}
+	result, err = sub.Run()
+	fmt.Printf("%v", result)
 
-	os.Exit(0)
+	if err != nil && err != errNoMatches {
+		fmt.Printf("Error running '%v':\n%v\n", sub.Name, err)
+	}
+	os.Exit(exitCode(err))
 }
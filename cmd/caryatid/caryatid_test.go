@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mrled/caryatid/pkg/caryatid"
+)
+
+// isolateEnvironment points the default config path at an empty directory,
+// and unsets the environment variables that set flags, for the duration of a test,
+// so that the settings of whoever runs the tests are not read
+func isolateEnvironment(t *testing.T) (configDir string, cleanup func()) {
+	configDir, err := ioutil.TempDir("", "caryatid-config")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v", err)
+	}
+	envVars := []string{"XDG_CONFIG_HOME"}
+	for _, envVar := range flagEnvironmentVariables {
+		envVars = append(envVars, envVar)
+	}
+	oldValues := map[string]string{}
+	for _, envVar := range envVars {
+		if value, ok := os.LookupEnv(envVar); ok {
+			oldValues[envVar] = value
+		}
+		os.Unsetenv(envVar)
+	}
+	os.Setenv("XDG_CONFIG_HOME", configDir)
+	cleanup = func() {
+		for _, envVar := range envVars {
+			if value, ok := oldValues[envVar]; ok {
+				os.Setenv(envVar, value)
+			} else {
+				os.Unsetenv(envVar)
+			}
+		}
+		os.RemoveAll(configDir)
+	}
+	return
+}
+
+func TestSubcommandFlags(t *testing.T) {
+	for _, sub := range subcommands {
+		fs := sub.FlagSet()
+		for _, name := range sub.Required {
+			if fs.Lookup(name) == nil {
+				t.Fatalf("Subcommand '%v' requires flag '%v', but does not accept it", sub.Name, name)
+			}
+		}
+	}
+
+	show := findSubcommand("show")
+	if show == nil {
+		t.Fatalf("No 'show' subcommand")
+	}
+	if show.FlagSet().Lookup("box") != nil {
+		t.Fatalf("The 'show' subcommand accepts a -box flag it does not use")
+	}
+}
+
+func TestSubcommandParse(t *testing.T) {
+	_, cleanup := isolateEnvironment(t)
+	defer cleanup()
+
+	type TestCase struct {
+		Subcommand      string
+		Args            []string
+		ExpectError     bool
+		ExpectedMissing []string
+	}
+	testCases := []TestCase{
+		{"show", []string{"-catalog", "file:///tmp/test.json"}, false, nil},
+		{"show", []string{}, false, []string{"catalog"}},
+		{"show", []string{"-catalog", ""}, false, []string{"catalog"}},
+		{"show", []string{"-catalog", "file:///tmp/test.json", "-box", "test.box"}, true, nil},
+		{"show", []string{"-catalog", "file:///tmp/test.json", "extra"}, true, nil},
+		{"add", []string{"-catalog", "file:///tmp/test.json", "-box", "test.box"}, false, []string{"name", "description", "version"}},
+		{"prune", []string{"-catalog", "file:///tmp/test.json", "-keep", "0"}, false, nil},
+		{"prune", []string{"-catalog", "file:///tmp/test.json", "-max-total-size", "50GB"}, false, nil},
+		{"prune", []string{"-catalog", "file:///tmp/test.json", "-max-total-size", "50 furlongs"}, true, nil},
+	}
+
+	for _, tc := range testCases {
+		sub := findSubcommand(tc.Subcommand)
+		missing, err := sub.Parse(sub.FlagSet(), tc.Args)
+		if tc.ExpectError && err == nil {
+			t.Fatalf("Expected an error parsing '%v' arguments %v, but got none", tc.Subcommand, tc.Args)
+		} else if !tc.ExpectError && err != nil {
+			t.Fatalf("Error parsing '%v' arguments %v: %v", tc.Subcommand, tc.Args, err)
+		}
+		if !reflect.DeepEqual(missing, tc.ExpectedMissing) {
+			t.Fatalf("Parsing '%v' arguments %v: expected missing flags %v, but got %v", tc.Subcommand, tc.Args, tc.ExpectedMissing, missing)
+		}
+	}
+}
+
+// -include-prerelease is true by default, but passing it explicitly also lets '-version latest' return a prerelease
+func TestIncludePrereleaseFlag(t *testing.T) {
+	type TestCase struct {
+		Args     []string
+		Expected caryatid.PrereleaseMode
+	}
+	testCases := []TestCase{
+		{[]string{}, caryatid.PrereleaseDefault},
+		{[]string{"-include-prerelease"}, caryatid.PrereleaseInclude},
+		{[]string{"-include-prerelease=true"}, caryatid.PrereleaseInclude},
+		{[]string{"-include-prerelease=false"}, caryatid.PrereleaseExclude},
+	}
+
+	query := findSubcommand("query")
+	for _, tc := range testCases {
+		args := append([]string{"-catalog", "file:///tmp/test.json"}, tc.Args...)
+		if _, err := query.Parse(query.FlagSet(), args); err != nil {
+			t.Fatalf("Error parsing 'query' arguments %v: %v", args, err)
+		}
+		if mode := caryatid.PrereleaseMode(includePrereleaseFlag); mode != tc.Expected {
+			t.Fatalf("Parsing 'query' arguments %v: expected prerelease mode '%v', but got '%v'", args, tc.Expected, mode)
+		}
+	}
+}
+
+func TestSubcommandParseConfig(t *testing.T) {
+	configDir, cleanup := isolateEnvironment(t)
+	defer cleanup()
+
+	configUri := "file:///tmp/from-config.json"
+	flagUri := "file:///tmp/from-flag.json"
+	configPath := filepath.Join(configDir, "caryatid", "config.json")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
+		t.Fatalf("Error creating config directory: %v", err)
+	}
+	configJson := `{"catalog": "` + configUri + `", "checksum-type": "sha512", "retries": 3, "box": "ignored.box"}`
+	if err := ioutil.WriteFile(configPath, []byte(configJson), 0600); err != nil {
+		t.Fatalf("Error writing config file: %v", err)
+	}
+
+	show := findSubcommand("show")
+	if missing, err := show.Parse(show.FlagSet(), []string{}); err != nil || len(missing) > 0 {
+		t.Fatalf("Error parsing with a config file: missing %v, error %v", missing, err)
+	}
+	if catalogFlag != configUri || retriesFlag != 3 {
+		t.Fatalf("Expected the catalog '%v' and 3 retries from the config file, but got '%v' and %v", configUri, catalogFlag, retriesFlag)
+	}
+
+	if _, err := show.Parse(show.FlagSet(), []string{"-catalog", flagUri}); err != nil {
+		t.Fatalf("Error parsing with a config file: %v", err)
+	}
+	if catalogFlag != flagUri {
+		t.Fatalf("Expected the -catalog flag '%v' to override the config file, but got '%v'", flagUri, catalogFlag)
+	}
+
+	// An explicit -config is used instead of the default path
+	otherPath := filepath.Join(configDir, "other.json")
+	if err := ioutil.WriteFile(otherPath, []byte(`{"checksum-type": "md5"}`), 0600); err != nil {
+		t.Fatalf("Error writing config file: %v", err)
+	}
+	add := findSubcommand("add")
+	missing, err := add.Parse(add.FlagSet(), []string{"-config", otherPath})
+	if err != nil {
+		t.Fatalf("Error parsing with -config: %v", err)
+	}
+	if checksumFlag != "md5" || !reflect.DeepEqual(missing, []string{"box", "name", "description", "version", "catalog"}) {
+		t.Fatalf("Expected only the config from -config to be read, but got checksum type '%v' and missing flags %v", checksumFlag, missing)
+	}
+
+	if _, err := add.Parse(add.FlagSet(), []string{"-config", filepath.Join(configDir, "nonexistent.json")}); err == nil {
+		t.Fatalf("Expected an error for a nonexistent -config file, but got none")
+	}
+
+	if err := ioutil.WriteFile(otherPath, []byte(`{"catalgo": "file:///tmp/typo.json"}`), 0600); err != nil {
+		t.Fatalf("Error writing config file: %v", err)
+	}
+	if _, err := add.Parse(add.FlagSet(), []string{"-config", otherPath}); err == nil {
+		t.Fatalf("Expected an error for a config file with an unknown flag, but got none")
+	}
+}
+
+func TestSubcommandParseEnvironment(t *testing.T) {
+	configDir, cleanup := isolateEnvironment(t)
+	defer cleanup()
+
+	envUri := "file:///tmp/from-env.json"
+	flagUri := "file:///tmp/from-flag.json"
+	os.Setenv("CARYATID_CATALOG", envUri)
+	os.Setenv("CARYATID_CHECKSUM_TYPE", "sha1")
+
+	recompute := findSubcommand("recompute-checksums")
+	if missing, err := recompute.Parse(recompute.FlagSet(), []string{}); err != nil || len(missing) > 0 {
+		t.Fatalf("Error parsing with environment variables: missing %v, error %v", missing, err)
+	}
+	if catalogFlag != envUri || checksumFlag != "sha1" {
+		t.Fatalf("Expected catalog '%v' and checksum type 'sha1' from the environment, but got '%v' and '%v'", envUri, catalogFlag, checksumFlag)
+	}
+
+	if _, err := recompute.Parse(recompute.FlagSet(), []string{"-catalog", flagUri, "-checksum-type", "md5"}); err != nil {
+		t.Fatalf("Error parsing with environment variables: %v", err)
+	}
+	if catalogFlag != flagUri || checksumFlag != "md5" {
+		t.Fatalf("Expected flags to override the environment, but got catalog '%v' and checksum type '%v'", catalogFlag, checksumFlag)
+	}
+
+	// The environment overrides the config file
+	configPath := filepath.Join(configDir, "config.json")
+	if err := ioutil.WriteFile(configPath, []byte(`{"catalog": "file:///tmp/from-config.json"}`), 0600); err != nil {
+		t.Fatalf("Error writing config file: %v", err)
+	}
+	if _, err := recompute.Parse(recompute.FlagSet(), []string{"-config", configPath}); err != nil {
+		t.Fatalf("Error parsing with environment variables: %v", err)
+	}
+	if catalogFlag != envUri {
+		t.Fatalf("Expected the environment to override the config file, but got catalog '%v'", catalogFlag)
+	}
+
+	os.Setenv("CARYATID_CATALOG", "")
+	if missing, _ := recompute.Parse(recompute.FlagSet(), []string{}); !reflect.DeepEqual(missing, []string{"catalog"}) {
+		t.Fatalf("Expected -catalog to be missing with an empty CARYATID_CATALOG, but got missing flags %v", missing)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	type TestCase struct {
+		Err      error
+		Expected int
+	}
+	testCases := []TestCase{
+		TestCase{nil, exitOk},
+		TestCase{fmt.Errorf("Something went wrong"), exitError},
+		TestCase{errNoMatches, exitError},
+		TestCase{fmt.Errorf("No catalog at 'file:///tmp/catalog.json': %w", caryatid.ErrCatalogNotFound), exitCatalogNotFound},
+		TestCase{fmt.Errorf("%w: 1 of 2 boxes failed", errVerificationFailed), exitVerificationFailed},
+		TestCase{fmt.Errorf("%w; timed out after 30s waiting for the lock", caryatid.ErrCatalogLocked), exitCatalogLocked},
+		TestCase{&caryatid.TimeoutError{Operation: "GetCatalogBytes()", Timeout: time.Second}, exitTimeout},
+		TestCase{fmt.Errorf("GetCatalogBytes() failed after 3 attempts: %w", &caryatid.TimeoutError{Operation: "GetCatalogBytes()", Timeout: time.Second}), exitTimeout},
+	}
+	for _, tc := range testCases {
+		if code := exitCode(tc.Err); code != tc.Expected {
+			t.Fatalf("exitCode(%v) returned %v, but expected %v\n", tc.Err, code, tc.Expected)
+		}
+	}
+
+	// Every exit code is documented
+	documented := map[int]bool{}
+	for _, exit := range exitCodeDescriptions {
+		documented[exit.Code] = true
+	}
+	for _, code := range []int{exitOk, exitError, exitUsage, exitCatalogNotFound, exitVerificationFailed, exitCatalogLocked, exitTimeout} {
+		if !documented[code] {
+			t.Fatalf("Exit code %v is not listed in exitCodeDescriptions\n", code)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ANSI colors for the human-readable output of subcommands like 'show', 'query', and 'verify'
+*/
+
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// colorEnabled is set by main() from shouldColor(), and can be set directly by tests
+// Output meant for machines, like '-output url' or '-format json', is never colorized, whatever its value
+var colorEnabled bool
+
+// shouldColor returns true if output written to file should be colorized:
+// noColor is not set, the NO_COLOR environment variable is empty, and file is a terminal
+func shouldColor(file *os.File, noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps s in an ANSI color code if colorEnabled is set
+func colorize(color string, s string) string {
+	if !colorEnabled || s == "" {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// colorizeCatalog colors the output of Catalog.DisplayString():
+// the name of the box in bold, version headers in yellow, and provider names in cyan
+func colorizeCatalog(display string) string {
+	if !colorEnabled {
+		return display
+	}
+	lines := strings.Split(display, "\n")
+	for idx, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "    "):
+			fields := strings.SplitN(strings.TrimPrefix(line, "    "), " ", 2)
+			lines[idx] = "    " + colorize(ansiCyan, fields[0])
+			if len(fields) > 1 {
+				lines[idx] += " " + fields[1]
+			}
+		case strings.HasPrefix(line, "  "):
+			lines[idx] = "  " + colorize(ansiYellow, strings.TrimPrefix(line, "  "))
+		case idx == 0:
+			lines[idx] = colorize(ansiBold, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// colorizeVerification colors the PASS and FAIL at the start of each line of the output of verifyAction()
+func colorizeVerification(result string) string {
+	if !colorEnabled {
+		return result
+	}
+	lines := strings.Split(result, "\n")
+	for idx, line := range lines {
+		if strings.HasPrefix(line, "PASS ") {
+			lines[idx] = colorize(ansiGreen, "PASS") + strings.TrimPrefix(line, "PASS")
+		} else if strings.HasPrefix(line, "FAIL ") {
+			lines[idx] = colorize(ansiRed, "FAIL") + strings.TrimPrefix(line, "FAIL")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
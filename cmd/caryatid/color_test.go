@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/mrled/caryatid/pkg/caryatid"
+)
+
+func TestColorize(t *testing.T) {
+	catalog := caryatid.Catalog{Name: "ColorBox", Description: "A colorful box", Versions: []caryatid.Version{
+		caryatid.Version{Version: "1.0.0", Providers: []caryatid.Provider{
+			caryatid.Provider{Name: "virtualbox", Url: "file:///boxes/ColorBox_1.0.0_virtualbox.box", ChecksumType: "sha256", Checksum: "0xB00B1E5"},
+			caryatid.Provider{Name: "libvirt", Architecture: "arm64", Url: "file:///boxes/ColorBox_1.0.0_libvirt_arm64.box", ChecksumType: "sha256", Checksum: "0xB00B1E5"},
+		}},
+	}}
+	display := catalog.DisplayString()
+	verification := "PASS 1.0.0 virtualbox\nFAIL 1.0.0 libvirt (file:///boxes/ColorBox_1.0.0_libvirt_arm64.box): checksum mismatch\n1 of 2 boxes passed verification\n"
+	escapeCodes := regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+	oldColorEnabled := colorEnabled
+	defer func() { colorEnabled = oldColorEnabled }()
+
+	colorEnabled = false
+	for _, result := range []string{colorizeCatalog(display), colorizeVerification(verification)} {
+		if strings.Contains(result, "\x1b") {
+			t.Fatalf("Expected no escape codes with color disabled, but got:\n%q\n", result)
+		}
+	}
+	if colorizeCatalog(display) != display || colorizeVerification(verification) != verification {
+		t.Fatalf("Expected output to be unchanged with color disabled\n")
+	}
+
+	colorEnabled = true
+	type TestCase struct {
+		Original  string
+		Colorized string
+		Expected  []string
+	}
+	testCases := []TestCase{
+		TestCase{display, colorizeCatalog(display), []string{
+			ansiBold + "ColorBox (A colorful box)" + ansiReset,
+			"  " + ansiYellow + "v1.0.0" + ansiReset,
+			"    " + ansiCyan + "virtualbox" + ansiReset + " sha256:0xB00B1E5",
+			"    " + ansiCyan + "libvirt/arm64" + ansiReset + " sha256:0xB00B1E5",
+		}},
+		TestCase{verification, colorizeVerification(verification), []string{
+			ansiGreen + "PASS" + ansiReset + " 1.0.0 virtualbox",
+			ansiRed + "FAIL" + ansiReset + " 1.0.0 libvirt",
+		}},
+	}
+	for _, tc := range testCases {
+		for _, expected := range tc.Expected {
+			if !strings.Contains(tc.Colorized, expected) {
+				t.Fatalf("Expected colorized output to contain %q, but got:\n%q\n", expected, tc.Colorized)
+			}
+		}
+		if stripped := escapeCodes.ReplaceAllString(tc.Colorized, ""); stripped != tc.Original {
+			t.Fatalf("Expected colorizing to change nothing but escape codes, but got:\n%q\n", stripped)
+		}
+	}
+}
+
+func TestShouldColor(t *testing.T) {
+	file, err := ioutil.TempFile("", "caryatid-color")
+	if err != nil {
+		t.Fatalf("Error creating temporary file: %v\n", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if shouldColor(file, false) {
+		t.Fatalf("Expected output to a regular file not to be colorized\n")
+	}
+
+	// The test may or may not be running in a terminal, but NO_COLOR and -no-color always win
+	oldNoColor, hadNoColor := os.LookupEnv("NO_COLOR")
+	defer func() {
+		if hadNoColor {
+			os.Setenv("NO_COLOR", oldNoColor)
+		} else {
+			os.Unsetenv("NO_COLOR")
+		}
+	}()
+	os.Unsetenv("NO_COLOR")
+	if shouldColor(os.Stdout, true) {
+		t.Fatalf("Expected -no-color to disable color\n")
+	}
+	os.Setenv("NO_COLOR", "1")
+	if shouldColor(os.Stdout, false) {
+		t.Fatalf("Expected NO_COLOR to disable color\n")
+	}
+}
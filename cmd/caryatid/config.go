@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+)
+
+// defaultConfigPath returns the path of the config file that is read when -config is not passed
+func defaultConfigPath() string {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" && runtime.GOOS == "windows" {
+		configDir = os.Getenv("APPDATA")
+	}
+	if configDir == "" {
+		configDir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(configDir, "caryatid", "config.json")
+}
+
+// defaultChecksumCachePath returns the path of the checksum cache that is used when -checksum-cache is not passed
+func defaultChecksumCachePath() string {
+	cacheDir := os.Getenv("XDG_CACHE_HOME")
+	if cacheDir == "" && runtime.GOOS == "windows" {
+		cacheDir = os.Getenv("LOCALAPPDATA")
+	}
+	if cacheDir == "" {
+		cacheDir = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	return filepath.Join(cacheDir, "caryatid", "checksums.json")
+}
+
+// readConfig reads a config file, which is a JSON object mapping flag names to default values, like:
+//
+//	{"catalog": "file:///srv/vagrant/testbox.json", "checksum-type": "sha512", "quiet": true}
+//
+// If the file does not exist and mustExist is false, the result is empty
+func readConfig(path string, mustExist bool) (config map[string]string, err error) {
+	config = map[string]string{}
+
+	configBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !mustExist {
+		return config, nil
+	} else if err != nil {
+		return
+	}
+
+	var rawConfig map[string]interface{}
+	if err = json.Unmarshal(configBytes, &rawConfig); err != nil {
+		return nil, fmt.Errorf("Could not parse config file '%v': %v", path, err)
+	}
+
+	var unknown []string
+	for name, value := range rawConfig {
+		if _, ok := flagDefinitions[name]; !ok || name == "config" {
+			unknown = append(unknown, name)
+			continue
+		}
+		switch typedValue := value.(type) {
+		case string:
+			config[name] = typedValue
+		case bool:
+			config[name] = strconv.FormatBool(typedValue)
+		case float64:
+			config[name] = strconv.FormatFloat(typedValue, 'f', -1, 64)
+		default:
+			return nil, fmt.Errorf("Config file '%v' has an invalid value for '%v': %v", path, name, value)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("Config file '%v' sets unknown flags: %v", path, unknown)
+	}
+
+	return
+}
@@ -8,7 +8,7 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/hashicorp/packer/common"
 	"github.com/hashicorp/packer/helper/config"
@@ -34,10 +34,66 @@ type Config struct {
 	// The version for the current artifact
 	Version string `mapstructure:"version"`
 
+	// Accept a version that is not a strict semantic version
+	AllowNonstandardVersion bool `mapstructure:"allow_nonstandard_version"`
+
+	// Replace a box already in the catalog with the same version and provider, rather than failing
+	Overwrite bool `mapstructure:"overwrite"`
+
+	// Keep an alias like '<name>/<name>_latest_<provider>.box' pointing to the box file of the newest version
+	LatestAlias bool `mapstructure:"latest_alias"`
+
+	// Read each box file back after copying it, and fail if its checksum does not match
+	VerifyAfterCopy bool `mapstructure:"verify_after_copy"`
+
+	// A name for people to read, distinct from the Name that Vagrant uses
+	DisplayName string `mapstructure:"display_name"`
+
 	// A short description for the Vagrant box
 	Description string `mapstructure:"description"`
 
+	// A description for this version only, overriding Description for it
+	VersionDescription string `mapstructure:"version_description"`
+
+	// The type of checksum to record in the catalog, like "sha256", or a comma-separated list like "sha256,md5"; see caryatid.ParseChecksumTypes()
+	ChecksumType string `mapstructure:"checksum_type"`
+
+	// If set, record box URLs in the catalog under this prefix rather than the location of the box file in the backend
+	UrlPrefix string `mapstructure:"url_prefix"`
+
+	// If set, record box URLs in the catalog relative to the catalog; only catalogs served with 'caryatid serve' can use this
+	RelativeUrls bool `mapstructure:"relative_urls"`
+
+	// A text/template for the name of the stored box file; see caryatid.ValidateFilenameTemplate()
+	FilenameTemplate string `mapstructure:"filename_template"`
+
+	// If set, append a line of JSON recording the new box to the local file at this path; see caryatid.AuditEntry
+	AuditLog string `mapstructure:"audit_log"`
+
+	// If set, fail if the audit log cannot be written; otherwise a failure to write it is only logged
+	AuditLogRequired bool `mapstructure:"audit_log_required"`
+
+	// If set, POST the same JSON as the audit log to this http:// or https:// URL; a failure to deliver it is only logged
+	Webhook string `mapstructure:"webhook"`
+
+	// How long to wait for the webhook to respond, like "5s"; if empty, caryatid.DefaultWebhookTimeout
+	WebhookTimeout string `mapstructure:"webhook_timeout"`
+
+	// If set, rebuild the index of the catalogs under this directory after adding the box; see caryatid.BackendManager.WriteCatalogIndex()
+	UpdateIndex string `mapstructure:"update_index"`
+
+	// For the file backend, the octal permissions of the catalog and box files, like "0640", and of the directories created for them, like "0750"
+	FileMode string `mapstructure:"file_mode"`
+	DirMode  string `mapstructure:"dir_mode"`
+
+	// Compress the box with gzip before storing it, unless it is already compressed
+	Compress bool `mapstructure:"compress"`
+
+	// Upload boxes larger than this many bytes in parts of this size, to backends that support it; see caryatid.BackendManager.UploadPartSize
+	PartSize int64 `mapstructure:"part_size"`
+
 	// Whether to keep the input artifact
+	// Like Packer's other post-processors, this defaults to false, so Packer deletes the input box once it is in the catalog
 	KeepInputArtifact bool `mapstructure:"keep_input_artifact"`
 
 	ctx interpolate.Context
@@ -62,9 +118,56 @@ func (pp *CaryatidPostProcessor) Configure(raws ...interface{}) error {
 	if pp.config.Version == "" {
 		return fmt.Errorf("Version required")
 	}
+	pp.config.Version = caryatid.NormalizeVersion(pp.config.Version)
+	if err = caryatid.ValidateVersion(pp.config.Version, pp.config.AllowNonstandardVersion); err != nil {
+		return err
+	}
 	if pp.config.CatalogUri == "" {
 		return fmt.Errorf("CatalogUri required")
 	}
+	if pp.config.CatalogUri, err = caryatid.ExpandFileUri(pp.config.CatalogUri); err != nil {
+		return err
+	}
+	pp.config.CatalogUri = caryatid.NormalizeFileUri(pp.config.CatalogUri)
+	if pp.config.UpdateIndex != "" {
+		if pp.config.UpdateIndex, err = caryatid.ExpandFileUri(pp.config.UpdateIndex); err != nil {
+			return err
+		}
+		pp.config.UpdateIndex = caryatid.NormalizeFileUri(pp.config.UpdateIndex)
+	}
+	if pp.config.UrlPrefix != "" && pp.config.RelativeUrls {
+		return fmt.Errorf("url_prefix and relative_urls cannot be used together")
+	}
+	if pp.config.FilenameTemplate != "" {
+		if err = caryatid.ValidateFilenameTemplate(pp.config.FilenameTemplate); err != nil {
+			return err
+		}
+	}
+	if _, err = caryatid.ParseFileMode(pp.config.FileMode); err != nil {
+		return err
+	}
+	if _, err = caryatid.ParseDirMode(pp.config.DirMode); err != nil {
+		return err
+	}
+	if pp.config.Webhook != "" {
+		if err = caryatid.ValidateWebhookUrl(pp.config.Webhook); err != nil {
+			return err
+		}
+	}
+	if pp.config.WebhookTimeout != "" {
+		if _, err = time.ParseDuration(pp.config.WebhookTimeout); err != nil {
+			return fmt.Errorf("Invalid webhook_timeout '%v': %v", pp.config.WebhookTimeout, err)
+		}
+	}
+	if pp.config.PartSize != 0 && pp.config.PartSize < caryatid.MinUploadPartSize {
+		return fmt.Errorf("part_size must be at least %v bytes", caryatid.MinUploadPartSize)
+	}
+	if pp.config.ChecksumType == "" {
+		pp.config.ChecksumType = caryatid.DefaultChecksumType
+	}
+	if _, err = caryatid.ParseChecksumTypes(pp.config.ChecksumType); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -73,41 +176,83 @@ func (pp *CaryatidPostProcessor) PostProcess(ui packer.Ui, artifact packer.Artif
 
 	keepInputArtifact = pp.config.KeepInputArtifact
 
-	inBoxFile, digestType, digest, provider, err := caryatid.DeriveArtifactInfoFromPackerArtifact(artifact)
+	boxFile, err := caryatid.BoxFileFromPackerArtifact(artifact)
+	if err != nil {
+		caryatid.LogErrorf("PostProcess(): Error finding box file in input artifact: %v\n", err)
+		return
+	}
+	caryatid.LogInfof("PostProcess(): Found input Vagrant .box file: '%v'\n", boxFile)
+
+	boxArtifact, cleanup, err := caryatid.PrepareBoxArtifact(boxFile, pp.config.ChecksumType, pp.config.Compress)
 	if err != nil {
-		log.Printf("PostProcess(): Error deriving artifact information: %v", err)
+		caryatid.LogErrorf("PostProcess(): %v\n", err)
 		return
 	}
+	defer cleanup()
 
 	var backend caryatid.CaryatidBackend
 	backend, err = caryatid.NewBackendFromUri(pp.config.CatalogUri)
 	if err != nil {
-		log.Printf("PostProcess(): Error trying to get backend: %v\n", err)
+		caryatid.LogErrorf("PostProcess(): Error trying to get backend: %v\n", err)
 		return
 	}
 	manager := caryatid.NewBackendManager(pp.config.CatalogUri, &backend)
+	manager.AllowNonstandardVersion = pp.config.AllowNonstandardVersion
+	manager.Overwrite = pp.config.Overwrite
+	manager.LatestAlias = pp.config.LatestAlias
+	manager.VerifyAfterCopy = pp.config.VerifyAfterCopy
+	manager.UrlPrefix = pp.config.UrlPrefix
+	manager.RelativeUrls = pp.config.RelativeUrls
+	manager.FilenameTemplate = pp.config.FilenameTemplate
+	manager.AuditLogPath = pp.config.AuditLog
+	manager.AuditLogRequired = pp.config.AuditLogRequired
+	manager.WebhookUrl = pp.config.Webhook
+	if pp.config.WebhookTimeout != "" {
+		// Configure() already checked that this parses
+		manager.WebhookTimeout, _ = time.ParseDuration(pp.config.WebhookTimeout)
+	}
+	manager.UploadPartSize = pp.config.PartSize
+	// Configure() already checked that these parse
+	manager.FileMode, _ = caryatid.ParseFileMode(pp.config.FileMode)
+	manager.DirMode, _ = caryatid.ParseDirMode(pp.config.DirMode)
+	if pp.config.UpdateIndex != "" {
+		var indexBackend caryatid.CaryatidBackend
+		if indexBackend, err = caryatid.NewBackendFromUri(pp.config.UpdateIndex); err != nil {
+			caryatid.LogErrorf("PostProcess(): Error trying to get backend for update_index: %v\n", err)
+			return
+		}
+		manager.IndexManager = caryatid.NewBackendManager(pp.config.UpdateIndex, &indexBackend)
+		manager.IndexManager.FileMode = manager.FileMode
+		manager.IndexManager.DirMode = manager.DirMode
+	}
+
+	boxArtifact.Name = pp.config.Name
+	boxArtifact.DisplayName = pp.config.DisplayName
+	boxArtifact.Description = pp.config.Description
+	boxArtifact.Version = pp.config.Version
+	boxArtifact.VersionDescription = pp.config.VersionDescription
 
-	err = manager.AddBox(inBoxFile, pp.config.Name, pp.config.Description, pp.config.Version, provider, digestType, digest)
+	err = manager.AddBox(boxArtifact)
 	if err != nil {
-		log.Printf("PostProcess(): Error adding box metadata to catalog: %v\n", err)
+		caryatid.LogErrorf("PostProcess(): Error adding box metadata to catalog: %v\n", err)
 		return
 	}
-	log.Println("PostProcess(): New box added to backend")
+	caryatid.LogInfof("PostProcess(): New box added to backend\n")
 
 	catalog, err := manager.GetCatalog()
 	if err != nil {
-		log.Printf("PostProcess(): Error getting catalog: %v\n", err)
+		caryatid.LogErrorf("PostProcess(): Error getting catalog: %v\n", err)
 		return
 	}
-	log.Printf("PostProcess(): New catalog is:\n%v\n", catalog)
+	caryatid.LogDebugf("PostProcess(): New catalog is:\n%v\n", catalog)
 
 	packerArtifact = &CaryatidOutputArtifact{
-		CatalogUri:   fmt.Sprintf("%v/%v.json", pp.config.CatalogUri, pp.config.Name),
+		CatalogUri:   pp.config.CatalogUri,
 		Description:  pp.config.Description,
 		Version:      pp.config.Version,
-		Provider:     provider,
-		ChecksumType: digestType,
-		Checksum:     digest,
+		Provider:     boxArtifact.Provider,
+		ChecksumType: boxArtifact.ChecksumType,
+		Checksum:     boxArtifact.Checksum,
 	}
 
 	return
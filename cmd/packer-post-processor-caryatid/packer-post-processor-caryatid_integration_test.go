@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -74,6 +75,7 @@ func TestPostProcess(t *testing.T) {
 	pp.config.KeepInputArtifact = inkeepinput
 	pp.config.Name = testBoxName
 	pp.config.Version = "6.6.6"
+	pp.config.ChecksumType = "sha256"
 
 	// Set up test: write files etc
 	err = caryatid.CreateTestBoxFile(testArtifactPath, testProviderName, true)
@@ -92,8 +94,20 @@ func TestPostProcess(t *testing.T) {
 	if outArt.BuilderId() != BuilderId {
 		t.Fatal("BuildId does not match")
 	}
+	if catalogUri := outArt.(*CaryatidOutputArtifact).CatalogUri; catalogUri != pp.config.CatalogUri {
+		t.Fatal(fmt.Sprintf("Expected the output artifact to refer to the catalog '%v', but it refers to '%v'", pp.config.CatalogUri, catalogUri))
+	}
 
-	expectedCatalogStr := fmt.Sprintf(`{"name":"TestBoxName","description":"Test box description","versions":[{"version":"6.6.6","providers":[{"name":"TestProvider","url":"file://%v/TestBoxName/TestBoxName_6.6.6_TestProvider.box","checksum_type":"sha1","checksum":"2cca98d0ecfd03d57a3106950e14d724797f0836"}]}]}`, integrationTestDir)
+	// The test box is gzipped, and gzip output can vary between Go versions, so we can't hardcode its digest
+	expectedDigest, err := util.HashFile(testArtifactPath, sha256.New())
+	if err != nil {
+		t.Fatal("Failed to calculate sha256 of ", testArtifactPath)
+	}
+	testArtifactInfo, err := os.Stat(testArtifactPath)
+	if err != nil {
+		t.Fatal("Failed to stat ", testArtifactPath)
+	}
+	expectedCatalogStr := fmt.Sprintf(`{"name":"TestBoxName","description":"Test box description","versions":[{"version":"6.6.6","providers":[{"name":"TestProvider","url":"file://%v/TestBoxName/TestBoxName_6.6.6_TestProvider.box","checksum_type":"sha256","checksum":"%v","size":%v}]}]}`, integrationTestDir, expectedDigest, testArtifactInfo.Size())
 	resultCatalogPath := path.Join(integrationTestDir, fmt.Sprintf("%v.json", testBoxName))
 	resultCatalogData, err := ioutil.ReadFile(resultCatalogPath)
 	if err != nil {
@@ -109,6 +123,14 @@ func TestPostProcess(t *testing.T) {
 	if err = json.Unmarshal(resultCatalogData, &resultCatalog); err != nil {
 		t.Fatal("Unable to unmarshal result catalog")
 	}
+	// The release timestamp depends on when the test runs, so check that it is set, and then ignore it
+	if len(resultCatalog.Versions) == 1 && len(resultCatalog.Versions[0].Providers) == 1 {
+		resultProvider := &resultCatalog.Versions[0].Providers[0]
+		if _, ok := resultProvider.ReleasedTime(); !ok {
+			t.Fatal(fmt.Sprintf("Expected a release timestamp, but got '%v'", resultProvider.ReleasedAt))
+		}
+		resultProvider.ReleasedAt = ""
+	}
 	if !expectedCatalog.Equals(&resultCatalog) {
 		t.Fatal(fmt.Sprintf("Catalog data did not match expectations\n\tExpected: %v\n\tResult:   %v", expectedCatalog, resultCatalog))
 	}
@@ -126,3 +148,35 @@ func TestPostProcess(t *testing.T) {
 		t.Fatal(fmt.Sprintf("Copying %v to %v failed... files are not identical", testArtifactPath, copiedBoxPath))
 	}
 }
+
+func TestPostProcessKeepInputArtifact(t *testing.T) {
+	testArtifactPath := path.Join(integrationTestDir, "KeepInputBox_TestProvider.box")
+	if err := caryatid.CreateTestBoxFile(testArtifactPath, "TestProvider", true); err != nil {
+		t.Fatal(fmt.Sprintf("Error trying to write input artifact file: %v", err))
+	}
+
+	testCases := []struct {
+		Version string
+		Keep    bool
+	}{
+		{"1.0.0", false},
+		{"1.0.1", true},
+	}
+	for _, tc := range testCases {
+		pp := CaryatidPostProcessor{}
+		pp.config.CatalogUri = fmt.Sprintf("file://%v/KeepInputBox.json", integrationTestDir)
+		pp.config.Name = "KeepInputBox"
+		pp.config.Description = "Test box description"
+		pp.config.Version = tc.Version
+		pp.config.KeepInputArtifact = tc.Keep
+
+		inartifact := &packer.MockArtifact{FilesValue: []string{testArtifactPath}}
+		_, keepResult, err := pp.PostProcess(&packer.BasicUi{}, inartifact)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Error during PostProcess(): %v", err))
+		}
+		if keepResult != tc.Keep {
+			t.Fatal(fmt.Sprintf("Expected PostProcess() to return keep_input_artifact %v, but it returned %v", tc.Keep, keepResult))
+		}
+	}
+}
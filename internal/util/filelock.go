@@ -0,0 +1,15 @@
+package util
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrLocked is returned by TryLockFile when another process holds the lock
+var ErrLocked = errors.New("The file is locked by another process")
+
+// FileLock is an advisory lock on a lockfile, taken with TryLockFile
+type FileLock struct {
+	path string
+	file *os.File
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// TryLockFile takes an exclusive flock() on the lockfile at path, creating it if necessary
+// It does not wait; if another process holds the lock, it returns ErrLocked
+// The lock is released when the process exits, so a crashed process never leaves a stale lock behind
+func TryLockFile(path string) (lock *FileLock, err error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			err = ErrLocked
+		}
+		return
+	}
+	lock = &FileLock{path: path, file: file}
+	return
+}
+
+// Unlock releases the lock
+// The lockfile is left in place, because removing it would let another process lock a file that a third process has already opened
+func (lock *FileLock) Unlock() (err error) {
+	if err = syscall.Flock(int(lock.file.Fd()), syscall.LOCK_UN); err != nil {
+		lock.file.Close()
+		return
+	}
+	err = lock.file.Close()
+	return
+}
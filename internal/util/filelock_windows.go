@@ -0,0 +1,33 @@
+//go:build windows
+// +build windows
+
+package util
+
+import (
+	"os"
+)
+
+// TryLockFile takes a lock by creating the lockfile at path, which must not already exist
+// It does not wait; if the lockfile exists, it returns ErrLocked
+// Unlike on other platforms, a process that crashes while holding the lock leaves the lockfile behind,
+// and it must be removed by hand
+func TryLockFile(path string) (lock *FileLock, err error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		err = ErrLocked
+		return
+	} else if err != nil {
+		return
+	}
+	lock = &FileLock{path: path, file: file}
+	return
+}
+
+// Unlock releases the lock by removing the lockfile
+func (lock *FileLock) Unlock() (err error) {
+	if err = lock.file.Close(); err != nil {
+		return
+	}
+	err = os.Remove(lock.path)
+	return
+}
@@ -5,12 +5,60 @@ This package contains generic functions used by caryatid. It should not be used
 package util
 
 import (
+	"bufio"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"fmt"
+	"hash"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
 )
 
+// A counter used to generate unique temporary file names
+var tempFileCounter uint64
+
+// The temporary files of writes that AtomicWriteFile() has not finished, guarded by pendingTempFilesLock
+// See RemovePendingTempFiles()
+var (
+	pendingTempFiles     = make(map[string]bool)
+	pendingTempFilesLock sync.Mutex
+)
+
+func setTempFilePending(tmpPath string, pending bool) {
+	pendingTempFilesLock.Lock()
+	defer pendingTempFilesLock.Unlock()
+	if pending {
+		pendingTempFiles[tmpPath] = true
+	} else {
+		delete(pendingTempFiles, tmpPath)
+	}
+}
+
+// RemovePendingTempFiles removes the temporary files of every AtomicWriteFile() that has not finished, returning their paths
+// A process that is about to exit without letting them finish, like on an interrupt, should call it,
+// so that partially written files are not left behind; the writes themselves then fail
+func RemovePendingTempFiles() (removed []string) {
+	pendingTempFilesLock.Lock()
+	defer pendingTempFilesLock.Unlock()
+	for tmpPath := range pendingTempFiles {
+		if err := os.Remove(tmpPath); err == nil {
+			removed = append(removed, tmpPath)
+		}
+		delete(pendingTempFiles, tmpPath)
+	}
+	sort.Strings(removed)
+	return
+}
+
 // PathExists tests whether path exists
 // Note that Stat() may return other errors, which we do not check for
 func PathExists(path string) bool {
@@ -20,42 +68,237 @@ func PathExists(path string) bool {
 
 // Sha1sum returns the SHA1 hash for a file on the filesystem
 func Sha1sum(filePath string) (result string, err error) {
+	return HashFile(filePath, sha1.New())
+}
+
+// HashFile returns the hex digest of a file on the filesystem, computed with the hasher it is passed
+func HashFile(filePath string, hasher hash.Hash) (result string, err error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return
 	}
 	defer file.Close()
 
-	hash := sha1.New()
-	_, err = io.Copy(hash, file)
+	return HashReader(file, hasher)
+}
+
+// HashReader returns the hex digest of everything read from reader, computed with the hasher it is passed
+// The reader is streamed through the hasher, so memory use does not depend on how much is read
+func HashReader(reader io.Reader, hasher hash.Hash) (result string, err error) {
+	_, err = io.Copy(hasher, reader)
 	if err != nil {
 		return
 	}
 
-	result = hex.EncodeToString(hash.Sum(nil))
+	result = hex.EncodeToString(hasher.Sum(nil))
 	return
 }
 
 // CopyFile copies a file
+// The copy is streamed, so memory use does not depend on the size of the file,
+// and written atomically, so that dst is never left partially written; see AtomicWriteFile()
 func CopyFile(src string, dst string) (written int64, err error) {
+	return CopyFileMode(src, dst, 0)
+}
+
+// CopyFileMode is like CopyFile(), but gives dst the permissions in mode, unless mode is 0; see AtomicWriteFileMode()
+func CopyFileMode(src string, dst string, mode os.FileMode) (written int64, err error) {
 	in, err := os.Open(src)
-	defer in.Close()
 	if err != nil {
 		return
 	}
-	out, err := os.Create(dst)
-	defer out.Close()
+	defer in.Close()
+	written, err = AtomicWriteFileMode(dst, in, mode)
+	return
+}
+
+// AtomicWriteFile streams the contents of a reader to dst
+// It writes to a temporary file in the same directory as dst and renames it to dst only on success,
+// so if anything fails, dst is left untouched and the temporary file is removed.
+// If the process is interrupted instead, RemovePendingTempFiles() removes the temporary file.
+// New files get the default permissions (honoring umask);
+// if dst already exists, its permissions are kept.
+func AtomicWriteFile(dst string, src io.Reader) (written int64, err error) {
+	return AtomicWriteFileMode(dst, src, 0)
+}
+
+// AtomicWriteFileMode is like AtomicWriteFile(), but gives dst the permissions in mode, regardless of umask or its existing permissions
+// If mode is 0, it behaves exactly like AtomicWriteFile()
+func AtomicWriteFileMode(dst string, src io.Reader, mode os.FileMode) (written int64, err error) {
+	dir, base := filepath.Split(dst)
+	var (
+		out     *os.File
+		tmpPath string
+	)
+	for attempt := 0; attempt < 100; attempt++ {
+		tmpPath = filepath.Join(dir, fmt.Sprintf(".%v.tmp%v-%v", base, os.Getpid(), atomic.AddUint64(&tempFileCounter, 1)))
+		out, err = os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			break
+		}
+	}
 	if err != nil {
 		return
 	}
-	written, err = io.Copy(out, in)
+
+	setTempFilePending(tmpPath, true)
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmpPath)
+		}
+		setTempFilePending(tmpPath, false)
+	}()
+
+	if mode != 0 {
+		if err = out.Chmod(mode); err != nil {
+			return
+		}
+	} else if info, statErr := os.Stat(dst); statErr == nil {
+		if err = out.Chmod(info.Mode()); err != nil {
+			return
+		}
+	}
+
+	buffered := bufio.NewWriterSize(out, 1024*1024)
+	if written, err = io.Copy(buffered, src); err != nil {
+		return
+	}
+	if err = buffered.Flush(); err != nil {
+		return
+	}
+	if err = out.Close(); err != nil {
+		return
+	}
+	err = os.Rename(tmpPath, dst)
+	return
+}
+
+// MkdirAllMode is like os.MkdirAll(), but gives each directory it creates the permissions in mode, regardless of umask
+// Directories that already exist are not changed
+// If mode is 0, the directories it creates get 0777 less the umask, like os.MkdirAll(path, 0777)
+func MkdirAllMode(path string, mode os.FileMode) (err error) {
+	if mode == 0 {
+		return os.MkdirAll(path, 0777)
+	}
+	missing := []string{}
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, statErr := os.Stat(dir); statErr == nil || !os.IsNotExist(statErr) {
+			break
+		}
+		missing = append(missing, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	if err = os.MkdirAll(path, mode); err != nil {
+		return
+	}
+	for _, dir := range missing {
+		if err = os.Chmod(dir, mode); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// AtomicSymlink creates a symbolic link at dst that points to target, replacing any file already at dst
+// Like AtomicWriteFile(), it creates the link under a temporary name in the same directory and renames it to dst,
+// so dst is never missing while it is replaced
+func AtomicSymlink(target string, dst string) (err error) {
+	dir, base := filepath.Split(dst)
+	var tmpPath string
+	for attempt := 0; attempt < 100; attempt++ {
+		tmpPath = filepath.Join(dir, fmt.Sprintf(".%v.tmp%v-%v", base, os.Getpid(), atomic.AddUint64(&tempFileCounter, 1)))
+		err = os.Symlink(target, tmpPath)
+		if !os.IsExist(err) {
+			break
+		}
+	}
 	if err != nil {
 		return
 	}
-	err = out.Close()
+	if err = os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+	}
+	return
+}
+
+// ContextReader wraps a reader so that it fails with the error of Context once Context is done,
+// which stops a copy partway through without closing the reader underneath
+type ContextReader struct {
+	Context context.Context
+	Reader  io.Reader
+}
+
+func (cr *ContextReader) Read(p []byte) (n int, err error) {
+	if err = cr.Context.Err(); err != nil {
+		return
+	}
+	return cr.Reader.Read(p)
+}
+
+// ProgressReader wraps a reader and calls Progress after each read
+// Total is the expected number of bytes, or a number <= 0 if that is unknown
+type ProgressReader struct {
+	Reader      io.Reader
+	Total       int64
+	Progress    func(transferred int64, total int64)
+	transferred int64
+}
+
+func (pr *ProgressReader) Read(p []byte) (n int, err error) {
+	n, err = pr.Reader.Read(p)
+	pr.transferred += int64(n)
+	if n > 0 || err == io.EOF {
+		pr.Progress(pr.transferred, pr.Total)
+	}
 	return
 }
 
+// FormatSize returns a human-readable representation of a number of bytes, like "1.5 GiB"
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%v B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 5; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// sizePattern matches the sizes that ParseSize() accepts
+var sizePattern = regexp.MustCompile(`^\s*([0-9]+(?:\.[0-9]+)?)\s*([A-Za-z]*)\s*$`)
+
+// sizeUnits are the units that ParseSize() accepts, in lower case
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+	"k": 1 << 10, "m": 1 << 20, "g": 1 << 30, "t": 1 << 40,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+}
+
+// ParseSize parses a number of bytes written for people to read, like "50GB" or "1.5 GiB"
+// KB, MB, GB, and TB are powers of 1000; KiB, MiB, GiB, and TiB, or just K, M, G, and T, are powers of 1024; a plain number is bytes
+func ParseSize(size string) (bytes int64, err error) {
+	match := sizePattern.FindStringSubmatch(size)
+	if match == nil {
+		return 0, fmt.Errorf("Invalid size '%v'; expected a number of bytes, optionally followed by a unit like GB or GiB", size)
+	}
+	multiplier, ok := sizeUnits[strings.ToLower(match[2])]
+	if !ok {
+		return 0, fmt.Errorf("Invalid size '%v'; unknown unit '%v'", size, match[2])
+	}
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid size '%v': %v", size, err)
+	}
+	return int64(number * multiplier), nil
+}
+
 // StringInSlice tests whether a string is in a slice of strings
 func StringInSlice(slice []string, str string) bool {
 	for _, item := range slice {
@@ -65,3 +308,49 @@ func StringInSlice(slice []string, str string) bool {
 	}
 	return false
 }
+
+// wideRanges are the ranges of characters that take up two columns in a terminal,
+// like CJK ideographs, Hangul, fullwidth forms, and most emoji
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F}, {0x2E80, 0x303E}, {0x3041, 0x33FF}, {0x3400, 0x4DBF}, {0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF}, {0xAC00, 0xD7A3}, {0xF900, 0xFAFF}, {0xFE30, 0xFE4F}, {0xFF00, 0xFF60},
+	{0xFFE0, 0xFFE6}, {0x1F300, 0x1F64F}, {0x1F900, 0x1F9FF}, {0x20000, 0x3FFFD},
+}
+
+// RuneWidth returns the number of columns a character takes up in a terminal
+// Wide characters take two columns, and combining marks and other invisible characters take none
+func RuneWidth(r rune) int {
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	for _, wide := range wideRanges {
+		if r >= wide[0] && r <= wide[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+// StringWidth returns the number of columns a string takes up in a terminal; see RuneWidth()
+func StringWidth(str string) (width int) {
+	for _, r := range str {
+		width += RuneWidth(r)
+	}
+	return
+}
+
+// TruncateString shortens a string to at most width columns, replacing the end of the string with '…' if it is too long
+func TruncateString(str string, width int) string {
+	if StringWidth(str) <= width {
+		return str
+	}
+	result, used := "", 0
+	for _, r := range str {
+		if used+RuneWidth(r) > width-1 {
+			break
+		}
+		result += string(r)
+		used += RuneWidth(r)
+	}
+	return result + "…"
+}
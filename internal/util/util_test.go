@@ -0,0 +1,227 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingReader returns some data, and then an error
+type failingReader struct {
+	remaining int
+}
+
+func (r *failingReader) Read(p []byte) (n int, err error) {
+	if r.remaining <= 0 {
+		return 0, fmt.Errorf("Simulated read failure")
+	}
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	for idx := range p {
+		p[idx] = 'x'
+	}
+	r.remaining -= len(p)
+	return len(p), nil
+}
+
+func TestAtomicWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caryatid-util-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "destination.box")
+
+	// A failed write must not create the destination or leave a temp file behind
+	if _, err = AtomicWriteFile(dst, &failingReader{remaining: 3 * 1024 * 1024}); err == nil {
+		t.Fatalf("AtomicWriteFile() with a failing reader should have returned an error\n")
+	}
+	if PathExists(dst) {
+		t.Fatalf("AtomicWriteFile() created the destination file even though the write failed\n")
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("AtomicWriteFile() left %v file(s) behind after a failed write, including '%v'\n", len(entries), entries[0].Name())
+	}
+
+	// A successful write replaces the destination
+	contents := []byte("some box contents")
+	written, err := AtomicWriteFile(dst, bytes.NewReader(contents))
+	if err != nil {
+		t.Fatalf("AtomicWriteFile() returned an unexpected error: %v\n", err)
+	}
+	if written != int64(len(contents)) {
+		t.Fatalf("AtomicWriteFile() reported writing %v bytes, but expected %v\n", written, len(contents))
+	}
+
+	// A failed overwrite must leave the previous contents intact
+	if _, err = AtomicWriteFile(dst, io.MultiReader(bytes.NewReader([]byte("new")), &failingReader{})); err == nil {
+		t.Fatalf("AtomicWriteFile() with a failing reader should have returned an error\n")
+	}
+	result, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("Error reading destination file: %v\n", err)
+	}
+	if !bytes.Equal(result, contents) {
+		t.Fatalf("Destination file contents were '%v' but expected '%v'\n", string(result), string(contents))
+	}
+}
+
+// interruptingReader returns some data, and then calls Interrupt before returning the rest
+type interruptingReader struct {
+	Reader    io.Reader
+	After     int
+	Interrupt func()
+	read      int
+}
+
+func (r *interruptingReader) Read(p []byte) (n int, err error) {
+	if r.read < r.After && r.read+len(p) >= r.After {
+		r.Interrupt()
+	}
+	n, err = r.Reader.Read(p)
+	r.read += n
+	return
+}
+
+func TestRemovePendingTempFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caryatid-util-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "destination.box")
+
+	var removed []string
+	reader := &interruptingReader{
+		Reader:    bytes.NewReader(bytes.Repeat([]byte("x"), 4*1024*1024)),
+		After:     2 * 1024 * 1024,
+		Interrupt: func() { removed = RemovePendingTempFiles() },
+	}
+	if _, err = AtomicWriteFile(dst, reader); err == nil {
+		t.Fatalf("AtomicWriteFile() should have failed after its temporary file was removed\n")
+	}
+	if len(removed) != 1 || filepath.Dir(removed[0]) != dir {
+		t.Fatalf("Expected RemovePendingTempFiles() to remove one temporary file in '%v', but it removed %v\n", dir, removed)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("An interrupted AtomicWriteFile() left %v file(s) behind, including '%v'\n", len(entries), entries[0].Name())
+	}
+
+	// Finished writes are not pending, so nothing is removed
+	if _, err = AtomicWriteFile(dst, bytes.NewReader([]byte("complete"))); err != nil {
+		t.Fatalf("AtomicWriteFile() returned an unexpected error: %v\n", err)
+	}
+	if removed = RemovePendingTempFiles(); len(removed) != 0 {
+		t.Fatalf("Expected RemovePendingTempFiles() to remove nothing once writes have finished, but it removed %v\n", removed)
+	}
+	if !PathExists(dst) {
+		t.Fatalf("RemovePendingTempFiles() removed a finished file\n")
+	}
+}
+
+func TestTryLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caryatid-util-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	lockPath := filepath.Join(dir, "catalog.json.lock")
+
+	lock, err := TryLockFile(lockPath)
+	if err != nil {
+		t.Fatalf("TryLockFile() returned an unexpected error: %v\n", err)
+	}
+	if _, err = TryLockFile(lockPath); err != ErrLocked {
+		t.Fatalf("TryLockFile() on a locked file should have returned ErrLocked, but returned %v\n", err)
+	}
+	if err = lock.Unlock(); err != nil {
+		t.Fatalf("Unlock() returned an unexpected error: %v\n", err)
+	}
+
+	lock, err = TryLockFile(lockPath)
+	if err != nil {
+		t.Fatalf("TryLockFile() after Unlock() returned an unexpected error: %v\n", err)
+	}
+	lock.Unlock()
+}
+
+func TestFormatSize(t *testing.T) {
+	type TestCase struct {
+		Bytes    int64
+		Expected string
+	}
+	testCases := []TestCase{
+		TestCase{0, "0 B"},
+		TestCase{1023, "1023 B"},
+		TestCase{1024, "1.0 KiB"},
+		TestCase{1536, "1.5 KiB"},
+		TestCase{5 * 1024 * 1024 * 1024, "5.0 GiB"},
+	}
+	for _, tc := range testCases {
+		if result := FormatSize(tc.Bytes); result != tc.Expected {
+			t.Fatalf("FormatSize(%v) returned '%v', but expected '%v'\n", tc.Bytes, result, tc.Expected)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	type TestCase struct {
+		Size        string
+		Expected    int64
+		ExpectError bool
+	}
+	testCases := []TestCase{
+		TestCase{"1048576", 1048576, false},
+		TestCase{"512B", 512, false},
+		TestCase{"50GB", 50 * 1000 * 1000 * 1000, false},
+		TestCase{"50 gb", 50 * 1000 * 1000 * 1000, false},
+		TestCase{"1.5GiB", 1536 * 1024 * 1024, false},
+		TestCase{"2G", 2 * 1024 * 1024 * 1024, false},
+		TestCase{"10MiB", 10 * 1024 * 1024, false},
+		TestCase{"1TB", 1000 * 1000 * 1000 * 1000, false},
+		TestCase{"", 0, true},
+		TestCase{"GB", 0, true},
+		TestCase{"-5GB", 0, true},
+		TestCase{"50 furlongs", 0, true},
+	}
+	for _, tc := range testCases {
+		result, err := ParseSize(tc.Size)
+		if tc.ExpectError && err == nil {
+			t.Fatalf("Expected ParseSize('%v') to return an error, but it returned %v\n", tc.Size, result)
+		} else if !tc.ExpectError && err != nil {
+			t.Fatalf("ParseSize('%v') returned an unexpected error: %v\n", tc.Size, err)
+		} else if result != tc.Expected {
+			t.Fatalf("ParseSize('%v') returned %v, but expected %v\n", tc.Size, result, tc.Expected)
+		}
+	}
+}
+
+func TestStringWidth(t *testing.T) {
+	type TestCase struct {
+		Str       string
+		Width     int
+		Truncated string
+	}
+	// Truncate everything to 6 columns
+	testCases := []TestCase{
+		TestCase{"", 0, ""},
+		TestCase{"abcdef", 6, "abcdef"},
+		TestCase{"abcdefg", 7, "abcde…"},
+		TestCase{"箱子", 4, "箱子"},
+		TestCase{"箱子箱子", 8, "箱子…"},
+		TestCase{"cafe\u0301", 4, "cafe\u0301"},
+	}
+	for _, tc := range testCases {
+		if width := StringWidth(tc.Str); width != tc.Width {
+			t.Fatalf("StringWidth('%v') returned %v, but expected %v\n", tc.Str, width, tc.Width)
+		}
+		if truncated := TruncateString(tc.Str, 6); truncated != tc.Truncated {
+			t.Fatalf("TruncateString('%v', 6) returned '%v', but expected '%v'\n", tc.Str, truncated, tc.Truncated)
+		}
+	}
+}
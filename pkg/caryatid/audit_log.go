@@ -0,0 +1,134 @@
+/*
+An append-only log of the changes made to a catalog
+*/
+
+package caryatid
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+)
+
+// AuditEntry records one box that was added to, deleted from, renamed in, tagged in, or rehashed in a catalog
+// BackendManager writes one to its AuditLogPath, as a line of JSON, for each box that a change to the catalog affects,
+// and POSTs the same JSON to its WebhookUrl
+type AuditEntry struct {
+	Timestamp    string `json:"timestamp"`
+	User         string `json:"user"`
+	Action       string `json:"action"`
+	CatalogUri   string `json:"catalog_uri"`
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Provider     string `json:"provider"`
+	Architecture string `json:"architecture,omitempty"`
+	ChecksumType string `json:"checksum_type"`
+	Checksum     string `json:"checksum"`
+}
+
+// The actions recorded in AuditEntry.Action
+const (
+	AuditActionAdd      = "add"
+	AuditActionDelete   = "delete"
+	AuditActionPrune    = "prune"
+	AuditActionRename   = "rename"
+	AuditActionTag      = "tag"
+	AuditActionChecksum = "checksum"
+)
+
+// auditUser returns the name of the user running this process, for AuditEntry.User
+func auditUser() string {
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		return current.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// auditEntries returns an AuditEntry for each provider in catalog that refs refers to
+func auditEntries(action string, catalog Catalog, refs BoxReferenceList) (entries []AuditEntry) {
+	for _, v := range catalog.Versions {
+		for _, p := range v.Providers {
+			if refs.Contains(BoxReference{Version: v.Version, ProviderName: p.Name, Architecture: p.Architecture}) {
+				entries = append(entries, newAuditEntry(action, catalog.Name, v.Version, p))
+			}
+		}
+	}
+	return
+}
+
+// newAuditEntry returns an AuditEntry for a provider, without the fields that recordChanges() fills in
+func newAuditEntry(action string, name string, version string, provider Provider) AuditEntry {
+	return AuditEntry{
+		Action:       action,
+		Name:         name,
+		Version:      version,
+		Provider:     provider.Name,
+		Architecture: provider.Architecture,
+		ChecksumType: provider.ChecksumType,
+		Checksum:     provider.Checksum,
+	}
+}
+
+// recordChanges fills in the fields of entries that describe this change as a whole, like the time,
+// and then writes them to the audit log and the webhook; see writeAuditLog() and postWebhook()
+// It also rebuilds the catalog index of bm.IndexManager, if it is set, logging any failure
+// Methods that change the catalog call it once the catalog is saved and the backend is cleaned up, even if cleaning up failed
+func (bm *BackendManager) recordChanges(entries []AuditEntry) (err error) {
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	username := auditUser()
+	for idx := range entries {
+		entries[idx].Timestamp = timestamp
+		entries[idx].User = username
+		entries[idx].CatalogUri = bm.CatalogUri
+	}
+	err = bm.writeAuditLog(entries)
+	bm.postWebhook(entries)
+	if bm.IndexManager != nil {
+		if _, indexErr := bm.IndexManager.WriteCatalogIndex(); indexErr != nil {
+			bm.log().Warnf("recordChanges(): Could not update the catalog index under '%v': %v; continuing anyway\n", bm.IndexManager.CatalogUri, indexErr)
+		}
+	}
+	return
+}
+
+// writeAuditLog appends entries to the file at bm.AuditLogPath, if it is set
+// If the entries cannot be written, the error is only logged, unless bm.AuditLogRequired is set
+func (bm *BackendManager) writeAuditLog(entries []AuditEntry) (err error) {
+	if bm.AuditLogPath == "" || len(entries) == 0 {
+		return
+	}
+
+	lines := []byte{}
+	for _, entry := range entries {
+		line, marshalErr := json.Marshal(entry)
+		if marshalErr != nil {
+			err = marshalErr
+			break
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	if err == nil {
+		var logFile *os.File
+		if logFile, err = os.OpenFile(bm.AuditLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err == nil {
+			_, err = logFile.Write(lines)
+			if closeErr := logFile.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+
+	if err != nil {
+		err = fmt.Errorf("Could not write to the audit log at '%v': %v", bm.AuditLogPath, err)
+		if !bm.AuditLogRequired {
+			bm.log().Warnf("writeAuditLog(): %v; continuing anyway\n", err)
+			err = nil
+		}
+	}
+	return
+}
@@ -0,0 +1,152 @@
+package caryatid
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readAuditLog returns the entries in an audit log, and fails the test if any line is not a valid AuditEntry
+func readAuditLog(t *testing.T, auditLogPath string) (entries []AuditEntry) {
+	file, err := os.Open(auditLogPath)
+	if err != nil {
+		t.Fatalf("Error opening audit log: %v\n", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		decoder := json.NewDecoder(strings.NewReader(scanner.Text()))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&entry); err != nil {
+			t.Fatalf("Audit log line '%v' is not a valid AuditEntry: %v\n", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+func TestBackendManagerAuditLog(t *testing.T) {
+	var (
+		boxName      = "AuditBox"
+		boxProvider  = "StrongSapling"
+		boxPath      = path.Join(integrationTestDir, "incoming-TestBackendManagerAuditLog.box")
+		catalogUri   = fmt.Sprintf("mem://TestBackendManagerAuditLog/%v.json", boxName)
+		auditLogPath = path.Join(integrationTestDir, "TestBackendManagerAuditLog.jsonl")
+	)
+
+	os.Remove(auditLogPath)
+	if err := CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	manager.AuditLogPath = auditLogPath
+
+	before := time.Now().UTC().Add(-time.Second)
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: version, Provider: boxProvider, Architecture: "amd64", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+		}
+	}
+	if _, err = manager.DeleteBox(CatalogQueryParams{Version: "1.0.0"}); err != nil {
+		t.Fatalf("DeleteBox() returned an unexpected error: %v\n", err)
+	}
+	if _, err = manager.RenameCatalog("RenamedAuditBox"); err != nil {
+		t.Fatalf("RenameCatalog() returned an unexpected error: %v\n", err)
+	}
+
+	entries := readAuditLog(t, auditLogPath)
+	expected := []AuditEntry{
+		AuditEntry{Action: AuditActionAdd, Name: boxName, Version: "1.0.0"},
+		AuditEntry{Action: AuditActionAdd, Name: boxName, Version: "2.0.0"},
+		AuditEntry{Action: AuditActionDelete, Name: boxName, Version: "1.0.0"},
+		AuditEntry{Action: AuditActionRename, Name: "RenamedAuditBox", Version: "2.0.0"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %v audit log entries, but got %v: %+v\n", len(expected), len(entries), entries)
+	}
+	for idx, entry := range entries {
+		if entry.Action != expected[idx].Action || entry.Name != expected[idx].Name || entry.Version != expected[idx].Version {
+			t.Fatalf("Expected audit log entry %v to be %+v, but got %+v\n", idx, expected[idx], entry)
+		}
+		if entry.Provider != boxProvider || entry.Architecture != "amd64" || entry.ChecksumType != "sha256" || entry.Checksum != "0xB00B1E5" || entry.CatalogUri != catalogUri {
+			t.Fatalf("Audit log entry %v does not describe the box: %+v\n", idx, entry)
+		}
+		timestamp, err := time.Parse(time.RFC3339, entry.Timestamp)
+		if err != nil || timestamp.Before(before) {
+			t.Fatalf("Audit log entry %v has an unexpected timestamp '%v'\n", idx, entry.Timestamp)
+		}
+	}
+
+	// A log that cannot be written does not stop the catalog from changing, unless it is required
+	manager.AuditLogPath = path.Join(integrationTestDir, "TestBackendManagerAuditLog", "missing", "audit.jsonl")
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: "RenamedAuditBox", Description: "A test box", Version: "3.0.0", Provider: boxProvider}); err != nil {
+		t.Fatalf("AddBox() failed because of an unwritable audit log: %v\n", err)
+	}
+	manager.AuditLogRequired = true
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: "RenamedAuditBox", Description: "A test box", Version: "4.0.0", Provider: boxProvider}); err == nil {
+		t.Fatalf("AddBox() succeeded even though the required audit log could not be written\n")
+	}
+
+	// The audit log is written last, so a failure to write it does not leave deleted boxes' files behind
+	boxUri, _ := BoxUriFromCatalogUri(catalogUri, "RenamedAuditBox", "3.0.0", boxProvider)
+	if _, err = manager.DeleteBox(CatalogQueryParams{Version: "3.0.0"}); err == nil {
+		t.Fatalf("DeleteBox() succeeded even though the required audit log could not be written\n")
+	}
+	if backend.(*CaryatidMemoryBackend).FileExists(boxUri) {
+		t.Fatalf("DeleteBox() left the box file at '%v' behind because the audit log could not be written\n", boxUri)
+	}
+}
+
+// Merged boxes are recorded as added to the destination catalog
+func TestBackendManagerAuditLogMerge(t *testing.T) {
+	var (
+		boxName      = "MergeAuditBox"
+		boxPath      = path.Join(integrationTestDir, "incoming-TestBackendManagerAuditLogMerge.box")
+		destUri      = fmt.Sprintf("mem://TestBackendManagerAuditLogMerge/dest/%v.json", boxName)
+		sourceUri    = fmt.Sprintf("mem://TestBackendManagerAuditLogMerge/source/%v.json", boxName)
+		auditLogPath = path.Join(integrationTestDir, "TestBackendManagerAuditLogMerge.jsonl")
+	)
+
+	os.Remove(auditLogPath)
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	destBackend, err := NewBackendFromUri(destUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	dest := NewBackendManager(destUri, &destBackend)
+	sourceBackend, err := NewBackendFromUri(sourceUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	source := NewBackendManager(sourceUri, &sourceBackend)
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		if err = source.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xDEC0DE"}); err != nil {
+			t.Fatalf("Error adding box to source catalog: %v\n", err)
+		}
+	}
+
+	dest.AuditLogPath = auditLogPath
+	merged, err := dest.MergeCatalog(source, true, false)
+	if err != nil {
+		t.Fatalf("MergeCatalog() returned an unexpected error: %v\n", err)
+	}
+	if len(merged) != 2 {
+		t.Fatalf("Expected 2 merged boxes, but got %v\n", merged)
+	}
+	entries := readAuditLog(t, auditLogPath)
+	if len(entries) != 2 || entries[0].Action != AuditActionAdd || entries[0].Checksum != "0xDEC0DE" || entries[0].CatalogUri != destUri {
+		t.Fatalf("Expected the merged boxes in the audit log, but got %+v\n", entries)
+	}
+}
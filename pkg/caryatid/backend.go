@@ -4,6 +4,74 @@ The interface for a Caryatid backend
 
 package caryatid
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrCatalogLocked is returned by CaryatidLockingBackend.LockCatalog() when another process holds the lock
+var ErrCatalogLocked = errors.New("The catalog is locked by another process")
+
+// These errors are wrapped by the errors that backends and the BackendManager return for common failures,
+// so that callers can tell them apart with errors.Is()
+var (
+	// ErrCatalogNotFound means that there is no catalog at the catalog URI yet
+	ErrCatalogNotFound = errors.New("The catalog does not exist")
+
+	// ErrBoxNotFound means that there is no box file at a URI
+	ErrBoxNotFound = errors.New("The box file does not exist")
+
+	// ErrBackendReadOnly means that the backend refused to write or delete a file
+	ErrBackendReadOnly = errors.New("The backend is read-only")
+
+	// ErrBoxExists means that the catalog already has a box with the same version, provider, and architecture, but a different checksum
+	// See BackendManager.Overwrite
+	ErrBoxExists = errors.New("The box is already in the catalog")
+)
+
+// catalogNotFoundError returns an error wrapping ErrCatalogNotFound for a catalog URI
+func catalogNotFoundError(catalogUri string) error {
+	return fmt.Errorf("No catalog at '%v': %w", catalogUri, ErrCatalogNotFound)
+}
+
+// boxNotFoundError wraps an error from a backend that could not find a box file
+// errors.Is() matches both ErrBoxNotFound and the wrapped error, such as os.ErrNotExist
+type boxNotFoundError struct {
+	Uri string
+	Err error
+}
+
+func (e *boxNotFoundError) Error() string {
+	return fmt.Sprintf("No box file at '%v': %v", e.Uri, e.Err)
+}
+
+func (e *boxNotFoundError) Is(target error) bool {
+	return target == ErrBoxNotFound
+}
+
+func (e *boxNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// backendReadOnlyError wraps an error from a backend that refused a write in an error that also matches ErrBackendReadOnly
+type backendReadOnlyError struct {
+	Err error
+}
+
+func (e *backendReadOnlyError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrBackendReadOnly, e.Err)
+}
+
+func (e *backendReadOnlyError) Is(target error) bool {
+	return target == ErrBackendReadOnly
+}
+
+func (e *backendReadOnlyError) Unwrap() error {
+	return e.Err
+}
+
 type CaryatidBackend interface {
 	// Set the manager to an internal property so the backend can access its properties/methods
 	// This is an appropriate place for setup code, since it's always called from NewBackendManager()
@@ -14,19 +82,135 @@ type CaryatidBackend interface {
 	GetManager() (*BackendManager, error)
 
 	// Get the raw byte value held in the Vagrant catalog
+	// If there is no catalog yet, return an error wrapping ErrCatalogNotFound
 	GetCatalogBytes() ([]byte, error)
 
 	// Save a raw byte value to the Vagrant catalog
+	// If the backend refuses the write, return an error wrapping ErrBackendReadOnly
 	SetCatalogBytes([]byte) error
 
-	// Copy the Vagrant box to the location referenced in the Vagrant catalog
-	CopyBoxFile(string, string, string, string) error
+	// Copy a local Vagrant box file to a URI in the backend, which the BackendManager chooses next to the catalog
+	// If the URI's .Scheme doesn't match the value of .Scheme(), error
+	// If the backend refuses the write, return an error wrapping ErrBackendReadOnly
+	CopyBoxFile(localPath string, boxUri string) error
+
+	// Open a file with a given URI for reading, such as a box file referenced in the catalog
+	// The caller must close the result
+	// If the URI's .Scheme doesn't match the value of .Scheme(), error
+	// If there is no file at the URI, return an error wrapping ErrBoxNotFound
+	OpenFile(uri string) (io.ReadCloser, error)
 
 	// Delete a file with a given URI
 	// If the URI's .Scheme doesn't match the value of .Scheme(), error
+	// If there is no file at the URI, return an error wrapping ErrBoxNotFound;
+	// if the backend refuses the deletion, return an error wrapping ErrBackendReadOnly
 	DeleteFile(uri string) error
 
+	// List the URIs of every box file stored for a box with a given name,
+	// which is every file that CopyBoxFile() could have created for any version or provider of that box
+	// If there are no such files, return an empty list rather than an error
+	ListBoxFiles(boxName string) ([]string, error)
+
 	// Return the scheme as would be used in the URI for the backend,
 	// such as "file" for a "file:///tmp/catalog.json" catalog
 	Scheme() string
 }
+
+// CaryatidMoveBackend is implemented by backends that can move a file without copying it
+// For backends that do not implement it, a file is moved by copying it and then deleting the original
+// Wrappers like RetryBackend implement it too, returning ErrMoveNotSupported if the backend they wrap does not
+type CaryatidMoveBackend interface {
+	// Move a file from one URI to another URI within the same backend
+	// If either URI's .Scheme doesn't match the value of .Scheme(), error
+	MoveFile(fromUri string, toUri string) error
+}
+
+// ErrMoveNotSupported means that a backend wrapper's MoveFile() was called, but the backend it wraps cannot move files
+// The caller should copy the file and delete the original instead; see CaryatidMoveBackend
+var ErrMoveNotSupported = errors.New("The backend cannot move files")
+
+// ErrNotSupported means that a backend wrapper's method from one of the other optional interfaces below was called,
+// but the backend it wraps does not implement that interface
+// BackendManager checks the wrapped backend before it calls these methods, so it does not see this error
+var ErrNotSupported = errors.New("The backend does not support the operation")
+
+// notSupportedError returns an error wrapping ErrNotSupported for an operation that the wrapped backend does not implement
+func notSupportedError(opName string) error {
+	return fmt.Errorf("%w: %v", ErrNotSupported, opName)
+}
+
+// CaryatidLinkingBackend is implemented by backends that can make one file an alias of another without copying it,
+// like a symbolic link
+// For backends that do not implement it, an alias is made by copying the file
+type CaryatidLinkingBackend interface {
+	// Make linkUri an alias of the file at targetUri, replacing any file already at linkUri
+	// If either URI's .Scheme doesn't match the value of .Scheme(), error
+	LinkFile(targetUri string, linkUri string) error
+}
+
+// CaryatidSigningBackend is implemented by backends that can generate URLs granting temporary access to a file,
+// like the presigned URLs of an object store
+type CaryatidSigningBackend interface {
+	// Return a URL that downloads the file at uri without any other credentials, and that expires after ttl
+	// If the URI's .Scheme doesn't match the value of .Scheme(), error
+	SignedUrl(uri string, ttl time.Duration) (string, error)
+}
+
+// CaryatidListingBackend is implemented by backends that can list every file under a directory,
+// like the catalogs of many boxes kept under one root
+type CaryatidListingBackend interface {
+	// Return the URIs of every file under dirUri, at any depth, sorted
+	// If the URI's .Scheme doesn't match the value of .Scheme(), error
+	// If there are no files, return an empty list rather than an error
+	ListFiles(dirUri string) ([]string, error)
+}
+
+// CaryatidFingerprintBackend is implemented by backends that can tell whether a file has changed without reading it,
+// so that the checksums of unchanged box files can be cached; see ChecksumCache
+type CaryatidFingerprintBackend interface {
+	// Return a string that changes whenever the file at uri changes, like its size and modification time, or an object store's ETag
+	// If the URI's .Scheme doesn't match the value of .Scheme(), error
+	// If there is no file at the URI, return an error wrapping ErrBoxNotFound
+	FileFingerprint(uri string) (string, error)
+}
+
+// CaryatidContentMD5Backend is implemented by backends that may know the MD5 checksum of a file without reading it,
+// like S3, whose ETags are the MD5 of objects that were not uploaded in parts
+type CaryatidContentMD5Backend interface {
+	// Return the hex MD5 checksum of the file at uri, or ok=false if the backend does not know it
+	// If the URI's .Scheme doesn't match the value of .Scheme(), error
+	FileMD5(uri string) (md5sum string, ok bool, err error)
+}
+
+// CaryatidLockingBackend is implemented by backends that can lock the catalog,
+// so that processes changing it at the same time do not lose each other's changes
+type CaryatidLockingBackend interface {
+	// Lock the catalog, and return a function that releases the lock
+	// Do not wait for the lock; if another process holds it, return ErrCatalogLocked
+	LockCatalog() (unlock func() error, err error)
+}
+
+// CaryatidBackupBackend is implemented by backends that can keep backup copies of the catalog next to it
+type CaryatidBackupBackend interface {
+	// Copy the catalog to a sibling named like the catalog with suffix appended, and return the URI of the copy
+	// If there is no catalog yet, do nothing, and return an empty URI
+	BackupCatalog(suffix string) (backupUri string, err error)
+
+	// Return the URIs of all siblings of the catalog named like the catalog followed by CatalogBackupSuffix
+	ListCatalogBackups() (uris []string, err error)
+}
+
+// CaryatidRangeBackend is implemented by backends that can read part of a file, and tell its size, without reading all of it,
+// like the ranged GETs of an object store
+// Backends whose OpenFile() returns an io.ReadSeeker, like the LocalFile backend, do not need it
+type CaryatidRangeBackend interface {
+	// Return the size of the file at uri in bytes
+	// If the URI's .Scheme doesn't match the value of .Scheme(), error
+	// If there is no file at the URI, return an error wrapping ErrBoxNotFound
+	FileSize(uri string) (size int64, err error)
+
+	// Open the file at uri, starting offset bytes in, and reading to the end of the file
+	// If the URI's .Scheme doesn't match the value of .Scheme(), error
+	// If there is no file at the URI, return an error wrapping ErrBoxNotFound
+	OpenFileRange(uri string, offset int64) (io.ReadCloser, error)
+}
@@ -0,0 +1,114 @@
+/*
+Checks that a backend is reachable and writable, without changing the catalog
+*/
+
+package caryatid
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// HealthCheckPrefix is the start of the name of the temporary file that HealthCheck() writes next to the catalog
+const HealthCheckPrefix = ".caryatid-healthcheck-"
+
+// healthCheckContents is written to the temporary file, and must be read back unchanged
+var healthCheckContents = []byte("caryatid health check\n")
+
+// HealthCheckStep is the result of one step of HealthCheck()
+type HealthCheckStep struct {
+	Name string
+
+	// What the step found, like the size of the catalog, when it passed or was skipped
+	Detail string
+
+	// Set if the step did not run, because it does not apply to the backend or an earlier step failed
+	Skipped bool
+
+	// Nil if the step passed or was skipped
+	Err error
+}
+
+func (step *HealthCheckStep) Passed() bool {
+	return step.Err == nil
+}
+
+// HealthCheck reads the catalog from the backend, tolerating a catalog that does not exist yet,
+// and unless bm.ReadOnly is set, writes a small temporary file next to the catalog, reads it back, and deletes it
+// The catalog itself is never changed
+// Every step is essential, so the backend is healthy only if no step has an Err
+func (bm *BackendManager) HealthCheck() (steps []HealthCheckStep) {
+	readStep := HealthCheckStep{Name: "read catalog"}
+	if catalogBytes, err := bm.Backend.GetCatalogBytes(); errors.Is(err, ErrCatalogNotFound) {
+		readStep.Detail = "there is no catalog yet"
+	} else if err != nil {
+		readStep.Err = err
+	} else {
+		readStep.Detail = fmt.Sprintf("read %v bytes", len(catalogBytes))
+	}
+	steps = append(steps, readStep)
+
+	tempUri := fmt.Sprintf("%v/%v%v", bm.catalogParentUri(), HealthCheckPrefix, time.Now().UTC().Format("20060102150405.000000000"))
+	writeStep := HealthCheckStep{Name: "write temporary file"}
+	readBackStep := HealthCheckStep{Name: "read temporary file"}
+	deleteStep := HealthCheckStep{Name: "delete temporary file"}
+
+	if bm.ReadOnly {
+		for _, step := range []*HealthCheckStep{&writeStep, &readBackStep, &deleteStep} {
+			step.Skipped = true
+			step.Detail = "the backend is read-only"
+		}
+		steps = append(steps, writeStep, readBackStep, deleteStep)
+		return
+	}
+
+	if writeStep.Err = bm.writeHealthCheckFile(tempUri); writeStep.Err != nil {
+		for _, step := range []*HealthCheckStep{&readBackStep, &deleteStep} {
+			step.Skipped = true
+			step.Detail = "the temporary file could not be written"
+		}
+		steps = append(steps, writeStep, readBackStep, deleteStep)
+		return
+	}
+	writeStep.Detail = tempUri
+
+	if reader, err := bm.Backend.OpenFile(tempUri); err != nil {
+		readBackStep.Err = err
+	} else {
+		contents, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			readBackStep.Err = err
+		} else if !bytes.Equal(contents, healthCheckContents) {
+			readBackStep.Err = fmt.Errorf("The temporary file has different contents than were written to it")
+		}
+	}
+
+	if deleteStep.Err = bm.Backend.DeleteFile(tempUri); deleteStep.Err != nil {
+		bm.log().Errorf("HealthCheck(): Could not delete temporary file at '%v': %v\n", tempUri, deleteStep.Err)
+	}
+
+	steps = append(steps, writeStep, readBackStep, deleteStep)
+	return
+}
+
+// writeHealthCheckFile copies healthCheckContents to uri in the backend
+func (bm *BackendManager) writeHealthCheckFile(uri string) (err error) {
+	tempFile, err := ioutil.TempFile("", "caryatid-healthcheck")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = tempFile.Write(healthCheckContents)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	return bm.Backend.CopyBoxFile(tempFile.Name(), uri)
+}
@@ -0,0 +1,89 @@
+package caryatid
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBackendManagerHealthCheck(t *testing.T) {
+	type TestCase struct {
+		Name            string
+		CatalogUri      string
+		ReadOnly        bool
+		FailCopy        bool
+		ExpectedPassed  []bool
+		ExpectedSkipped []bool
+	}
+
+	var (
+		boxName = "TestHealthCheckBox"
+		boxPath = path.Join(integrationTestDir, "incoming-TestHealthCheckBox.box")
+		fileDir = path.Join(integrationTestDir, "TestBackendManagerHealthCheck")
+	)
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	if err := os.MkdirAll(fileDir, 0777); err != nil {
+		t.Fatalf("Error creating test directory: %v\n", err)
+	}
+	memUri := fmt.Sprintf("mem://TestBackendManagerHealthCheck/%v.json", boxName)
+	memBackend, err := NewBackendFromUri(memUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	writer := NewBackendManager(memUri, &memBackend)
+	if err = writer.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "1.0.0", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+
+	testCases := []TestCase{
+		TestCase{"memory", memUri, false, false, []bool{true, true, true, true}, []bool{false, false, false, false}},
+		TestCase{"file without a catalog", fmt.Sprintf("file://%v/%v.json", fileDir, boxName), false, false, []bool{true, true, true, true}, []bool{false, false, false, false}},
+		TestCase{"read-only", memUri, true, false, []bool{true, true, true, true}, []bool{false, true, true, true}},
+		TestCase{"failing writes", memUri, false, true, []bool{true, false, true, true}, []bool{false, false, true, true}},
+	}
+	for _, tc := range testCases {
+		backend, err := NewBackendFromUri(tc.CatalogUri)
+		if err != nil {
+			t.Fatalf("%v: Error getting backend: %v\n", tc.Name, err)
+		}
+		if tc.FailCopy {
+			backend = &failingCopyBackend{CaryatidBackend: backend, FailUriSubstring: HealthCheckPrefix}
+		}
+		if tc.ReadOnly {
+			backend = NewReadOnlyBackend(backend)
+		}
+		manager := NewBackendManager(tc.CatalogUri, &backend)
+
+		before := snapshotMemoryBackend()
+		steps := manager.HealthCheck()
+		if after := snapshotMemoryBackend(); !reflect.DeepEqual(before, after) {
+			t.Fatalf("%v: Expected HealthCheck() to leave the backend unchanged, but it had\n%v\nand then\n%v\n", tc.Name, before, after)
+		}
+
+		passed := []bool{}
+		skipped := []bool{}
+		for _, step := range steps {
+			passed = append(passed, step.Passed())
+			skipped = append(skipped, step.Skipped)
+		}
+		if !reflect.DeepEqual(passed, tc.ExpectedPassed) || !reflect.DeepEqual(skipped, tc.ExpectedSkipped) {
+			t.Fatalf("%v: Expected steps to pass %v and be skipped %v, but got %+v\n", tc.Name, tc.ExpectedPassed, tc.ExpectedSkipped, steps)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(fileDir)
+	if err != nil {
+		t.Fatalf("Error reading test directory: %v\n", err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), HealthCheckPrefix) {
+			t.Fatalf("Expected HealthCheck() to delete its temporary file, but found '%v'\n", entry.Name())
+		}
+	}
+}
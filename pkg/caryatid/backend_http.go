@@ -0,0 +1,224 @@
+/*
+The HTTP backend, for reading a Vagrant catalog that a web server publishes
+
+It is read-only, since a plain web server has no way to accept changes.
+*/
+
+package caryatid
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+func init() {
+	for _, scheme := range []string{"http", "https"} {
+		scheme := scheme
+		RegisterBackend(scheme, func(uri string) (CaryatidBackend, error) {
+			return &CaryatidHttpBackend{scheme: scheme}, nil
+		})
+	}
+}
+
+type CaryatidHttpBackend struct {
+	Manager *BackendManager
+
+	// The client that makes requests; if nil, http.DefaultClient is used
+	Client *http.Client
+
+	// Either "http" or "https", from the catalog URI
+	scheme string
+
+	// The catalog as it was last downloaded, with its ETag and Last-Modified headers; see GetCatalogBytes()
+	// A BackendManager may read the catalog from several goroutines, so these are guarded by catalogLock
+	catalogBytes        []byte
+	catalogETag         string
+	catalogLastModified string
+	catalogLock         sync.Mutex
+}
+
+// SetManager also sets the manager's ReadOnly property, since nothing can be written over HTTP; see ReadOnlyBackend
+func (backend *CaryatidHttpBackend) SetManager(manager *BackendManager) (err error) {
+	backend.Manager = manager
+	manager.ReadOnly = true
+	return
+}
+
+func (backend *CaryatidHttpBackend) GetManager() (manager *BackendManager, err error) {
+	manager = backend.Manager
+	if manager == nil {
+		err = fmt.Errorf("The Manager property was not set")
+	}
+	return
+}
+
+func (backend *CaryatidHttpBackend) client() *http.Client {
+	if backend.Client != nil {
+		return backend.Client
+	}
+	return http.DefaultClient
+}
+
+// get makes a GET request for uri, with any headers given, after checking that it has the backend's scheme
+func (backend *CaryatidHttpBackend) get(uri string, headers map[string]string) (response *http.Response, err error) {
+	return backend.request("GET", uri, headers)
+}
+
+// request makes a request for uri with method and any headers given, after checking that it has the backend's scheme
+func (backend *CaryatidHttpBackend) request(method string, uri string, headers map[string]string) (response *http.Response, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse '%v' as URI: %v", uri, err)
+	}
+	if u.Scheme != backend.Scheme() {
+		return nil, fmt.Errorf("Expected scheme '%v' but was given a URI with scheme '%v'", backend.Scheme(), u.Scheme)
+	}
+	request, err := http.NewRequest(method, uri, nil)
+	if err != nil {
+		return
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	return backend.client().Do(request)
+}
+
+// GetCatalogBytes downloads the catalog
+// Once it has downloaded the catalog, it sends the ETag and Last-Modified headers it got back with If-None-Match and If-Modified-Since,
+// and if the server responds that the catalog has not changed, it returns the catalog it already has instead of downloading it again
+func (backend *CaryatidHttpBackend) GetCatalogBytes() (catalogBytes []byte, err error) {
+	catalogUri := backend.Manager.CatalogUri
+
+	backend.catalogLock.Lock()
+	cachedBytes, cachedETag, cachedLastModified := backend.catalogBytes, backend.catalogETag, backend.catalogLastModified
+	backend.catalogLock.Unlock()
+
+	headers := make(map[string]string)
+	if cachedBytes != nil && cachedETag != "" {
+		headers["If-None-Match"] = cachedETag
+	}
+	if cachedBytes != nil && cachedLastModified != "" {
+		headers["If-Modified-Since"] = cachedLastModified
+	}
+	response, err := backend.get(catalogUri, headers)
+	if err != nil {
+		backend.Manager.log().Errorf("CaryatidHttpBackend.GetCatalogBytes(): Could not download '%v': %v\n", catalogUri, err)
+		return
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotModified && cachedBytes != nil:
+		backend.Manager.log().Debugf("CaryatidHttpBackend.GetCatalogBytes(): Catalog is unchanged since it was last downloaded\n")
+		catalogBytes = append([]byte{}, cachedBytes...)
+		return
+	case response.StatusCode == http.StatusNotFound:
+		err = catalogNotFoundError(catalogUri)
+		return
+	case response.StatusCode != http.StatusOK:
+		err = fmt.Errorf("Could not download '%v': %v", catalogUri, response.Status)
+		backend.Manager.log().Errorf("CaryatidHttpBackend.GetCatalogBytes(): %v\n", err)
+		return
+	}
+
+	if catalogBytes, err = ioutil.ReadAll(response.Body); err != nil {
+		backend.Manager.log().Errorf("CaryatidHttpBackend.GetCatalogBytes(): Could not read '%v': %v\n", catalogUri, err)
+		return
+	}
+	backend.catalogLock.Lock()
+	defer backend.catalogLock.Unlock()
+	backend.catalogBytes = append([]byte{}, catalogBytes...)
+	backend.catalogETag = response.Header.Get("ETag")
+	backend.catalogLastModified = response.Header.Get("Last-Modified")
+	return
+}
+
+func (backend *CaryatidHttpBackend) SetCatalogBytes(serializedCatalog []byte) error {
+	return readOnlyError("save the catalog", backend.Manager.CatalogUri)
+}
+
+func (backend *CaryatidHttpBackend) CopyBoxFile(localPath string, boxUri string) error {
+	return readOnlyError("copy a box file to", boxUri)
+}
+
+func (backend *CaryatidHttpBackend) OpenFile(uri string) (reader io.ReadCloser, err error) {
+	response, err := backend.get(uri, nil)
+	if err != nil {
+		return
+	}
+	switch response.StatusCode {
+	case http.StatusOK:
+		return response.Body, nil
+	case http.StatusNotFound:
+		err = &boxNotFoundError{uri, &os.PathError{Op: "open", Path: uri, Err: os.ErrNotExist}}
+	default:
+		err = fmt.Errorf("Could not download '%v': %v", uri, response.Status)
+	}
+	response.Body.Close()
+	return
+}
+
+// FileSize makes a HEAD request for the file, and returns its Content-Length
+func (backend *CaryatidHttpBackend) FileSize(uri string) (size int64, err error) {
+	response, err := backend.request("HEAD", uri, nil)
+	if err != nil {
+		return
+	}
+	response.Body.Close()
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		err = &boxNotFoundError{uri, &os.PathError{Op: "stat", Path: uri, Err: os.ErrNotExist}}
+	case response.StatusCode != http.StatusOK:
+		err = fmt.Errorf("Could not get the size of '%v': %v", uri, response.Status)
+	case response.ContentLength < 0:
+		err = fmt.Errorf("Could not get the size of '%v': the server did not send a Content-Length", uri)
+	default:
+		size = response.ContentLength
+	}
+	return
+}
+
+// OpenFileRange requests the file from offset on with a Range header
+// If the server ignores the header and sends the whole file, the bytes before offset are skipped
+func (backend *CaryatidHttpBackend) OpenFileRange(uri string, offset int64) (reader io.ReadCloser, err error) {
+	response, err := backend.get(uri, map[string]string{"Range": fmt.Sprintf("bytes=%v-", offset)})
+	if err != nil {
+		return
+	}
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		return response.Body, nil
+	case http.StatusOK:
+		if _, err = io.CopyN(ioutil.Discard, response.Body, offset); err == nil {
+			return response.Body, nil
+		}
+		err = fmt.Errorf("Could not skip to byte %v of '%v': %v", offset, uri, err)
+	case http.StatusNotFound:
+		err = &boxNotFoundError{uri, &os.PathError{Op: "open", Path: uri, Err: os.ErrNotExist}}
+	default:
+		err = fmt.Errorf("Could not download '%v' from byte %v: %v", uri, offset, response.Status)
+	}
+	response.Body.Close()
+	return
+}
+
+func (backend *CaryatidHttpBackend) DeleteFile(uri string) error {
+	return readOnlyError("delete", uri)
+}
+
+// ListBoxFiles always fails, since a web server has no standard way to list the files under a directory
+func (backend *CaryatidHttpBackend) ListBoxFiles(boxName string) ([]string, error) {
+	return nil, fmt.Errorf("The %v backend cannot list box files", backend.Scheme())
+}
+
+func (backend *CaryatidHttpBackend) Scheme() string {
+	if backend.scheme == "" {
+		return "http"
+	}
+	return backend.scheme
+}
@@ -0,0 +1,107 @@
+package caryatid
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestCaryatidHttpBackend_ImplementsCaryatidBackend(t *testing.T) {
+	var _ CaryatidBackend = (*CaryatidHttpBackend)(nil)
+}
+
+func TestCaryatidHttpBackendConditionalGet(t *testing.T) {
+	var (
+		lock         sync.Mutex
+		catalogJson  = `{"name": "HttpBox", "description": "desc", "versions": [{"version": "1.0.0", "providers": [{"name": "StrongSapling", "url": "box"}]}]}`
+		etag         = `"1"`
+		downloads    int
+		notModifieds int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.URL.Path {
+		case "/HttpBox.json":
+			if r.Header.Get("If-None-Match") == etag {
+				notModifieds += 1
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			downloads += 1
+			w.Header().Set("ETag", etag)
+			fmt.Fprint(w, catalogJson)
+		case "/HttpBox/box.box":
+			fmt.Fprint(w, "box contents")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	catalogUri := server.URL + "/HttpBox.json"
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting HTTP backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	if !manager.ReadOnly {
+		t.Fatalf("Expected the HTTP backend to make the manager read-only\n")
+	}
+
+	for idx := 0; idx < 3; idx++ {
+		catalog, err := manager.Reload()
+		if err != nil {
+			t.Fatalf("Reload() returned an error: %v\n", err)
+		}
+		if catalog.Name != "HttpBox" || len(catalog.Versions) != 1 {
+			t.Fatalf("Unexpected catalog:\n%v", catalog.DisplayString())
+		}
+	}
+	if downloads != 1 || notModifieds != 2 {
+		t.Fatalf("Expected the catalog to be downloaded once and then not modified twice, but it was downloaded %v times and not modified %v times\n", downloads, notModifieds)
+	}
+
+	// Once the catalog changes, it is downloaded again
+	lock.Lock()
+	catalogJson = `{"name": "HttpBox", "description": "desc", "versions": []}`
+	etag = `"2"`
+	lock.Unlock()
+	catalog, err := manager.Reload()
+	if err != nil {
+		t.Fatalf("Reload() returned an error: %v\n", err)
+	}
+	if len(catalog.Versions) != 0 || downloads != 2 {
+		t.Fatalf("Expected the changed catalog to be downloaded again, but got:\n%v", catalog.DisplayString())
+	}
+
+	reader, err := backend.OpenFile(server.URL + "/HttpBox/box.box")
+	if err != nil {
+		t.Fatalf("OpenFile() returned an error: %v\n", err)
+	}
+	contents, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(contents) != "box contents" {
+		t.Fatalf("Unexpected box file contents '%v': %v\n", string(contents), err)
+	}
+	if _, err = backend.OpenFile(server.URL + "/HttpBox/missing.box"); !errors.Is(err, ErrBoxNotFound) {
+		t.Fatalf("Expected a missing box file to be ErrBoxNotFound, but got: %v\n", err)
+	}
+	if err = backend.DeleteFile(server.URL + "/HttpBox/box.box"); !errors.Is(err, ErrBackendReadOnly) {
+		t.Fatalf("Expected DeleteFile() to be refused with ErrBackendReadOnly, but got: %v\n", err)
+	}
+
+	missingUri := server.URL + "/Missing.json"
+	missingBackend, err := NewBackendFromUri(missingUri)
+	if err != nil {
+		t.Fatalf("Error getting HTTP backend: %v\n", err)
+	}
+	missingManager := NewBackendManager(missingUri, &missingBackend)
+	if _, err = missingManager.GetCatalog(); !errors.Is(err, ErrCatalogNotFound) {
+		t.Fatalf("Expected a missing catalog to be ErrCatalogNotFound, but got: %v\n", err)
+	}
+}
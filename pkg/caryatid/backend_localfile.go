@@ -5,18 +5,29 @@ The localfile backend, for dealing with a Vagrant catalog on a local filesystem
 package caryatid
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/url"
 	"os"
-	"path"
+	"os/user"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/mrled/caryatid/internal/util"
 )
 
+func init() {
+	RegisterBackend("file", func(uri string) (CaryatidBackend, error) {
+		return &CaryatidLocalFileBackend{}, nil
+	})
+}
+
 type CaryatidLocalFileBackend struct {
 	VagrantCatalogRootPath string
 	VagrantCatalogPath     string
@@ -28,11 +39,11 @@ func (backend *CaryatidLocalFileBackend) SetManager(manager *BackendManager) (er
 
 	backend.VagrantCatalogPath, err = getValidLocalPath(backend.Manager.CatalogUri)
 	if err != nil {
-		fmt.Printf("Error trying to parse local catalog path from URI: %v\n", err)
+		backend.Manager.log().Errorf("Error trying to parse local catalog path from URI: %v\n", err)
 		return
 	}
 
-	backend.VagrantCatalogRootPath, _ = path.Split(backend.VagrantCatalogPath)
+	backend.VagrantCatalogRootPath, _ = filepath.Split(backend.VagrantCatalogPath)
 
 	return
 }
@@ -48,64 +59,143 @@ func (cb *CaryatidLocalFileBackend) GetManager() (manager *BackendManager, err e
 func (backend *CaryatidLocalFileBackend) GetCatalogBytes() (catalogBytes []byte, err error) {
 	catalogBytes, err = ioutil.ReadFile(backend.VagrantCatalogPath)
 	if os.IsNotExist(err) {
-		log.Printf("No file at '%v'; starting with empty catalog\n", backend.VagrantCatalogPath)
-		catalogBytes = []byte("{}")
-		err = nil
+		err = catalogNotFoundError(backend.Manager.CatalogUri)
 	} else if err != nil {
-		log.Printf("Error trying to read catalog: %v\n", err)
+		backend.Manager.log().Errorf("Error trying to read catalog: %v\n", err)
 	}
 	return
 }
 
 func (backend *CaryatidLocalFileBackend) SetCatalogBytes(serializedCatalog []byte) (err error) {
+	return backend.writeCatalog(bytes.NewReader(serializedCatalog))
+}
 
-	err = os.MkdirAll(backend.VagrantCatalogRootPath, 0777)
+// writeCatalog replaces the catalog with the contents of a reader
+// The catalog is written to a temporary file next to it and renamed into place,
+// so an interrupted write, or one that races another process, never leaves a truncated catalog behind
+func (backend *CaryatidLocalFileBackend) writeCatalog(src io.Reader) (err error) {
+	err = util.MkdirAllMode(backend.VagrantCatalogRootPath, backend.Manager.DirMode)
 	if err != nil {
-		log.Printf("Error trying to create the catalog root path at '%v': %v\b", backend.VagrantCatalogRootPath, err)
-		return
+		backend.Manager.log().Errorf("Error trying to create the catalog root path at '%v': %v\n", backend.VagrantCatalogRootPath, err)
+		return localWriteError(err)
 	}
 
-	err = ioutil.WriteFile(backend.VagrantCatalogPath, serializedCatalog, 0666)
+	_, err = util.AtomicWriteFileMode(backend.VagrantCatalogPath, src, backend.Manager.FileMode)
 	if err != nil {
-		log.Println("Error trying to write catalog: ", err)
+		err = localWriteError(err)
+		backend.Manager.log().Errorf("Error trying to write catalog: %v\n", err)
 		return
 	}
-	log.Println(fmt.Sprintf("Catalog updated on disk to reflect new value"))
+	backend.Manager.log().Infof("Catalog updated on disk to reflect new value\n")
 	return
 }
 
-func (backend *CaryatidLocalFileBackend) CopyBoxFile(localPath string, boxName string, boxVersion string, boxProvider string) (err error) {
-	var boxUri string
+// LockCatalog takes an flock() on a lockfile next to the catalog, like 'catalog.json.lock'
+// On Windows, the lockfile is the lock itself, and is removed when the lock is released
+func (backend *CaryatidLocalFileBackend) LockCatalog() (unlock func() error, err error) {
+	if err = util.MkdirAllMode(backend.VagrantCatalogRootPath, backend.Manager.DirMode); err != nil {
+		return
+	}
+	lock, err := util.TryLockFile(backend.VagrantCatalogPath + ".lock")
+	if err == util.ErrLocked {
+		err = ErrCatalogLocked
+		return
+	} else if err != nil {
+		return
+	}
+	unlock = lock.Unlock
+	return
+}
 
-	boxUri, err = BoxUriFromCatalogUri(backend.VagrantCatalogPath, boxName, boxVersion, boxProvider)
-	if err != nil {
-		fmt.Printf("Error trying to determine box URI: %v\n", err)
+// BackupCatalog copies the catalog to a file next to it
+func (backend *CaryatidLocalFileBackend) BackupCatalog(suffix string) (backupUri string, err error) {
+	backupPath := backend.VagrantCatalogPath + suffix
+	if _, err = util.CopyFileMode(backend.VagrantCatalogPath, backupPath, backend.Manager.FileMode); os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
 		return
 	}
+	backupUri = backend.Manager.CatalogUri + suffix
+	return
+}
 
+func (backend *CaryatidLocalFileBackend) ListCatalogBackups() (uris []string, err error) {
+	entries, err := ioutil.ReadDir(backend.VagrantCatalogRootPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+	catalogName := filepath.Base(backend.VagrantCatalogPath)
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), catalogName+CatalogBackupSuffix) {
+			uris = append(uris, backend.Manager.CatalogUri+strings.TrimPrefix(entry.Name(), catalogName))
+		}
+	}
+	return
+}
+
+func (backend *CaryatidLocalFileBackend) CopyBoxFile(localPath string, boxUri string) (err error) {
 	remoteBoxPath, err := getValidLocalPath(boxUri)
 	if err != nil {
-		fmt.Printf("Error trying to parse local artifact path from URI: %v\n", err)
+		backend.Manager.log().Errorf("Error trying to parse local artifact path from URI: %v\n", err)
 		return
 	}
 
-	remoteBoxParentPath, _ := path.Split(remoteBoxPath)
-	err = os.MkdirAll(remoteBoxParentPath, 0777)
+	remoteBoxParentPath, _ := filepath.Split(remoteBoxPath)
+	err = util.MkdirAllMode(remoteBoxParentPath, backend.Manager.DirMode)
+	if err != nil {
+		backend.Manager.log().Errorf("Error trying to create the box directory: %v\n", err)
+		return localWriteError(err)
+	}
+	backend.Manager.log().Debugf("Successfully created directory at %v\n", remoteBoxParentPath)
+
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		backend.Manager.log().Errorf("Error trying to open '%v': %v\n", localPath, err)
+		return
+	}
+	defer localFile.Close()
+	localInfo, err := localFile.Stat()
 	if err != nil {
-		log.Println("Error trying to create the box directory: ", err)
 		return
 	}
-	log.Printf("Successfully created directory at %v\n", remoteBoxParentPath)
 
-	written, err := util.CopyFile(localPath, remoteBoxPath)
+	written, err := util.AtomicWriteFileMode(remoteBoxPath, backend.Manager.ProgressReader(localFile, localInfo.Size()), backend.Manager.FileMode)
 	if err != nil {
-		log.Printf("Error trying to copy '%v' to '%v' file: %v\n", localPath, remoteBoxPath, err)
+		err = localWriteError(err)
+		backend.Manager.log().Errorf("Error trying to copy '%v' to '%v' file: %v\n", localPath, remoteBoxPath, err)
 		return
 	}
-	log.Printf("Copied %v bytes from original path at '%v' to new location at '%v'\n", written, localPath, remoteBoxPath)
+	backend.Manager.log().Infof("Copied %v bytes from original path at '%v' to new location at '%v'\n", written, localPath, remoteBoxPath)
 	return
 }
 
+func (backend *CaryatidLocalFileBackend) OpenFile(uri string) (reader io.ReadCloser, err error) {
+	var (
+		u    *url.URL
+		path string
+	)
+	u, err = url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse '%v' as URI: %v", uri, err)
+	}
+	if u.Scheme != backend.Scheme() {
+		return nil, fmt.Errorf("Expected scheme '%v' but was given a URI with scheme '%v'", backend.Scheme(), u.Scheme)
+	}
+
+	if path, err = getValidLocalPath(uri); err != nil {
+		return
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, &boxNotFoundError{uri, err}
+	} else if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
 func (backend *CaryatidLocalFileBackend) DeleteFile(uri string) (err error) {
 	var (
 		u    *url.URL
@@ -122,45 +212,323 @@ func (backend *CaryatidLocalFileBackend) DeleteFile(uri string) (err error) {
 	if path, err = getValidLocalPath(uri); err != nil {
 		return
 	}
-	if err = os.Remove(path); err != nil {
+	backend.Manager.log().Debugf("Deleting file at '%v'\n", path)
+	if err = os.Remove(path); os.IsNotExist(err) {
+		return &boxNotFoundError{uri, err}
+	} else if err != nil {
+		return localWriteError(err)
+	}
+
+	return
+}
+
+func (backend *CaryatidLocalFileBackend) ListBoxFiles(boxName string) (uris []string, err error) {
+	boxDirUri, err := BoxDirUriFromCatalogUri(backend.Manager.CatalogUri, boxName)
+	if err != nil {
+		return
+	}
+	boxDirPath, err := getValidLocalPath(boxDirUri)
+	if err != nil {
+		return
+	}
+
+	err = filepath.Walk(boxDirPath, func(walkPath string, info os.FileInfo, walkErr error) error {
+		if os.IsNotExist(walkErr) && walkPath == boxDirPath {
+			return filepath.SkipDir
+		} else if walkErr != nil {
+			return walkErr
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".box") {
+			return nil
+		}
+		relPath, err := filepath.Rel(boxDirPath, walkPath)
+		if err != nil {
+			return err
+		}
+		uris = append(uris, fmt.Sprintf("%v/%v", boxDirUri, filepath.ToSlash(relPath)))
+		return nil
+	})
+	return
+}
+
+func (backend *CaryatidLocalFileBackend) ListFiles(dirUri string) (uris []string, err error) {
+	dirUri = strings.TrimSuffix(dirUri, "/")
+	dirPath, err := getValidLocalPath(dirUri)
+	if err != nil {
 		return
 	}
 
+	err = filepath.Walk(dirPath, func(walkPath string, info os.FileInfo, walkErr error) error {
+		if os.IsNotExist(walkErr) && walkPath == dirPath {
+			return filepath.SkipDir
+		} else if walkErr != nil {
+			return walkErr
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(dirPath, walkPath)
+		if err != nil {
+			return err
+		}
+		uris = append(uris, fmt.Sprintf("%v/%v", dirUri, filepath.ToSlash(relPath)))
+		return nil
+	})
 	return
 }
 
+// FileFingerprint returns the size and modification time of a file
+func (backend *CaryatidLocalFileBackend) FileFingerprint(uri string) (fingerprint string, err error) {
+	filePath, err := getValidLocalPath(uri)
+	if err != nil {
+		return
+	}
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		err = &boxNotFoundError{uri, err}
+		return
+	} else if err != nil {
+		return
+	}
+	fingerprint = fmt.Sprintf("size=%v mtime=%v", info.Size(), info.ModTime().UnixNano())
+	return
+}
+
+func (backend *CaryatidLocalFileBackend) MoveFile(fromUri string, toUri string) (err error) {
+	var fromPath, toPath string
+
+	for _, uri := range []string{fromUri, toUri} {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return fmt.Errorf("Could not parse '%v' as URI: %v", uri, err)
+		}
+		if u.Scheme != backend.Scheme() {
+			return fmt.Errorf("Expected scheme '%v' but was given a URI with scheme '%v'", backend.Scheme(), u.Scheme)
+		}
+	}
+
+	if fromPath, err = getValidLocalPath(fromUri); err != nil {
+		return
+	}
+	if toPath, err = getValidLocalPath(toUri); err != nil {
+		return
+	}
+	toParentPath, _ := filepath.Split(toPath)
+	if err = util.MkdirAllMode(toParentPath, backend.Manager.DirMode); err != nil {
+		backend.Manager.log().Errorf("Error trying to create the box directory: %v\n", err)
+		return
+	}
+	backend.Manager.log().Debugf("Moving file at '%v' to '%v'\n", fromPath, toPath)
+	if err = os.Rename(fromPath, toPath); os.IsNotExist(err) {
+		return &boxNotFoundError{fromUri, err}
+	}
+	return localWriteError(err)
+}
+
+// LinkFile makes linkUri a relative symbolic link to targetUri, so that it still works if the directory holding the catalog is moved
+// If the filesystem cannot make symbolic links, like on Windows without the right privilege, it copies the file instead
+func (backend *CaryatidLocalFileBackend) LinkFile(targetUri string, linkUri string) (err error) {
+	var targetPath, linkPath string
+
+	for _, uri := range []string{targetUri, linkUri} {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return fmt.Errorf("Could not parse '%v' as URI: %v", uri, err)
+		}
+		if u.Scheme != backend.Scheme() {
+			return fmt.Errorf("Expected scheme '%v' but was given a URI with scheme '%v'", backend.Scheme(), u.Scheme)
+		}
+	}
+
+	if targetPath, err = getValidLocalPath(targetUri); err != nil {
+		return
+	}
+	if linkPath, err = getValidLocalPath(linkUri); err != nil {
+		return
+	}
+	if _, err = os.Stat(targetPath); os.IsNotExist(err) {
+		return &boxNotFoundError{targetUri, err}
+	} else if err != nil {
+		return
+	}
+	relTarget, err := filepath.Rel(filepath.Dir(linkPath), targetPath)
+	if err != nil {
+		return
+	}
+
+	backend.Manager.log().Debugf("Linking '%v' to '%v'\n", linkPath, relTarget)
+	if err = util.AtomicSymlink(relTarget, linkPath); err != nil {
+		backend.Manager.log().Debugf("Could not link '%v', so copying it instead: %v\n", linkPath, err)
+		_, err = util.CopyFileMode(targetPath, linkPath, backend.Manager.FileMode)
+	}
+	return localWriteError(err)
+}
+
 func (backend *CaryatidLocalFileBackend) Scheme() string {
 	return "file"
 }
 
-// Get a valid local path from a URI
-// Converts URI paths (with '/' separator) to Windows paths (with '\' separator) when on Windows
-// On Windows, a URI will sometimes be in the form 'file:///C:/path/to/something' (or 'file:///C:\\path\\to\\something')
-func getValidLocalPath(uri string) (outpath string, err error) {
-	u, err := url.Parse(uri)
-	if err != nil {
+// RemovePartialFiles removes the temporary files of box files and catalogs that the local file backend is still writing,
+// returning their paths
+// A program that is about to exit without letting the writes finish, like on an interrupt, should call it,
+// so that partially written files are not left behind
+func RemovePartialFiles() []string {
+	return util.RemovePendingTempFiles()
+}
+
+// ParseFileMode parses the permissions of a file from octal, like "0640" or "640", for BackendManager.FileMode
+// An empty string results in 0, which leaves the permissions to the umask
+func ParseFileMode(octal string) (mode os.FileMode, err error) {
+	if octal == "" {
+		return
+	}
+	parsed, err := strconv.ParseUint(octal, 8, 32)
+	if err != nil || parsed > 0777 {
+		err = fmt.Errorf("Invalid file mode '%v'; expected octal permissions from 0000 to 0777, like 0640", octal)
 		return
 	}
+	mode = os.FileMode(parsed)
+	return
+}
 
-	outpath = u.Path
-	if u.Path == "" {
-		err = fmt.Errorf("No valid path information was provided in the URI '%v'", uri)
+// ParseDirMode is like ParseFileMode(), for BackendManager.DirMode
+// The owner must be able to read, write, and search a directory, or boxes could not be added to it
+func ParseDirMode(octal string) (mode os.FileMode, err error) {
+	if mode, err = ParseFileMode(octal); err != nil {
 		return
 	}
+	if mode != 0 && mode&0700 != 0700 {
+		err = fmt.Errorf("Invalid directory mode '%v'; the owner must have read, write, and search permission, like 0750", octal)
+		mode = 0
+	}
+	return
+}
+
+// localWriteError wraps errors from writing to a directory we don't have permission to write to,
+// or to a read-only filesystem, so that they match ErrBackendReadOnly
+func localWriteError(err error) error {
+	if os.IsPermission(err) || errors.Is(err, syscall.EROFS) {
+		return &backendReadOnlyError{err}
+	}
+	return err
+}
+
+// ExpandLocalPath expands a leading '~' or '~user' in a local path to that user's home directory,
+// and then environment variables like '$HOME' or '${HOME}'
+// An environment variable that is not set is an error, rather than silently expanding to nothing
+func ExpandLocalPath(localPath string) (expanded string, err error) {
+	expanded = localPath
+	if strings.HasPrefix(expanded, "~") {
+		name, rest := expanded[1:], ""
+		if idx := strings.IndexAny(name, `/\`); idx >= 0 {
+			name, rest = name[:idx], name[idx:]
+		}
+		home := ""
+		if name == "" {
+			home, err = os.UserHomeDir()
+		} else {
+			var homeUser *user.User
+			if homeUser, err = user.Lookup(name); err == nil {
+				home = homeUser.HomeDir
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("Could not find the home directory to expand '%v': %v", localPath, err)
+			return
+		}
+		expanded = home + rest
+	}
 
-	// On Windows, valid URIs look like file:///C:/whatever or file:///C:\\whatever
-	// The naivePath variable will contain that leading slash, like "/C:/whatever" or "/C:\\whatever"
-	// If the path looks like that, strip the leading slash
-	matched, err := regexp.MatchString("^/[a-zA-Z]:", outpath)
+	unset := []string{}
+	expanded = os.Expand(expanded, func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			unset = append(unset, name)
+		}
+		return value
+	})
+	if len(unset) > 0 {
+		err = fmt.Errorf("Could not expand '%v', because the environment variable '%v' is not set", localPath, unset[0])
+	}
+	return
+}
+
+// ExpandFileUri expands '~' and environment variables in the path of a file:// URI, like 'file://~/boxes/testbox.json'; see ExpandLocalPath()
+// Other URIs are returned unchanged
+func ExpandFileUri(uri string) (expanded string, err error) {
+	if !strings.HasPrefix(uri, "file://") {
+		return uri, nil
+	}
+	localPath, err := ExpandLocalPath(strings.TrimPrefix(uri, "file://"))
 	if err != nil {
 		return
-	} else if matched {
-		outpath = outpath[1:len(outpath)]
 	}
+	expanded = "file://" + localPath
+	return
+}
+
+// windowsDrivePath matches a local path or the path of a URI that starts with a Windows drive letter, like 'C:\' or '/C:/'
+var windowsDrivePath = regexp.MustCompile("^/?[a-zA-Z]:")
+
+// LocalPathToUri returns the file:// URI of an absolute local path, always with forward slashes,
+// like 'file:///srv/boxes/testbox.json', or on Windows, 'file:///C:/boxes/testbox.json'
+func LocalPathToUri(localPath string) string {
+	return localPathToUri(localPath, os.PathSeparator)
+}
+
+// localPathToUri is LocalPathToUri() for a path with the given separator, so that Windows paths can be tested on any OS
+func localPathToUri(localPath string, separator rune) string {
+	uriPath := strings.Replace(localPath, string(separator), "/", -1)
+	if !strings.HasPrefix(uriPath, "/") {
+		uriPath = "/" + uriPath
+	}
+	return "file://" + uriPath
+}
+
+// NormalizeFileUri rewrites a file:// URI for a Windows path, like 'file://C:\boxes\testbox.json' or 'file:///C:\boxes\testbox.json',
+// as 'file:///C:/boxes/testbox.json', so that it parses, and the URIs of box files can be joined to it with forward slashes
+// Other URIs are returned unchanged, as are file:// URIs without a drive letter on other OSes,
+// where a backslash is an ordinary character in a file name
+func NormalizeFileUri(uri string) string {
+	return normalizeFileUri(uri, os.PathSeparator)
+}
+
+// normalizeFileUri is NormalizeFileUri() for an OS with the given path separator
+func normalizeFileUri(uri string, separator rune) string {
+	if !strings.HasPrefix(uri, "file://") {
+		return uri
+	}
+	uriPath := strings.TrimPrefix(uri, "file://")
+	if windowsDrivePath.MatchString(uriPath) {
+		return localPathToUri(uriPath, '\\')
+	} else if separator == '\\' {
+		return "file://" + strings.Replace(uriPath, "\\", "/", -1)
+	}
+	return uri
+}
 
-	// Get an absolute path
-	// If on Windows, replaces any forward slashes in the URI with backslashes
-	outpath = filepath.Clean(outpath)
+// uriPathToLocalPath converts the path of a file:// URI, like '/srv/boxes/testbox.json' or '/C:/boxes/testbox.json',
+// to a local path with the given separator, dropping the slash before a Windows drive letter
+func uriPathToLocalPath(uriPath string, separator rune) string {
+	if windowsDrivePath.MatchString(uriPath) {
+		uriPath = strings.TrimPrefix(uriPath, "/")
+	}
+	return strings.Replace(uriPath, "/", string(separator), -1)
+}
 
+// Get a valid local path from a URI
+// Windows URIs like 'file:///C:/path/to/something' (or 'file:///C:\\path\\to\\something') become Windows paths like 'C:\\path\\to\\something'
+// '~' and environment variables are not expanded, since the URI may have come from the catalog, where a '$' in a path is literal;
+// callers expand URIs that a user typed with ExpandFileUri() first
+func getValidLocalPath(uri string) (outpath string, err error) {
+	u, err := url.Parse(NormalizeFileUri(uri))
+	if err != nil {
+		return
+	}
+	if u.Path == "" {
+		err = fmt.Errorf("No valid path information was provided in the URI '%v'", uri)
+		return
+	}
+	outpath = filepath.Clean(uriPathToLocalPath(u.Path, os.PathSeparator))
 	return
 }
@@ -1,9 +1,424 @@
 package caryatid
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
+
+	"github.com/mrled/caryatid/internal/util"
 )
 
-func TestCaryatidLocalFileBackend(t *testing.T) {
-	t.Logf("NOT IMPLEMENTED\n")
+func TestCaryatidLocalFileBackend_ImplementsCaryatidBackend(t *testing.T) {
+	var _ CaryatidBackend = new(CaryatidLocalFileBackend)
+}
+
+func TestCaryatidLocalFileBackendCopyBoxFile(t *testing.T) {
+	var (
+		err         error
+		boxName     = "TestLocalFileCopyBox"
+		boxVersion  = "1.0.0"
+		boxProvider = "TestProvider"
+		catalogRoot = path.Join(integrationTestDir, "TestCaryatidLocalFileBackendCopyBoxFile")
+		catalogUri  = fmt.Sprintf("file://%v/%v.json", catalogRoot, boxName)
+		srcPath     = path.Join(integrationTestDir, "incoming-TestLocalFileCopyBox.box")
+		dstDir      = path.Join(catalogRoot, boxName)
+		dstPath     = path.Join(dstDir, fmt.Sprintf("%v_%v_%v.box", boxName, boxVersion, boxProvider))
+	)
+
+	// Generate a box that is larger than the copy buffer
+	srcFile, err := os.Create(srcPath)
+	if err != nil {
+		t.Fatalf("Error creating source box: %v\n", err)
+	}
+	chunk := make([]byte, 1024*1024)
+	for idx := range chunk {
+		chunk[idx] = byte(idx % 251)
+	}
+	for idx := 0; idx < 5; idx++ {
+		if _, err = srcFile.Write(chunk); err != nil {
+			t.Fatalf("Error writing source box: %v\n", err)
+		}
+	}
+	srcFile.Close()
+
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	NewBackendManager(catalogUri, &backend)
+
+	if err = backend.CopyBoxFile(srcPath, "file://"+dstPath); err != nil {
+		t.Fatalf("CopyBoxFile() returned an unexpected error: %v\n", err)
+	}
+	srcDigest, _ := util.Sha1sum(srcPath)
+	dstDigest, err := util.Sha1sum(dstPath)
+	if err != nil {
+		t.Fatalf("Error hashing copied box: %v\n", err)
+	}
+	if srcDigest != dstDigest {
+		t.Fatalf("Copied box at '%v' does not match the original\n", dstPath)
+	}
+
+	// Copying from a source that cannot be read must not leave anything behind
+	failedUri := fmt.Sprintf("file://%v/%v_%v_%v.box", dstDir, boxName, "2.0.0", boxProvider)
+	if err = backend.CopyBoxFile(integrationTestDir, failedUri); err == nil {
+		t.Fatalf("CopyBoxFile() from a directory should have failed\n")
+	}
+	entries, err := ioutil.ReadDir(dstDir)
+	if err != nil {
+		t.Fatalf("Error listing '%v': %v\n", dstDir, err)
+	}
+	if len(entries) != 1 {
+		names := []string{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Fatalf("Expected only the first box in '%v' after a failed copy, but found: %v\n", dstDir, names)
+	}
+
+	// A copy interrupted partway through must not leave a partial box file behind either
+	manager, _ := backend.GetManager()
+	interruptedUri := fmt.Sprintf("file://%v/%v_%v_%v.box", dstDir, boxName, "3.0.0", boxProvider)
+	var removed []string
+	manager.CopyProgress = func(transferred int64, total int64) {
+		if removed == nil && transferred >= total/2 {
+			removed = RemovePartialFiles()
+		}
+	}
+	if err = backend.CopyBoxFile(srcPath, interruptedUri); err == nil {
+		t.Fatalf("CopyBoxFile() should have failed after it was interrupted\n")
+	}
+	if len(removed) != 1 {
+		t.Fatalf("Expected RemovePartialFiles() to remove the partial box file, but it removed %v\n", removed)
+	}
+	if entries, _ = ioutil.ReadDir(dstDir); len(entries) != 1 {
+		t.Fatalf("Expected only the first box in '%v' after an interrupted copy, but found %v files\n", dstDir, len(entries))
+	}
+}
+
+// failingReader returns some data, and then an error
+type failingReader struct {
+	remaining int
+}
+
+func (r *failingReader) Read(p []byte) (n int, err error) {
+	if r.remaining <= 0 {
+		return 0, fmt.Errorf("Simulated read failure")
+	}
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	for idx := range p {
+		p[idx] = '{'
+	}
+	r.remaining -= len(p)
+	return len(p), nil
+}
+
+func TestCaryatidLocalFileBackendSetCatalogBytesAtomic(t *testing.T) {
+	var (
+		err         error
+		catalogRoot = path.Join(integrationTestDir, "TestCaryatidLocalFileBackendSetCatalogBytesAtomic")
+		catalogUri  = fmt.Sprintf("file://%v/TestAtomicCatalog.json", catalogRoot)
+		contents    = []byte(`{"name":"TestAtomicCatalog","versions":[]}`)
+	)
+
+	backendInterface, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	NewBackendManager(catalogUri, &backendInterface)
+	backend := backendInterface.(*CaryatidLocalFileBackend)
+
+	if err = backend.SetCatalogBytes(contents); err != nil {
+		t.Fatalf("SetCatalogBytes() returned an unexpected error: %v\n", err)
+	}
+
+	// Simulate a failure partway through writing a new catalog
+	if err = backend.writeCatalog(&failingReader{remaining: 16}); err == nil {
+		t.Fatalf("writeCatalog() with a failing reader should have returned an error\n")
+	}
+
+	result, err := backend.GetCatalogBytes()
+	if err != nil {
+		t.Fatalf("GetCatalogBytes() returned an unexpected error: %v\n", err)
+	}
+	if !bytes.Equal(result, contents) {
+		t.Fatalf("Expected the previous catalog '%v' after a failed write, but got '%v'\n", string(contents), string(result))
+	}
+	entries, err := ioutil.ReadDir(catalogRoot)
+	if err != nil {
+		t.Fatalf("Error listing '%v': %v\n", catalogRoot, err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the catalog in '%v' after a failed write, but found %v files\n", catalogRoot, len(entries))
+	}
+}
+
+func TestLocalWriteError(t *testing.T) {
+	type TestCase struct {
+		Err              error
+		ExpectedReadOnly bool
+	}
+	testCases := []TestCase{
+		TestCase{&os.PathError{Op: "open", Path: "/catalog.json", Err: syscall.EACCES}, true},
+		TestCase{&os.PathError{Op: "open", Path: "/catalog.json", Err: syscall.EROFS}, true},
+		TestCase{&os.PathError{Op: "open", Path: "/catalog.json", Err: syscall.ENOSPC}, false},
+		TestCase{nil, false},
+	}
+	for _, tc := range testCases {
+		err := localWriteError(tc.Err)
+		if errors.Is(err, ErrBackendReadOnly) != tc.ExpectedReadOnly {
+			t.Fatalf("Expected errors.Is(localWriteError(%v), ErrBackendReadOnly) to be %v\n", tc.Err, tc.ExpectedReadOnly)
+		}
+		if tc.Err != nil && !errors.Is(err, tc.Err) {
+			t.Fatalf("Expected localWriteError(%v) to wrap the original error, but got %v\n", tc.Err, err)
+		}
+	}
+}
+
+func TestCaryatidLocalFileBackendModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows does not have Unix permissions")
+	}
+	var (
+		boxName     = "TestLocalFileModesBox"
+		boxProvider = "TestProvider"
+		catalogRoot = path.Join(integrationTestDir, "TestCaryatidLocalFileBackendModes", "nested")
+		catalogPath = path.Join(catalogRoot, fmt.Sprintf("%v.json", boxName))
+		boxPath     = path.Join(integrationTestDir, "incoming-TestLocalFileModesBox.box")
+	)
+	os.RemoveAll(path.Dir(catalogRoot))
+	if err := CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri("file://" + catalogPath)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	manager := NewBackendManager("file://"+catalogPath, &backend)
+	if manager.FileMode, err = ParseFileMode("0640"); err != nil {
+		t.Fatalf("ParseFileMode() returned an unexpected error: %v\n", err)
+	}
+	if manager.DirMode, err = ParseDirMode("750"); err != nil {
+		t.Fatalf("ParseDirMode() returned an unexpected error: %v\n", err)
+	}
+
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: "1.0.0", Provider: boxProvider, ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+	}
+	expectMode := func(filePath string, expected os.FileMode) {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			t.Fatalf("Error trying to stat '%v': %v\n", filePath, err)
+		}
+		if info.Mode().Perm() != expected {
+			t.Fatalf("Expected '%v' to have mode %#o, but it has %#o\n", filePath, expected, info.Mode().Perm())
+		}
+	}
+	expectMode(path.Dir(catalogRoot), 0750)
+	expectMode(catalogRoot, 0750)
+	expectMode(catalogPath, 0640)
+	expectMode(path.Join(catalogRoot, boxName), 0750)
+	expectMode(path.Join(catalogRoot, boxName, fmt.Sprintf("%v_1.0.0_%v.box", boxName, boxProvider)), 0640)
+
+	// Replacing the catalog gives it the mode too, even if it had another
+	if err = os.Chmod(catalogPath, 0600); err != nil {
+		t.Fatalf("Error trying to chmod the catalog: %v\n", err)
+	}
+	manager.FileMode = 0644
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: "2.0.0", Provider: boxProvider, ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+	}
+	expectMode(catalogPath, 0644)
+}
+
+func TestParseFileMode(t *testing.T) {
+	type TestCase struct {
+		Octal        string
+		ExpectedFile os.FileMode
+		ExpectedDir  os.FileMode
+		FileError    bool
+		DirError     bool
+	}
+	testCases := []TestCase{
+		TestCase{"", 0, 0, false, false},
+		TestCase{"0640", 0640, 0, false, true},
+		TestCase{"755", 0755, 0755, false, false},
+		TestCase{"0777", 0777, 0777, false, false},
+		TestCase{"1777", 0, 0, true, true},
+		TestCase{"0648", 0, 0, true, true},
+		TestCase{"rw-r-----", 0, 0, true, true},
+		TestCase{"-0640", 0, 0, true, true},
+	}
+	for _, tc := range testCases {
+		fileMode, err := ParseFileMode(tc.Octal)
+		if tc.FileError != (err != nil) || fileMode != tc.ExpectedFile {
+			t.Fatalf("Expected ParseFileMode(%v) to return %#o with error %v, but got %#o with error %v\n", tc.Octal, tc.ExpectedFile, tc.FileError, fileMode, err)
+		}
+		dirMode, err := ParseDirMode(tc.Octal)
+		if tc.DirError != (err != nil) || dirMode != tc.ExpectedDir {
+			t.Fatalf("Expected ParseDirMode(%v) to return %#o with error %v, but got %#o with error %v\n", tc.Octal, tc.ExpectedDir, tc.DirError, dirMode, err)
+		}
+	}
+}
+
+func TestExpandLocalPath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skipf("No home directory to expand to: %v\n", err)
+	}
+	defer os.Unsetenv("CARYATID_TEST_BOXES")
+	os.Setenv("CARYATID_TEST_BOXES", "/srv/boxes")
+	os.Unsetenv("CARYATID_TEST_UNSET")
+
+	type TestCase struct {
+		Input       string
+		Expected    string
+		ExpectError bool
+	}
+	testCases := []TestCase{
+		TestCase{"~", home, false},
+		TestCase{"~/boxes/testbox.json", home + "/boxes/testbox.json", false},
+		TestCase{"$CARYATID_TEST_BOXES/testbox.json", "/srv/boxes/testbox.json", false},
+		TestCase{"${CARYATID_TEST_BOXES}/testbox.json", "/srv/boxes/testbox.json", false},
+		TestCase{"/srv/boxes/~testbox.json", "/srv/boxes/~testbox.json", false},
+		TestCase{"/srv/boxes/testbox.json", "/srv/boxes/testbox.json", false},
+		TestCase{"$CARYATID_TEST_UNSET/testbox.json", "", true},
+		TestCase{"~caryatid-test-no-such-user/testbox.json", "", true},
+	}
+	if current, userErr := user.Current(); userErr == nil && current.HomeDir != "" && !strings.ContainsAny(current.Username, `/\`) {
+		testCases = append(testCases, TestCase{"~" + current.Username + "/testbox.json", current.HomeDir + "/testbox.json", false})
+	}
+	for _, tc := range testCases {
+		expanded, err := ExpandLocalPath(tc.Input)
+		if tc.ExpectError {
+			if err == nil {
+				t.Fatalf("Expected ExpandLocalPath(%v) to return an error, but got '%v'\n", tc.Input, expanded)
+			}
+		} else if err != nil || expanded != tc.Expected {
+			t.Fatalf("Expected ExpandLocalPath(%v) to return '%v', but got '%v' with error %v\n", tc.Input, tc.Expected, expanded, err)
+		}
+	}
+
+	uriCases := []TestCase{
+		TestCase{"file://~/boxes/testbox.json", "file://" + home + "/boxes/testbox.json", false},
+		TestCase{"file://$CARYATID_TEST_BOXES/testbox.json", "file:///srv/boxes/testbox.json", false},
+		TestCase{"s3://bucket/$CARYATID_TEST_BOXES/~/testbox.json", "s3://bucket/$CARYATID_TEST_BOXES/~/testbox.json", false},
+		TestCase{"file://$CARYATID_TEST_UNSET/testbox.json", "", true},
+	}
+	for _, tc := range uriCases {
+		expanded, err := ExpandFileUri(tc.Input)
+		if tc.ExpectError != (err != nil) || (!tc.ExpectError && expanded != tc.Expected) {
+			t.Fatalf("Expected ExpandFileUri(%v) to return '%v', but got '%v' with error %v\n", tc.Input, tc.Expected, expanded, err)
+		}
+	}
+}
+
+// URIs that the backend is given, like the box URLs in a catalog, are used as they are, even if they look like they could be expanded
+func TestCaryatidLocalFileBackendLiteralPaths(t *testing.T) {
+	defer os.Unsetenv("CARYATID_TEST_LITERAL")
+	os.Setenv("CARYATID_TEST_LITERAL", "expanded")
+
+	var (
+		boxName     = "TestLiteralPathBox"
+		boxPath     = path.Join(integrationTestDir, "incoming-TestLiteralPathBox.box")
+		catalogRoot = path.Join(integrationTestDir, "TestCaryatidLocalFileBackendLiteralPaths", "$CARYATID_TEST_LITERAL")
+		catalogUri  = fmt.Sprintf("file://%v/%v.json", catalogRoot, boxName)
+	)
+	if err := os.RemoveAll(path.Dir(catalogRoot)); err != nil {
+		t.Fatalf("Error removing old catalog root: %v\n", err)
+	}
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "1.0.0", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("AddBox() returned an error: %v\n", err)
+	}
+
+	boxFile := path.Join(catalogRoot, boxName, boxName+"_1.0.0_StrongSapling.box")
+	if _, err = os.Stat(boxFile); err != nil {
+		t.Fatalf("Expected the box file at the literal path '%v', but got: %v\n", boxFile, err)
+	}
+	if _, err = os.Stat(path.Join(path.Dir(catalogRoot), "expanded")); !os.IsNotExist(err) {
+		t.Fatalf("Expected nothing to be written to the expanded path, but got: %v\n", err)
+	}
+	if _, err = manager.DeleteBox(CatalogQueryParams{Version: "1.0.0"}); err != nil {
+		t.Fatalf("DeleteBox() returned an error: %v\n", err)
+	}
+	if _, err = os.Stat(boxFile); !os.IsNotExist(err) {
+		t.Fatalf("Expected DeleteBox() to delete '%v', but got: %v\n", boxFile, err)
+	}
+}
+
+func TestLocalPathUriRoundTrip(t *testing.T) {
+	type TestCase struct {
+		LocalPath string
+		Separator rune
+		Uri       string
+	}
+	testCases := []TestCase{
+		TestCase{`C:\Users\Test User\boxes\testbox.json`, '\\', "file:///C:/Users/Test User/boxes/testbox.json"},
+		TestCase{`d:\testbox.json`, '\\', "file:///d:/testbox.json"},
+		TestCase{"/srv/boxes/testbox.json", '/', "file:///srv/boxes/testbox.json"},
+		TestCase{`/srv/odd\name/testbox.json`, '/', `file:///srv/odd\name/testbox.json`},
+	}
+	for _, tc := range testCases {
+		uri := localPathToUri(tc.LocalPath, tc.Separator)
+		if uri != tc.Uri {
+			t.Fatalf("Expected localPathToUri(%v) to return '%v', but got '%v'\n", tc.LocalPath, tc.Uri, uri)
+		}
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatalf("Could not parse '%v' as a URI: %v\n", uri, err)
+		}
+		if localPath := uriPathToLocalPath(u.Path, tc.Separator); localPath != tc.LocalPath {
+			t.Fatalf("Expected '%v' to map back to '%v', but got '%v'\n", uri, tc.LocalPath, localPath)
+		}
+	}
+}
+
+func TestNormalizeFileUri(t *testing.T) {
+	type TestCase struct {
+		Uri       string
+		Separator rune
+		Expected  string
+	}
+	testCases := []TestCase{
+		TestCase{`file://C:\Users\test\testbox.json`, '\\', "file:///C:/Users/test/testbox.json"},
+		TestCase{`file:///C:\Users\test\testbox.json`, '\\', "file:///C:/Users/test/testbox.json"},
+		TestCase{"file:///C:/Users/test/testbox.json", '\\', "file:///C:/Users/test/testbox.json"},
+		TestCase{`file://C:\Users\test\testbox.json`, '/', "file:///C:/Users/test/testbox.json"},
+		TestCase{`file:///srv/boxes\testbox.json`, '\\', "file:///srv/boxes/testbox.json"},
+		TestCase{`file:///srv/odd\name/testbox.json`, '/', `file:///srv/odd\name/testbox.json`},
+		TestCase{`s3://bucket/C:\testbox.json`, '\\', `s3://bucket/C:\testbox.json`},
+	}
+	for _, tc := range testCases {
+		if actual := normalizeFileUri(tc.Uri, tc.Separator); actual != tc.Expected {
+			t.Fatalf("Expected normalizeFileUri(%v, %q) to return '%v', but got '%v'\n", tc.Uri, tc.Separator, tc.Expected, actual)
+		}
+	}
+
+	// The URIs of box files are joined to a normalized catalog URI with forward slashes
+	boxUri, err := BoxDirUriFromCatalogUri(normalizeFileUri(`file://C:\boxes\testbox.json`, '\\'), "testbox")
+	if err != nil {
+		t.Fatalf("BoxDirUriFromCatalogUri() returned an error: %v\n", err)
+	}
+	if boxUri != "file:///C:/boxes/testbox" {
+		t.Fatalf("Expected the box directory of a Windows catalog to be 'file:///C:/boxes/testbox', but got '%v'\n", boxUri)
+	}
 }
@@ -1,135 +1,1991 @@
 package caryatid
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/url"
-)
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-func NewBackend(name string) (backend CaryatidBackend, err error) {
-	switch name {
-	case "file":
-		backend = &CaryatidLocalFileBackend{}
-	case "s3":
-		backend = &CaryatidS3Backend{}
-	default:
-		err = fmt.Errorf("No known backend with name '%v'", name)
-	}
-	return
-}
+	"github.com/mrled/caryatid/internal/util"
+)
 
-func NewBackendFromUri(uri string) (backend CaryatidBackend, err error) {
-	u, err := url.Parse(uri)
-	if err != nil {
-		err = fmt.Errorf("Error trying to parse URI '%v': %v\n", uri, err)
-		return
-	}
-	backend, err = NewBackend(u.Scheme)
-	return
-}
+// CopyProgressFunc is called periodically as a box file is copied
+// If the size of the box file is not known, total will be <= 0
+type CopyProgressFunc func(transferred int64, total int64)
 
 // Manages Vagrant catalogs via various backends
+// It is safe for concurrent use, as long as its settings are not changed while its methods run; see lockCatalog()
 type BackendManager struct {
 	CatalogUri string
 	Backend    CaryatidBackend
+
+	// Called as box files of at least ProgressThreshold bytes are copied
+	CopyProgress      CopyProgressFunc
+	ProgressThreshold int64
+
+	// Part size for backends that upload in parts, like S3; 0 means DefaultUploadPartSize
+	UploadPartSize int64
+
+	// Permissions for files and directories the local file backend writes; 0 means the umask decides
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// Report what would change without changing anything
+	DryRun bool
+
+	// Refuse to change anything; set when a ReadOnlyBackend is passed to NewBackendManager()
+	ReadOnly bool
+
+	// Accept versions that are not strict semantic versions; see ValidateVersion()
+	AllowNonstandardVersion bool
+
+	// Strip a leading 'v' from added versions; see NormalizeVersion()
+	NormalizeVersions bool
+
+	// Replace boxes that are already in the catalog instead of skipping them
+	Overwrite bool
+
+	// Read each box file back after copying it and check its checksum; see verifyCopiedBox()
+	VerifyAfterCopy bool
+
+	// Keep a '<name>_latest_<provider>.box' alias of the newest box of each provider; see updateLatestAliases()
+	LatestAlias bool
+
+	// How long to wait for another process's lock on the catalog; see CaryatidLockingBackend
+	LockTimeout time.Duration
+
+	// Record box URLs under this prefix instead of their storage URIs; see boxUrl()
+	UrlPrefix string
+
+	// Record box URLs relative to the catalog; see storageUri()
+	RelativeUrls bool
+
+	// How SaveCatalog() formats the catalog; empty means DefaultJSONStyle
+	JSONStyle JSONStyle
+
+	// How many catalog backups SaveCatalog() keeps; see CaryatidBackupBackend
+	CatalogBackups int
+
+	// Template for box file names; empty means DefaultFilenameTemplate
+	FilenameTemplate string
+
+	// How many box files VerifyBoxes() hashes at once
+	Concurrency int
+
+	// Checksums of box files that have not changed since they were hashed
+	ChecksumCache *ChecksumCache
+
+	// Local file to append an AuditEntry to for each box changed; see writeAuditLog()
+	AuditLogPath string
+
+	// Fail if AuditLogPath cannot be written, instead of only logging it
+	AuditLogRequired bool
+
+	// URL to POST each AuditEntry to; see postWebhook()
+	WebhookUrl     string
+	WebhookTimeout time.Duration
+
+	// Manager of the directory whose catalog index is rebuilt after each change; see WriteCatalogIndex()
+	IndexManager *BackendManager
+
+	// Cancelling it stops box files being copied, so that the change in progress rolls back and releases its lock; nil means it is never cancelled
+	// See ProgressReader() and boxFileChanges.copyBoxFile()
+	Context context.Context
+
+	// Where the manager and its backend log; nil means the package Logger
+	Logger Logger
+
+	// The catalog as GetCatalog() last read it, or nil; see Reload()
+	cachedCatalog *Catalog
+	cacheLock     sync.Mutex
+
+	// Held by lockCatalog() so that one goroutine at a time changes the catalog
+	catalogLock sync.Mutex
 }
 
+// DefaultCatalogBackups is the CatalogBackups of a new BackendManager
+const DefaultCatalogBackups = 5
+
+// CatalogBackupSuffix follows the name of the catalog in the names of its backups,
+// which end in a timestamp like 'catalog.json.bak.20240101T120000',
+// followed by a counter like 'catalog.json.bak.20240101T120000.001' for later backups made in the same second
+const CatalogBackupSuffix = ".bak."
+
+// catalogBackupTimeFormat formats the timestamp of a catalog backup, so that sorting backups by name sorts them by age
+const catalogBackupTimeFormat = "20060102T150405"
+
+// catalogBackupTime returns the time used in the name of a new catalog backup
+var catalogBackupTime = time.Now
+
+// DefaultLockTimeout is the LockTimeout of a new BackendManager
+const DefaultLockTimeout = 30 * time.Second
+
+// catalogLockPollInterval is how often lockCatalog() tries again to take a lock that another process holds
+var catalogLockPollInterval = 100 * time.Millisecond
+
 // TODO: Should this also just call NewBackendFromUri()? Why split them out?
 func NewBackendManager(catalogUri string, backend *CaryatidBackend) (bm *BackendManager) {
+	return NewBackendManagerWithLogger(catalogUri, backend, nil)
+}
+
+// NewBackendManagerWithLogger is like NewBackendManager(), but the manager and its backend log through logger,
+// including while the backend is set up; see BackendManager.Logger
+func NewBackendManagerWithLogger(catalogUri string, backend *CaryatidBackend, logger Logger) (bm *BackendManager) {
 	bm = &BackendManager{
-		catalogUri,
-		*backend,
+		CatalogUri:     catalogUri,
+		Backend:        *backend,
+		LockTimeout:    DefaultLockTimeout,
+		WebhookTimeout: DefaultWebhookTimeout,
+		CatalogBackups: DefaultCatalogBackups,
+		Logger:         logger,
 	}
 	bm.Backend.SetManager(bm)
 	return
 }
 
+// log returns bm.Logger, or the package Logger if it is not set or bm is nil
+func (bm *BackendManager) log() Logger {
+	if bm == nil || bm.Logger == nil {
+		return GetLogger()
+	}
+	return bm.Logger
+}
+
+// catalogParentUri returns the URI of the directory (or prefix) that holds the catalog
+func (bm *BackendManager) catalogParentUri() string {
+	return bm.CatalogUri[0:strings.LastIndex(bm.CatalogUri, "/")]
+}
+
+// boxFileUri returns the URI where the backend stores the box file for artifact,
+// in a directory named after the box next to the catalog, with a name from bm.FilenameTemplate
+func (bm *BackendManager) boxFileUri(artifact BoxArtifact) (boxUri string, err error) {
+	boxDirUri, err := BoxDirUriFromCatalogUri(bm.CatalogUri, artifact.Name)
+	if err != nil {
+		return
+	}
+	fileName, err := artifact.fileName(bm.FilenameTemplate)
+	if err != nil {
+		return
+	}
+	boxUri = fmt.Sprintf("%v/%v", boxDirUri, fileName)
+	return
+}
+
+// boxUrl returns the URL that the catalog records for a box file that the backend stores at boxUri
+// This is boxUri itself, unless bm.UrlPrefix or bm.RelativeUrls is set
+func (bm *BackendManager) boxUrl(boxUri string) string {
+	parentUri := bm.catalogParentUri()
+	switch {
+	case !strings.HasPrefix(boxUri, parentUri+"/"):
+		return boxUri
+	case bm.RelativeUrls:
+		return strings.TrimPrefix(boxUri, parentUri+"/")
+	case bm.UrlPrefix != "":
+		return strings.TrimSuffix(bm.UrlPrefix, "/") + strings.TrimPrefix(boxUri, parentUri)
+	}
+	return boxUri
+}
+
+// storageUri returns the URI where the backend stores the box file that a provider URL in the catalog refers to
+// Usually the URL is that URI already, but a box added with a UrlPrefix has a URL somewhere else, like a CDN,
+// and a box added with RelativeUrls has a URL relative to the catalog
+// Relative URLs are resolved against the catalog, and URLs under bm.UrlPrefix are mapped back to the path next to the catalog
+// Other URLs that end in '<name>/<name>_<version>_<provider>.box' are mapped back too,
+// which works even if the UrlPrefix is not set when the catalog is read, as long as the box files have their default names
+func (bm *BackendManager) storageUri(url string) string {
+	if strings.HasPrefix(url, bm.Backend.Scheme()+"://") {
+		return url
+	}
+	if !strings.Contains(url, "://") && url != "" && !strings.HasPrefix(url, "/") {
+		return fmt.Sprintf("%v/%v", bm.catalogParentUri(), url)
+	}
+	if prefix := strings.TrimSuffix(bm.UrlPrefix, "/") + "/"; bm.UrlPrefix != "" && strings.HasPrefix(url, prefix) {
+		return fmt.Sprintf("%v/%v", bm.catalogParentUri(), strings.TrimPrefix(url, prefix))
+	}
+	segments := strings.Split(url, "/")
+	if len(segments) < 2 {
+		return url
+	}
+	dir, file := segments[len(segments)-2], segments[len(segments)-1]
+	if dir == "" || !strings.HasPrefix(file, dir+"_") || !strings.HasSuffix(file, ".box") {
+		return url
+	}
+	return fmt.Sprintf("%v/%v/%v", bm.catalogParentUri(), dir, file)
+}
+
+// BoxFileUri returns the URI of the box file in the backend that ref refers to, which is the file that DeleteBox() or PruneVersions() deletes for it
+// It only resolves the URI, and does not check that the file exists
+func (bm *BackendManager) BoxFileUri(ref BoxReference) string {
+	return bm.storageUri(ref.Uri)
+}
+
+// ProgressReader wraps a reader of a box file so that it reports progress to bm.CopyProgress, and fails once bm.Context is cancelled
+// Backends should use it in CopyBoxFile(); it returns the reader unchanged if there is no Context and progress reporting is not wanted
+func (bm *BackendManager) ProgressReader(reader io.Reader, total int64) io.Reader {
+	if bm.Context != nil {
+		reader = &util.ContextReader{Context: bm.Context, Reader: reader}
+	}
+	if bm.CopyProgress == nil || total < bm.ProgressThreshold {
+		return reader
+	}
+	return &util.ProgressReader{Reader: reader, Total: total, Progress: bm.CopyProgress}
+}
+
+// GetCatalog retrieves the catalog from the backend
+// If there is no catalog yet, the result is an empty catalog, along with an error wrapping ErrCatalogNotFound;
+// callers that can start from an empty catalog can check for it with errors.Is()
+// Catalogs written by older versions of caryatid are migrated to CatalogSchemaVersion in memory; see MigrateCatalog()
+// The catalog is only read from the backend the first time; later calls return a copy of it, until SaveCatalog() saves a new one
+// Call Reload() to read it from the backend again
 func (bm *BackendManager) GetCatalog() (catalog Catalog, err error) {
+	bm.cacheLock.Lock()
+	defer bm.cacheLock.Unlock()
+	if bm.cachedCatalog != nil {
+		bm.log().Debugf("GetCatalog(): Using the cached catalog for '%v'\n", bm.CatalogUri)
+		return bm.cachedCatalog.clone(), nil
+	}
+
 	catalogBytes, err := bm.Backend.GetCatalogBytes()
-	if err != nil {
-		log.Printf("Error trying to get catalog bytes: %v\n", err)
+	if errors.Is(err, ErrCatalogNotFound) {
+		bm.log().Infof("No catalog at '%v'; starting with empty catalog\n", bm.CatalogUri)
+		return
+	} else if err != nil {
+		bm.log().Errorf("Error trying to get catalog bytes: %v\n", err)
 		return
 	}
 
-	err = json.Unmarshal(catalogBytes, &catalog)
+	var document catalogDocument
+	err = json.Unmarshal(catalogBytes, &document)
 	if err != nil {
-		log.Printf("Error unmashalling catalog: %v\ncatalogbytes:\n%v\n", err, catalogBytes)
+		bm.log().Errorf("Error unmashalling catalog: %v\ncatalogbytes:\n%v\n", err, catalogBytes)
 		return
 	}
+	catalog = document.Catalog
+	MigrateCatalog(&catalog, document.SchemaVersion)
+
+	cached := catalog.clone()
+	bm.cachedCatalog = &cached
+	return
+}
+
+// Reload reads the catalog from the backend, even if GetCatalog() has already read it
+func (bm *BackendManager) Reload() (catalog Catalog, err error) {
+	bm.invalidateCatalogCache()
+	return bm.GetCatalog()
+}
+
+// invalidateCatalogCache makes the next call to GetCatalog() read the catalog from the backend
+func (bm *BackendManager) invalidateCatalogCache() {
+	bm.cacheLock.Lock()
+	defer bm.cacheLock.Unlock()
+	bm.cachedCatalog = nil
+}
+
+// readCatalog is like GetCatalog(), but returns an empty catalog without an error if there is no catalog yet
+func (bm *BackendManager) readCatalog() (catalog Catalog, err error) {
+	if catalog, err = bm.GetCatalog(); errors.Is(err, ErrCatalogNotFound) {
+		err = nil
+	}
+	return
+}
 
+// marshalCatalogDocument serializes a catalog as it is saved to a backend, sorted and with caryatid's schema version, in style
+// An empty style means DefaultJSONStyle
+func marshalCatalogDocument(catalog Catalog, style JSONStyle) (jsonData []byte, err error) {
+	document := catalogDocument{catalog.Sorted(), CatalogSchemaVersion}
+	switch style {
+	case "", JSONStylePretty:
+		return json.MarshalIndent(document, "", "  ")
+	case JSONStyleCompact:
+		return json.Marshal(document)
+	}
+	err = fmt.Errorf("Unknown JSON style '%v'; supported styles are: %v", style, JSONStyles())
 	return
 }
 
+// SaveCatalog serializes the catalog, with its versions sorted semantically, and saves it to the backend
+// If bm.CatalogBackups is set, the existing catalog is backed up first; see backupCatalog()
+// The catalog is saved with the current CatalogSchemaVersion, formatted in bm.JSONStyle
 func (bm *BackendManager) SaveCatalog(catalog Catalog) (err error) {
-	jsonData, err := json.MarshalIndent(catalog, "", "  ")
+	if bm.ReadOnly {
+		err = fmt.Errorf("%w: refusing to save the catalog '%v'", ErrBackendReadOnly, bm.CatalogUri)
+		bm.log().Errorf("SaveCatalog(): %v\n", err)
+		return
+	}
+	jsonData, err := marshalCatalogDocument(catalog, bm.JSONStyle)
 	if err != nil {
-		log.Println("Error trying to marshal catalog: ", err)
+		bm.log().Errorf("Error trying to marshal catalog: %v\n", err)
 		return
 	}
+	if err = bm.backupCatalog(); err != nil {
+		bm.log().Errorf("Error backing up catalog; not saving it: %v\n", err)
+		return
+	}
+	// Even a failed save may have changed the catalog in the backend
+	defer bm.invalidateCatalogCache()
 	err = bm.Backend.SetCatalogBytes(jsonData)
 	if err != nil {
-		log.Printf("Error saving catalog: %v\n", err)
+		bm.log().Errorf("Error saving catalog: %v\n", err)
+	}
+	return
+}
+
+// lockCatalog locks the catalog, waiting up to bm.LockTimeout if another process holds the lock
+// Methods that change the catalog call it before reading the catalog, and call unlock after saving it
+// Within this process, it waits for any other goroutine that has locked the catalog through bm to unlock it, however long that takes
+// It clears the cache of GetCatalog(), so that the catalog they read is not stale
+// If the backend does not implement CaryatidLockingBackend, the catalog is not locked, and unlock does nothing
+// If bm.ReadOnly is set, it returns an error wrapping ErrBackendReadOnly, so that those methods fail before changing anything,
+// unless bm.DryRun is also set, in which case the catalog is not locked, since locking may itself write to the backend
+func (bm *BackendManager) lockCatalog() (unlock func(), err error) {
+	if bm.ReadOnly && !bm.DryRun {
+		err = fmt.Errorf("%w: refusing to change the catalog '%v'", ErrBackendReadOnly, bm.CatalogUri)
+		bm.log().Errorf("lockCatalog(): %v\n", err)
+		return func() {}, err
+	}
+
+	bm.catalogLock.Lock()
+	unlock = bm.catalogLock.Unlock
+	defer func() {
+		if err != nil {
+			bm.catalogLock.Unlock()
+			unlock = func() {}
+			return
+		}
+		atomic.AddInt64(&catalogsLocked, 1)
+		release := unlock
+		unlock = func() {
+			release()
+			atomic.AddInt64(&catalogsLocked, -1)
+		}
+	}()
+
+	// Another process may have changed the catalog since it was cached, so the caller must read it again
+	bm.invalidateCatalogCache()
+	if bm.ReadOnly {
+		bm.log().Debugf("lockCatalog(): Not locking the read-only catalog '%v' for a dry run\n", bm.CatalogUri)
+		return
+	}
+	locker, ok := bm.lockingBackend()
+	if !ok {
+		bm.log().Debugf("lockCatalog(): The backend for '%v' does not support locking\n", bm.CatalogUri)
+		return
+	}
+
+	deadline := time.Now().Add(bm.LockTimeout)
+	for {
+		var release func() error
+		release, err = locker.LockCatalog()
+		if err == nil {
+			bm.log().Debugf("lockCatalog(): Locked catalog '%v'\n", bm.CatalogUri)
+			unlock = func() {
+				if releaseErr := release(); releaseErr != nil {
+					bm.log().Errorf("lockCatalog(): Error releasing the lock on catalog '%v': %v\n", bm.CatalogUri, releaseErr)
+				}
+				bm.catalogLock.Unlock()
+			}
+			return
+		} else if err != ErrCatalogLocked {
+			bm.log().Errorf("lockCatalog(): Error locking catalog '%v': %v\n", bm.CatalogUri, err)
+			return
+		} else if !time.Now().Before(deadline) {
+			err = fmt.Errorf("%w; timed out after %v waiting for the lock on catalog '%v'", ErrCatalogLocked, bm.LockTimeout, bm.CatalogUri)
+			return
+		}
+		bm.log().Debugf("lockCatalog(): Catalog '%v' is locked by another process; waiting\n", bm.CatalogUri)
+		time.Sleep(catalogLockPollInterval)
+	}
+}
+
+// catalogsLocked counts the catalogs that lockCatalog() has locked and not yet unlocked, across every BackendManager
+var catalogsLocked int64
+
+// ChangesInProgress reports whether any BackendManager holds the lock on a catalog while it changes it
+// A program that is interrupted then should cancel BackendManager.Context and let the change roll back, rather than exiting right away
+func ChangesInProgress() bool {
+	return atomic.LoadInt64(&catalogsLocked) > 0
+}
+
+// backupCatalog copies the existing catalog to a timestamped sibling, and then deletes all but the newest bm.CatalogBackups backups
+// It does nothing if bm.CatalogBackups is 0, or if the backend does not implement CaryatidBackupBackend
+func (bm *BackendManager) backupCatalog() (err error) {
+	if bm.CatalogBackups <= 0 {
+		return
+	}
+	backuper, ok := bm.backupBackend()
+	if !ok {
+		bm.log().Debugf("backupCatalog(): The backend for '%v' does not support backups\n", bm.CatalogUri)
+		return
+	}
+
+	backups, err := backuper.ListCatalogBackups()
+	if err != nil {
+		return
+	}
+	taken := make(map[string]bool)
+	for _, uri := range backups {
+		taken[catalogBackupTimestamp(uri)] = true
+	}
+	// A backup made in the same second as an earlier one gets a counter, so that it does not replace it,
+	// and so that it still sorts after it and before the backups of the next second
+	timestamp := catalogBackupTime().UTC().Format(catalogBackupTimeFormat)
+	for count, base := 1, timestamp; taken[timestamp]; count++ {
+		timestamp = fmt.Sprintf("%v.%03d", base, count)
+	}
+
+	backupUri, err := backuper.BackupCatalog(CatalogBackupSuffix + timestamp)
+	if err != nil || backupUri == "" {
+		return
+	}
+	bm.log().Infof("backupCatalog(): Backed up catalog to '%v'\n", backupUri)
+
+	if backups, err = backuper.ListCatalogBackups(); err != nil {
+		return
+	}
+	sort.Strings(backups)
+	for idx := 0; idx < len(backups)-bm.CatalogBackups; idx++ {
+		bm.log().Debugf("backupCatalog(): Deleting old catalog backup '%v'\n", backups[idx])
+		if err = bm.Backend.DeleteFile(backups[idx]); err != nil {
+			return
+		}
 	}
 	return
 }
 
-func (bm *BackendManager) AddBox(localPath string, name string, description string, version string, provider string, checksumType string, checksum string) (err error) {
+// AddBox adds the artifact to the catalog and copies its box file to the backend; see AddBoxes()
+func (bm *BackendManager) AddBox(artifact BoxArtifact) (err error) {
+	return bm.AddBoxes([]BoxArtifact{artifact})
+}
+
+// AddBoxes adds several artifacts for the same box name and version to the catalog at once, like a box built for several providers
+// A box that is already in the catalog is replaced if bm.Overwrite is set; otherwise, it is skipped if its checksum is the same,
+// so that adding the same box twice does nothing, and is an error wrapping ErrBoxExists if its checksum is different
+// Every box file is copied to the backend before the catalog is saved, so the catalog never has only some of them
+// If any box file cannot be copied, or the catalog cannot be saved, the box files already copied are deleted again,
+// and box files the catalog already referred to are restored; see boxFileChanges
+func (bm *BackendManager) AddBoxes(artifacts []BoxArtifact) (err error) {
+	if len(artifacts) == 0 {
+		err = fmt.Errorf("No boxes to add")
+		return
+	}
+	// Don't modify the caller's artifacts when filling in their defaults
+	artifacts = append([]BoxArtifact{}, artifacts...)
+	if bm.NormalizeVersions {
+		for idx := range artifacts {
+			artifacts[idx].Version = NormalizeVersion(artifacts[idx].Version)
+		}
+	}
 
-	catalog, err := bm.GetCatalog()
-	if _, err = NewComparableVersion(version); err != nil {
-		log.Printf("AddBox(): Invalid version '%v'\n", version)
+	added := BoxReferenceList{}
+	for _, artifact := range artifacts {
+		if artifact.Name != artifacts[0].Name || artifact.Version != artifacts[0].Version {
+			err = fmt.Errorf("Boxes added together must have the same name and version, but got '%v' version '%v' and '%v' version '%v'", artifacts[0].Name, artifacts[0].Version, artifact.Name, artifact.Version)
+			bm.log().Errorf("AddBoxes(): %v\n", err)
+			return
+		}
+		ref := BoxReference{Version: artifact.Version, ProviderName: artifact.Provider, Architecture: artifact.Architecture}
+		if added.Contains(ref) {
+			err = fmt.Errorf("Provider '%v' was added more than once for version '%v'", artifact.fileProvider(), artifact.Version)
+			bm.log().Errorf("AddBoxes(): %v\n", err)
+			return
+		}
+		added = append(added, ref)
+	}
+	if err = ValidateVersion(artifacts[0].Version, bm.AllowNonstandardVersion); err != nil {
+		bm.log().Errorf("AddBoxes(): %v\n", err)
+		return
 	}
+
+	unlock, err := bm.lockCatalog()
 	if err != nil {
-		log.Printf("AddBox(): Error retrieving catalog from backend: %v\n", err)
 		return
 	}
+	defer unlock()
 
-	err = catalog.AddBox(bm.CatalogUri, name, description, version, provider, checksumType, checksum)
+	catalog, err := bm.readCatalog()
 	if err != nil {
-		log.Printf("AddBox(): Error adding box to catalog metadata object: %v\n", err)
+		bm.log().Errorf("AddBoxes(): Error retrieving catalog from backend: %v\n", err)
 		return
 	}
-	if err = bm.SaveCatalog(catalog); err != nil {
-		log.Printf("AddBox(): Error saving catalog: %v\n", err)
+	changes := bm.newBoxFileChanges(catalog)
+	previousLatest, err := catalog.latestReferences()
+	if err != nil {
+		bm.log().Errorf("AddBoxes(): %v\n", err)
+		return
+	}
+
+	pending := []BoxArtifact{}
+	added = BoxReferenceList{}
+	for _, artifact := range artifacts {
+		existing := catalog.findProvider(artifact.Version, artifact.Provider, artifact.Architecture)
+		switch {
+		case existing == nil:
+		case bm.Overwrite:
+			bm.log().Infof("AddBoxes(): Replacing provider '%v' of version '%v'\n", artifact.Provider, artifact.Version)
+		case existing.ChecksumType == artifact.ChecksumType && existing.Checksum == artifact.Checksum:
+			bm.log().Infof("AddBoxes(): Version '%v' already has provider '%v' with the same checksum; not adding it again\n", artifact.Version, artifact.Provider)
+			continue
+		default:
+			err = fmt.Errorf("%w: version '%v' already has provider '%v' with a different checksum", ErrBoxExists, artifact.Version, artifact.Provider)
+			bm.log().Errorf("AddBoxes(): %v\n", err)
+			return
+		}
+		pending = append(pending, artifact)
+		added = append(added, BoxReference{Version: artifact.Version, ProviderName: artifact.Provider, Architecture: artifact.Architecture})
+	}
+	if len(pending) == 0 {
+		return
+	}
+	artifacts = pending
+
+	now := time.Now()
+	boxUris := make([]string, len(artifacts))
+	for idx := range artifacts {
+		artifact := &artifacts[idx]
+		if artifact.ReleasedAt.IsZero() {
+			artifact.ReleasedAt = now
+		}
+		if boxUris[idx], err = bm.boxFileUri(*artifact); err != nil {
+			bm.log().Errorf("AddBoxes(): Error determining where to store the box file: %v\n", err)
+			return
+		}
+		if artifact.Url == "" {
+			artifact.Url = bm.boxUrl(boxUris[idx])
+		}
+		if err = catalog.AddBox(bm.CatalogUri, *artifact); err != nil {
+			bm.log().Errorf("AddBoxes(): Error adding box to catalog metadata object: %v\n", err)
+			return
+		}
+	}
+
+	for idx, artifact := range artifacts {
+		if err = changes.copyBoxFile(boxUris[idx], func() error { return bm.Backend.CopyBoxFile(artifact.Path, boxUris[idx]) }); err != nil {
+			bm.log().Errorf("AddBoxes(): Error copying box file: %v\n", err)
+			changes.rollback()
+			return
+		}
+		if bm.VerifyAfterCopy {
+			if err = bm.verifyCopiedBox(artifact, boxUris[idx]); err != nil {
+				bm.log().Errorf("AddBoxes(): %v\n", err)
+				changes.rollback()
+				return
+			}
+		}
+	}
+	if err = changes.saveCatalog(catalog); err != nil {
+		bm.log().Errorf("AddBoxes(): Error saving catalog: %v\n", err)
+		return
+	}
+	err = bm.updateLatestAliases(previousLatest, catalog, added)
+	if auditErr := bm.recordChanges(auditEntries(AuditActionAdd, catalog, added)); err == nil {
+		err = auditErr
+	}
+	return
+}
+
+// verifyCopiedBox checks that the box file copied to boxUri has the checksum of artifact, returning an error wrapping ErrChecksumMismatch if not
+// If the artifact has an md5 checksum and the backend knows the MD5 of the box file, they are compared instead of reading it back;
+// see CaryatidContentMD5Backend
+func (bm *BackendManager) verifyCopiedBox(artifact BoxArtifact, boxUri string) (err error) {
+	if artifact.ChecksumType == "" || artifact.Checksum == "" {
+		return fmt.Errorf("Cannot verify the box file copied to '%v' without a checksum", boxUri)
+	}
+
+	checksumType, expected := artifact.ChecksumType, artifact.Checksum
+	digest := ""
+	if reporter, ok := bm.contentMD5Backend(); ok {
+		expectedMD5 := ""
+		for _, checksum := range append([]Checksum{{Type: checksumType, Value: expected}}, artifact.Checksums...) {
+			if checksum.Type == "md5" {
+				expectedMD5 = checksum.Value
+			}
+		}
+		// If the MD5 is unknown, or can't be retrieved, reading the box file back below still checks it
+		if md5sum, known, md5err := reporter.FileMD5(boxUri); expectedMD5 != "" && md5err == nil && known {
+			checksumType, expected, digest = "md5", expectedMD5, md5sum
+			bm.log().Debugf("verifyCopiedBox(): Using the MD5 that the backend reports for '%v'\n", boxUri)
+		}
+	}
+
+	if digest == "" {
+		hasher, hashErr := NewChecksumHash(checksumType)
+		if hashErr != nil {
+			return hashErr
+		}
+		reader, openErr := bm.Backend.OpenFile(boxUri)
+		if openErr != nil {
+			return fmt.Errorf("Could not open the box file copied to '%v' to verify it: %v", boxUri, openErr)
+		}
+		defer reader.Close()
+		if digest, err = util.HashReader(reader, hasher); err != nil {
+			return fmt.Errorf("Could not read the box file copied to '%v' to verify it: %v", boxUri, err)
+		}
+	}
+
+	if !strings.EqualFold(digest, strings.TrimSpace(expected)) {
+		return fmt.Errorf("%w: expected the %v checksum of the box file copied to '%v' to be '%v', but it is '%v'", ErrChecksumMismatch, checksumType, boxUri, expected, digest)
+	}
+	bm.log().Infof("verifyCopiedBox(): The box file copied to '%v' has the expected %v checksum\n", boxUri, checksumType)
+	return
+}
+
+// replacedBoxFileUri returns where a box file that is about to be replaced is kept until the catalog is saved,
+// like "file:///srv/vagrant/testbox/testbox_1.0.0_virtualbox.replaced.box"
+// It ends in ".box", so that if it is left behind, CollectGarbage() finds it
+func replacedBoxFileUri(boxUri string) string {
+	return strings.TrimSuffix(boxUri, ".box") + ".replaced.box"
+}
+
+// boxFileChanges tracks the box files copied to the backend for a change to the catalog, so that they can be rolled back if the change fails
+// A box file that the catalog already refers to is backed up before it is replaced, and the backup is restored when rolling back,
+// so that the catalog never refers to a box file that does not match its checksum
+type boxFileChanges struct {
+	bm         *BackendManager
+	referenced map[string]bool
+
+	// The box files that were copied, in order
+	copied []string
+
+	// The backups of the box files that were replaced, keyed by the URI of the box file
+	backups map[string]string
+}
+
+// newBoxFileChanges starts tracking the box files copied for a change to catalog
+func (bm *BackendManager) newBoxFileChanges(catalog Catalog) *boxFileChanges {
+	changes := &boxFileChanges{bm: bm, referenced: make(map[string]bool), backups: make(map[string]string)}
+	for _, ref := range catalog.BoxReferences() {
+		changes.referenced[bm.storageUri(ref.Uri)] = true
+	}
+	return changes
+}
+
+// copyBoxFile runs copy, which copies a box file to boxUri
+// If the catalog refers to the box file at boxUri, it is backed up first, and copy is not run if that fails
+// Nothing is copied once bm.Context is cancelled, so that the caller rolls back instead
+func (changes *boxFileChanges) copyBoxFile(boxUri string, copy func() error) (err error) {
+	bm := changes.bm
+	if bm.Context != nil && bm.Context.Err() != nil {
+		err = fmt.Errorf("Not copying a box file to '%v': %w", boxUri, bm.Context.Err())
+		return
+	}
+	if _, backedUp := changes.backups[boxUri]; changes.referenced[boxUri] && !backedUp {
+		backupUri := replacedBoxFileUri(boxUri)
+		if err = bm.copyFileFrom(bm, boxUri, backupUri); err != nil {
+			err = fmt.Errorf("Could not back up the box file at '%v' before replacing it: %v", boxUri, err)
+			return
+		}
+		changes.backups[boxUri] = backupUri
+	}
+	if err = copy(); err != nil {
+		return
+	}
+	changes.copied = append(changes.copied, boxUri)
+	return
+}
+
+// rollback deletes the box files that were copied, and restores the box files they replaced
+func (changes *boxFileChanges) rollback() {
+	bm := changes.bm
+	for _, boxUri := range changes.copied {
+		if _, backedUp := changes.backups[boxUri]; backedUp {
+			continue
+		}
+		if err := bm.Backend.DeleteFile(boxUri); err != nil {
+			bm.log().Errorf("rollback(): Error deleting '%v' while rolling back: %v\n", boxUri, err)
+		}
+	}
+	for boxUri, backupUri := range changes.backups {
+		if err := bm.moveFile(backupUri, boxUri); err != nil {
+			bm.log().Errorf("rollback(): Error restoring the box file at '%v' from '%v' while rolling back: %v\n", boxUri, backupUri, err)
+		}
+	}
+}
+
+// commit deletes the backups of the box files that were replaced, once the catalog that refers to their replacements is saved
+func (changes *boxFileChanges) commit() {
+	bm := changes.bm
+	for _, backupUri := range changes.backups {
+		if err := bm.Backend.DeleteFile(backupUri); err != nil {
+			bm.log().Warnf("commit(): Error deleting '%v', the backup of a box file that was replaced: %v\n", backupUri, err)
+		}
+	}
+}
+
+// saveCatalog saves catalog, and then commits the changes, or rolls them back if it could not be saved
+// A save that timed out may still land after it was abandoned (see TimeoutBackend), and then the catalog would refer to the box files just copied,
+// so in that case nothing is deleted; CollectGarbage() deletes whichever box files and backups the catalog does not refer to
+func (changes *boxFileChanges) saveCatalog(catalog Catalog) (err error) {
+	bm := changes.bm
+	if err = bm.SaveCatalog(catalog); err == nil {
+		changes.commit()
 		return
 	}
-	if err = bm.Backend.CopyBoxFile(localPath, name, version, provider); err != nil {
-		log.Printf("AddBox(): Error copying box file: %v\n", err)
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		bm.log().Warnf("saveCatalog(): Saving the catalog timed out, so it may or may not refer to the box files just copied; leaving them and any backups in place\n")
 		return
 	}
+	changes.rollback()
 	return
 }
 
-func (bm *BackendManager) DeleteBox(params CatalogQueryParams) (err error) {
+// DeleteBox deletes the boxes matched by params, removing both their catalog entries and their box files
+// If params match only some providers of a version, the rest are kept; a version is removed once it has no providers left
+// The result lists the boxes that were deleted, or that would have been deleted if bm.DryRun is set
+func (bm *BackendManager) DeleteBox(params CatalogQueryParams) (deleted BoxReferenceList, err error) {
 	var (
 		catalog       Catalog
 		deleteCatalog Catalog
-		refs          BoxReferenceList
 	)
 
-	if catalog, err = bm.GetCatalog(); err != nil {
-		log.Printf("DeleteBox(): Error retrieving catalog from backend: %v\n", err)
+	unlock, err := bm.lockCatalog()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("DeleteBox(): Error retrieving catalog from backend: %v\n", err)
 		return
 	}
 	if deleteCatalog, err = catalog.QueryCatalog(params); err != nil {
-		log.Printf("DeleteBox(): Error querying catalog: %v\n", err)
+		bm.log().Errorf("DeleteBox(): Error querying catalog: %v\n", err)
 		return
 	}
 
-	refs = deleteCatalog.BoxReferences()
-	catalog = catalog.DeleteReferences(refs)
-	if err = bm.SaveCatalog(catalog); err != nil {
-		log.Printf("DeleteBox(): Error saving catalog: %v\n", err)
+	deleted = deleteCatalog.BoxReferences()
+	if bm.DryRun {
+		bm.log().Infof("DeleteBox(): Dry run; not deleting anything\n")
+		return
+	}
+	err = bm.deleteReferences(AuditActionDelete, catalog, catalog.DeleteReferences(deleted), deleteCatalog)
+	return
+}
+
+// deleteReferences saves result, which is catalog without the providers in removed, and then deletes their box files from the backend
+// A box file that result still refers to, like one shared by duplicate providers, is kept
+// The deletions are recorded in the audit log as action
+func (bm *BackendManager) deleteReferences(action string, catalog Catalog, result Catalog, removed Catalog) (err error) {
+	refs := removed.BoxReferences()
+	entries := auditEntries(action, removed, refs)
+	previousLatest, err := catalog.latestReferences()
+	if err != nil {
+		bm.log().Errorf("deleteReferences(): %v\n", err)
+		return
+	}
+	if err = bm.SaveCatalog(result); err != nil {
+		bm.log().Errorf("deleteReferences(): Error saving catalog: %v\n", err)
 		return
 	}
 
+	kept := make(map[string]bool)
+	for _, ref := range result.BoxReferences() {
+		kept[ref.Uri] = true
+	}
 	for _, ref := range refs {
-		if err = bm.Backend.DeleteFile(ref.Uri); err != nil {
-			log.Printf("DeleteBox(): Error copying box file: %v\n", err)
-			return
+		if kept[ref.Uri] {
+			continue
 		}
+		if err = bm.Backend.DeleteFile(bm.storageUri(ref.Uri)); err != nil {
+			bm.log().Errorf("deleteReferences(): Error deleting box file: %v\n", err)
+			break
+		}
+		kept[ref.Uri] = true
+	}
+	if err == nil {
+		err = bm.updateLatestAliases(previousLatest, result, refs)
+	}
+
+	if auditErr := bm.recordChanges(entries); err == nil {
+		err = auditErr
+	}
+	return
+}
+
+// latestAliasUri returns the URI of the alias that bm.LatestAlias keeps for a provider of a box,
+// like '<name>/<name>_latest_<provider>.box', or '<name>/<name>_latest_<provider>_<architecture>.box' for a provider with an architecture
+// Unlike box files, aliases are always named this way, regardless of bm.FilenameTemplate
+func (bm *BackendManager) latestAliasUri(name string, providerName string, architecture string) (aliasUri string, err error) {
+	boxDirUri, err := BoxDirUriFromCatalogUri(bm.CatalogUri, name)
+	if err != nil {
+		return
+	}
+	artifact := BoxArtifact{Provider: providerName, Architecture: architecture}
+	aliasUri = fmt.Sprintf("%v/%v_latest_%v.box", boxDirUri, name, artifact.fileProvider())
+	return
+}
+
+// isLatestAlias returns true if uri is named like an alias that bm.LatestAlias keeps for a box called name
+// No box file is named like this, because 'latest' is not a valid version; see ValidateVersion()
+func (bm *BackendManager) isLatestAlias(name string, uri string) bool {
+	aliasPrefix, err := bm.latestAliasUri(name, "", "")
+	if err != nil {
+		return false
 	}
+	return strings.HasPrefix(uri, strings.TrimSuffix(aliasPrefix, ".box")) && strings.HasSuffix(uri, ".box")
+}
 
+// updateLatestAliases updates the alias of each provider and architecture in changed, if bm.LatestAlias is set; see latestAliasUri()
+// previous holds the newest box of each provider before the change; see Catalog.latestReferences()
+// An alias is pointed at the box file of the newest version if that version was changed, or if the newest version is not what it was,
+// and it is deleted if the catalog has no versions of the provider left
+func (bm *BackendManager) updateLatestAliases(previous map[string]BoxReference, catalog Catalog, changed BoxReferenceList) (err error) {
+	var (
+		latest   map[string]BoxReference
+		aliasUri string
+	)
+	if !bm.LatestAlias {
+		return
+	}
+	if latest, err = catalog.latestReferences(); err != nil {
+		bm.log().Errorf("updateLatestAliases(): %v\n", err)
+		return
+	}
+
+	updated := make(map[string]bool)
+	for _, ref := range changed {
+		artifact := BoxArtifact{Provider: ref.ProviderName, Architecture: ref.Architecture}
+		key := artifact.fileProvider()
+		if updated[key] {
+			continue
+		}
+		updated[key] = true
+		if aliasUri, err = bm.latestAliasUri(catalog.Name, ref.ProviderName, ref.Architecture); err != nil {
+			return
+		}
+
+		newest, ok := latest[key]
+		switch {
+		case !ok:
+			bm.log().Infof("updateLatestAliases(): Deleting '%v', since there are no versions of provider '%v' left\n", aliasUri, artifact.fileProvider())
+			if err = bm.Backend.DeleteFile(aliasUri); errors.Is(err, ErrBoxNotFound) {
+				err = nil
+			}
+		case newest.Version == ref.Version || newest.Version != previous[key].Version:
+			bm.log().Infof("updateLatestAliases(): Pointing '%v' at version '%v'\n", aliasUri, newest.Version)
+			err = bm.linkFile(bm.storageUri(newest.Uri), aliasUri)
+		}
+		if err != nil {
+			bm.log().Errorf("updateLatestAliases(): Error updating '%v': %v\n", aliasUri, err)
+			return
+		}
+	}
+	return
+}
+
+// linkFile makes linkUri an alias of the file at targetUri
+// It uses the backend's LinkFile() if it implements CaryatidLinkingBackend, and copies the file otherwise
+func (bm *BackendManager) linkFile(targetUri string, linkUri string) error {
+	if linker, ok := bm.linkingBackend(); ok {
+		return linker.LinkFile(targetUri, linkUri)
+	}
+	return bm.copyFileFrom(bm, targetUri, linkUri)
+}
+
+// RemoveLatestAliases deletes every alias that bm.LatestAlias kept for the catalog's box
+// The result lists the URIs of the aliases, which were deleted unless bm.DryRun is set
+func (bm *BackendManager) RemoveLatestAliases() (removed []string, err error) {
+	var (
+		catalog  Catalog
+		boxFiles []string
+	)
+
+	unlock, err := bm.lockCatalog()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("RemoveLatestAliases(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	if catalog.Name == "" {
+		err = fmt.Errorf("The catalog has no name, so its aliases cannot be found")
+		return
+	}
+	if boxFiles, err = bm.Backend.ListBoxFiles(catalog.Name); err != nil {
+		bm.log().Errorf("RemoveLatestAliases(): Error listing box files: %v\n", err)
+		return
+	}
+	for _, uri := range boxFiles {
+		if bm.isLatestAlias(catalog.Name, uri) {
+			removed = append(removed, uri)
+		}
+	}
+
+	if bm.DryRun {
+		return
+	}
+	for _, uri := range removed {
+		if err = bm.Backend.DeleteFile(uri); err != nil {
+			bm.log().Errorf("RemoveLatestAliases(): Error deleting alias: %v\n", err)
+			return
+		}
+	}
+	return
+}
+
+// PruneVersions deletes all but the keep newest versions of the boxes matched by params,
+// removing both their catalog entries and their box files
+// If prunePrereleases is set, prerelease versions are always pruned, and do not count towards keep
+// The result lists the boxes that were pruned, or that would have been pruned if bm.DryRun is set
+func (bm *BackendManager) PruneVersions(params CatalogQueryParams, keep int, prunePrereleases bool) (pruned BoxReferenceList, err error) {
+	return bm.pruneCatalog("PruneVersions", params, func(queryCatalog *Catalog) (Catalog, error) {
+		return queryCatalog.PruneCandidates(keep, prunePrereleases)
+	})
+}
+
+// PruneToSize deletes the oldest versions of the boxes matched by params until the rest fit in maxTotalSize bytes; see Catalog.PruneToSize()
+// It is like PruneVersions(), but also returns the total size of the boxes matched by params that are kept
+// If the catalog does not record the size of a matched box, the result is an error wrapping ErrUnknownSize, and nothing is pruned
+func (bm *BackendManager) PruneToSize(params CatalogQueryParams, maxTotalSize int64, prunePrereleases bool) (pruned BoxReferenceList, remaining int64, err error) {
+	pruned, err = bm.pruneCatalog("PruneToSize", params, func(queryCatalog *Catalog) (result Catalog, err error) {
+		result, remaining, err = queryCatalog.PruneToSize(maxTotalSize, prunePrereleases)
+		return
+	})
+	return
+}
+
+// pruneCatalog deletes the boxes that candidates picks from the boxes matched by params,
+// removing both their catalog entries and their box files, unless bm.DryRun is set
+// The caller is the name of the public method, for log messages
+func (bm *BackendManager) pruneCatalog(caller string, params CatalogQueryParams, candidates func(queryCatalog *Catalog) (Catalog, error)) (pruned BoxReferenceList, err error) {
+	var (
+		catalog      Catalog
+		queryCatalog Catalog
+		pruneCatalog Catalog
+	)
+
+	unlock, err := bm.lockCatalog()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("%v(): Error retrieving catalog from backend: %v\n", caller, err)
+		return
+	}
+	if queryCatalog, err = catalog.QueryCatalog(params); err != nil {
+		bm.log().Errorf("%v(): Error querying catalog: %v\n", caller, err)
+		return
+	}
+	if pruneCatalog, err = candidates(&queryCatalog); err != nil {
+		bm.log().Errorf("%v(): %v\n", caller, err)
+		return
+	}
+	pruned = pruneCatalog.BoxReferences()
+
+	if len(pruned) == 0 {
+		return
+	}
+	if bm.DryRun {
+		bm.log().Infof("%v(): Dry run; not deleting anything\n", caller)
+		return
+	}
+	err = bm.deleteReferences(AuditActionPrune, catalog, catalog.DeleteReferences(pruned), pruneCatalog)
+	return
+}
+
+// TagVersions adds the tags in add to, and removes the tags in remove from, each version matched by params; see Catalog.TagVersions()
+// The result lists the versions whose tags changed, or would have changed if bm.DryRun is set
+// It is an error if params matches no versions
+func (bm *BackendManager) TagVersions(params CatalogQueryParams, add []string, remove []string) (tagged []string, err error) {
+	var (
+		catalog       Catalog
+		queryCatalog  Catalog
+		taggedCatalog Catalog
+	)
+
+	unlock, err := bm.lockCatalog()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("TagVersions(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	if queryCatalog, err = catalog.QueryCatalog(params); err != nil {
+		bm.log().Errorf("TagVersions(): Error querying catalog: %v\n", err)
+		return
+	}
+	if len(queryCatalog.Versions) == 0 {
+		err = fmt.Errorf("No versions in the catalog at '%v' matched the query", bm.CatalogUri)
+		return
+	}
+	versions := []string{}
+	for _, v := range queryCatalog.Versions {
+		versions = append(versions, v.Version)
+	}
+	if taggedCatalog, tagged, err = catalog.TagVersions(versions, add, remove); err != nil {
+		bm.log().Errorf("TagVersions(): %v\n", err)
+		return
+	}
+
+	if len(tagged) == 0 {
+		return
+	}
+	if bm.DryRun {
+		bm.log().Infof("TagVersions(): Dry run; not saving the catalog\n")
+		return
+	}
+	if err = bm.SaveCatalog(taggedCatalog); err != nil {
+		bm.log().Errorf("TagVersions(): Error saving catalog: %v\n", err)
+		return
+	}
+	refs := BoxReferenceList{}
+	for _, ref := range taggedCatalog.BoxReferences() {
+		if util.StringInSlice(tagged, ref.Version) {
+			refs = append(refs, ref)
+		}
+	}
+	err = bm.recordChanges(auditEntries(AuditActionTag, taggedCatalog, refs))
+	return
+}
+
+// BoxVerification is the result of checking one box file against its entry in the catalog
+type BoxVerification struct {
+	Version      string
+	ProviderName string
+	Uri          string
+
+	// Nil if the box file exists and its checksum matches the catalog
+	Err error
+}
+
+func (bv *BoxVerification) Passed() bool {
+	return bv.Err == nil
+}
+
+// hashBoxFile streams a box file from the backend through a hash of type checksumType, returning its checksum and its size in bytes
+// If the box file has not changed since its checksum was saved to bm.ChecksumCache, the cached checksum is returned instead, with a size of 0,
+// unless needSize is set
+func (bm *BackendManager) hashBoxFile(uri string, checksumType string, needSize bool) (digest string, size int64, err error) {
+	hasher, err := NewChecksumHash(checksumType)
+	if err != nil {
+		return
+	}
+	storageUri := bm.storageUri(uri)
+
+	fingerprint := ""
+	if fingerprinter, ok := bm.fingerprintBackend(); ok && bm.ChecksumCache != nil {
+		// If the box file can't be fingerprinted, opening it below fails with a better error
+		if fingerprint, err = fingerprinter.FileFingerprint(storageUri); err != nil {
+			fingerprint = ""
+		} else if cached, ok := bm.ChecksumCache.Get(storageUri, checksumType, fingerprint); ok && !needSize {
+			bm.log().Debugf("hashBoxFile(): Using the cached %v checksum of '%v'\n", checksumType, storageUri)
+			return cached, 0, nil
+		}
+	}
+
+	reader, err := bm.Backend.OpenFile(storageUri)
+	if err != nil {
+		return "", 0, fmt.Errorf("Could not open box file: %v", err)
+	}
+	defer reader.Close()
+
+	counter := &util.ProgressReader{Reader: reader, Progress: func(transferred int64, total int64) { size = transferred }}
+	digest, err = util.HashReader(counter, hasher)
+	if err != nil {
+		return "", 0, fmt.Errorf("Could not read box file: %v", err)
+	}
+	if fingerprint != "" {
+		bm.ChecksumCache.Put(storageUri, checksumType, fingerprint, digest)
+	}
+	return
+}
+
+// saveChecksumCache saves bm.ChecksumCache, if there is one
+// The cache only saves time, so failing to save it is logged rather than returned
+func (bm *BackendManager) saveChecksumCache() {
+	if bm.ChecksumCache == nil {
+		return
+	}
+	if err := bm.ChecksumCache.Save(); err != nil {
+		bm.log().Warnf("Could not save the checksum cache to '%v': %v\n", bm.ChecksumCache.Path, err)
+	}
+}
+
+// verifyBox checks that the box file for a provider exists in the backend and matches its checksum
+func (bm *BackendManager) verifyBox(provider Provider) (err error) {
+	if provider.ChecksumType == "" || provider.Checksum == "" {
+		return fmt.Errorf("The catalog has no checksum for this box")
+	}
+	digest, _, err := bm.hashBoxFile(provider.Url, provider.ChecksumType, false)
+	if err != nil {
+		return
+	}
+	if !strings.EqualFold(digest, strings.TrimSpace(provider.Checksum)) {
+		return fmt.Errorf("Expected %v checksum '%v' but the box file has '%v'", provider.ChecksumType, provider.Checksum, digest)
+	}
+	return
+}
+
+// VerifyBoxes checks every box matched by params, returning one result per provider
+// Up to bm.Concurrency boxes are checked at once, but the results are always in the same order as the catalog
+// A box that fails verification does not cause an error, or stop the others from being checked;
+// err is only set if the catalog itself could not be queried
+func (bm *BackendManager) VerifyBoxes(params CatalogQueryParams) (results []BoxVerification, err error) {
+	var (
+		catalog       Catalog
+		verifyCatalog Catalog
+	)
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("VerifyBoxes(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	if verifyCatalog, err = catalog.QueryCatalog(params); err != nil {
+		bm.log().Errorf("VerifyBoxes(): Error querying catalog: %v\n", err)
+		return
+	}
+
+	var providers []Provider
+	for _, v := range verifyCatalog.Versions {
+		for _, p := range v.Providers {
+			results = append(results, BoxVerification{Version: v.Version, ProviderName: p.Name, Uri: p.Url})
+			providers = append(providers, p)
+		}
+	}
+
+	// Each worker writes only to the results of the boxes it checks, so the results need no lock
+	concurrency := bm.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	indexes := make(chan int)
+	var workers sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for idx := range indexes {
+				results[idx].Err = bm.verifyBox(providers[idx])
+			}
+		}()
+	}
+	for idx := range results {
+		indexes <- idx
+	}
+	close(indexes)
+	workers.Wait()
+	bm.saveChecksumCache()
+
+	for _, result := range results {
+		if !result.Passed() {
+			bm.log().Errorf("VerifyBoxes(): Box at '%v' failed verification: %v\n", result.Uri, result.Err)
+		}
+	}
+	return
+}
+
+// ChecksumUpdate is the result of recomputing the checksum of one box file in the catalog
+type ChecksumUpdate struct {
+	Version      string
+	ProviderName string
+	Architecture string
+	Uri          string
+
+	OldChecksumType string
+	OldChecksum     string
+	NewChecksumType string
+	NewChecksum     string
+
+	// The size of the box file in bytes, if the catalog did not record it before; otherwise 0
+	NewSize int64
+
+	// Set if the box was skipped, for instance because its box file is missing
+	Err error
+}
+
+func (cu *ChecksumUpdate) Changed() bool {
+	return cu.Err == nil && (cu.OldChecksumType != cu.NewChecksumType || cu.OldChecksum != cu.NewChecksum || cu.NewSize > 0)
+}
+
+// RecomputeChecksums rehashes every box matched by params with checksumType,
+// and saves the new checksums to the catalog unless bm.DryRun is set
+// Boxes added before caryatid recorded sizes get their sizes recorded too
+// Boxes that cannot be read are skipped, with the reason recorded in the Err field of their result
+// Hashing every box can take a long time, so the catalog is only locked once the boxes have been hashed;
+// a box that was changed by someone else in the meantime is skipped too
+func (bm *BackendManager) RecomputeChecksums(params CatalogQueryParams, checksumType string) (results []ChecksumUpdate, err error) {
+	var (
+		catalog       Catalog
+		updateCatalog Catalog
+		refs          BoxReferenceList
+		changed       bool
+	)
+
+	if checksumType == "" {
+		checksumType = DefaultChecksumType
+	}
+	if _, err = NewChecksumHash(checksumType); err != nil {
+		return
+	}
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("RecomputeChecksums(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	if updateCatalog, err = catalog.QueryCatalog(params); err != nil {
+		bm.log().Errorf("RecomputeChecksums(): Error querying catalog: %v\n", err)
+		return
+	}
+	refs = updateCatalog.BoxReferences()
+
+	for _, version := range catalog.Versions {
+		for _, provider := range version.Providers {
+			if !refs.Contains(BoxReference{Version: version.Version, ProviderName: provider.Name, Architecture: provider.Architecture}) {
+				continue
+			}
+
+			result := ChecksumUpdate{
+				Version:         version.Version,
+				ProviderName:    provider.Name,
+				Architecture:    provider.Architecture,
+				Uri:             provider.Url,
+				OldChecksumType: provider.ChecksumType,
+				OldChecksum:     provider.Checksum,
+				NewChecksumType: checksumType,
+			}
+			needSize := provider.Size <= 0
+			var size int64
+			if result.NewChecksum, size, result.Err = bm.hashBoxFile(provider.Url, checksumType, needSize); result.Err != nil {
+				bm.log().Errorf("RecomputeChecksums(): WARNING: Skipping box at '%v': %v\n", provider.Url, result.Err)
+			} else if needSize {
+				result.NewSize = size
+			}
+			changed = changed || result.Changed()
+			results = append(results, result)
+		}
+	}
+	bm.saveChecksumCache()
+
+	if !changed {
+		return
+	}
+	if bm.DryRun {
+		bm.log().Infof("RecomputeChecksums(): Dry run; not saving catalog\n")
+		return
+	}
+
+	unlock, err := bm.lockCatalog()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("RecomputeChecksums(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	updated := BoxReferenceList{}
+	for rIdx := range results {
+		result := &results[rIdx]
+		if !result.Changed() {
+			continue
+		}
+		provider := catalog.findProvider(result.Version, result.ProviderName, result.Architecture)
+		if provider == nil || provider.Url != result.Uri || provider.ChecksumType != result.OldChecksumType || provider.Checksum != result.OldChecksum {
+			result.Err = fmt.Errorf("The box was changed or removed while its checksum was being recomputed")
+			bm.log().Errorf("RecomputeChecksums(): WARNING: Skipping box at '%v': %v\n", result.Uri, result.Err)
+			continue
+		}
+		provider.ChecksumType = result.NewChecksumType
+		provider.Checksum = result.NewChecksum
+		if result.NewSize > 0 {
+			provider.Size = result.NewSize
+		}
+		updated = append(updated, BoxReference{Version: result.Version, ProviderName: result.ProviderName, Architecture: result.Architecture})
+	}
+
+	if len(updated) == 0 {
+		return
+	}
+	if err = bm.SaveCatalog(catalog); err != nil {
+		bm.log().Errorf("RecomputeChecksums(): Error saving catalog: %v\n", err)
+		return
+	}
+	err = bm.recordChanges(auditEntries(AuditActionChecksum, catalog, updated))
+	return
+}
+
+// providerBoxUri returns the URI where this backend stores the box file for a provider of a box called name
+func (bm *BackendManager) providerBoxUri(name string, version string, provider Provider) (string, error) {
+	return bm.boxFileUri(BoxArtifact{Name: name, Version: version, Provider: provider.Name, Architecture: provider.Architecture})
+}
+
+// copyFileFrom copies the file at fromUri in the source backend to toUri in this one,
+// by way of a temporary local file
+func (bm *BackendManager) copyFileFrom(source *BackendManager, fromUri string, toUri string) (err error) {
+	reader, err := source.Backend.OpenFile(fromUri)
+	if err != nil {
+		return fmt.Errorf("Could not open box file: %v", err)
+	}
+	defer reader.Close()
+
+	tempFile, err := ioutil.TempFile("", "caryatid-copy")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = io.Copy(tempFile, reader)
+	tempFile.Close()
+	if err != nil {
+		return fmt.Errorf("Could not download box file: %v", err)
+	}
+
+	return bm.Backend.CopyBoxFile(tempFile.Name(), toUri)
+}
+
+// MergeCatalog merges the catalog managed by source into this one, and saves it
+// If overwrite is set, boxes in the source catalog replace boxes with the same version, provider, and architecture here;
+// otherwise, those boxes are left alone
+// If copyBoxes is set, box files are copied from the source backend, and their URLs are rewritten to point to the copies;
+// otherwise, merged boxes still refer to box files in the source backend
+// If a box file cannot be copied, or the catalog cannot be saved, the copies are rolled back as in AddBoxes()
+// If bm.LatestAlias is set, the aliases are updated as in AddBoxes(), but only if copyBoxes is set,
+// since an alias cannot refer to a box file in the source backend
+// The result lists the boxes that were merged from the source catalog, or that would have been merged if bm.DryRun is set
+func (bm *BackendManager) MergeCatalog(source *BackendManager, copyBoxes bool, overwrite bool) (merged BoxReferenceList, err error) {
+	var (
+		catalog       Catalog
+		sourceCatalog Catalog
+		incoming      Catalog
+	)
+
+	unlock, err := bm.lockCatalog()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("MergeCatalog(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	if sourceCatalog, err = source.readCatalog(); err != nil {
+		bm.log().Errorf("MergeCatalog(): Error retrieving source catalog: %v\n", err)
+		return
+	}
+
+	// Merging into an empty catalog collapses any duplicate providers in the source
+	incoming = sourceCatalog.Merge(&Catalog{}, false)
+	if !overwrite {
+		incoming = incoming.DeleteReferences(catalog.BoxReferences())
+	}
+	if bm.DryRun {
+		merged = incoming.BoxReferences()
+		bm.log().Infof("MergeCatalog(): Dry run; not merging anything\n")
+		return
+	}
+	previousLatest, err := catalog.latestReferences()
+	if err != nil {
+		bm.log().Errorf("MergeCatalog(): %v\n", err)
+		return
+	}
+
+	name := catalog.Name
+	if name == "" {
+		name = sourceCatalog.Name
+	}
+	changes := bm.newBoxFileChanges(catalog)
+	if copyBoxes {
+		for vidx := range incoming.Versions {
+			version := &incoming.Versions[vidx]
+			for pidx := range version.Providers {
+				provider := &version.Providers[pidx]
+				var boxUri string
+				if boxUri, err = bm.providerBoxUri(name, version.Version, *provider); err == nil {
+					err = changes.copyBoxFile(boxUri, func() error { return bm.copyFileFrom(source, source.storageUri(provider.Url), boxUri) })
+				}
+				if err != nil {
+					bm.log().Errorf("MergeCatalog(): Error copying box file for %v %v: %v\n", version.Version, provider.Name, err)
+					changes.rollback()
+					return
+				}
+				provider.Url = bm.boxUrl(boxUri)
+			}
+		}
+	}
+
+	catalog = catalog.Merge(&incoming, overwrite)
+	if err = changes.saveCatalog(catalog); err != nil {
+		bm.log().Errorf("MergeCatalog(): Error saving catalog: %v\n", err)
+		return
+	}
+	merged = incoming.BoxReferences()
+	if copyBoxes {
+		err = bm.updateLatestAliases(previousLatest, catalog, merged)
+	} else if bm.LatestAlias {
+		bm.log().Warnf("MergeCatalog(): Not updating the latest aliases, since the merged boxes refer to box files in the source backend\n")
+	}
+	if auditErr := bm.recordChanges(auditEntries(AuditActionAdd, catalog, merged)); err == nil {
+		err = auditErr
+	}
+	return
+}
+
+// CopyBoxes copies the boxes matched by params from the catalog managed by source into this one, along with their box files,
+// like promoting boxes from a staging catalog to a production one
+// The copies are recorded with URLs for this backend, and the catalog is only saved once every box file has been copied;
+// boxes already here are handled as in AddBoxes(), according to bm.Overwrite
+// If bm.LatestAlias is set, the aliases are updated as in AddBoxes()
+// If move is set, the boxes are then deleted from the source catalog, along with their box files
+// The result lists the boxes that were copied, or that would have been copied if bm.DryRun is set
+func (bm *BackendManager) CopyBoxes(source *BackendManager, params CatalogQueryParams, move bool) (copied BoxReferenceList, err error) {
+	var (
+		catalog       Catalog
+		sourceCatalog Catalog
+		matched       Catalog
+		incoming      Catalog
+	)
+	if bm.CatalogUri == source.CatalogUri {
+		err = fmt.Errorf("Cannot copy boxes from a catalog into itself")
+		return
+	}
+
+	unlock, err := bm.lockCatalog()
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if move {
+		var unlockSource func()
+		if unlockSource, err = source.lockCatalog(); err != nil {
+			return
+		}
+		defer unlockSource()
+	}
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("CopyBoxes(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	previousLatest, err := catalog.latestReferences()
+	if err != nil {
+		bm.log().Errorf("CopyBoxes(): %v\n", err)
+		return
+	}
+	if sourceCatalog, err = source.GetCatalog(); err != nil {
+		bm.log().Errorf("CopyBoxes(): Error retrieving source catalog: %v\n", err)
+		return
+	}
+	if matched, err = sourceCatalog.QueryCatalog(params); err != nil {
+		bm.log().Errorf("CopyBoxes(): Error querying source catalog: %v\n", err)
+		return
+	}
+	// Merging into an empty catalog collapses any duplicate providers in the source
+	incoming = matched.Merge(&Catalog{}, false)
+
+	name := catalog.Name
+	if name == "" {
+		name = sourceCatalog.Name
+		catalog.Name = sourceCatalog.Name
+		catalog.DisplayName = sourceCatalog.DisplayName
+		catalog.Description = sourceCatalog.Description
+	}
+	changes := bm.newBoxFileChanges(catalog)
+
+	skipped := BoxReferenceList{}
+	for _, v := range incoming.Versions {
+		for _, p := range v.Providers {
+			existing := catalog.findProvider(v.Version, p.Name, p.Architecture)
+			switch {
+			case existing == nil:
+			case bm.Overwrite:
+				bm.log().Infof("CopyBoxes(): Replacing provider '%v' of version '%v'\n", p.Name, v.Version)
+			case existing.ChecksumType == p.ChecksumType && existing.Checksum == p.Checksum:
+				bm.log().Infof("CopyBoxes(): Version '%v' already has provider '%v' with the same checksum; not copying it again\n", v.Version, p.Name)
+				skipped = append(skipped, BoxReference{Version: v.Version, ProviderName: p.Name, Architecture: p.Architecture})
+			default:
+				err = fmt.Errorf("%w: version '%v' already has provider '%v' with a different checksum", ErrBoxExists, v.Version, p.Name)
+				bm.log().Errorf("CopyBoxes(): %v\n", err)
+				return
+			}
+		}
+	}
+	// Boxes that were already here are removed from the source too when moving, since they have been promoted all the same
+	moved := incoming.BoxReferences()
+	toCopy := incoming.DeleteReferences(skipped)
+	if bm.DryRun {
+		copied = toCopy.BoxReferences()
+		bm.log().Infof("CopyBoxes(): Dry run; not copying anything\n")
+		return
+	}
+
+	for vidx := range toCopy.Versions {
+		version := &toCopy.Versions[vidx]
+		for pidx := range version.Providers {
+			provider := &version.Providers[pidx]
+			var boxUri string
+			if boxUri, err = bm.providerBoxUri(name, version.Version, *provider); err == nil && move && boxUri == source.storageUri(provider.Url) {
+				err = fmt.Errorf("The box file for %v %v is already at '%v', so it cannot be moved there", version.Version, provider.Name, boxUri)
+			}
+			if err == nil {
+				err = changes.copyBoxFile(boxUri, func() error { return bm.copyFileFrom(source, source.storageUri(provider.Url), boxUri) })
+			}
+			if err != nil {
+				bm.log().Errorf("CopyBoxes(): Error copying box file for %v %v: %v\n", version.Version, provider.Name, err)
+				changes.rollback()
+				return
+			}
+			provider.Url = bm.boxUrl(boxUri)
+		}
+	}
+
+	catalog = catalog.Merge(&toCopy, true)
+	if err = changes.saveCatalog(catalog); err != nil {
+		bm.log().Errorf("CopyBoxes(): Error saving catalog: %v\n", err)
+		return
+	}
+	copied = toCopy.BoxReferences()
+	err = bm.updateLatestAliases(previousLatest, catalog, copied)
+
+	if move {
+		if moveErr := source.deleteReferences(AuditActionDelete, sourceCatalog, sourceCatalog.DeleteReferences(moved), matched); moveErr != nil {
+			bm.log().Errorf("CopyBoxes(): Error deleting moved boxes from the source catalog: %v\n", moveErr)
+			if err == nil {
+				err = moveErr
+			}
+		}
+	}
+	if auditErr := bm.recordChanges(auditEntries(AuditActionAdd, catalog, copied)); err == nil {
+		err = auditErr
+	}
+	return
+}
+
+// Deduplicate removes duplicate Providers from the catalog and saves it; see Catalog.Deduplicate()
+// The box files of the removed Providers are deleted too, unless a remaining Provider still refers to them
+// The catalog is only saved if it had duplicates and bm.DryRun is not set
+func (bm *BackendManager) Deduplicate() (removed int, err error) {
+	var (
+		catalog      Catalog
+		deduplicated Catalog
+	)
+
+	unlock, err := bm.lockCatalog()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("Deduplicate(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	if deduplicated, removed = catalog.Deduplicate(); removed == 0 {
+		return
+	}
+	if bm.DryRun {
+		bm.log().Infof("Deduplicate(): Dry run; not saving catalog\n")
+		return
+	}
+	err = bm.deleteReferences(AuditActionDelete, catalog, deduplicated, catalog.duplicateProviders())
+	return
+}
+
+// BoxMove records the old and new locations of a box file that was moved
+type BoxMove struct {
+	Version      string
+	ProviderName string
+	OldUri       string
+	NewUri       string
+}
+
+// moveBoxFile moves the box file for provider so that it belongs to a box called name,
+// and returns its new URI
+// If the box file is already there, it is left alone; see moveFile()
+func (bm *BackendManager) moveBoxFile(name string, version string, provider Provider) (uri string, err error) {
+	if uri, err = bm.providerBoxUri(name, version, provider); err != nil {
+		return
+	}
+	storageUri := bm.storageUri(provider.Url)
+	if uri == storageUri {
+		bm.log().Debugf("moveBoxFile(): The box file for %v %v is already at '%v'\n", version, provider.Name, uri)
+		return
+	}
+	err = bm.moveFile(storageUri, uri)
+	return
+}
+
+// moveFile moves the file at fromUri to toUri
+// It uses the backend's MoveFile() if it implements CaryatidMoveBackend, and copies and then deletes the file otherwise
+func (bm *BackendManager) moveFile(fromUri string, toUri string) (err error) {
+	if mover, ok := bm.Backend.(CaryatidMoveBackend); ok {
+		if err = mover.MoveFile(fromUri, toUri); !errors.Is(err, ErrMoveNotSupported) {
+			return
+		}
+	}
+	if err = bm.copyFileFrom(bm, fromUri, toUri); err != nil {
+		return
+	}
+	err = bm.Backend.DeleteFile(fromUri)
+	return
+}
+
+// RenameCatalog changes the Name of the catalog, moves each of its box files to match, and saves it
+// The catalog itself stays at bm.CatalogUri
+// If a box file cannot be moved, the box files that were already moved are moved back, and the catalog is not saved
+// Aliases kept by bm.LatestAlias are named after the catalog, so they are replaced too; see renameLatestAliases()
+// The result lists each box file that was moved, or that would have been moved if bm.DryRun is set
+func (bm *BackendManager) RenameCatalog(newName string) (moves []BoxMove, err error) {
+	var (
+		catalog Catalog
+		moved   []BoxMove
+	)
+
+	if newName == "" {
+		err = fmt.Errorf("Cannot rename a catalog to an empty name")
+		return
+	}
+
+	unlock, err := bm.lockCatalog()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("RenameCatalog(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	if newName == catalog.Name {
+		err = fmt.Errorf("The catalog '%v' is already named '%v'", bm.CatalogUri, newName)
+		return
+	}
+
+	for vidx := range catalog.Versions {
+		version := &catalog.Versions[vidx]
+		for pidx := range version.Providers {
+			provider := &version.Providers[pidx]
+			move := BoxMove{Version: version.Version, ProviderName: provider.Name, OldUri: provider.Url}
+			if bm.DryRun {
+				if move.NewUri, err = bm.providerBoxUri(newName, version.Version, *provider); err != nil {
+					return
+				}
+			} else if move.NewUri, err = bm.moveBoxFile(newName, version.Version, *provider); err != nil {
+				bm.log().Errorf("RenameCatalog(): Error moving box file '%v': %v\n", provider.Url, err)
+				bm.unmoveBoxFiles(moved)
+				return
+			} else {
+				if move.NewUri != bm.storageUri(provider.Url) {
+					moved = append(moved, move)
+				}
+				provider.Url = bm.boxUrl(move.NewUri)
+			}
+			moves = append(moves, move)
+		}
+	}
+
+	if bm.DryRun {
+		bm.log().Infof("RenameCatalog(): Dry run; not saving catalog\n")
+		return
+	}
+	oldName := catalog.Name
+	catalog.Name = newName
+	if err = bm.SaveCatalog(catalog); err != nil {
+		bm.log().Errorf("RenameCatalog(): Error saving catalog: %v\n", err)
+		// A save that timed out may have happened all the same, and then the box files belong where they are now
+		var timeoutErr *TimeoutError
+		if !errors.As(err, &timeoutErr) {
+			bm.unmoveBoxFiles(moved)
+		}
+		return
+	}
+	err = bm.renameLatestAliases(oldName, catalog)
+	if auditErr := bm.recordChanges(auditEntries(AuditActionRename, catalog, catalog.BoxReferences())); err == nil {
+		err = auditErr
+	}
+	return
+}
+
+// unmoveBoxFiles moves the box files in moves back where they were, newest move first
+// It is best effort, since it is only called when something has already gone wrong, so failures are only logged
+func (bm *BackendManager) unmoveBoxFiles(moves []BoxMove) {
+	for idx := len(moves) - 1; idx >= 0; idx-- {
+		oldUri := bm.storageUri(moves[idx].OldUri)
+		if err := bm.moveFile(moves[idx].NewUri, oldUri); err != nil {
+			bm.log().Errorf("unmoveBoxFiles(): Error moving box file '%v' back to '%v': %v\n", moves[idx].NewUri, oldUri, err)
+		}
+	}
+}
+
+// renameLatestAliases replaces the aliases that bm.LatestAlias kept for a box called oldName with aliases named after catalog
+// The new aliases are made if bm.LatestAlias is set, or if there were aliases under the old name; see latestAliasUri()
+func (bm *BackendManager) renameLatestAliases(oldName string, catalog Catalog) (err error) {
+	var (
+		boxFiles   []string
+		oldAliases []string
+		latest     map[string]BoxReference
+		aliasUri   string
+	)
+	if oldName != "" {
+		if boxFiles, err = bm.Backend.ListBoxFiles(oldName); err != nil {
+			bm.log().Errorf("renameLatestAliases(): Error listing box files: %v\n", err)
+			return
+		}
+	}
+	for _, uri := range boxFiles {
+		if bm.isLatestAlias(oldName, uri) {
+			oldAliases = append(oldAliases, uri)
+		}
+	}
+	if !bm.LatestAlias && len(oldAliases) == 0 {
+		return
+	}
+
+	if latest, err = catalog.latestReferences(); err != nil {
+		bm.log().Errorf("renameLatestAliases(): %v\n", err)
+		return
+	}
+	for _, ref := range latest {
+		if aliasUri, err = bm.latestAliasUri(catalog.Name, ref.ProviderName, ref.Architecture); err != nil {
+			return
+		}
+		bm.log().Infof("renameLatestAliases(): Pointing '%v' at version '%v'\n", aliasUri, ref.Version)
+		if err = bm.linkFile(bm.storageUri(ref.Uri), aliasUri); err != nil {
+			bm.log().Errorf("renameLatestAliases(): Error updating '%v': %v\n", aliasUri, err)
+			return
+		}
+	}
+	for _, uri := range oldAliases {
+		if err = bm.Backend.DeleteFile(uri); err != nil {
+			bm.log().Errorf("renameLatestAliases(): Error deleting '%v': %v\n", uri, err)
+			return
+		}
+	}
+	return
+}
+
+// CollectGarbage deletes box files that are stored for the catalog's box, but that the catalog does not refer to
+// These may be left behind by failed or interrupted operations
+// Aliases kept by bm.LatestAlias are not collected; see RemoveLatestAliases()
+// The result lists the URIs of those files, which were deleted unless bm.DryRun is set
+func (bm *BackendManager) CollectGarbage() (orphans []string, err error) {
+	var (
+		catalog  Catalog
+		boxFiles []string
+	)
+
+	unlock, err := bm.lockCatalog()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("CollectGarbage(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	if catalog.Name == "" {
+		err = fmt.Errorf("The catalog has no name, so its box files cannot be found")
+		return
+	}
+	if boxFiles, err = bm.Backend.ListBoxFiles(catalog.Name); err != nil {
+		bm.log().Errorf("CollectGarbage(): Error listing box files: %v\n", err)
+		return
+	}
+
+	referenced := make(map[string]bool)
+	for _, ref := range catalog.BoxReferences() {
+		referenced[bm.storageUri(ref.Uri)] = true
+	}
+	for _, uri := range boxFiles {
+		if !referenced[uri] && !bm.isLatestAlias(catalog.Name, uri) {
+			orphans = append(orphans, uri)
+		}
+	}
+
+	if bm.DryRun {
+		return
+	}
+	for _, uri := range orphans {
+		if err = bm.Backend.DeleteFile(uri); err != nil {
+			bm.log().Errorf("CollectGarbage(): Error deleting box file: %v\n", err)
+			return
+		}
+	}
+	return
+}
+
+// CheckCatalog validates the structure of the catalog; see Catalog.Check()
+// If deep is set, it also checks that the box file for each provider exists in the backend
+func (bm *BackendManager) CheckCatalog(deep bool) (problems []CatalogProblem, err error) {
+	var catalog Catalog
+
+	if catalog, err = bm.readCatalog(); err != nil {
+		bm.log().Errorf("CheckCatalog(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	problems = catalog.Check()
+	if !deep {
+		return
+	}
+
+	for _, ref := range catalog.BoxReferences() {
+		reader, openErr := bm.Backend.OpenFile(bm.storageUri(ref.Uri))
+		if openErr != nil {
+			problems = append(problems, CatalogProblem{Version: ref.Version, ProviderName: ref.ProviderName, Message: fmt.Sprintf("Could not open box file: %v", openErr)})
+			continue
+		}
+		reader.Close()
+	}
+	return
+}
+
+// ValidateCatalog checks the catalog as it is serialized in the backend against what Vagrant expects; see ValidateVagrantSchema()
+// If there is no catalog yet, the result is an error wrapping ErrCatalogNotFound
+func (bm *BackendManager) ValidateCatalog() (violations []SchemaViolation, err error) {
+	catalogBytes, err := bm.Backend.GetCatalogBytes()
+	if err != nil {
+		bm.log().Errorf("ValidateCatalog(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	violations = ValidateVagrantSchema(catalogBytes)
+	return
+}
+
+// ValidateCatalogSchema checks the catalog as it is serialized in the backend against CatalogJSONSchema; see ValidateCatalogJSONSchema()
+// If there is no catalog yet, the result is an error wrapping ErrCatalogNotFound
+func (bm *BackendManager) ValidateCatalogSchema() (violations []SchemaViolation, err error) {
+	catalogBytes, err := bm.Backend.GetCatalogBytes()
+	if err != nil {
+		bm.log().Errorf("ValidateCatalogSchema(): Error retrieving catalog from backend: %v\n", err)
+		return
+	}
+	violations = ValidateCatalogJSONSchema(catalogBytes)
+	return
+}
+
+// unwrappedBackend returns the backend, looking through any RetryBackend, TimeoutBackend, or ReadOnlyBackend wrapping it
+// It is only for checking which optional interfaces the backend implements; call them through the helpers below
+func (bm *BackendManager) unwrappedBackend() CaryatidBackend {
+	backend := bm.Backend
+	for {
+		switch wrapper := backend.(type) {
+		case *RetryBackend:
+			backend = wrapper.Backend
+		case *TimeoutBackend:
+			backend = wrapper.Backend
+		case *ReadOnlyBackend:
+			backend = wrapper.Backend
+		default:
+			return backend
+		}
+	}
+}
+
+// The helpers below return the backend as one of the optional interfaces, if the backend inside any wrappers implements it
+// The wrappers implement every optional interface themselves, so the result is the outermost wrapper,
+// and the call is still retried, timed out, or refused as the wrappers do for the required methods
+
+// linkingBackend returns the backend as a CaryatidLinkingBackend
+func (bm *BackendManager) linkingBackend() (linker CaryatidLinkingBackend, ok bool) {
+	if _, ok = bm.unwrappedBackend().(CaryatidLinkingBackend); ok {
+		linker, ok = bm.Backend.(CaryatidLinkingBackend)
+	}
+	return
+}
+
+// signingBackend returns the backend as a CaryatidSigningBackend
+func (bm *BackendManager) signingBackend() (signer CaryatidSigningBackend, ok bool) {
+	if _, ok = bm.unwrappedBackend().(CaryatidSigningBackend); ok {
+		signer, ok = bm.Backend.(CaryatidSigningBackend)
+	}
+	return
+}
+
+// listingBackend returns the backend as a CaryatidListingBackend
+func (bm *BackendManager) listingBackend() (lister CaryatidListingBackend, ok bool) {
+	if _, ok = bm.unwrappedBackend().(CaryatidListingBackend); ok {
+		lister, ok = bm.Backend.(CaryatidListingBackend)
+	}
+	return
+}
+
+// fingerprintBackend returns the backend as a CaryatidFingerprintBackend
+func (bm *BackendManager) fingerprintBackend() (fingerprinter CaryatidFingerprintBackend, ok bool) {
+	if _, ok = bm.unwrappedBackend().(CaryatidFingerprintBackend); ok {
+		fingerprinter, ok = bm.Backend.(CaryatidFingerprintBackend)
+	}
+	return
+}
+
+// contentMD5Backend returns the backend as a CaryatidContentMD5Backend
+func (bm *BackendManager) contentMD5Backend() (reporter CaryatidContentMD5Backend, ok bool) {
+	if _, ok = bm.unwrappedBackend().(CaryatidContentMD5Backend); ok {
+		reporter, ok = bm.Backend.(CaryatidContentMD5Backend)
+	}
+	return
+}
+
+// lockingBackend returns the backend as a CaryatidLockingBackend
+func (bm *BackendManager) lockingBackend() (locker CaryatidLockingBackend, ok bool) {
+	if _, ok = bm.unwrappedBackend().(CaryatidLockingBackend); ok {
+		locker, ok = bm.Backend.(CaryatidLockingBackend)
+	}
+	return
+}
+
+// backupBackend returns the backend as a CaryatidBackupBackend
+func (bm *BackendManager) backupBackend() (backuper CaryatidBackupBackend, ok bool) {
+	if _, ok = bm.unwrappedBackend().(CaryatidBackupBackend); ok {
+		backuper, ok = bm.Backend.(CaryatidBackupBackend)
+	}
+	return
+}
+
+// rangeBackend returns the backend as a CaryatidRangeBackend
+func (bm *BackendManager) rangeBackend() (ranger CaryatidRangeBackend, ok bool) {
+	if _, ok = bm.unwrappedBackend().(CaryatidRangeBackend); ok {
+		ranger, ok = bm.Backend.(CaryatidRangeBackend)
+	}
+	return
+}
+
+// CanSignUrls returns true if the backend can generate signed URLs; see CaryatidSigningBackend
+func (bm *BackendManager) CanSignUrls() bool {
+	_, ok := bm.signingBackend()
+	return ok
+}
+
+// SignCatalogUrls replaces the URL of each provider in the catalog with a signed URL that expires after ttl
+// If the backend cannot sign URLs, the catalog is unchanged
+// Relative URLs are resolved against the catalog before they are signed; see storageUri()
+// URLs that refer somewhere other than the backend, like those added with a UrlPrefix, are not signed
+func (bm *BackendManager) SignCatalogUrls(catalog *Catalog, ttl time.Duration) (err error) {
+	signer, ok := bm.signingBackend()
+	if !ok {
+		return
+	}
+	for vIdx := range catalog.Versions {
+		for pIdx := range catalog.Versions[vIdx].Providers {
+			provider := &catalog.Versions[vIdx].Providers[pIdx]
+			boxUri := provider.Url
+			if !strings.Contains(boxUri, "://") {
+				boxUri = bm.storageUri(boxUri)
+			}
+			if !strings.HasPrefix(boxUri, bm.Backend.Scheme()+"://") {
+				continue
+			}
+			if provider.Url, err = signer.SignedUrl(boxUri, ttl); err != nil {
+				bm.log().Errorf("SignCatalogUrls(): Error signing URL for %v %v: %v\n", catalog.Versions[vIdx].Version, provider.Name, err)
+				return
+			}
+		}
+	}
 	return
 }
@@ -1,8 +1,26 @@
 package caryatid
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/mrled/caryatid/internal/util"
 )
 
 type CaryatidTestBackend struct {
@@ -36,14 +54,22 @@ func (cb *CaryatidTestBackend) SetCatalogBytes(serializedCatalog []byte) (err er
 	return nil
 }
 
-func (cb *CaryatidTestBackend) CopyBoxFile(path string, boxName string, boxVersion string, boxProvider string) error {
+func (cb *CaryatidTestBackend) CopyBoxFile(path string, boxUri string) error {
 	return nil
 }
 
+func (cb *CaryatidTestBackend) OpenFile(uri string) (io.ReadCloser, error) {
+	return nil, &os.PathError{Op: "open", Path: uri, Err: os.ErrNotExist}
+}
+
 func (bc *CaryatidTestBackend) DeleteFile(uri string) error {
 	return nil
 }
 
+func (cb *CaryatidTestBackend) ListBoxFiles(boxName string) ([]string, error) {
+	return nil, nil
+}
+
 func (backend *CaryatidTestBackend) Scheme() string {
 	return "Test"
 }
@@ -70,9 +96,9 @@ func TestBackendManagerConfigure(t *testing.T) {
 	}
 
 	expectedCata := Catalog{
-		boxName, boxDesc, []Version{
-			Version{boxVersion, []Provider{
-				Provider{boxProvider, boxPath, boxDigestType, boxDigest},
+		Name: boxName, Description: boxDesc, Versions: []Version{
+			Version{Version: boxVersion, Providers: []Provider{
+				Provider{Name: boxProvider, Url: boxPath, ChecksumType: boxDigestType, Checksum: boxDigest},
 			}},
 		},
 	}
@@ -93,3 +119,2453 @@ func TestBackendManagerConfigure(t *testing.T) {
 		t.Fatal(fmt.Sprintf("Backend Manager property not set properly; value was '%v'; error was '%v'", backendManager, err))
 	}
 }
+
+func TestBackendManagerProgressReader(t *testing.T) {
+	var (
+		calls       int
+		transferred int64
+		total       int64
+		backend     CaryatidBackend = &CaryatidTestBackend{}
+		manager                     = NewBackendManager("http://example.com/cata/ProgressBox.json", &backend)
+		contents                    = make([]byte, 100)
+	)
+
+	if reader := manager.ProgressReader(bytes.NewReader(contents), int64(len(contents))); reader == nil {
+		t.Fatalf("ProgressReader() returned nil without a CopyProgress function\n")
+	}
+
+	manager.CopyProgress = func(t int64, tot int64) {
+		calls += 1
+		transferred = t
+		total = tot
+	}
+	manager.ProgressThreshold = 1000
+	ioutil.ReadAll(manager.ProgressReader(bytes.NewReader(contents), int64(len(contents))))
+	if calls != 0 {
+		t.Fatalf("Progress was reported for a file smaller than ProgressThreshold\n")
+	}
+
+	manager.ProgressThreshold = 10
+	ioutil.ReadAll(manager.ProgressReader(bytes.NewReader(contents), int64(len(contents))))
+	if calls == 0 {
+		t.Fatalf("Progress was not reported for a file larger than ProgressThreshold\n")
+	}
+	if transferred != int64(len(contents)) || total != int64(len(contents)) {
+		t.Fatalf("Final progress report was %v of %v bytes, but expected %v of %v\n", transferred, total, len(contents), len(contents))
+	}
+}
+
+func TestBackendManagerVerifyBoxes(t *testing.T) {
+	var (
+		boxName     = "TestVerifyBoxesBox"
+		boxDesc     = "TestVerifyBoxesBox is a test box"
+		boxProvider = "StrongSapling"
+		boxPath     = path.Join(integrationTestDir, "incoming-TestVerifyBoxesBox.box")
+		catalogUri  = fmt.Sprintf("mem://TestBackendManagerVerifyBoxes/%v.json", boxName)
+	)
+
+	if err := CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	digest, err := util.HashFile(boxPath, sha256.New())
+	if err != nil {
+		t.Fatalf("Error trying to hash test box file: %v\n", err)
+	}
+
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+
+	// 1.0.0 is intact, 1.0.1 has the wrong checksum, 1.0.2 is intact but has its checksum in uppercase, and 2.0.0 is missing its box file
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: boxDesc, Version: "1.0.0", Provider: boxProvider, ChecksumType: "sha256", Checksum: digest}); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: boxDesc, Version: "1.0.1", Provider: boxProvider, ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: boxDesc, Version: "1.0.2", Provider: boxProvider, ChecksumType: "sha256", Checksum: strings.ToUpper(digest)}); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: boxDesc, Version: "2.0.0", Provider: boxProvider, ChecksumType: "sha256", Checksum: digest}); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+	missingUri, _ := BoxUriFromCatalogUri(catalogUri, boxName, "2.0.0", boxProvider)
+	if err = backend.DeleteFile(missingUri); err != nil {
+		t.Fatalf("Error deleting box file: %v\n", err)
+	}
+
+	type TestCase struct {
+		Params   CatalogQueryParams
+		Expected map[string]bool
+	}
+	testCases := []TestCase{
+		TestCase{CatalogQueryParams{}, map[string]bool{"1.0.0": true, "1.0.1": false, "1.0.2": true, "2.0.0": false}},
+		TestCase{CatalogQueryParams{Version: "<2"}, map[string]bool{"1.0.0": true, "1.0.1": false, "1.0.2": true}},
+		TestCase{CatalogQueryParams{Version: "=1.0.0"}, map[string]bool{"1.0.0": true}},
+		TestCase{CatalogQueryParams{Provider: "FeebleFungus"}, map[string]bool{}},
+	}
+
+	for _, tc := range testCases {
+		results, err := manager.VerifyBoxes(tc.Params)
+		if err != nil {
+			t.Fatalf("VerifyBoxes(%v) returned an error: %v\n", tc.Params, err)
+		}
+		if len(results) != len(tc.Expected) {
+			t.Fatalf("VerifyBoxes(%v) returned %v results, but expected %v: %v\n", tc.Params, len(results), len(tc.Expected), results)
+		}
+		for _, result := range results {
+			expectPass, ok := tc.Expected[result.Version]
+			if !ok {
+				t.Fatalf("VerifyBoxes(%v) returned unexpected version %v\n", tc.Params, result.Version)
+			}
+			if result.Passed() != expectPass {
+				t.Fatalf("VerifyBoxes(%v): expected version %v to pass=%v, but got error: %v\n", tc.Params, result.Version, expectPass, result.Err)
+			}
+		}
+	}
+}
+
+func TestBackendManagerVerifyBoxesConcurrently(t *testing.T) {
+	var (
+		boxName    = "TestVerifyConcurrentBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestVerifyConcurrentBox.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerVerifyBoxesConcurrently/%v.json", boxName)
+		providers  = []string{"StrongSapling", "FeebleFungus", "MightyMoss"}
+		versions   = []string{"1.0.0", "1.1.0", "1.2.0", "2.0.0", "2.1.0"}
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	digest, err := util.HashFile(boxPath, sha256.New())
+	if err != nil {
+		t.Fatalf("Error trying to hash test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+
+	// Every FeebleFungus box has the wrong checksum
+	for _, version := range versions {
+		for _, provider := range providers {
+			checksum := digest
+			if provider == "FeebleFungus" {
+				checksum = "0xB00B1E5"
+			}
+			if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: version, Provider: provider, ChecksumType: "sha256", Checksum: checksum}); err != nil {
+				t.Fatalf("Error adding box to catalog: %v\n", err)
+			}
+		}
+	}
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	expectedRefs := catalog.BoxReferences()
+
+	for _, concurrency := range []int{0, 1, 4, 100} {
+		manager.Concurrency = concurrency
+		results, err := manager.VerifyBoxes(CatalogQueryParams{})
+		if err != nil {
+			t.Fatalf("VerifyBoxes() with concurrency %v returned an error: %v\n", concurrency, err)
+		}
+		if len(results) != len(expectedRefs) {
+			t.Fatalf("VerifyBoxes() with concurrency %v returned %v results, but expected %v\n", concurrency, len(results), len(expectedRefs))
+		}
+		for idx, result := range results {
+			if result.Version != expectedRefs[idx].Version || result.ProviderName != expectedRefs[idx].ProviderName {
+				t.Fatalf("VerifyBoxes() with concurrency %v returned %v %v in position %v, but expected %v %v\n",
+					concurrency, result.Version, result.ProviderName, idx, expectedRefs[idx].Version, expectedRefs[idx].ProviderName)
+			}
+			if expectPass := result.ProviderName != "FeebleFungus"; result.Passed() != expectPass {
+				t.Fatalf("VerifyBoxes() with concurrency %v: expected %v %v to pass=%v, but got error: %v\n", concurrency, result.Version, result.ProviderName, expectPass, result.Err)
+			}
+		}
+	}
+}
+
+func TestBackendManagerRecomputeChecksums(t *testing.T) {
+	var (
+		boxName     = "TestRecomputeChecksumsBox"
+		boxDesc     = "TestRecomputeChecksumsBox is a test box"
+		boxProvider = "StrongSapling"
+		boxPath     = path.Join(integrationTestDir, "incoming-TestRecomputeChecksumsBox.box")
+		catalogUri  = fmt.Sprintf("mem://TestBackendManagerRecomputeChecksums/%v.json", boxName)
+	)
+
+	if err := CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	md5Digest, err := util.HashFile(boxPath, md5.New())
+	if err != nil {
+		t.Fatalf("Error trying to hash test box file: %v\n", err)
+	}
+	sha256Digest, err := util.HashFile(boxPath, sha256.New())
+	if err != nil {
+		t.Fatalf("Error trying to hash test box file: %v\n", err)
+	}
+
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+
+	// 2.0.0 is missing its box file, and should be skipped rather than causing an error
+	for _, version := range []string{"1.0.0", "1.0.1", "2.0.0"} {
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: boxDesc, Version: version, Provider: boxProvider, ChecksumType: "md5", Checksum: md5Digest}); err != nil {
+			t.Fatalf("Error adding box to catalog: %v\n", err)
+		}
+	}
+	missingUri, _ := BoxUriFromCatalogUri(catalogUri, boxName, "2.0.0", boxProvider)
+	if err = backend.DeleteFile(missingUri); err != nil {
+		t.Fatalf("Error deleting box file: %v\n", err)
+	}
+
+	checksumTypes := func() (types map[string]string) {
+		types = make(map[string]string)
+		catalog, err := manager.GetCatalog()
+		if err != nil {
+			t.Fatalf("Error getting catalog: %v\n", err)
+		}
+		for _, v := range catalog.Versions {
+			types[v.Version] = v.Providers[0].ChecksumType
+		}
+		return
+	}
+
+	manager.DryRun = true
+	results, err := manager.RecomputeChecksums(CatalogQueryParams{Version: "<2"}, "sha256")
+	if err != nil {
+		t.Fatalf("RecomputeChecksums() returned an error during a dry run: %v\n", err)
+	}
+	if len(results) != 2 || !results[0].Changed() || !results[1].Changed() {
+		t.Fatalf("Expected two changed results from a dry run, but got %v\n", results)
+	}
+	if types := checksumTypes(); types["1.0.0"] != "md5" || types["1.0.1"] != "md5" {
+		t.Fatalf("A dry run modified the catalog: %v\n", types)
+	}
+
+	auditLogPath := path.Join(integrationTestDir, "TestBackendManagerRecomputeChecksums.jsonl")
+	os.Remove(auditLogPath)
+	manager.AuditLogPath = auditLogPath
+	manager.DryRun = false
+	results, err = manager.RecomputeChecksums(CatalogQueryParams{}, "sha256")
+	if err != nil {
+		t.Fatalf("RecomputeChecksums() returned an error: %v\n", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected three results, but got %v\n", results)
+	}
+	for _, result := range results {
+		if result.Version == "2.0.0" {
+			if result.Err == nil {
+				t.Fatalf("Expected the missing box to be skipped, but got %v\n", result)
+			}
+		} else if result.Err != nil || result.NewChecksum != sha256Digest {
+			t.Fatalf("Expected a sha256 digest of '%v', but got %v\n", sha256Digest, result)
+		}
+	}
+	if types := checksumTypes(); types["1.0.0"] != "sha256" || types["1.0.1"] != "sha256" || types["2.0.0"] != "md5" {
+		t.Fatalf("Unexpected checksum types after recomputing: %v\n", types)
+	}
+	entries := readAuditLog(t, auditLogPath)
+	if len(entries) != 2 || entries[0].Action != AuditActionChecksum || entries[0].ChecksumType != "sha256" || entries[0].Checksum != sha256Digest {
+		t.Fatalf("Expected the two recomputed checksums in the audit log, but got %+v\n", entries)
+	}
+
+	if _, err = manager.RecomputeChecksums(CatalogQueryParams{}, "crc32"); err == nil {
+		t.Fatalf("RecomputeChecksums() did not fail with an unsupported checksum type\n")
+	}
+}
+
+// changingBackend runs Change the first time a box file is opened,
+// as if another process changed the catalog while the box file was being read
+type changingBackend struct {
+	CaryatidBackend
+	Change func()
+}
+
+func (backend *changingBackend) OpenFile(uri string) (io.ReadCloser, error) {
+	if change := backend.Change; change != nil {
+		backend.Change = nil
+		change()
+	}
+	return backend.CaryatidBackend.OpenFile(uri)
+}
+
+// The boxes are hashed before the catalog is locked, so a box that changes in the meantime keeps its new checksum
+func TestBackendManagerRecomputeChecksumsConcurrentChange(t *testing.T) {
+	var (
+		boxName     = "ConcurrentRecomputeBox"
+		boxProvider = "StrongSapling"
+		boxPath     = path.Join(integrationTestDir, "incoming-TestBackendManagerRecomputeChecksumsConcurrentChange.box")
+		catalogUri  = fmt.Sprintf("mem://TestBackendManagerRecomputeChecksumsConcurrentChange/%v.json", boxName)
+	)
+	if err := CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	md5Digest, err := util.HashFile(boxPath, md5.New())
+	if err != nil {
+		t.Fatalf("Error trying to hash test box file: %v\n", err)
+	}
+
+	otherBackend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	other := NewBackendManager(catalogUri, &otherBackend)
+	for _, version := range []string{"1.0.0", "1.0.1"} {
+		if err = other.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: version, Provider: boxProvider, ChecksumType: "md5", Checksum: md5Digest}); err != nil {
+			t.Fatalf("Error adding box to catalog: %v\n", err)
+		}
+	}
+
+	memBackend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	changing := &changingBackend{CaryatidBackend: memBackend}
+	changing.Change = func() {
+		other.Overwrite = true
+		if err := other.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "1.0.1", Provider: boxProvider, ChecksumType: "sha1", Checksum: "0xDECAFBAD"}); err != nil {
+			t.Fatalf("Error replacing box in catalog: %v\n", err)
+		}
+	}
+	var backend CaryatidBackend = changing
+	manager := NewBackendManager(catalogUri, &backend)
+
+	results, err := manager.RecomputeChecksums(CatalogQueryParams{}, "sha256")
+	if err != nil {
+		t.Fatalf("RecomputeChecksums() returned an error: %v\n", err)
+	}
+	skipped := 0
+	for _, result := range results {
+		if result.Version == "1.0.1" && result.Err != nil {
+			skipped += 1
+		} else if result.Err != nil {
+			t.Fatalf("Unexpected error recomputing the checksum of %v: %v\n", result.Version, result.Err)
+		}
+	}
+	if len(results) != 2 || skipped != 1 {
+		t.Fatalf("Expected the box that changed to be skipped, but got %+v\n", results)
+	}
+
+	catalog, err := other.Reload()
+	if err != nil {
+		t.Fatalf("Error reading catalog: %v\n", err)
+	}
+	if provider := catalog.findProvider("1.0.0", boxProvider, ""); provider == nil || provider.ChecksumType != "sha256" {
+		t.Fatalf("Expected the unchanged box to get a sha256 checksum, but got %+v\n", provider)
+	}
+	if provider := catalog.findProvider("1.0.1", boxProvider, ""); provider == nil || provider.Checksum != "0xDECAFBAD" {
+		t.Fatalf("Expected the box that changed to keep its new checksum, but got %+v\n", provider)
+	}
+}
+
+func TestBackendManagerAddBoxValidatesVersion(t *testing.T) {
+	var (
+		backend CaryatidBackend = &CaryatidTestBackend{}
+		manager                 = NewBackendManager("http://example.com/cata/VersionBox.json", &backend)
+	)
+
+	if err := manager.AddBox(BoxArtifact{Path: "/tmp/example.box", Name: "VersionBox", Description: "desc", Version: "1.2", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err == nil {
+		t.Fatalf("AddBox() accepted a nonstandard version by default\n")
+	}
+	manager.AllowNonstandardVersion = true
+	if err := manager.AddBox(BoxArtifact{Path: "/tmp/example.box", Name: "VersionBox", Description: "desc", Version: "1.2", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("AddBox() rejected a nonstandard version with AllowNonstandardVersion set: %v\n", err)
+	}
+	if err := manager.AddBox(BoxArtifact{Path: "/tmp/example.box", Name: "VersionBox", Description: "desc", Version: "1,2", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err == nil {
+		t.Fatalf("AddBox() accepted an unparseable version\n")
+	}
+}
+
+func TestBackendManagerAddBoxSetsReleasedAt(t *testing.T) {
+	var (
+		backend    CaryatidBackend = &CaryatidTestBackend{}
+		manager                    = NewBackendManager("http://example.com/cata/ReleaseBox.json", &backend)
+		artifact                   = BoxArtifact{Path: "/tmp/example.box", Name: "ReleaseBox", Description: "desc", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}
+		releasedAt                 = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	)
+
+	before := time.Now().Add(-time.Second)
+	artifact.Version = "1.0.0"
+	if err := manager.AddBox(artifact); err != nil {
+		t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+	}
+	artifact.Version = "2.0.0"
+	artifact.ReleasedAt = releasedAt
+	if err := manager.AddBox(artifact); err != nil {
+		t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+	}
+
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	released, ok := catalog.Versions[0].Providers[0].ReleasedTime()
+	if !ok || released.Before(before) || released.After(time.Now()) {
+		t.Fatalf("Expected AddBox() to record the current time, but recorded '%v'\n", catalog.Versions[0].Providers[0].ReleasedAt)
+	}
+	if catalog.Versions[1].Providers[0].ReleasedAt != "2024-01-01T12:00:00Z" {
+		t.Fatalf("Expected AddBox() to keep the artifact's release time, but recorded '%v'\n", catalog.Versions[1].Providers[0].ReleasedAt)
+	}
+}
+
+func TestBackendManagerPruneVersions(t *testing.T) {
+	var (
+		boxName    = "TestPruneVersionsBox"
+		boxDesc    = "TestPruneVersionsBox is a test box"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestPruneVersionsBox.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerPruneVersions/%v.json", boxName)
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0-BETA", "1.2.0"} {
+		for _, provider := range []string{"StrongSapling", "FeebleFungus"} {
+			if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: boxDesc, Version: version, Provider: provider, ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+				t.Fatalf("Error adding box to catalog: %v\n", err)
+			}
+		}
+	}
+
+	// boxExists returns true if the box file exists AND the catalog refers to it
+	boxExists := func(version string, provider string) bool {
+		uri, _ := BoxUriFromCatalogUri(catalogUri, boxName, version, provider)
+		reader, err := backend.OpenFile(uri)
+		if err != nil {
+			return false
+		}
+		reader.Close()
+		catalog, err := manager.GetCatalog()
+		if err != nil {
+			t.Fatalf("Error getting catalog: %v\n", err)
+		}
+		return catalog.BoxReferences().Contains(BoxReference{Version: version, ProviderName: provider})
+	}
+
+	manager.DryRun = true
+	pruned, err := manager.PruneVersions(CatalogQueryParams{Provider: "StrongSapling"}, 1, true)
+	if err != nil {
+		t.Fatalf("PruneVersions() returned an error during a dry run: %v\n", err)
+	}
+	if len(pruned) != 3 {
+		t.Fatalf("Expected a dry run to report 3 pruned boxes, but got %v\n", pruned)
+	}
+	if !boxExists("1.0.0", "StrongSapling") {
+		t.Fatalf("A dry run deleted a box\n")
+	}
+
+	manager.DryRun = false
+	if pruned, err = manager.PruneVersions(CatalogQueryParams{Provider: "StrongSapling"}, 1, true); err != nil {
+		t.Fatalf("PruneVersions() returned an error: %v\n", err)
+	}
+	if len(pruned) != 3 {
+		t.Fatalf("Expected 3 pruned boxes, but got %v\n", pruned)
+	}
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0-BETA"} {
+		if boxExists(version, "StrongSapling") {
+			t.Fatalf("Expected StrongSapling %v to be pruned\n", version)
+		}
+		if !boxExists(version, "FeebleFungus") {
+			t.Fatalf("Expected FeebleFungus %v to be kept when pruning only StrongSapling\n", version)
+		}
+	}
+	if !boxExists("1.2.0", "StrongSapling") {
+		t.Fatalf("Expected the newest StrongSapling box to be kept\n")
+	}
+}
+
+func TestBackendManagerTagVersions(t *testing.T) {
+	var (
+		boxName    = "TestTagVersionsBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestTagVersionsBox.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerTagVersions/%v.json", boxName)
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("Error adding box to catalog: %v\n", err)
+		}
+	}
+
+	// tagsOf returns the tags of each version in the saved catalog
+	tagsOf := func() map[string][]string {
+		catalog, err := manager.Reload()
+		if err != nil {
+			t.Fatalf("Error getting catalog: %v\n", err)
+		}
+		tags := map[string][]string{}
+		for _, v := range catalog.Versions {
+			tags[v.Version] = v.Tags
+		}
+		return tags
+	}
+
+	manager.DryRun = true
+	tagged, err := manager.TagVersions(CatalogQueryParams{Version: "<1.2.0"}, []string{"stable"}, nil)
+	if err != nil {
+		t.Fatalf("TagVersions() returned an error during a dry run: %v\n", err)
+	}
+	if !reflect.DeepEqual(tagged, []string{"1.0.0", "1.1.0"}) {
+		t.Fatalf("Expected a dry run to report tagging 1.0.0 and 1.1.0, but got %v\n", tagged)
+	}
+	if tags := tagsOf(); tags["1.0.0"] != nil {
+		t.Fatalf("A dry run tagged a version: %v\n", tags)
+	}
+
+	manager.DryRun = false
+	if _, err = manager.TagVersions(CatalogQueryParams{Version: "<1.2.0"}, []string{"stable"}, nil); err != nil {
+		t.Fatalf("TagVersions() returned an error: %v\n", err)
+	}
+	if _, err = manager.TagVersions(CatalogQueryParams{Version: "latest"}, []string{"canary"}, nil); err != nil {
+		t.Fatalf("TagVersions() returned an error: %v\n", err)
+	}
+	expected := map[string][]string{"1.0.0": []string{"stable"}, "1.1.0": []string{"stable"}, "1.2.0": []string{"canary"}}
+	if tags := tagsOf(); !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("Expected tags %v, but got %v\n", expected, tags)
+	}
+
+	// Promoting the canary moves the stable tag to it, and "latest" then finds it among the stable versions
+	if tagged, err = manager.TagVersions(CatalogQueryParams{Version: "1.2.0", ExactVersion: true}, []string{"stable"}, []string{"canary"}); err != nil {
+		t.Fatalf("TagVersions() returned an error: %v\n", err)
+	}
+	if !reflect.DeepEqual(tagged, []string{"1.2.0"}) {
+		t.Fatalf("Expected to tag 1.2.0, but got %v\n", tagged)
+	}
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	latestStable, err := catalog.QueryCatalog(CatalogQueryParams{Version: "latest", Tag: "stable"})
+	if err != nil || len(latestStable.Versions) != 1 || latestStable.Versions[0].Version != "1.2.0" {
+		t.Fatalf("Expected 1.2.0 to be the latest stable version, but got %v (error %v)\n", latestStable.Versions, err)
+	}
+
+	if _, err = manager.TagVersions(CatalogQueryParams{Version: "9.9.9", ExactVersion: true}, []string{"stable"}, nil); err == nil {
+		t.Fatalf("Expected TagVersions() to return an error when no versions match\n")
+	}
+}
+
+func TestBackendManagerDeleteBoxOneProvider(t *testing.T) {
+	var (
+		boxName    = "TestDeleteOneProviderBox"
+		boxDesc    = "TestDeleteOneProviderBox is a test box"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestDeleteOneProviderBox.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerDeleteBoxOneProvider/%v.json", boxName)
+		providers  = []string{"virtualbox", "vmware", "libvirt"}
+	)
+
+	if err := CreateTestBoxFile(boxPath, "virtualbox", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	memBackend := backend.(*CaryatidMemoryBackend)
+	manager := NewBackendManager(catalogUri, &backend)
+
+	for _, version := range []string{"1.2.3", "1.2.4"} {
+		for _, provider := range providers {
+			if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: boxDesc, Version: version, Provider: provider, ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+				t.Fatalf("Error adding box to catalog: %v\n", err)
+			}
+		}
+	}
+	boxUri := func(version string, provider string) string {
+		uri, _ := BoxUriFromCatalogUri(catalogUri, boxName, version, provider)
+		return uri
+	}
+
+	deleted, err := manager.DeleteBox(CatalogQueryParams{Version: "1.2.3", Provider: "virtualbox"})
+	if err != nil {
+		t.Fatalf("DeleteBox() returned an error: %v\n", err)
+	}
+	if len(deleted) != 1 || deleted[0].Version != "1.2.3" || deleted[0].ProviderName != "virtualbox" {
+		t.Fatalf("Expected to delete only virtualbox 1.2.3, but deleted %v\n", deleted)
+	}
+	if memBackend.FileExists(boxUri("1.2.3", "virtualbox")) {
+		t.Fatalf("Expected the virtualbox 1.2.3 box file to be deleted\n")
+	}
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	refs := catalog.BoxReferences()
+	if len(refs) != 5 {
+		t.Fatalf("Expected 5 boxes to remain after deleting one provider, but got %v\n", refs)
+	}
+	for _, provider := range []string{"vmware", "libvirt"} {
+		if !refs.Contains(BoxReference{Version: "1.2.3", ProviderName: provider}) || !memBackend.FileExists(boxUri("1.2.3", provider)) {
+			t.Fatalf("Expected %v 1.2.3 to be kept when deleting only virtualbox\n", provider)
+		}
+	}
+
+	// Once no providers remain, the version itself is removed
+	if _, err = manager.DeleteBox(CatalogQueryParams{Version: "1.2.3"}); err != nil {
+		t.Fatalf("DeleteBox() returned an error: %v\n", err)
+	}
+	if catalog, err = manager.GetCatalog(); err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	if len(catalog.Versions) != 1 || catalog.Versions[0].Version != "1.2.4" {
+		t.Fatalf("Expected only version 1.2.4 to remain, but got:\n%v\n", catalog.DisplayString())
+	}
+}
+
+func TestBackendManagerDeduplicate(t *testing.T) {
+	var (
+		boxName      = "TestDeduplicateBox"
+		boxPath      = path.Join(integrationTestDir, "incoming-TestDeduplicateBox.box")
+		catalogUri   = fmt.Sprintf("mem://TestBackendManagerDeduplicate/%v.json", boxName)
+		auditLogPath = path.Join(integrationTestDir, "TestBackendManagerDeduplicate.jsonl")
+	)
+
+	if err := CreateTestBoxFile(boxPath, "virtualbox", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	memBackend := backend.(*CaryatidMemoryBackend)
+	manager := NewBackendManager(catalogUri, &backend)
+
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "1.0.0", Provider: "virtualbox", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+	boxUri, _ := BoxUriFromCatalogUri(catalogUri, boxName, "1.0.0", "virtualbox")
+	staleUri := strings.TrimSuffix(boxUri, ".box") + "_stale.box"
+	if err = backend.CopyBoxFile(boxPath, staleUri); err != nil {
+		t.Fatalf("Error copying stale box file: %v\n", err)
+	}
+
+	// An older duplicate with its own box file, and one that shares the box file of the provider that is kept
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	kept := catalog.Versions[0].Providers[0]
+	stale := kept
+	stale.Url = manager.boxUrl(staleUri)
+	stale.Checksum = "0xDEADBEEF"
+	catalog.Versions[0].Providers = []Provider{stale, kept, kept}
+	if err = manager.SaveCatalog(catalog); err != nil {
+		t.Fatalf("Error saving catalog: %v\n", err)
+	}
+
+	os.Remove(auditLogPath)
+	manager.AuditLogPath = auditLogPath
+	removed, err := manager.Deduplicate()
+	if err != nil {
+		t.Fatalf("Deduplicate() returned an error: %v\n", err)
+	}
+	if removed != 2 {
+		t.Fatalf("Expected 2 duplicates to be removed, but removed %v\n", removed)
+	}
+	if catalog, err = manager.GetCatalog(); err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	if len(catalog.Versions) != 1 || len(catalog.Versions[0].Providers) != 1 || catalog.Versions[0].Providers[0].Url != kept.Url {
+		t.Fatalf("Expected only the last duplicate to be kept, but got:\n%v\n", catalog.DisplayString())
+	}
+	if memBackend.FileExists(staleUri) {
+		t.Fatalf("Expected the box file of the removed duplicate to be deleted\n")
+	}
+	if !memBackend.FileExists(boxUri) {
+		t.Fatalf("Expected the box file that the kept provider refers to be kept\n")
+	}
+	entries := readAuditLog(t, auditLogPath)
+	if len(entries) != 2 || entries[0].Action != AuditActionDelete || entries[0].Checksum != "0xDEADBEEF" || entries[1].Checksum != "0xB00B1E5" {
+		t.Fatalf("Expected the two removed duplicates in the audit log, but got %+v\n", entries)
+	}
+}
+
+func TestBackendManagerRenameCatalog(t *testing.T) {
+	var (
+		boxName    = "TestRenameCatalogBox"
+		newName    = "TestRenameCatalogBoxRenamed"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestRenameCatalogBox.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerRenameCatalog/%v.json", boxName)
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	manager.LatestAlias = true
+	if _, ok := backend.(CaryatidMoveBackend); ok {
+		t.Fatalf("This test expects the memory backend to fall back to copying and deleting box files\n")
+	}
+
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("Error adding box to catalog: %v\n", err)
+		}
+	}
+	fileExists := func(uri string) bool {
+		reader, err := backend.OpenFile(uri)
+		if err != nil {
+			return false
+		}
+		reader.Close()
+		return true
+	}
+
+	manager.DryRun = true
+	moves, err := manager.RenameCatalog(newName)
+	if err != nil {
+		t.Fatalf("RenameCatalog() returned an error during a dry run: %v\n", err)
+	}
+	if len(moves) != 2 || !fileExists(moves[0].OldUri) || fileExists(moves[0].NewUri) {
+		t.Fatalf("A dry run should report moves without moving anything, but got %v\n", moves)
+	}
+
+	manager.DryRun = false
+	if moves, err = manager.RenameCatalog(newName); err != nil {
+		t.Fatalf("RenameCatalog() returned an error: %v\n", err)
+	}
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	if catalog.Name != newName {
+		t.Fatalf("Expected the catalog to be named '%v', but it was '%v'\n", newName, catalog.Name)
+	}
+	for _, move := range moves {
+		expectedUri, _ := BoxUriFromCatalogUri(catalogUri, newName, move.Version, move.ProviderName)
+		if move.NewUri != expectedUri || fileExists(move.OldUri) || !fileExists(move.NewUri) {
+			t.Fatalf("Expected the box file to be moved to '%v', but got %v\n", expectedUri, move)
+		}
+	}
+	for _, ref := range catalog.BoxReferences() {
+		if !fileExists(ref.Uri) {
+			t.Fatalf("The renamed catalog refers to a missing box file at '%v'\n", ref.Uri)
+		}
+	}
+	oldAlias, _ := manager.latestAliasUri(boxName, "StrongSapling", "")
+	newAlias, _ := manager.latestAliasUri(newName, "StrongSapling", "")
+	if fileExists(oldAlias) || !fileExists(newAlias) {
+		t.Fatalf("Expected the latest alias to move from '%v' to '%v'\n", oldAlias, newAlias)
+	}
+
+	if _, err = manager.RenameCatalog(""); err == nil {
+		t.Fatalf("RenameCatalog() did not fail with an empty name\n")
+	}
+	if _, err = manager.RenameCatalog(newName); err == nil {
+		t.Fatalf("RenameCatalog() did not fail when renaming the catalog to its current name\n")
+	}
+}
+
+// moveRecordingBackend adds MoveFile() to the memory backend it wraps, and counts how many times it is called
+// If FailTo is set, moving a file there fails
+type moveRecordingBackend struct {
+	CaryatidBackend
+	Moves  int
+	FailTo string
+}
+
+func (backend *moveRecordingBackend) MoveFile(fromUri string, toUri string) error {
+	backend.Moves += 1
+	if toUri == backend.FailTo {
+		return fmt.Errorf("Refusing to move '%v' to '%v'", fromUri, toUri)
+	}
+	memoryBackendLock.Lock()
+	defer memoryBackendLock.Unlock()
+	data, ok := memoryBackendFiles[fromUri]
+	if !ok {
+		return fmt.Errorf("No such file '%v'", fromUri)
+	}
+	memoryBackendFiles[toUri] = data
+	delete(memoryBackendFiles, fromUri)
+	return nil
+}
+
+// Renaming moves box files with MoveFile() through the wrappers for retries and timeouts
+func TestBackendManagerRenameCatalogWrapped(t *testing.T) {
+	var (
+		boxName    = "TestRenameCatalogWrappedBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestRenameCatalogWrappedBox.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerRenameCatalogWrapped/%v.json", boxName)
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	memBackend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	mover := &moveRecordingBackend{CaryatidBackend: memBackend}
+	var backend CaryatidBackend = NewRetryBackend(NewTimeoutBackend(mover, time.Minute), 3)
+	manager := NewBackendManager(catalogUri, &backend)
+
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("Error adding box to catalog: %v\n", err)
+		}
+	}
+	if _, err = manager.RenameCatalog(boxName + "Renamed"); err != nil {
+		t.Fatalf("RenameCatalog() returned an error: %v\n", err)
+	}
+	if mover.Moves != 2 {
+		t.Fatalf("Expected both box files to be moved with MoveFile(), but it was called %v times\n", mover.Moves)
+	}
+}
+
+// If a box file cannot be moved, the ones that were already moved are moved back, and the catalog keeps its name
+func TestBackendManagerRenameCatalogRollsBack(t *testing.T) {
+	var (
+		boxName    = "TestRenameCatalogRollsBackBox"
+		newName    = "TestRenameCatalogRollsBackBoxRenamed"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestRenameCatalogRollsBackBox.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerRenameCatalogRollsBack/%v.json", boxName)
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	memBackend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	mover := &moveRecordingBackend{CaryatidBackend: memBackend}
+	mover.FailTo, _ = BoxUriFromCatalogUri(catalogUri, newName, "1.1.0", "StrongSapling")
+	var backend CaryatidBackend = mover
+	manager := NewBackendManager(catalogUri, &backend)
+
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("Error adding box to catalog: %v\n", err)
+		}
+	}
+	if _, err = manager.RenameCatalog(newName); err == nil {
+		t.Fatalf("RenameCatalog() did not fail when a box file could not be moved\n")
+	}
+	if mover.Moves != 3 {
+		t.Fatalf("Expected the first box file to be moved and then moved back, but MoveFile() was called %v times\n", mover.Moves)
+	}
+
+	catalog, err := manager.Reload()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	if catalog.Name != boxName {
+		t.Fatalf("Expected the catalog to keep the name '%v', but it was '%v'\n", boxName, catalog.Name)
+	}
+	for _, ref := range catalog.BoxReferences() {
+		reader, err := backend.OpenFile(manager.storageUri(ref.Uri))
+		if err != nil {
+			t.Fatalf("The catalog refers to a missing box file at '%v'\n", ref.Uri)
+		}
+		reader.Close()
+	}
+}
+
+func TestBackendManagerCollectGarbage(t *testing.T) {
+	var (
+		boxName    = "TestCollectGarbageBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestCollectGarbageBox.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerCollectGarbage/%v.json", boxName)
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("Error adding box to catalog: %v\n", err)
+		}
+	}
+	// Removing 1.0.0 from the catalog but not the backend leaves an orphaned box file
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	orphanUri, _ := BoxUriFromCatalogUri(catalogUri, boxName, "1.0.0", "StrongSapling")
+	catalog = catalog.DeleteReferences(BoxReferenceList{BoxReference{Version: "1.0.0", ProviderName: "StrongSapling"}})
+	if err = manager.SaveCatalog(catalog); err != nil {
+		t.Fatalf("Error saving catalog: %v\n", err)
+	}
+
+	manager.DryRun = true
+	orphans, err := manager.CollectGarbage()
+	if err != nil {
+		t.Fatalf("CollectGarbage() returned an error during a dry run: %v\n", err)
+	}
+	if len(orphans) != 1 || orphans[0] != orphanUri {
+		t.Fatalf("Expected CollectGarbage() to find only '%v', but got %v\n", orphanUri, orphans)
+	}
+
+	manager.DryRun = false
+	if orphans, err = manager.CollectGarbage(); err != nil {
+		t.Fatalf("CollectGarbage() returned an error: %v\n", err)
+	}
+	remaining, err := backend.ListBoxFiles(boxName)
+	if err != nil {
+		t.Fatalf("ListBoxFiles() returned an error: %v\n", err)
+	}
+	referencedUri, _ := BoxUriFromCatalogUri(catalogUri, boxName, "1.1.0", "StrongSapling")
+	if len(remaining) != 1 || remaining[0] != referencedUri {
+		t.Fatalf("Expected only '%v' to remain after collecting garbage, but got %v\n", referencedUri, remaining)
+	}
+}
+
+func TestBackendManagerLatestAlias(t *testing.T) {
+	var (
+		boxName     = "TestLatestAliasBox"
+		boxPath     = path.Join(integrationTestDir, "incoming-TestLatestAliasBox.box")
+		catalogRoot = path.Join(integrationTestDir, "TestBackendManagerLatestAlias")
+		catalogUri  = fmt.Sprintf("file://%v/%v.json", catalogRoot, boxName)
+		aliasPath   = path.Join(catalogRoot, boxName, boxName+"_latest_StrongSapling.box")
+	)
+
+	if err := os.RemoveAll(catalogRoot); err != nil {
+		t.Fatalf("Error removing old catalog root: %v\n", err)
+	}
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting local file backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	manager.LatestAlias = true
+
+	addVersion := func(version string) {
+		if err := manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("Error adding version %v to catalog: %v\n", version, err)
+		}
+	}
+	expectAlias := func(version string) {
+		target, err := os.Readlink(aliasPath)
+		if err != nil {
+			t.Fatalf("Expected '%v' to be a symbolic link: %v\n", aliasPath, err)
+		}
+		if expected := fmt.Sprintf("%v_%v_StrongSapling.box", boxName, version); target != expected {
+			t.Fatalf("Expected the latest alias to point to '%v', but it points to '%v'\n", expected, target)
+		}
+	}
+
+	addVersion("1.0.0")
+	expectAlias("1.0.0")
+	addVersion("2.0.0")
+	expectAlias("2.0.0")
+	// Adding an older version leaves the alias alone
+	addVersion("1.5.0")
+	expectAlias("2.0.0")
+
+	if _, err = manager.DeleteBox(CatalogQueryParams{Version: "2.0.0"}); err != nil {
+		t.Fatalf("DeleteBox() returned an error: %v\n", err)
+	}
+	expectAlias("1.5.0")
+
+	if orphans, err := manager.CollectGarbage(); err != nil || len(orphans) != 0 {
+		t.Fatalf("Expected CollectGarbage() to leave the latest alias alone, but got %v and error %v\n", orphans, err)
+	}
+
+	manager.DryRun = true
+	removed, err := manager.RemoveLatestAliases()
+	if err != nil || len(removed) != 1 || !strings.HasSuffix(removed[0], boxName+"_latest_StrongSapling.box") {
+		t.Fatalf("Expected RemoveLatestAliases() to find only the latest alias during a dry run, but got %v and error %v\n", removed, err)
+	}
+	expectAlias("1.5.0")
+	manager.DryRun = false
+	if _, err = manager.RemoveLatestAliases(); err != nil {
+		t.Fatalf("RemoveLatestAliases() returned an error: %v\n", err)
+	}
+	if _, err = os.Lstat(aliasPath); !os.IsNotExist(err) {
+		t.Fatalf("Expected RemoveLatestAliases() to delete '%v', but got %v\n", aliasPath, err)
+	}
+
+	// Deleting every version of a provider deletes its alias
+	addVersion("3.0.0")
+	expectAlias("3.0.0")
+	if _, err = manager.DeleteBox(CatalogQueryParams{Provider: "StrongSapling"}); err != nil {
+		t.Fatalf("DeleteBox() returned an error: %v\n", err)
+	}
+	if _, err = os.Lstat(aliasPath); !os.IsNotExist(err) {
+		t.Fatalf("Expected deleting every version to delete '%v', but got %v\n", aliasPath, err)
+	}
+}
+
+func TestBackendManagerLatestAliasCopies(t *testing.T) {
+	var (
+		boxName    = "TestLatestAliasCopiesBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestLatestAliasCopiesBox.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerLatestAliasCopies/%v.json", boxName)
+	)
+
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	manager.LatestAlias = true
+
+	// Boxes with different contents show which one the alias is a copy of
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		if err = CreateTestBoxFile(boxPath, "StrongSapling", version == "2.0.0"); err != nil {
+			t.Fatalf("Error trying to create test box file: %v\n", err)
+		}
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("Error adding version %v to catalog: %v\n", version, err)
+		}
+	}
+
+	files := snapshotMemoryBackend()
+	boxUri, _ := BoxUriFromCatalogUri(catalogUri, boxName, "2.0.0", "StrongSapling")
+	aliasUri := fmt.Sprintf("mem://TestBackendManagerLatestAliasCopies/%v/%v_latest_StrongSapling.box", boxName, boxName)
+	if alias, ok := files[aliasUri]; !ok || alias != files[boxUri] {
+		t.Fatalf("Expected '%v' to be a copy of '%v'\n", aliasUri, boxUri)
+	}
+}
+
+// snapshotMemoryBackend returns a copy of every file in the memory backend, keyed by URI
+func snapshotMemoryBackend() (snapshot map[string]string) {
+	memoryBackendLock.RLock()
+	defer memoryBackendLock.RUnlock()
+	snapshot = make(map[string]string)
+	for uri, data := range memoryBackendFiles {
+		snapshot[uri] = string(data)
+	}
+	return
+}
+
+func TestBackendManagerDryRunDoesNotModify(t *testing.T) {
+	var (
+		boxName    = "TestDryRunBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestDryRunBox.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerDryRunDoesNotModify/%v.json", boxName)
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+
+	for _, version := range []string{"1.0.0", "1.1.0", "2.0.0-BETA", "2.0.0"} {
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("Error adding box to catalog: %v\n", err)
+		}
+	}
+	// An orphaned box file gives CollectGarbage() something to delete
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	if err = manager.SaveCatalog(catalog.DeleteReferences(BoxReferenceList{BoxReference{Version: "1.0.0", ProviderName: "StrongSapling"}})); err != nil {
+		t.Fatalf("Error saving catalog: %v\n", err)
+	}
+
+	before := snapshotMemoryBackend()
+	manager.DryRun = true
+
+	deleted, err := manager.DeleteBox(CatalogQueryParams{Version: "<2"})
+	if err != nil || len(deleted) != 1 {
+		t.Fatalf("Expected DeleteBox() to report 1 box during a dry run, but got %v and error %v\n", deleted, err)
+	}
+	pruned, err := manager.PruneVersions(CatalogQueryParams{}, 1, true)
+	if err != nil || len(pruned) != 2 {
+		t.Fatalf("Expected PruneVersions() to report 2 boxes during a dry run, but got %v and error %v\n", pruned, err)
+	}
+	orphans, err := manager.CollectGarbage()
+	if err != nil || len(orphans) != 1 {
+		t.Fatalf("Expected CollectGarbage() to report 1 file during a dry run, but got %v and error %v\n", orphans, err)
+	}
+	moves, err := manager.RenameCatalog("TestDryRunRenamedBox")
+	if err != nil || len(moves) != 3 {
+		t.Fatalf("Expected RenameCatalog() to report 3 moves during a dry run, but got %v and error %v\n", moves, err)
+	}
+
+	after := snapshotMemoryBackend()
+	if len(after) != len(before) {
+		t.Fatalf("Expected the same files after a dry run, but had %v files before and %v after\n", len(before), len(after))
+	}
+	for uri, data := range before {
+		if after[uri] != data {
+			t.Fatalf("Expected '%v' to be unchanged after a dry run\n", uri)
+		}
+	}
+}
+
+// signingTestBackend signs URLs by appending the TTL, so that tests can tell they were signed
+type signingTestBackend struct {
+	CaryatidMemoryBackend
+}
+
+func (backend *signingTestBackend) SignedUrl(uri string, ttl time.Duration) (string, error) {
+	return fmt.Sprintf("%v?ttl=%v", uri, ttl), nil
+}
+
+func TestBackendManagerSignCatalogUrls(t *testing.T) {
+	boxUri := "mem://TestSign/TestSignBox/TestSignBox_1.0.0_StrongSapling.box"
+	newCatalog := func() Catalog {
+		return Catalog{Name: "TestSignBox", Description: "desc", Versions: []Version{
+			Version{Version: "1.0.0", Providers: []Provider{
+				Provider{Name: "StrongSapling", Url: boxUri, ChecksumType: "sha256", Checksum: "0xB00B1E5"},
+			}},
+		}}
+	}
+	catalogUri := "mem://TestSign/TestSignBox.json"
+
+	var memBackend CaryatidBackend = &CaryatidMemoryBackend{}
+	manager := NewBackendManager(catalogUri, &memBackend)
+	unsigned := newCatalog()
+	if manager.CanSignUrls() {
+		t.Fatalf("Expected the memory backend not to sign URLs\n")
+	}
+	if err := manager.SignCatalogUrls(&unsigned, time.Hour); err != nil {
+		t.Fatalf("SignCatalogUrls() returned an error: %v\n", err)
+	}
+	if unsigned.Versions[0].Providers[0].Url != boxUri {
+		t.Fatalf("Expected the memory backend to leave URLs unchanged, but got '%v'\n", unsigned.Versions[0].Providers[0].Url)
+	}
+
+	// A RetryBackend signs URLs if the backend it wraps does
+	var signingBackend CaryatidBackend = NewRetryBackend(&signingTestBackend{}, 2)
+	manager = NewBackendManager(catalogUri, &signingBackend)
+	signed := newCatalog()
+	if !manager.CanSignUrls() {
+		t.Fatalf("Expected a RetryBackend wrapping a signing backend to sign URLs\n")
+	}
+	if err := manager.SignCatalogUrls(&signed, time.Hour); err != nil {
+		t.Fatalf("SignCatalogUrls() returned an error: %v\n", err)
+	}
+	expectedUrl := boxUri + "?ttl=1h0m0s"
+	if signed.Versions[0].Providers[0].Url != expectedUrl {
+		t.Fatalf("Expected the signed URL '%v', but got '%v'\n", expectedUrl, signed.Versions[0].Providers[0].Url)
+	}
+}
+
+func TestBackendManagerConcurrentAddBox(t *testing.T) {
+	var (
+		boxName     = "TestConcurrentAddBox"
+		boxProvider = "TestProvider"
+		catalogRoot = path.Join(integrationTestDir, "TestBackendManagerConcurrentAddBox")
+		catalogUri  = fmt.Sprintf("file://%v/%v.json", catalogRoot, boxName)
+		boxPath     = path.Join(integrationTestDir, "incoming-TestConcurrentAddBox.box")
+		versions    = []string{"1.0.0", "1.1.0", "1.2.0", "2.0.0", "2.1.0", "3.0.0", "3.1.0", "4.0.0"}
+	)
+
+	if err := CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+
+	// Each goroutine has its own manager, like separate processes would
+	errs := make(chan error, len(versions))
+	for _, version := range versions {
+		go func(version string) {
+			backend, err := NewBackendFromUri(catalogUri)
+			if err != nil {
+				errs <- err
+				return
+			}
+			manager := NewBackendManager(catalogUri, &backend)
+			artifact := BoxArtifact{
+				Path:         boxPath,
+				Name:         boxName,
+				Description:  "A test box",
+				Version:      version,
+				Provider:     boxProvider,
+				ChecksumType: "sha1",
+				Checksum:     "0123456789abcdef",
+			}
+			errs <- manager.AddBox(artifact)
+		}(version)
+	}
+	for range versions {
+		if err := <-errs; err != nil {
+			t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+		}
+	}
+
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	catalog, err := NewBackendManager(catalogUri, &backend).GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	if len(catalog.Versions) != len(versions) {
+		t.Fatalf("Expected %v versions after adding them concurrently, but the catalog has %v:\n%v\n", len(versions), len(catalog.Versions), catalog)
+	}
+}
+
+func TestBackendManagerLockTimeout(t *testing.T) {
+	var (
+		catalogRoot = path.Join(integrationTestDir, "TestBackendManagerLockTimeout")
+		catalogUri  = fmt.Sprintf("file://%v/TestLockTimeout.json", catalogRoot)
+		artifact    = BoxArtifact{Name: "TestLockTimeout", Version: "1.0.0", Provider: "TestProvider"}
+	)
+
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	manager.LockTimeout = 250 * time.Millisecond
+
+	unlock, err := backend.(CaryatidLockingBackend).LockCatalog()
+	if err != nil {
+		t.Fatalf("LockCatalog() returned an unexpected error: %v\n", err)
+	}
+	defer unlock()
+
+	started := time.Now()
+	if err = manager.AddBox(artifact); err == nil {
+		t.Fatalf("AddBox() should have failed while another process holds the lock\n")
+	}
+	if elapsed := time.Since(started); elapsed < manager.LockTimeout {
+		t.Fatalf("AddBox() gave up after %v, before the %v lock timeout\n", elapsed, manager.LockTimeout)
+	}
+}
+
+func TestBackendManagerCatalogBackups(t *testing.T) {
+	oldBackupTime := catalogBackupTime
+	defer func() { catalogBackupTime = oldBackupTime }()
+
+	catalogRoot := path.Join(integrationTestDir, "TestBackendManagerCatalogBackups")
+	catalogUris := []string{
+		"mem://TestBackendManagerCatalogBackups/TestBackupBox.json",
+		fmt.Sprintf("file://%v/TestBackupBox.json", catalogRoot),
+	}
+	for _, catalogUri := range catalogUris {
+		// Each backup gets a different timestamp, even though they are made in the same second
+		backupTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		catalogBackupTime = func() time.Time {
+			backupTime = backupTime.Add(time.Second)
+			return backupTime
+		}
+
+		backend, err := NewBackendFromUri(catalogUri)
+		if err != nil {
+			t.Fatalf("Error getting backend: %v\n", err)
+		}
+		manager := NewBackendManager(catalogUri, &backend)
+		manager.CatalogBackups = 2
+		backuper := backend.(CaryatidBackupBackend)
+
+		if err = manager.SaveCatalog(Catalog{Name: "TestBackupBox", Description: "first"}); err != nil {
+			t.Fatalf("SaveCatalog() returned an unexpected error: %v\n", err)
+		}
+		if backups, _ := backuper.ListCatalogBackups(); len(backups) != 0 {
+			t.Fatalf("Expected no backups of '%v' after the first save, but found %v\n", catalogUri, backups)
+		}
+
+		if err = manager.SaveCatalog(Catalog{Name: "TestBackupBox", Description: "second"}); err != nil {
+			t.Fatalf("SaveCatalog() returned an unexpected error: %v\n", err)
+		}
+		backups, err := backuper.ListCatalogBackups()
+		if err != nil || len(backups) != 1 {
+			t.Fatalf("Expected one backup of '%v' after the second save, but found %v (error %v)\n", catalogUri, backups, err)
+		}
+		expectedBackup := catalogUri + ".bak.20240101T120002"
+		if backups[0] != expectedBackup {
+			t.Fatalf("Expected a backup at '%v', but found '%v'\n", expectedBackup, backups[0])
+		}
+		reader, err := backend.OpenFile(backups[0])
+		if err != nil {
+			t.Fatalf("Error opening backup: %v\n", err)
+		}
+		backupBytes, _ := ioutil.ReadAll(reader)
+		reader.Close()
+		if !bytes.Contains(backupBytes, []byte(`"first"`)) {
+			t.Fatalf("Expected the backup to hold the first catalog, but it holds:\n%v\n", string(backupBytes))
+		}
+
+		for _, description := range []string{"third", "fourth", "fifth"} {
+			if err = manager.SaveCatalog(Catalog{Name: "TestBackupBox", Description: description}); err != nil {
+				t.Fatalf("SaveCatalog() returned an unexpected error: %v\n", err)
+			}
+		}
+		backups, err = backuper.ListCatalogBackups()
+		if err != nil {
+			t.Fatalf("ListCatalogBackups() returned an unexpected error: %v\n", err)
+		}
+		expectedBackups := []string{catalogUri + ".bak.20240101T120004", catalogUri + ".bak.20240101T120005"}
+		if fmt.Sprintf("%v", backups) != fmt.Sprintf("%v", expectedBackups) {
+			t.Fatalf("Expected only the newest backups %v, but found %v\n", expectedBackups, backups)
+		}
+
+		// Backups are not box files
+		if boxFiles, _ := backend.ListBoxFiles("TestBackupBox"); len(boxFiles) != 0 {
+			t.Fatalf("Expected no box files, but found %v\n", boxFiles)
+		}
+	}
+}
+
+func TestBackendManagerCatalogBackupsSameSecond(t *testing.T) {
+	catalogRoot := path.Join(integrationTestDir, "TestBackendManagerCatalogBackupsSameSecond")
+	catalogUris := []string{
+		"mem://TestBackendManagerCatalogBackupsSameSecond/TestBackupBox.json",
+		fmt.Sprintf("file://%v/TestBackupBox.json", catalogRoot),
+	}
+	for _, catalogUri := range catalogUris {
+		backend, err := NewBackendFromUri(catalogUri)
+		if err != nil {
+			t.Fatalf("Error getting backend: %v\n", err)
+		}
+		manager := NewBackendManager(catalogUri, &backend)
+		backuper := backend.(CaryatidBackupBackend)
+
+		// Saves made back to back, almost always within the same second, must not overwrite each other's backups
+		for _, description := range []string{"first", "second", "third"} {
+			if err = manager.SaveCatalog(Catalog{Name: "TestBackupBox", Description: description}); err != nil {
+				t.Fatalf("SaveCatalog() returned an unexpected error: %v\n", err)
+			}
+		}
+		backups, err := backuper.ListCatalogBackups()
+		if err != nil || len(backups) != 2 {
+			t.Fatalf("Expected two backups of '%v', but found %v (error %v)\n", catalogUri, backups, err)
+		}
+		sort.Strings(backups)
+		for idx, expected := range []string{`"first"`, `"second"`} {
+			reader, err := backend.OpenFile(backups[idx])
+			if err != nil {
+				t.Fatalf("Error opening backup: %v\n", err)
+			}
+			backupBytes, _ := ioutil.ReadAll(reader)
+			reader.Close()
+			if !bytes.Contains(backupBytes, []byte(expected)) {
+				t.Fatalf("Expected backup '%v' to hold the %v catalog, but it holds:\n%v\n", backups[idx], expected, string(backupBytes))
+			}
+		}
+	}
+}
+
+func TestBackendManagerUrlPrefix(t *testing.T) {
+	var (
+		catalogUri = "mem://TestBackendManagerUrlPrefix/PrefixBox.json"
+		storedUri  = "mem://TestBackendManagerUrlPrefix/PrefixBox/PrefixBox_1.0.0_StrongSapling.box"
+		boxUrl     = "https://cdn.example.com/boxes/PrefixBox/PrefixBox_1.0.0_StrongSapling.box"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestBackendManagerUrlPrefix.box")
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	artifact, err := DeriveArtifactInfoFromBoxFile(boxPath, "sha256")
+	if err != nil {
+		t.Fatalf("Error deriving artifact info: %v\n", err)
+	}
+	artifact.Name = "PrefixBox"
+	artifact.Description = "A test box"
+	artifact.Version = "1.0.0"
+
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	manager.UrlPrefix = "https://cdn.example.com/boxes/"
+	if err = manager.AddBox(artifact); err != nil {
+		t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+	}
+
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	if url := catalog.Versions[0].Providers[0].Url; url != boxUrl {
+		t.Fatalf("Expected the catalog to record the URL '%v', but it recorded '%v'\n", boxUrl, url)
+	}
+	memBackend := backend.(*CaryatidMemoryBackend)
+	if !memBackend.FileExists(storedUri) {
+		t.Fatalf("Expected the box file to be stored at '%v'\n", storedUri)
+	}
+
+	// Other operations find the box file from its URL, even without the UrlPrefix
+	reader := NewBackendManager(catalogUri, &backend)
+	if results, err := reader.VerifyBoxes(CatalogQueryParams{}); err != nil || len(results) != 1 || !results[0].Passed() {
+		t.Fatalf("Expected the box to pass verification, but got %v (error %v)\n", results, err)
+	}
+	if orphans, err := reader.CollectGarbage(); err != nil || len(orphans) != 0 {
+		t.Fatalf("Expected no orphaned box files, but got %v (error %v)\n", orphans, err)
+	}
+	if _, err = reader.DeleteBox(CatalogQueryParams{Version: "1.0.0"}); err != nil {
+		t.Fatalf("DeleteBox() returned an unexpected error: %v\n", err)
+	}
+	if memBackend.FileExists(storedUri) {
+		t.Fatalf("Expected DeleteBox() to delete the box file at '%v'\n", storedUri)
+	}
+}
+
+func TestBackendManagerFilenameTemplate(t *testing.T) {
+	var (
+		catalogUri = "mem://TestBackendManagerFilenameTemplate/TmplBox.json"
+		template   = "{{.Name}}-{{.Version}}-{{.Provider}}{{if .Architecture}}-{{.Architecture}}{{end}}.box"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestBackendManagerFilenameTemplate.box")
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	memBackend := backend.(*CaryatidMemoryBackend)
+	manager := NewBackendManager(catalogUri, &backend)
+	manager.FilenameTemplate = template
+
+	type TestCase struct {
+		Version      string
+		Architecture string
+		UrlPrefix    string
+		StoredUri    string
+		Url          string
+	}
+	testCases := []TestCase{
+		TestCase{"1.0.0", "", "", "mem://TestBackendManagerFilenameTemplate/TmplBox/TmplBox-1.0.0-StrongSapling.box", "mem://TestBackendManagerFilenameTemplate/TmplBox/TmplBox-1.0.0-StrongSapling.box"},
+		TestCase{"1.0.0", "arm64", "", "mem://TestBackendManagerFilenameTemplate/TmplBox/TmplBox-1.0.0-StrongSapling-arm64.box", "mem://TestBackendManagerFilenameTemplate/TmplBox/TmplBox-1.0.0-StrongSapling-arm64.box"},
+		TestCase{"2.0.0", "", "https://cdn.example.com/boxes", "mem://TestBackendManagerFilenameTemplate/TmplBox/TmplBox-2.0.0-StrongSapling.box", "https://cdn.example.com/boxes/TmplBox/TmplBox-2.0.0-StrongSapling.box"},
+	}
+	for _, tc := range testCases {
+		manager.UrlPrefix = tc.UrlPrefix
+		artifact := BoxArtifact{Path: boxPath, Name: "TmplBox", Description: "A test box", Version: tc.Version, Provider: "StrongSapling", Architecture: tc.Architecture, ChecksumType: "sha256", Checksum: "0xB00B1E5"}
+		if err = manager.AddBox(artifact); err != nil {
+			t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+		}
+		if !memBackend.FileExists(tc.StoredUri) {
+			t.Fatalf("Expected the box file to be stored at '%v'\n", tc.StoredUri)
+		}
+		catalog, err := manager.GetCatalog()
+		if err != nil {
+			t.Fatalf("Error getting catalog: %v\n", err)
+		}
+		query, _ := catalog.QueryCatalog(CatalogQueryParams{Version: tc.Version, Architecture: tc.Architecture})
+		refs := query.BoxReferences()
+		if tc.Architecture == "" {
+			refs = refs[:1]
+		}
+		if len(refs) != 1 || refs[0].Uri != tc.Url {
+			t.Fatalf("Expected the catalog to record the URL '%v', but got %v\n", tc.Url, refs)
+		}
+	}
+
+	// Box files with custom names are still found from their URLs
+	manager.UrlPrefix = "https://cdn.example.com/boxes"
+	if orphans, err := manager.CollectGarbage(); err != nil || len(orphans) != 0 {
+		t.Fatalf("Expected no orphaned box files, but got %v (error %v)\n", orphans, err)
+	}
+	if _, err = manager.DeleteBox(CatalogQueryParams{Version: "2.0.0"}); err != nil {
+		t.Fatalf("DeleteBox() returned an unexpected error: %v\n", err)
+	}
+	if memBackend.FileExists(testCases[2].StoredUri) {
+		t.Fatalf("Expected DeleteBox() to delete the box file at '%v'\n", testCases[2].StoredUri)
+	}
+
+	manager.FilenameTemplate = "{{.Name}}.box"
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: "TmplBox", Description: "A test box", Version: "3.0.0", Provider: "StrongSapling"}); err != nil {
+		t.Fatalf("AddBox() with a template that ignores the version returned an unexpected error: %v\n", err)
+	}
+}
+
+func TestBackendManagerRelativeUrls(t *testing.T) {
+	var (
+		catalogUri = "mem://TestBackendManagerRelativeUrls/RelBox.json"
+		storedUri  = "mem://TestBackendManagerRelativeUrls/RelBox/RelBox_1.0.0_StrongSapling.box"
+		boxUrl     = "RelBox/RelBox_1.0.0_StrongSapling.box"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestBackendManagerRelativeUrls.box")
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	artifact, err := DeriveArtifactInfoFromBoxFile(boxPath, "sha256")
+	if err != nil {
+		t.Fatalf("Error deriving artifact info: %v\n", err)
+	}
+	artifact.Name = "RelBox"
+	artifact.Description = "A test box"
+	artifact.Version = "1.0.0"
+
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	manager.RelativeUrls = true
+	if err = manager.AddBox(artifact); err != nil {
+		t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+	}
+
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	if url := catalog.Versions[0].Providers[0].Url; url != boxUrl {
+		t.Fatalf("Expected the catalog to record the URL '%v', but it recorded '%v'\n", boxUrl, url)
+	}
+	if problems := catalog.Check(); len(problems) != 0 {
+		t.Fatalf("Expected a catalog with relative URLs to pass Check(), but got %v\n", problems)
+	}
+	if !backend.(*CaryatidMemoryBackend).FileExists(storedUri) {
+		t.Fatalf("Expected the box file to be stored at '%v'\n", storedUri)
+	}
+
+	reader := NewBackendManager(catalogUri, &backend)
+	if results, err := reader.VerifyBoxes(CatalogQueryParams{}); err != nil || len(results) != 1 || !results[0].Passed() {
+		t.Fatalf("Expected the box to pass verification, but got %v (error %v)\n", results, err)
+	}
+
+	server := httptest.NewServer(NewCatalogServer(reader))
+	defer server.Close()
+	response, err := http.Get(server.URL + "/boxes/1.0.0/StrongSapling")
+	if err != nil {
+		t.Fatalf("Error getting box file: %v\n", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 for a relative box URL, but got %v\n", response.StatusCode)
+	}
+}
+
+func TestBackendManagerTypedErrors(t *testing.T) {
+	var (
+		boxName     = "TypedErrorsBox"
+		boxProvider = "StrongSapling"
+		boxPath     = path.Join(integrationTestDir, "incoming-TestBackendManagerTypedErrors.box")
+	)
+	if err := CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+
+	type TestCase struct {
+		CatalogUri string
+		Retry      bool
+	}
+	testCases := []TestCase{
+		TestCase{"mem://TestBackendManagerTypedErrors/TypedErrorsBox.json", false},
+		TestCase{"mem://TestBackendManagerTypedErrorsRetry/TypedErrorsBox.json", true},
+		TestCase{fmt.Sprintf("file://%v/TestBackendManagerTypedErrors/TypedErrorsBox.json", integrationTestDir), false},
+	}
+
+	for _, tc := range testCases {
+		backend, err := NewBackendFromUri(tc.CatalogUri)
+		if err != nil {
+			t.Fatalf("Error getting backend for '%v': %v\n", tc.CatalogUri, err)
+		}
+		if tc.Retry {
+			backend = &RetryBackend{Backend: backend, MaxAttempts: 3}
+		}
+		manager := NewBackendManager(tc.CatalogUri, &backend)
+
+		catalog, err := manager.GetCatalog()
+		if !errors.Is(err, ErrCatalogNotFound) {
+			t.Fatalf("Expected GetCatalog() for '%v' to return ErrCatalogNotFound before a catalog exists, but got %v\n", tc.CatalogUri, err)
+		}
+		if !catalog.Equals(&Catalog{}) {
+			t.Fatalf("Expected GetCatalog() for '%v' to return an empty catalog before a catalog exists, but got %v\n", tc.CatalogUri, catalog)
+		}
+
+		// Methods that change the catalog still start from an empty catalog
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: "1.0.0", Provider: boxProvider, ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("AddBox() to '%v' returned an unexpected error: %v\n", tc.CatalogUri, err)
+		}
+		if _, err = manager.GetCatalog(); err != nil {
+			t.Fatalf("GetCatalog() for '%v' returned an unexpected error after adding a box: %v\n", tc.CatalogUri, err)
+		}
+
+		boxUri, err := manager.providerBoxUri(boxName, "1.0.0", Provider{Name: boxProvider})
+		if err != nil {
+			t.Fatalf("Error getting box URI: %v\n", err)
+		}
+		if err = manager.Backend.DeleteFile(boxUri); err != nil {
+			t.Fatalf("Error deleting box file at '%v': %v\n", boxUri, err)
+		}
+		if _, err = manager.Backend.OpenFile(boxUri); !errors.Is(err, ErrBoxNotFound) || !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Expected OpenFile() for a missing box file to return ErrBoxNotFound, but got %v\n", err)
+		}
+		if err = manager.Backend.DeleteFile(boxUri); !errors.Is(err, ErrBoxNotFound) {
+			t.Fatalf("Expected DeleteFile() for a missing box file to return ErrBoxNotFound, but got %v\n", err)
+		}
+		if _, err = manager.DeleteBox(CatalogQueryParams{Version: "1.0.0"}); !errors.Is(err, ErrBoxNotFound) {
+			t.Fatalf("Expected DeleteBox() for a box whose file is missing to return ErrBoxNotFound, but got %v\n", err)
+		}
+	}
+}
+
+func TestBackendManagerMigratesCatalog(t *testing.T) {
+	catalogUri := "mem://TestBackendManagerMigratesCatalog/MigratedBox.json"
+	// A catalog written before caryatid recorded its schema version
+	v0CatalogBytes := []byte(`{"name":"MigratedBox","description":"An old box","versions":[{"version":"1.0.0","providers":[{"name":"StrongSapling","url":"mem://TestBackendManagerMigratesCatalog/MigratedBox/MigratedBox_1.0.0_StrongSapling.box","checksum_type":"SHA256","checksum":"0xB00B1E5"}]},{"version":"2.0.0","providers":null}]}`)
+
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	if err = backend.SetCatalogBytes(v0CatalogBytes); err != nil {
+		t.Fatalf("Error setting catalog bytes: %v\n", err)
+	}
+
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("GetCatalog() returned an unexpected error: %v\n", err)
+	}
+	if checksumType := catalog.Versions[0].Providers[0].ChecksumType; checksumType != "sha256" {
+		t.Fatalf("Expected the checksum type to be migrated to 'sha256', but it was '%v'\n", checksumType)
+	}
+	if catalog.Versions[1].Providers == nil {
+		t.Fatalf("Expected null providers to be migrated to an empty list\n")
+	}
+
+	migratedCatalog := catalog.Sorted()
+	if MigrateCatalog(&catalog, CatalogSchemaVersion) || !catalog.Equals(&migratedCatalog) {
+		t.Fatalf("Expected migrating an already migrated catalog to do nothing\n")
+	}
+
+	if err = manager.SaveCatalog(catalog); err != nil {
+		t.Fatalf("SaveCatalog() returned an unexpected error: %v\n", err)
+	}
+	savedBytes, err := backend.GetCatalogBytes()
+	if err != nil {
+		t.Fatalf("Error getting catalog bytes: %v\n", err)
+	}
+	expected := fmt.Sprintf(`"caryatid_schema_version": %v`, CatalogSchemaVersion)
+	if !strings.Contains(string(savedBytes), expected) {
+		t.Fatalf("Expected the saved catalog to contain '%v', but it was:\n%v\n", expected, string(savedBytes))
+	}
+	if strings.Contains(string(savedBytes), "null") {
+		t.Fatalf("Expected the saved catalog not to contain any nulls, but it was:\n%v\n", string(savedBytes))
+	}
+
+	// A catalog from a newer version of caryatid is not touched
+	newer := Catalog{Name: "NewerBox"}
+	if MigrateCatalog(&newer, CatalogSchemaVersion+1) || newer.Versions != nil {
+		t.Fatalf("Expected a catalog with a newer schema version not to be migrated\n")
+	}
+}
+
+func TestBackendManagerJSONStyle(t *testing.T) {
+	type TestCase struct {
+		Style           JSONStyle
+		ExpectIndented  bool
+		ExpectSaveError bool
+	}
+
+	catalog := Catalog{Name: "StyledBox", Description: "A box saved in different styles", Versions: []Version{
+		Version{Version: "1.0.0", Description: "The first release", Providers: []Provider{
+			Provider{Name: "StrongSapling", Url: "mem://TestBackendManagerJSONStyle/StyledBox/StyledBox_1.0.0_StrongSapling.box", ChecksumType: "sha256", Checksum: "0xB00B1E5", Labels: map[string]string{"build": "42"}, Checksums: []Checksum{Checksum{"sha256", "0xB00B1E5"}, Checksum{"md5", "0xDEC0DE"}}},
+		}},
+		Version{Version: "1.0.1", Providers: []Provider{
+			Provider{Name: "StrongSapling", Url: "mem://TestBackendManagerJSONStyle/StyledBox/StyledBox_1.0.1_StrongSapling.box", ChecksumType: "sha256", Checksum: "0xDEC0DE"},
+		}},
+	}}
+
+	testCases := []TestCase{
+		TestCase{"", true, false},
+		TestCase{JSONStylePretty, true, false},
+		TestCase{JSONStyleCompact, false, false},
+		TestCase{"tabbed", false, true},
+	}
+	for _, tc := range testCases {
+		catalogUri := fmt.Sprintf("mem://TestBackendManagerJSONStyle/%v/StyledBox.json", tc.Style)
+		backend, err := NewBackendFromUri(catalogUri)
+		if err != nil {
+			t.Fatalf("Error getting backend: %v\n", err)
+		}
+		writer := NewBackendManager(catalogUri, &backend)
+		writer.JSONStyle = tc.Style
+		err = writer.SaveCatalog(catalog)
+		if tc.ExpectSaveError {
+			if err == nil {
+				t.Fatalf("Expected SaveCatalog() with JSON style '%v' to fail\n", tc.Style)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("SaveCatalog() with JSON style '%v' returned an unexpected error: %v\n", tc.Style, err)
+		}
+
+		savedBytes, err := backend.GetCatalogBytes()
+		if err != nil {
+			t.Fatalf("Error getting catalog bytes: %v\n", err)
+		}
+		if indented := strings.Contains(string(savedBytes), "\n  "); indented != tc.ExpectIndented {
+			t.Fatalf("Expected the catalog saved with JSON style '%v' to be indented: %v, but it was:\n%v\n", tc.Style, tc.ExpectIndented, string(savedBytes))
+		}
+
+		// Read it back with a manager that would save it in the other style
+		readerBackend, err := NewBackendFromUri(catalogUri)
+		if err != nil {
+			t.Fatalf("Error getting backend: %v\n", err)
+		}
+		reader := NewBackendManager(catalogUri, &readerBackend)
+		reader.JSONStyle = JSONStyleCompact
+		if tc.Style == JSONStyleCompact {
+			reader.JSONStyle = JSONStylePretty
+		}
+		readCatalog, err := reader.GetCatalog()
+		if err != nil {
+			t.Fatalf("GetCatalog() of a catalog saved with JSON style '%v' returned an unexpected error: %v\n", tc.Style, err)
+		}
+		if !readCatalog.Equals(&catalog) {
+			t.Fatalf("Expected the catalog saved with JSON style '%v' to read back as\n%v\nbut got\n%v\n", tc.Style, catalog.DisplayString(), readCatalog.DisplayString())
+		}
+	}
+
+	if _, err := ParseJSONStyle("tabbed"); err == nil {
+		t.Fatalf("Expected ParseJSONStyle() to reject an unknown style\n")
+	}
+}
+
+func TestBackendManagerNormalizeVersions(t *testing.T) {
+	var (
+		boxName    = "NormalizedBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestBackendManagerNormalizeVersions.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerNormalizeVersions/%v.json", boxName)
+	)
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	artifact := BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "v1.2.3", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}
+
+	if err = manager.AddBox(artifact); err == nil {
+		t.Fatalf("Expected AddBox() to reject version 'v1.2.3' without NormalizeVersions\n")
+	}
+
+	manager.NormalizeVersions = true
+	if err = manager.AddBox(artifact); err != nil {
+		t.Fatalf("AddBox() with NormalizeVersions returned an error: %v\n", err)
+	}
+	if artifact.Version != "v1.2.3" {
+		t.Fatalf("Expected AddBox() not to modify the caller's artifact, but its version is now '%v'\n", artifact.Version)
+	}
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("GetCatalog() returned an error: %v\n", err)
+	}
+	if len(catalog.Versions) != 1 || catalog.Versions[0].Version != "1.2.3" {
+		t.Fatalf("Expected version 'v1.2.3' to be stored as '1.2.3', but got:\n%v\n", catalog.DisplayString())
+	}
+	expectedUrl := fmt.Sprintf("mem://TestBackendManagerNormalizeVersions/%v/%v_1.2.3_StrongSapling.box", boxName, boxName)
+	if url := catalog.Versions[0].Providers[0].Url; url != expectedUrl {
+		t.Fatalf("Expected the box file to be named for the normalized version, at '%v', but it is at '%v'\n", expectedUrl, url)
+	}
+
+	// The plain and prefixed versions are the same box, so adding it again is skipped rather than duplicated
+	artifact.Version = "1.2.3"
+	if err = manager.AddBox(artifact); err != nil {
+		t.Fatalf("AddBox() of the same box with the plain version returned an error: %v\n", err)
+	}
+	if catalog, err = manager.GetCatalog(); err != nil || len(catalog.Versions) != 1 || len(catalog.Versions[0].Providers) != 1 {
+		t.Fatalf("Expected the catalog to still have one version with one provider, but got %v:\n%v\n", err, catalog.DisplayString())
+	}
+
+	// Without NormalizeVersions, a nonstandard version keeps its 'v'
+	manager.NormalizeVersions = false
+	manager.AllowNonstandardVersion = true
+	artifact.Version = "v2.0.0"
+	if err = manager.AddBox(artifact); err != nil {
+		t.Fatalf("AddBox() of a nonstandard version returned an error: %v\n", err)
+	}
+	if catalog, err = manager.GetCatalog(); err != nil || len(catalog.Versions) != 2 || catalog.Versions[1].Version != "v2.0.0" {
+		t.Fatalf("Expected version 'v2.0.0' to be stored as it is, but got %v:\n%v\n", err, catalog.DisplayString())
+	}
+}
+
+// readCountingBackend counts how many times the catalog is read from the backend it wraps
+type readCountingBackend struct {
+	CaryatidBackend
+	Reads int
+}
+
+func (backend *readCountingBackend) GetCatalogBytes() ([]byte, error) {
+	backend.Reads += 1
+	return backend.CaryatidBackend.GetCatalogBytes()
+}
+
+func TestBackendManagerCachesCatalog(t *testing.T) {
+	var (
+		boxName     = "CachedBox"
+		boxProvider = "StrongSapling"
+		boxPath     = path.Join(integrationTestDir, "incoming-TestBackendManagerCachesCatalog.box")
+		catalogUri  = fmt.Sprintf("mem://TestBackendManagerCachesCatalog/%v.json", boxName)
+	)
+	if err := CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	memBackend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	counter := &readCountingBackend{CaryatidBackend: memBackend}
+	var backend CaryatidBackend = counter
+	manager := NewBackendManager(catalogUri, &backend)
+
+	expectReads := func(expected int, when string) {
+		if counter.Reads != expected {
+			t.Fatalf("Expected %v reads of the catalog %v, but there were %v\n", expected, when, counter.Reads)
+		}
+	}
+
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: "1.0.0", Provider: boxProvider}); err != nil {
+		t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+	}
+	counter.Reads = 0
+
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("GetCatalog() returned an unexpected error: %v\n", err)
+	}
+	for idx := 0; idx < 3; idx += 1 {
+		if _, err = manager.GetCatalog(); err != nil {
+			t.Fatalf("GetCatalog() returned an unexpected error: %v\n", err)
+		}
+	}
+	expectReads(1, "after calling GetCatalog() repeatedly")
+
+	// Modifying a returned catalog must not change the cached one
+	catalog.Versions[0].Providers[0].Checksum = "0xDECAFBAD"
+	catalog.Versions = append(catalog.Versions, Version{Version: "9.9.9"})
+	cached, _ := manager.GetCatalog()
+	if len(cached.Versions) != 1 || cached.Versions[0].Providers[0].Checksum == "0xDECAFBAD" {
+		t.Fatalf("Modifying the result of GetCatalog() changed the cached catalog: %+v\n", cached)
+	}
+
+	if _, err = manager.Reload(); err != nil {
+		t.Fatalf("Reload() returned an unexpected error: %v\n", err)
+	}
+	expectReads(2, "after Reload()")
+
+	// Changing the catalog reads it fresh after locking it, and the catalog that was saved is read again afterwards
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: "2.0.0", Provider: boxProvider}); err != nil {
+		t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+	}
+	readsAfterAdd := counter.Reads
+	if readsAfterAdd <= 2 {
+		t.Fatalf("Expected AddBox() to read the catalog from the backend instead of the cache\n")
+	}
+	catalog, err = manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("GetCatalog() returned an unexpected error: %v\n", err)
+	}
+	expectReads(readsAfterAdd+1, "after saving the catalog")
+	if len(catalog.Versions) != 2 {
+		t.Fatalf("Expected the catalog to have 2 versions after AddBox(), but it had %v: %+v\n", len(catalog.Versions), catalog)
+	}
+	if _, err = manager.GetCatalog(); err != nil {
+		t.Fatalf("GetCatalog() returned an unexpected error: %v\n", err)
+	}
+	expectReads(readsAfterAdd+1, "after calling GetCatalog() again")
+}
+
+// failingCopyBackend fails to copy any box file to a URI containing FailUriSubstring, if it is set
+type failingCopyBackend struct {
+	CaryatidBackend
+	FailUriSubstring string
+}
+
+func (backend *failingCopyBackend) CopyBoxFile(localPath string, boxUri string) error {
+	if backend.FailUriSubstring != "" && strings.Contains(boxUri, backend.FailUriSubstring) {
+		return fmt.Errorf("Refusing to copy to '%v'", boxUri)
+	}
+	return backend.CaryatidBackend.CopyBoxFile(localPath, boxUri)
+}
+
+// corruptingCopyBackend copies box files, but then overwrites what it stored with Garbage, if it is set,
+// like a flaky network or storage layer might
+type corruptingCopyBackend struct {
+	CaryatidBackend
+	Garbage []byte
+}
+
+func (backend *corruptingCopyBackend) CopyBoxFile(localPath string, boxUri string) (err error) {
+	if err = backend.CaryatidBackend.CopyBoxFile(localPath, boxUri); err != nil || backend.Garbage == nil {
+		return
+	}
+	memoryBackendLock.Lock()
+	defer memoryBackendLock.Unlock()
+	memoryBackendFiles[boxUri] = backend.Garbage
+	return
+}
+
+// slowCopyBackend takes Delay to copy a box file, which AddBoxes() does after reading the catalog and before saving it,
+// so that concurrent changes to the catalog would overlap if they were not serialized
+type slowCopyBackend struct {
+	CaryatidBackend
+	Delay time.Duration
+}
+
+func (backend *slowCopyBackend) CopyBoxFile(localPath string, boxUri string) error {
+	time.Sleep(backend.Delay)
+	return backend.CaryatidBackend.CopyBoxFile(localPath, boxUri)
+}
+
+func TestBackendManagerConcurrentAdds(t *testing.T) {
+	var (
+		boxName  = "TestConcurrentAddsBox"
+		boxPath  = path.Join(integrationTestDir, "incoming-TestConcurrentAddsBox.box")
+		versions = 12
+	)
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+
+	for _, catalogUri := range []string{
+		fmt.Sprintf("mem://TestBackendManagerConcurrentAdds/%v.json", boxName),
+		fmt.Sprintf("file://%v/TestBackendManagerConcurrentAdds/%v.json", integrationTestDir, boxName),
+	} {
+		inner, err := NewBackendFromUri(catalogUri)
+		if err != nil {
+			t.Fatalf("Error getting backend for '%v': %v\n", catalogUri, err)
+		}
+		var backend CaryatidBackend = &slowCopyBackend{CaryatidBackend: inner, Delay: 5 * time.Millisecond}
+		manager := NewBackendManager(catalogUri, &backend)
+
+		errs := make(chan error, versions)
+		var adders sync.WaitGroup
+		for idx := 0; idx < versions; idx++ {
+			adders.Add(1)
+			go func(version string) {
+				defer adders.Done()
+				// Readers may run alongside the writers
+				if _, err := manager.GetCatalog(); err != nil && !errors.Is(err, ErrCatalogNotFound) {
+					errs <- err
+					return
+				}
+				errs <- manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"})
+			}(fmt.Sprintf("1.0.%v", idx))
+		}
+		adders.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("Error adding a box to '%v' concurrently: %v\n", catalogUri, err)
+			}
+		}
+
+		catalog, err := manager.Reload()
+		if err != nil {
+			t.Fatalf("Error reading catalog '%v': %v\n", catalogUri, err)
+		}
+		if len(catalog.Versions) != versions {
+			t.Fatalf("Expected all %v versions added concurrently to '%v' to be in the catalog, but got:\n%v\n", versions, catalogUri, catalog.DisplayString())
+		}
+	}
+}
+
+func TestBackendManagerAddBoxes(t *testing.T) {
+	var (
+		boxName    = "MultiProviderBox"
+		providers  = []string{"StrongSapling", "FeebleFungus"}
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerAddBoxes/%v.json", boxName)
+		artifacts  = []BoxArtifact{}
+	)
+	for _, provider := range providers {
+		boxPath := path.Join(integrationTestDir, fmt.Sprintf("incoming-TestBackendManagerAddBoxes-%v.box", provider))
+		if err := CreateTestBoxFile(boxPath, provider, true); err != nil {
+			t.Fatalf("Error creating test box file: %v\n", err)
+		}
+		artifacts = append(artifacts, BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: "1.2.3", Provider: provider, ChecksumType: "sha256", Checksum: "0xB00B1E5"})
+	}
+	memBackend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	failing := &failingCopyBackend{CaryatidBackend: memBackend}
+	var backend CaryatidBackend = failing
+	manager := NewBackendManager(catalogUri, &backend)
+
+	if err = manager.AddBoxes(artifacts); err != nil {
+		t.Fatalf("AddBoxes() returned an unexpected error: %v\n", err)
+	}
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("GetCatalog() returned an unexpected error: %v\n", err)
+	}
+	if len(catalog.Versions) != 1 || len(catalog.Versions[0].Providers) != 2 {
+		t.Fatalf("Expected both providers under one version, but got:\n%v\n", catalog.DisplayString())
+	}
+	for _, provider := range catalog.Versions[0].Providers {
+		if reader, openErr := memBackend.OpenFile(provider.Url); openErr != nil {
+			t.Fatalf("Expected the box file for '%v' to be copied, but: %v\n", provider.Name, openErr)
+		} else {
+			reader.Close()
+		}
+	}
+
+	// If the second box file cannot be copied, the first is deleted again and the catalog is unchanged
+	for idx := range artifacts {
+		artifacts[idx].Version = "2.0.0"
+	}
+	failing.FailUriSubstring = providers[1]
+	if err = manager.AddBoxes(artifacts); err == nil {
+		t.Fatalf("AddBoxes() succeeded even though a box file could not be copied\n")
+	}
+	rolledBack, err := manager.Reload()
+	if err != nil {
+		t.Fatalf("Reload() returned an unexpected error: %v\n", err)
+	}
+	if !rolledBack.Equals(&catalog) {
+		t.Fatalf("Expected a failed AddBoxes() not to change the catalog, but got:\n%v\n", rolledBack.DisplayString())
+	}
+	boxUris, err := memBackend.ListBoxFiles(boxName)
+	if err != nil {
+		t.Fatalf("ListBoxFiles() returned an unexpected error: %v\n", err)
+	}
+	if len(boxUris) != 2 {
+		t.Fatalf("Expected the box file copied before the failure to be deleted, but the backend has: %v\n", boxUris)
+	}
+
+	// Replacing boxes that fails partway restores the box files that were already replaced
+	memoryBackendLock.RLock()
+	originalBytes := memoryBackendFiles[catalog.Versions[0].Providers[0].Url]
+	memoryBackendLock.RUnlock()
+	replacements := append([]BoxArtifact{}, artifacts...)
+	for idx := range replacements {
+		replacements[idx].Version = "1.2.3"
+		replacements[idx].Path = artifacts[1].Path
+		replacements[idx].Checksum = "0xDEC0DE"
+	}
+	manager.Overwrite = true
+	if err = manager.AddBoxes(replacements); err == nil {
+		t.Fatalf("AddBoxes() succeeded even though a replacement box file could not be copied\n")
+	}
+	manager.Overwrite = false
+	if rolledBack, err = manager.Reload(); err != nil || !rolledBack.Equals(&catalog) {
+		t.Fatalf("Expected a failed AddBoxes() not to change the checksums in the catalog, but got %v:\n%v\n", err, rolledBack.DisplayString())
+	}
+	memoryBackendLock.RLock()
+	restoredBytes := memoryBackendFiles[catalog.Versions[0].Providers[0].Url]
+	memoryBackendLock.RUnlock()
+	if !reflect.DeepEqual(restoredBytes, originalBytes) {
+		t.Fatalf("Expected the box file replaced before the failure to be restored\n")
+	}
+	if boxUris, err = memBackend.ListBoxFiles(boxName); err != nil || len(boxUris) != 2 {
+		t.Fatalf("Expected no backups to be left behind after rolling back, but the backend has %v (error %v)\n", boxUris, err)
+	}
+
+	type TestCase struct {
+		Description string
+		Artifacts   []BoxArtifact
+	}
+	mismatched := append([]BoxArtifact{}, artifacts...)
+	mismatched[1].Version = "3.0.0"
+	duplicated := []BoxArtifact{artifacts[0], artifacts[0]}
+	for _, tc := range []TestCase{
+		TestCase{"no artifacts", []BoxArtifact{}},
+		TestCase{"different versions", mismatched},
+		TestCase{"the same provider twice", duplicated},
+	} {
+		if err = manager.AddBoxes(tc.Artifacts); err == nil {
+			t.Fatalf("Expected AddBoxes() to fail for %v\n", tc.Description)
+		}
+	}
+}
+
+func TestBackendManagerAddBoxesCancelled(t *testing.T) {
+	var (
+		boxName    = "CancelledBox"
+		providers  = []string{"StrongSapling", "FeebleFungus"}
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerAddBoxesCancelled/%v.json", boxName)
+		artifacts  = []BoxArtifact{}
+	)
+	for _, provider := range providers {
+		boxPath := path.Join(integrationTestDir, fmt.Sprintf("incoming-TestBackendManagerAddBoxesCancelled-%v.box", provider))
+		if err := CreateTestBoxFile(boxPath, provider, true); err != nil {
+			t.Fatalf("Error creating test box file: %v\n", err)
+		}
+		artifacts = append(artifacts, BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: "1.2.3", Provider: provider, ChecksumType: "sha256", Checksum: "0xB00B1E5"})
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+
+	// Cancel once the first box file is copied, as an interrupt would, while the change still holds the lock
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager.Context = ctx
+	manager.CopyProgress = func(transferred int64, total int64) {
+		if transferred == total {
+			if !ChangesInProgress() {
+				t.Errorf("Expected ChangesInProgress() while copying a box file\n")
+			}
+			cancel()
+		}
+	}
+	if err = manager.AddBoxes(artifacts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected a cancelled AddBoxes() to fail with context.Canceled, but got: %v\n", err)
+	}
+	if ChangesInProgress() {
+		t.Fatalf("Expected a cancelled AddBoxes() to release its lock\n")
+	}
+	if catalog, err := manager.Reload(); !errors.Is(err, ErrCatalogNotFound) {
+		t.Fatalf("Expected a cancelled AddBoxes() not to save a catalog, but got %v:\n%v\n", err, catalog.DisplayString())
+	}
+	if boxUris, err := backend.ListBoxFiles(boxName); err != nil || len(boxUris) != 0 {
+		t.Fatalf("Expected the box file copied before cancelling to be deleted, but the backend has %v (error %v)\n", boxUris, err)
+	}
+
+	// Once the interrupted change has rolled back, the catalog can be changed again
+	manager.Context = context.Background()
+	if err = manager.AddBoxes(artifacts); err != nil {
+		t.Fatalf("AddBoxes() returned an unexpected error after a cancelled AddBoxes(): %v\n", err)
+	}
+}
+
+// slowSaveBackend takes Delay to save the catalog, but does everything else right away
+type slowSaveBackend struct {
+	CaryatidBackend
+	Delay time.Duration
+}
+
+func (backend *slowSaveBackend) SetCatalogBytes(serializedCatalog []byte) error {
+	time.Sleep(backend.Delay)
+	return backend.CaryatidBackend.SetCatalogBytes(serializedCatalog)
+}
+
+// A save that times out is an error, but does not roll back the box files, since the save may still land
+func TestBackendManagerAddBoxSaveTimesOut(t *testing.T) {
+	var (
+		boxName    = "TestAddBoxSaveTimesOutBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestAddBoxSaveTimesOutBox.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerAddBoxSaveTimesOut/%v.json", boxName)
+	)
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	memBackend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	slow := &slowSaveBackend{CaryatidBackend: memBackend}
+	var backend CaryatidBackend = NewTimeoutBackend(slow, 100*time.Millisecond)
+	manager := NewBackendManager(catalogUri, &backend)
+
+	// The save is abandoned after 100ms, but lands after 150ms
+	slow.Delay = 150 * time.Millisecond
+	err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "1.0.0", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"})
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected AddBox() to return a TimeoutError, but got: %v\n", err)
+	}
+	// The abandoned save does not hold up reading the catalog, so wait for it to land
+	var refs BoxReferenceList
+	for deadline := time.Now().Add(2 * time.Second); len(refs) != 1; time.Sleep(10 * time.Millisecond) {
+		catalog, err := manager.Reload()
+		if err != nil && !errors.Is(err, ErrCatalogNotFound) {
+			t.Fatalf("Error getting catalog: %v\n", err)
+		}
+		if refs = catalog.BoxReferences(); len(refs) != 1 && time.Now().After(deadline) {
+			t.Fatalf("Expected the abandoned save to land, but got:\n%v\n", catalog.DisplayString())
+		}
+	}
+	if reader, openErr := memBackend.OpenFile(refs[0].Uri); openErr != nil {
+		t.Fatalf("The catalog refers to a box file that was rolled back: %v\n", openErr)
+	} else {
+		reader.Close()
+	}
+}
+
+// Copying and merging boxes updates the latest aliases, and neither changes anything in a dry run
+func TestBackendManagerCopyMergeLatestAlias(t *testing.T) {
+	var (
+		boxName    = "CopyAliasBox"
+		oldPath    = path.Join(integrationTestDir, "incoming-TestBackendManagerCopyMergeLatestAlias-old.box")
+		newPath    = path.Join(integrationTestDir, "incoming-TestBackendManagerCopyMergeLatestAlias-new.box")
+		sourceUri  = fmt.Sprintf("mem://TestBackendManagerCopyMergeLatestAlias/source/%v.json", boxName)
+		destUri    = fmt.Sprintf("mem://TestBackendManagerCopyMergeLatestAlias/dest/%v.json", boxName)
+		artifact   = BoxArtifact{Name: boxName, Description: "desc", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}
+		oldVersion = artifact
+		newVersion = artifact
+	)
+	if err := CreateTestBoxFile(oldPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	if err := CreateTestBoxFile(newPath, "StrongSapling", false); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	oldVersion.Path, oldVersion.Version = oldPath, "1.0.0"
+	newVersion.Path, newVersion.Version = newPath, "2.0.0"
+
+	sourceBackend, err := NewBackendFromUri(sourceUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	source := NewBackendManager(sourceUri, &sourceBackend)
+	for _, version := range []BoxArtifact{oldVersion, newVersion} {
+		if err = source.AddBox(version); err != nil {
+			t.Fatalf("AddBox() returned an error: %v\n", err)
+		}
+	}
+	destBackend, err := NewBackendFromUri(destUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	dest := NewBackendManager(destUri, &destBackend)
+	dest.LatestAlias = true
+	memBackend := destBackend.(*CaryatidMemoryBackend)
+	aliasUri, _ := dest.latestAliasUri(boxName, "StrongSapling", "")
+	expectAlias := func(version string) {
+		boxUri, _ := BoxUriFromCatalogUri(destUri, boxName, version, "StrongSapling")
+		memoryBackendLock.RLock()
+		defer memoryBackendLock.RUnlock()
+		alias, ok := memoryBackendFiles[aliasUri]
+		if !ok || !bytes.Equal(alias, memoryBackendFiles[boxUri]) {
+			t.Fatalf("Expected '%v' to be an alias of version '%v'\n", aliasUri, version)
+		}
+	}
+
+	dest.DryRun = true
+	if copied, err := dest.CopyBoxes(source, CatalogQueryParams{Version: "1.0.0"}, false); err != nil || len(copied) != 1 {
+		t.Fatalf("Expected a dry run of CopyBoxes() to report one box, but got %v and error %v\n", copied, err)
+	}
+	if merged, err := dest.MergeCatalog(source, true, false); err != nil || len(merged) != 2 {
+		t.Fatalf("Expected a dry run of MergeCatalog() to report two boxes, but got %v and error %v\n", merged, err)
+	}
+	if _, err = dest.Reload(); !errors.Is(err, ErrCatalogNotFound) || memBackend.FileExists(aliasUri) {
+		t.Fatalf("Expected a dry run not to change anything, but got error %v\n", err)
+	}
+	dest.DryRun = false
+
+	if _, err = dest.CopyBoxes(source, CatalogQueryParams{Version: "1.0.0"}, false); err != nil {
+		t.Fatalf("CopyBoxes() returned an error: %v\n", err)
+	}
+	expectAlias("1.0.0")
+	if _, err = dest.MergeCatalog(source, true, false); err != nil {
+		t.Fatalf("MergeCatalog() returned an error: %v\n", err)
+	}
+	expectAlias("2.0.0")
+}
+
+// Merging box files that fails partway restores the box files that were already replaced
+func TestBackendManagerMergeCatalog(t *testing.T) {
+	var (
+		boxName    = "MergeBox"
+		destPath   = path.Join(integrationTestDir, "incoming-TestBackendManagerMergeCatalog-dest.box")
+		sourcePath = path.Join(integrationTestDir, "incoming-TestBackendManagerMergeCatalog-source.box")
+		destUri    = fmt.Sprintf("mem://TestBackendManagerMergeCatalog/dest/%v.json", boxName)
+		sourceUri  = fmt.Sprintf("mem://TestBackendManagerMergeCatalog/source/%v.json", boxName)
+	)
+	// The same provider, but different bytes
+	if err := CreateTestBoxFile(destPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	if err := CreateTestBoxFile(sourcePath, "StrongSapling", false); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+
+	memBackend, err := NewBackendFromUri(destUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	failing := &failingCopyBackend{CaryatidBackend: memBackend}
+	var destBackend CaryatidBackend = failing
+	dest := NewBackendManager(destUri, &destBackend)
+	sourceBackend, err := NewBackendFromUri(sourceUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	source := NewBackendManager(sourceUri, &sourceBackend)
+
+	if err = dest.AddBox(BoxArtifact{Path: destPath, Name: boxName, Description: "desc", Version: "1.0.0", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		if err = source.AddBox(BoxArtifact{Path: sourcePath, Name: boxName, Description: "desc", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xDEC0DE"}); err != nil {
+			t.Fatalf("Error adding box to source catalog: %v\n", err)
+		}
+	}
+	catalog, err := dest.GetCatalog()
+	if err != nil {
+		t.Fatalf("GetCatalog() returned an unexpected error: %v\n", err)
+	}
+	memoryBackendLock.RLock()
+	originalBytes := memoryBackendFiles[catalog.Versions[0].Providers[0].Url]
+	memoryBackendLock.RUnlock()
+
+	failing.FailUriSubstring = boxName + "_2.0.0"
+	if _, err = dest.MergeCatalog(source, true, true); err == nil {
+		t.Fatalf("MergeCatalog() succeeded even though a box file could not be copied\n")
+	}
+	rolledBack, err := dest.Reload()
+	if err != nil || !rolledBack.Equals(&catalog) {
+		t.Fatalf("Expected a failed MergeCatalog() not to change the catalog, but got %v:\n%v\n", err, rolledBack.DisplayString())
+	}
+	memoryBackendLock.RLock()
+	restoredBytes := memoryBackendFiles[catalog.Versions[0].Providers[0].Url]
+	memoryBackendLock.RUnlock()
+	if !reflect.DeepEqual(restoredBytes, originalBytes) {
+		t.Fatalf("Expected the box file replaced before the failure to be restored\n")
+	}
+	if boxUris, err := memBackend.ListBoxFiles(boxName); err != nil || len(boxUris) != 1 {
+		t.Fatalf("Expected only the original box file after rolling back, but the backend has %v (error %v)\n", boxUris, err)
+	}
+
+	failing.FailUriSubstring = ""
+	if _, err = dest.MergeCatalog(source, true, true); err != nil {
+		t.Fatalf("MergeCatalog() returned an unexpected error: %v\n", err)
+	}
+}
+
+func TestBackendManagerAddBoxExisting(t *testing.T) {
+	var (
+		boxName     = "ExistingBox"
+		boxProvider = "StrongSapling"
+		boxPath     = path.Join(integrationTestDir, "incoming-TestBackendManagerAddBoxExisting.box")
+		catalogUri  = fmt.Sprintf("mem://TestBackendManagerAddBoxExisting/%v.json", boxName)
+	)
+	if err := CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	artifact := BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: "1.0.0", Provider: boxProvider, ChecksumType: "sha256", Checksum: "0xB00B1E5"}
+
+	if err = manager.AddBox(artifact); err != nil {
+		t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+	}
+	original, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("GetCatalog() returned an unexpected error: %v\n", err)
+	}
+
+	// Adding the same box again does nothing
+	if err = manager.AddBox(artifact); err != nil {
+		t.Fatalf("Expected adding the same box again to do nothing, but got: %v\n", err)
+	}
+
+	// Adding the same version and provider with a different checksum is an error, and changes nothing
+	artifact.Checksum = "0xDECAFBAD"
+	if err = manager.AddBox(artifact); !errors.Is(err, ErrBoxExists) {
+		t.Fatalf("Expected adding an existing box to fail with ErrBoxExists, but got: %v\n", err)
+	}
+	unchanged, err := manager.Reload()
+	if err != nil {
+		t.Fatalf("Reload() returned an unexpected error: %v\n", err)
+	}
+	if !unchanged.Equals(&original) {
+		t.Fatalf("Expected a failed AddBox() not to change the catalog, but got:\n%v\n", unchanged.DisplayString())
+	}
+
+	// A different architecture is a different box
+	archArtifact := artifact
+	archArtifact.Architecture = "arm64"
+	if err = manager.AddBox(archArtifact); err != nil {
+		t.Fatalf("AddBox() for a new architecture returned an unexpected error: %v\n", err)
+	}
+
+	manager.Overwrite = true
+	if err = manager.AddBox(artifact); err != nil {
+		t.Fatalf("AddBox() with Overwrite returned an unexpected error: %v\n", err)
+	}
+	replaced, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("GetCatalog() returned an unexpected error: %v\n", err)
+	}
+	if len(replaced.Versions) != 1 || len(replaced.Versions[0].Providers) != 2 {
+		t.Fatalf("Expected Overwrite to replace the box rather than add another, but got:\n%v\n", replaced.DisplayString())
+	}
+	for _, p := range replaced.Versions[0].Providers {
+		if p.Architecture == "" && p.Checksum != "0xDECAFBAD" {
+			t.Fatalf("Expected Overwrite to replace the checksum, but got:\n%v\n", replaced.DisplayString())
+		}
+	}
+}
+
+func TestBackendManagerVerifyAfterCopy(t *testing.T) {
+	var (
+		boxName     = "VerifiedCopyBox"
+		boxProvider = "StrongSapling"
+		boxPath     = path.Join(integrationTestDir, "incoming-TestBackendManagerVerifyAfterCopy.box")
+		catalogUri  = fmt.Sprintf("mem://TestBackendManagerVerifyAfterCopy/%v.json", boxName)
+	)
+	if err := CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	checksums, err := FileChecksums(boxPath, []string{"sha256"})
+	if err != nil {
+		t.Fatalf("Error computing the checksum of the test box file: %v\n", err)
+	}
+	artifact := func(version string) BoxArtifact {
+		return BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: version, Provider: boxProvider, ChecksumType: "sha256", Checksum: checksums[0].Value}
+	}
+	memBackend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	corrupting := &corruptingCopyBackend{CaryatidBackend: memBackend}
+	var backend CaryatidBackend = corrupting
+	manager := NewBackendManager(catalogUri, &backend)
+	manager.VerifyAfterCopy = true
+
+	if err = manager.AddBox(artifact("1.0.0")); err != nil {
+		t.Fatalf("AddBox() failed to verify an intact copy: %v\n", err)
+	}
+	catalog, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("GetCatalog() returned an unexpected error: %v\n", err)
+	}
+
+	corrupting.Garbage = []byte("corrupted on the way")
+	if err = manager.AddBox(artifact("2.0.0")); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Expected AddBox() to fail with ErrChecksumMismatch for a corrupted copy, but got: %v\n", err)
+	}
+	unchanged, err := manager.Reload()
+	if err != nil {
+		t.Fatalf("Reload() returned an unexpected error: %v\n", err)
+	}
+	if !unchanged.Equals(&catalog) {
+		t.Fatalf("Expected a failed AddBox() not to change the catalog, but got:\n%v\n", unchanged.DisplayString())
+	}
+	if boxUris, _ := memBackend.ListBoxFiles(boxName); len(boxUris) != 1 {
+		t.Fatalf("Expected the corrupted box file to be deleted, but the backend has: %v\n", boxUris)
+	}
+
+	// Without verification, the corrupted copy goes unnoticed
+	manager.VerifyAfterCopy = false
+	if err = manager.AddBox(artifact("2.0.0")); err != nil {
+		t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+	}
+}
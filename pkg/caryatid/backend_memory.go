@@ -0,0 +1,226 @@
+/*
+The memory backend, for dealing with a Vagrant catalog that lives only in memory
+
+This is intended for testing.
+All memory backends in a process share the same storage,
+so that a catalog saved by one BackendManager can be read by another,
+the same way that two managers pointed at the same file:// URI would behave.
+*/
+
+package caryatid
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterBackend("mem", func(uri string) (CaryatidBackend, error) {
+		return &CaryatidMemoryBackend{}, nil
+	})
+}
+
+var (
+	memoryBackendLock  sync.RWMutex
+	memoryBackendFiles = make(map[string][]byte)
+)
+
+// ResetMemoryBackend removes all catalogs and box files from the memory backend
+func ResetMemoryBackend() {
+	memoryBackendLock.Lock()
+	defer memoryBackendLock.Unlock()
+	memoryBackendFiles = make(map[string][]byte)
+}
+
+type CaryatidMemoryBackend struct {
+	Manager *BackendManager
+}
+
+func (backend *CaryatidMemoryBackend) SetManager(manager *BackendManager) (err error) {
+	backend.Manager = manager
+	return
+}
+
+func (backend *CaryatidMemoryBackend) GetManager() (manager *BackendManager, err error) {
+	manager = backend.Manager
+	if manager == nil {
+		err = fmt.Errorf("The Manager property was not set")
+	}
+	return
+}
+
+func (backend *CaryatidMemoryBackend) GetCatalogBytes() (catalogBytes []byte, err error) {
+	memoryBackendLock.RLock()
+	defer memoryBackendLock.RUnlock()
+	catalogBytes, ok := memoryBackendFiles[backend.Manager.CatalogUri]
+	if !ok {
+		err = catalogNotFoundError(backend.Manager.CatalogUri)
+	}
+	return
+}
+
+func (backend *CaryatidMemoryBackend) SetCatalogBytes(serializedCatalog []byte) (err error) {
+	memoryBackendLock.Lock()
+	defer memoryBackendLock.Unlock()
+	memoryBackendFiles[backend.Manager.CatalogUri] = append([]byte{}, serializedCatalog...)
+	return
+}
+
+func (backend *CaryatidMemoryBackend) CopyBoxFile(localPath string, boxUri string) (err error) {
+	u, err := url.Parse(boxUri)
+	if err != nil {
+		return fmt.Errorf("Could not parse '%v' as URI: %v", boxUri, err)
+	}
+	if u.Scheme != backend.Scheme() {
+		return fmt.Errorf("Expected scheme '%v' but was given a URI with scheme '%v'", backend.Scheme(), u.Scheme)
+	}
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return
+	}
+	defer localFile.Close()
+	localInfo, err := localFile.Stat()
+	if err != nil {
+		return
+	}
+	contents, err := ioutil.ReadAll(backend.Manager.ProgressReader(localFile, localInfo.Size()))
+	if err != nil {
+		return
+	}
+	memoryBackendLock.Lock()
+	defer memoryBackendLock.Unlock()
+	memoryBackendFiles[boxUri] = contents
+	return
+}
+
+func (backend *CaryatidMemoryBackend) OpenFile(uri string) (reader io.ReadCloser, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse '%v' as URI: %v", uri, err)
+	}
+	if u.Scheme != backend.Scheme() {
+		return nil, fmt.Errorf("Expected scheme '%v' but was given a URI with scheme '%v'", backend.Scheme(), u.Scheme)
+	}
+
+	memoryBackendLock.RLock()
+	defer memoryBackendLock.RUnlock()
+	contents, ok := memoryBackendFiles[uri]
+	if !ok {
+		return nil, &boxNotFoundError{uri, &os.PathError{Op: "open", Path: uri, Err: os.ErrNotExist}}
+	}
+	// Stored files are never modified in place, so there is no need to copy contents here
+	return memoryFileReader{bytes.NewReader(contents)}, nil
+}
+
+// memoryFileReader can seek, like the *os.File that the LocalFile backend returns from OpenFile()
+type memoryFileReader struct {
+	*bytes.Reader
+}
+
+func (reader memoryFileReader) Close() error {
+	return nil
+}
+
+func (backend *CaryatidMemoryBackend) DeleteFile(uri string) (err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("Could not parse '%v' as URI: %v", uri, err)
+	}
+	if u.Scheme != backend.Scheme() {
+		return fmt.Errorf("Expected scheme '%v' but was given a URI with scheme '%v'", backend.Scheme(), u.Scheme)
+	}
+
+	memoryBackendLock.Lock()
+	defer memoryBackendLock.Unlock()
+	if _, ok := memoryBackendFiles[uri]; !ok {
+		return &boxNotFoundError{uri, &os.PathError{Op: "remove", Path: uri, Err: os.ErrNotExist}}
+	}
+	delete(memoryBackendFiles, uri)
+	return
+}
+
+func (backend *CaryatidMemoryBackend) ListBoxFiles(boxName string) (uris []string, err error) {
+	boxDirUri, err := BoxDirUriFromCatalogUri(backend.Manager.CatalogUri, boxName)
+	if err != nil {
+		return
+	}
+
+	memoryBackendLock.RLock()
+	defer memoryBackendLock.RUnlock()
+	for uri := range memoryBackendFiles {
+		if strings.HasPrefix(uri, boxDirUri+"/") && strings.HasSuffix(uri, ".box") {
+			uris = append(uris, uri)
+		}
+	}
+	sort.Strings(uris)
+	return
+}
+
+func (backend *CaryatidMemoryBackend) ListFiles(dirUri string) (uris []string, err error) {
+	prefix := strings.TrimSuffix(dirUri, "/") + "/"
+
+	memoryBackendLock.RLock()
+	defer memoryBackendLock.RUnlock()
+	for uri := range memoryBackendFiles {
+		if strings.HasPrefix(uri, prefix) {
+			uris = append(uris, uri)
+		}
+	}
+	sort.Strings(uris)
+	return
+}
+
+func (backend *CaryatidMemoryBackend) BackupCatalog(suffix string) (backupUri string, err error) {
+	memoryBackendLock.Lock()
+	defer memoryBackendLock.Unlock()
+	catalogBytes, ok := memoryBackendFiles[backend.Manager.CatalogUri]
+	if !ok {
+		return
+	}
+	backupUri = backend.Manager.CatalogUri + suffix
+	memoryBackendFiles[backupUri] = catalogBytes
+	return
+}
+
+func (backend *CaryatidMemoryBackend) ListCatalogBackups() (uris []string, err error) {
+	memoryBackendLock.RLock()
+	defer memoryBackendLock.RUnlock()
+	for uri := range memoryBackendFiles {
+		if strings.HasPrefix(uri, backend.Manager.CatalogUri+CatalogBackupSuffix) {
+			uris = append(uris, uri)
+		}
+	}
+	sort.Strings(uris)
+	return
+}
+
+func (backend *CaryatidMemoryBackend) Scheme() string {
+	return "mem"
+}
+
+// FileExists tests whether a catalog or box file exists at a URI
+// It is the memory backend equivalent of util.PathExists()
+func (backend *CaryatidMemoryBackend) FileExists(uri string) bool {
+	memoryBackendLock.RLock()
+	defer memoryBackendLock.RUnlock()
+	_, ok := memoryBackendFiles[uri]
+	return ok
+}
+
+// FileUris returns a sorted list of the URIs of every catalog and box file in the memory backend
+func (backend *CaryatidMemoryBackend) FileUris() (uris []string) {
+	memoryBackendLock.RLock()
+	defer memoryBackendLock.RUnlock()
+	for uri := range memoryBackendFiles {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return
+}
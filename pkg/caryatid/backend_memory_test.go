@@ -0,0 +1,74 @@
+package caryatid
+
+import (
+	"fmt"
+	"path"
+	"testing"
+)
+
+func TestCaryatidMemoryBackend_ImplementsCaryatidBackend(t *testing.T) {
+	var _ CaryatidBackend = new(CaryatidMemoryBackend)
+}
+
+func TestCaryatidMemoryBackend(t *testing.T) {
+	var (
+		boxName     = "TestMemoryBackendBox"
+		boxDesc     = "TestMemoryBackendBox is a test box"
+		boxProvider = "StrongSapling"
+		boxPath     = path.Join(integrationTestDir, "incoming-TestMemoryBackendBox.box")
+		catalogUri  = fmt.Sprintf("mem://TestCaryatidMemoryBackend/%v.json", boxName)
+		digestType  = "TestMemoryBackendDigestType"
+		digest      = "0xB00B1E5"
+	)
+
+	ResetMemoryBackend()
+	if err := CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	memBackend := backend.(*CaryatidMemoryBackend)
+
+	for _, version := range []string{"1.0.0", "1.0.1", "2.0.0"} {
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: boxDesc, Version: version, Provider: boxProvider, ChecksumType: digestType, Checksum: digest}); err != nil {
+			t.Fatalf("Error adding box to catalog: %v\n", err)
+		}
+	}
+
+	boxUri := func(version string) string {
+		uri, _ := BoxUriFromCatalogUri(catalogUri, boxName, version, boxProvider)
+		return uri
+	}
+	if !memBackend.FileExists(catalogUri) {
+		t.Fatalf("Expected catalog to exist at '%v'; files were: %v\n", catalogUri, memBackend.FileUris())
+	}
+	for _, version := range []string{"1.0.0", "1.0.1", "2.0.0"} {
+		if !memBackend.FileExists(boxUri(version)) {
+			t.Fatalf("Expected box to exist at '%v'; files were: %v\n", boxUri(version), memBackend.FileUris())
+		}
+	}
+
+	// A second manager for the same URI should see the same catalog
+	backend2, _ := NewBackendFromUri(catalogUri)
+	manager2 := NewBackendManager(catalogUri, &backend2)
+	catalog, err := manager2.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog from second manager: %v\n", err)
+	}
+	if len(catalog.Versions) != 3 {
+		t.Fatalf("Expected 3 versions in catalog, but found:\n%v\n", catalog.DisplayString())
+	}
+
+	if _, err = manager.DeleteBox(CatalogQueryParams{Version: "<2"}); err != nil {
+		t.Fatalf("Error deleting boxes: %v\n", err)
+	}
+	for version, exists := range map[string]bool{"1.0.0": false, "1.0.1": false, "2.0.0": true} {
+		if memBackend.FileExists(boxUri(version)) != exists {
+			t.Fatalf("Expected box at '%v' to exist: %v; files were: %v\n", boxUri(version), exists, memBackend.FileUris())
+		}
+	}
+}
@@ -0,0 +1,148 @@
+/*
+A backend decorator that refuses to change anything
+*/
+
+package caryatid
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ReadOnlyBackend wraps another backend, refusing SetCatalogBytes(), CopyBoxFile(), and DeleteFile() with an error wrapping ErrBackendReadOnly
+// It implements the optional interfaces like CaryatidBackupBackend too, refusing the methods that change anything the same way,
+// and returning an error wrapping ErrNotSupported from the others if the backend it wraps does not implement them
+// It is a guarantee that nothing in the backend is changed, for tools pointed at a catalog that must not be modified by accident
+type ReadOnlyBackend struct {
+	Backend CaryatidBackend
+}
+
+// NewReadOnlyBackend wraps a backend so that it cannot be written to
+func NewReadOnlyBackend(backend CaryatidBackend) *ReadOnlyBackend {
+	return &ReadOnlyBackend{Backend: backend}
+}
+
+// readOnlyError returns an error wrapping ErrBackendReadOnly for an operation that the backend refused
+func readOnlyError(opName string, uri string) error {
+	return fmt.Errorf("%w: refusing to %v '%v'", ErrBackendReadOnly, opName, uri)
+}
+
+// SetManager also sets the manager's ReadOnly property,
+// so that it refuses to change the catalog before it tries, including through the backend's optional interfaces like CaryatidLockingBackend
+func (backend *ReadOnlyBackend) SetManager(manager *BackendManager) error {
+	manager.ReadOnly = true
+	return backend.Backend.SetManager(manager)
+}
+
+func (backend *ReadOnlyBackend) GetManager() (*BackendManager, error) {
+	return backend.Backend.GetManager()
+}
+
+// catalogUri returns the URI of the manager's catalog, for errors, or an empty string if there is no manager yet
+func (backend *ReadOnlyBackend) catalogUri() string {
+	if manager, _ := backend.GetManager(); manager != nil {
+		return manager.CatalogUri
+	}
+	return ""
+}
+
+func (backend *ReadOnlyBackend) GetCatalogBytes() ([]byte, error) {
+	return backend.Backend.GetCatalogBytes()
+}
+
+func (backend *ReadOnlyBackend) SetCatalogBytes(serializedCatalog []byte) error {
+	return readOnlyError("save the catalog", backend.catalogUri())
+}
+
+func (backend *ReadOnlyBackend) CopyBoxFile(localPath string, boxUri string) error {
+	return readOnlyError("copy a box file to", boxUri)
+}
+
+func (backend *ReadOnlyBackend) OpenFile(uri string) (io.ReadCloser, error) {
+	return backend.Backend.OpenFile(uri)
+}
+
+func (backend *ReadOnlyBackend) MoveFile(fromUri string, toUri string) error {
+	return readOnlyError("move", fromUri)
+}
+
+func (backend *ReadOnlyBackend) DeleteFile(uri string) error {
+	return readOnlyError("delete", uri)
+}
+
+func (backend *ReadOnlyBackend) ListBoxFiles(boxName string) ([]string, error) {
+	return backend.Backend.ListBoxFiles(boxName)
+}
+
+func (backend *ReadOnlyBackend) LinkFile(targetUri string, linkUri string) error {
+	return readOnlyError("link", linkUri)
+}
+
+func (backend *ReadOnlyBackend) SignedUrl(uri string, ttl time.Duration) (string, error) {
+	signer, ok := backend.Backend.(CaryatidSigningBackend)
+	if !ok {
+		return "", notSupportedError("SignedUrl()")
+	}
+	return signer.SignedUrl(uri, ttl)
+}
+
+func (backend *ReadOnlyBackend) ListFiles(dirUri string) ([]string, error) {
+	lister, ok := backend.Backend.(CaryatidListingBackend)
+	if !ok {
+		return nil, notSupportedError("ListFiles()")
+	}
+	return lister.ListFiles(dirUri)
+}
+
+func (backend *ReadOnlyBackend) FileFingerprint(uri string) (string, error) {
+	fingerprinter, ok := backend.Backend.(CaryatidFingerprintBackend)
+	if !ok {
+		return "", notSupportedError("FileFingerprint()")
+	}
+	return fingerprinter.FileFingerprint(uri)
+}
+
+func (backend *ReadOnlyBackend) FileMD5(uri string) (string, bool, error) {
+	reporter, ok := backend.Backend.(CaryatidContentMD5Backend)
+	if !ok {
+		return "", false, notSupportedError("FileMD5()")
+	}
+	return reporter.FileMD5(uri)
+}
+
+func (backend *ReadOnlyBackend) LockCatalog() (func() error, error) {
+	return nil, readOnlyError("lock the catalog", backend.catalogUri())
+}
+
+func (backend *ReadOnlyBackend) BackupCatalog(suffix string) (string, error) {
+	return "", readOnlyError("back up the catalog", backend.catalogUri())
+}
+
+func (backend *ReadOnlyBackend) ListCatalogBackups() ([]string, error) {
+	backuper, ok := backend.Backend.(CaryatidBackupBackend)
+	if !ok {
+		return nil, notSupportedError("ListCatalogBackups()")
+	}
+	return backuper.ListCatalogBackups()
+}
+
+func (backend *ReadOnlyBackend) FileSize(uri string) (int64, error) {
+	ranger, ok := backend.Backend.(CaryatidRangeBackend)
+	if !ok {
+		return 0, notSupportedError("FileSize()")
+	}
+	return ranger.FileSize(uri)
+}
+
+func (backend *ReadOnlyBackend) OpenFileRange(uri string, offset int64) (io.ReadCloser, error) {
+	ranger, ok := backend.Backend.(CaryatidRangeBackend)
+	if !ok {
+		return nil, notSupportedError("OpenFileRange()")
+	}
+	return ranger.OpenFileRange(uri, offset)
+}
+
+func (backend *ReadOnlyBackend) Scheme() string {
+	return backend.Backend.Scheme()
+}
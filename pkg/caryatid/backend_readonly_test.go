@@ -0,0 +1,91 @@
+package caryatid
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"testing"
+)
+
+func TestReadOnlyBackend_ImplementsCaryatidBackend(t *testing.T) {
+	var _ CaryatidBackend = new(ReadOnlyBackend)
+}
+
+func TestReadOnlyBackend(t *testing.T) {
+	var (
+		boxName    = "TestReadOnlyBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestReadOnlyBox.box")
+		catalogUri = fmt.Sprintf("mem://TestReadOnlyBackend/%v.json", boxName)
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	writer := NewBackendManager(catalogUri, &backend)
+	if err = writer.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "1.0.0", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+	before := snapshotMemoryBackend()
+
+	var readOnlyBackend CaryatidBackend = NewReadOnlyBackend(backend)
+	manager := NewBackendManager(catalogUri, &readOnlyBackend)
+	if !manager.ReadOnly {
+		t.Fatalf("Expected a ReadOnlyBackend to make its manager read-only\n")
+	}
+
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "2.0.0", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); !errors.Is(err, ErrBackendReadOnly) {
+		t.Fatalf("Expected AddBox() to fail with ErrBackendReadOnly, but got: %v\n", err)
+	}
+	if _, err = manager.DeleteBox(CatalogQueryParams{Version: "1.0.0"}); !errors.Is(err, ErrBackendReadOnly) {
+		t.Fatalf("Expected DeleteBox() to fail with ErrBackendReadOnly, but got: %v\n", err)
+	}
+	if err = manager.SaveCatalog(Catalog{Name: boxName}); !errors.Is(err, ErrBackendReadOnly) {
+		t.Fatalf("Expected SaveCatalog() to fail with ErrBackendReadOnly, but got: %v\n", err)
+	}
+	boxUri, _ := BoxUriFromCatalogUri(catalogUri, boxName, "1.0.0", "StrongSapling")
+	if err = readOnlyBackend.DeleteFile(boxUri); !errors.Is(err, ErrBackendReadOnly) {
+		t.Fatalf("Expected DeleteFile() to fail with ErrBackendReadOnly, but got: %v\n", err)
+	}
+	if err = readOnlyBackend.CopyBoxFile(boxPath, boxUri); !errors.Is(err, ErrBackendReadOnly) {
+		t.Fatalf("Expected CopyBoxFile() to fail with ErrBackendReadOnly, but got: %v\n", err)
+	}
+	if err = readOnlyBackend.(CaryatidMoveBackend).MoveFile(boxUri, boxUri+".moved"); !errors.Is(err, ErrBackendReadOnly) {
+		t.Fatalf("Expected MoveFile() to fail with ErrBackendReadOnly, but got: %v\n", err)
+	}
+	if err = readOnlyBackend.(CaryatidLinkingBackend).LinkFile(boxUri, boxUri+".linked"); !errors.Is(err, ErrBackendReadOnly) {
+		t.Fatalf("Expected LinkFile() to fail with ErrBackendReadOnly, but got: %v\n", err)
+	}
+	if _, err = readOnlyBackend.(CaryatidBackupBackend).BackupCatalog(".bak.test"); !errors.Is(err, ErrBackendReadOnly) {
+		t.Fatalf("Expected BackupCatalog() to fail with ErrBackendReadOnly, but got: %v\n", err)
+	}
+	if _, err = readOnlyBackend.(CaryatidLockingBackend).LockCatalog(); !errors.Is(err, ErrBackendReadOnly) {
+		t.Fatalf("Expected LockCatalog() to fail with ErrBackendReadOnly, but got: %v\n", err)
+	}
+
+	// Reading, and dry runs, still work
+	catalog, err := manager.GetCatalog()
+	if err != nil || len(catalog.Versions) != 1 {
+		t.Fatalf("Expected GetCatalog() to return the catalog, but got %v and error %v\n", catalog, err)
+	}
+	if _, err = manager.catalogBackups(); err != nil {
+		t.Fatalf("Expected the backups of the catalog to be listed through the read-only backend, but got: %v\n", err)
+	}
+	manager.DryRun = true
+	if deleted, err := manager.DeleteBox(CatalogQueryParams{Version: "1.0.0"}); err != nil || len(deleted) != 1 {
+		t.Fatalf("Expected a dry run of DeleteBox() to work, but got %v and error %v\n", deleted, err)
+	}
+
+	after := snapshotMemoryBackend()
+	if len(before) != len(after) {
+		t.Fatalf("Expected the backend to be unchanged, but it had %v files before and %v after\n", len(before), len(after))
+	}
+	for uri, data := range before {
+		if after[uri] != data {
+			t.Fatalf("Expected the backend to be unchanged, but '%v' changed\n", uri)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+A registry of backends, keyed by URI scheme
+
+Built-in backends register themselves from an init() function in their own file.
+Backends that live outside this package can do the same from their own package,
+so long as that package is imported (perhaps with a blank import) by the program.
+*/
+
+package caryatid
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// BackendFactory returns a new, unconfigured backend for a URI
+// The backend is not ready to use until it is passed to NewBackendManager()
+type BackendFactory func(uri string) (CaryatidBackend, error)
+
+var (
+	backendRegistryLock sync.RWMutex
+	backendRegistry     = make(map[string]BackendFactory)
+)
+
+// RegisterBackend makes a backend available for URIs with the given scheme
+// Registering a scheme a second time replaces the previous factory
+func RegisterBackend(scheme string, factory BackendFactory) {
+	if scheme == "" {
+		panic("RegisterBackend(): scheme must not be empty")
+	}
+	if factory == nil {
+		panic(fmt.Sprintf("RegisterBackend(): nil factory for scheme '%v'", scheme))
+	}
+	backendRegistryLock.Lock()
+	defer backendRegistryLock.Unlock()
+	backendRegistry[scheme] = factory
+}
+
+// RegisteredBackendSchemes returns a sorted list of all registered schemes
+func RegisteredBackendSchemes() (schemes []string) {
+	backendRegistryLock.RLock()
+	defer backendRegistryLock.RUnlock()
+	for scheme := range backendRegistry {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return
+}
+
+func lookupBackendFactory(scheme string) (factory BackendFactory, err error) {
+	backendRegistryLock.RLock()
+	factory, ok := backendRegistry[scheme]
+	backendRegistryLock.RUnlock()
+	if !ok {
+		err = fmt.Errorf("No backend registered for scheme '%v'; registered schemes are: %v", scheme, RegisteredBackendSchemes())
+	}
+	return
+}
+
+// NewBackend returns a new backend for a scheme name like "file" or "s3"
+func NewBackend(name string) (backend CaryatidBackend, err error) {
+	factory, err := lookupBackendFactory(name)
+	if err != nil {
+		return
+	}
+	backend, err = factory("")
+	return
+}
+
+// NewBackendFromUri returns a new backend appropriate for the scheme of the URI
+func NewBackendFromUri(uri string) (backend CaryatidBackend, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		err = fmt.Errorf("Error trying to parse URI '%v': %v\n", uri, err)
+		return
+	}
+	factory, err := lookupBackendFactory(u.Scheme)
+	if err != nil {
+		return
+	}
+	LogDebugf("Using the '%v' backend for URI '%v'\n", u.Scheme, uri)
+	backend, err = factory(uri)
+	return
+}
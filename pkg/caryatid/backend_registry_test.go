@@ -0,0 +1,54 @@
+package caryatid
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegisterBackend(t *testing.T) {
+	var factoryUri string
+	RegisterBackend("caryatidtest", func(uri string) (CaryatidBackend, error) {
+		factoryUri = uri
+		return &CaryatidTestBackend{}, nil
+	})
+
+	uri := "caryatidtest://example/catalog.json"
+	backend, err := NewBackendFromUri(uri)
+	if err != nil {
+		t.Fatalf("NewBackendFromUri('%v') returned an unexpected error: %v\n", uri, err)
+	}
+	if _, ok := backend.(*CaryatidTestBackend); !ok {
+		t.Fatalf("NewBackendFromUri('%v') returned a backend of the wrong type: %T\n", uri, backend)
+	}
+	if factoryUri != uri {
+		t.Fatalf("Backend factory was passed URI '%v', but expected '%v'\n", factoryUri, uri)
+	}
+
+	found := false
+	for _, scheme := range RegisteredBackendSchemes() {
+		if scheme == "caryatidtest" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Scheme 'caryatidtest' missing from RegisteredBackendSchemes(): %v\n", RegisteredBackendSchemes())
+	}
+}
+
+func TestBuiltinBackendsRegistered(t *testing.T) {
+	for _, scheme := range []string{"file", "s3"} {
+		if _, err := NewBackend(scheme); err != nil {
+			t.Fatalf("Built in backend '%v' is not registered: %v\n", scheme, err)
+		}
+	}
+}
+
+func TestNewBackendFromUriUnknownScheme(t *testing.T) {
+	_, err := NewBackendFromUri("nonexistent://example/catalog.json")
+	if err == nil {
+		t.Fatalf("NewBackendFromUri() with an unregistered scheme should have failed\n")
+	}
+	if !strings.Contains(err.Error(), "No backend registered for scheme 'nonexistent'") {
+		t.Fatalf("NewBackendFromUri() with an unregistered scheme returned an unhelpful error: %v\n", err)
+	}
+}
@@ -0,0 +1,307 @@
+/*
+A backend decorator that retries operations that fail with transient errors
+*/
+
+package caryatid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// PermanentError wraps an error that retrying will not fix, such as an authentication failure,
+// or one from an operation that the backend already retried itself
+// RetryBackend will return these immediately rather than retrying
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// NewPermanentError wraps err in a PermanentError, unless err is nil
+func NewPermanentError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{err}
+}
+
+// IsRetryableError returns false for errors that retrying will not fix, even if they are wrapped in other errors
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var permanentErr *PermanentError
+	if errors.As(err, &permanentErr) {
+		return false
+	}
+	if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	if errors.Is(err, ErrCatalogNotFound) || errors.Is(err, ErrBoxNotFound) || errors.Is(err, ErrBackendReadOnly) || errors.Is(err, ErrMoveNotSupported) || errors.Is(err, ErrNotSupported) {
+		return false
+	}
+	// The BackendManager waits for a locked catalog itself; see BackendManager.LockTimeout
+	if errors.Is(err, ErrCatalogLocked) {
+		return false
+	}
+	// A cancelled operation is rolling back; see BackendManager.Context
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	return true
+}
+
+// RetryBackend wraps another backend,
+// retrying GetCatalogBytes(), SetCatalogBytes(), CopyBoxFile(), MoveFile(), and OpenFile() with exponential backoff
+// It implements the optional interfaces like CaryatidBackupBackend too, retrying them the same way,
+// and returning an error wrapping ErrNotSupported if the backend it wraps does not implement them
+type RetryBackend struct {
+	Backend CaryatidBackend
+
+	// The maximum number of times to try an operation, including the first try
+	MaxAttempts int
+
+	// The delay after the first failure; it doubles after each subsequent failure, up to MaxDelay
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// NewRetryBackend wraps a backend so that each operation is tried at most maxAttempts times
+func NewRetryBackend(backend CaryatidBackend, maxAttempts int) *RetryBackend {
+	return &RetryBackend{
+		Backend:      backend,
+		MaxAttempts:  maxAttempts,
+		InitialDelay: 1 * time.Second,
+		MaxDelay:     30 * time.Second,
+	}
+}
+
+func (backend *RetryBackend) retry(opName string, operation func() error) (err error) {
+	delay := backend.InitialDelay
+	for attempt := 1; ; attempt++ {
+		if err = operation(); err == nil {
+			return
+		}
+		if errors.Is(err, ErrCatalogNotFound) || errors.Is(err, ErrCatalogLocked) {
+			return
+		} else if !IsRetryableError(err) {
+			backendLogger(backend).Errorf("RetryBackend: %v failed with a non-retryable error: %v\n", opName, err)
+			return
+		}
+		if attempt >= backend.MaxAttempts {
+			err = fmt.Errorf("%v failed after %v attempts: %w", opName, attempt, err)
+			return
+		}
+		backendLogger(backend).Debugf("RetryBackend: %v failed on attempt %v of %v, retrying in %v: %v\n", opName, attempt, backend.MaxAttempts, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+		if backend.MaxDelay > 0 && delay > backend.MaxDelay {
+			delay = backend.MaxDelay
+		}
+	}
+}
+
+func (backend *RetryBackend) SetManager(manager *BackendManager) error {
+	return backend.Backend.SetManager(manager)
+}
+
+func (backend *RetryBackend) GetManager() (*BackendManager, error) {
+	return backend.Backend.GetManager()
+}
+
+func (backend *RetryBackend) GetCatalogBytes() (catalogBytes []byte, err error) {
+	err = backend.retry("GetCatalogBytes()", func() (opErr error) {
+		catalogBytes, opErr = backend.Backend.GetCatalogBytes()
+		return
+	})
+	return
+}
+
+func (backend *RetryBackend) SetCatalogBytes(serializedCatalog []byte) error {
+	return backend.retry("SetCatalogBytes()", func() error {
+		return backend.Backend.SetCatalogBytes(serializedCatalog)
+	})
+}
+
+// CopyBoxFile retries the copy, unless the wrapped backend already retried it;
+// backends that retry uploads themselves, like the S3 backend, return a PermanentError once they give up
+func (backend *RetryBackend) CopyBoxFile(localPath string, boxUri string) error {
+	return backend.retry("CopyBoxFile()", func() error {
+		return backend.Backend.CopyBoxFile(localPath, boxUri)
+	})
+}
+
+// OpenFile retries opening the file, but not reading from it once it has been opened
+func (backend *RetryBackend) OpenFile(uri string) (reader io.ReadCloser, err error) {
+	err = backend.retry("OpenFile()", func() (opErr error) {
+		reader, opErr = backend.Backend.OpenFile(uri)
+		return
+	})
+	return
+}
+
+// MoveFile returns ErrMoveNotSupported if the wrapped backend does not implement CaryatidMoveBackend
+func (backend *RetryBackend) MoveFile(fromUri string, toUri string) error {
+	mover, ok := backend.Backend.(CaryatidMoveBackend)
+	if !ok {
+		return ErrMoveNotSupported
+	}
+	return backend.retry("MoveFile()", func() error {
+		return mover.MoveFile(fromUri, toUri)
+	})
+}
+
+func (backend *RetryBackend) DeleteFile(uri string) error {
+	return backend.Backend.DeleteFile(uri)
+}
+
+func (backend *RetryBackend) ListBoxFiles(boxName string) (uris []string, err error) {
+	err = backend.retry("ListBoxFiles()", func() (opErr error) {
+		uris, opErr = backend.Backend.ListBoxFiles(boxName)
+		return
+	})
+	return
+}
+
+func (backend *RetryBackend) LinkFile(targetUri string, linkUri string) error {
+	linker, ok := backend.Backend.(CaryatidLinkingBackend)
+	if !ok {
+		return notSupportedError("LinkFile()")
+	}
+	return backend.retry("LinkFile()", func() error {
+		return linker.LinkFile(targetUri, linkUri)
+	})
+}
+
+func (backend *RetryBackend) SignedUrl(uri string, ttl time.Duration) (signedUrl string, err error) {
+	signer, ok := backend.Backend.(CaryatidSigningBackend)
+	if !ok {
+		return "", notSupportedError("SignedUrl()")
+	}
+	err = backend.retry("SignedUrl()", func() (opErr error) {
+		signedUrl, opErr = signer.SignedUrl(uri, ttl)
+		return
+	})
+	return
+}
+
+func (backend *RetryBackend) ListFiles(dirUri string) (uris []string, err error) {
+	lister, ok := backend.Backend.(CaryatidListingBackend)
+	if !ok {
+		return nil, notSupportedError("ListFiles()")
+	}
+	err = backend.retry("ListFiles()", func() (opErr error) {
+		uris, opErr = lister.ListFiles(dirUri)
+		return
+	})
+	return
+}
+
+func (backend *RetryBackend) FileFingerprint(uri string) (fingerprint string, err error) {
+	fingerprinter, ok := backend.Backend.(CaryatidFingerprintBackend)
+	if !ok {
+		return "", notSupportedError("FileFingerprint()")
+	}
+	err = backend.retry("FileFingerprint()", func() (opErr error) {
+		fingerprint, opErr = fingerprinter.FileFingerprint(uri)
+		return
+	})
+	return
+}
+
+func (backend *RetryBackend) FileMD5(uri string) (md5sum string, ok bool, err error) {
+	reporter, implemented := backend.Backend.(CaryatidContentMD5Backend)
+	if !implemented {
+		return "", false, notSupportedError("FileMD5()")
+	}
+	err = backend.retry("FileMD5()", func() (opErr error) {
+		md5sum, ok, opErr = reporter.FileMD5(uri)
+		return
+	})
+	return
+}
+
+// LockCatalog retries taking the lock and releasing it, but not a lock that another process holds;
+// the BackendManager waits for that itself
+func (backend *RetryBackend) LockCatalog() (unlock func() error, err error) {
+	locker, ok := backend.Backend.(CaryatidLockingBackend)
+	if !ok {
+		return nil, notSupportedError("LockCatalog()")
+	}
+	var release func() error
+	err = backend.retry("LockCatalog()", func() (opErr error) {
+		release, opErr = locker.LockCatalog()
+		return
+	})
+	if err != nil {
+		return
+	}
+	unlock = func() error {
+		return backend.retry("LockCatalog() unlock", release)
+	}
+	return
+}
+
+func (backend *RetryBackend) BackupCatalog(suffix string) (backupUri string, err error) {
+	backuper, ok := backend.Backend.(CaryatidBackupBackend)
+	if !ok {
+		return "", notSupportedError("BackupCatalog()")
+	}
+	err = backend.retry("BackupCatalog()", func() (opErr error) {
+		backupUri, opErr = backuper.BackupCatalog(suffix)
+		return
+	})
+	return
+}
+
+func (backend *RetryBackend) ListCatalogBackups() (uris []string, err error) {
+	backuper, ok := backend.Backend.(CaryatidBackupBackend)
+	if !ok {
+		return nil, notSupportedError("ListCatalogBackups()")
+	}
+	err = backend.retry("ListCatalogBackups()", func() (opErr error) {
+		uris, opErr = backuper.ListCatalogBackups()
+		return
+	})
+	return
+}
+
+func (backend *RetryBackend) FileSize(uri string) (size int64, err error) {
+	ranger, ok := backend.Backend.(CaryatidRangeBackend)
+	if !ok {
+		return 0, notSupportedError("FileSize()")
+	}
+	err = backend.retry("FileSize()", func() (opErr error) {
+		size, opErr = ranger.FileSize(uri)
+		return
+	})
+	return
+}
+
+// OpenFileRange retries opening the file, but not reading from it once it has been opened
+func (backend *RetryBackend) OpenFileRange(uri string, offset int64) (reader io.ReadCloser, err error) {
+	ranger, ok := backend.Backend.(CaryatidRangeBackend)
+	if !ok {
+		return nil, notSupportedError("OpenFileRange()")
+	}
+	err = backend.retry("OpenFileRange()", func() (opErr error) {
+		reader, opErr = ranger.OpenFileRange(uri, offset)
+		return
+	})
+	return
+}
+
+func (backend *RetryBackend) Scheme() string {
+	return backend.Backend.Scheme()
+}
@@ -0,0 +1,181 @@
+package caryatid
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// flakyTestBackend fails the first Failures calls to each retried operation
+type flakyTestBackend struct {
+	CaryatidTestBackend
+	Failures  int
+	Permanent bool
+	Calls     map[string]int
+}
+
+func (backend *flakyTestBackend) fail(opName string) error {
+	if backend.Calls == nil {
+		backend.Calls = make(map[string]int)
+	}
+	backend.Calls[opName] += 1
+	if backend.Calls[opName] > backend.Failures {
+		return nil
+	}
+	err := fmt.Errorf("Simulated failure %v of %v", backend.Calls[opName], opName)
+	if backend.Permanent {
+		return NewPermanentError(err)
+	}
+	return err
+}
+
+func (backend *flakyTestBackend) GetCatalogBytes() ([]byte, error) {
+	if err := backend.fail("GetCatalogBytes"); err != nil {
+		return nil, err
+	}
+	return backend.CaryatidTestBackend.GetCatalogBytes()
+}
+
+func (backend *flakyTestBackend) SetCatalogBytes(serializedCatalog []byte) error {
+	if err := backend.fail("SetCatalogBytes"); err != nil {
+		return err
+	}
+	return backend.CaryatidTestBackend.SetCatalogBytes(serializedCatalog)
+}
+
+func (backend *flakyTestBackend) CopyBoxFile(path string, boxUri string) error {
+	if err := backend.fail("CopyBoxFile"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// flakyOptionalTestBackend is a flakyTestBackend that implements some of the optional interfaces, failing them the same way
+type flakyOptionalTestBackend struct {
+	flakyTestBackend
+}
+
+func (backend *flakyOptionalTestBackend) LockCatalog() (func() error, error) {
+	if err := backend.fail("LockCatalog"); err != nil {
+		return nil, err
+	}
+	return func() error { return backend.fail("unlock") }, nil
+}
+
+func (backend *flakyOptionalTestBackend) BackupCatalog(suffix string) (string, error) {
+	if err := backend.fail("BackupCatalog"); err != nil {
+		return "", err
+	}
+	return "Test://TestRetryBox.json" + suffix, nil
+}
+
+func (backend *flakyOptionalTestBackend) ListCatalogBackups() ([]string, error) {
+	if err := backend.fail("ListCatalogBackups"); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func TestRetryBackend_ImplementsCaryatidBackend(t *testing.T) {
+	var _ CaryatidBackend = new(RetryBackend)
+	var _ CaryatidMoveBackend = new(RetryBackend)
+	var _ CaryatidLinkingBackend = new(RetryBackend)
+	var _ CaryatidSigningBackend = new(RetryBackend)
+	var _ CaryatidListingBackend = new(RetryBackend)
+	var _ CaryatidFingerprintBackend = new(RetryBackend)
+	var _ CaryatidContentMD5Backend = new(RetryBackend)
+	var _ CaryatidLockingBackend = new(RetryBackend)
+	var _ CaryatidBackupBackend = new(RetryBackend)
+	var _ CaryatidRangeBackend = new(RetryBackend)
+}
+
+// The BackendManager calls the optional interfaces through the RetryBackend, so they are retried too
+func TestRetryBackendOptionalInterfaces(t *testing.T) {
+	flaky := &flakyOptionalTestBackend{flakyTestBackend{Failures: 2}}
+	retry := NewRetryBackend(flaky, 3)
+	retry.InitialDelay = time.Millisecond
+	var backend CaryatidBackend = retry
+	manager := NewBackendManager("Test://TestRetryBackendOptionalInterfaces/TestRetryBox.json", &backend)
+
+	if err := manager.AddBox(BoxArtifact{Path: "/tmp/path/to/example.box", Name: "TestRetryBox", Description: "TestRetryBox description", Version: "1.0.0", Provider: "ExampleProvider", ChecksumType: "sha1", Checksum: "0xDECAFBAD"}); err != nil {
+		t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+	}
+	for _, opName := range []string{"LockCatalog", "unlock", "BackupCatalog"} {
+		if flaky.Calls[opName] != 3 {
+			t.Fatalf("Expected %v to be tried 3 times, but it was tried %v times\n", opName, flaky.Calls[opName])
+		}
+	}
+
+	// A backend that does not implement an interface is not retried, and the manager does not call it
+	var plain CaryatidBackend = NewRetryBackend(&flakyTestBackend{}, 3)
+	if _, err := plain.(CaryatidBackupBackend).ListCatalogBackups(); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("Expected ListCatalogBackups() to return ErrNotSupported, but got %v\n", err)
+	}
+	plainManager := NewBackendManager("Test://TestRetryBackendOptionalInterfaces/TestRetryBox.json", &plain)
+	if _, ok := plainManager.backupBackend(); ok {
+		t.Fatalf("Expected the manager not to use the backup interface of a backend that does not implement it\n")
+	}
+}
+
+func TestRetryBackend(t *testing.T) {
+	type TestCase struct {
+		Failures      int
+		Permanent     bool
+		MaxAttempts   int
+		ExpectSuccess bool
+		ExpectedCalls int
+	}
+	testCases := []TestCase{
+		TestCase{Failures: 2, MaxAttempts: 3, ExpectSuccess: true, ExpectedCalls: 3},
+		TestCase{Failures: 2, MaxAttempts: 2, ExpectSuccess: false, ExpectedCalls: 2},
+		TestCase{Failures: 0, MaxAttempts: 1, ExpectSuccess: true, ExpectedCalls: 1},
+		TestCase{Failures: 2, Permanent: true, MaxAttempts: 5, ExpectSuccess: false, ExpectedCalls: 1},
+	}
+
+	for _, tc := range testCases {
+		flaky := &flakyTestBackend{Failures: tc.Failures, Permanent: tc.Permanent}
+		retry := NewRetryBackend(flaky, tc.MaxAttempts)
+		retry.InitialDelay = time.Millisecond
+		var backend CaryatidBackend = retry
+		manager := NewBackendManager("http://example.com/cata/RetryBox.json", &backend)
+
+		err := manager.AddBox(BoxArtifact{Path: "/tmp/path/to/example.box", Name: "RetryBox", Description: "RetryBox description", Version: "1.0.0", Provider: "ExampleProvider", ChecksumType: "sha1", Checksum: "0xDECAFBAD"})
+		if tc.ExpectSuccess && err != nil {
+			t.Fatalf("Test case %+v: expected AddBox() to succeed, but got error: %v\n", tc, err)
+		} else if !tc.ExpectSuccess && err == nil {
+			t.Fatalf("Test case %+v: expected AddBox() to fail, but it succeeded\n", tc)
+		}
+		if flaky.Calls["GetCatalogBytes"] != tc.ExpectedCalls {
+			t.Fatalf("Test case %+v: expected %v calls to GetCatalogBytes(), but there were %v\n", tc, tc.ExpectedCalls, flaky.Calls["GetCatalogBytes"])
+		}
+		if tc.ExpectSuccess && (flaky.Calls["SetCatalogBytes"] != tc.ExpectedCalls || flaky.Calls["CopyBoxFile"] != tc.ExpectedCalls) {
+			t.Fatalf("Test case %+v: unexpected number of calls: %v\n", tc, flaky.Calls)
+		}
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	type TestCase struct {
+		Err       error
+		Retryable bool
+	}
+	testCases := []TestCase{
+		TestCase{nil, false},
+		TestCase{fmt.Errorf("Simulated network failure"), true},
+		TestCase{NewPermanentError(fmt.Errorf("Simulated authentication failure")), false},
+		TestCase{fmt.Errorf("Wrapped: %w", NewPermanentError(fmt.Errorf("Simulated authentication failure"))), false},
+		TestCase{&os.PathError{Op: "open", Path: "/tmp/missing.box", Err: os.ErrNotExist}, false},
+		TestCase{fmt.Errorf("Wrapped: %w", &os.PathError{Op: "open", Path: "/tmp/denied.box", Err: os.ErrPermission}), false},
+		TestCase{catalogNotFoundError("file:///tmp/missing.json"), false},
+		TestCase{fmt.Errorf("Wrapped: %w", ErrMoveNotSupported), false},
+		TestCase{notSupportedError("LinkFile()"), false},
+		TestCase{fmt.Errorf("Wrapped: %w", ErrCatalogLocked), false},
+	}
+	for _, tc := range testCases {
+		if retryable := IsRetryableError(tc.Err); retryable != tc.Retryable {
+			t.Fatalf("IsRetryableError(%v) returned %v, but expected %v\n", tc.Err, retryable, tc.Retryable)
+		}
+	}
+}
@@ -7,26 +7,67 @@ package caryatid
 import (
 	"bytes"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
+// DefaultUploadPartSize is the size of the parts that box files are uploaded in when BackendManager.UploadPartSize is 0
+const DefaultUploadPartSize int64 = 64 * 1024 * 1024
+
+// MinUploadPartSize is the smallest part size that S3 accepts; only the last part of an upload may be smaller
+const MinUploadPartSize int64 = s3manager.MinUploadPartSize
+
+// s3UploadAttempts is how many times CopyBoxFile() tries to upload each part of a box file, including the first try
+const s3UploadAttempts = 3
+
+// s3UploadRetryDelay is how long CopyBoxFile() waits after the first failure to upload a part; it doubles after each subsequent failure
+var s3UploadRetryDelay = 1 * time.Second
+
+func init() {
+	RegisterBackend("s3", func(uri string) (CaryatidBackend, error) {
+		return &CaryatidS3Backend{}, nil
+	})
+}
+
 type CaryatidS3Backend struct {
 	AwsSession   *session.Session
-	S3Service    *s3.S3
+	S3Service    s3iface.S3API
 	S3Downloader *s3manager.Downloader
 	S3Uploader   *s3manager.Uploader
 	Manager      *BackendManager
 
 	CatalogLocation *caryatidS3Location
+
+	// The ETag of the catalog when it was last read or written, or empty if it did not exist; see checkCatalogUnchanged()
+	// The catalog itself is kept along with it, so that GetCatalogBytes() need not download it again if it has not changed
+	// A BackendManager may read the catalog while it saves it from another goroutine, so these are guarded by etagLock
+	catalogETag  string
+	catalogRead  bool
+	catalogBytes []byte
+	etagLock     sync.Mutex
+}
+
+// setCatalogETag records the ETag and contents of the catalog as it was just read or written
+func (backend *CaryatidS3Backend) setCatalogETag(etag string, catalogBytes []byte, read bool) {
+	backend.etagLock.Lock()
+	defer backend.etagLock.Unlock()
+	backend.catalogETag = etag
+	backend.catalogBytes = catalogBytes
+	backend.catalogRead = read
 }
 
 type caryatidS3Location struct {
@@ -46,7 +87,7 @@ func uri2s3location(uri string) (loc *caryatidS3Location, err error) {
 		return
 	}
 
-	log.Printf("result:\n%v\n", result)
+	LogDebugf("result:\n%v\n", result)
 
 	loc = new(caryatidS3Location)
 	loc.Bucket = result[0][1]
@@ -54,6 +95,25 @@ func uri2s3location(uri string) (loc *caryatidS3Location, err error) {
 	return
 }
 
+// s3PermanentError wraps errors that retrying will not fix, like authentication failures, in a PermanentError
+func s3PermanentError(err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", s3.ErrCodeNoSuchBucket, s3.ErrCodeNoSuchKey:
+			return NewPermanentError(err)
+		}
+	}
+	return err
+}
+
+// s3WriteError is like s3PermanentError, but also makes errors from writes that were denied match ErrBackendReadOnly
+func s3WriteError(err error) error {
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "AccessDenied" {
+		return NewPermanentError(&backendReadOnlyError{err})
+	}
+	return s3PermanentError(err)
+}
+
 func (backend *CaryatidS3Backend) verifyCredential() (err error) {
 	_, err = backend.S3Service.ListObjects(&s3.ListObjectsInput{
 		Bucket: aws.String(backend.CatalogLocation.Bucket),
@@ -96,98 +156,309 @@ func (backend *CaryatidS3Backend) SetCredential(backendCredential string) (err e
 	return
 }
 
+// GetCatalogBytes downloads the catalog
+// Once it has read or written the catalog, it sends the ETag it got back with If-None-Match,
+// and if S3 responds that the catalog has not changed, it returns the catalog it already has instead of downloading it again
 func (backend *CaryatidS3Backend) GetCatalogBytes() (catalogBytes []byte, err error) {
 	var (
+		out           *s3.GetObjectOutput
 		dlerr         error
 		catalogExists bool
 	)
 
+	backend.etagLock.Lock()
+	cachedETag, cachedBytes := backend.catalogETag, backend.catalogBytes
+	backend.etagLock.Unlock()
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(backend.CatalogLocation.Bucket),
+		Key:    aws.String(backend.CatalogLocation.Resource),
+	}
+	if cachedETag != "" && cachedBytes != nil {
+		input.IfNoneMatch = aws.String(cachedETag)
+	}
+
 	catalogExists = true
-	dlBuffer := &aws.WriteAtBuffer{}
-	_, dlerr = backend.S3Downloader.Download(
-		dlBuffer,
-		&s3.GetObjectInput{
-			Bucket: aws.String(backend.CatalogLocation.Bucket),
-			Key:    aws.String(backend.CatalogLocation.Resource),
-		},
-	)
+	out, dlerr = backend.S3Service.GetObject(input)
 
-	if aerr, ok := dlerr.(awserr.Error); ok {
+	if reqerr, ok := dlerr.(awserr.RequestFailure); ok && reqerr.StatusCode() == http.StatusNotModified {
+		backend.Manager.log().Debugf("CaryatidS3Backend.GetCatalogBytes(): Catalog is unchanged since it was last read, with ETag %v\n", cachedETag)
+		backend.setCatalogETag(cachedETag, cachedBytes, true)
+		catalogBytes = append([]byte{}, cachedBytes...)
+		return
+	} else if aerr, ok := dlerr.(awserr.Error); ok {
 		switch aerr.Code() {
 		case s3.ErrCodeNoSuchKey:
-			log.Printf("No file at '%v'; starting with empty catalog\n", backend.Manager.CatalogUri)
 			catalogExists = false
 		case s3.ErrCodeNoSuchBucket:
-			err = fmt.Errorf("Bucket '%v' does not exist\n", backend.CatalogLocation.Bucket)
+			err = NewPermanentError(fmt.Errorf("Bucket '%v' does not exist\n", backend.CatalogLocation.Bucket))
 		default:
-			err = dlerr
+			err = s3PermanentError(dlerr)
 		}
+	} else if dlerr != nil {
+		err = dlerr
 	}
 
 	if err != nil {
-		log.Printf("CaryatidS3Backend.GetCatalogBytes(): Could not download from S3: %v", err)
+		backend.Manager.log().Errorf("CaryatidS3Backend.GetCatalogBytes(): Could not download from S3: %v", err)
 		return
 	}
 
 	if catalogExists {
-		catalogBytes = dlBuffer.Bytes()
+		defer out.Body.Close()
+		if catalogBytes, err = ioutil.ReadAll(out.Body); err != nil {
+			backend.Manager.log().Errorf("CaryatidS3Backend.GetCatalogBytes(): Could not download from S3: %v", err)
+			return
+		}
+		backend.setCatalogETag(aws.StringValue(out.ETag), append([]byte{}, catalogBytes...), true)
+	} else {
+		backend.setCatalogETag("", nil, true)
+		err = catalogNotFoundError(backend.Manager.CatalogUri)
+	}
+
+	return
+}
+
+// checkCatalogUnchanged returns an error if another process has changed the catalog since this backend last read or wrote it
+// S3 cannot lock an object, so this is the best the backend can do, and it is not airtight:
+// another process can still change the catalog between this check and the upload that follows it
+func (backend *CaryatidS3Backend) checkCatalogUnchanged() (err error) {
+	backend.etagLock.Lock()
+	expectedETag, catalogRead := backend.catalogETag, backend.catalogRead
+	backend.etagLock.Unlock()
+	if !catalogRead {
+		return
+	}
+
+	currentETag := ""
+	head, err := backend.S3Service.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(backend.CatalogLocation.Bucket),
+		Key:    aws.String(backend.CatalogLocation.Resource),
+	})
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+		err = nil
+	} else if err != nil {
+		err = s3PermanentError(err)
+		return
 	} else {
-		catalogBytes = []byte("{}")
+		currentETag = aws.StringValue(head.ETag)
 	}
 
+	if currentETag != expectedETag {
+		err = NewPermanentError(fmt.Errorf(
+			"The catalog at '%v' was changed by another process after it was read; not overwriting it",
+			backend.Manager.CatalogUri))
+	}
 	return
 }
 
+// SetCatalogBytes uploads the catalog, unless another process has changed it since it was read; see checkCatalogUnchanged()
+// S3 only makes a new object visible once the whole upload has succeeded, so a failed or interrupted upload
+// leaves the previous catalog in place; there is no need to upload to a temporary key and copy it over the catalog
 func (backend *CaryatidS3Backend) SetCatalogBytes(serializedCatalog []byte) (err error) {
+	if err = backend.checkCatalogUnchanged(); err != nil {
+		backend.Manager.log().Errorf("CaryatidS3Backend.SetCatalogBytes(): %v\n", err)
+		return
+	}
+
 	upParams := &s3manager.UploadInput{
 		Bucket: aws.String(backend.CatalogLocation.Bucket),
 		Key:    aws.String(backend.CatalogLocation.Resource),
 		Body:   bytes.NewReader(serializedCatalog),
 	}
 
-	_, err = backend.S3Uploader.Upload(upParams)
+	out, err := backend.S3Uploader.Upload(upParams)
 	if err != nil {
-		log.Println("CaryatidS3Backend.SetCatalogBytes(): Error trying to upload catalog: ", err)
+		backend.Manager.log().Errorf("CaryatidS3Backend.SetCatalogBytes(): Error trying to upload catalog: %v\n", err)
+		err = s3WriteError(err)
 		return
 	}
+	// If the upload does not report an ETag, the next write cannot be checked
+	backend.setCatalogETag(aws.StringValue(out.ETag), append([]byte{}, serializedCatalog...), out.ETag != nil)
 	return
 }
 
-func (backend *CaryatidS3Backend) CopyBoxFile(path string, boxName string, boxVersion string, boxProvider string) (err error) {
+func (backend *CaryatidS3Backend) CopyBoxFile(path string, boxUri string) (err error) {
 	var (
-		boxFileLoc  caryatidS3Location
+		boxFileLoc  *caryatidS3Location
 		fileHandler *os.File
 	)
 
-	boxFileLoc.Bucket = backend.CatalogLocation.Bucket
-
-	// TODO: Do we need the if statement? Can we just use the second version and be OK if LastIndex() returns -1 ?
-	lastSlashIdx := strings.LastIndex(backend.CatalogLocation.Resource, "/")
-	if lastSlashIdx < 0 {
-		boxFileLoc.Resource = fmt.Sprintf(
-			"%v/%v_%v_%v.box",
-			boxName, boxName, boxVersion, boxProvider)
-	} else {
-		boxFileLoc.Resource = fmt.Sprintf(
-			"%v/%v/%v_%v_%v.box",
-			backend.CatalogLocation.Resource[0:lastSlashIdx],
-			boxName, boxName, boxVersion, boxProvider)
+	if boxFileLoc, err = uri2s3location(boxUri); err != nil {
+		return
 	}
 
 	if fileHandler, err = os.Open(path); err != nil {
 		return
 	}
 	defer fileHandler.Close()
+	fileInfo, err := fileHandler.Stat()
+	if err != nil {
+		return
+	}
+
+	partSize := backend.Manager.UploadPartSize
+	if partSize <= 0 {
+		partSize = DefaultUploadPartSize
+	}
+	if fileInfo.Size() > partSize*s3manager.MaxUploadParts {
+		partSize = fileInfo.Size()/s3manager.MaxUploadParts + 1
+		backend.Manager.log().Debugf("Using parts of %v bytes, so that '%v' fits in %v parts\n", partSize, path, s3manager.MaxUploadParts)
+	}
+
+	reader := backend.Manager.ProgressReader(fileHandler, fileInfo.Size())
+	if fileInfo.Size() <= partSize {
+		backend.Manager.log().Debugf("Uploading '%v' to S3 object '%v' in bucket '%v'\n", path, boxFileLoc.Resource, boxFileLoc.Bucket)
+		var body []byte
+		if body, err = ioutil.ReadAll(reader); err != nil {
+			return
+		}
+		err = backend.retryUpload(fmt.Sprintf("upload of '%v'", boxUri), func() (uploadErr error) {
+			_, uploadErr = backend.S3Service.PutObject(&s3.PutObjectInput{
+				Bucket: aws.String(boxFileLoc.Bucket),
+				Key:    aws.String(boxFileLoc.Resource),
+				Body:   bytes.NewReader(body),
+			})
+			return
+		})
+		return
+	}
+
+	backend.Manager.log().Debugf("Uploading '%v' to S3 object '%v' in bucket '%v' in parts of %v bytes\n", path, boxFileLoc.Resource, boxFileLoc.Bucket, partSize)
+	err = backend.uploadMultipart(boxFileLoc, reader, partSize)
+	return
+}
 
-	_, err = backend.S3Uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(boxFileLoc.Bucket),
-		Key:    aws.String(boxFileLoc.Resource),
-		Body:   fileHandler,
+// uploadMultipart uploads everything read from reader to loc with a multipart upload, in parts of partSize bytes
+// Each part is retried on its own, so a failure late in a large upload does not start it over;
+// if a part still fails, the upload is aborted, so that S3 does not keep the parts that were uploaded
+func (backend *CaryatidS3Backend) uploadMultipart(loc *caryatidS3Location, reader io.Reader, partSize int64) (err error) {
+	created, err := backend.S3Service.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(loc.Bucket),
+		Key:    aws.String(loc.Resource),
 	})
 	if err != nil {
+		err = s3WriteError(err)
 		return
 	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		backend.Manager.log().Warnf("Aborting multipart upload of S3 object '%v' in bucket '%v': %v\n", loc.Resource, loc.Bucket, err)
+		_, abortErr := backend.S3Service.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(loc.Bucket),
+			Key:      aws.String(loc.Resource),
+			UploadId: created.UploadId,
+		})
+		if abortErr != nil {
+			backend.Manager.log().Errorf("Could not abort multipart upload '%v'; its parts may be kept until a bucket lifecycle rule removes them: %v\n", aws.StringValue(created.UploadId), abortErr)
+		}
+	}()
+
+	parts := []*s3.CompletedPart{}
+	buffer := make([]byte, partSize)
+	for partNumber := int64(1); ; partNumber++ {
+		length, readErr := io.ReadFull(reader, buffer)
+		if readErr == io.EOF && partNumber > 1 {
+			break
+		} else if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			err = readErr
+			return
+		}
+
+		var etag *string
+		err = backend.retryUpload(fmt.Sprintf("upload of part %v of S3 object '%v'", partNumber, loc.Resource), func() (uploadErr error) {
+			output, uploadErr := backend.S3Service.UploadPart(&s3.UploadPartInput{
+				Bucket:     aws.String(loc.Bucket),
+				Key:        aws.String(loc.Resource),
+				UploadId:   created.UploadId,
+				PartNumber: aws.Int64(partNumber),
+				Body:       bytes.NewReader(buffer[:length]),
+			})
+			if uploadErr == nil {
+				etag = output.ETag
+			}
+			return
+		})
+		if err != nil {
+			return
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: etag, PartNumber: aws.Int64(partNumber)})
+
+		if readErr != nil {
+			break
+		}
+	}
 
+	err = backend.retryUpload(fmt.Sprintf("completion of multipart upload of S3 object '%v'", loc.Resource), func() (completeErr error) {
+		_, completeErr = backend.S3Service.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(loc.Bucket),
+			Key:             aws.String(loc.Resource),
+			UploadId:        created.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+		return
+	})
+	return
+}
+
+// retryUpload tries upload up to s3UploadAttempts times, stopping early for errors that retrying will not fix
+// If every attempt fails, the error is a PermanentError, so that a RetryBackend does not start the whole upload over
+func (backend *CaryatidS3Backend) retryUpload(opName string, upload func() error) (err error) {
+	delay := s3UploadRetryDelay
+	for attempt := 1; ; attempt++ {
+		if err = upload(); err == nil {
+			return
+		}
+		err = s3WriteError(err)
+		if !IsRetryableError(err) {
+			return
+		} else if attempt >= s3UploadAttempts {
+			err = NewPermanentError(fmt.Errorf("%v failed after %v attempts: %w", opName, attempt, err))
+			return
+		}
+		backend.Manager.log().Warnf("Attempt %v of %v at %v failed; retrying in %v: %v\n", attempt, s3UploadAttempts, opName, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// OpenFile streams the object from S3 rather than downloading it all at once
+func (backend *CaryatidS3Backend) OpenFile(uri string) (reader io.ReadCloser, err error) {
+	return backend.getObject(uri, nil)
+}
+
+// OpenFileRange streams the object from S3 starting at offset, with a ranged GET
+func (backend *CaryatidS3Backend) OpenFileRange(uri string, offset int64) (reader io.ReadCloser, err error) {
+	return backend.getObject(uri, aws.String(fmt.Sprintf("bytes=%v-", offset)))
+}
+
+// getObject streams the object at uri, or only the bytes in byteRange if it is not nil
+func (backend *CaryatidS3Backend) getObject(uri string, byteRange *string) (reader io.ReadCloser, err error) {
+	var (
+		fileLoc *caryatidS3Location
+		output  *s3.GetObjectOutput
+	)
+
+	if fileLoc, err = uri2s3location(uri); err != nil {
+		return
+	}
+
+	output, err = backend.S3Service.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(fileLoc.Bucket),
+		Key:    aws.String(fileLoc.Resource),
+		Range:  byteRange,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		err = NewPermanentError(&boxNotFoundError{uri, err})
+		return
+	} else if err != nil {
+		err = s3PermanentError(err)
+		return
+	}
+
+	reader = output.Body
 	return
 }
 
@@ -200,11 +471,13 @@ func (backend *CaryatidS3Backend) DeleteFile(uri string) (err error) {
 		return
 	}
 
+	backend.Manager.log().Debugf("Deleting S3 object '%v' from bucket '%v'\n", fileLoc.Resource, fileLoc.Bucket)
 	_, err = backend.S3Service.DeleteObject(&s3.DeleteObjectInput{
 		Bucket: aws.String(fileLoc.Bucket),
 		Key:    aws.String(fileLoc.Resource),
 	})
 	if err != nil {
+		err = s3WriteError(err)
 		return
 	}
 
@@ -219,6 +492,190 @@ func (backend *CaryatidS3Backend) DeleteFile(uri string) (err error) {
 	return
 }
 
+func (backend *CaryatidS3Backend) ListBoxFiles(boxName string) (uris []string, err error) {
+	var boxDirLoc *caryatidS3Location
+
+	boxDirUri, err := BoxDirUriFromCatalogUri(backend.Manager.CatalogUri, boxName)
+	if err != nil {
+		return
+	}
+	if boxDirLoc, err = uri2s3location(boxDirUri); err != nil {
+		return
+	}
+
+	err = backend.S3Service.ListObjectsV2Pages(
+		&s3.ListObjectsV2Input{
+			Bucket: aws.String(boxDirLoc.Bucket),
+			Prefix: aws.String(boxDirLoc.Resource + "/"),
+		},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, object := range page.Contents {
+				if strings.HasSuffix(*object.Key, ".box") {
+					uris = append(uris, fmt.Sprintf("s3://%v/%v", boxDirLoc.Bucket, *object.Key))
+				}
+			}
+			return true
+		},
+	)
+	if err != nil {
+		err = s3PermanentError(err)
+		return
+	}
+	return
+}
+
+// ListFiles lists every object whose key starts with the path of dirUri, treating it as a directory
+// S3 already lists keys in sorted order
+// A URI for the root of a bucket, like 's3://bucket/', lists every object in the bucket
+func (backend *CaryatidS3Backend) ListFiles(dirUri string) (uris []string, err error) {
+	dirLoc, err := uri2s3location(dirUri)
+	if err != nil {
+		return
+	}
+	prefix := strings.TrimSuffix(dirLoc.Resource, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	err = backend.S3Service.ListObjectsV2Pages(
+		&s3.ListObjectsV2Input{
+			Bucket: aws.String(dirLoc.Bucket),
+			Prefix: aws.String(prefix),
+		},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, object := range page.Contents {
+				if !strings.HasSuffix(*object.Key, "/") {
+					uris = append(uris, fmt.Sprintf("s3://%v/%v", dirLoc.Bucket, *object.Key))
+				}
+			}
+			return true
+		},
+	)
+	if err != nil {
+		err = s3PermanentError(err)
+	}
+	return
+}
+
+// FileFingerprint returns the ETag of an object, without downloading it
+func (backend *CaryatidS3Backend) FileFingerprint(uri string) (fingerprint string, err error) {
+	fileLoc, err := uri2s3location(uri)
+	if err != nil {
+		return
+	}
+	output, err := backend.S3Service.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(fileLoc.Bucket),
+		Key:    aws.String(fileLoc.Resource),
+	})
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+		err = NewPermanentError(&boxNotFoundError{uri, err})
+		return
+	} else if err != nil {
+		err = s3PermanentError(err)
+		return
+	}
+	fingerprint = fmt.Sprintf("etag=%v", aws.StringValue(output.ETag))
+	return
+}
+
+// FileSize returns the size of an object, without downloading it
+func (backend *CaryatidS3Backend) FileSize(uri string) (size int64, err error) {
+	fileLoc, err := uri2s3location(uri)
+	if err != nil {
+		return
+	}
+	output, err := backend.S3Service.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(fileLoc.Bucket),
+		Key:    aws.String(fileLoc.Resource),
+	})
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+		err = NewPermanentError(&boxNotFoundError{uri, err})
+		return
+	} else if err != nil {
+		err = s3PermanentError(err)
+		return
+	}
+	size = aws.Int64Value(output.ContentLength)
+	return
+}
+
+// s3MD5ETag matches an ETag that is the MD5 of an object; the ETag of an object uploaded in parts has a '-' and a part count
+var s3MD5ETag = regexp.MustCompile(`^"?([0-9a-fA-F]{32})"?$`)
+
+// FileMD5 returns the MD5 of an object from its ETag, without downloading it
+// The ETag of an object uploaded in parts, or encrypted with a KMS key, is not its MD5, so it is not known for those
+func (backend *CaryatidS3Backend) FileMD5(uri string) (md5sum string, ok bool, err error) {
+	fileLoc, err := uri2s3location(uri)
+	if err != nil {
+		return
+	}
+	output, err := backend.S3Service.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(fileLoc.Bucket),
+		Key:    aws.String(fileLoc.Resource),
+	})
+	if err != nil {
+		err = s3PermanentError(err)
+		return
+	}
+	if aws.StringValue(output.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms {
+		return
+	}
+	if match := s3MD5ETag.FindStringSubmatch(aws.StringValue(output.ETag)); match != nil {
+		return strings.ToLower(match[1]), true, nil
+	}
+	return
+}
+
+// BackupCatalog copies the catalog to an object next to it, without downloading it
+func (backend *CaryatidS3Backend) BackupCatalog(suffix string) (backupUri string, err error) {
+	backupKey := backend.CatalogLocation.Resource + suffix
+	_, err = backend.S3Service.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(backend.CatalogLocation.Bucket),
+		CopySource: aws.String(url.PathEscape(backend.CatalogLocation.Bucket + "/" + backend.CatalogLocation.Resource)),
+		Key:        aws.String(backupKey),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return "", nil
+	} else if err != nil {
+		err = s3PermanentError(err)
+		return
+	}
+	backupUri = fmt.Sprintf("s3://%v/%v", backend.CatalogLocation.Bucket, backupKey)
+	return
+}
+
+func (backend *CaryatidS3Backend) ListCatalogBackups() (uris []string, err error) {
+	err = backend.S3Service.ListObjectsV2Pages(
+		&s3.ListObjectsV2Input{
+			Bucket: aws.String(backend.CatalogLocation.Bucket),
+			Prefix: aws.String(backend.CatalogLocation.Resource + CatalogBackupSuffix),
+		},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, object := range page.Contents {
+				uris = append(uris, fmt.Sprintf("s3://%v/%v", backend.CatalogLocation.Bucket, *object.Key))
+			}
+			return true
+		},
+	)
+	if err != nil {
+		err = s3PermanentError(err)
+	}
+	return
+}
+
+// SignedUrl returns a presigned HTTPS URL for an object, signed with the same credentials the backend uses for everything else
+func (backend *CaryatidS3Backend) SignedUrl(uri string, ttl time.Duration) (signedUrl string, err error) {
+	fileLoc, err := uri2s3location(uri)
+	if err != nil {
+		return
+	}
+	request, _ := backend.S3Service.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(fileLoc.Bucket),
+		Key:    aws.String(fileLoc.Resource),
+	})
+	return request.Presign(ttl)
+}
+
 func (backend *CaryatidS3Backend) Scheme() string {
 	return "s3"
 }
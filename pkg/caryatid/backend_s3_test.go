@@ -0,0 +1,406 @@
+package caryatid
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// mockS3Service records the uploads that a CaryatidS3Backend makes, and lists the objects it holds, without network access
+// Calls to methods it does not override panic, because the embedded interface is nil
+type mockS3Service struct {
+	s3iface.S3API
+
+	// How many times to fail each part number before uploading it
+	PartFailures map[int64]int
+
+	lock      sync.Mutex
+	Calls     []string
+	Parts     map[int64][]byte
+	Objects   map[string][]byte
+	Heads     map[string]*s3.HeadObjectOutput
+	Completed bool
+	Aborted   bool
+}
+
+func (svc *mockS3Service) call(name string) {
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+	svc.Calls = append(svc.Calls, name)
+}
+
+func (svc *mockS3Service) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	svc.call("PutObject")
+	body, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	svc.Objects[aws.StringValue(input.Key)] = body
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (svc *mockS3Service) CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	svc.call("CreateMultipartUpload")
+	svc.Parts = map[int64][]byte{}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("mock-upload-id")}, nil
+}
+
+func (svc *mockS3Service) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	partNumber := aws.Int64Value(input.PartNumber)
+	svc.call(fmt.Sprintf("UploadPart %v", partNumber))
+	if svc.PartFailures[partNumber] > 0 {
+		svc.PartFailures[partNumber]--
+		return nil, fmt.Errorf("Mock failure uploading part %v", partNumber)
+	}
+	body, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	svc.Parts[partNumber] = body
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%v", partNumber))}, nil
+}
+
+func (svc *mockS3Service) CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	svc.call("CompleteMultipartUpload")
+	object := []byte{}
+	for idx, part := range input.MultipartUpload.Parts {
+		if aws.Int64Value(part.PartNumber) != int64(idx+1) || aws.StringValue(part.ETag) != fmt.Sprintf("etag-%v", idx+1) {
+			return nil, fmt.Errorf("Unexpected part %v with ETag %v", aws.Int64Value(part.PartNumber), aws.StringValue(part.ETag))
+		}
+		object = append(object, svc.Parts[aws.Int64Value(part.PartNumber)]...)
+	}
+	svc.Objects[aws.StringValue(input.Key)] = object
+	svc.Completed = true
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (svc *mockS3Service) AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	svc.call("AbortMultipartUpload")
+	svc.Aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// HeadObject returns the Heads set for a key, or a NotFound error
+func (svc *mockS3Service) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	svc.call("HeadObject")
+	if head, ok := svc.Heads[aws.StringValue(input.Key)]; ok {
+		return head, nil
+	}
+	return nil, awserr.New("NotFound", "Not Found", nil)
+}
+
+// GetObject returns an object from Objects, or only the bytes from an offset on if the input has a Range like 'bytes=100-'
+func (svc *mockS3Service) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	svc.call(fmt.Sprintf("GetObject %v", aws.StringValue(input.Range)))
+	object, ok := svc.Objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "Not Found", nil)
+	}
+	if input.Range != nil {
+		var offset int
+		if _, err := fmt.Sscanf(aws.StringValue(input.Range), "bytes=%d-", &offset); err != nil || offset >= len(object) {
+			return nil, awserr.New("InvalidRange", "The requested range is not satisfiable", nil)
+		}
+		object = object[offset:]
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(object))}, nil
+}
+
+// ListObjectsV2Pages lists the objects whose keys start with the prefix, sorted like S3 sorts them, in a single page
+func (svc *mockS3Service) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	svc.call("ListObjectsV2Pages")
+	keys := []string{}
+	for key := range svc.Objects {
+		if strings.HasPrefix(key, aws.StringValue(input.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	page := &s3.ListObjectsV2Output{}
+	for _, key := range keys {
+		page.Contents = append(page.Contents, &s3.Object{Key: aws.String(key)})
+	}
+	fn(page, true)
+	return nil
+}
+
+// Presigning happens locally, so this does not need real credentials or network access
+func TestS3BackendSignedUrl(t *testing.T) {
+	awsSession, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("AKIDEXAMPLE", "SECRETEXAMPLE", ""),
+	})
+	if err != nil {
+		t.Fatalf("Error creating AWS session: %v\n", err)
+	}
+	backend := &CaryatidS3Backend{S3Service: s3.New(awsSession)}
+
+	signedUrl, err := backend.SignedUrl("s3://example-bucket/boxes/testbox/testbox_1.0.0_virtualbox.box", 90*time.Minute)
+	if err != nil {
+		t.Fatalf("SignedUrl() returned an error: %v\n", err)
+	}
+	u, err := url.Parse(signedUrl)
+	if err != nil {
+		t.Fatalf("SignedUrl() returned an invalid URL '%v': %v\n", signedUrl, err)
+	}
+	if u.Scheme != "https" || u.Query().Get("X-Amz-Expires") != "5400" || u.Query().Get("X-Amz-Signature") == "" {
+		t.Fatalf("Expected a presigned HTTPS URL that expires in 5400 seconds, but got '%v'\n", signedUrl)
+	}
+	if u.Query().Get("X-Amz-Credential") == "" || u.Path == "" {
+		t.Fatalf("Expected the presigned URL to include the credential and object path, but got '%v'\n", signedUrl)
+	}
+
+	if _, err = backend.SignedUrl("file:///tmp/testbox.box", time.Hour); err == nil {
+		t.Fatalf("Expected an error signing a URI that is not an S3 URI\n")
+	}
+}
+
+func TestS3BackendCopyBoxFileMultipart(t *testing.T) {
+	type TestCase struct {
+		Name          string
+		FileSize      int
+		PartFailures  map[int64]int
+		ExpectedCalls []string
+		ExpectError   bool
+	}
+
+	oldDelay := s3UploadRetryDelay
+	s3UploadRetryDelay = time.Millisecond
+	defer func() { s3UploadRetryDelay = oldDelay }()
+
+	partSize := int64(10)
+	testCases := []TestCase{
+		TestCase{"below the part size", 10, nil, []string{"PutObject"}, false},
+		TestCase{"above the part size", 25, nil, []string{"CreateMultipartUpload", "UploadPart 1", "UploadPart 2", "UploadPart 3", "CompleteMultipartUpload"}, false},
+		TestCase{"a multiple of the part size", 20, nil, []string{"CreateMultipartUpload", "UploadPart 1", "UploadPart 2", "CompleteMultipartUpload"}, false},
+		TestCase{"a part that fails once", 25, map[int64]int{2: 1}, []string{"CreateMultipartUpload", "UploadPart 1", "UploadPart 2", "UploadPart 2", "UploadPart 3", "CompleteMultipartUpload"}, false},
+		TestCase{"a part that keeps failing", 25, map[int64]int{2: s3UploadAttempts}, []string{"CreateMultipartUpload", "UploadPart 1", "UploadPart 2", "UploadPart 2", "UploadPart 2", "AbortMultipartUpload"}, true},
+	}
+	for _, tc := range testCases {
+		contents := bytes.Repeat([]byte("0123456789abcdef"), tc.FileSize/16+1)[:tc.FileSize]
+		boxPath := path.Join(integrationTestDir, "TestS3BackendCopyBoxFileMultipart.box")
+		if err := ioutil.WriteFile(boxPath, contents, 0666); err != nil {
+			t.Fatalf("%v: Error writing test box file: %v\n", tc.Name, err)
+		}
+
+		svc := &mockS3Service{PartFailures: tc.PartFailures, Objects: map[string][]byte{}}
+		backend := &CaryatidS3Backend{S3Service: svc, Manager: &BackendManager{UploadPartSize: partSize}}
+		err := backend.CopyBoxFile(boxPath, "s3://example-bucket/boxes/testbox/testbox_1.0.0_virtualbox.box")
+
+		if tc.ExpectError && err == nil {
+			t.Fatalf("%v: Expected CopyBoxFile() to fail\n", tc.Name)
+		} else if !tc.ExpectError && err != nil {
+			t.Fatalf("%v: CopyBoxFile() returned an error: %v\n", tc.Name, err)
+		}
+		if !reflect.DeepEqual(svc.Calls, tc.ExpectedCalls) {
+			t.Fatalf("%v: Expected calls %v, but got %v\n", tc.Name, tc.ExpectedCalls, svc.Calls)
+		}
+		object, uploaded := svc.Objects["boxes/testbox/testbox_1.0.0_virtualbox.box"]
+		if tc.ExpectError && (uploaded || svc.Completed) {
+			t.Fatalf("%v: Expected a failed upload to leave no object behind\n", tc.Name)
+		} else if !tc.ExpectError && !bytes.Equal(object, contents) {
+			t.Fatalf("%v: Expected the object to contain\n%q\nbut got\n%q\n", tc.Name, contents, object)
+		}
+	}
+
+	// Once a part has failed s3UploadAttempts times, a RetryBackend does not start the whole upload over
+	boxPath := path.Join(integrationTestDir, "TestS3BackendCopyBoxFileMultipart.box")
+	svc := &mockS3Service{PartFailures: map[int64]int{2: s3UploadAttempts}, Objects: map[string][]byte{}}
+	retry := NewRetryBackend(&CaryatidS3Backend{S3Service: svc, Manager: &BackendManager{UploadPartSize: partSize}}, 3)
+	retry.InitialDelay = time.Millisecond
+	if err := retry.CopyBoxFile(boxPath, "s3://example-bucket/boxes/testbox/testbox_1.0.0_virtualbox.box"); err == nil || IsRetryableError(err) {
+		t.Fatalf("Expected a PermanentError from an upload whose part kept failing, but got: %v\n", err)
+	}
+	if uploads := strings.Count(strings.Join(svc.Calls, ","), "CreateMultipartUpload"); uploads != 1 {
+		t.Fatalf("Expected RetryBackend to start the upload once, but it started it %v times: %v\n", uploads, svc.Calls)
+	}
+}
+
+func TestS3BackendListFiles(t *testing.T) {
+	svc := &mockS3Service{Objects: map[string][]byte{
+		"boxes/":                         nil,
+		"boxes/alpha.json":               nil,
+		"boxes/alpha/alpha_1.0.0_vb.box": nil,
+		"boxes/nested/beta.json":         nil,
+		"boxes-archive/gamma.json":       nil,
+		"other/delta.json":               nil,
+	}}
+	backend := &CaryatidS3Backend{S3Service: svc, Manager: &BackendManager{}}
+
+	type TestCase struct {
+		DirUri   string
+		Expected []string
+	}
+	testCases := []TestCase{
+		TestCase{"s3://example-bucket/boxes", []string{
+			"s3://example-bucket/boxes/alpha.json",
+			"s3://example-bucket/boxes/alpha/alpha_1.0.0_vb.box",
+			"s3://example-bucket/boxes/nested/beta.json",
+		}},
+		TestCase{"s3://example-bucket/boxes/nested/", []string{"s3://example-bucket/boxes/nested/beta.json"}},
+		TestCase{"s3://example-bucket/missing/", nil},
+		TestCase{"s3://example-bucket/", []string{
+			"s3://example-bucket/boxes-archive/gamma.json",
+			"s3://example-bucket/boxes/alpha.json",
+			"s3://example-bucket/boxes/alpha/alpha_1.0.0_vb.box",
+			"s3://example-bucket/boxes/nested/beta.json",
+			"s3://example-bucket/other/delta.json",
+		}},
+	}
+	for _, tc := range testCases {
+		uris, err := backend.ListFiles(tc.DirUri)
+		if err != nil {
+			t.Fatalf("ListFiles(%v) returned an error: %v\n", tc.DirUri, err)
+		}
+		if !reflect.DeepEqual(uris, tc.Expected) {
+			t.Fatalf("Expected ListFiles(%v) to return %v, but got %v\n", tc.DirUri, tc.Expected, uris)
+		}
+	}
+}
+
+func TestS3BackendGetCatalogBytesConditional(t *testing.T) {
+	var (
+		lock        sync.Mutex
+		catalog     = []byte(`{"name": "testbox", "versions": []}`)
+		etag        = `"etag-1"`
+		ifNoneMatch []string
+	)
+	// A fake S3 endpoint that serves the catalog, and responds 304 Not Modified when its ETag matches If-None-Match
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.Method != "GET" || r.URL.Path != "/example-bucket/testbox.json" {
+			http.Error(w, "Unexpected request", http.StatusBadRequest)
+			return
+		}
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(catalog)
+	}))
+	defer server.Close()
+
+	awsSession, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("AKIDEXAMPLE", "SECRETEXAMPLE", ""),
+	})
+	if err != nil {
+		t.Fatalf("Error creating AWS session: %v\n", err)
+	}
+	backend := &CaryatidS3Backend{
+		S3Service:       s3.New(awsSession),
+		Manager:         &BackendManager{CatalogUri: "s3://example-bucket/testbox.json"},
+		CatalogLocation: &caryatidS3Location{"example-bucket", "testbox.json"},
+	}
+
+	getCatalog := func(expected []byte) {
+		catalogBytes, err := backend.GetCatalogBytes()
+		if err != nil {
+			t.Fatalf("GetCatalogBytes() returned an error: %v\n", err)
+		}
+		if !bytes.Equal(catalogBytes, expected) {
+			t.Fatalf("Expected GetCatalogBytes() to return\n%s\nbut got\n%s\n", expected, catalogBytes)
+		}
+		// Changing what is returned must not change the cached catalog
+		for idx := range catalogBytes {
+			catalogBytes[idx] = 0
+		}
+	}
+
+	getCatalog(catalog)
+	getCatalog(catalog)
+	lock.Lock()
+	catalog, etag = []byte(`{"name": "testbox", "versions": [{"version": "1.0.0", "providers": []}]}`), `"etag-2"`
+	lock.Unlock()
+	getCatalog(catalog)
+	getCatalog(catalog)
+
+	expected := []string{"", `"etag-1"`, `"etag-1"`, `"etag-2"`}
+	if !reflect.DeepEqual(ifNoneMatch, expected) {
+		t.Fatalf("Expected requests with If-None-Match %v, but got %v\n", expected, ifNoneMatch)
+	}
+}
+
+func TestS3BackendFileMD5(t *testing.T) {
+	md5sum := "0123456789abcdef0123456789abcdef"
+	svc := &mockS3Service{Heads: map[string]*s3.HeadObjectOutput{
+		"single.box":    &s3.HeadObjectOutput{ETag: aws.String(`"` + strings.ToUpper(md5sum) + `"`)},
+		"multipart.box": &s3.HeadObjectOutput{ETag: aws.String(`"` + md5sum + `-3"`)},
+		"kms.box":       &s3.HeadObjectOutput{ETag: aws.String(`"` + md5sum + `"`), ServerSideEncryption: aws.String(s3.ServerSideEncryptionAwsKms)},
+	}}
+	backend := &CaryatidS3Backend{S3Service: svc, Manager: &BackendManager{}}
+
+	type TestCase struct {
+		Key         string
+		ExpectedMD5 string
+		ExpectKnown bool
+		ExpectError bool
+	}
+	testCases := []TestCase{
+		TestCase{"single.box", md5sum, true, false},
+		TestCase{"multipart.box", "", false, false},
+		TestCase{"kms.box", "", false, false},
+		TestCase{"missing.box", "", false, true},
+	}
+	for _, tc := range testCases {
+		uri := "s3://example-bucket/" + tc.Key
+		actual, known, err := backend.FileMD5(uri)
+		if tc.ExpectError != (err != nil) {
+			t.Fatalf("Expected FileMD5(%v) to return an error: %v, but got: %v\n", uri, tc.ExpectError, err)
+		}
+		if actual != tc.ExpectedMD5 || known != tc.ExpectKnown {
+			t.Fatalf("Expected FileMD5(%v) to return '%v', %v; got '%v', %v\n", uri, tc.ExpectedMD5, tc.ExpectKnown, actual, known)
+		}
+	}
+}
+
+func TestS3BackendOpenFileRange(t *testing.T) {
+	svc := &mockS3Service{
+		Objects: map[string][]byte{"range.box": []byte("0123456789")},
+		Heads:   map[string]*s3.HeadObjectOutput{"range.box": &s3.HeadObjectOutput{ContentLength: aws.Int64(10)}},
+	}
+	backend := &CaryatidS3Backend{S3Service: svc, Manager: &BackendManager{}}
+
+	if size, err := backend.FileSize("s3://example-bucket/range.box"); err != nil || size != 10 {
+		t.Fatalf("Expected FileSize() to return 10, but got %v and error %v\n", size, err)
+	}
+	if _, err := backend.FileSize("s3://example-bucket/missing.box"); !errors.Is(err, ErrBoxNotFound) {
+		t.Fatalf("Expected FileSize() of a missing object to return ErrBoxNotFound, but got %v\n", err)
+	}
+
+	reader, err := backend.OpenFileRange("s3://example-bucket/range.box", 4)
+	if err != nil {
+		t.Fatalf("OpenFileRange() returned an unexpected error: %v\n", err)
+	}
+	contents, _ := ioutil.ReadAll(reader)
+	reader.Close()
+	if string(contents) != "456789" {
+		t.Fatalf("Expected OpenFileRange() to read from the offset, but got '%v'\n", string(contents))
+	}
+	if expected := []string{"HeadObject", "HeadObject", "GetObject bytes=4-"}; !reflect.DeepEqual(svc.Calls, expected) {
+		t.Fatalf("Expected calls %v, but got %v\n", expected, svc.Calls)
+	}
+}
@@ -0,0 +1,335 @@
+/*
+A backend decorator that bounds how long each backend operation can take
+*/
+
+package caryatid
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// TimeoutError is returned by TimeoutBackend when an operation does not finish in time
+type TimeoutError struct {
+	Operation string
+	Timeout   time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%v: operation timed out after %v", e.Operation, e.Timeout)
+}
+
+// TimeoutBackend wraps another backend, returning a TimeoutError from any operation that takes longer than Timeout
+// The backends cannot cancel a request that is already in flight, so the operation is abandoned rather than interrupted;
+// this is still enough to fail quickly when a backend is unreachable, rather than waiting minutes for the OS to give up on the connection
+// The timeout applies to CopyBoxFile() too, so it must be long enough to upload the largest box
+// Each operation is timed on its own, so an abandoned operation that is still running does not hold up the ones after it,
+// but it runs alongside them, so the wrapped backend must be safe to use from several goroutines, as the built in backends are
+// TimeoutBackend implements the optional interfaces like CaryatidLockingBackend too, bounding them the same way,
+// and returning an error wrapping ErrNotSupported if the backend it wraps does not implement them
+type TimeoutBackend struct {
+	Backend CaryatidBackend
+	Timeout time.Duration
+}
+
+// NewTimeoutBackend wraps a backend so that each operation fails if it takes longer than timeout
+func NewTimeoutBackend(backend CaryatidBackend, timeout time.Duration) *TimeoutBackend {
+	return &TimeoutBackend{Backend: backend, Timeout: timeout}
+}
+
+// withTimeout runs operation, and returns a TimeoutError if it has not finished after backend.Timeout
+// operation must not write to anything the caller reads unless it finishes in time
+func (backend *TimeoutBackend) withTimeout(opName string, operation func() error) (err error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- operation()
+	}()
+
+	timer := time.NewTimer(backend.Timeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		err = &TimeoutError{Operation: opName, Timeout: backend.Timeout}
+		backendLogger(backend).Errorf("TimeoutBackend: %v\n", err)
+	}
+	return
+}
+
+func (backend *TimeoutBackend) SetManager(manager *BackendManager) error {
+	return backend.Backend.SetManager(manager)
+}
+
+func (backend *TimeoutBackend) GetManager() (*BackendManager, error) {
+	return backend.Backend.GetManager()
+}
+
+func (backend *TimeoutBackend) GetCatalogBytes() (catalogBytes []byte, err error) {
+	var result []byte
+	err = backend.withTimeout("GetCatalogBytes()", func() (opErr error) {
+		result, opErr = backend.Backend.GetCatalogBytes()
+		return
+	})
+	if _, timedOut := err.(*TimeoutError); !timedOut {
+		catalogBytes = result
+	}
+	return
+}
+
+func (backend *TimeoutBackend) SetCatalogBytes(serializedCatalog []byte) error {
+	return backend.withTimeout("SetCatalogBytes()", func() error {
+		return backend.Backend.SetCatalogBytes(serializedCatalog)
+	})
+}
+
+func (backend *TimeoutBackend) CopyBoxFile(localPath string, boxUri string) error {
+	return backend.withTimeout("CopyBoxFile()", func() error {
+		return backend.Backend.CopyBoxFile(localPath, boxUri)
+	})
+}
+
+// OpenFile bounds opening the file, but not reading from it once it has been opened
+func (backend *TimeoutBackend) OpenFile(uri string) (io.ReadCloser, error) {
+	return backend.openWithTimeout("OpenFile()", func() (io.ReadCloser, error) {
+		return backend.Backend.OpenFile(uri)
+	})
+}
+
+// openWithTimeout runs open like withTimeout, but if the file opens after the timeout, it is closed again
+func (backend *TimeoutBackend) openWithTimeout(opName string, open func() (io.ReadCloser, error)) (reader io.ReadCloser, err error) {
+	type openResult struct {
+		reader io.ReadCloser
+		err    error
+	}
+	opened := make(chan openResult, 1)
+	go func() {
+		result, openErr := open()
+		opened <- openResult{result, openErr}
+	}()
+
+	timer := time.NewTimer(backend.Timeout)
+	defer timer.Stop()
+	select {
+	case result := <-opened:
+		return result.reader, result.err
+	case <-timer.C:
+		go func() {
+			if result := <-opened; result.err == nil {
+				result.reader.Close()
+			}
+		}()
+		err = &TimeoutError{Operation: opName, Timeout: backend.Timeout}
+		backendLogger(backend).Errorf("TimeoutBackend: %v\n", err)
+		return
+	}
+}
+
+// MoveFile returns ErrMoveNotSupported if the wrapped backend does not implement CaryatidMoveBackend
+func (backend *TimeoutBackend) MoveFile(fromUri string, toUri string) error {
+	mover, ok := backend.Backend.(CaryatidMoveBackend)
+	if !ok {
+		return ErrMoveNotSupported
+	}
+	return backend.withTimeout("MoveFile()", func() error {
+		return mover.MoveFile(fromUri, toUri)
+	})
+}
+
+func (backend *TimeoutBackend) DeleteFile(uri string) error {
+	return backend.withTimeout("DeleteFile()", func() error {
+		return backend.Backend.DeleteFile(uri)
+	})
+}
+
+func (backend *TimeoutBackend) ListBoxFiles(boxName string) (uris []string, err error) {
+	var result []string
+	err = backend.withTimeout("ListBoxFiles()", func() (opErr error) {
+		result, opErr = backend.Backend.ListBoxFiles(boxName)
+		return
+	})
+	if _, timedOut := err.(*TimeoutError); !timedOut {
+		uris = result
+	}
+	return
+}
+
+func (backend *TimeoutBackend) LinkFile(targetUri string, linkUri string) error {
+	linker, ok := backend.Backend.(CaryatidLinkingBackend)
+	if !ok {
+		return notSupportedError("LinkFile()")
+	}
+	return backend.withTimeout("LinkFile()", func() error {
+		return linker.LinkFile(targetUri, linkUri)
+	})
+}
+
+func (backend *TimeoutBackend) SignedUrl(uri string, ttl time.Duration) (signedUrl string, err error) {
+	signer, ok := backend.Backend.(CaryatidSigningBackend)
+	if !ok {
+		return "", notSupportedError("SignedUrl()")
+	}
+	var result string
+	err = backend.withTimeout("SignedUrl()", func() (opErr error) {
+		result, opErr = signer.SignedUrl(uri, ttl)
+		return
+	})
+	if _, timedOut := err.(*TimeoutError); !timedOut {
+		signedUrl = result
+	}
+	return
+}
+
+func (backend *TimeoutBackend) ListFiles(dirUri string) (uris []string, err error) {
+	lister, ok := backend.Backend.(CaryatidListingBackend)
+	if !ok {
+		return nil, notSupportedError("ListFiles()")
+	}
+	var result []string
+	err = backend.withTimeout("ListFiles()", func() (opErr error) {
+		result, opErr = lister.ListFiles(dirUri)
+		return
+	})
+	if _, timedOut := err.(*TimeoutError); !timedOut {
+		uris = result
+	}
+	return
+}
+
+func (backend *TimeoutBackend) FileFingerprint(uri string) (fingerprint string, err error) {
+	fingerprinter, ok := backend.Backend.(CaryatidFingerprintBackend)
+	if !ok {
+		return "", notSupportedError("FileFingerprint()")
+	}
+	var result string
+	err = backend.withTimeout("FileFingerprint()", func() (opErr error) {
+		result, opErr = fingerprinter.FileFingerprint(uri)
+		return
+	})
+	if _, timedOut := err.(*TimeoutError); !timedOut {
+		fingerprint = result
+	}
+	return
+}
+
+func (backend *TimeoutBackend) FileMD5(uri string) (md5sum string, ok bool, err error) {
+	reporter, implemented := backend.Backend.(CaryatidContentMD5Backend)
+	if !implemented {
+		return "", false, notSupportedError("FileMD5()")
+	}
+	var (
+		result string
+		known  bool
+	)
+	err = backend.withTimeout("FileMD5()", func() (opErr error) {
+		result, known, opErr = reporter.FileMD5(uri)
+		return
+	})
+	if _, timedOut := err.(*TimeoutError); !timedOut {
+		md5sum, ok = result, known
+	}
+	return
+}
+
+// LockCatalog bounds taking the lock and releasing it
+// If the lock is taken after the timeout, it is released again, so that the abandoned attempt does not keep the catalog locked
+func (backend *TimeoutBackend) LockCatalog() (unlock func() error, err error) {
+	locker, ok := backend.Backend.(CaryatidLockingBackend)
+	if !ok {
+		return nil, notSupportedError("LockCatalog()")
+	}
+	type lockResult struct {
+		unlock func() error
+		err    error
+	}
+	locked := make(chan lockResult, 1)
+	go func() {
+		result, lockErr := locker.LockCatalog()
+		locked <- lockResult{result, lockErr}
+	}()
+
+	timer := time.NewTimer(backend.Timeout)
+	defer timer.Stop()
+	select {
+	case result := <-locked:
+		if result.err != nil {
+			return nil, result.err
+		}
+		unlock = func() error {
+			return backend.withTimeout("LockCatalog() unlock", result.unlock)
+		}
+		return
+	case <-timer.C:
+		go func() {
+			if result := <-locked; result.err == nil {
+				result.unlock()
+			}
+		}()
+		err = &TimeoutError{Operation: "LockCatalog()", Timeout: backend.Timeout}
+		backendLogger(backend).Errorf("TimeoutBackend: %v\n", err)
+		return
+	}
+}
+
+func (backend *TimeoutBackend) BackupCatalog(suffix string) (backupUri string, err error) {
+	backuper, ok := backend.Backend.(CaryatidBackupBackend)
+	if !ok {
+		return "", notSupportedError("BackupCatalog()")
+	}
+	var result string
+	err = backend.withTimeout("BackupCatalog()", func() (opErr error) {
+		result, opErr = backuper.BackupCatalog(suffix)
+		return
+	})
+	if _, timedOut := err.(*TimeoutError); !timedOut {
+		backupUri = result
+	}
+	return
+}
+
+func (backend *TimeoutBackend) ListCatalogBackups() (uris []string, err error) {
+	backuper, ok := backend.Backend.(CaryatidBackupBackend)
+	if !ok {
+		return nil, notSupportedError("ListCatalogBackups()")
+	}
+	var result []string
+	err = backend.withTimeout("ListCatalogBackups()", func() (opErr error) {
+		result, opErr = backuper.ListCatalogBackups()
+		return
+	})
+	if _, timedOut := err.(*TimeoutError); !timedOut {
+		uris = result
+	}
+	return
+}
+
+func (backend *TimeoutBackend) FileSize(uri string) (size int64, err error) {
+	ranger, ok := backend.Backend.(CaryatidRangeBackend)
+	if !ok {
+		return 0, notSupportedError("FileSize()")
+	}
+	var result int64
+	err = backend.withTimeout("FileSize()", func() (opErr error) {
+		result, opErr = ranger.FileSize(uri)
+		return
+	})
+	if _, timedOut := err.(*TimeoutError); !timedOut {
+		size = result
+	}
+	return
+}
+
+// OpenFileRange bounds opening the file like OpenFile()
+func (backend *TimeoutBackend) OpenFileRange(uri string, offset int64) (io.ReadCloser, error) {
+	ranger, ok := backend.Backend.(CaryatidRangeBackend)
+	if !ok {
+		return nil, notSupportedError("OpenFileRange()")
+	}
+	return backend.openWithTimeout("OpenFileRange()", func() (io.ReadCloser, error) {
+		return ranger.OpenFileRange(uri, offset)
+	})
+}
+
+func (backend *TimeoutBackend) Scheme() string {
+	return backend.Backend.Scheme()
+}
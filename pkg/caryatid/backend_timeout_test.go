@@ -0,0 +1,189 @@
+package caryatid
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowTestBackend takes Delay to finish each operation, like a backend that cannot be reached
+// Abandoned operations keep running alongside later ones, so the catalog is guarded by lock
+type slowTestBackend struct {
+	CaryatidTestBackend
+	Delay time.Duration
+
+	lock sync.Mutex
+}
+
+func (backend *slowTestBackend) GetCatalogBytes() ([]byte, error) {
+	time.Sleep(backend.Delay)
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	return backend.CaryatidTestBackend.GetCatalogBytes()
+}
+
+func (backend *slowTestBackend) SetCatalogBytes(serializedCatalog []byte) error {
+	time.Sleep(backend.Delay)
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	return backend.CaryatidTestBackend.SetCatalogBytes(serializedCatalog)
+}
+
+func (backend *slowTestBackend) OpenFile(uri string) (io.ReadCloser, error) {
+	time.Sleep(backend.Delay)
+	return ioutil.NopCloser(strings.NewReader("box")), nil
+}
+
+// slowLockingBackend takes Delay to lock the catalog, and refuses to lock it again until it is unlocked
+type slowLockingBackend struct {
+	CaryatidTestBackend
+	Delay time.Duration
+
+	lock   sync.Mutex
+	locked bool
+}
+
+func (backend *slowLockingBackend) LockCatalog() (unlock func() error, err error) {
+	time.Sleep(backend.Delay)
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.locked {
+		return nil, ErrCatalogLocked
+	}
+	backend.locked = true
+	unlock = func() error {
+		backend.lock.Lock()
+		defer backend.lock.Unlock()
+		backend.locked = false
+		return nil
+	}
+	return
+}
+
+func (backend *slowLockingBackend) isLocked() bool {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	return backend.locked
+}
+
+func TestTimeoutBackend_ImplementsCaryatidBackend(t *testing.T) {
+	var _ CaryatidBackend = new(TimeoutBackend)
+	var _ CaryatidMoveBackend = new(TimeoutBackend)
+	var _ CaryatidLinkingBackend = new(TimeoutBackend)
+	var _ CaryatidSigningBackend = new(TimeoutBackend)
+	var _ CaryatidListingBackend = new(TimeoutBackend)
+	var _ CaryatidFingerprintBackend = new(TimeoutBackend)
+	var _ CaryatidContentMD5Backend = new(TimeoutBackend)
+	var _ CaryatidLockingBackend = new(TimeoutBackend)
+	var _ CaryatidBackupBackend = new(TimeoutBackend)
+	var _ CaryatidRangeBackend = new(TimeoutBackend)
+}
+
+func TestTimeoutBackendAbandonedOperation(t *testing.T) {
+	slow := &slowTestBackend{Delay: 500 * time.Millisecond}
+	backend := NewTimeoutBackend(slow, 10*time.Millisecond)
+
+	var timeoutErr *TimeoutError
+	if _, err := backend.GetCatalogBytes(); !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected GetCatalogBytes() to return a TimeoutError, but got %v\n", err)
+	}
+
+	// The abandoned GetCatalogBytes() is still running, but it does not hold up an operation that is quick
+	start := time.Now()
+	if _, err := backend.ListBoxFiles("TestBox"); err != nil {
+		t.Fatalf("ListBoxFiles() returned an unexpected error: %v\n", err)
+	}
+	if elapsed := time.Since(start); elapsed >= slow.Delay/2 {
+		t.Fatalf("Expected ListBoxFiles() not to wait for the abandoned GetCatalogBytes(), but it took %v\n", elapsed)
+	}
+
+	if err := backend.LinkFile("Test://TestBox/a.box", "Test://TestBox/b.box"); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("Expected LinkFile() on a backend that cannot link files to return ErrNotSupported, but got %v\n", err)
+	}
+}
+
+func TestTimeoutBackendLockCatalog(t *testing.T) {
+	locker := &slowLockingBackend{Delay: 100 * time.Millisecond}
+	backend := NewTimeoutBackend(locker, 10*time.Millisecond)
+
+	var timeoutErr *TimeoutError
+	if _, err := backend.LockCatalog(); !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected LockCatalog() to return a TimeoutError, but got %v\n", err)
+	}
+
+	// The abandoned attempt takes the lock after the timeout, and then releases it
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Sleep(locker.Delay * 2); locker.isLocked(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the lock taken after the timeout to be released\n")
+		}
+	}
+
+	locker.Delay = 0
+	unlock, err := backend.LockCatalog()
+	if err != nil {
+		t.Fatalf("LockCatalog() returned an unexpected error: %v\n", err)
+	}
+	if !locker.isLocked() {
+		t.Fatalf("Expected LockCatalog() to lock the catalog\n")
+	}
+	if err = unlock(); err != nil || locker.isLocked() {
+		t.Fatalf("Expected unlock() to release the lock, but it returned %v\n", err)
+	}
+}
+
+func TestTimeoutBackend(t *testing.T) {
+	type TestCase struct {
+		Delay           time.Duration
+		Timeout         time.Duration
+		ExpectedTimeout bool
+	}
+	testCases := []TestCase{
+		TestCase{0, 1 * time.Second, false},
+		TestCase{500 * time.Millisecond, 10 * time.Millisecond, true},
+	}
+
+	for _, tc := range testCases {
+		slow := &slowTestBackend{Delay: tc.Delay}
+		var backend CaryatidBackend = NewTimeoutBackend(slow, tc.Timeout)
+		manager := NewBackendManager("Test://TestTimeoutBackend/catalog.json", &backend)
+
+		start := time.Now()
+		_, err := manager.GetCatalog()
+		elapsed := time.Since(start)
+
+		var timeoutErr *TimeoutError
+		if !tc.ExpectedTimeout {
+			if err != nil {
+				t.Fatalf("Test case %+v: GetCatalog() returned an unexpected error: %v\n", tc, err)
+			}
+			if _, err = backend.OpenFile("Test://TestTimeoutBackend/box.box"); err != nil {
+				t.Fatalf("Test case %+v: OpenFile() returned an unexpected error: %v\n", tc, err)
+			}
+			continue
+		}
+
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("Test case %+v: expected GetCatalog() to return a TimeoutError, but got %v\n", tc, err)
+		}
+		if !strings.Contains(err.Error(), "timed out after "+tc.Timeout.String()) {
+			t.Fatalf("Test case %+v: expected the error to say how long the operation took, but got '%v'\n", tc, err)
+		}
+		if elapsed >= tc.Delay {
+			t.Fatalf("Test case %+v: expected GetCatalog() to give up before the backend finished, but it took %v\n", tc, elapsed)
+		}
+		if err = backend.SetCatalogBytes([]byte("{}")); !errors.As(err, &timeoutErr) {
+			t.Fatalf("Test case %+v: expected SetCatalogBytes() to return a TimeoutError, but got %v\n", tc, err)
+		}
+		if _, err = backend.OpenFile("Test://TestTimeoutBackend/box.box"); !errors.As(err, &timeoutErr) {
+			t.Fatalf("Test case %+v: expected OpenFile() to return a TimeoutError, but got %v\n", tc, err)
+		}
+		if !IsRetryableError(err) {
+			t.Fatalf("Test case %+v: expected a TimeoutError to be retryable\n", tc)
+		}
+	}
+}
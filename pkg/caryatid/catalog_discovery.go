@@ -0,0 +1,91 @@
+/*
+Finding the catalogs kept under one directory, like a root that holds a catalog for each of many boxes
+*/
+
+package caryatid
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// DiscoveredCatalog is a catalog found by DiscoverCatalogs(), along with the URI it was found at
+type DiscoveredCatalog struct {
+	Uri     string
+	Catalog Catalog
+}
+
+// DiscoverCatalogs finds every catalog under the directory at bm.CatalogUri, at any depth, sorted by URI
+// Each file whose name ends in .json is read, and files that are not catalogs are skipped with a warning; see parseCatalogFile()
+// The index that WriteCatalogIndex() saves in the directory is skipped too
+// The backend must implement CaryatidListingBackend
+func (bm *BackendManager) DiscoverCatalogs() (catalogs []DiscoveredCatalog, err error) {
+	lister, ok := bm.listingBackend()
+	if !ok {
+		err = fmt.Errorf("The '%v' backend cannot list the files in a directory", bm.Backend.Scheme())
+		return
+	}
+	uris, err := lister.ListFiles(bm.CatalogUri)
+	if err != nil {
+		bm.log().Errorf("DiscoverCatalogs(): Error listing files under '%v': %v\n", bm.CatalogUri, err)
+		return
+	}
+
+	indexUri := CatalogIndexUri(bm.CatalogUri)
+	for _, uri := range uris {
+		if !strings.HasSuffix(uri, ".json") || uri == indexUri {
+			continue
+		}
+		var catalogBytes []byte
+		if catalogBytes, err = bm.readFile(uri); err != nil {
+			bm.log().Errorf("DiscoverCatalogs(): Error reading '%v': %v\n", uri, err)
+			return
+		}
+		catalog, parseErr := parseCatalogFile(catalogBytes)
+		if parseErr != nil {
+			bm.log().Warnf("DiscoverCatalogs(): Skipping '%v', which is not a catalog: %v\n", uri, parseErr)
+			continue
+		}
+		catalogs = append(catalogs, DiscoveredCatalog{uri, catalog})
+	}
+	return
+}
+
+// readFile returns the contents of a file in the backend
+func (bm *BackendManager) readFile(uri string) (contents []byte, err error) {
+	reader, err := bm.Backend.OpenFile(uri)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// parseCatalogFile unmarshals a file that might be a catalog, migrating it to the current CatalogSchemaVersion
+// It is a catalog if it is a JSON object with a non-empty name and a list of versions, as every catalog caryatid writes has;
+// other JSON files, like a package.json, are an error
+func parseCatalogFile(catalogBytes []byte) (catalog Catalog, err error) {
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(catalogBytes, &fields); err != nil {
+		err = fmt.Errorf("Not a JSON object: %v", err)
+		return
+	}
+	if _, ok := fields["versions"]; !ok {
+		err = fmt.Errorf("No versions field")
+		return
+	}
+
+	var document catalogDocument
+	if err = json.Unmarshal(catalogBytes, &document); err != nil {
+		return
+	}
+	if document.Name == "" {
+		err = fmt.Errorf("No name field")
+		return
+	}
+	catalog = document.Catalog
+	MigrateCatalog(&catalog, document.SchemaVersion)
+	return
+}
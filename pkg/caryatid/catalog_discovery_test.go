@@ -0,0 +1,86 @@
+package caryatid
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiscoverCatalogs(t *testing.T) {
+	rootUri := "mem://TestDiscoverCatalogs/boxes"
+	saveCatalog := func(uri string, catalog Catalog) {
+		backend, err := NewBackendFromUri(uri)
+		if err != nil {
+			t.Fatalf("Error getting memory backend: %v\n", err)
+		}
+		if err = NewBackendManager(uri, &backend).SaveCatalog(catalog); err != nil {
+			t.Fatalf("Error saving catalog to '%v': %v\n", uri, err)
+		}
+	}
+	version := func(v string) Version {
+		return Version{Version: v, Providers: []Provider{Provider{Name: "virtualbox", Url: "mem://example.box", ChecksumType: "sha1", Checksum: "0xB00B1E5"}}}
+	}
+
+	saveCatalog(rootUri+"/alpha.json", Catalog{Name: "alpha", Versions: []Version{version("1.0.0"), version("1.1.0")}})
+	saveCatalog(rootUri+"/nested/beta.json", Catalog{Name: "beta", Versions: []Version{version("2.0.0")}})
+	saveCatalog(rootUri+"/empty.json", Catalog{Name: "empty"})
+	saveCatalog("mem://TestDiscoverCatalogs/elsewhere.json", Catalog{Name: "elsewhere", Versions: []Version{version("1.0.0")}})
+
+	memoryBackendLock.Lock()
+	memoryBackendFiles[rootUri+"/package.json"] = []byte(`{"name": "not-a-catalog", "version": "1.0.0"}`)
+	memoryBackendFiles[rootUri+"/list.json"] = []byte(`["alpha", "beta"]`)
+	memoryBackendFiles[rootUri+"/broken.json"] = []byte(`{"name": "broken", "versions": [`)
+	memoryBackendFiles[rootUri+"/alpha/alpha_1.0.0_virtualbox.box"] = []byte("not JSON at all")
+	memoryBackendLock.Unlock()
+
+	backend, err := NewBackendFromUri(rootUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(rootUri, &backend)
+	discovered, err := manager.DiscoverCatalogs()
+	if err != nil {
+		t.Fatalf("DiscoverCatalogs() returned an error: %v\n", err)
+	}
+
+	expected := map[string]int{
+		rootUri + "/alpha.json":       2,
+		rootUri + "/empty.json":       0,
+		rootUri + "/nested/beta.json": 1,
+	}
+	actual := map[string]int{}
+	for _, found := range discovered {
+		actual[found.Uri] = len(found.Catalog.Versions)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected DiscoverCatalogs() to find catalogs with version counts %v, but found %v\n", expected, actual)
+	}
+	if discovered[0].Catalog.Name != "alpha" {
+		t.Fatalf("Expected the first catalog found to be 'alpha', but got '%v'\n", discovered[0].Catalog.Name)
+	}
+}
+
+func TestParseCatalogFile(t *testing.T) {
+	type TestCase struct {
+		Contents    string
+		ExpectError bool
+	}
+	testCases := []TestCase{
+		TestCase{`{"name": "testbox", "description": "", "versions": []}`, false},
+		TestCase{`{"name": "testbox", "description": "", "versions": null}`, false},
+		TestCase{`{"name": "testbox", "versions": [], "caryatid_schema_version": 1}`, false},
+		TestCase{`{"name": "testbox", "version": "1.0.0"}`, true},
+		TestCase{`{"name": "", "versions": []}`, true},
+		TestCase{`{"versions": []}`, true},
+		TestCase{`{"name": "testbox", "versions": "1.0.0"}`, true},
+		TestCase{`["testbox"]`, true},
+		TestCase{`not json`, true},
+	}
+	for _, tc := range testCases {
+		_, err := parseCatalogFile([]byte(tc.Contents))
+		if tc.ExpectError && err == nil {
+			t.Fatalf("Expected parseCatalogFile(%v) to return an error, but it did not\n", tc.Contents)
+		} else if !tc.ExpectError && err != nil {
+			t.Fatalf("parseCatalogFile(%v) returned an error: %v\n", tc.Contents, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Export formats for Vagrant catalogs, for consumption by tools that don't understand the catalog JSON
+*/
+
+package caryatid
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/mrled/caryatid/internal/util"
+)
+
+// DefaultExportFormat is used when the caller does not specify an export format
+const DefaultExportFormat = "csv"
+
+// The columns of a CSV export, in order
+var csvExportHeader = []string{"name", "version", "provider", "architecture", "checksum_type", "checksum", "url"}
+
+// The keys of this map are the supported export formats
+var catalogExporters = map[string]func(*Catalog, io.Writer) error{
+	"csv":  exportCatalogCsv,
+	"json": exportCatalogJson,
+	"html": exportCatalogHtml,
+}
+
+// ExportFormats returns a sorted list of all supported export formats
+func ExportFormats() (formats []string) {
+	for format := range catalogExporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return
+}
+
+// ExportCatalog writes the catalog to writer in an export format like "csv"
+// An empty format results in DefaultExportFormat
+func ExportCatalog(catalog *Catalog, format string, writer io.Writer) (err error) {
+	if format == "" {
+		format = DefaultExportFormat
+	}
+	exporter, ok := catalogExporters[strings.ToLower(format)]
+	if !ok {
+		return fmt.Errorf("Unsupported export format '%v'; supported formats are: %v", format, strings.Join(ExportFormats(), ", "))
+	}
+	return exporter(catalog, writer)
+}
+
+// exportCatalogCsv writes a header row, and then one row for each provider, with versions sorted semantically
+func exportCatalogCsv(catalog *Catalog, writer io.Writer) (err error) {
+	csvWriter := csv.NewWriter(writer)
+	if err = csvWriter.Write(csvExportHeader); err != nil {
+		return
+	}
+	for _, v := range catalog.Sorted().Versions {
+		for _, p := range v.Providers {
+			if err = csvWriter.Write([]string{catalog.Name, v.Version, p.Name, p.Architecture, p.ChecksumType, p.Checksum, p.Url}); err != nil {
+				return
+			}
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// exportCatalogJson writes the catalog as indented JSON, in the same structure that Vagrant reads
+func exportCatalogJson(catalog *Catalog, writer io.Writer) (err error) {
+	jsonData, err := json.MarshalIndent(catalog.Sorted(), "", "  ")
+	if err != nil {
+		return
+	}
+	_, err = fmt.Fprintf(writer, "%s\n", jsonData)
+	return
+}
+
+// DefaultHtmlExportTemplate is the html/template for the "html" export format,
+// a self-contained page with a row for each provider; see ExportCatalogHtml()
+const DefaultHtmlExportTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{or .DisplayName .Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.checksum { font-family: monospace; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>{{or .DisplayName .Name}}</h1>
+<p>{{.Description}}</p>
+<table>
+<thead>
+<tr><th>Version</th><th>Provider</th><th>Architecture</th><th>Size</th><th>Checksum</th><th>Download</th></tr>
+</thead>
+<tbody>
+{{- range $version := .Versions}}{{range .Providers}}
+<tr class="provider"><td>{{$version.Version}}{{with $version.Description}} ({{.}}){{end}}</td><td>{{.Name}}</td><td>{{.Architecture}}</td><td>{{if .Size}}{{formatSize .Size}}{{end}}</td><td class="checksum">{{.ChecksumType}}:{{.Checksum}}</td><td><a href="{{boxUrl .Url}}">Download</a></td></tr>
+{{- end}}{{end}}
+</tbody>
+</table>
+</body>
+</html>
+`
+
+// htmlExportFuncs are the functions that HTML export templates can call, in addition to those built in to html/template
+var htmlExportFuncs = template.FuncMap{
+	// formatSize formats a number of bytes for people to read, like "1.5 GiB"
+	"formatSize": util.FormatSize,
+
+	// boxUrl marks the URL of a box file as safe to link to, since html/template would otherwise refuse schemes like file:// and s3://
+	// URLs that could run a script, like javascript: URLs, are replaced with '#'
+	"boxUrl": func(boxUrl string) template.URL {
+		if u, err := url.Parse(boxUrl); err != nil {
+			return "#"
+		} else if scheme := strings.ToLower(u.Scheme); scheme == "javascript" || scheme == "vbscript" || scheme == "data" {
+			return "#"
+		}
+		return template.URL(boxUrl)
+	},
+}
+
+// exportCatalogHtml renders the catalog through DefaultHtmlExportTemplate
+func exportCatalogHtml(catalog *Catalog, writer io.Writer) error {
+	return ExportCatalogHtml(catalog, DefaultHtmlExportTemplate, writer)
+}
+
+// ExportCatalogHtml renders the catalog, with versions sorted semantically, through the html/template in htmlTemplate
+// The template is executed with the Catalog, and can call formatSize with a provider's Size,
+// and boxUrl with a provider's Url to link to it
+func ExportCatalogHtml(catalog *Catalog, htmlTemplate string, writer io.Writer) (err error) {
+	tmpl, err := template.New("export").Funcs(htmlExportFuncs).Parse(htmlTemplate)
+	if err != nil {
+		return fmt.Errorf("Invalid HTML export template: %v", err)
+	}
+	return tmpl.Execute(writer, catalog.Sorted())
+}
@@ -0,0 +1,101 @@
+package caryatid
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExportCatalogCsv(t *testing.T) {
+	catalog := Catalog{Name: "ExportBox", Description: "desc", Versions: []Version{
+		Version{Version: "1.10.0", Providers: []Provider{
+			Provider{Name: "virtualbox", Url: "file:///catalog/ExportBox_1.10.0_virtualbox.box", ChecksumType: "sha256", Checksum: "0xB00B1E5"},
+		}},
+		Version{Version: "1.2.0", Providers: []Provider{
+			Provider{Name: "hyperv, gen2", Url: "file:///catalog/a,b.box", ChecksumType: "sha256", Checksum: "0xDECAFBAD", Architecture: "amd64"},
+			Provider{Name: `say "hi"`, Url: "file:///catalog/c.box", ChecksumType: "md5", Checksum: "0xC0FFEE"},
+		}},
+	}}
+	expected := "name,version,provider,architecture,checksum_type,checksum,url\n" +
+		"ExportBox,1.2.0,\"hyperv, gen2\",amd64,sha256,0xDECAFBAD,\"file:///catalog/a,b.box\"\n" +
+		"ExportBox,1.2.0,\"say \"\"hi\"\"\",,md5,0xC0FFEE,file:///catalog/c.box\n" +
+		"ExportBox,1.10.0,virtualbox,,sha256,0xB00B1E5,file:///catalog/ExportBox_1.10.0_virtualbox.box\n"
+
+	for _, format := range []string{"csv", "CSV", ""} {
+		var buffer bytes.Buffer
+		if err := ExportCatalog(&catalog, format, &buffer); err != nil {
+			t.Fatalf("ExportCatalog() with format '%v' returned an error: %v\n", format, err)
+		}
+		if buffer.String() != expected {
+			t.Fatalf("Expected CSV export with format '%v':\n%v\nBut got:\n%v\n", format, expected, buffer.String())
+		}
+	}
+}
+
+func TestExportCatalogJson(t *testing.T) {
+	var (
+		buffer   bytes.Buffer
+		exported Catalog
+	)
+	if err := ExportCatalog(&testCatalog, "json", &buffer); err != nil {
+		t.Fatalf("ExportCatalog() returned an error: %v\n", err)
+	}
+	if err := json.Unmarshal(buffer.Bytes(), &exported); err != nil {
+		t.Fatalf("Could not unmarshal exported JSON: %v\n", err)
+	}
+	if !exported.FuzzyEquals(&testCatalog, CatalogFuzzyEqualsParams{LogMismatch: true}) {
+		t.Fatalf("Expected exported JSON to match the catalog, but got:\n%v\n", buffer.String())
+	}
+
+	if err := ExportCatalog(&testCatalog, "xml", &buffer); err == nil {
+		t.Fatalf("ExportCatalog() did not fail with an unsupported format\n")
+	}
+}
+
+func TestExportCatalogHtml(t *testing.T) {
+	var buffer bytes.Buffer
+	catalog := Catalog{Name: "<ExportBox>", Description: "desc", Versions: []Version{
+		Version{Version: "1.10.0", Providers: []Provider{
+			Provider{Name: "virtualbox", Url: "file:///catalog/ExportBox_1.10.0_virtualbox.box", ChecksumType: "sha256", Checksum: "0xB00B1E5", Size: 1536},
+		}},
+		Version{Version: "1.2.0", Providers: []Provider{
+			Provider{Name: "hyperv", Url: "https://cdn.example.com/ExportBox_1.2.0_hyperv.box?sig=a&b=c", ChecksumType: "sha256", Checksum: "0xDECAFBAD", Architecture: "amd64"},
+			Provider{Name: "libvirt", Url: "javascript:alert(1)", ChecksumType: "md5", Checksum: "0xC0FFEE"},
+		}},
+	}}
+
+	if err := ExportCatalog(&catalog, "html", &buffer); err != nil {
+		t.Fatalf("ExportCatalog() returned an error: %v\n", err)
+	}
+	html := buffer.String()
+	if rows := strings.Count(html, `<tr class="provider">`); rows != 3 {
+		t.Fatalf("Expected a row for each of 3 providers, but got %v:\n%v\n", rows, html)
+	}
+	for _, expected := range []string{
+		"<title>&lt;ExportBox&gt;</title>",
+		`<a href="file:///catalog/ExportBox_1.10.0_virtualbox.box">`,
+		`<a href="https://cdn.example.com/ExportBox_1.2.0_hyperv.box?sig=a&amp;b=c">`,
+		`<a href="#">`,
+		"sha256:0xB00B1E5",
+		"1.5 KiB",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Fatalf("Expected the HTML export to contain '%v', but got:\n%v\n", expected, html)
+		}
+	}
+	if strings.Index(html, "1.2.0") > strings.Index(html, "1.10.0") {
+		t.Fatalf("Expected versions to be sorted semantically, but got:\n%v\n", html)
+	}
+
+	buffer.Reset()
+	if err := ExportCatalogHtml(&catalog, `{{range .Versions}}<li>{{.Version}}</li>{{end}}`, &buffer); err != nil {
+		t.Fatalf("ExportCatalogHtml() returned an error with a custom template: %v\n", err)
+	}
+	if buffer.String() != "<li>1.2.0</li><li>1.10.0</li>" {
+		t.Fatalf("Unexpected result from a custom template: %v\n", buffer.String())
+	}
+	if err := ExportCatalogHtml(&catalog, `{{range .Versions}`, &buffer); err == nil {
+		t.Fatalf("ExportCatalogHtml() did not fail with an invalid template\n")
+	}
+}
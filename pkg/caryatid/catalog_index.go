@@ -0,0 +1,124 @@
+/*
+An index of the catalogs kept under one directory, for tools like a web portal to find every box without listing the directory
+*/
+
+package caryatid
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// CatalogIndexFilename is the name of the file that WriteCatalogIndex() saves in the directory it indexes
+const CatalogIndexFilename = "index.json"
+
+// CatalogIndex lists the catalogs under one directory, keyed by the name of the box in each
+type CatalogIndex struct {
+	Boxes map[string]CatalogIndexEntry `json:"boxes"`
+}
+
+// CatalogIndexEntry describes one catalog in a CatalogIndex
+type CatalogIndexEntry struct {
+	// The URI of the catalog, like "s3://bucket/boxes/example/testbox.json"
+	CatalogUri string `json:"catalog_uri"`
+
+	// The location of the catalog relative to the index, like "example/testbox.json", for clients that download the index over HTTP
+	Path string `json:"path"`
+
+	// See Catalog.DisplayName
+	DisplayName string `json:"display_name,omitempty"`
+
+	// The number of versions in the catalog
+	Versions int `json:"versions"`
+}
+
+// CatalogIndexUri returns the URI of the index for the directory at rootUri
+func CatalogIndexUri(rootUri string) string {
+	return strings.TrimSuffix(rootUri, "/") + "/" + CatalogIndexFilename
+}
+
+// Marshal serializes the index, formatted in style
+func (index CatalogIndex) Marshal(style JSONStyle) (jsonData []byte, err error) {
+	if index.Boxes == nil {
+		index.Boxes = map[string]CatalogIndexEntry{}
+	}
+	switch style {
+	case "", JSONStylePretty:
+		return json.MarshalIndent(index, "", "  ")
+	case JSONStyleCompact:
+		return json.Marshal(index)
+	}
+	err = fmt.Errorf("Unknown JSON style '%v'; supported styles are: %v", style, JSONStyles())
+	return
+}
+
+// ParseCatalogIndex unmarshals an index saved by WriteCatalogIndex()
+func ParseCatalogIndex(indexBytes []byte) (index CatalogIndex, err error) {
+	if err = json.Unmarshal(indexBytes, &index); err != nil {
+		return
+	}
+	if index.Boxes == nil {
+		err = fmt.Errorf("No boxes field")
+	}
+	return
+}
+
+// BuildCatalogIndex returns an index of every catalog under the directory at bm.CatalogUri; see DiscoverCatalogs()
+// If more than one catalog is for the same box name, the first by URI is indexed, and the others are skipped with a warning
+func (bm *BackendManager) BuildCatalogIndex() (index CatalogIndex, err error) {
+	catalogs, err := bm.DiscoverCatalogs()
+	if err != nil {
+		return
+	}
+	rootPrefix := strings.TrimSuffix(bm.CatalogUri, "/") + "/"
+	index.Boxes = make(map[string]CatalogIndexEntry)
+	for _, found := range catalogs {
+		if existing, ok := index.Boxes[found.Catalog.Name]; ok {
+			bm.log().Warnf("BuildCatalogIndex(): Skipping '%v', because '%v' is already indexed for the box '%v'\n", found.Uri, existing.CatalogUri, found.Catalog.Name)
+			continue
+		}
+		index.Boxes[found.Catalog.Name] = CatalogIndexEntry{
+			CatalogUri:  found.Uri,
+			Path:        strings.TrimPrefix(found.Uri, rootPrefix),
+			DisplayName: found.Catalog.DisplayName,
+			Versions:    len(found.Catalog.Versions),
+		}
+	}
+	return
+}
+
+// WriteCatalogIndex builds the index of every catalog under the directory at bm.CatalogUri, and saves it there as CatalogIndexFilename,
+// formatted in bm.JSONStyle; see BuildCatalogIndex()
+func (bm *BackendManager) WriteCatalogIndex() (index CatalogIndex, err error) {
+	indexUri := CatalogIndexUri(bm.CatalogUri)
+	if bm.ReadOnly {
+		err = fmt.Errorf("%w: refusing to save the catalog index '%v'", ErrBackendReadOnly, indexUri)
+		bm.log().Errorf("WriteCatalogIndex(): %v\n", err)
+		return
+	}
+	if index, err = bm.BuildCatalogIndex(); err != nil {
+		return
+	}
+	jsonData, err := index.Marshal(bm.JSONStyle)
+	if err != nil {
+		return
+	}
+
+	tempFile, err := ioutil.TempFile("", "caryatid-index")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = tempFile.Write(jsonData)
+	tempFile.Close()
+	if err != nil {
+		return
+	}
+	if err = bm.Backend.CopyBoxFile(tempFile.Name(), indexUri); err != nil {
+		bm.log().Errorf("WriteCatalogIndex(): Error saving the catalog index to '%v': %v\n", indexUri, err)
+	}
+	return
+}
@@ -0,0 +1,115 @@
+package caryatid
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestWriteCatalogIndex(t *testing.T) {
+	rootUri := "mem://TestWriteCatalogIndex/boxes"
+	saveCatalog := func(uri string, catalog Catalog) {
+		backend, err := NewBackendFromUri(uri)
+		if err != nil {
+			t.Fatalf("Error getting memory backend: %v\n", err)
+		}
+		if err = NewBackendManager(uri, &backend).SaveCatalog(catalog); err != nil {
+			t.Fatalf("Error saving catalog to '%v': %v\n", uri, err)
+		}
+	}
+	version := func(v string) Version {
+		return Version{Version: v, Providers: []Provider{Provider{Name: "virtualbox", Url: "mem://example.box", ChecksumType: "sha1", Checksum: "0xB00B1E5"}}}
+	}
+
+	saveCatalog(rootUri+"/alpha.json", Catalog{Name: "alpha", Versions: []Version{version("1.0.0"), version("1.1.0")}, DisplayName: "Alpha Box"})
+	saveCatalog(rootUri+"/example/beta.json", Catalog{Name: "example/beta", Versions: []Version{version("2.0.0")}})
+	saveCatalog(rootUri+"/empty.json", Catalog{Name: "empty"})
+	saveCatalog(rootUri+"/old/alpha.json", Catalog{Name: "alpha", Versions: []Version{version("0.1.0")}})
+
+	backend, err := NewBackendFromUri(rootUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(rootUri, &backend)
+	manager.JSONStyle = JSONStyleCompact
+	written, err := manager.WriteCatalogIndex()
+	if err != nil {
+		t.Fatalf("WriteCatalogIndex() returned an error: %v\n", err)
+	}
+
+	// Of two catalogs for the same box, the first by URI is indexed
+	expected := CatalogIndex{map[string]CatalogIndexEntry{
+		"alpha":        CatalogIndexEntry{rootUri + "/alpha.json", "alpha.json", "Alpha Box", 2},
+		"empty":        CatalogIndexEntry{rootUri + "/empty.json", "empty.json", "", 0},
+		"example/beta": CatalogIndexEntry{rootUri + "/example/beta.json", "example/beta.json", "", 1},
+	}}
+	if !reflect.DeepEqual(written, expected) {
+		t.Fatalf("Expected WriteCatalogIndex() to index\n%+v\nbut it indexed\n%+v\n", expected, written)
+	}
+
+	memoryBackendLock.Lock()
+	indexBytes := memoryBackendFiles[CatalogIndexUri(rootUri)]
+	memoryBackendLock.Unlock()
+	expectedJson := fmt.Sprintf(`{"boxes":{"alpha":{"catalog_uri":"%v/alpha.json","path":"alpha.json","display_name":"Alpha Box","versions":2},"empty":{"catalog_uri":"%v/empty.json","path":"empty.json","versions":0},"example/beta":{"catalog_uri":"%v/example/beta.json","path":"example/beta.json","versions":1}}}`, rootUri, rootUri, rootUri)
+	if string(indexBytes) != expectedJson {
+		t.Fatalf("Expected the saved index to be\n%v\nbut it was\n%v\n", expectedJson, string(indexBytes))
+	}
+	parsed, err := ParseCatalogIndex(indexBytes)
+	if err != nil || !reflect.DeepEqual(parsed, expected) {
+		t.Fatalf("Expected the saved index to parse as\n%+v\nbut got\n%+v\n(error %v)\n", expected, parsed, err)
+	}
+
+	// The index is not itself discovered as a catalog, and is rebuilt when a catalog changes
+	boxPath := path.Join(integrationTestDir, "incoming-TestWriteCatalogIndex.box")
+	if err = CreateTestBoxFile(boxPath, "virtualbox", true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	catalogUri := rootUri + "/gamma.json"
+	catalogBackend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	catalogManager := NewBackendManager(catalogUri, &catalogBackend)
+	catalogManager.IndexManager = manager
+	if err = catalogManager.AddBox(BoxArtifact{Path: boxPath, Name: "gamma", Description: "A test box", Version: "3.0.0", Provider: "virtualbox", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("AddBox() returned an error: %v\n", err)
+	}
+	memoryBackendLock.Lock()
+	indexBytes = memoryBackendFiles[CatalogIndexUri(rootUri)]
+	memoryBackendLock.Unlock()
+	if parsed, err = ParseCatalogIndex(indexBytes); err != nil {
+		t.Fatalf("Error parsing the updated index: %v\n", err)
+	}
+	expected.Boxes["gamma"] = CatalogIndexEntry{catalogUri, "gamma.json", "", 1}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Fatalf("Expected AddBox() to update the index to\n%+v\nbut it was\n%+v\n", expected, parsed)
+	}
+
+	manager.ReadOnly = true
+	if _, err = manager.WriteCatalogIndex(); err == nil {
+		t.Fatalf("Expected WriteCatalogIndex() to refuse to write with a read-only manager\n")
+	}
+}
+
+func TestParseCatalogIndex(t *testing.T) {
+	type TestCase struct {
+		Contents    string
+		ExpectError bool
+	}
+	testCases := []TestCase{
+		TestCase{`{"boxes": {}}`, false},
+		TestCase{`{"boxes": {"testbox": {"catalog_uri": "file:///srv/vagrant/testbox.json", "path": "testbox.json", "versions": 3}}}`, false},
+		TestCase{`{"name": "testbox", "versions": []}`, true},
+		TestCase{`{"boxes": ["testbox"]}`, true},
+		TestCase{`not json`, true},
+	}
+	for _, tc := range testCases {
+		_, err := ParseCatalogIndex([]byte(tc.Contents))
+		if tc.ExpectError && err == nil {
+			t.Fatalf("Expected ParseCatalogIndex() to return an error for '%v', but it did not\n", tc.Contents)
+		} else if !tc.ExpectError && err != nil {
+			t.Fatalf("ParseCatalogIndex() returned an unexpected error for '%v': %v\n", tc.Contents, err)
+		}
+	}
+}
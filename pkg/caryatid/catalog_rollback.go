@@ -0,0 +1,132 @@
+/*
+Restoring a catalog from the backups that SaveCatalog() keeps next to it
+*/
+
+package caryatid
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CatalogRollback describes the backup that RollbackCatalog() restored the catalog from
+type CatalogRollback struct {
+	// The URI of the backup, like "file:///srv/vagrant/testbox.json.bak.20240101T120000"
+	BackupUri string
+
+	// The timestamp in the name of the backup, like "20240101T120000", or "20240101T120000.001" for a later backup made in the same second
+	Timestamp string
+
+	// The catalog as it was restored
+	Catalog Catalog
+
+	// Boxes in the restored catalog whose box files are no longer in the backend
+	// Rolling back only restores the catalog, so these box files are not recovered
+	Missing BoxReferenceList
+}
+
+// catalogBackupTimestamp returns the timestamp in the name of a backup, like "20240101T120000"
+func catalogBackupTimestamp(backupUri string) string {
+	idx := strings.LastIndex(backupUri, CatalogBackupSuffix)
+	if idx < 0 {
+		return ""
+	}
+	return backupUri[idx+len(CatalogBackupSuffix):]
+}
+
+// sortedBackupTimestamps returns the timestamps of backups, oldest first
+func sortedBackupTimestamps(backups map[string]string) (timestamps []string) {
+	for timestamp := range backups {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Strings(timestamps)
+	return
+}
+
+// catalogBackups returns the URIs of the backups of the catalog, keyed by their timestamps
+func (bm *BackendManager) catalogBackups() (backups map[string]string, err error) {
+	backuper, ok := bm.backupBackend()
+	if !ok {
+		err = fmt.Errorf("The '%v' backend does not keep backups of the catalog", bm.Backend.Scheme())
+		return
+	}
+	uris, err := backuper.ListCatalogBackups()
+	if err != nil {
+		bm.log().Errorf("catalogBackups(): Error listing backups of '%v': %v\n", bm.CatalogUri, err)
+		return
+	}
+	backups = make(map[string]string)
+	for _, uri := range uris {
+		backups[catalogBackupTimestamp(uri)] = uri
+	}
+	return
+}
+
+// RollbackCatalog replaces the catalog with one of its backups
+// timestamp picks the backup, like "20240101T120000"; if it is empty, the most recent backup is used
+// The backup must parse as a catalog before it replaces anything; see parseCatalogFile()
+// The catalog is saved with SaveCatalog(), so the catalog that was replaced is itself backed up, and the rollback can be undone the same way
+// Only the catalog is restored: box files deleted since the backup was made are not, and are listed in the result with a warning
+// If bm.DryRun is set, the result describes the rollback without saving anything
+func (bm *BackendManager) RollbackCatalog(timestamp string) (rollback CatalogRollback, err error) {
+	unlock, err := bm.lockCatalog()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	backups, err := bm.catalogBackups()
+	if err != nil {
+		return
+	}
+	timestamps := sortedBackupTimestamps(backups)
+	if len(timestamps) == 0 {
+		err = fmt.Errorf("There are no backups of the catalog '%v'", bm.CatalogUri)
+		return
+	}
+	if timestamp == "" {
+		timestamp = timestamps[len(timestamps)-1]
+	} else if _, ok := backups[timestamp]; !ok {
+		err = fmt.Errorf("There is no backup of the catalog '%v' from %v; the backups are from: %v", bm.CatalogUri, timestamp, strings.Join(timestamps, ", "))
+		return
+	}
+	rollback.Timestamp = timestamp
+	rollback.BackupUri = backups[timestamp]
+
+	backupBytes, err := bm.readFile(rollback.BackupUri)
+	if err != nil {
+		bm.log().Errorf("RollbackCatalog(): Error reading the backup '%v': %v\n", rollback.BackupUri, err)
+		return
+	}
+	if rollback.Catalog, err = parseCatalogFile(backupBytes); err != nil {
+		err = fmt.Errorf("The backup '%v' is not a catalog, so it was not restored: %v", rollback.BackupUri, err)
+		bm.log().Errorf("RollbackCatalog(): %v\n", err)
+		return
+	}
+
+	for _, ref := range rollback.Catalog.BoxReferences() {
+		reader, openErr := bm.Backend.OpenFile(bm.storageUri(ref.Uri))
+		if errors.Is(openErr, ErrBoxNotFound) {
+			bm.log().Warnf("RollbackCatalog(): The box file for %v %v at '%v' was deleted, and cannot be recovered by rolling back\n", ref.Version, ref.ProviderName, ref.Uri)
+			rollback.Missing = append(rollback.Missing, ref)
+			continue
+		} else if openErr != nil {
+			bm.log().Warnf("RollbackCatalog(): Could not tell whether the box file for %v %v at '%v' exists: %v\n", ref.Version, ref.ProviderName, ref.Uri, openErr)
+			continue
+		}
+		reader.Close()
+	}
+
+	if bm.DryRun {
+		bm.log().Infof("RollbackCatalog(): Dry run; not restoring '%v'\n", rollback.BackupUri)
+		return
+	}
+	if err = bm.SaveCatalog(rollback.Catalog); err != nil {
+		bm.log().Errorf("RollbackCatalog(): Error saving catalog: %v\n", err)
+		return
+	}
+	bm.log().Infof("RollbackCatalog(): Restored the catalog '%v' from '%v'\n", bm.CatalogUri, rollback.BackupUri)
+	return
+}
@@ -0,0 +1,156 @@
+package caryatid
+
+import (
+	"fmt"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestBackendManagerRollbackCatalog(t *testing.T) {
+	oldBackupTime := catalogBackupTime
+	defer func() { catalogBackupTime = oldBackupTime }()
+	backupTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	catalogBackupTime = func() time.Time {
+		backupTime = backupTime.Add(time.Second)
+		return backupTime
+	}
+
+	var (
+		boxName    = "RollbackBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestBackendManagerRollbackCatalog.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerRollbackCatalog/%v.json", boxName)
+	)
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+
+	if _, err = manager.RollbackCatalog(""); err == nil {
+		t.Fatalf("Expected RollbackCatalog() to return an error when there are no backups\n")
+	}
+
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("AddBox() returned an error: %v\n", err)
+		}
+	}
+	before, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	if _, err = manager.DeleteBox(CatalogQueryParams{Version: "<1.2.0"}); err != nil {
+		t.Fatalf("DeleteBox() returned an error: %v\n", err)
+	}
+
+	manager.DryRun = true
+	rollback, err := manager.RollbackCatalog("")
+	if err != nil {
+		t.Fatalf("RollbackCatalog() returned an error during a dry run: %v\n", err)
+	}
+	if after, _ := manager.Reload(); len(after.Versions) != 1 {
+		t.Fatalf("A dry run restored the catalog:\n%v\n", after.DisplayString())
+	}
+
+	manager.DryRun = false
+	if rollback, err = manager.RollbackCatalog(""); err != nil {
+		t.Fatalf("RollbackCatalog() returned an error: %v\n", err)
+	}
+	if rollback.Timestamp != "20240101T120004" || rollback.BackupUri != catalogUri+".bak.20240101T120004" {
+		t.Fatalf("Expected to roll back to the most recent backup, but rolled back to '%v' (%v)\n", rollback.BackupUri, rollback.Timestamp)
+	}
+	restored, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	if !restored.Equals(&before) {
+		t.Fatalf("Expected the catalog from before the delete\n%v\nbut got\n%v\n", before.DisplayString(), restored.DisplayString())
+	}
+
+	// The catalog is restored, but the deleted box files are not
+	expectedMissing := BoxReferenceList{
+		BoxReference{Version: "1.0.0", ProviderName: "StrongSapling"},
+		BoxReference{Version: "1.1.0", ProviderName: "StrongSapling"},
+	}
+	if len(rollback.Missing) != len(expectedMissing) {
+		t.Fatalf("Expected the box files of %v to be missing, but got %v\n", expectedMissing, rollback.Missing)
+	}
+	for _, ref := range expectedMissing {
+		if !rollback.Missing.Contains(ref) {
+			t.Fatalf("Expected the box file of %v to be missing, but got %v\n", ref, rollback.Missing)
+		}
+	}
+
+	// The rollback backed up the catalog it replaced, so a specific backup undoes the rollback
+	if rollback, err = manager.RollbackCatalog("20240101T120005"); err != nil {
+		t.Fatalf("RollbackCatalog() returned an error: %v\n", err)
+	}
+	if undone, _ := manager.GetCatalog(); len(undone.Versions) != 1 || len(rollback.Missing) != 0 {
+		t.Fatalf("Expected to undo the rollback, but got\n%v\nwith missing box files %v\n", undone.DisplayString(), rollback.Missing)
+	}
+
+	if _, err = manager.RollbackCatalog("19991231T235959"); err == nil {
+		t.Fatalf("Expected RollbackCatalog() to return an error for a backup that does not exist\n")
+	}
+
+	// A backup that does not parse is not swapped in
+	memoryBackendLock.Lock()
+	memoryBackendFiles[catalogUri+".bak.20240101T125959"] = []byte(`{"not": "a catalog"}`)
+	memoryBackendLock.Unlock()
+	if _, err = manager.RollbackCatalog(""); err == nil {
+		t.Fatalf("Expected RollbackCatalog() to refuse a backup that is not a catalog\n")
+	}
+	if current, _ := manager.Reload(); len(current.Versions) != 1 {
+		t.Fatalf("A backup that is not a catalog changed the catalog:\n%v\n", current.DisplayString())
+	}
+}
+
+func TestBackendManagerRollbackCatalogSameSecond(t *testing.T) {
+	var (
+		boxName    = "SameSecondRollbackBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestBackendManagerRollbackCatalogSameSecond.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerRollbackCatalogSameSecond/%v.json", boxName)
+	)
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: version, Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("AddBox() returned an error: %v\n", err)
+		}
+	}
+	before, err := manager.GetCatalog()
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+
+	// With the real clock, the delete and the rollback almost always back up the catalog in the same second,
+	// and the backup the rollback makes must not replace the one it restores
+	if _, err = manager.DeleteBox(CatalogQueryParams{Version: "1.0.0"}); err != nil {
+		t.Fatalf("DeleteBox() returned an error: %v\n", err)
+	}
+	if _, err = manager.RollbackCatalog(""); err != nil {
+		t.Fatalf("RollbackCatalog() returned an error: %v\n", err)
+	}
+	if restored, _ := manager.Reload(); !restored.Equals(&before) {
+		t.Fatalf("Expected the catalog from before the delete\n%v\nbut got\n%v\n", before.DisplayString(), restored.DisplayString())
+	}
+
+	// Rolling back again restores the backup the first rollback made, undoing it
+	if _, err = manager.RollbackCatalog(""); err != nil {
+		t.Fatalf("RollbackCatalog() returned an error: %v\n", err)
+	}
+	if undone, _ := manager.Reload(); len(undone.Versions) != 1 || undone.Versions[0].Version != "1.1.0" {
+		t.Fatalf("Expected to undo the rollback, but got\n%v\n", undone.DisplayString())
+	}
+}
@@ -0,0 +1,240 @@
+/*
+A JSON Schema for serialized catalogs, and a validator for the parts of JSON Schema that it uses
+*/
+
+package caryatid
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CatalogJSONSchema describes every field of a catalog that caryatid writes, as a JSON Schema (draft 7)
+// It is stricter than ValidateVagrantSchema(): fields that neither Vagrant nor caryatid know about are violations,
+// which catches misspelled optional fields that both would silently ignore
+// Fields added to Catalog, Version, or Provider must be added here too
+// ValidateCatalogJSONSchema() only understands the keywords used here; see validateJSONSchema()
+const CatalogJSONSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "Vagrant catalog, as written by caryatid",
+  "type": "object",
+  "required": ["name"],
+  "additionalProperties": false,
+  "properties": {
+    "name": {"type": "string", "minLength": 1},
+    "display_name": {"type": "string"},
+    "description": {"type": "string"},
+    "caryatid_schema_version": {"type": "integer", "minimum": 0},
+    "versions": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "required": ["version", "providers"],
+        "additionalProperties": false,
+        "properties": {
+          "version": {"type": "string", "minLength": 1},
+          "description": {"type": "string"},
+          "tags": {
+            "type": "array",
+            "items": {"type": "string", "minLength": 1}
+          },
+          "providers": {
+            "type": "array",
+            "minItems": 1,
+            "items": {
+              "type": "object",
+              "required": ["name", "url", "checksum_type", "checksum"],
+              "additionalProperties": false,
+              "properties": {
+                "name": {"type": "string", "minLength": 1},
+                "url": {"type": "string", "minLength": 1},
+                "checksum_type": {"enum": ["md5", "sha1", "sha256", "sha384", "sha512"]},
+                "checksum": {"type": "string", "minLength": 1},
+                "architecture": {"type": "string"},
+                "size": {"type": "integer", "minimum": 0},
+                "released_at": {"type": "string", "format": "date-time"},
+                "labels": {
+                  "type": "object",
+                  "additionalProperties": {"type": "string"}
+                },
+                "checksums": {
+                  "type": "array",
+                  "items": {
+                    "type": "object",
+                    "required": ["type", "value"],
+                    "additionalProperties": false,
+                    "properties": {
+                      "type": {"enum": ["md5", "sha1", "sha256", "sha384", "sha512"]},
+                      "value": {"type": "string", "minLength": 1}
+                    }
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+// ValidateCatalogJSONSchema checks a serialized catalog against CatalogJSONSchema, and returns every violation it finds
+func ValidateCatalogJSONSchema(catalogBytes []byte) (violations []SchemaViolation) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(CatalogJSONSchema), &schema); err != nil {
+		panic(fmt.Sprintf("CatalogJSONSchema is not valid JSON: %v", err))
+	}
+	var raw interface{}
+	if err := json.Unmarshal(catalogBytes, &raw); err != nil {
+		return []SchemaViolation{SchemaViolation{"$", fmt.Sprintf("Not valid JSON: %v", err)}}
+	}
+	return validateJSONSchema(schema, raw, "$")
+}
+
+// validateJSONSchema checks a value decoded from JSON against a schema, and returns every violation it finds
+// It understands the keywords type, enum, required, properties, additionalProperties, items, minItems, minLength, minimum,
+// and the date-time format, and ignores the rest
+// A value of the wrong type is not checked any further
+func validateJSONSchema(schema map[string]interface{}, value interface{}, valuePath string) (violations []SchemaViolation) {
+	if types, ok := schema["type"]; ok && !jsonSchemaTypeMatches(types, value) {
+		return []SchemaViolation{SchemaViolation{valuePath, fmt.Sprintf("Must be of type %v", strings.Join(jsonSchemaTypeNames(types), " or "))}}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || reflect.DeepEqual(allowed, value)
+		}
+		if !found {
+			violations = append(violations, SchemaViolation{valuePath, fmt.Sprintf("Must be one of %v, but is %v", jsonSchemaList(enum), jsonSchemaValue(value))})
+		}
+	}
+
+	switch typed := value.(type) {
+	case string:
+		if minLength, ok := schema["minLength"].(float64); ok && float64(len(typed)) < minLength {
+			violations = append(violations, SchemaViolation{valuePath, fmt.Sprintf("Must be at least %v characters long", minLength)})
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, typed); err != nil {
+				violations = append(violations, SchemaViolation{valuePath, fmt.Sprintf("Must be an RFC 3339 timestamp, but is '%v'", typed)})
+			}
+		}
+
+	case float64:
+		if minimum, ok := schema["minimum"].(float64); ok && typed < minimum {
+			violations = append(violations, SchemaViolation{valuePath, fmt.Sprintf("Must be at least %v", minimum)})
+		}
+
+	case []interface{}:
+		if minItems, ok := schema["minItems"].(float64); ok && float64(len(typed)) < minItems {
+			violations = append(violations, SchemaViolation{valuePath, fmt.Sprintf("Must have at least %v items", minItems)})
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for idx, item := range typed {
+				violations = append(violations, validateJSONSchema(items, item, fmt.Sprintf("%v[%v]", valuePath, idx))...)
+			}
+		}
+
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, field := range required {
+				if _, present := typed[field.(string)]; !present {
+					violations = append(violations, SchemaViolation{fmt.Sprintf("%v.%v", valuePath, field), "Required field is missing"})
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		fields := []string{}
+		for field := range typed {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			fieldPath := fmt.Sprintf("%v.%v", valuePath, field)
+			if property, ok := properties[field].(map[string]interface{}); ok {
+				violations = append(violations, validateJSONSchema(property, typed[field], fieldPath)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					violations = append(violations, SchemaViolation{fieldPath, "Unknown field"})
+				}
+			case map[string]interface{}:
+				violations = append(violations, validateJSONSchema(additional, typed[field], fieldPath)...)
+			}
+		}
+	}
+
+	return
+}
+
+// jsonSchemaTypeMatches returns true if value has one of the JSON Schema types in types, which is a type name or a list of them
+func jsonSchemaTypeMatches(types interface{}, value interface{}) bool {
+	for _, typeName := range jsonSchemaTypeNames(types) {
+		switch typed := value.(type) {
+		case nil:
+			if typeName == "null" {
+				return true
+			}
+		case bool:
+			if typeName == "boolean" {
+				return true
+			}
+		case string:
+			if typeName == "string" {
+				return true
+			}
+		case float64:
+			if typeName == "number" || (typeName == "integer" && typed == math.Trunc(typed)) {
+				return true
+			}
+		case []interface{}:
+			if typeName == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if typeName == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonSchemaTypeNames returns the type names in the type keyword of a schema, which may be a single name or a list of them
+func jsonSchemaTypeNames(types interface{}) (names []string) {
+	switch typed := types.(type) {
+	case string:
+		names = []string{typed}
+	case []interface{}:
+		for _, name := range typed {
+			names = append(names, fmt.Sprintf("%v", name))
+		}
+	}
+	return
+}
+
+// jsonSchemaList formats the values of an enum for a violation message, like 'md5', 'sha1'
+func jsonSchemaList(values []interface{}) string {
+	formatted := []string{}
+	for _, value := range values {
+		formatted = append(formatted, jsonSchemaValue(value))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// jsonSchemaValue formats a value decoded from JSON for a violation message
+func jsonSchemaValue(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return strings.Replace(string(encoded), `"`, "'", -1)
+}
@@ -0,0 +1,170 @@
+package caryatid
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestValidateCatalogJSONSchema(t *testing.T) {
+	// validCatalog returns a fresh catalog that matches the schema, with every optional field, so that each test case can break it differently
+	validCatalog := func() map[string]interface{} {
+		return map[string]interface{}{
+			"name":                    "ValidBox",
+			"description":             "A box that matches the schema",
+			"caryatid_schema_version": 1,
+			"versions": []interface{}{
+				map[string]interface{}{
+					"version":     "1.0.0",
+					"description": "The first release",
+					"providers": []interface{}{
+						map[string]interface{}{
+							"name":          "virtualbox",
+							"url":           "https://example.com/ValidBox_1.0.0_virtualbox.box",
+							"checksum_type": "sha256",
+							"checksum":      "0xB00B1E5",
+							"architecture":  "amd64",
+							"size":          1024,
+							"released_at":   "2017-06-01T12:00:00Z",
+							"labels":        map[string]interface{}{"build": "42"},
+							"checksums": []interface{}{
+								map[string]interface{}{"type": "sha256", "value": "0xB00B1E5"},
+								map[string]interface{}{"type": "md5", "value": "0xDEC0DE"},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	firstVersion := func(c map[string]interface{}) map[string]interface{} {
+		return c["versions"].([]interface{})[0].(map[string]interface{})
+	}
+	firstProvider := func(c map[string]interface{}) map[string]interface{} {
+		return firstVersion(c)["providers"].([]interface{})[0].(map[string]interface{})
+	}
+
+	type TestCase struct {
+		Description  string
+		Break        func(map[string]interface{})
+		ExpectedPath string
+	}
+	testCases := []TestCase{
+		TestCase{"valid", func(c map[string]interface{}) {}, ""},
+		TestCase{"no versions", func(c map[string]interface{}) { delete(c, "versions") }, ""},
+		TestCase{"null versions", func(c map[string]interface{}) { c["versions"] = nil }, ""},
+		TestCase{"no optional fields", func(c map[string]interface{}) {
+			for _, field := range []string{"architecture", "size", "released_at", "labels", "checksums"} {
+				delete(firstProvider(c), field)
+			}
+		}, ""},
+		TestCase{"missing name", func(c map[string]interface{}) { delete(c, "name") }, "$.name"},
+		TestCase{"unknown catalog field", func(c map[string]interface{}) { c["homepage"] = "https://example.com" }, "$.homepage"},
+		TestCase{"versions not an array", func(c map[string]interface{}) { c["versions"] = "1.0.0" }, "$.versions"},
+		TestCase{"fractional schema version", func(c map[string]interface{}) { c["caryatid_schema_version"] = 1.5 }, "$.caryatid_schema_version"},
+		TestCase{"empty version", func(c map[string]interface{}) { firstVersion(c)["version"] = "" }, "$.versions[0].version"},
+		TestCase{"empty providers", func(c map[string]interface{}) { firstVersion(c)["providers"] = []interface{}{} }, "$.versions[0].providers"},
+		TestCase{"missing url", func(c map[string]interface{}) { delete(firstProvider(c), "url") }, "$.versions[0].providers[0].url"},
+		TestCase{"misspelled optional field", func(c map[string]interface{}) {
+			firstProvider(c)["arch"] = firstProvider(c)["architecture"]
+			delete(firstProvider(c), "architecture")
+		}, "$.versions[0].providers[0].arch"},
+		TestCase{"unsupported checksum_type", func(c map[string]interface{}) { firstProvider(c)["checksum_type"] = "crc32" }, "$.versions[0].providers[0].checksum_type"},
+		TestCase{"size not a number", func(c map[string]interface{}) { firstProvider(c)["size"] = "1 KiB" }, "$.versions[0].providers[0].size"},
+		TestCase{"negative size", func(c map[string]interface{}) { firstProvider(c)["size"] = -1 }, "$.versions[0].providers[0].size"},
+		TestCase{"released_at not a timestamp", func(c map[string]interface{}) { firstProvider(c)["released_at"] = "last tuesday" }, "$.versions[0].providers[0].released_at"},
+		TestCase{"label not a string", func(c map[string]interface{}) { firstProvider(c)["labels"] = map[string]interface{}{"build": 42} }, "$.versions[0].providers[0].labels.build"},
+		TestCase{"checksum without a value", func(c map[string]interface{}) {
+			delete(firstProvider(c)["checksums"].([]interface{})[1].(map[string]interface{}), "value")
+		}, "$.versions[0].providers[0].checksums[1].value"},
+	}
+
+	for _, tc := range testCases {
+		catalog := validCatalog()
+		tc.Break(catalog)
+		catalogBytes, err := json.Marshal(catalog)
+		if err != nil {
+			t.Fatalf("Error marshalling catalog for test case '%v': %v\n", tc.Description, err)
+		}
+		violations := ValidateCatalogJSONSchema(catalogBytes)
+		if tc.ExpectedPath == "" {
+			if len(violations) != 0 {
+				t.Fatalf("Expected no violations for test case '%v', but got %v\n", tc.Description, violations)
+			}
+		} else if len(violations) != 1 || violations[0].Path != tc.ExpectedPath {
+			t.Fatalf("Expected exactly one violation at '%v' for test case '%v', but got %v\n", tc.ExpectedPath, tc.Description, violations)
+		}
+	}
+
+	for _, invalid := range []string{"not json", `["a", "list"]`} {
+		if violations := ValidateCatalogJSONSchema([]byte(invalid)); len(violations) != 1 || violations[0].Path != "$" {
+			t.Fatalf("Expected one violation at '$' for '%v', but got %v\n", invalid, violations)
+		}
+	}
+}
+
+// The schema must accept whatever caryatid itself writes, and keep up with the checksum types it supports
+func TestCatalogJSONSchemaMatchesCatalog(t *testing.T) {
+	var (
+		boxName = "TestCatalogJSONSchemaBox"
+		boxPath = path.Join(integrationTestDir, "incoming-TestCatalogJSONSchemaBox.box")
+		uri     = fmt.Sprintf("mem://TestCatalogJSONSchemaMatchesCatalog/%v.json", boxName)
+	)
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(uri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(uri, &backend)
+	artifact, err := DeriveArtifactInfoFromBoxFile(boxPath, "sha256,md5")
+	if err != nil {
+		t.Fatalf("Error deriving artifact info: %v\n", err)
+	}
+	artifact.Name = boxName
+	artifact.Description = "desc"
+	artifact.Version = "1.0.0"
+	artifact.VersionDescription = "The first release"
+	artifact.Architecture = "amd64"
+	artifact.Labels = map[string]string{"build": "42"}
+	if err = manager.AddBox(artifact); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+
+	violations, err := manager.ValidateCatalogSchema()
+	if err != nil {
+		t.Fatalf("ValidateCatalogSchema() returned an error: %v\n", err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("Expected a catalog caryatid wrote to match the schema, but got %v\n", violations)
+	}
+
+	var schema struct {
+		Properties struct {
+			Versions struct {
+				Items struct {
+					Properties struct {
+						Providers struct {
+							Items struct {
+								Properties struct {
+									ChecksumType struct {
+										Enum []string `json:"enum"`
+									} `json:"checksum_type"`
+								} `json:"properties"`
+							} `json:"items"`
+						} `json:"providers"`
+					} `json:"properties"`
+				} `json:"items"`
+			} `json:"versions"`
+		} `json:"properties"`
+	}
+	if err = json.Unmarshal([]byte(CatalogJSONSchema), &schema); err != nil {
+		t.Fatalf("CatalogJSONSchema is not valid JSON: %v\n", err)
+	}
+	if enum := schema.Properties.Versions.Items.Properties.Providers.Items.Properties.ChecksumType.Enum; !reflect.DeepEqual(enum, ChecksumTypes()) {
+		t.Fatalf("Expected the schema to allow the checksum types %v, but it allows %v\n", ChecksumTypes(), enum)
+	}
+}
@@ -0,0 +1,296 @@
+/*
+An HTTP server for a catalog and the box files it refers to
+
+This lets Vagrant use a catalog from any backend without a separate web server
+*/
+
+package caryatid
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Box files are served beneath this path, at /boxes/<version>/<provider> or /boxes/<version>/<provider>/<architecture>
+const catalogServerBoxPrefix = "/boxes/"
+
+// CatalogServer is an http.Handler that serves a catalog, and streams its box files from the backend
+// Provider URLs in the served catalog are rewritten to point at the server itself
+type CatalogServer struct {
+	Manager *BackendManager
+
+	// If set, and the backend can sign URLs, provider URLs are instead signed URLs that expire after UrlTtl,
+	// so that clients download box files directly from the backend; see BackendManager.SignCatalogUrls()
+	UrlTtl time.Duration
+
+	// If AuthUser is set, every request must use HTTP basic authentication with AuthUser and AuthPass
+	AuthUser string
+	AuthPass string
+}
+
+func NewCatalogServer(manager *BackendManager) *CatalogServer {
+	return &CatalogServer{Manager: manager}
+}
+
+// CatalogPath returns the path at which the catalog is served,
+// which is the file name from the catalog URI, like "/mybox.json"
+func (server *CatalogServer) CatalogPath() string {
+	return "/" + path.Base(server.Manager.CatalogUri)
+}
+
+func (server *CatalogServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !server.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="caryatid", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case r.URL.Path == server.CatalogPath():
+		server.serveCatalog(w, r)
+	case strings.HasPrefix(r.URL.Path, catalogServerBoxPrefix):
+		server.serveBox(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorized returns true if the request has the right credentials, or if the server does not require any
+func (server *CatalogServer) authorized(r *http.Request) bool {
+	if server.AuthUser == "" {
+		return true
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	// Comparing digests, which are always the same length, does not leak the length of the credentials either
+	userMatch := constantTimeEqual(user, server.AuthUser)
+	passMatch := constantTimeEqual(pass, server.AuthPass)
+	return userMatch && passMatch
+}
+
+// constantTimeEqual compares two strings in an amount of time that does not depend on their contents
+func constantTimeEqual(s1 string, s2 string) bool {
+	digest1 := sha256.Sum256([]byte(s1))
+	digest2 := sha256.Sum256([]byte(s2))
+	return subtle.ConstantTimeCompare(digest1[:], digest2[:]) == 1
+}
+
+// catalogServerBoxPath returns the path at which a box is served
+func catalogServerBoxPath(ref BoxReference) string {
+	segments := []string{url.PathEscape(ref.Version), url.PathEscape(ref.ProviderName)}
+	if ref.Architecture != "" {
+		segments = append(segments, url.PathEscape(ref.Architecture))
+	}
+	return catalogServerBoxPrefix + strings.Join(segments, "/")
+}
+
+// catalogServerBaseUrl returns the URL of the server as the client sees it, like "http://example.com:8099"
+func catalogServerBaseUrl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (server *CatalogServer) serveCatalog(w http.ResponseWriter, r *http.Request) {
+	// Read the catalog afresh for each request, rather than from the manager's cache, so that boxes added by other processes are served
+	catalog, err := server.Manager.Reload()
+	if errors.Is(err, ErrCatalogNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		server.Manager.log().Errorf("CatalogServer: Error retrieving catalog from backend: %v\n", err)
+		http.Error(w, "Could not retrieve catalog", http.StatusInternalServerError)
+		return
+	}
+
+	if server.UrlTtl > 0 && server.Manager.CanSignUrls() {
+		if err = server.Manager.SignCatalogUrls(&catalog, server.UrlTtl); err != nil {
+			http.Error(w, "Could not sign box URLs", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		baseUrl := catalogServerBaseUrl(r)
+		for vIdx := range catalog.Versions {
+			version := &catalog.Versions[vIdx]
+			for pIdx := range version.Providers {
+				provider := &version.Providers[pIdx]
+				ref := BoxReference{Version: version.Version, ProviderName: provider.Name, Architecture: provider.Architecture}
+				provider.Url = baseUrl + catalogServerBoxPath(ref)
+			}
+		}
+	}
+
+	jsonData, err := json.MarshalIndent(catalog.Sorted(), "", "  ")
+	if err != nil {
+		server.Manager.log().Errorf("CatalogServer: Error trying to marshal catalog: %v\n", err)
+		http.Error(w, "Could not serialize catalog", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+func (server *CatalogServer) serveBox(w http.ResponseWriter, r *http.Request) {
+	// Use the escaped path so that a provider name containing an escaped slash stays in one segment
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), catalogServerBoxPrefix), "/")
+	if len(segments) < 2 || len(segments) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	for idx, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		segments[idx] = unescaped
+	}
+	ref := BoxReference{Version: segments[0], ProviderName: segments[1]}
+	if len(segments) == 3 {
+		ref.Architecture = segments[2]
+	}
+
+	catalog, err := server.Manager.Reload()
+	if errors.Is(err, ErrCatalogNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		server.Manager.log().Errorf("CatalogServer: Error retrieving catalog from backend: %v\n", err)
+		http.Error(w, "Could not retrieve catalog", http.StatusInternalServerError)
+		return
+	}
+	boxUri := ""
+	for _, candidate := range catalog.BoxReferences() {
+		if candidate.Equals(ref) {
+			boxUri = candidate.Uri
+			break
+		}
+	}
+	if boxUri == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	storageUri := server.Manager.storageUri(boxUri)
+	server.Manager.log().Debugf("CatalogServer: Serving '%v' for %v\n", boxUri, r.URL.Path)
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	// Range requests, which Vagrant uses to resume downloads, need a reader that can seek
+	// Backends that only stream their files, like S3, are read with ranged requests instead,
+	// so that neither a HEAD request nor a Range request downloads the whole box file
+	if ranger, ok := server.Manager.rangeBackend(); ok {
+		size, err := ranger.FileSize(storageUri)
+		if err != nil {
+			server.boxFileError(w, r, boxUri, err)
+			return
+		}
+		content := &rangeReadSeeker{backend: ranger, uri: storageUri, size: size}
+		defer content.Close()
+		http.ServeContent(w, r, path.Base(boxUri), time.Time{}, content)
+		return
+	}
+
+	reader, err := server.Manager.Backend.OpenFile(storageUri)
+	if err != nil {
+		server.boxFileError(w, r, boxUri, err)
+		return
+	}
+	defer reader.Close()
+	if content, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(boxUri), time.Time{}, content)
+		return
+	}
+
+	// Any other backend's box files are streamed whole, without support for Range requests
+	w.Header().Set("Accept-Ranges", "none")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err = io.Copy(w, reader); err != nil {
+		server.Manager.log().Errorf("CatalogServer: Error streaming box file '%v': %v\n", boxUri, err)
+	}
+}
+
+// boxFileError responds to an error opening a box file, with 404 if the box file does not exist
+func (server *CatalogServer) boxFileError(w http.ResponseWriter, r *http.Request, boxUri string, err error) {
+	if errors.Is(err, ErrBoxNotFound) || errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	server.Manager.log().Errorf("CatalogServer: Error opening box file '%v': %v\n", boxUri, err)
+	http.Error(w, "Could not open box file", http.StatusInternalServerError)
+}
+
+// rangeReadSeeker reads a box file from a CaryatidRangeBackend as an io.ReadSeeker, for http.ServeContent()
+// Seeking only moves the offset, and the next Read() opens the file from there,
+// so only the parts of the file that are read are downloaded
+type rangeReadSeeker struct {
+	backend CaryatidRangeBackend
+	uri     string
+	size    int64
+	offset  int64
+	reader  io.ReadCloser
+}
+
+func (content *rangeReadSeeker) Read(p []byte) (n int, err error) {
+	if content.offset >= content.size {
+		return 0, io.EOF
+	}
+	if content.reader == nil {
+		reader, err := content.backend.OpenFileRange(content.uri, content.offset)
+		if err != nil {
+			return 0, err
+		}
+		content.reader = reader
+	}
+	n, err = content.reader.Read(p)
+	content.offset += int64(n)
+	return
+}
+
+func (content *rangeReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += content.offset
+	case io.SeekEnd:
+		offset += content.size
+	default:
+		return 0, fmt.Errorf("Invalid whence %v", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("Cannot seek to negative offset %v", offset)
+	}
+	if offset != content.offset {
+		content.Close()
+		content.offset = offset
+	}
+	return offset, nil
+}
+
+// Close closes the reader that is open at the current offset, if there is one
+func (content *rangeReadSeeker) Close() (err error) {
+	if content.reader != nil {
+		err = content.reader.Close()
+		content.reader = nil
+	}
+	return
+}
@@ -0,0 +1,287 @@
+package caryatid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCatalogServer(t *testing.T) {
+	var (
+		boxName    = "TestCatalogServerBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestCatalogServerBox.box")
+		catalogUri = fmt.Sprintf("mem://TestCatalogServer/%v.json", boxName)
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	boxContents, err := ioutil.ReadFile(boxPath)
+	if err != nil {
+		t.Fatalf("Error reading test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "1.0.0", Provider: "StrongSapling", Architecture: "arm64", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+
+	server := httptest.NewServer(NewCatalogServer(manager))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/" + boxName + ".json")
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 for the catalog, but got %v\n", response.StatusCode)
+	}
+	var catalog Catalog
+	if err = json.NewDecoder(response.Body).Decode(&catalog); err != nil {
+		t.Fatalf("Error decoding served catalog: %v\n", err)
+	}
+	if len(catalog.Versions) != 1 || len(catalog.Versions[0].Providers) != 1 {
+		t.Fatalf("Unexpected served catalog:\n%v", catalog.DisplayString())
+	}
+	expectedUrl := server.URL + "/boxes/1.0.0/StrongSapling/arm64"
+	if boxUrl := catalog.Versions[0].Providers[0].Url; boxUrl != expectedUrl {
+		t.Fatalf("Expected the provider URL to be rewritten to '%v', but got '%v'\n", expectedUrl, boxUrl)
+	}
+
+	request, _ := http.NewRequest("GET", expectedUrl, nil)
+	request.Header.Set("Range", "bytes=10-19")
+	rangeResponse, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Error getting box file: %v\n", err)
+	}
+	defer rangeResponse.Body.Close()
+	if rangeResponse.StatusCode != http.StatusPartialContent {
+		t.Fatalf("Expected status 206 for a range request, but got %v\n", rangeResponse.StatusCode)
+	}
+	rangeContents, err := ioutil.ReadAll(rangeResponse.Body)
+	if err != nil {
+		t.Fatalf("Error reading box file: %v\n", err)
+	}
+	if string(rangeContents) != string(boxContents[10:20]) {
+		t.Fatalf("Expected bytes 10-19 of the box file, but got %v\n", rangeContents)
+	}
+
+	for _, missingPath := range []string{"/boxes/1.0.0/StrongSapling", "/boxes/2.0.0/StrongSapling/arm64", "/other.json"} {
+		missingResponse, err := http.Get(server.URL + missingPath)
+		if err != nil {
+			t.Fatalf("Error getting '%v': %v\n", missingPath, err)
+		}
+		missingResponse.Body.Close()
+		if missingResponse.StatusCode != http.StatusNotFound {
+			t.Fatalf("Expected status 404 for '%v', but got %v\n", missingPath, missingResponse.StatusCode)
+		}
+	}
+
+	postResponse, err := http.Post(server.URL+"/"+boxName+".json", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Error posting to catalog: %v\n", err)
+	}
+	postResponse.Body.Close()
+	if postResponse.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected status 405 for a POST, but got %v\n", postResponse.StatusCode)
+	}
+
+	// Boxes that another process adds are served without restarting the server
+	otherBackend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	other := NewBackendManager(catalogUri, &otherBackend)
+	if err = other.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "2.0.0", Provider: "StrongSapling", Architecture: "arm64", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+	addedResponse, err := http.Get(server.URL + "/boxes/2.0.0/StrongSapling/arm64")
+	if err != nil {
+		t.Fatalf("Error getting box file: %v\n", err)
+	}
+	addedResponse.Body.Close()
+	if addedResponse.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 for a box added by another process, but got %v\n", addedResponse.StatusCode)
+	}
+}
+
+// Box files from a backend that cannot seek are read with ranged requests, rather than downloaded whole for every request
+func TestCatalogServerRangeBackend(t *testing.T) {
+	var (
+		lock        sync.Mutex
+		boxRequests []string
+		boxContents = []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/RangeBox.json":
+			fmt.Fprintf(w, `{"name": "RangeBox", "versions": [{"version": "1.0.0", "providers": [{"name": "StrongSapling", "url": "http://%v/RangeBox/box.box"}]}]}`, r.Host)
+		case "/RangeBox/box.box":
+			lock.Lock()
+			boxRequests = append(boxRequests, strings.TrimSpace(r.Method+" "+r.Header.Get("Range")))
+			lock.Unlock()
+			http.ServeContent(w, r, "box.box", time.Time{}, bytes.NewReader(boxContents))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	catalogUri := upstream.URL + "/RangeBox.json"
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting HTTP backend: %v\n", err)
+	}
+	backend = NewRetryBackend(backend, 1)
+	manager := NewBackendManager(catalogUri, &backend)
+	server := httptest.NewServer(NewCatalogServer(manager))
+	defer server.Close()
+	boxUrl := server.URL + "/boxes/1.0.0/StrongSapling"
+
+	headResponse, err := http.Head(boxUrl)
+	if err != nil {
+		t.Fatalf("Error getting box file: %v\n", err)
+	}
+	headResponse.Body.Close()
+	if headResponse.StatusCode != http.StatusOK || headResponse.ContentLength != int64(len(boxContents)) {
+		t.Fatalf("Expected status 200 and the size of the box file for a HEAD request, but got %v and %v\n", headResponse.StatusCode, headResponse.ContentLength)
+	}
+
+	request, _ := http.NewRequest("GET", boxUrl, nil)
+	request.Header.Set("Range", "bytes=10-19")
+	rangeResponse, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Error getting box file: %v\n", err)
+	}
+	rangeContents, err := ioutil.ReadAll(rangeResponse.Body)
+	rangeResponse.Body.Close()
+	if err != nil || rangeResponse.StatusCode != http.StatusPartialContent || string(rangeContents) != string(boxContents[10:20]) {
+		t.Fatalf("Expected status 206 and bytes 10-19 of the box file, but got %v and '%v' (error %v)\n", rangeResponse.StatusCode, string(rangeContents), err)
+	}
+
+	fullResponse, err := http.Get(boxUrl)
+	if err != nil {
+		t.Fatalf("Error getting box file: %v\n", err)
+	}
+	fullContents, err := ioutil.ReadAll(fullResponse.Body)
+	fullResponse.Body.Close()
+	if err != nil || string(fullContents) != string(boxContents) {
+		t.Fatalf("Expected the whole box file, but got '%v' (error %v)\n", string(fullContents), err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	expected := []string{"HEAD", "HEAD", "GET bytes=10-", "HEAD", "GET bytes=0-"}
+	if !reflect.DeepEqual(boxRequests, expected) {
+		t.Fatalf("Expected the box file to be requested with %v, but it was requested with %v\n", expected, boxRequests)
+	}
+}
+
+func TestCatalogServerSignedUrls(t *testing.T) {
+	catalogUri := "mem://TestCatalogServerSignedUrls/TestSignedBox.json"
+	boxUri := "mem://TestCatalogServerSignedUrls/TestSignedBox/TestSignedBox_1.0.0_StrongSapling.box"
+
+	var backend CaryatidBackend = &signingTestBackend{}
+	manager := NewBackendManager(catalogUri, &backend)
+	catalog := Catalog{Name: "TestSignedBox", Description: "desc", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{
+			Provider{Name: "StrongSapling", Url: boxUri, ChecksumType: "sha256", Checksum: "0xB00B1E5"},
+		}},
+	}}
+	if err := manager.SaveCatalog(catalog); err != nil {
+		t.Fatalf("Error saving catalog: %v\n", err)
+	}
+
+	catalogServer := NewCatalogServer(manager)
+	catalogServer.UrlTtl = 15 * time.Minute
+	server := httptest.NewServer(catalogServer)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/TestSignedBox.json")
+	if err != nil {
+		t.Fatalf("Error getting catalog: %v\n", err)
+	}
+	defer response.Body.Close()
+	var served Catalog
+	if err = json.NewDecoder(response.Body).Decode(&served); err != nil {
+		t.Fatalf("Error decoding served catalog: %v\n", err)
+	}
+	expectedUrl := boxUri + "?ttl=15m0s"
+	if len(served.Versions) != 1 || served.Versions[0].Providers[0].Url != expectedUrl {
+		t.Fatalf("Expected the served catalog to refer to the signed URL '%v', but got:\n%v", expectedUrl, served.DisplayString())
+	}
+}
+
+func TestCatalogServerBasicAuth(t *testing.T) {
+	var (
+		boxName    = "TestCatalogServerAuthBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestCatalogServerAuthBox.box")
+		catalogUri = fmt.Sprintf("mem://TestCatalogServerBasicAuth/%v.json", boxName)
+	)
+
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "1.0.0", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+
+	catalogServer := NewCatalogServer(manager)
+	catalogServer.AuthUser = "vagrant"
+	catalogServer.AuthPass = "correct horse"
+	server := httptest.NewServer(catalogServer)
+	defer server.Close()
+
+	type TestCase struct {
+		Path           string
+		User           string
+		Pass           string
+		SendAuth       bool
+		ExpectedStatus int
+	}
+	testCases := []TestCase{
+		{"/" + boxName + ".json", "", "", false, http.StatusUnauthorized},
+		{"/" + boxName + ".json", "vagrant", "wrong", true, http.StatusUnauthorized},
+		{"/" + boxName + ".json", "other", "correct horse", true, http.StatusUnauthorized},
+		{"/" + boxName + ".json", "vagrant", "correct horse", true, http.StatusOK},
+		{"/boxes/1.0.0/StrongSapling", "", "", false, http.StatusUnauthorized},
+		{"/boxes/1.0.0/StrongSapling", "vagrant", "wrong", true, http.StatusUnauthorized},
+		{"/boxes/1.0.0/StrongSapling", "vagrant", "correct horse", true, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		request, _ := http.NewRequest("GET", server.URL+tc.Path, nil)
+		if tc.SendAuth {
+			request.SetBasicAuth(tc.User, tc.Pass)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Error getting '%v': %v\n", tc.Path, err)
+		}
+		response.Body.Close()
+		if response.StatusCode != tc.ExpectedStatus {
+			t.Fatalf("Expected status %v for '%v' as '%v'/'%v', but got %v\n", tc.ExpectedStatus, tc.Path, tc.User, tc.Pass, response.StatusCode)
+		}
+		if tc.ExpectedStatus == http.StatusUnauthorized && !strings.HasPrefix(response.Header.Get("WWW-Authenticate"), "Basic ") {
+			t.Fatalf("Expected a WWW-Authenticate header for '%v', but got '%v'\n", tc.Path, response.Header.Get("WWW-Authenticate"))
+		}
+	}
+}
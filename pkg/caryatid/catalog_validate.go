@@ -0,0 +1,109 @@
+/*
+Validation of serialized catalogs against the metadata format that Vagrant expects
+*/
+
+package caryatid
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SchemaViolation describes one way that a serialized catalog does not match what Vagrant expects
+// Path is a JSON path to the offending value, like '$.versions[0].providers[1].url'
+type SchemaViolation struct {
+	Path    string
+	Message string
+}
+
+func (sv SchemaViolation) String() string {
+	return fmt.Sprintf("%v: %v", sv.Path, sv.Message)
+}
+
+// The fields that Vagrant requires of every provider, in the order they are checked
+var vagrantRequiredProviderFields = []string{"name", "url", "checksum", "checksum_type"}
+
+// ValidateVagrantSchema checks a serialized catalog against the metadata format that Vagrant expects, and returns every violation it finds
+// Unlike Catalog.Check(), this works on the JSON itself, so it catches fields that are missing, misnamed, or of the wrong type,
+// which would otherwise be silently dropped when the catalog is unmarshalled
+// A valid catalog has a name; every version has a version and at least one provider;
+// and every provider has a name, url, checksum, and a checksum_type that Vagrant supports
+func ValidateVagrantSchema(catalogBytes []byte) (violations []SchemaViolation) {
+	var raw interface{}
+	if err := json.Unmarshal(catalogBytes, &raw); err != nil {
+		return []SchemaViolation{SchemaViolation{"$", fmt.Sprintf("Not valid JSON: %v", err)}}
+	}
+	root, ok := raw.(map[string]interface{})
+	if !ok {
+		return []SchemaViolation{SchemaViolation{"$", "Must be an object"}}
+	}
+
+	violations = append(violations, requireString(root, "$", "name")...)
+
+	// Vagrant treats a missing list of versions as an empty one
+	rawVersions, ok := root["versions"]
+	if !ok || rawVersions == nil {
+		return
+	}
+	versions, ok := rawVersions.([]interface{})
+	if !ok {
+		return append(violations, SchemaViolation{"$.versions", "Must be an array"})
+	}
+	for vidx, rawVersion := range versions {
+		versionPath := fmt.Sprintf("$.versions[%v]", vidx)
+		version, ok := rawVersion.(map[string]interface{})
+		if !ok {
+			violations = append(violations, SchemaViolation{versionPath, "Must be an object"})
+			continue
+		}
+		violations = append(violations, requireString(version, versionPath, "version")...)
+
+		providersPath := versionPath + ".providers"
+		providers, ok := version["providers"].([]interface{})
+		if _, present := version["providers"]; !present {
+			violations = append(violations, SchemaViolation{providersPath, "Required field is missing"})
+			continue
+		} else if !ok {
+			violations = append(violations, SchemaViolation{providersPath, "Must be an array"})
+			continue
+		} else if len(providers) == 0 {
+			violations = append(violations, SchemaViolation{providersPath, "Must have at least one provider"})
+			continue
+		}
+		for pidx, rawProvider := range providers {
+			providerPath := fmt.Sprintf("%v[%v]", providersPath, pidx)
+			provider, ok := rawProvider.(map[string]interface{})
+			if !ok {
+				violations = append(violations, SchemaViolation{providerPath, "Must be an object"})
+				continue
+			}
+			for _, field := range vagrantRequiredProviderFields {
+				violations = append(violations, requireString(provider, providerPath, field)...)
+			}
+			if checksumType, ok := provider["checksum_type"].(string); ok && checksumType != "" {
+				if _, supported := checksumHashes[checksumType]; !supported {
+					violations = append(violations, SchemaViolation{providerPath + ".checksum_type", fmt.Sprintf("Unsupported checksum type '%v'; Vagrant supports: %v", checksumType, strings.Join(ChecksumTypes(), ", "))})
+				}
+			}
+		}
+	}
+	return
+}
+
+// requireString returns a violation unless object has a non-empty string for field
+func requireString(object map[string]interface{}, objectPath string, field string) (violations []SchemaViolation) {
+	fieldPath := fmt.Sprintf("%v.%v", objectPath, field)
+	value, present := object[field]
+	if !present {
+		return []SchemaViolation{SchemaViolation{fieldPath, "Required field is missing"}}
+	}
+	str, ok := value.(string)
+	if !ok {
+		return []SchemaViolation{SchemaViolation{fieldPath, "Must be a string"}}
+	}
+	if str == "" {
+		return []SchemaViolation{SchemaViolation{fieldPath, "Must not be empty"}}
+	}
+	return
+}
@@ -0,0 +1,83 @@
+package caryatid
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateVagrantSchema(t *testing.T) {
+	// validCatalog returns a fresh catalog that Vagrant can parse, as generic JSON, so that each test case can break it differently
+	validCatalog := func() map[string]interface{} {
+		return map[string]interface{}{
+			"name":        "ValidBox",
+			"description": "A box Vagrant can parse",
+			"versions": []interface{}{
+				map[string]interface{}{
+					"version": "1.0.0",
+					"providers": []interface{}{
+						map[string]interface{}{"name": "virtualbox", "url": "https://example.com/ValidBox_1.0.0_virtualbox.box", "checksum_type": "sha256", "checksum": "0xB00B1E5"},
+					},
+				},
+			},
+		}
+	}
+	firstVersion := func(c map[string]interface{}) map[string]interface{} {
+		return c["versions"].([]interface{})[0].(map[string]interface{})
+	}
+	firstProvider := func(c map[string]interface{}) map[string]interface{} {
+		return firstVersion(c)["providers"].([]interface{})[0].(map[string]interface{})
+	}
+
+	type TestCase struct {
+		Description  string
+		Break        func(map[string]interface{})
+		ExpectedPath string
+	}
+	testCases := []TestCase{
+		TestCase{"valid", func(c map[string]interface{}) {}, ""},
+		TestCase{"no versions", func(c map[string]interface{}) { delete(c, "versions") }, ""},
+		TestCase{"missing name", func(c map[string]interface{}) { delete(c, "name") }, "$.name"},
+		TestCase{"empty name", func(c map[string]interface{}) { c["name"] = "" }, "$.name"},
+		TestCase{"versions not an array", func(c map[string]interface{}) { c["versions"] = "1.0.0" }, "$.versions"},
+		TestCase{"missing version", func(c map[string]interface{}) { delete(firstVersion(c), "version") }, "$.versions[0].version"},
+		TestCase{"misnamed version", func(c map[string]interface{}) {
+			firstVersion(c)["Version"] = firstVersion(c)["version"]
+			delete(firstVersion(c), "version")
+		}, "$.versions[0].version"},
+		TestCase{"missing providers", func(c map[string]interface{}) { delete(firstVersion(c), "providers") }, "$.versions[0].providers"},
+		TestCase{"empty providers", func(c map[string]interface{}) { firstVersion(c)["providers"] = []interface{}{} }, "$.versions[0].providers"},
+		TestCase{"missing provider name", func(c map[string]interface{}) { delete(firstProvider(c), "name") }, "$.versions[0].providers[0].name"},
+		TestCase{"missing url", func(c map[string]interface{}) { delete(firstProvider(c), "url") }, "$.versions[0].providers[0].url"},
+		TestCase{"missing checksum", func(c map[string]interface{}) { delete(firstProvider(c), "checksum") }, "$.versions[0].providers[0].checksum"},
+		TestCase{"missing checksum_type", func(c map[string]interface{}) { delete(firstProvider(c), "checksum_type") }, "$.versions[0].providers[0].checksum_type"},
+		TestCase{"misnamed checksum_type", func(c map[string]interface{}) {
+			firstProvider(c)["checksumType"] = firstProvider(c)["checksum_type"]
+			delete(firstProvider(c), "checksum_type")
+		}, "$.versions[0].providers[0].checksum_type"},
+		TestCase{"unsupported checksum_type", func(c map[string]interface{}) { firstProvider(c)["checksum_type"] = "crc32" }, "$.versions[0].providers[0].checksum_type"},
+		TestCase{"url not a string", func(c map[string]interface{}) { firstProvider(c)["url"] = 42 }, "$.versions[0].providers[0].url"},
+	}
+
+	for _, tc := range testCases {
+		catalog := validCatalog()
+		tc.Break(catalog)
+		catalogBytes, err := json.Marshal(catalog)
+		if err != nil {
+			t.Fatalf("Error marshalling catalog for test case '%v': %v\n", tc.Description, err)
+		}
+		violations := ValidateVagrantSchema(catalogBytes)
+		if tc.ExpectedPath == "" {
+			if len(violations) != 0 {
+				t.Fatalf("Expected no violations for test case '%v', but got %v\n", tc.Description, violations)
+			}
+		} else if len(violations) != 1 || violations[0].Path != tc.ExpectedPath {
+			t.Fatalf("Expected exactly one violation at '%v' for test case '%v', but got %v\n", tc.ExpectedPath, tc.Description, violations)
+		}
+	}
+
+	for _, invalid := range []string{"not json", `["a", "list"]`} {
+		if violations := ValidateVagrantSchema([]byte(invalid)); len(violations) != 1 || violations[0].Path != "$" {
+			t.Fatalf("Expected one violation at '$' for '%v', but got %v\n", invalid, violations)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Checksum types supported by Vagrant, and the hash implementations that compute them
+*/
+
+package caryatid
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mrled/caryatid/internal/util"
+)
+
+// DefaultChecksumType is used when the caller does not specify a checksum type
+const DefaultChecksumType = "sha256"
+
+// ErrChecksumMismatch is wrapped by the error that VerifyFileChecksum() returns when a file does not have the checksum it should
+var ErrChecksumMismatch = errors.New("The checksum does not match")
+
+// The keys of this map are the checksum_type values that Vagrant understands
+var checksumHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// ChecksumTypes returns a sorted list of all supported checksum types
+func ChecksumTypes() (types []string) {
+	for checksumType := range checksumHashes {
+		types = append(types, checksumType)
+	}
+	sort.Strings(types)
+	return
+}
+
+// NewChecksumHash returns a new hash.Hash for a checksum type like "sha256"
+// An empty checksumType results in DefaultChecksumType
+func NewChecksumHash(checksumType string) (hasher hash.Hash, err error) {
+	if checksumType == "" {
+		checksumType = DefaultChecksumType
+	}
+	newHash, ok := checksumHashes[checksumType]
+	if !ok {
+		err = fmt.Errorf("Unsupported checksum type '%v'; supported types are: %v", checksumType, strings.Join(ChecksumTypes(), ", "))
+		return
+	}
+	hasher = newHash()
+	return
+}
+
+// ParseChecksumTypes splits a comma-separated list of checksum types, like "sha256,md5", checking that each is supported
+// Duplicates are dropped, and an empty list results in just DefaultChecksumType
+func ParseChecksumTypes(list string) (checksumTypes []string, err error) {
+	for _, checksumType := range strings.Split(list, ",") {
+		checksumType = strings.TrimSpace(checksumType)
+		if checksumType == "" {
+			continue
+		}
+		if _, err = NewChecksumHash(checksumType); err != nil {
+			return
+		}
+		duplicate := false
+		for _, existing := range checksumTypes {
+			duplicate = duplicate || existing == checksumType
+		}
+		if !duplicate {
+			checksumTypes = append(checksumTypes, checksumType)
+		}
+	}
+	if len(checksumTypes) == 0 {
+		checksumTypes = []string{DefaultChecksumType}
+	}
+	return
+}
+
+// FileChecksums computes a checksum of each type in checksumTypes for a local file, in the same order,
+// reading the file only once no matter how many types there are
+func FileChecksums(filePath string, checksumTypes []string) (checksums []Checksum, err error) {
+	hashers := make([]hash.Hash, len(checksumTypes))
+	writers := make([]io.Writer, len(checksumTypes))
+	for idx, checksumType := range checksumTypes {
+		if hashers[idx], err = NewChecksumHash(checksumType); err != nil {
+			return
+		}
+		writers[idx] = hashers[idx]
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if _, err = io.Copy(io.MultiWriter(writers...), file); err != nil {
+		return
+	}
+
+	for idx, checksumType := range checksumTypes {
+		checksums = append(checksums, Checksum{Type: checksumType, Value: hex.EncodeToString(hashers[idx].Sum(nil))})
+	}
+	return
+}
+
+// VerifyFileChecksum computes the checksum of a local file and compares it to expected,
+// returning an error wrapping ErrChecksumMismatch if they differ
+// Hex digests are compared without regard to case; an empty checksumType means DefaultChecksumType
+func VerifyFileChecksum(filePath string, checksumType string, expected string) (err error) {
+	if checksumType == "" {
+		checksumType = DefaultChecksumType
+	}
+	hasher, err := NewChecksumHash(checksumType)
+	if err != nil {
+		return
+	}
+	digest, err := util.HashFile(filePath, hasher)
+	if err != nil {
+		return
+	}
+	if !strings.EqualFold(digest, strings.TrimSpace(expected)) {
+		err = fmt.Errorf("%w: expected the %v checksum of '%v' to be '%v', but it is '%v'", ErrChecksumMismatch, checksumType, filePath, expected, digest)
+		LogErrorf("VerifyFileChecksum(): %v\n", err)
+		return
+	}
+	LogInfof("VerifyFileChecksum(): The %v checksum of '%v' is '%v', as expected\n", checksumType, filePath, digest)
+	return
+}
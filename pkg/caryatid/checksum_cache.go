@@ -0,0 +1,143 @@
+/*
+An on-disk cache of the checksums of box files, so that unchanged boxes are not hashed again
+*/
+
+package caryatid
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/mrled/caryatid/internal/util"
+)
+
+// ChecksumCache remembers the checksums of box files, along with a fingerprint of each file from the backend,
+// like its size and modification time; see CaryatidFingerprintBackend
+// A checksum is only used while the file still has the same fingerprint, so a changed box file is always hashed again
+// It is safe to use from several goroutines at once
+type ChecksumCache struct {
+	// The local path of the cache file
+	Path string
+
+	// If set, Get() never finds a cached checksum, so that every box file is hashed again, but Put() still updates the cache
+	Refresh bool
+
+	lock    sync.Mutex
+	loaded  bool
+	changed bool
+	entries map[checksumCacheKey]checksumCacheEntry
+}
+
+type checksumCacheKey struct {
+	Uri          string
+	ChecksumType string
+}
+
+type checksumCacheEntry struct {
+	Fingerprint string
+	Checksum    string
+}
+
+// checksumCacheRecord is how each entry is saved in the cache file
+type checksumCacheRecord struct {
+	Uri          string `json:"uri"`
+	ChecksumType string `json:"checksum_type"`
+	Fingerprint  string `json:"fingerprint"`
+	Checksum     string `json:"checksum"`
+}
+
+// NewChecksumCache returns a cache kept in the file at path, which is read the first time the cache is used
+func NewChecksumCache(path string) *ChecksumCache {
+	return &ChecksumCache{Path: path}
+}
+
+// load reads the cache file, if it has not been read already
+// A missing or corrupt cache file is not an error; the cache just starts out empty
+// The caller must hold the lock
+func (cache *ChecksumCache) load() {
+	if cache.loaded {
+		return
+	}
+	cache.loaded = true
+	cache.entries = make(map[checksumCacheKey]checksumCacheEntry)
+
+	cacheBytes, err := ioutil.ReadFile(cache.Path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		LogWarnf("Ignoring the checksum cache at '%v', which could not be read: %v\n", cache.Path, err)
+		return
+	}
+	var records []checksumCacheRecord
+	if err = json.Unmarshal(cacheBytes, &records); err != nil {
+		LogWarnf("Ignoring the checksum cache at '%v', which is corrupt: %v\n", cache.Path, err)
+		return
+	}
+	for _, record := range records {
+		cache.entries[checksumCacheKey{record.Uri, record.ChecksumType}] = checksumCacheEntry{record.Fingerprint, record.Checksum}
+	}
+}
+
+// Get returns the checksum of type checksumType cached for the file at uri, if the file still has the same fingerprint
+func (cache *ChecksumCache) Get(uri string, checksumType string, fingerprint string) (checksum string, ok bool) {
+	if cache.Refresh {
+		return "", false
+	}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.load()
+	entry, ok := cache.entries[checksumCacheKey{uri, checksumType}]
+	if !ok || entry.Fingerprint != fingerprint {
+		return "", false
+	}
+	return entry.Checksum, true
+}
+
+// Put caches the checksum of type checksumType for the file at uri, replacing any checksum cached for an earlier fingerprint
+func (cache *ChecksumCache) Put(uri string, checksumType string, fingerprint string, checksum string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.load()
+	key := checksumCacheKey{uri, checksumType}
+	if entry := (checksumCacheEntry{fingerprint, checksum}); cache.entries[key] != entry {
+		cache.entries[key] = entry
+		cache.changed = true
+	}
+}
+
+// Save writes the cache file, if anything was added to the cache since it was read
+func (cache *ChecksumCache) Save() (err error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if !cache.changed {
+		return
+	}
+
+	records := []checksumCacheRecord{}
+	for key, entry := range cache.entries {
+		records = append(records, checksumCacheRecord{key.Uri, key.ChecksumType, entry.Fingerprint, entry.Checksum})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Uri != records[j].Uri {
+			return records[i].Uri < records[j].Uri
+		}
+		return records[i].ChecksumType < records[j].ChecksumType
+	})
+	cacheBytes, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(cache.Path), 0777); err != nil {
+		return
+	}
+	if _, err = util.AtomicWriteFile(cache.Path, bytes.NewReader(cacheBytes)); err != nil {
+		return
+	}
+	cache.changed = false
+	return
+}
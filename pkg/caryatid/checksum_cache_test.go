@@ -0,0 +1,80 @@
+package caryatid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksumCache(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "TestChecksumCache")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(tempDir)
+	cachePath := filepath.Join(tempDir, "nested", "checksums.json")
+
+	cache := NewChecksumCache(cachePath)
+	if _, ok := cache.Get("file:///boxes/a.box", "sha1", "size=1"); ok {
+		t.Fatalf("Expected an empty cache to miss\n")
+	}
+	cache.Put("file:///boxes/a.box", "sha1", "size=1", "0xA")
+	cache.Put("file:///boxes/a.box", "sha256", "size=1", "0xA256")
+	cache.Put("file:///boxes/b.box", "sha1", "size=2", "0xB")
+	if err = cache.Save(); err != nil {
+		t.Fatalf("Save() returned an error: %v\n", err)
+	}
+
+	type TestCase struct {
+		Uri          string
+		ChecksumType string
+		Fingerprint  string
+		Checksum     string
+		ExpectHit    bool
+	}
+	testCases := []TestCase{
+		TestCase{"file:///boxes/a.box", "sha1", "size=1", "0xA", true},
+		TestCase{"file:///boxes/a.box", "sha256", "size=1", "0xA256", true},
+		TestCase{"file:///boxes/b.box", "sha1", "size=2", "0xB", true},
+		TestCase{"file:///boxes/b.box", "sha1", "size=3", "", false},
+		TestCase{"file:///boxes/b.box", "md5", "size=2", "", false},
+		TestCase{"file:///boxes/c.box", "sha1", "size=1", "", false},
+	}
+	reloaded := NewChecksumCache(cachePath)
+	for _, tc := range testCases {
+		checksum, ok := reloaded.Get(tc.Uri, tc.ChecksumType, tc.Fingerprint)
+		if ok != tc.ExpectHit || checksum != tc.Checksum {
+			t.Fatalf("Expected Get(%v, %v, %v) to return '%v', %v; got '%v', %v\n", tc.Uri, tc.ChecksumType, tc.Fingerprint, tc.Checksum, tc.ExpectHit, checksum, ok)
+		}
+	}
+
+	reloaded.Refresh = true
+	if _, ok := reloaded.Get("file:///boxes/a.box", "sha1", "size=1"); ok {
+		t.Fatalf("Expected Get() to miss when Refresh is set\n")
+	}
+}
+
+func TestChecksumCacheCorrupt(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "TestChecksumCacheCorrupt")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(tempDir)
+	cachePath := filepath.Join(tempDir, "checksums.json")
+	if err = ioutil.WriteFile(cachePath, []byte(`[{"uri": "file:///boxes/a.box"`), 0666); err != nil {
+		t.Fatalf("Error writing corrupt cache file: %v\n", err)
+	}
+
+	cache := NewChecksumCache(cachePath)
+	if _, ok := cache.Get("file:///boxes/a.box", "sha1", ""); ok {
+		t.Fatalf("Expected a corrupt cache to miss\n")
+	}
+	cache.Put("file:///boxes/a.box", "sha1", "size=1", "0xA")
+	if err = cache.Save(); err != nil {
+		t.Fatalf("Save() returned an error: %v\n", err)
+	}
+	if checksum, ok := NewChecksumCache(cachePath).Get("file:///boxes/a.box", "sha1", "size=1"); !ok || checksum != "0xA" {
+		t.Fatalf("Expected saving to replace the corrupt cache file, but got '%v', %v\n", checksum, ok)
+	}
+}
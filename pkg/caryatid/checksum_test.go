@@ -0,0 +1,100 @@
+package caryatid
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestNewChecksumHash(t *testing.T) {
+	type TestCase struct {
+		ChecksumType   string
+		ExpectedDigest string
+		ExpectedErr    bool
+	}
+	testCases := []TestCase{
+		TestCase{"", "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", false},
+		TestCase{"sha256", "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", false},
+		TestCase{"sha1", "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33", false},
+		TestCase{"md5", "acbd18db4cc2f85cedef654fccc4a4d8", false},
+		TestCase{"sha512", "f7fbba6e0636f890e56fbbf3283e524c6fa3204ae298382d624741d0dc6638326e282c41be5e4254d8820772c5518a2c5a8c0c7f7eda19594a7eb539453e1ed7", false},
+		TestCase{"SHA256", "", true},
+		TestCase{"crc32", "", true},
+	}
+
+	for _, tc := range testCases {
+		hasher, err := NewChecksumHash(tc.ChecksumType)
+		if tc.ExpectedErr {
+			if err == nil {
+				t.Fatalf("NewChecksumHash('%v') should have returned an error\n", tc.ChecksumType)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("NewChecksumHash('%v') returned an unexpected error: %v\n", tc.ChecksumType, err)
+		}
+		hasher.Write([]byte("foo"))
+		if digest := hex.EncodeToString(hasher.Sum(nil)); digest != tc.ExpectedDigest {
+			t.Fatalf("NewChecksumHash('%v') computed digest '%v' but expected '%v'\n", tc.ChecksumType, digest, tc.ExpectedDigest)
+		}
+	}
+}
+
+func TestParseChecksumTypes(t *testing.T) {
+	type TestCase struct {
+		List          string
+		ExpectedTypes []string
+		ExpectedErr   bool
+	}
+	testCases := []TestCase{
+		TestCase{"", []string{"sha256"}, false},
+		TestCase{"sha1", []string{"sha1"}, false},
+		TestCase{"sha256,md5", []string{"sha256", "md5"}, false},
+		TestCase{" sha512 , sha256,sha512,", []string{"sha512", "sha256"}, false},
+		TestCase{"sha256,crc32", nil, true},
+	}
+
+	for _, tc := range testCases {
+		checksumTypes, err := ParseChecksumTypes(tc.List)
+		if tc.ExpectedErr {
+			if err == nil {
+				t.Fatalf("ParseChecksumTypes('%v') should have returned an error\n", tc.List)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("ParseChecksumTypes('%v') returned an unexpected error: %v\n", tc.List, err)
+		}
+		if !reflect.DeepEqual(checksumTypes, tc.ExpectedTypes) {
+			t.Fatalf("ParseChecksumTypes('%v') returned %v but expected %v\n", tc.List, checksumTypes, tc.ExpectedTypes)
+		}
+	}
+}
+
+func TestVerifyFileChecksum(t *testing.T) {
+	filePath := path.Join(integrationTestDir, "TestVerifyFileChecksum.txt")
+	if err := ioutil.WriteFile(filePath, []byte("foo"), 0666); err != nil {
+		t.Fatalf("Error writing test file: %v\n", err)
+	}
+
+	type TestCase struct {
+		ChecksumType string
+		Expected     string
+		ExpectedErr  error
+	}
+	testCases := []TestCase{
+		TestCase{"", "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", nil},
+		TestCase{"md5", "ACBD18DB4CC2F85CEDEF654FCCC4A4D8", nil},
+		TestCase{"md5", "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33", ErrChecksumMismatch},
+		TestCase{"sha256", "acbd18db4cc2f85cedef654fccc4a4d8", ErrChecksumMismatch},
+	}
+	for _, tc := range testCases {
+		if err := VerifyFileChecksum(filePath, tc.ChecksumType, tc.Expected); !errors.Is(err, tc.ExpectedErr) {
+			t.Fatalf("VerifyFileChecksum('%v', '%v') returned '%v', but expected '%v'\n", tc.ChecksumType, tc.Expected, err, tc.ExpectedErr)
+		}
+	}
+	if err := VerifyFileChecksum(filePath, "crc32", "8c736521"); err == nil || errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Expected an unsupported checksum type to be an error other than a mismatch, but got: %v\n", err)
+	}
+}
@@ -4,10 +4,45 @@ package caryatid
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
+// The regular expression suggested by the semver 2.0.0 spec
+var strictSemverRegex = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// ValidateVersion returns an error if version is not a strict semantic version, like "1.2.3", "1.0.0-PRE", or "1.0.0+build.5"
+// If allowNonstandard is true, it instead accepts any version that NewComparableVersion() can parse, like "1.2", "1.2.3.4", or "v1.2.3"
+func ValidateVersion(version string, allowNonstandard bool) (err error) {
+	if allowNonstandard {
+		if _, err = NewComparableVersion(version); err != nil {
+			err = fmt.Errorf("Invalid version '%v': %v", version, err)
+		}
+		return
+	}
+	if NormalizeVersion(version) != version {
+		err = fmt.Errorf("Invalid version '%v': semantic versions do not start with a 'v'; see NormalizeVersion()", version)
+	} else if !strictSemverRegex.MatchString(version) {
+		err = fmt.Errorf("Invalid version '%v': versions must be semantic versions like '1.2.3' or '1.2.3-PRE'", version)
+	}
+	return
+}
+
+// NormalizeVersion strips a leading 'v' or 'V' from a version like "v1.2.3", as build systems often add to git tags
+// Other versions are returned unchanged
+func NormalizeVersion(version string) string {
+	if len(version) > 1 && (version[0] == 'v' || version[0] == 'V') && version[1] >= '0' && version[1] <= '9' {
+		return version[1:]
+	}
+	return version
+}
+
+// versionsEqual returns true if two version strings are the same once they are normalized; see NormalizeVersion()
+func versionsEqual(version1 string, version2 string) bool {
+	return NormalizeVersion(version1) == NormalizeVersion(version2)
+}
+
 /*
 VersionComparator represents the numerical relationship between to Version structs
 VersionEquals indicates that the two structs are equal
@@ -53,14 +88,18 @@ type ComparableVersion struct {
 }
 
 // ComparableVersion returns a ComparableVersion struct for a semver string
+// A leading 'v', like in "v1.2.3", is ignored, so that such versions compare equal to their plain form; see NormalizeVersion()
+// Build metadata, like the "+exp.sha.5114f85" in "1.0.0-alpha+exp.sha.5114f85", is discarded,
+// because it is ignored when comparing versions
 func NewComparableVersion(semver string) (cvers ComparableVersion, err error) {
 	var verStr string
+	semver = NormalizeVersion(semver)
+	if plusIdx := strings.Index(semver, "+"); plusIdx >= 0 {
+		semver = semver[0:plusIdx]
+	}
 	if strings.Contains(semver, "-") {
-		splitSemver := strings.Split(semver, "-")
-		if len(splitSemver) > 2 {
-			err = fmt.Errorf("Too many dash (-) characters in semver '%v'\n", semver)
-			return
-		}
+		// Only the first dash starts the prerelease, which may itself contain dashes, like "1.0.0-rc-1"
+		splitSemver := strings.SplitN(semver, "-", 2)
 		verStr = splitSemver[0]
 		cvers.Prerelease = splitSemver[1]
 	} else {
@@ -141,3 +180,63 @@ func (cv1 *ComparableVersion) Compare(cv2 *ComparableVersion) VersionComparator
 		return vResult
 	}
 }
+
+// comparePrerelease returns -1, 0, or 1 if the prerelease tag p1 is lower than, equal to, or higher than p2
+// Precedence follows the semver spec:
+// a version without a prerelease tag is higher than one with a tag;
+// otherwise the dot-separated identifiers are compared in order,
+// numerically if both are numeric, and with numeric identifiers lower than alphanumeric ones;
+// if all identifiers are equal, the tag with more identifiers is higher
+func comparePrerelease(p1 string, p2 string) int {
+	if p1 == p2 {
+		return 0
+	} else if p1 == "" {
+		return 1
+	} else if p2 == "" {
+		return -1
+	}
+
+	ids1 := strings.Split(p1, ".")
+	ids2 := strings.Split(p2, ".")
+	for idx := 0; idx < len(ids1) && idx < len(ids2); idx += 1 {
+		num1, err1 := strconv.ParseUint(ids1[idx], 10, 64)
+		num2, err2 := strconv.ParseUint(ids2[idx], 10, 64)
+		switch {
+		case err1 == nil && err2 == nil:
+			if num1 < num2 {
+				return -1
+			} else if num1 > num2 {
+				return 1
+			}
+		case err1 == nil:
+			return -1
+		case err2 == nil:
+			return 1
+		default:
+			if ids1[idx] < ids2[idx] {
+				return -1
+			} else if ids1[idx] > ids2[idx] {
+				return 1
+			}
+		}
+	}
+
+	if len(ids1) < len(ids2) {
+		return -1
+	} else if len(ids1) > len(ids2) {
+		return 1
+	}
+	return 0
+}
+
+// Less returns true if cv1 has lower precedence than cv2, including prerelease precedence,
+// so that 1.0.0-alpha < 1.0.0-alpha.1 < 1.0.0-beta < 1.0.0 < 1.0.1
+func (cv1 *ComparableVersion) Less(cv2 *ComparableVersion) bool {
+	switch CompareIntArray(cv1.Version, cv2.Version) {
+	case VersionLessThan:
+		return true
+	case VersionGreaterThan:
+		return false
+	}
+	return comparePrerelease(cv1.Prerelease, cv2.Prerelease) < 0
+}
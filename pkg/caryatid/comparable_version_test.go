@@ -30,6 +30,13 @@ func TestNewComparableVersion(t *testing.T) {
 		TestCase{"1.2.3-LONGASSPRERELEASE", []int{1, 2, 3}, "LONGASSPRERELEASE", false},
 		TestCase{"10.20.30", []int{10, 20, 30}, "", false},
 		TestCase{"0-X", []int{0}, "X", false},
+		TestCase{"1.2.3+build.5", []int{1, 2, 3}, "", false},
+		TestCase{"1.2.3-BETA+exp.sha.5114f85", []int{1, 2, 3}, "BETA", false},
+		TestCase{"v1.2.3", []int{1, 2, 3}, "", false},
+		TestCase{"V1.2.3-PRE", []int{1, 2, 3}, "PRE", false},
+		TestCase{"1.0.0-rc-1", []int{1, 0, 0}, "rc-1", false},
+		TestCase{"1.0.0-alpha.beta-2+build-7", []int{1, 0, 0}, "alpha.beta-2", false},
+		TestCase{"+build", []int{}, "", true},
 		TestCase{"-JUSTPRERELEASE", []int{}, "", true},
 		TestCase{"-X", []int{}, "", true},
 		TestCase{"-4", []int{}, "", true},
@@ -65,6 +72,46 @@ func TestNewComparableVersion(t *testing.T) {
 	}
 }
 
+func TestValidateVersion(t *testing.T) {
+	type TestCase struct {
+		Version          string
+		AllowNonstandard bool
+		ExpectedErr      bool
+	}
+	testCases := []TestCase{
+		TestCase{"1.2.3", false, false},
+		TestCase{"0.0.0", false, false},
+		TestCase{"1.0.0-PRE", false, false},
+		TestCase{"1.0.0-alpha.1", false, false},
+		TestCase{"1.0.0+build.5", false, false},
+		TestCase{"1.0.0-rc.1+build.5", false, false},
+		TestCase{"1.0.20170102150405", false, false},
+		TestCase{"v1.2", false, true},
+		TestCase{"v1.2.3", false, true},
+		TestCase{"1,2,3", false, true},
+		TestCase{"1.2", false, true},
+		TestCase{"1.2.3.4", false, true},
+		TestCase{"01.2.3", false, true},
+		TestCase{"1.2.3-", false, true},
+		TestCase{"", false, true},
+		TestCase{"1.2", true, false},
+		TestCase{"1.2.3.4", true, false},
+		TestCase{"1.0.0-PRE", true, false},
+		TestCase{"v1.2", true, false},
+		TestCase{"v1.2.3", true, false},
+		TestCase{"vv1.2.3", true, true},
+		TestCase{"1,2,3", true, true},
+	}
+	for _, tc := range testCases {
+		err := ValidateVersion(tc.Version, tc.AllowNonstandard)
+		if tc.ExpectedErr && err == nil {
+			t.Fatalf("ValidateVersion(%v, %v) was expected to return an error\n", tc.Version, tc.AllowNonstandard)
+		} else if !tc.ExpectedErr && err != nil {
+			t.Fatalf("ValidateVersion(%v, %v) returned an unexpected error: %v\n", tc.Version, tc.AllowNonstandard, err)
+		}
+	}
+}
+
 func TestCompareIntArray(t *testing.T) {
 	type TestCase struct {
 		A1        []int
@@ -122,3 +169,37 @@ func TestComparableVersionCompare(t *testing.T) {
 		}
 	}
 }
+
+func TestComparableVersionLess(t *testing.T) {
+	// In ascending order of precedence, per the example in the semver spec
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"2.10.0",
+		"2.11.1",
+	}
+	for idx := 0; idx < len(ordered)-1; idx += 1 {
+		lower, _ := NewComparableVersion(ordered[idx])
+		higher, _ := NewComparableVersion(ordered[idx+1])
+		if !lower.Less(&higher) {
+			t.Fatalf("Expected %v < %v\n", ordered[idx], ordered[idx+1])
+		}
+		if higher.Less(&lower) {
+			t.Fatalf("Expected !(%v < %v)\n", ordered[idx+1], ordered[idx])
+		}
+	}
+
+	// Build metadata is ignored for precedence
+	build1, _ := NewComparableVersion("1.0.0+build.1")
+	build2, _ := NewComparableVersion("1.0.0+build.2")
+	if build1.Less(&build2) || build2.Less(&build1) {
+		t.Fatalf("Expected versions differing only in build metadata to have equal precedence\n")
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Leveled logging for the package and the programs that use it
+
+By default, messages are written with the standard library's global logger,
+so they go wherever log.SetOutput() sends them,
+and only the level decides whether a message is written at all.
+Applications with their own logger can implement Logger instead,
+and pass it to SetLogger() or set it as the Logger of a BackendManager.
+A JSONLogger writes each message as a line of JSON, for log aggregation.
+*/
+
+package caryatid
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Logger receives the messages logged by the package
+// Each message is formatted like fmt.Sprintf(), and usually ends in a newline
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// StandardLogger is a Logger that writes to the standard library's global logger, at the level set by SetLogLevel()
+// It is the default Logger; warnings are logged at LogLevelError, like errors
+type StandardLogger struct{}
+
+func (StandardLogger) Debugf(format string, v ...interface{}) {
+	logAtLevel(LogLevelDebug, format, v...)
+}
+
+func (StandardLogger) Infof(format string, v ...interface{}) {
+	logAtLevel(LogLevelInfo, format, v...)
+}
+
+func (StandardLogger) Warnf(format string, v ...interface{}) {
+	logAtLevel(LogLevelError, format, v...)
+}
+
+func (StandardLogger) Errorf(format string, v ...interface{}) {
+	logAtLevel(LogLevelError, format, v...)
+}
+
+// FieldLogger is implemented by Loggers that can record contextual fields, like the catalog URI, alongside each message
+type FieldLogger interface {
+	Logger
+
+	// Return a Logger that records key with value in every message, along with the fields of this Logger
+	WithField(key string, value string) Logger
+}
+
+// WithLogField returns a Logger that records key with value in every message, if logger is a FieldLogger
+// Otherwise, it returns logger unchanged, and the field is dropped
+func WithLogField(logger Logger, key string, value string) Logger {
+	if fieldLogger, ok := logger.(FieldLogger); ok {
+		return fieldLogger.WithField(key, value)
+	}
+	return logger
+}
+
+// JSONLogger is a FieldLogger that writes each message to Writer as a line of JSON,
+// like '{"catalog":"file:///srv/boxes.json","level":"info","message":"...","time":"2024-01-01T12:00:00.000000000Z"}'
+// Messages are written at the level set by SetLogLevel(), like StandardLogger
+// Any fields are keys of the object too; they cannot replace the level, message, or time
+// Create it with NewJSONLogger()
+type JSONLogger struct {
+	Writer io.Writer
+
+	fields map[string]string
+
+	// Shared by every Logger returned by WithField(), so that lines from different goroutines are not interleaved
+	writeLock *sync.Mutex
+}
+
+// NewJSONLogger returns a JSONLogger that writes to writer
+func NewJSONLogger(writer io.Writer) *JSONLogger {
+	return &JSONLogger{Writer: writer, writeLock: &sync.Mutex{}}
+}
+
+func (logger *JSONLogger) WithField(key string, value string) Logger {
+	fields := make(map[string]string, len(logger.fields)+1)
+	for k, v := range logger.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return &JSONLogger{Writer: logger.Writer, fields: fields, writeLock: logger.writeLock}
+}
+
+// jsonLogTimeFormat has a fixed number of digits, so that timestamps sort as text
+const jsonLogTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+func (logger *JSONLogger) write(level LogLevel, levelName string, format string, v ...interface{}) {
+	if GetLogLevel() < level {
+		return
+	}
+	event := make(map[string]string, len(logger.fields)+3)
+	for key, value := range logger.fields {
+		event[key] = value
+	}
+	event["level"] = levelName
+	event["message"] = strings.TrimRight(fmt.Sprintf(format, v...), "\n")
+	event["time"] = time.Now().UTC().Format(jsonLogTimeFormat)
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	logger.writeLock.Lock()
+	defer logger.writeLock.Unlock()
+	logger.Writer.Write(append(line, '\n'))
+}
+
+func (logger *JSONLogger) Debugf(format string, v ...interface{}) {
+	logger.write(LogLevelDebug, "debug", format, v...)
+}
+
+func (logger *JSONLogger) Infof(format string, v ...interface{}) {
+	logger.write(LogLevelInfo, "info", format, v...)
+}
+
+func (logger *JSONLogger) Warnf(format string, v ...interface{}) {
+	logger.write(LogLevelError, "warning", format, v...)
+}
+
+func (logger *JSONLogger) Errorf(format string, v ...interface{}) {
+	logger.write(LogLevelError, "error", format, v...)
+}
+
+// loggerBox holds the package Logger, so that atomic.Value always stores the same concrete type
+type loggerBox struct {
+	logger Logger
+}
+
+var packageLogger atomic.Value
+
+// SetLogger sets the Logger for messages that are not logged through a BackendManager with its own Logger
+// A nil logger restores the default StandardLogger
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = StandardLogger{}
+	}
+	packageLogger.Store(loggerBox{logger})
+}
+
+// GetLogger returns the Logger set by SetLogger(), or a StandardLogger if it has not been called
+func GetLogger() Logger {
+	if box, ok := packageLogger.Load().(loggerBox); ok {
+		return box.logger
+	}
+	return StandardLogger{}
+}
+
+// backendLogger returns the Logger of the manager of a backend, for backends that wrap another backend and do not keep their own reference to it
+func backendLogger(backend CaryatidBackend) Logger {
+	manager, err := backend.GetManager()
+	if err != nil {
+		return GetLogger()
+	}
+	return manager.log()
+}
+
+// LogLevel determines which messages are logged
+type LogLevel int32
+
+const (
+	// LogLevelError logs only errors and warnings
+	LogLevelError LogLevel = iota
+	// LogLevelInfo also logs what the package is doing, like which files it copied; this is the default
+	LogLevelInfo
+	// LogLevelDebug also logs details like backend resolution and operations on individual files
+	LogLevelDebug
+)
+
+var logLevel = int32(LogLevelInfo)
+
+// SetLogLevel sets the level of messages logged by the package
+func SetLogLevel(level LogLevel) {
+	atomic.StoreInt32(&logLevel, int32(level))
+}
+
+// GetLogLevel returns the level of messages logged by the package
+func GetLogLevel() LogLevel {
+	return LogLevel(atomic.LoadInt32(&logLevel))
+}
+
+func logAtLevel(level LogLevel, format string, v ...interface{}) {
+	if GetLogLevel() >= level {
+		log.Output(3, fmt.Sprintf(format, v...))
+	}
+}
+
+// LogErrorf logs an error through the package Logger; see SetLogger()
+// The default StandardLogger logs errors at every level
+func LogErrorf(format string, v ...interface{}) {
+	GetLogger().Errorf(format, v...)
+}
+
+// LogWarnf logs a warning through the package Logger
+// The default StandardLogger logs warnings at every level
+func LogWarnf(format string, v ...interface{}) {
+	GetLogger().Warnf(format, v...)
+}
+
+// LogInfof logs an informational message through the package Logger
+// The default StandardLogger does not log it at LogLevelError
+func LogInfof(format string, v ...interface{}) {
+	GetLogger().Infof(format, v...)
+}
+
+// LogDebugf logs a debugging message through the package Logger
+// The default StandardLogger only logs it at LogLevelDebug
+func LogDebugf(format string, v ...interface{}) {
+	GetLogger().Debugf(format, v...)
+}
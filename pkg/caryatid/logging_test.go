@@ -0,0 +1,173 @@
+package caryatid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	oldLevel := GetLogLevel()
+	defer SetLogLevel(oldLevel)
+
+	type TestCase struct {
+		Level    LogLevel
+		Expected []string
+		Missing  []string
+	}
+	testCases := []TestCase{
+		{LogLevelError, []string{"error message"}, []string{"info message", "debug message"}},
+		{LogLevelInfo, []string{"error message", "info message"}, []string{"debug message"}},
+		{LogLevelDebug, []string{"error message", "info message", "debug message"}, []string{}},
+	}
+
+	for _, tc := range testCases {
+		buf.Reset()
+		SetLogLevel(tc.Level)
+		LogErrorf("error message %v\n", 1)
+		LogInfof("info message %v\n", 2)
+		LogDebugf("debug message %v\n", 3)
+		output := buf.String()
+		for _, expected := range tc.Expected {
+			if !strings.Contains(output, expected) {
+				t.Fatalf("Expected '%v' to be logged at level %v, but got output:\n%v", expected, tc.Level, output)
+			}
+		}
+		for _, missing := range tc.Missing {
+			if strings.Contains(output, missing) {
+				t.Fatalf("Expected '%v' not to be logged at level %v, but got output:\n%v", missing, tc.Level, output)
+			}
+		}
+	}
+}
+
+// capturingLogger records each message it is passed, prefixed with its level
+type capturingLogger struct {
+	Messages []string
+}
+
+func (logger *capturingLogger) capture(level string, format string, v ...interface{}) {
+	logger.Messages = append(logger.Messages, level+" "+fmt.Sprintf(format, v...))
+}
+func (logger *capturingLogger) Debugf(format string, v ...interface{}) {
+	logger.capture("DEBUG", format, v...)
+}
+func (logger *capturingLogger) Infof(format string, v ...interface{}) {
+	logger.capture("INFO", format, v...)
+}
+func (logger *capturingLogger) Warnf(format string, v ...interface{}) {
+	logger.capture("WARN", format, v...)
+}
+func (logger *capturingLogger) Errorf(format string, v ...interface{}) {
+	logger.capture("ERROR", format, v...)
+}
+
+func (logger *capturingLogger) contains(prefix string) bool {
+	for _, message := range logger.Messages {
+		if strings.HasPrefix(message, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBackendManagerLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var (
+		boxName    = "TestLoggerBox"
+		boxPath    = path.Join(integrationTestDir, "incoming-TestLoggerBox.box")
+		catalogUri = fmt.Sprintf("mem://TestBackendManagerLogger/%v.json", boxName)
+		logger     = &capturingLogger{}
+	)
+	if err := CreateTestBoxFile(boxPath, "StrongSapling", true); err != nil {
+		t.Fatalf("Error trying to create test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting memory backend: %v\n", err)
+	}
+	manager := NewBackendManagerWithLogger(catalogUri, &backend, logger)
+
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "1.0.0", Provider: "StrongSapling", ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+		t.Fatalf("Error adding box to catalog: %v\n", err)
+	}
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "desc", Version: "not-a-version", Provider: "StrongSapling"}); err == nil {
+		t.Fatalf("Expected adding a box with an invalid version to fail\n")
+	}
+
+	if !logger.contains(fmt.Sprintf("INFO No catalog at '%v'", catalogUri)) {
+		t.Fatalf("Expected the injected logger to receive an info message about the missing catalog, but got:\n%v\n", strings.Join(logger.Messages, ""))
+	}
+	if !logger.contains("ERROR AddBoxes(): ") {
+		t.Fatalf("Expected the injected logger to receive an error message about the invalid version, but got:\n%v\n", strings.Join(logger.Messages, ""))
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("Expected nothing to be logged through the standard logger, but got:\n%v\n", buf.String())
+	}
+
+	// The package Logger receives messages from everything that does not have its own
+	packageLogger := &capturingLogger{}
+	SetLogger(packageLogger)
+	defer SetLogger(nil)
+	LogWarnf("warning message %v\n", 1)
+	if !packageLogger.contains("WARN warning message 1") || buf.Len() != 0 {
+		t.Fatalf("Expected SetLogger() to replace the standard logger, but it got %v and the standard logger got:\n%v\n", packageLogger.Messages, buf.String())
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	oldLevel := GetLogLevel()
+	defer SetLogLevel(oldLevel)
+	SetLogLevel(LogLevelInfo)
+
+	base := NewJSONLogger(&buf)
+	logger := WithLogField(WithLogField(WithLogField(base, "catalog", "mem://TestJSONLogger/box.json"), "box", "TestJSONLoggerBox"), "version", "1.2.3")
+	logger.Errorf("error message %v\n", 1)
+	logger.Debugf("debug message %v\n", 2)
+	base.Infof("info message %v\n", 3)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected two lines of JSON, without the debug message, but got:\n%v\n", buf.String())
+	}
+
+	var event map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatalf("Could not parse a log event as JSON: %v\n%v\n", err, lines[0])
+	}
+	expected := map[string]string{"level": "error", "message": "error message 1", "catalog": "mem://TestJSONLogger/box.json", "box": "TestJSONLoggerBox", "version": "1.2.3"}
+	for key, value := range expected {
+		if event[key] != value {
+			t.Fatalf("Expected the log event to have '%v' of '%v', but got:\n%v\n", key, value, lines[0])
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, event["time"]); err != nil {
+		t.Fatalf("Expected the log event to have a timestamp, but got:\n%v\n", lines[0])
+	}
+
+	event = nil
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatalf("Could not parse a log event as JSON: %v\n%v\n", err, lines[1])
+	}
+	if len(event) != 3 || event["level"] != "info" {
+		t.Fatalf("Expected the base logger not to have the fields added by WithLogField(), but got:\n%v\n", lines[1])
+	}
+
+	// Loggers that cannot record fields are returned unchanged
+	if WithLogField(StandardLogger{}, "catalog", "mem://x/y.json") != (StandardLogger{}) {
+		t.Fatalf("Expected WithLogField() to return a StandardLogger unchanged\n")
+	}
+}
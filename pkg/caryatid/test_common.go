@@ -8,11 +8,16 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"fmt"
-	"log"
 	"os"
 )
 
 func CreateTestBoxFile(filePath string, providerName string, compress bool) (err error) {
+	return CreateTestBoxFileWithArchitecture(filePath, providerName, "", compress)
+}
+
+// CreateTestBoxFileWithArchitecture creates a test box file whose metadata.json also records an architecture
+// If architecture is empty, the metadata.json does not have an architecture key at all
+func CreateTestBoxFileWithArchitecture(filePath string, providerName string, architecture string, compress bool) (err error) {
 	outFile, err := os.Create(filePath)
 	if err != nil {
 		fmt.Printf("Error trying to create the test box file at '%v': %v\n", filePath, err)
@@ -31,6 +36,9 @@ func CreateTestBoxFile(filePath string, providerName string, compress bool) (err
 	defer tarWriter.Close()
 
 	metaDataContents := fmt.Sprintf(`{"provider": "%v"}`, providerName)
+	if architecture != "" {
+		metaDataContents = fmt.Sprintf(`{"provider": "%v", "architecture": "%v"}`, providerName, architecture)
+	}
 	header := &tar.Header{
 		Name: "metadata.json",
 		Mode: 0666,
@@ -48,24 +56,17 @@ func CreateTestBoxFile(filePath string, providerName string, compress bool) (err
 	return
 }
 
-type CatalogFuzzyEqualsParams struct {
-	SkipName                 bool
-	SkipDescription          bool
-	SkipVersions             bool
-	SkipVersionString        bool
-	SkipProviders            bool
-	SkipProviderName         bool
-	SkipProviderUrl          bool
-	SkipProviderChecksumType bool
-	SkipProviderChecksum     bool
-	LogMismatch              bool
-}
-
 // FuzzyEquals tests whether two Catalogs are equal, but allows skipping comparison of any property via CatalogFuzzyEqualsParams
+// The order of Versions is not significant, since catalogs are sorted by semantic version when they are saved
 func (c1 *Catalog) FuzzyEquals(c2 *Catalog, params CatalogFuzzyEqualsParams) bool {
+	sorted1 := c1.Sorted()
+	sorted2 := c2.Sorted()
+	c1 = &sorted1
+	c2 = &sorted2
+
 	logMismatch := func(property string) {
 		if params.LogMismatch {
-			log.Printf("FuzzyEquals() for '%v ?= %v' Catalog failed to match property '%v'\n", c1.Name, c2.Name, property)
+			LogInfof("FuzzyEquals() for '%v ?= %v' Catalog failed to match property '%v'\n", c1.Name, c2.Name, property)
 		}
 	}
 
@@ -73,6 +74,10 @@ func (c1 *Catalog) FuzzyEquals(c2 *Catalog, params CatalogFuzzyEqualsParams) boo
 		logMismatch("Name")
 		return false
 	}
+	if !params.SkipDescription && c1.DisplayName != c2.DisplayName {
+		logMismatch("DisplayName")
+		return false
+	}
 	if !params.SkipDescription && c1.Description != c2.Description {
 		logMismatch("Description")
 		return false
@@ -115,6 +120,10 @@ func (c1 *Catalog) FuzzyEquals(c2 *Catalog, params CatalogFuzzyEqualsParams) boo
 				logMismatch("ProviderChecksum")
 				return false
 			}
+			if !params.SkipProviderArchitecture && p1.Architecture != p2.Architecture {
+				logMismatch("ProviderArchitecture")
+				return false
+			}
 		}
 	}
 
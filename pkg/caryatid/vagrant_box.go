@@ -2,116 +2,638 @@ package caryatid
 
 import (
 	"archive/tar"
+	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/hashicorp/packer/packer"
-	"github.com/mrled/caryatid/internal/util"
 )
 
-// Determine the provider of a Vagrant box based on its metadata.json
-// See also https://www.packer.io/docs/post-processors/vagrant.html
-func DetermineProvider(boxFilePath string) (result string, err error) {
-	file, err := os.Open(boxFilePath)
-	defer file.Close()
+// BoxArtifact describes a box file and the metadata that the catalog records about it
+type BoxArtifact struct {
+	// The local path to the box file
+	Path string
+
+	Name        string
+	Description string
+	Version     string
+
+	// A name for people to read, distinct from Name; see Catalog.DisplayName
+	// This is optional, and an existing catalog keeps its display name if it is empty
+	DisplayName string
+
+	// A description of this version only, overriding Description; see Version.Description
+	// This is optional, and existing versions keep their description if it is empty
+	VersionDescription string
+
+	Provider string
+
+	// The CPU architecture the box was built for, like "amd64" or "arm64"
+	// This is optional, and older boxes and catalogs do not have it
+	Architecture string
+
+	ChecksumType string
+	Checksum     string
+
+	// Checksums of several types, including ChecksumType, when more than one type was requested; see Provider.Checksums
+	Checksums []Checksum
+
+	// The size of the box file in bytes
+	Size int64
+
+	// The URL to record for the box in the catalog
+	// If this is empty, it is the URI where the backend stores the box file; see BackendManager.boxFileUri()
+	Url string
+
+	// Arbitrary metadata to record for the box in the catalog; see Provider.Labels
+	Labels map[string]string
+
+	// When the box was added to the catalog
+	// BackendManager.AddBox() sets this to the current time if it is not already set
+	ReleasedAt time.Time
+}
+
+// fileProvider returns the provider component of the box file's name
+// It includes the architecture, if there is one, so that boxes for different architectures do not overwrite each other
+func (artifact *BoxArtifact) fileProvider() string {
+	if artifact.Architecture == "" {
+		return artifact.Provider
+	}
+	return fmt.Sprintf("%v_%v", artifact.Provider, artifact.Architecture)
+}
+
+// DefaultFilenameTemplate names box files like '<name>_<version>_<provider>.box',
+// or '<name>_<version>_<provider>_<architecture>.box' for boxes with an architecture
+const DefaultFilenameTemplate = "{{.Name}}_{{.Version}}_{{.Provider}}{{if .Architecture}}_{{.Architecture}}{{end}}.box"
+
+// parseFilenameTemplate parses a text/template for the names of box files, which is executed with a BoxArtifact
+// An empty filenameTemplate means DefaultFilenameTemplate
+func parseFilenameTemplate(filenameTemplate string) (*template.Template, error) {
+	if filenameTemplate == "" {
+		filenameTemplate = DefaultFilenameTemplate
+	}
+	return template.New("filename").Option("missingkey=error").Parse(filenameTemplate)
+}
+
+// fileName returns the name of the box file for the artifact, by executing filenameTemplate; see ValidateFilenameTemplate()
+func (artifact *BoxArtifact) fileName(filenameTemplate string) (name string, err error) {
+	tmpl, err := parseFilenameTemplate(filenameTemplate)
 	if err != nil {
 		return
 	}
+	var buffer bytes.Buffer
+	if err = tmpl.Execute(&buffer, artifact); err != nil {
+		return
+	}
+	name = buffer.String()
+	return
+}
+
+// ValidateFilenameTemplate checks that a text/template for the names of box files is usable
+// The template is executed with a BoxArtifact, and can refer to its .Name, .Version, .Provider, and .Architecture
+// Box files for one box are all stored in the same directory, so the result must end in '.box', must not contain a '/',
+// and must differ for boxes with a different version, provider, or architecture, so that they do not overwrite each other
+func ValidateFilenameTemplate(filenameTemplate string) (err error) {
+	if _, err = parseFilenameTemplate(filenameTemplate); err != nil {
+		return fmt.Errorf("Invalid filename template: %v", err)
+	}
 
-	magic := make([]byte, 2, 2)
-	_, err = file.Read(magic)
+	example := BoxArtifact{Name: "example", Version: "1.0.0", Provider: "virtualbox", Architecture: "amd64"}
+	exampleName, err := example.fileName(filenameTemplate)
 	if err != nil {
-		return
+		return fmt.Errorf("Invalid filename template: %v", err)
+	}
+	if !strings.HasSuffix(exampleName, ".box") || strings.Contains(exampleName, "/") {
+		return fmt.Errorf("Filename template '%v' must make names that end in '.box' and do not contain '/', but it made '%v'", filenameTemplate, exampleName)
 	}
 
-	// "Rewind" the file reader. If we don't do this after reading the magic number,
-	// then gzip.NewReader() will see the file starting at the third byte, and panic()
-	_, err = file.Seek(0, 0)
+	variants := map[string]BoxArtifact{
+		"version":      BoxArtifact{Name: "example", Version: "2.0.0", Provider: "virtualbox", Architecture: "amd64"},
+		"provider":     BoxArtifact{Name: "example", Version: "1.0.0", Provider: "libvirt", Architecture: "amd64"},
+		"architecture": BoxArtifact{Name: "example", Version: "1.0.0", Provider: "virtualbox", Architecture: "arm64"},
+	}
+	for field, variant := range variants {
+		variantName, variantErr := variant.fileName(filenameTemplate)
+		if variantErr != nil {
+			return fmt.Errorf("Invalid filename template: %v", variantErr)
+		}
+		if variantName == exampleName {
+			return fmt.Errorf("Filename template '%v' does not include the %v, so different boxes would have the same name '%v'", filenameTemplate, field, exampleName)
+		}
+	}
+	return
+}
+
+// These stand in for the fields of a BoxArtifact when a filename template is turned into a regular expression; see filenameTemplatePatterns()
+const (
+	namePlaceholder         = "CaryatidNamePlaceholder"
+	versionPlaceholder      = "CaryatidVersionPlaceholder"
+	providerPlaceholder     = "CaryatidProviderPlaceholder"
+	architecturePlaceholder = "CaryatidArchitecturePlaceholder"
+)
+
+// filenameTemplateGroups maps each placeholder to the regular expression group that replaces it
+// A version never contains an underscore, so its pattern finds where the name ends in names like '<name>_<version>_<provider>.box'
+var filenameTemplateGroups = []struct {
+	Placeholder string
+	Group       string
+	Pattern     string
+}{
+	{namePlaceholder, "name", `.+`},
+	{versionPlaceholder, "version", `[vV]?[0-9][0-9A-Za-z.+\-]*`},
+	{providerPlaceholder, "provider", `[^/]+?`},
+	{architecturePlaceholder, "architecture", `[^/]+`},
+}
+
+// filenameTemplatePatterns returns regular expressions that match the names filenameTemplate makes
+// If provider is set, they only match names for a box with that provider and architecture;
+// otherwise, there is one for boxes with an architecture, and then one for boxes without one
+func filenameTemplatePatterns(filenameTemplate string, provider string, architecture string) (patterns []*regexp.Regexp, err error) {
+	tmpl, err := parseFilenameTemplate(filenameTemplate)
 	if err != nil {
 		return
 	}
+	placeholders := []BoxArtifact{
+		BoxArtifact{Name: namePlaceholder, Version: versionPlaceholder, Provider: providerPlaceholder, Architecture: architecturePlaceholder},
+		BoxArtifact{Name: namePlaceholder, Version: versionPlaceholder, Provider: providerPlaceholder},
+	}
+	if provider != "" {
+		placeholders = []BoxArtifact{BoxArtifact{Name: namePlaceholder, Version: versionPlaceholder, Provider: provider, Architecture: architecture}}
+	}
+	for _, placeholder := range placeholders {
+		var buffer bytes.Buffer
+		if err = tmpl.Execute(&buffer, placeholder); err != nil {
+			return
+		}
+		pattern := regexp.QuoteMeta(buffer.String())
+		for _, g := range filenameTemplateGroups {
+			// Only the first use of a field captures it, since a group name can only be used once
+			pattern = strings.Replace(pattern, g.Placeholder, fmt.Sprintf("(?P<%v>%v)", g.Group, g.Pattern), 1)
+			pattern = strings.Replace(pattern, g.Placeholder, fmt.Sprintf("(?:%v)", g.Pattern), -1)
+		}
+		var compiled *regexp.Regexp
+		if compiled, err = regexp.Compile("^" + pattern + "$"); err != nil {
+			return
+		}
+		patterns = append(patterns, compiled)
+	}
+	return
+}
 
-	// The magic number for Gzip files is 0xF1 0x8B or, in decimal, 31 139.
-	var tarReader tar.Reader
-	if magic[0] == 31 && magic[1] == 139 {
-		gzReader, err := gzip.NewReader(file)
-		defer gzReader.Close()
-		if err != nil {
-			e := fmt.Errorf("Failed to create gzip reader for file '%v': %v", boxFilePath, err)
-			fmt.Printf("%v\n", e)
-			return result, e
+// ParseBoxFileName returns an artifact with the Name, Version, Provider, and Architecture that filenameTemplate would have made fileName from
+// An empty filenameTemplate means DefaultFilenameTemplate
+// Without a provider, names can be ambiguous: 'testbox_1.0.0_vmware_desktop.box' might be for a 'vmware' box with a 'desktop' architecture,
+// and is parsed as one, since names that could be for a box with an architecture are parsed that way
+// Passing the provider and architecture from the box's own metadata, if it has any, resolves this; see FindBoxFiles()
+// It is an error if filenameTemplate could not have made fileName
+func ParseBoxFileName(fileName string, filenameTemplate string, provider string, architecture string) (artifact BoxArtifact, err error) {
+	if filenameTemplate == "" {
+		filenameTemplate = DefaultFilenameTemplate
+	}
+	patterns, err := filenameTemplatePatterns(filenameTemplate, provider, architecture)
+	if err != nil {
+		err = fmt.Errorf("Invalid filename template: %v", err)
+		return
+	}
+	for _, pattern := range patterns {
+		match := pattern.FindStringSubmatch(fileName)
+		if match == nil {
+			continue
 		}
-		tr := tar.NewReader(gzReader)
-		tarReader = *tr
-	} else {
-		tr := tar.NewReader(file)
-		tarReader = *tr
+		artifact.Provider = provider
+		artifact.Architecture = architecture
+		for idx, group := range pattern.SubexpNames() {
+			switch group {
+			case "name":
+				artifact.Name = match[idx]
+			case "version":
+				artifact.Version = match[idx]
+			case "provider":
+				artifact.Provider = match[idx]
+			case "architecture":
+				artifact.Architecture = match[idx]
+			}
+		}
+		return
 	}
+	if provider != "" {
+		err = fmt.Errorf("The name '%v' does not match the filename template '%v' for a '%v' box with architecture '%v'", fileName, filenameTemplate, provider, architecture)
+		return
+	}
+	err = fmt.Errorf("The name '%v' does not match the filename template '%v'", fileName, filenameTemplate)
+	return
+}
 
-	var metadataContents []byte
-	done := false
-	for done == false {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			return result, fmt.Errorf("Could not find metadata.json file in %v", boxFilePath)
-		} else if err != nil {
-			return result, err
+// FindBoxFiles returns an artifact for each file in a local directory whose name filenameTemplate could have made,
+// with its Path set, along with the fields from its name; see ParseBoxFileName()
+// Boxes with a metadata.json are matched against the provider and architecture it has, so that their names are parsed unambiguously
+// Files with other names, or that are not boxes, are skipped with a warning, and subdirectories are not searched
+func FindBoxFiles(dir string, filenameTemplate string) (artifacts []BoxArtifact, err error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
 		}
+	}
+	sort.Strings(names)
 
-		if strings.ToLower(header.Name) == "metadata.json" {
-			done = true
-			metadataContents, err = ioutil.ReadAll(&tarReader)
-			if err != nil {
-				return result, err
+	for _, name := range names {
+		boxPath := filepath.Join(dir, name)
+		artifact, parseErr := ParseBoxFileName(name, filenameTemplate, "", "")
+		if parseErr != nil {
+			LogWarnf("Skipping '%v': %v\n", boxPath, parseErr)
+			continue
+		}
+		metadata, metadataErr := ReadBoxMetadata(boxPath)
+		if metadataErr == nil {
+			if artifact, parseErr = ParseBoxFileName(name, filenameTemplate, metadata.Provider, metadata.Architecture); parseErr != nil {
+				LogWarnf("Skipping '%v': %v\n", boxPath, parseErr)
+				continue
 			}
+		} else if metadataErr != ErrNoBoxMetadata {
+			LogWarnf("Skipping '%v', which does not seem to be a box: %v\n", boxPath, metadataErr)
+			continue
+		}
+		artifact.Path = boxPath
+		artifacts = append(artifacts, artifact)
+	}
+	return
+}
+
+// BoxMetadata holds the parts of a Vagrant box's internal metadata.json that we care about
+type BoxMetadata struct {
+	Provider     string `json:"provider"`
+	Architecture string `json:"architecture"`
+}
+
+// BoxCompression is the compression format of a box file, which is a tar archive that may be compressed
+type BoxCompression string
+
+const (
+	// The box file is a tar archive that is not compressed
+	BoxCompressionNone BoxCompression = "none"
+
+	BoxCompressionGzip  BoxCompression = "gzip"
+	BoxCompressionBzip2 BoxCompression = "bzip2"
+
+	// Reading an xz-compressed box file requires the 'xz' command; see ErrDecompressorNotFound
+	BoxCompressionXz BoxCompression = "xz"
+
+	// Reading a zstd-compressed box file requires the 'zstd' command; see ErrDecompressorNotFound
+	BoxCompressionZstd BoxCompression = "zstd"
+)
+
+// ErrUnknownBoxCompression is returned by DetectBoxCompression() for a file that is neither a tar archive nor compressed in a known format
+var ErrUnknownBoxCompression = errors.New("Box file is not a tar archive compressed in a known format")
+
+// boxCompressionMagic maps the bytes at the start of a compressed file to its format
+var boxCompressionMagic = []struct {
+	Magic       []byte
+	Compression BoxCompression
+}{
+	{[]byte{0x1f, 0x8b}, BoxCompressionGzip},
+	{[]byte("BZh"), BoxCompressionBzip2},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, BoxCompressionXz},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, BoxCompressionZstd},
+}
+
+// tarMagicOffset is where a tar archive has the magic bytes 'ustar', in the header of its first file
+const tarMagicOffset = 257
+
+// DetectBoxCompression determines the compression format of a box file from the magic bytes at its start
+// If it is not compressed in a known format, and is not an uncompressed tar archive either, it returns an error wrapping ErrUnknownBoxCompression
+func DetectBoxCompression(boxFilePath string) (compression BoxCompression, err error) {
+	file, err := os.Open(boxFilePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	header := make([]byte, tarMagicOffset+5)
+	read, err := io.ReadFull(file, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	} else if err != nil {
+		return
+	}
+	header = header[:read]
+
+	for _, format := range boxCompressionMagic {
+		if bytes.HasPrefix(header, format.Magic) {
+			return format.Compression, nil
+		}
+	}
+	if len(header) == tarMagicOffset+5 && string(header[tarMagicOffset:]) == "ustar" {
+		return BoxCompressionNone, nil
+	}
+	err = fmt.Errorf("%w: '%v'", ErrUnknownBoxCompression, boxFilePath)
+	return
+}
+
+// IsCompressedBoxFile returns true if a box file is compressed in any format that DetectBoxCompression() knows
+func IsCompressedBoxFile(boxFilePath string) (compressed bool, err error) {
+	compression, err := DetectBoxCompression(boxFilePath)
+	if errors.Is(err, ErrUnknownBoxCompression) {
+		return false, nil
+	} else if err != nil {
+		return
+	}
+	return compression != BoxCompressionNone, nil
+}
+
+// ErrDecompressorNotFound is returned when reading a box file whose compression needs a command that is not installed, like 'xz'
+var ErrDecompressorNotFound = errors.New("The command that decompresses the box file is not installed")
+
+// decompressCommandReader streams reader through an external decompression command, like 'xz -dc'
+// Calling cleanup stops the command, even if it has not read all of its input
+func decompressCommandReader(reader io.Reader, compression BoxCompression, command string, args ...string) (result io.Reader, cleanup func(), err error) {
+	if _, err = exec.LookPath(command); err != nil {
+		err = fmt.Errorf("%w: reading a box file compressed with %v requires the '%v' command, which was not found in PATH; install '%v', or recompress the box with gzip", ErrDecompressorNotFound, compression, command, command)
+		return
+	}
+	cmd := exec.Command(command, args...)
+	cmd.Stdin = reader
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	cleanup = func() {
+		stdout.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+	return stdout, cleanup, nil
+}
+
+// decompressBoxReader returns a reader of the tar archive inside a box file compressed with compression
+// The caller must call cleanup when it is done reading
+func decompressBoxReader(file io.Reader, compression BoxCompression) (archive io.Reader, cleanup func(), err error) {
+	cleanup = func() {}
+	switch compression {
+	case BoxCompressionNone:
+		return file, cleanup, nil
+	case BoxCompressionGzip:
+		gzReader, gzErr := gzip.NewReader(file)
+		if gzErr != nil {
+			return nil, cleanup, fmt.Errorf("Not a valid gzip file: %v", gzErr)
 		}
+		return gzReader, func() { gzReader.Close() }, nil
+	case BoxCompressionBzip2:
+		return bzip2.NewReader(file), cleanup, nil
+	case BoxCompressionXz:
+		return decompressCommandReader(file, compression, "xz", "-dc")
+	case BoxCompressionZstd:
+		return decompressCommandReader(file, compression, "zstd", "-dcq")
 	}
+	return nil, cleanup, fmt.Errorf("%w: %v", ErrUnknownBoxCompression, compression)
+}
 
-	var metadata struct {
-		Provider string `json:"provider"`
+// CompressBoxFile writes a gzip-compressed copy of a box file to compressedPath
+// Vagrant detects the compression when it adds a box, so the result is still a valid box file
+func CompressBoxFile(boxFilePath string, compressedPath string) (err error) {
+	inFile, err := os.Open(boxFilePath)
+	if err != nil {
+		return
 	}
-	if err = json.Unmarshal(metadataContents, &metadata); err != nil {
+	defer inFile.Close()
+
+	outFile, err := os.Create(compressedPath)
+	if err != nil {
 		return
 	}
+	defer func() {
+		if closeErr := outFile.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	gzipWriter := gzip.NewWriter(outFile)
+	if _, err = io.Copy(gzipWriter, inFile); err != nil {
+		return
+	}
+	err = gzipWriter.Close()
+	return
+}
 
+// PrepareCompressedBoxFile returns the path to a gzip-compressed copy of a box file, in a new temporary directory,
+// which the caller should remove by calling cleanup when it is done with the copy
+// The copy has the same file name as the original
+// If the box file is already compressed, its own path is returned, and cleanup does nothing
+func PrepareCompressedBoxFile(boxFilePath string) (compressedPath string, cleanup func(), err error) {
+	cleanup = func() {}
+
+	compressed, err := IsCompressedBoxFile(boxFilePath)
+	if err != nil {
+		return
+	} else if compressed {
+		LogInfof("Box file '%v' is already compressed\n", boxFilePath)
+		return boxFilePath, cleanup, nil
+	}
+
+	tempDir, err := ioutil.TempDir("", "caryatid-compress-")
+	if err != nil {
+		return
+	}
+	compressedPath = filepath.Join(tempDir, filepath.Base(boxFilePath))
+	LogInfof("Compressing box file '%v' to '%v'\n", boxFilePath, compressedPath)
+	if err = CompressBoxFile(boxFilePath, compressedPath); err != nil {
+		os.RemoveAll(tempDir)
+		return "", func() {}, err
+	}
+	cleanup = func() {
+		os.RemoveAll(tempDir)
+	}
+	return
+}
+
+// BufferBoxFile writes the box file read from reader, like stdin, to a new temporary directory,
+// so that it can be checksummed and copied like any other box file
+// The caller should remove the temporary directory by calling cleanup when it is done with the box file
+func BufferBoxFile(reader io.Reader) (boxFilePath string, cleanup func(), err error) {
+	cleanup = func() {}
+
+	tempDir, err := ioutil.TempDir("", "caryatid-stdin-")
+	if err != nil {
+		return
+	}
+	removeTempDir := func() {
+		os.RemoveAll(tempDir)
+	}
+
+	boxFilePath = filepath.Join(tempDir, "stdin.box")
+	boxFile, err := os.Create(boxFilePath)
+	if err != nil {
+		removeTempDir()
+		return "", cleanup, err
+	}
+	written, err := io.Copy(boxFile, reader)
+	if closeErr := boxFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written == 0 {
+		err = fmt.Errorf("No box data was read")
+	}
+	if err != nil {
+		removeTempDir()
+		return "", cleanup, err
+	}
+	LogInfof("Buffered %v bytes of box data to '%v'\n", written, boxFilePath)
+
+	cleanup = removeTempDir
+	return
+}
+
+// Determine the provider of a Vagrant box based on its metadata.json
+// See also https://www.packer.io/docs/post-processors/vagrant.html
+func DetermineProvider(boxFilePath string) (result string, err error) {
+	metadata, err := ReadBoxMetadata(boxFilePath)
 	result = metadata.Provider
 	return
 }
 
-func DeriveArtifactInfoFromBoxFile(boxFile string) (digestType string, digest string, provider string, err error) {
+// ErrNoBoxMetadata is returned by ReadBoxMetadata when a box file is a valid archive but has no metadata.json
+var ErrNoBoxMetadata = errors.New("Box file has no metadata.json")
+
+// ReadBoxMetadata reads the metadata.json file from inside a Vagrant box
+// A box file is a tar archive, which may be compressed with gzip, bzip2, xz, or zstd; see DetectBoxCompression()
+// The box file itself is not changed
+func ReadBoxMetadata(boxFilePath string) (metadata BoxMetadata, err error) {
+	compression, err := DetectBoxCompression(boxFilePath)
+	if err != nil {
+		return
+	}
+
+	file, err := os.Open(boxFilePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	archive, closeArchive, err := decompressBoxReader(file, compression)
+	if err != nil {
+		err = fmt.Errorf("Could not decompress box file '%v': %w", boxFilePath, err)
+		return
+	}
+	defer closeArchive()
+
+	tarReader := tar.NewReader(archive)
+	for entries := 0; ; entries++ {
+		header, tarErr := tarReader.Next()
+		if tarErr == io.EOF && entries > 0 {
+			err = ErrNoBoxMetadata
+			return
+		} else if tarErr != nil {
+			err = fmt.Errorf("Box file '%v' is not a valid tar archive: %v", boxFilePath, tarErr)
+			return
+		}
+
+		// Some tools store the file as './metadata.json'
+		if strings.ToLower(path.Clean(header.Name)) != "metadata.json" {
+			continue
+		}
+		metadataContents, readErr := ioutil.ReadAll(tarReader)
+		if readErr != nil {
+			err = fmt.Errorf("Could not read metadata.json from box file '%v': %v", boxFilePath, readErr)
+			return
+		}
+		if err = json.Unmarshal(metadataContents, &metadata); err != nil {
+			err = fmt.Errorf("Could not parse metadata.json from box file '%v': %v", boxFilePath, err)
+		}
+		return
+	}
+}
+
+// providerFromBoxFileName guesses the provider of a box from its file name,
+// which Packer's Vagrant post-processor names like 'packer_<build name>_<provider>.box' by default
+func providerFromBoxFileName(boxFilePath string) (provider string, err error) {
+	baseName := strings.TrimSuffix(filepath.Base(boxFilePath), ".box")
+	separator := strings.LastIndex(baseName, "_")
+	if separator < 0 || separator == len(baseName)-1 {
+		err = fmt.Errorf("Could not determine the provider of box file '%v' from its name", boxFilePath)
+		return
+	}
+	provider = baseName[separator+1:]
+	return
+}
+
+// DeriveArtifactInfoFromBoxFile computes the checksum and determines the provider and architecture of a box file
+// The checksumType must be one of ChecksumTypes(), or empty for DefaultChecksumType
+// It may also be a comma-separated list, like 'sha256,md5', which computes every checksum in one pass over the file;
+// the first is the artifact's Checksum, and all of them are its Checksums
+// The checksum is of boxFile exactly as it is on disk, so a box that will be compressed before it is stored must be compressed first
+// The caller is responsible for setting the Name, Description, and Version of the result
+func DeriveArtifactInfoFromBoxFile(boxFile string, checksumType string) (artifact BoxArtifact, err error) {
+	var metadata BoxMetadata
+
 	if !strings.HasSuffix(boxFile, ".box") {
 		err = fmt.Errorf("Input artifact '%v' doesn't have a '.box' file extension, and is therefore not a valid Vagrant box", boxFile)
 		return
 	}
-	log.Println(fmt.Sprintf("Found input Vagrant .box file: '%v'", boxFile))
+	LogInfof("Found input Vagrant .box file: '%v'\n", boxFile)
+	artifact.Path = boxFile
 
-	digestType = "sha1"
-
-	digest, err = util.Sha1sum(boxFile)
+	boxInfo, err := os.Stat(boxFile)
 	if err != nil {
-		log.Printf("sha1sum failed for box file '%v' with error %v\n", boxFile, err)
 		return
 	}
-	log.Println(fmt.Sprintf("Found SHA1 hash for file: '%v'", digest))
+	artifact.Size = boxInfo.Size()
 
-	provider, err = DetermineProvider(boxFile)
+	checksumTypes, err := ParseChecksumTypes(checksumType)
 	if err != nil {
-		log.Printf("Could not determine provider from the filename for box file '%v'; got error %v\n", boxFile, err)
 		return
 	}
-	log.Println(fmt.Sprintf("Determined provider as '%v'", provider))
+	checksums, err := FileChecksums(boxFile, checksumTypes)
+	if err != nil {
+		LogErrorf("%v hash failed for box file '%v' with error %v\n", checksumType, boxFile, err)
+		return
+	}
+	artifact.ChecksumType = checksums[0].Type
+	artifact.Checksum = checksums[0].Value
+	if len(checksums) > 1 {
+		artifact.Checksums = checksums
+	}
+	LogDebugf("Found hashes for file: %v\n", checksums)
+
+	metadata, err = ReadBoxMetadata(boxFile)
+	if err == ErrNoBoxMetadata {
+		LogInfof("Box file '%v' has no metadata.json, so guessing its provider from its name\n", boxFile)
+		if metadata.Provider, err = providerFromBoxFileName(boxFile); err != nil {
+			return
+		}
+	} else if err != nil {
+		LogErrorf("Could not read metadata from box file '%v'; got error %v\n", boxFile, err)
+		return
+	}
+	artifact.Provider = metadata.Provider
+	artifact.Architecture = metadata.Architecture
+	LogDebugf("Determined provider as '%v' and architecture as '%v'\n", artifact.Provider, artifact.Architecture)
 
 	return
 }
 
-// func DerivePackerArtifactInfo(artifact packer.Artifact) (boxFile string, digest string, provider string, err error) {
-func DeriveArtifactInfoFromPackerArtifact(artifact packer.Artifact) (boxFile string, digestType string, digest string, provider string, err error) {
+// BoxFileFromPackerArtifact returns the path to the single .box file in a Packer artifact
+func BoxFileFromPackerArtifact(artifact packer.Artifact) (boxFile string, err error) {
 	if len(artifact.Files()) != 1 {
 		err = fmt.Errorf(
 			"Wrong number of files in the input artifact; expected exactly 1 file but found %v:\n%v",
@@ -124,8 +646,37 @@ func DeriveArtifactInfoFromPackerArtifact(artifact packer.Artifact) (boxFile str
 		err = fmt.Errorf("Input artifact '%v' doesn't have a '.box' file extension, and is therefore not a valid Vagrant box", boxFile)
 		return
 	}
-	log.Println(fmt.Sprintf("Found input Vagrant .box file: '%v'", boxFile))
+	return
+}
 
-	digestType, digest, provider, err = DeriveArtifactInfoFromBoxFile(boxFile)
+func DeriveArtifactInfoFromPackerArtifact(artifact packer.Artifact, checksumType string) (boxArtifact BoxArtifact, err error) {
+	boxFile, err := BoxFileFromPackerArtifact(artifact)
+	if err != nil {
+		return
+	}
+	boxArtifact, err = DeriveArtifactInfoFromBoxFile(boxFile, checksumType)
+	return
+}
+
+// PrepareBoxArtifact derives the artifact info for a box file that is about to be added to a catalog,
+// first compressing it if compress is set
+// Both the caryatid command and the Packer post-processor add boxes this way
+// The caller should call cleanup once the box has been added, to remove any compressed copy
+func PrepareBoxArtifact(boxFile string, checksumType string, compress bool) (artifact BoxArtifact, cleanup func(), err error) {
+	cleanup = func() {}
+	if compress {
+		if boxFile, cleanup, err = PrepareCompressedBoxFile(boxFile); err != nil {
+			err = fmt.Errorf("Could not compress box file: %v", err)
+			return
+		}
+	}
+
+	// The catalog must record the checksum of the file that is actually stored
+	if artifact, err = DeriveArtifactInfoFromBoxFile(boxFile, checksumType); err != nil {
+		cleanup()
+		cleanup = func() {}
+		err = fmt.Errorf("Could not determine artifact info: %v", err)
+		return
+	}
 	return
 }
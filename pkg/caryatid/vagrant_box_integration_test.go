@@ -1,12 +1,21 @@
 package caryatid
 
 import (
+	"archive/tar"
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
+	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+
+	"github.com/mrled/caryatid/internal/util"
 )
 
 const integrationTestDirName = "integration_tests"
@@ -81,3 +90,357 @@ func TestDetermineProvider(t *testing.T) {
 		t.Fatal("Expected provider name does not match result provider name: ", testProviderName, resultProviderName)
 	}
 }
+
+func TestDeriveArtifactInfoFromBoxFileArchitecture(t *testing.T) {
+	type TestCase struct {
+		Architecture string
+		BoxPath      string
+	}
+	testCases := []TestCase{
+		TestCase{"", path.Join(integrationTestDir, "testDeriveArchNone.box")},
+		TestCase{"arm64", path.Join(integrationTestDir, "testDeriveArchArm64.box")},
+	}
+	for _, tc := range testCases {
+		if err := CreateTestBoxFileWithArchitecture(tc.BoxPath, "TESTPROVIDER", tc.Architecture, true); err != nil {
+			t.Fatalf("Error trying to write input artifact file: %v\n", err)
+		}
+		artifact, err := DeriveArtifactInfoFromBoxFile(tc.BoxPath, "")
+		if err != nil {
+			t.Fatalf("DeriveArtifactInfoFromBoxFile() returned an error: %v\n", err)
+		}
+		if artifact.Provider != "TESTPROVIDER" || artifact.Architecture != tc.Architecture || artifact.Path != tc.BoxPath {
+			t.Fatalf("Expected provider 'TESTPROVIDER' and architecture '%v' for '%v', but got %+v\n", tc.Architecture, tc.BoxPath, artifact)
+		}
+		if artifact.ChecksumType != DefaultChecksumType || artifact.Checksum == "" {
+			t.Fatalf("Expected a %v checksum, but got %+v\n", DefaultChecksumType, artifact)
+		}
+	}
+}
+
+func TestCompressBoxFile(t *testing.T) {
+	var (
+		boxPath          = path.Join(integrationTestDir, "testCompressUncompressed.box")
+		testProviderName = "TESTPROVIDER"
+	)
+
+	if err := CreateTestBoxFileWithArchitecture(boxPath, testProviderName, "arm64", false); err != nil {
+		t.Fatalf("Error trying to write input artifact file: %v\n", err)
+	}
+	if compressed, err := IsCompressedBoxFile(boxPath); err != nil || compressed {
+		t.Fatalf("Expected an uncompressed box file, but got compressed %v and error %v\n", compressed, err)
+	}
+	original, err := DeriveArtifactInfoFromBoxFile(boxPath, "sha256")
+	if err != nil {
+		t.Fatalf("DeriveArtifactInfoFromBoxFile() returned an error: %v\n", err)
+	}
+
+	compressedPath, cleanup, err := PrepareCompressedBoxFile(boxPath)
+	if err != nil {
+		t.Fatalf("PrepareCompressedBoxFile() returned an error: %v\n", err)
+	}
+	defer cleanup()
+	if path.Base(compressedPath) != path.Base(boxPath) || compressedPath == boxPath {
+		t.Fatalf("Expected a copy of '%v' with the same file name, but got '%v'\n", boxPath, compressedPath)
+	}
+	if compressed, err := IsCompressedBoxFile(compressedPath); err != nil || !compressed {
+		t.Fatalf("Expected a compressed box file, but got compressed %v and error %v\n", compressed, err)
+	}
+
+	artifact, err := DeriveArtifactInfoFromBoxFile(compressedPath, "sha256")
+	if err != nil {
+		t.Fatalf("DeriveArtifactInfoFromBoxFile() returned an error for the compressed box: %v\n", err)
+	}
+	if artifact.Provider != testProviderName || artifact.Architecture != "arm64" {
+		t.Fatalf("Expected the compressed box to keep its metadata, but got %+v\n", artifact)
+	}
+	if artifact.Checksum == original.Checksum {
+		t.Fatalf("Expected the compressed box checksum to differ from the original checksum '%v'\n", original.Checksum)
+	}
+	hasher, _ := NewChecksumHash("sha256")
+	if storedChecksum, err := util.HashFile(compressedPath, hasher); err != nil || storedChecksum != artifact.Checksum {
+		t.Fatalf("Expected the checksum of the compressed file '%v', but got '%v' (error %v)\n", storedChecksum, artifact.Checksum, err)
+	}
+
+	// An already compressed box is used as-is
+	samePath, sameCleanup, err := PrepareCompressedBoxFile(compressedPath)
+	if err != nil {
+		t.Fatalf("PrepareCompressedBoxFile() returned an error for a compressed box: %v\n", err)
+	}
+	sameCleanup()
+	if samePath != compressedPath {
+		t.Fatalf("Expected an already compressed box to be used as-is, but got '%v'\n", samePath)
+	}
+}
+
+// writeTestTarBox writes an uncompressed box file containing the given files
+func writeTestTarBox(boxPath string, files map[string]string) (err error) {
+	outFile, err := os.Create(boxPath)
+	if err != nil {
+		return
+	}
+	defer outFile.Close()
+	tarWriter := tar.NewWriter(outFile)
+	for name, contents := range files {
+		if err = tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0666, Size: int64(len(contents))}); err != nil {
+			return
+		}
+		if _, err = tarWriter.Write([]byte(contents)); err != nil {
+			return
+		}
+	}
+	return tarWriter.Close()
+}
+
+func TestReadBoxMetadataDecompressorNotFound(t *testing.T) {
+	type TestCase struct {
+		Compression BoxCompression
+		Command     string
+		Magic       []byte
+	}
+	testCases := []TestCase{
+		TestCase{BoxCompressionXz, "xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+		TestCase{BoxCompressionZstd, "zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	}
+
+	// With nothing in PATH, the commands are missing whether or not they are installed
+	t.Setenv("PATH", "")
+	for _, tc := range testCases {
+		boxPath := path.Join(integrationTestDir, fmt.Sprintf("testReadBoxMetadataDecompressorNotFound-%v.box", tc.Compression))
+		if err := ioutil.WriteFile(boxPath, append(tc.Magic, make([]byte, 512)...), 0666); err != nil {
+			t.Fatalf("Error trying to write input artifact file: %v\n", err)
+		}
+		_, err := ReadBoxMetadata(boxPath)
+		if !errors.Is(err, ErrDecompressorNotFound) {
+			t.Fatalf("Expected reading a %v box file without the '%v' command to fail with ErrDecompressorNotFound, but got: %v\n", tc.Compression, tc.Command, err)
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("'%v' command", tc.Command)) {
+			t.Fatalf("Expected the error to name the missing '%v' command, but got: %v\n", tc.Command, err)
+		}
+	}
+}
+
+func TestReadBoxMetadataCompression(t *testing.T) {
+	type TestCase struct {
+		Compression BoxCompression
+		// A command that writes a compressed copy of the file named by its last argument to stdout, or nil for gzip
+		Command []string
+	}
+	testCases := []TestCase{
+		TestCase{BoxCompressionNone, nil},
+		TestCase{BoxCompressionGzip, nil},
+		TestCase{BoxCompressionBzip2, []string{"bzip2", "-c"}},
+		TestCase{BoxCompressionXz, []string{"xz", "-c"}},
+		TestCase{BoxCompressionZstd, []string{"zstd", "-qc"}},
+	}
+
+	tarPath := path.Join(integrationTestDir, "testReadBoxMetadataCompression.tar")
+	if err := CreateTestBoxFileWithArchitecture(tarPath, "TESTPROVIDER", "arm64", false); err != nil {
+		t.Fatalf("Error trying to write input artifact file: %v\n", err)
+	}
+
+	for _, tc := range testCases {
+		boxPath := path.Join(integrationTestDir, fmt.Sprintf("testReadBoxMetadataCompression-%v.box", tc.Compression))
+		switch {
+		case tc.Compression == BoxCompressionNone:
+			if _, err := util.CopyFile(tarPath, boxPath); err != nil {
+				t.Fatalf("Error copying test box file: %v\n", err)
+			}
+		case tc.Compression == BoxCompressionGzip:
+			if err := CreateTestBoxFileWithArchitecture(boxPath, "TESTPROVIDER", "arm64", true); err != nil {
+				t.Fatalf("Error trying to write input artifact file: %v\n", err)
+			}
+		default:
+			if _, err := exec.LookPath(tc.Command[0]); err != nil {
+				t.Logf("Skipping %v compression, because the '%v' command is not installed\n", tc.Compression, tc.Command[0])
+				continue
+			}
+			compressed, err := exec.Command(tc.Command[0], append(tc.Command[1:], tarPath)...).Output()
+			if err != nil {
+				t.Fatalf("Error compressing test box file with %v: %v\n", tc.Command, err)
+			}
+			if err = ioutil.WriteFile(boxPath, compressed, 0666); err != nil {
+				t.Fatalf("Error trying to write input artifact file: %v\n", err)
+			}
+		}
+		before, err := ioutil.ReadFile(boxPath)
+		if err != nil {
+			t.Fatalf("Error reading test box file: %v\n", err)
+		}
+
+		if compression, err := DetectBoxCompression(boxPath); err != nil || compression != tc.Compression {
+			t.Fatalf("Expected DetectBoxCompression() to return %v, but got %v and error %v\n", tc.Compression, compression, err)
+		}
+		if compressed, err := IsCompressedBoxFile(boxPath); err != nil || compressed != (tc.Compression != BoxCompressionNone) {
+			t.Fatalf("Expected IsCompressedBoxFile() for %v to return %v, but got %v and error %v\n", tc.Compression, !compressed, compressed, err)
+		}
+		artifact, err := DeriveArtifactInfoFromBoxFile(boxPath, "")
+		if err != nil {
+			t.Fatalf("DeriveArtifactInfoFromBoxFile() for %v returned an error: %v\n", tc.Compression, err)
+		}
+		if artifact.Provider != "TESTPROVIDER" || artifact.Architecture != "arm64" {
+			t.Fatalf("Expected the metadata of the box compressed with %v, but got %+v\n", tc.Compression, artifact)
+		}
+		if after, err := ioutil.ReadFile(boxPath); err != nil || !bytes.Equal(before, after) {
+			t.Fatalf("Expected the box file compressed with %v to be unchanged, but got error %v\n", tc.Compression, err)
+		}
+	}
+
+	unknownPath := path.Join(integrationTestDir, "testReadBoxMetadataCompression-unknown.box")
+	if err := ioutil.WriteFile(unknownPath, []byte("PK\x03\x04 this is a zip file"), 0666); err != nil {
+		t.Fatalf("Error trying to write input artifact file: %v\n", err)
+	}
+	if _, err := ReadBoxMetadata(unknownPath); !errors.Is(err, ErrUnknownBoxCompression) {
+		t.Fatalf("Expected an error wrapping ErrUnknownBoxCompression for a zip file, but got %v\n", err)
+	}
+}
+
+func TestDeriveArtifactInfoFromBoxFileMetadata(t *testing.T) {
+	type TestCase struct {
+		BoxName          string
+		Files            map[string]string
+		ExpectedProvider string
+		ExpectError      bool
+	}
+	testCases := []TestCase{
+		TestCase{"packer_build_CoolProvider.box", map[string]string{"./metadata.json": `{"provider": "MetadataProvider"}`}, "MetadataProvider", false},
+		TestCase{"packer_build_CoolProvider.box", map[string]string{"box.ovf": "<ovf/>"}, "CoolProvider", false},
+		TestCase{"noprovider.box", map[string]string{"box.ovf": "<ovf/>"}, "", true},
+		TestCase{"packer_build_CoolProvider.box", map[string]string{"metadata.json": "not json"}, "", true},
+	}
+	for idx, tc := range testCases {
+		boxDir := path.Join(integrationTestDir, fmt.Sprintf("testDeriveMetadata%v", idx))
+		if err := os.MkdirAll(boxDir, 0777); err != nil {
+			t.Fatalf("Error creating directory: %v\n", err)
+		}
+		boxPath := path.Join(boxDir, tc.BoxName)
+		if err := writeTestTarBox(boxPath, tc.Files); err != nil {
+			t.Fatalf("Error trying to write input artifact file: %v\n", err)
+		}
+		artifact, err := DeriveArtifactInfoFromBoxFile(boxPath, "")
+		if tc.ExpectError {
+			if err == nil {
+				t.Fatalf("Expected an error for box with files %v, but got provider '%v'\n", tc.Files, artifact.Provider)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Error deriving artifact info for box with files %v: %v\n", tc.Files, err)
+		}
+		if artifact.Provider != tc.ExpectedProvider {
+			t.Fatalf("Expected provider '%v' but got '%v'\n", tc.ExpectedProvider, artifact.Provider)
+		}
+	}
+
+	invalidBoxPath := path.Join(integrationTestDir, "testDeriveMetadataInvalid_CoolProvider.box")
+	if err := ioutil.WriteFile(invalidBoxPath, []byte("this is not a tar archive"), 0666); err != nil {
+		t.Fatalf("Error trying to write input artifact file: %v\n", err)
+	}
+	if _, err := DeriveArtifactInfoFromBoxFile(invalidBoxPath, ""); err == nil {
+		t.Fatalf("Expected an error for a box file that is not a tar archive\n")
+	}
+}
+
+func TestValidateFilenameTemplate(t *testing.T) {
+	type TestCase struct {
+		Template    string
+		ExpectError bool
+	}
+	testCases := []TestCase{
+		TestCase{"", false},
+		TestCase{DefaultFilenameTemplate, false},
+		TestCase{"{{.Version}}_{{.Provider}}_{{.Architecture}}.box", false},
+		TestCase{"{{.Name}}-{{.Version}}-{{.Provider}}{{if .Architecture}}-{{.Architecture}}{{end}}.box", false},
+		TestCase{"{{.Name}}-{{.Version}}-{{.Provider}}.box", true},
+		TestCase{"{{.Name}}-{{.Provider}}-{{.Architecture}}.box", true},
+		TestCase{"{{.Name}}-{{.Version}}-{{.Architecture}}.box", true},
+		TestCase{"{{.Version}}/{{.Provider}}_{{.Architecture}}.box", true},
+		TestCase{"{{.Version}}_{{.Provider}}_{{.Architecture}}.tar.gz", true},
+		TestCase{"{{.Version}}_{{.Provider}}_{{.Architecture}}.box{{", true},
+		TestCase{"{{.Version}}_{{.Provider}}_{{.Architecture}}_{{.Color}}.box", true},
+	}
+	for _, tc := range testCases {
+		err := ValidateFilenameTemplate(tc.Template)
+		if tc.ExpectError && err == nil {
+			t.Fatalf("Expected filename template '%v' to be rejected\n", tc.Template)
+		} else if !tc.ExpectError && err != nil {
+			t.Fatalf("Expected filename template '%v' to be accepted, but got error %v\n", tc.Template, err)
+		}
+	}
+}
+
+func TestParseBoxFileName(t *testing.T) {
+	hyphenTemplate := "{{.Name}}-{{.Version}}-{{.Provider}}{{if .Architecture}}-{{.Architecture}}{{end}}.box"
+	type TestCase struct {
+		FileName     string
+		Template     string
+		Provider     string
+		Architecture string
+		Expected     BoxArtifact
+		ExpectError  bool
+	}
+	testCases := []TestCase{
+		TestCase{"testbox_1.0.0_virtualbox.box", "", "", "", BoxArtifact{Name: "testbox", Version: "1.0.0", Provider: "virtualbox"}, false},
+		TestCase{"test_box_1.0.0-PRE_virtualbox.box", "", "", "", BoxArtifact{Name: "test_box", Version: "1.0.0-PRE", Provider: "virtualbox"}, false},
+		TestCase{"testbox_1.0.0_virtualbox_arm64.box", "", "", "", BoxArtifact{Name: "testbox", Version: "1.0.0", Provider: "virtualbox", Architecture: "arm64"}, false},
+		TestCase{"testbox_1.0.0_vmware_desktop.box", "", "", "", BoxArtifact{Name: "testbox", Version: "1.0.0", Provider: "vmware", Architecture: "desktop"}, false},
+		TestCase{"testbox_1.0.0_vmware_desktop.box", "", "vmware_desktop", "", BoxArtifact{Name: "testbox", Version: "1.0.0", Provider: "vmware_desktop"}, false},
+		TestCase{"testbox_1.0.0_vmware_desktop_arm64.box", "", "vmware_desktop", "arm64", BoxArtifact{Name: "testbox", Version: "1.0.0", Provider: "vmware_desktop", Architecture: "arm64"}, false},
+		TestCase{"testbox-1.0.0-PRE-virtualbox.box", hyphenTemplate, "virtualbox", "", BoxArtifact{Name: "testbox", Version: "1.0.0-PRE", Provider: "virtualbox"}, false},
+		TestCase{"test-box-1.0.0-virtualbox-amd64.box", hyphenTemplate, "virtualbox", "amd64", BoxArtifact{Name: "test-box", Version: "1.0.0", Provider: "virtualbox", Architecture: "amd64"}, false},
+		TestCase{"1.0.0_virtualbox.box", "{{.Version}}_{{.Provider}}{{if .Architecture}}_{{.Architecture}}{{end}}.box", "virtualbox", "", BoxArtifact{Version: "1.0.0", Provider: "virtualbox"}, false},
+		TestCase{"testbox_1.0.0_libvirt.box", "", "virtualbox", "", BoxArtifact{}, true},
+		TestCase{"testbox_virtualbox.box", "", "", "", BoxArtifact{}, true},
+		TestCase{"testbox_1.0.0_virtualbox.box.sha256", "", "", "", BoxArtifact{}, true},
+		TestCase{"readme.txt", "", "", "", BoxArtifact{}, true},
+	}
+	for _, tc := range testCases {
+		artifact, err := ParseBoxFileName(tc.FileName, tc.Template, tc.Provider, tc.Architecture)
+		if tc.ExpectError {
+			if err == nil {
+				t.Fatalf("Expected ParseBoxFileName(%v) to return an error, but got %+v\n", tc.FileName, artifact)
+			}
+		} else if err != nil {
+			t.Fatalf("ParseBoxFileName(%v) returned an error: %v\n", tc.FileName, err)
+		} else if !reflect.DeepEqual(artifact, tc.Expected) {
+			t.Fatalf("Expected ParseBoxFileName(%v) to return %+v, but got %+v\n", tc.FileName, tc.Expected, artifact)
+		}
+	}
+}
+
+func TestFindBoxFiles(t *testing.T) {
+	dir := path.Join(integrationTestDir, "TestFindBoxFiles")
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("Error removing old test directory: %v\n", err)
+	}
+	if err := os.MkdirAll(path.Join(dir, "subdir"), 0777); err != nil {
+		t.Fatalf("Error creating test directory: %v\n", err)
+	}
+	boxes := map[string]string{
+		"testbox_1.0.0_vmware_desktop.box": "vmware_desktop",
+		"testbox_1.1.0_virtualbox.box":     "virtualbox",
+		"testbox_1.2.0_virtualbox.box":     "libvirt",
+		"subdir/testbox_2.0.0_libvirt.box": "libvirt",
+	}
+	for name, provider := range boxes {
+		if err := CreateTestBoxFile(path.Join(dir, name), provider, true); err != nil {
+			t.Fatalf("Error trying to create test box file: %v\n", err)
+		}
+	}
+	for _, name := range []string{"notes.txt", "testbox_1.3.0_virtualbox.box"} {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte("not a box"), 0666); err != nil {
+			t.Fatalf("Error writing test file: %v\n", err)
+		}
+	}
+
+	artifacts, err := FindBoxFiles(dir, "")
+	if err != nil {
+		t.Fatalf("FindBoxFiles() returned an error: %v\n", err)
+	}
+	expected := []BoxArtifact{
+		BoxArtifact{Path: path.Join(dir, "testbox_1.0.0_vmware_desktop.box"), Name: "testbox", Version: "1.0.0", Provider: "vmware_desktop"},
+		BoxArtifact{Path: path.Join(dir, "testbox_1.1.0_virtualbox.box"), Name: "testbox", Version: "1.1.0", Provider: "virtualbox"},
+	}
+	if !reflect.DeepEqual(artifacts, expected) {
+		t.Fatalf("Expected FindBoxFiles() to return %+v, but got %+v\n", expected, artifacts)
+	}
+}
@@ -26,36 +26,208 @@ Here's the JSON of an example catalog:
 package caryatid
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"net/url"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/mrled/caryatid/internal/util"
 )
 
 // Provider represents part of the structure of a Vagrant catalog
 // It holds a box name, as well as its URL, checksum, and checksum type
+// Newer versions of Vagrant also understand an optional architecture, like "amd64" or "arm64"
 type Provider struct {
 	Name         string `json:"name"`
 	Url          string `json:"url"`
 	ChecksumType string `json:"checksum_type"`
 	Checksum     string `json:"checksum"`
+	Architecture string `json:"architecture,omitempty"`
+
+	// The size of the box file in bytes, or 0 for boxes added before caryatid recorded it
+	Size int64 `json:"size,omitempty"`
+
+	// When the box was added to the catalog, as an RFC 3339 timestamp in UTC,
+	// or empty for boxes added before caryatid recorded it
+	ReleasedAt string `json:"released_at,omitempty"`
+
+	// Arbitrary metadata, like a build ID or a git commit, which Vagrant ignores; see ParseLabel()
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Checksums of several types, like sha256 and md5, for clients that need a type other than ChecksumType
+	// Vagrant only reads ChecksumType and Checksum, so those are always set too; this is empty unless more than one type was requested
+	Checksums []Checksum `json:"checksums,omitempty"`
+}
+
+// Checksum is one of the checksums of a box file in Provider.Checksums
+type Checksum struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// ReleasedTime parses the ReleasedAt timestamp
+// The result is false if the provider has no timestamp, or if it cannot be parsed
+func (p *Provider) ReleasedTime() (released time.Time, ok bool) {
+	if p.ReleasedAt == "" {
+		return
+	}
+	released, err := time.Parse(time.RFC3339, p.ReleasedAt)
+	return released, err == nil
 }
 
 // Equals will return true if all properties of both Provider structs match
+// A nil Labels map matches an empty one, and so do nil Checksums
 func (p1 *Provider) Equals(p2 *Provider) bool {
 	if p1 == nil || p2 == nil {
 		return false
 	}
-	return *p1 == *p2
+	return p1.Name == p2.Name &&
+		p1.Url == p2.Url &&
+		p1.ChecksumType == p2.ChecksumType &&
+		p1.Checksum == p2.Checksum &&
+		p1.Architecture == p2.Architecture &&
+		p1.Size == p2.Size &&
+		p1.ReleasedAt == p2.ReleasedAt &&
+		labelsEqual(p1.Labels, p2.Labels) &&
+		checksumsEqual(p1.Checksums, p2.Checksums)
+}
+
+// checksumsEqual returns true if both lists have the same checksums in the same order
+func checksumsEqual(checksums1 []Checksum, checksums2 []Checksum) bool {
+	if len(checksums1) != len(checksums2) {
+		return false
+	}
+	for idx := range checksums1 {
+		if checksums1[idx] != checksums2[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// labelsEqual returns true if both maps have the same labels
+func labelsEqual(labels1 map[string]string, labels2 map[string]string) bool {
+	if len(labels1) != len(labels2) {
+		return false
+	}
+	for key, value1 := range labels1 {
+		if value2, ok := labels2[key]; !ok || value1 != value2 {
+			return false
+		}
+	}
+	return true
+}
+
+// HasLabels returns true if the provider has every label in labels, with the same value
+func (p *Provider) HasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		if actual, ok := p.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// LabelString returns the provider's labels like 'build=1234,env=prod', sorted by key
+func (p *Provider) LabelString() string {
+	labels := make([]string, 0, len(p.Labels))
+	for key, value := range p.Labels {
+		labels = append(labels, fmt.Sprintf("%v=%v", key, value))
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
+
+// ParseLabel parses a label like 'build=1234' into its key and value
+// The key must not be empty or contain ',' or '='; the value may be empty, and may contain anything
+func ParseLabel(label string) (key string, value string, err error) {
+	parts := strings.SplitN(label, "=", 2)
+	if len(parts) != 2 {
+		err = fmt.Errorf("Invalid label '%v'; labels look like 'key=value'", label)
+		return
+	}
+	key, value = strings.TrimSpace(parts[0]), parts[1]
+	if key == "" || strings.Contains(key, ",") {
+		err = fmt.Errorf("Invalid label '%v'; the key must not be empty or contain ','", label)
+	}
+	return
+}
+
+// ParseLabelSelector parses a comma-separated list of labels, like 'env=prod,team=infra', which a provider must all have to match
+// An empty selector matches every provider
+func ParseLabelSelector(selector string) (labels map[string]string, err error) {
+	labels = make(map[string]string)
+	if strings.TrimSpace(selector) == "" {
+		return
+	}
+	for _, label := range strings.Split(selector, ",") {
+		var key, value string
+		if key, value, err = ParseLabel(label); err != nil {
+			return
+		}
+		labels[key] = value
+	}
+	return
 }
 
 // Version represents part of the structure of a Vagrant catalog
 // It holds a string representing the version, as well as an array of Provider structs
 type Version struct {
-	Version   string     `json:"version"`
+	Version string `json:"version"`
+
+	// A description of this version, which overrides the Catalog's Description, or empty to use the Catalog's Description
+	Description string `json:"description,omitempty"`
+
 	Providers []Provider `json:"providers"`
+
+	// Release channels that this version has been promoted to, like "stable" or "canary", sorted and without duplicates
+	// Unlike Provider.Labels, these describe the whole version; see Catalog.TagVersions()
+	// This is optional, and older catalogs do not have it
+	Tags []string `json:"tags,omitempty"`
+}
+
+// validTag matches the tags that ValidateTag() accepts
+var validTag = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateTag returns an error if tag cannot be used as a Version tag
+// A tag is made of letters, numbers, '.', '_', and '-', and starts with a letter or number
+func ValidateTag(tag string) (err error) {
+	if !validTag.MatchString(tag) {
+		err = fmt.Errorf("Invalid tag '%v'; a tag is made of letters, numbers, '.', '_', and '-', and starts with a letter or number", tag)
+	}
+	return
+}
+
+// HasTag returns true if the version has tag
+func (v *Version) HasTag(tag string) bool {
+	return util.StringInSlice(v.Tags, tag)
+}
+
+// normalizeTags returns the tags sorted and without duplicates, or nil if there are none
+func normalizeTags(tags []string) (result []string) {
+	for _, tag := range tags {
+		if !util.StringInSlice(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	sort.Strings(result)
+	return
+}
+
+// tagsEqual returns true if both lists have the same tags in the same order
+func tagsEqual(tags1 []string, tags2 []string) bool {
+	if len(tags1) != len(tags2) {
+		return false
+	}
+	for idx := range tags1 {
+		if tags1[idx] != tags2[idx] {
+			return false
+		}
+	}
+	return true
 }
 
 // Equals compares two Version structs - including each of their Providers - and returns true if they are equal
@@ -66,7 +238,7 @@ func (v1 *Version) Equals(v2 *Version) bool {
 	if v1 == v2 {
 		return true
 	}
-	if v1.Version != v2.Version || len(v1.Providers) != len(v2.Providers) {
+	if v1.Version != v2.Version || v1.Description != v2.Description || !tagsEqual(v1.Tags, v2.Tags) || len(v1.Providers) != len(v2.Providers) {
 		return false
 	}
 	for idx := 0; idx < len(v1.Providers); idx += 1 {
@@ -80,18 +252,378 @@ func (v1 *Version) Equals(v2 *Version) bool {
 // Catalog represents a Vagrant Catalog
 // It holds the box name, its description, and an array of Version structs
 type Catalog struct {
+	// The name Vagrant uses for the box, like "example/testbox"; this must not change, or Vagrant clients will not find the box
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Versions    []Version `json:"versions"`
+
+	// A friendlier name for people to read, like "Test Box", which Vagrant ignores
+	// This is optional, and catalogs without one are displayed with their Name
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// CatalogSchemaVersion is the version of caryatid's catalog format that this version of caryatid writes
+// It is saved in the catalog as caryatid_schema_version, which Vagrant ignores;
+// catalogs written before caryatid recorded it are version 0
+const CatalogSchemaVersion = 1
+
+// catalogDocument is a Catalog as it is serialized to a backend, along with caryatid's schema version
+type catalogDocument struct {
+	Catalog
+	SchemaVersion int `json:"caryatid_schema_version,omitempty"`
+}
+
+// JSONStyle determines how a catalog is formatted when it is saved; see BackendManager.JSONStyle
+// Catalogs are read the same way whatever style they were saved in
+type JSONStyle string
+
+const (
+	// Indented, with one field per line, for people to read
+	JSONStylePretty JSONStyle = "pretty"
+
+	// All on one line with no extra whitespace, which is smaller, and makes smaller diffs when catalogs are kept in version control
+	JSONStyleCompact JSONStyle = "compact"
+)
+
+// DefaultJSONStyle is used when the caller does not specify a JSONStyle
+const DefaultJSONStyle = JSONStylePretty
+
+// JSONStyles returns all supported JSON styles
+func JSONStyles() []JSONStyle {
+	return []JSONStyle{JSONStylePretty, JSONStyleCompact}
+}
+
+// ParseJSONStyle returns the JSONStyle named by style
+func ParseJSONStyle(style string) (result JSONStyle, err error) {
+	for _, supported := range JSONStyles() {
+		if JSONStyle(style) == supported {
+			return supported, nil
+		}
+	}
+	err = fmt.Errorf("Unknown JSON style '%v'; supported styles are: %v", style, JSONStyles())
+	return
+}
+
+// catalogMigrations upgrade a catalog from one schema version to the next
+// The migration at index N upgrades a catalog from version N to version N+1,
+// so there must always be exactly CatalogSchemaVersion of them
+var catalogMigrations = []func(*Catalog){
+	// Version 0 to 1: Vagrant expects arrays rather than nulls, and only understands lowercase checksum types;
+	// checksum types that are not supported even when lowercased are left alone
+	func(c *Catalog) {
+		if c.Versions == nil {
+			c.Versions = []Version{}
+		}
+		for vidx := range c.Versions {
+			if c.Versions[vidx].Providers == nil {
+				c.Versions[vidx].Providers = []Provider{}
+			}
+			for pidx := range c.Versions[vidx].Providers {
+				provider := &c.Versions[vidx].Providers[pidx]
+				lowered := strings.ToLower(strings.TrimSpace(provider.ChecksumType))
+				if _, ok := checksumHashes[lowered]; ok {
+					provider.ChecksumType = lowered
+				}
+			}
+		}
+	},
+}
+
+// MigrateCatalog upgrades a catalog with the given schema version in place to CatalogSchemaVersion,
+// and returns true if anything was migrated
+// A catalog that is already current, or that was written by a newer version of caryatid, is left alone
+func MigrateCatalog(catalog *Catalog, schemaVersion int) (migrated bool) {
+	if schemaVersion > CatalogSchemaVersion {
+		LogInfof("MigrateCatalog(): Catalog '%v' has schema version %v, which is newer than this version of caryatid understands (%v)\n", catalog.Name, schemaVersion, CatalogSchemaVersion)
+		return
+	}
+	for ; schemaVersion < CatalogSchemaVersion; schemaVersion += 1 {
+		LogInfof("MigrateCatalog(): Migrating catalog '%v' from schema version %v to %v\n", catalog.Name, schemaVersion, schemaVersion+1)
+		catalogMigrations[schemaVersion](catalog)
+		migrated = true
+	}
+	return
+}
+
+// versionsBySemver sorts Version structs by semantic version
+// Versions that cannot be parsed sort after all valid versions, in string order
+type versionsBySemver []Version
+
+func (versions versionsBySemver) Len() int {
+	return len(versions)
+}
+
+func (versions versionsBySemver) Swap(i, j int) {
+	versions[i], versions[j] = versions[j], versions[i]
+}
+
+func (versions versionsBySemver) Less(i, j int) bool {
+	cv1, err1 := NewComparableVersion(versions[i].Version)
+	cv2, err2 := NewComparableVersion(versions[j].Version)
+	switch {
+	case err1 != nil && err2 != nil:
+		return versions[i].Version < versions[j].Version
+	case err1 != nil:
+		return false
+	case err2 != nil:
+		return true
+	}
+	return cv1.Less(&cv2)
+}
+
+// SortVersions sorts a slice of Version structs in place, from lowest to highest semantic version
+func SortVersions(versions []Version) {
+	sort.Stable(versionsBySemver(versions))
+}
+
+// clone returns a copy of the catalog that shares no slices with the original, so that either can be modified without affecting the other
+func (c *Catalog) clone() (result Catalog) {
+	result = *c
+	if c.Versions != nil {
+		result.Versions = make([]Version, len(c.Versions))
+		for idx, v := range c.Versions {
+			result.Versions[idx] = v
+			if v.Tags != nil {
+				result.Versions[idx].Tags = append([]string{}, v.Tags...)
+			}
+			if v.Providers != nil {
+				result.Versions[idx].Providers = append([]Provider{}, v.Providers...)
+				for pidx, p := range v.Providers {
+					if p.Labels != nil {
+						labels := make(map[string]string, len(p.Labels))
+						for key, value := range p.Labels {
+							labels[key] = value
+						}
+						result.Versions[idx].Providers[pidx].Labels = labels
+					}
+					if p.Checksums != nil {
+						result.Versions[idx].Providers[pidx].Checksums = append([]Checksum{}, p.Checksums...)
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// Sorted returns a copy of the catalog with its Versions sorted by semantic version
+// The original catalog is not modified
+func (c *Catalog) Sorted() (result Catalog) {
+	result = *c
+	if c.Versions != nil {
+		result.Versions = append([]Version{}, c.Versions...)
+		SortVersions(result.Versions)
+	}
+	return
+}
+
+// SortedByRelease returns a copy of the catalog with its Versions, and the Providers of each Version, sorted by when they were released
+// A Version was released when its newest Provider was
+// Providers without a ReleasedAt timestamp, and Versions without any, sort first; otherwise, Versions are sorted semantically
+// The original catalog is not modified
+func (c *Catalog) SortedByRelease() (result Catalog) {
+	result = c.Sorted()
+	latest := make([]time.Time, len(result.Versions))
+	for vidx := range result.Versions {
+		providers := append([]Provider{}, result.Versions[vidx].Providers...)
+		sort.SliceStable(providers, func(i, j int) bool {
+			ti, _ := providers[i].ReleasedTime()
+			tj, _ := providers[j].ReleasedTime()
+			return ti.Before(tj)
+		})
+		result.Versions[vidx].Providers = providers
+		if len(providers) > 0 {
+			latest[vidx], _ = providers[len(providers)-1].ReleasedTime()
+		}
+	}
+	sort.Stable(versionsByRelease{result.Versions, latest})
+	return
+}
+
+// versionsByRelease sorts versions by the corresponding times in latest
+type versionsByRelease struct {
+	versions []Version
+	latest   []time.Time
+}
+
+func (v versionsByRelease) Len() int {
+	return len(v.versions)
+}
+func (v versionsByRelease) Less(i, j int) bool {
+	return v.latest[i].Before(v.latest[j])
+}
+func (v versionsByRelease) Swap(i, j int) {
+	v.versions[i], v.versions[j] = v.versions[j], v.versions[i]
+	v.latest[i], v.latest[j] = v.latest[j], v.latest[i]
+}
+
+// SortOrder determines the order of the Versions in a Catalog; see SortedBy()
+type SortOrder string
+
+const (
+	// Versions are sorted from lowest to highest semantic version
+	SortVersionAsc SortOrder = "version-asc"
+
+	// Versions are sorted from highest to lowest semantic version
+	SortVersionDesc SortOrder = "version-desc"
+
+	// Versions, and the Providers of each Version, are sorted from oldest to newest release; see SortedByRelease()
+	SortDateAsc SortOrder = "date-asc"
+
+	// Versions, and the Providers of each Version, are sorted from newest to oldest release
+	SortDateDesc SortOrder = "date-desc"
+)
+
+// DefaultSortOrder is used when the caller does not specify a SortOrder
+const DefaultSortOrder = SortVersionAsc
+
+// SortOrders returns all supported sort orders
+func SortOrders() []SortOrder {
+	return []SortOrder{SortVersionAsc, SortVersionDesc, SortDateAsc, SortDateDesc}
+}
+
+// ParseSortOrder returns the SortOrder named by order
+// It also accepts the older names "version", for SortVersionAsc, and "released", for SortDateAsc
+func ParseSortOrder(order string) (result SortOrder, err error) {
+	switch order {
+	case "version":
+		return SortVersionAsc, nil
+	case "released":
+		return SortDateAsc, nil
+	}
+	for _, supported := range SortOrders() {
+		if SortOrder(order) == supported {
+			return supported, nil
+		}
+	}
+	err = fmt.Errorf("Unknown sort order '%v'; supported orders are: %v", order, SortOrders())
+	return
+}
+
+// SortedBy returns a copy of the catalog with its Versions sorted in order
+// An empty order means DefaultSortOrder
+// Sorting is stable, so Versions that compare equal keep their order relative to each other, reversed for the descending orders
+// The original catalog is not modified
+func (c *Catalog) SortedBy(order SortOrder) (result Catalog, err error) {
+	switch order {
+	case "", SortVersionAsc:
+		result = c.Sorted()
+	case SortVersionDesc:
+		result = c.Sorted()
+		reverseVersions(result.Versions)
+	case SortDateAsc:
+		result = c.SortedByRelease()
+	case SortDateDesc:
+		result = c.SortedByRelease()
+		reverseVersions(result.Versions)
+		for vidx := range result.Versions {
+			providers := result.Versions[vidx].Providers
+			for i, j := 0, len(providers)-1; i < j; i, j = i+1, j-1 {
+				providers[i], providers[j] = providers[j], providers[i]
+			}
+		}
+	default:
+		err = fmt.Errorf("Unknown sort order '%v'; supported orders are: %v", order, SortOrders())
+	}
+	return
+}
+
+// reverseVersions reverses a slice of Version structs in place
+func reverseVersions(versions []Version) {
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
 }
 
+// DisplayString returns a human-readable representation of the catalog, with versions sorted semantically
 func (c *Catalog) DisplayString() (s string) {
-	s = fmt.Sprintf("%v (%v)\n", c.Name, c.Description)
+	sorted := c.Sorted()
+	return sorted.displayString()
+}
+
+// ReleaseDisplayString returns a human-readable representation of the catalog, with versions sorted by release; see SortedByRelease()
+func (c *Catalog) ReleaseDisplayString() (s string) {
+	sorted := c.SortedByRelease()
+	return sorted.displayString()
+}
+
+// SortedDisplayString returns a human-readable representation of the catalog, with versions sorted in order; see SortedBy()
+func (c *Catalog) SortedDisplayString(order SortOrder) (s string, err error) {
+	sorted, err := c.SortedBy(order)
+	if err != nil {
+		return
+	}
+	return sorted.displayString(), nil
+}
+
+// displayString returns a human-readable representation of the catalog, with versions in the order they are in the catalog
+func (c *Catalog) displayString() (s string) {
+	if c.DisplayName != "" && c.DisplayName != c.Name {
+		s = fmt.Sprintf("%v [%v] (%v)\n", c.DisplayName, c.Name, c.Description)
+	} else {
+		s = fmt.Sprintf("%v (%v)\n", c.Name, c.Description)
+	}
+	for _, v := range c.Versions {
+		s += fmt.Sprintf("  v%v", v.Version)
+		if v.Description != "" {
+			s += fmt.Sprintf(" (%v)", v.Description)
+		}
+		if len(v.Tags) > 0 {
+			s += fmt.Sprintf(" tags %v", strings.Join(v.Tags, ","))
+		}
+		s += "\n"
+		for _, p := range v.Providers {
+			name := p.Name
+			if p.Architecture != "" {
+				name = fmt.Sprintf("%v/%v", p.Name, p.Architecture)
+			}
+			s += fmt.Sprintf("    %v %v:%v <%v>", name, p.ChecksumType, p.Checksum, p.Url)
+			if p.Size > 0 {
+				s += fmt.Sprintf(" (%v)", util.FormatSize(p.Size))
+			}
+			if p.ReleasedAt != "" {
+				s += fmt.Sprintf(" released %v", p.ReleasedAt)
+			}
+			if len(p.Labels) > 0 {
+				s += fmt.Sprintf(" labels %v", p.LabelString())
+			}
+			s += "\n"
+		}
+	}
+	return
+}
+
+// TableChecksumWidth is the most columns TableString() uses for a checksum; longer checksums are truncated
+const TableChecksumWidth = 16
+
+// TableString returns the providers of the catalog as a text table with a header and aligned columns,
+// with versions in the order they are in the catalog
+func (c *Catalog) TableString() (s string) {
+	rows := [][]string{{"Version", "Provider", "Architecture", "Checksum Type", "Checksum", "URL"}}
 	for _, v := range c.Versions {
-		s += fmt.Sprintf("  v%v\n", v.Version)
 		for _, p := range v.Providers {
-			s += fmt.Sprintf("    %v %v:%v <%v>\n", p.Name, p.ChecksumType, p.Checksum, p.Url)
+			rows = append(rows, []string{v.Version, p.Name, p.Architecture, p.ChecksumType, util.TruncateString(p.Checksum, TableChecksumWidth), p.Url})
+		}
+	}
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for col, cell := range row {
+			if width := util.StringWidth(cell); width > widths[col] {
+				widths[col] = width
+			}
+		}
+	}
+	for _, row := range rows {
+		line := ""
+		for col, cell := range row {
+			if col == len(row)-1 {
+				line += cell
+			} else {
+				line += cell + strings.Repeat(" ", widths[col]-util.StringWidth(cell)+2)
+			}
 		}
+		s += strings.TrimRight(line, " ") + "\n"
 	}
 	return
 }
@@ -104,7 +636,7 @@ func (c1 *Catalog) Equals(c2 *Catalog) bool {
 	if c1 == c2 {
 		return true
 	}
-	if c1.Name != c2.Name || c1.Description != c2.Description || len(c1.Versions) != len(c2.Versions) {
+	if c1.Name != c2.Name || c1.DisplayName != c2.DisplayName || c1.Description != c2.Description || len(c1.Versions) != len(c2.Versions) {
 		return false
 	}
 	for idx := 0; idx < len(c1.Versions); idx += 1 {
@@ -116,53 +648,91 @@ func (c1 *Catalog) Equals(c2 *Catalog) bool {
 }
 
 func BoxUriFromCatalogUri(catalogUri string, name string, version string, provider string) (boxUri string, err error) {
+	boxDirUri, err := BoxDirUriFromCatalogUri(catalogUri, name)
+	if err != nil {
+		return
+	}
+	boxUri = fmt.Sprintf("%v/%v_%v_%v.box", boxDirUri, name, version, provider)
+	return
+}
+
+// BoxDirUriFromCatalogUri returns the URI of the directory (or prefix) that holds every box file for a box called name
+func BoxDirUriFromCatalogUri(catalogUri string, name string) (boxDirUri string, err error) {
 	lastSlashIdx := strings.LastIndex(catalogUri, "/")
 	if lastSlashIdx < 0 {
 		err = fmt.Errorf("Invalid URI: %v\n", catalogUri)
 		return
 	}
 	catalogParentUri := catalogUri[0:lastSlashIdx]
-	boxUri = fmt.Sprintf("%v/%v/%v_%v_%v.box", catalogParentUri, name, name, version, provider)
+	boxDirUri = fmt.Sprintf("%v/%v", catalogParentUri, name)
 	return
 }
 
 // AddBox updates the Catalog to include a new box file
 // The artifact's Name must match the Catalog's Name, if the Catalog already exists in storage
 // However, the artifact's Description always overwrites the Catalog's Description, even if they are different
+// The artifact's DisplayName overwrites the Catalog's DisplayName too, unless it is empty
 // This minimizes painful end-of-build errors,
 // and lets the user change their mind about the wording of the description
-func (c *Catalog) AddBox(catalogUri string, name string, description string, version string, provider string, checksumType string, checksum string) (err error) {
-	if c.Name != "" && name != "" && c.Name != name {
-		err = fmt.Errorf("Catalog.AddBox(): Catalog name '%v' does not match input name '%v'\n", c.Name, name)
+// Boxes with the same provider but different architectures are kept as separate Providers
+// A box for a version like "v1.2.3" is added to an existing "1.2.3", and the other way around; see NormalizeVersion()
+func (c *Catalog) AddBox(catalogUri string, artifact BoxArtifact) (err error) {
+	if c.Name != "" && artifact.Name != "" && c.Name != artifact.Name {
+		err = fmt.Errorf("Catalog.AddBox(): Catalog name '%v' does not match input name '%v'\n", c.Name, artifact.Name)
 		return
-	} else if name != "" && c.Name == "" {
-		c.Name = name
+	} else if artifact.Name != "" && c.Name == "" {
+		c.Name = artifact.Name
 	}
 	if c.Name == "" {
 		err = fmt.Errorf("Catalog.AddBox(): Catalog name could not be determined\n")
 	}
 
-	c.Description = description
+	c.Description = artifact.Description
+	if artifact.DisplayName != "" {
+		c.DisplayName = artifact.DisplayName
+	}
 
-	boxUri, err := BoxUriFromCatalogUri(catalogUri, name, version, provider)
-	if err != nil {
-		return
+	boxUri := artifact.Url
+	if boxUri == "" {
+		if boxUri, err = BoxUriFromCatalogUri(catalogUri, artifact.Name, artifact.Version, artifact.fileProvider()); err != nil {
+			return
+		}
 	}
 
-	newProvider := Provider{provider, boxUri, checksumType, checksum}
-	newVersion := Version{version, []Provider{newProvider}}
+	newProvider := Provider{
+		Name:         artifact.Provider,
+		Url:          boxUri,
+		ChecksumType: artifact.ChecksumType,
+		Checksum:     artifact.Checksum,
+		Architecture: artifact.Architecture,
+		Size:         artifact.Size,
+		Labels:       artifact.Labels,
+		Checksums:    artifact.Checksums,
+	}
+	if !artifact.ReleasedAt.IsZero() {
+		newProvider.ReleasedAt = artifact.ReleasedAt.UTC().Format(time.RFC3339)
+	}
+	newVersion := Version{Version: artifact.Version, Description: artifact.VersionDescription, Providers: []Provider{newProvider}}
 
 	foundVersion := false
 	foundProvider := false
 
 	for vidx, _ := range c.Versions {
-		if c.Versions[vidx].Version == version {
+		if versionsEqual(c.Versions[vidx].Version, artifact.Version) {
 			foundVersion = true
+			if artifact.VersionDescription != "" {
+				c.Versions[vidx].Description = artifact.VersionDescription
+			}
 			for pidx, _ := range c.Versions[vidx].Providers {
-				if c.Versions[vidx].Providers[pidx].Name == provider {
-					c.Versions[vidx].Providers[pidx].Url = boxUri
-					c.Versions[vidx].Providers[pidx].ChecksumType = checksumType
-					c.Versions[vidx].Providers[pidx].Checksum = checksum
+				existing := &c.Versions[vidx].Providers[pidx]
+				if existing.Name == artifact.Provider && existing.Architecture == artifact.Architecture {
+					existing.Url = boxUri
+					existing.ChecksumType = artifact.ChecksumType
+					existing.Checksum = artifact.Checksum
+					existing.Size = artifact.Size
+					existing.ReleasedAt = newProvider.ReleasedAt
+					existing.Labels = artifact.Labels
+					existing.Checksums = artifact.Checksums
 					foundProvider = true
 					break
 				}
@@ -180,61 +750,793 @@ func (c *Catalog) AddBox(catalogUri string, name string, description string, ver
 	return
 }
 
-// parseVersionQueryString parses a semver query string
-// The string must be a valid semantic version string, optionally preceded by a qualifier - one of < > <= or >=
-// If a semver doesn't have a qualifier, such as "1.0.0", return BOTH VersionEquals and VersionEqualsPrereleaseMismatch
-// However, if the semver has an equals qualifier, like "=1.0.0", return ONLY VersionEquals
-func parseVersionQueryString(semver string) (version ComparableVersion, qualifier VersionComparatorList, err error) {
-	if len(semver) == 0 {
-		return
+// CatalogProblem describes something wrong with the structure of a catalog
+// Version and ProviderName are empty if the problem is not specific to one of them
+type CatalogProblem struct {
+	Version      string
+	ProviderName string
+	Message      string
+}
+
+func (cp CatalogProblem) String() string {
+	switch {
+	case cp.Version == "":
+		return cp.Message
+	case cp.ProviderName == "":
+		return fmt.Sprintf("%v: %v", cp.Version, cp.Message)
 	}
+	return fmt.Sprintf("%v %v: %v", cp.Version, cp.ProviderName, cp.Message)
+}
 
-	// WARNING: The two-character prefixes must come first!
-	for _, prefix := range []string{">=", "<=", ">", "<", "="} {
-		if strings.HasPrefix(semver, prefix) {
-			if qualifier, err = NewVersionComparator(prefix); err != nil {
-				return
-			}
-			if version, err = NewComparableVersion(semver[len(prefix):]); err != nil {
-				log.Printf("FAILING HERE: %v, %v, %v\n%v\n", semver, prefix, qualifier, err)
-				return
+// findProvider returns the provider with the given name and architecture in the given version, or nil if there isn't one
+func (c *Catalog) findProvider(version string, name string, architecture string) *Provider {
+	for vidx := range c.Versions {
+		if !versionsEqual(c.Versions[vidx].Version, version) {
+			continue
+		}
+		for pidx := range c.Versions[vidx].Providers {
+			provider := &c.Versions[vidx].Providers[pidx]
+			if provider.Name == name && provider.Architecture == architecture {
+				return provider
 			}
-			break
 		}
 	}
-	if len(qualifier) == 0 {
-		qualifier = VersionComparatorList{VersionEquals, VersionEqualsPrereleaseMismatch}
-		if version, err = NewComparableVersion(semver); err != nil {
-			return
-		}
+	return nil
+}
+
+// Check validates the structure of the catalog without reference to any backend, and returns every problem it finds
+// A valid catalog has a name, every version parses as a semantic version and has at least one provider,
+// no provider appears twice in the same version, and every provider has a well-formed URL
+// URLs may be relative to the catalog, like 'name/name_1.0.0_virtualbox.box'; see BackendManager.RelativeUrls
+func (c *Catalog) Check() (problems []CatalogProblem) {
+	if c.Name == "" {
+		problems = append(problems, CatalogProblem{Message: "The catalog has no name"})
 	}
 
+	seen := make(map[BoxReference]bool)
+	for _, v := range c.Versions {
+		if _, err := NewComparableVersion(v.Version); err != nil {
+			problems = append(problems, CatalogProblem{Version: v.Version, Message: fmt.Sprintf("Invalid version: %v", err)})
+		}
+		if len(v.Providers) == 0 {
+			problems = append(problems, CatalogProblem{Version: v.Version, Message: "No providers"})
+		}
+		for idx, tag := range v.Tags {
+			if util.StringInSlice(v.Tags[:idx], tag) {
+				problems = append(problems, CatalogProblem{Version: v.Version, Message: fmt.Sprintf("Duplicate tag '%v'", tag)})
+			} else if err := ValidateTag(tag); err != nil {
+				problems = append(problems, CatalogProblem{Version: v.Version, Message: err.Error()})
+			}
+		}
+		for _, p := range v.Providers {
+			ref := BoxReference{Version: v.Version, ProviderName: p.Name, Architecture: p.Architecture}
+			if seen[ref] {
+				problems = append(problems, CatalogProblem{Version: v.Version, ProviderName: p.Name, Message: "Duplicate provider"})
+			}
+			seen[ref] = true
+
+			if p.Name == "" {
+				problems = append(problems, CatalogProblem{Version: v.Version, Message: "Provider has no name"})
+			}
+			if u, err := url.Parse(p.Url); err != nil {
+				problems = append(problems, CatalogProblem{Version: v.Version, ProviderName: p.Name, Message: fmt.Sprintf("Invalid URL '%v': %v", p.Url, err)})
+			} else if u.Scheme == "" && (u.Path == "" || strings.HasPrefix(u.Path, "/")) {
+				problems = append(problems, CatalogProblem{Version: v.Version, ProviderName: p.Name, Message: fmt.Sprintf("Invalid URL '%v'", p.Url)})
+			} else if u.Scheme != "" && u.Host == "" && u.Path == "" {
+				problems = append(problems, CatalogProblem{Version: v.Version, ProviderName: p.Name, Message: fmt.Sprintf("Invalid URL '%v'", p.Url)})
+			}
+		}
+	}
 	return
 }
 
-// CatalogQueryParams represents valid parameters for QueryCatalog(), below
-type CatalogQueryParams struct {
-	Version  string
-	Provider string
+// CatalogStats summarizes the contents of a catalog
+type CatalogStats struct {
+	Versions      int      `json:"versions"`
+	Providers     int      `json:"providers"`
+	ProviderNames []string `json:"provider_names"`
+
+	// The lowest and highest semantic versions in the catalog, ignoring versions that cannot be parsed
+	OldestVersion string `json:"oldest_version,omitempty"`
+	NewestVersion string `json:"newest_version,omitempty"`
+
+	// The total size of the box files whose size the catalog records
+	TotalSize int64 `json:"total_size"`
+	// The number of providers whose size the catalog does not record, because they were added before caryatid recorded it
+	UnsizedProviders int `json:"unsized_providers"`
 }
 
-// QueryCatalogVersions returns a new Catalog containing only Versions match the versionquery input string
-// If the caller has provided an *exact* version like "=1.0.0",
-// assume they do NOT want to find prerelease-mismatched versions;
-// If the caller has provided a version *range* like "<=1.0.0",
-// assume they DO want to find prerelease-mismatched versions.
-func (catalog *Catalog) QueryCatalogVersions(versionquery string) (result Catalog, err error) {
-	var (
-		comparator VersionComparator
-		cVers      ComparableVersion
-		queryVers  ComparableVersion
-		queryQual  VersionComparatorList
-	)
+// Stats summarizes the catalog
+func (c *Catalog) Stats() (stats CatalogStats) {
+	stats.ProviderNames = []string{}
+	names := make(map[string]bool)
+
+	sorted := c.Sorted()
+	for _, v := range sorted.Versions {
+		stats.Versions += 1
+		if _, err := NewComparableVersion(v.Version); err == nil {
+			if stats.OldestVersion == "" {
+				stats.OldestVersion = v.Version
+			}
+			stats.NewestVersion = v.Version
+		}
+		for _, p := range v.Providers {
+			stats.Providers += 1
+			if !names[p.Name] {
+				names[p.Name] = true
+				stats.ProviderNames = append(stats.ProviderNames, p.Name)
+			}
+			if p.Size > 0 {
+				stats.TotalSize += p.Size
+			} else {
+				stats.UnsizedProviders += 1
+			}
+		}
+	}
+	sort.Strings(stats.ProviderNames)
+	return
+}
+
+// DisplayString returns a human-readable summary, one statistic per line
+func (stats CatalogStats) DisplayString() (s string) {
+	s += fmt.Sprintf("Versions: %v\n", stats.Versions)
+	s += fmt.Sprintf("Providers: %v\n", stats.Providers)
+	s += fmt.Sprintf("Provider names: %v\n", strings.Join(stats.ProviderNames, ", "))
+	s += fmt.Sprintf("Oldest version: %v\n", stats.OldestVersion)
+	s += fmt.Sprintf("Newest version: %v\n", stats.NewestVersion)
+	switch {
+	case stats.Providers == 0 || stats.UnsizedProviders == stats.Providers:
+		s += "Total size: unknown\n"
+	case stats.UnsizedProviders > 0:
+		s += fmt.Sprintf("Total size: %v, not counting %v providers of unknown size\n", util.FormatSize(stats.TotalSize), stats.UnsizedProviders)
+	default:
+		s += fmt.Sprintf("Total size: %v\n", util.FormatSize(stats.TotalSize))
+	}
+	return
+}
+
+// Deduplicate returns a new Catalog where each Version appears once, and each Version has at most one Provider for each Name and Architecture
+// Versions are the same if they are equal once normalized, like "v1.2.3" and "1.2.3"; see NormalizeVersion()
+// Duplicates keep the position of the first occurrence but the contents of the last,
+// since later entries come from more recent calls to AddBox(); duplicate Versions keep the tags of all of them
+// The result also reports how many duplicate Providers were removed
+func (c *Catalog) Deduplicate() (result Catalog, removed int) {
+	result.Name = c.Name
+	result.DisplayName = c.DisplayName
+	result.Description = c.Description
+
+	versionIdx := make(map[string]int)
+	for _, v := range c.Versions {
+		vidx, ok := versionIdx[NormalizeVersion(v.Version)]
+		if !ok {
+			vidx = len(result.Versions)
+			versionIdx[NormalizeVersion(v.Version)] = vidx
+			result.Versions = append(result.Versions, Version{Version: v.Version, Description: v.Description, Providers: []Provider{}})
+		}
+		deduped := &result.Versions[vidx]
+		if v.Description != "" {
+			deduped.Description = v.Description
+		}
+		deduped.Tags = normalizeTags(append(append([]string{}, deduped.Tags...), v.Tags...))
+		for _, p := range v.Providers {
+			found := false
+			for pidx := range deduped.Providers {
+				if deduped.Providers[pidx].Name == p.Name && deduped.Providers[pidx].Architecture == p.Architecture {
+					deduped.Providers[pidx] = p
+					found = true
+					removed += 1
+					break
+				}
+			}
+			if !found {
+				deduped.Providers = append(deduped.Providers, p)
+			}
+		}
+	}
+	return
+}
+
+// duplicateProviders returns a new Catalog with the Providers that Deduplicate() removes,
+// which is every occurrence of a duplicate Provider but the last
+func (c *Catalog) duplicateProviders() (result Catalog) {
+	result.Name = c.Name
+	result.DisplayName = c.DisplayName
+	result.Description = c.Description
+
+	providerKey := func(v Version, p Provider) string {
+		return fmt.Sprintf("%v/%v/%v", NormalizeVersion(v.Version), p.Name, p.Architecture)
+	}
+	remaining := make(map[string]int)
+	for _, v := range c.Versions {
+		for _, p := range v.Providers {
+			remaining[providerKey(v, p)] += 1
+		}
+	}
+	for _, v := range c.Versions {
+		duplicates := Version{Version: v.Version, Description: v.Description, Providers: []Provider{}, Tags: v.Tags}
+		for _, p := range v.Providers {
+			if remaining[providerKey(v, p)] -= 1; remaining[providerKey(v, p)] > 0 {
+				duplicates.Providers = append(duplicates.Providers, p)
+			}
+		}
+		if len(duplicates.Providers) > 0 {
+			result.Versions = append(result.Versions, duplicates)
+		}
+	}
+	return
+}
+
+// Merge returns a new Catalog with the Versions and Providers of both c and src
+// Providers are identified by their Version, Name, and Architecture, where Versions are compared once normalized; see NormalizeVersion()
+// when both catalogs have the same Provider, the one from c is kept, unless overwrite is set
+// Duplicate Providers within a single catalog are collapsed, keeping the first
+// A Version in both catalogs has the tags of both
+// The result keeps the Name, DisplayName, and Description of c, unless c has no Name
+func (c *Catalog) Merge(src *Catalog, overwrite bool) (result Catalog) {
+	result.Name = c.Name
+	result.DisplayName = c.DisplayName
+	result.Description = c.Description
+	if result.Name == "" {
+		result.Name = src.Name
+		result.DisplayName = src.DisplayName
+		result.Description = src.Description
+	}
+
+	versionIdx := make(map[string]int)
+	mergeVersions := func(versions []Version, replace bool) {
+		for _, v := range versions {
+			vidx, ok := versionIdx[NormalizeVersion(v.Version)]
+			if !ok {
+				vidx = len(result.Versions)
+				versionIdx[NormalizeVersion(v.Version)] = vidx
+				result.Versions = append(result.Versions, Version{Version: v.Version, Description: v.Description, Providers: []Provider{}})
+			}
+			merged := &result.Versions[vidx]
+			if v.Description != "" && (replace || merged.Description == "") {
+				merged.Description = v.Description
+			}
+			merged.Tags = normalizeTags(append(append([]string{}, merged.Tags...), v.Tags...))
+			for _, p := range v.Providers {
+				found := false
+				for pidx := range merged.Providers {
+					if merged.Providers[pidx].Name == p.Name && merged.Providers[pidx].Architecture == p.Architecture {
+						if replace {
+							merged.Providers[pidx] = p
+						}
+						found = true
+						break
+					}
+				}
+				if !found {
+					merged.Providers = append(merged.Providers, p)
+				}
+			}
+		}
+	}
+	mergeVersions(c.Versions, false)
+	mergeVersions(src.Versions, overwrite)
+	return
+}
+
+// CatalogFuzzyEqualsParams selects which properties FuzzyEquals and Diff skip when comparing two catalogs
+// SkipDescription skips the DisplayName as well as the Description
+type CatalogFuzzyEqualsParams struct {
+	SkipName                 bool
+	SkipDescription          bool
+	SkipVersions             bool
+	SkipVersionString        bool
+	SkipProviders            bool
+	SkipProviderName         bool
+	SkipProviderUrl          bool
+	SkipProviderChecksumType bool
+	SkipProviderChecksum     bool
+	SkipProviderArchitecture bool
+	LogMismatch              bool
+}
+
+// CatalogDifference describes one way in which two catalogs differ
+// Version and ProviderName are empty if the difference is not specific to one of them
+type CatalogDifference struct {
+	Version      string
+	ProviderName string
+	Architecture string
+	Message      string
+}
+
+func (cd CatalogDifference) String() string {
+	switch {
+	case cd.Version == "":
+		return cd.Message
+	case cd.ProviderName == "":
+		return fmt.Sprintf("%v: %v", cd.Version, cd.Message)
+	case cd.Architecture == "":
+		return fmt.Sprintf("%v %v: %v", cd.Version, cd.ProviderName, cd.Message)
+	}
+	return fmt.Sprintf("%v %v/%v: %v", cd.Version, cd.ProviderName, cd.Architecture, cd.Message)
+}
+
+// Diff compares c1 to c2 and returns every difference it finds
+// Unlike FuzzyEquals, Versions are matched by their normalized version string, and Providers by their Name and Architecture,
+// so the order of either does not matter
+// The params select properties to skip, as for FuzzyEquals; SkipVersionString and SkipProviderName have no effect,
+// since those properties are how Versions and Providers are matched
+func (c1 *Catalog) Diff(c2 *Catalog, params CatalogFuzzyEqualsParams) (differences []CatalogDifference) {
+	differ := func(property string, value1 string, value2 string) string {
+		return fmt.Sprintf("%v differs: '%v' in the first catalog, '%v' in the second", property, value1, value2)
+	}
+
+	if !params.SkipName && c1.Name != c2.Name {
+		differences = append(differences, CatalogDifference{Message: differ("Name", c1.Name, c2.Name)})
+	}
+	if !params.SkipDescription && c1.DisplayName != c2.DisplayName {
+		differences = append(differences, CatalogDifference{Message: differ("DisplayName", c1.DisplayName, c2.DisplayName)})
+	}
+	if !params.SkipDescription && c1.Description != c2.Description {
+		differences = append(differences, CatalogDifference{Message: differ("Description", c1.Description, c2.Description)})
+	}
+	if params.SkipVersions {
+		return
+	}
+
+	providerKey := func(p Provider) string {
+		if params.SkipProviderArchitecture {
+			return p.Name
+		}
+		return p.Name + "/" + p.Architecture
+	}
+	versions2 := make(map[string]Version)
+	for _, v2 := range c2.Versions {
+		versions2[NormalizeVersion(v2.Version)] = v2
+	}
+
+	sorted1 := c1.Sorted()
+	seen := make(map[string]bool)
+	for _, v1 := range sorted1.Versions {
+		seen[NormalizeVersion(v1.Version)] = true
+		v2, ok := versions2[NormalizeVersion(v1.Version)]
+		if !ok {
+			differences = append(differences, CatalogDifference{Version: v1.Version, Message: "Only in the first catalog"})
+			continue
+		}
+		if params.SkipProviders {
+			continue
+		}
+
+		providers2 := make(map[string]Provider)
+		for _, p2 := range v2.Providers {
+			providers2[providerKey(p2)] = p2
+		}
+		seenProviders := make(map[string]bool)
+		for _, p1 := range v1.Providers {
+			key := providerKey(p1)
+			seenProviders[key] = true
+			difference := CatalogDifference{Version: v1.Version, ProviderName: p1.Name, Architecture: p1.Architecture}
+			p2, ok := providers2[key]
+			if !ok {
+				difference.Message = "Only in the first catalog"
+				differences = append(differences, difference)
+				continue
+			}
+			if !params.SkipProviderUrl && p1.Url != p2.Url {
+				difference.Message = differ("URL", p1.Url, p2.Url)
+				differences = append(differences, difference)
+			}
+			if !params.SkipProviderChecksumType && p1.ChecksumType != p2.ChecksumType {
+				difference.Message = differ("Checksum type", p1.ChecksumType, p2.ChecksumType)
+				differences = append(differences, difference)
+			}
+			if !params.SkipProviderChecksum && p1.Checksum != p2.Checksum {
+				difference.Message = differ("Checksum", p1.Checksum, p2.Checksum)
+				differences = append(differences, difference)
+			}
+		}
+		for _, p2 := range v2.Providers {
+			if !seenProviders[providerKey(p2)] {
+				differences = append(differences, CatalogDifference{Version: v2.Version, ProviderName: p2.Name, Architecture: p2.Architecture, Message: "Only in the second catalog"})
+			}
+		}
+	}
+
+	sorted2 := c2.Sorted()
+	for _, v2 := range sorted2.Versions {
+		if !seen[NormalizeVersion(v2.Version)] {
+			differences = append(differences, CatalogDifference{Version: v2.Version, Message: "Only in the second catalog"})
+		}
+	}
+
+	if params.LogMismatch {
+		for _, difference := range differences {
+			LogInfof("Diff() for '%v ?= %v': %v\n", c1.Name, c2.Name, difference)
+		}
+	}
+	return
+}
+
+// versionConstraint is a single version and the comparators that a matching version may have relative to it
+type versionConstraint struct {
+	Version   ComparableVersion
+	Qualifier VersionComparatorList
+}
+
+// Matches returns true if cvers satisfies the constraint
+func (vc *versionConstraint) Matches(cvers *ComparableVersion) bool {
+	return vc.Qualifier.Contains(VersionComparatorList{cvers.Compare(&vc.Version)})
+}
+
+// pessimisticUpperBound returns the exclusive upper bound for a pessimistic "~>" constraint,
+// by dropping the last component of the version and incrementing the one before it,
+// so that "~> 1.2" is bounded by 2.0 and "~> 1.2.3" is bounded by 1.3
+func pessimisticUpperBound(version ComparableVersion) (upper ComparableVersion) {
+	if len(version.Version) <= 1 {
+		upper.Version = append(upper.Version, version.Version...)
+	} else {
+		upper.Version = append(upper.Version, version.Version[0:len(version.Version)-1]...)
+	}
+	upper.Version[len(upper.Version)-1] += 1
+	return
+}
+
+// parseVersionQueryString parses a semver query string into a list of constraints, all of which a version must match
+// The string is a comma-separated list of constraints, like ">=1.0.0, <2.0.0"; an empty string has no constraints
+// Each constraint is parsed by parseVersionConstraint()
+func parseVersionQueryString(query string) (constraints []versionConstraint, err error) {
+	var parsed []versionConstraint
+
+	if len(strings.TrimSpace(query)) == 0 {
+		return
+	}
+
+	for _, semver := range strings.Split(query, ",") {
+		if parsed, err = parseVersionConstraint(semver); err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, parsed...)
+	}
+	return
+}
+
+// parseVersionConstraint parses a single constraint from a semver query string
+// The string must be a valid semantic version string, optionally preceded by a qualifier - one of < > <= >= = or ~>
+// If a semver doesn't have a qualifier, such as "1.0.0", match BOTH VersionEquals and VersionEqualsPrereleaseMismatch
+// However, if the semver has an equals qualifier, like "=1.0.0", match ONLY VersionEquals
+// A pessimistic qualifier, like "~> 1.2", is the same as ">=1.2" combined with "<2.0";
+// "~> 1.2.3" is the same as ">=1.2.3" combined with "<1.3.0"
+func parseVersionConstraint(semver string) (constraints []versionConstraint, err error) {
+	var (
+		version   ComparableVersion
+		qualifier VersionComparatorList
+	)
+
+	semver = strings.TrimSpace(semver)
+	if len(semver) == 0 {
+		err = fmt.Errorf("Empty version constraint")
+		return
+	}
+
+	if strings.HasPrefix(semver, "~>") {
+		if version, err = NewComparableVersion(strings.TrimSpace(semver[len("~>"):])); err != nil {
+			return
+		}
+		lower, _ := NewVersionComparator(">=")
+		upper, _ := NewVersionComparator("<")
+		constraints = []versionConstraint{
+			versionConstraint{version, lower},
+			versionConstraint{pessimisticUpperBound(version), upper},
+		}
+		return
+	}
+
+	// WARNING: The two-character prefixes must come first!
+	for _, prefix := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(semver, prefix) {
+			if qualifier, err = NewVersionComparator(prefix); err != nil {
+				return
+			}
+			if version, err = NewComparableVersion(strings.TrimSpace(semver[len(prefix):])); err != nil {
+				return
+			}
+			break
+		}
+	}
+	if len(qualifier) == 0 {
+		qualifier = VersionComparatorList{VersionEquals, VersionEqualsPrereleaseMismatch}
+		if version, err = NewComparableVersion(semver); err != nil {
+			return
+		}
+	}
+
+	constraints = []versionConstraint{versionConstraint{version, qualifier}}
+	return
+}
+
+// globToRegexp converts a glob, where an asterisk matches any sequence of characters, to an anchored regular expression
+// Every other character, including a slash, matches only itself
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	pattern := strings.Replace(regexp.QuoteMeta(glob), `\*`, ".*", -1)
+	return regexp.Compile(fmt.Sprintf("^%v$", pattern))
+}
+
+// ProviderMatchMode determines how a provider query is matched against provider names
+type ProviderMatchMode string
+
+const (
+	// The query is an unanchored regular expression, so "rongSap" and ".*rongSap.*" both match "StrongSapling"
+	ProviderMatchRegex ProviderMatchMode = "regex"
+
+	// The query is a glob that must match the whole name, where an asterisk matches any sequence of characters, like "*-iso"
+	ProviderMatchGlob ProviderMatchMode = "glob"
+
+	// The query is a literal string that must appear somewhere in the name
+	ProviderMatchSubstring ProviderMatchMode = "substring"
+)
+
+// DefaultProviderMatchMode is used when the caller does not specify a ProviderMatchMode
+const DefaultProviderMatchMode = ProviderMatchRegex
+
+// ProviderMatchModes returns all supported provider match modes
+func ProviderMatchModes() []ProviderMatchMode {
+	return []ProviderMatchMode{ProviderMatchRegex, ProviderMatchGlob, ProviderMatchSubstring}
+}
+
+// providerMatcher returns a function that tests whether a provider name matches providerquery
+// An empty providerquery matches every name, regardless of mode
+func providerMatcher(providerquery string, mode ProviderMatchMode) (matcher func(string) bool, err error) {
+	var providerRegex *regexp.Regexp
+
+	if providerquery == "" {
+		return func(string) bool { return true }, nil
+	}
+
+	switch mode {
+	case ProviderMatchRegex, "":
+		if providerRegex, err = regexp.Compile(providerquery); err != nil {
+			return nil, fmt.Errorf("Invalid provider regular expression '%v': %v", providerquery, err)
+		}
+	case ProviderMatchGlob:
+		if providerRegex, err = globToRegexp(providerquery); err != nil {
+			return nil, fmt.Errorf("Invalid provider glob '%v': %v", providerquery, err)
+		}
+	case ProviderMatchSubstring:
+		return func(name string) bool { return strings.Contains(name, providerquery) }, nil
+	default:
+		return nil, fmt.Errorf("Unknown provider match mode '%v'; supported modes are: %v", mode, ProviderMatchModes())
+	}
+	return providerRegex.MatchString, nil
+}
+
+// PrereleaseMode determines how a query treats prerelease versions, like "1.0.0-BETA"
+type PrereleaseMode string
+
+const (
+	// Prereleases match like any other version, except that a "latest" query skips them
+	PrereleaseDefault PrereleaseMode = ""
+
+	// Prereleases match like any other version, and a "latest" query may return one
+	PrereleaseInclude PrereleaseMode = "include"
+
+	// Prereleases are dropped before the query, so they never match
+	PrereleaseExclude PrereleaseMode = "exclude"
+)
+
+// CatalogQueryParams represents valid parameters for QueryCatalog(), below
+type CatalogQueryParams struct {
+	// A glob matched against the catalog's Name, like "*win10*"
+	// If the Name does not match, QueryCatalog() returns an empty Catalog
+	Name string
+
+	// A version query like ">=1.0.0, <2.0.0"; see parseVersionQueryString()
+	// May also be "latest" or "newest"; see IsLatestVersionQuery()
+	Version string
+
+	// Whether Version is a literal version string rather than a version query; see QueryCatalogExactVersion()
+	ExactVersion bool
+
+	Provider string
+
+	// How Provider is matched against provider names; defaults to DefaultProviderMatchMode
+	ProviderMatch ProviderMatchMode
+
+	// An architecture like "amd64" that must exactly match a provider's Architecture
+	// If empty, providers of any architecture (or none) match
+	Architecture string
+
+	// Labels like "env=prod,team=infra" that a provider must all have; see ParseLabelSelector()
+	// This applies before a "latest" query, so that it finds the latest version with matching labels
+	LabelSelector string
+
+	// A tag that a version must have, like "stable"; see Version.Tags
+	// This applies before a "latest" query, so that it finds the latest version with the tag
+	Tag string
+
+	// Whether prerelease versions, like "1.0.0-BETA", may be in the result; see PrereleaseMode
+	// By default they may, except that a "latest" query returns the latest non-prerelease version
+	Prerelease PrereleaseMode
+
+	// The order of the Versions in the result; see SortedBy()
+	// If this is empty, and the result is not paged with Offset or Limit, the Versions are in the order they are in the catalog
+	Sort SortOrder
+
+	// Skip the first Offset matching versions, and then return at most Limit of them, counting in the order of Sort
+	// A Limit of 0 means no limit; see Page()
+	Offset int
+	Limit  int
+}
+
+// IsLatestVersionQuery returns true for version queries that select only the highest version in the catalog
+func IsLatestVersionQuery(versionquery string) bool {
+	switch strings.TrimSpace(versionquery) {
+	case "latest", "newest":
+		return true
+	}
+	return false
+}
+
+// LatestVersion returns a new Catalog containing only the Version with the highest semantic version
+// If the catalog has no Versions, neither does the result
+func (catalog *Catalog) LatestVersion() (result Catalog, err error) {
+	var (
+		latest      *Version
+		latestVers  ComparableVersion
+		versionVers ComparableVersion
+	)
 	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
 	result.Description = catalog.Description
-	if queryVers, queryQual, err = parseVersionQueryString(versionquery); err != nil {
+
+	for idx := range catalog.Versions {
+		if versionVers, err = NewComparableVersion(catalog.Versions[idx].Version); err != nil {
+			return
+		}
+		if latest == nil || latestVers.Less(&versionVers) {
+			latest = &catalog.Versions[idx]
+			latestVers = versionVers
+		}
+	}
+
+	if latest != nil {
+		result.Versions = []Version{*latest}
+	}
+	return
+}
+
+// latestReferences returns a reference to the box in the highest semantic version of each provider and architecture,
+// keyed by the provider component of its box file's name; see BoxArtifact.fileProvider()
+func (catalog *Catalog) latestReferences() (latest map[string]BoxReference, err error) {
+	var versionVers ComparableVersion
+	latest = make(map[string]BoxReference)
+	latestVers := make(map[string]ComparableVersion)
+
+	for _, version := range catalog.Versions {
+		if versionVers, err = NewComparableVersion(version.Version); err != nil {
+			return
+		}
+		for _, provider := range version.Providers {
+			artifact := BoxArtifact{Provider: provider.Name, Architecture: provider.Architecture}
+			key := artifact.fileProvider()
+			if current, ok := latestVers[key]; ok && !current.Less(&versionVers) {
+				continue
+			}
+			latest[key] = BoxReference{Version: version.Version, ProviderName: provider.Name, Architecture: provider.Architecture, Uri: provider.Url}
+			latestVers[key] = versionVers
+		}
+	}
+	return
+}
+
+// WithoutPrereleases returns a new Catalog containing only Versions that do not have a prerelease tag
+func (catalog *Catalog) WithoutPrereleases() (result Catalog, err error) {
+	var cVers ComparableVersion
+	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
+	result.Description = catalog.Description
+	for _, version := range catalog.Versions {
+		if cVers, err = NewComparableVersion(version.Version); err != nil {
+			return
+		}
+		if cVers.Prerelease == "" {
+			result.Versions = append(result.Versions, version)
+		}
+	}
+	return
+}
+
+// PruneCandidates returns a new Catalog containing every Version except the keep newest, by semantic version
+// If prunePrereleases is set, prerelease Versions are always included in the result, and do not count towards keep
+func (catalog *Catalog) PruneCandidates(keep int, prunePrereleases bool) (result Catalog, err error) {
+	var cVers ComparableVersion
+	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
+	result.Description = catalog.Description
+
+	if keep < 0 {
+		err = fmt.Errorf("Cannot keep a negative number of versions: %v", keep)
 		return
-	} else if len(queryVers.Version) == 0 {
+	}
+
+	sorted := catalog.Sorted()
+	kept := 0
+	for idx := len(sorted.Versions) - 1; idx >= 0; idx -= 1 {
+		version := sorted.Versions[idx]
+		if cVers, err = NewComparableVersion(version.Version); err != nil {
+			return
+		}
+		if prunePrereleases && cVers.Prerelease != "" {
+			result.Versions = append(result.Versions, version)
+		} else if kept < keep {
+			kept += 1
+		} else {
+			result.Versions = append(result.Versions, version)
+		}
+	}
+	return
+}
+
+// ErrUnknownSize means that the catalog does not record the size of a box, as for boxes added before caryatid recorded sizes
+var ErrUnknownSize = errors.New("The catalog does not record the size of the box")
+
+// PruneToSize returns a new Catalog containing every Version except the newest ones whose boxes fit in maxTotalSize bytes together,
+// along with the total size of the Versions that are kept
+// Versions are kept newest first, by semantic version, until one does not fit; it and every older Version are in the result
+// If prunePrereleases is set, prerelease Versions are always included in the result, and do not count towards maxTotalSize
+// Every box must have its size recorded, or the result is an error wrapping ErrUnknownSize
+func (catalog *Catalog) PruneToSize(maxTotalSize int64, prunePrereleases bool) (result Catalog, remaining int64, err error) {
+	var cVers ComparableVersion
+	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
+	result.Description = catalog.Description
+
+	if maxTotalSize < 0 {
+		err = fmt.Errorf("Cannot keep a negative total size: %v", maxTotalSize)
+		return
+	}
+	for _, v := range catalog.Versions {
+		for _, p := range v.Providers {
+			if p.Size <= 0 {
+				err = fmt.Errorf("%w for %v %v", ErrUnknownSize, v.Version, p.Name)
+				return
+			}
+		}
+	}
+
+	sorted := catalog.Sorted()
+	full := false
+	for idx := len(sorted.Versions) - 1; idx >= 0; idx -= 1 {
+		version := sorted.Versions[idx]
+		if cVers, err = NewComparableVersion(version.Version); err != nil {
+			return
+		}
+		var size int64
+		for _, p := range version.Providers {
+			size += p.Size
+		}
+		if prunePrereleases && cVers.Prerelease != "" {
+			result.Versions = append(result.Versions, version)
+		} else if !full && remaining+size <= maxTotalSize {
+			remaining += size
+		} else {
+			full = true
+			result.Versions = append(result.Versions, version)
+		}
+	}
+	return
+}
+
+// QueryCatalogVersions returns a new Catalog containing only Versions match the versionquery input string
+// If the caller has provided an *exact* version like "=1.0.0",
+// assume they do NOT want to find prerelease-mismatched versions;
+// If the caller has provided a version *range* like "<=1.0.0",
+// assume they DO want to find prerelease-mismatched versions.
+func (catalog *Catalog) QueryCatalogVersions(versionquery string) (result Catalog, err error) {
+	var (
+		cVers       ComparableVersion
+		constraints []versionConstraint
+	)
+	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
+	result.Description = catalog.Description
+	if constraints, err = parseVersionQueryString(versionquery); err != nil {
+		return
+	} else if len(constraints) == 0 {
 		result = *catalog
 		return
 	}
@@ -243,24 +1545,68 @@ func (catalog *Catalog) QueryCatalogVersions(versionquery string) (result Catalo
 		if cVers, err = NewComparableVersion(version.Version); err != nil {
 			return
 		}
-		comparator = cVers.Compare(&queryVers)
 
-		if queryQual.Contains(VersionComparatorList{comparator}) {
+		matched := true
+		for _, constraint := range constraints {
+			if !constraint.Matches(&cVers) {
+				matched = false
+				break
+			}
+		}
+		if matched {
 			result.Versions = append(result.Versions, version)
 		}
 	}
 	return
 }
 
+// versionQueryOperators are the characters that a version query may contain, but a literal version may not
+const versionQueryOperators = "<>=~,"
+
+// QueryCatalogExactVersion returns a new Catalog containing only the Version whose version string is exactly version
+// Unlike QueryCatalogVersions(), version is not parsed as a query, so "1.0.0" does not match "1.0.0-PRE";
+// the strings are compared after normalizing them, so "v1.0.0" matches "1.0.0"; see NormalizeVersion()
+// It is an error for version to be empty, or to contain version query operators like ">=" or ","
+func (catalog *Catalog) QueryCatalogExactVersion(version string) (result Catalog, err error) {
+	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
+	result.Description = catalog.Description
+	version = strings.TrimSpace(version)
+	if version == "" {
+		err = fmt.Errorf("An exact version query requires a version")
+		return
+	} else if strings.ContainsAny(version, versionQueryOperators) {
+		err = fmt.Errorf("Exact version '%v' must not contain any of the version query operators '%v'", version, versionQueryOperators)
+		return
+	}
+	for _, v := range catalog.Versions {
+		if versionsEqual(v.Version, version) {
+			result.Versions = append(result.Versions, v)
+		}
+	}
+	return
+}
+
 // QueryCatalogProviders returns a new Catalog containing only Providers that have a .Name property matching the providerquery input string
+// The providerquery is an unanchored regular expression; see QueryCatalogProvidersByMode() for other kinds of queries
 func (catalog *Catalog) QueryCatalogProviders(providerquery string) (result Catalog, err error) {
+	return catalog.QueryCatalogProvidersByMode(providerquery, ProviderMatchRegex)
+}
+
+// QueryCatalogProvidersByMode returns a new Catalog containing only Providers that have a .Name property matching the providerquery input string,
+// where the mode determines how providerquery is interpreted
+func (catalog *Catalog) QueryCatalogProvidersByMode(providerquery string, mode ProviderMatchMode) (result Catalog, err error) {
 	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
 	result.Description = catalog.Description
-	providerRegex := regexp.MustCompile(providerquery)
+	matches, err := providerMatcher(providerquery, mode)
+	if err != nil {
+		return
+	}
 	for _, version := range catalog.Versions {
-		newVersion := Version{version.Version, []Provider{}}
+		newVersion := Version{Version: version.Version, Description: version.Description, Providers: []Provider{}, Tags: version.Tags}
 		for _, provider := range version.Providers {
-			if providerRegex.Match([]byte(provider.Name)) {
+			if matches(provider.Name) {
 				newVersion.Providers = append(newVersion.Providers, provider)
 			}
 		}
@@ -272,17 +1618,190 @@ func (catalog *Catalog) QueryCatalogProviders(providerquery string) (result Cata
 }
 
 // QueryCatalog returns a new catalog containing only matching boxes from a CatalogQueryParams input query
+// A "latest" version query is applied after the provider query,
+// so that it finds the latest version that has a matching provider
 func (catalog *Catalog) QueryCatalog(params CatalogQueryParams) (result Catalog, err error) {
 	var vResult, pResult Catalog
-	if vResult, err = catalog.QueryCatalogVersions(params.Version); err != nil {
+	if params.Name != "" {
+		var nameRegex *regexp.Regexp
+		if nameRegex, err = globToRegexp(params.Name); err != nil {
+			return
+		}
+		if !nameRegex.MatchString(catalog.Name) {
+			return
+		}
+	}
+	if params.LabelSelector != "" {
+		var labeled Catalog
+		if labeled, err = catalog.QueryCatalogLabels(params.LabelSelector); err != nil {
+			return
+		}
+		catalog = &labeled
+	}
+	if params.Tag != "" {
+		tagged := catalog.QueryCatalogTag(params.Tag)
+		catalog = &tagged
+	}
+	if params.Prerelease == PrereleaseExclude {
+		var released Catalog
+		if released, err = catalog.WithoutPrereleases(); err != nil {
+			return
+		}
+		catalog = &released
+	}
+	if params.ExactVersion {
+		if vResult, err = catalog.QueryCatalogExactVersion(params.Version); err != nil {
+			return
+		}
+		if pResult, err = vResult.QueryCatalogProvidersByMode(params.Provider, params.ProviderMatch); err != nil {
+			return
+		}
+	} else if IsLatestVersionQuery(params.Version) {
+		if pResult, err = catalog.QueryCatalogProvidersByMode(params.Provider, params.ProviderMatch); err != nil {
+			return
+		}
+		if params.Prerelease == PrereleaseDefault {
+			if pResult, err = pResult.WithoutPrereleases(); err != nil {
+				return
+			}
+		}
+		if pResult, err = pResult.LatestVersion(); err != nil {
+			return
+		}
+	} else {
+		if vResult, err = catalog.QueryCatalogVersions(params.Version); err != nil {
+			return
+		}
+		if pResult, err = vResult.QueryCatalogProvidersByMode(params.Provider, params.ProviderMatch); err != nil {
+			return
+		}
+	}
+	result = pResult.QueryCatalogArchitecture(params.Architecture)
+	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
+	result.Description = catalog.Description
+	if params.Sort != "" || params.Offset > 0 || params.Limit > 0 {
+		if result, err = result.SortedBy(params.Sort); err != nil {
+			return
+		}
+	}
+	if params.Offset > 0 || params.Limit > 0 {
+		result = result.page(params.Offset, params.Limit)
+	}
+	return
+}
+
+// Page returns a copy of the catalog, sorted by semantic version, with at most limit Versions starting at offset
+// A limit of 0 means no limit
+func (catalog *Catalog) Page(offset int, limit int) (result Catalog) {
+	sorted := catalog.Sorted()
+	return sorted.page(offset, limit)
+}
+
+// page returns a copy of the catalog with at most limit Versions starting at offset, in the order they are in the catalog
+func (catalog *Catalog) page(offset int, limit int) (result Catalog) {
+	result = *catalog
+	if offset >= len(result.Versions) {
+		result.Versions = []Version{}
 		return
 	}
-	if pResult, err = vResult.QueryCatalogProviders(params.Provider); err != nil {
+	result.Versions = result.Versions[offset:]
+	if limit > 0 && limit < len(result.Versions) {
+		result.Versions = result.Versions[:limit]
+	}
+	return
+}
+
+// QueryCatalogLabels returns a new Catalog containing only Providers that have all the labels in selector; see ParseLabelSelector()
+func (catalog *Catalog) QueryCatalogLabels(selector string) (result Catalog, err error) {
+	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
+	result.Description = catalog.Description
+	labels, err := ParseLabelSelector(selector)
+	if err != nil {
 		return
 	}
-	result = pResult
+	for _, version := range catalog.Versions {
+		newVersion := Version{Version: version.Version, Description: version.Description, Providers: []Provider{}, Tags: version.Tags}
+		for _, provider := range version.Providers {
+			if provider.HasLabels(labels) {
+				newVersion.Providers = append(newVersion.Providers, provider)
+			}
+		}
+		if len(newVersion.Providers) > 0 {
+			result.Versions = append(result.Versions, newVersion)
+		}
+	}
+	return
+}
+
+// QueryCatalogTag returns a new Catalog containing only Versions that have tag
+// An empty tag matches every Version
+func (catalog *Catalog) QueryCatalogTag(tag string) (result Catalog) {
+	if tag == "" {
+		return *catalog
+	}
+	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
+	result.Description = catalog.Description
+	for _, version := range catalog.Versions {
+		if version.HasTag(tag) {
+			result.Versions = append(result.Versions, version)
+		}
+	}
+	return
+}
+
+// TagVersions returns a copy of the catalog where each Version in versions has every tag in add, and none in remove,
+// along with the Versions whose tags changed
+// Adding a tag that a Version already has, or removing one that it does not, changes nothing
+func (c *Catalog) TagVersions(versions []string, add []string, remove []string) (result Catalog, changed []string, err error) {
+	for _, tag := range append(append([]string{}, add...), remove...) {
+		if err = ValidateTag(tag); err != nil {
+			return
+		}
+	}
+	result = c.clone()
+	for vidx := range result.Versions {
+		version := &result.Versions[vidx]
+		if !util.StringInSlice(versions, version.Version) {
+			continue
+		}
+		tags := []string{}
+		for _, tag := range normalizeTags(append(append([]string{}, version.Tags...), add...)) {
+			if !util.StringInSlice(remove, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		tags = normalizeTags(tags)
+		if !tagsEqual(tags, version.Tags) {
+			version.Tags = tags
+			changed = append(changed, version.Version)
+		}
+	}
+	return
+}
+
+// QueryCatalogArchitecture returns a new Catalog containing only Providers whose Architecture is exactly architecture
+// An empty architecture matches every Provider
+func (catalog *Catalog) QueryCatalogArchitecture(architecture string) (result Catalog) {
+	if architecture == "" {
+		return *catalog
+	}
 	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
 	result.Description = catalog.Description
+	for _, version := range catalog.Versions {
+		newVersion := Version{Version: version.Version, Description: version.Description, Providers: []Provider{}, Tags: version.Tags}
+		for _, provider := range version.Providers {
+			if provider.Architecture == architecture {
+				newVersion.Providers = append(newVersion.Providers, provider)
+			}
+		}
+		if len(newVersion.Providers) > 0 {
+			result.Versions = append(result.Versions, newVersion)
+		}
+	}
 	return
 }
 
@@ -290,12 +1809,13 @@ func (catalog *Catalog) QueryCatalog(params CatalogQueryParams) (result Catalog,
 // Note that this function *only* works with *exact* matches.
 func (catalog *Catalog) deleteBoxes(vStrings []string, pStrings []string) (result Catalog) {
 	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
 	result.Description = catalog.Description
 
 	for _, version := range catalog.Versions {
 
 		if !util.StringInSlice(vStrings, version.Version) {
-			newVersion := Version{version.Version, []Provider{}}
+			newVersion := Version{Version: version.Version, Description: version.Description, Providers: []Provider{}, Tags: version.Tags}
 			for _, provider := range version.Providers {
 				if !util.StringInSlice(pStrings, provider.Name) {
 					newVersion.Providers = append(newVersion.Providers, provider)
@@ -314,13 +1834,17 @@ func (catalog *Catalog) deleteBoxes(vStrings []string, pStrings []string) (resul
 type BoxReference struct {
 	Version      string
 	ProviderName string
+	Architecture string
 	Uri          string
+
+	// The size of the box file in bytes, from the provider's Size; 0 if it is not known
+	Size int64
 }
 
-// Compare the two key fields of a BoxReference: Version and ProviderName
-// Within a given Catalog, these two values should be enough to uniquely identify a box
+// Compare the key fields of a BoxReference: Version, ProviderName, and Architecture
+// Within a given Catalog, these values should be enough to uniquely identify a box
 func (br1 *BoxReference) Equals(br2 BoxReference) bool {
-	return br1.Version == br2.Version && br1.ProviderName == br2.ProviderName
+	return br1.Version == br2.Version && br1.ProviderName == br2.ProviderName && br1.Architecture == br2.Architecture
 }
 
 type BoxReferenceList []BoxReference
@@ -337,21 +1861,24 @@ func (list BoxReferenceList) Contains(br BoxReference) bool {
 func (catalog *Catalog) BoxReferences() (result BoxReferenceList) {
 	for _, v := range catalog.Versions {
 		for _, p := range v.Providers {
-			result = append(result, BoxReference{Version: v.Version, ProviderName: p.Name, Uri: p.Url})
+			result = append(result, BoxReference{Version: v.Version, ProviderName: p.Name, Architecture: p.Architecture, Uri: p.Url, Size: p.Size})
 		}
 	}
 
 	return
 }
 
+// DeleteReferences returns a new Catalog without the referenced Providers
+// Versions that are left without any Providers are dropped
 func (catalog *Catalog) DeleteReferences(references BoxReferenceList) (result Catalog) {
 	result.Name = catalog.Name
+	result.DisplayName = catalog.DisplayName
 	result.Description = catalog.Description
 
 	for _, v := range catalog.Versions {
-		newVersion := Version{Version: v.Version, Providers: []Provider{}}
+		newVersion := Version{Version: v.Version, Description: v.Description, Providers: []Provider{}, Tags: v.Tags}
 		for _, p := range v.Providers {
-			thisBox := BoxReference{Version: v.Version, ProviderName: p.Name}
+			thisBox := BoxReference{Version: v.Version, ProviderName: p.Name, Architecture: p.Architecture}
 			if !references.Contains(thisBox) {
 				newVersion.Providers = append(newVersion.Providers, p)
 			}
@@ -2,7 +2,10 @@ package caryatid
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -54,13 +57,13 @@ func TestJsonDecodingEmptyCatalog(t *testing.T) {
 }
 
 func TestProviderEquals(t *testing.T) {
-	matchingp1 := Provider{"TestProviderX", "http://example.com/pX", "TestChecksum", "0xB00B135"}
-	matchingp2 := Provider{"TestProviderX", "http://example.com/pX", "TestChecksum", "0xB00B135"}
+	matchingp1 := Provider{Name: "TestProviderX", Url: "http://example.com/pX", ChecksumType: "TestChecksum", Checksum: "0xB00B135"}
+	matchingp2 := Provider{Name: "TestProviderX", Url: "http://example.com/pX", ChecksumType: "TestChecksum", Checksum: "0xB00B135"}
 	unmatchingp := []Provider{
-		Provider{"TestProviderYaaaas", "http://example.com/pX", "TestChecksum", "0xB00B135"},
-		Provider{"TestProviderX", "http://example.com/pother", "TestChecksum", "0xB00B135"},
-		Provider{"TestProviderX", "http://example.com/pX", "DifferentChecksum", "0xB00B135"},
-		Provider{"TestProviderX", "http://example.com/pX", "TestChecksum", "0xDECAFBADxxxxx"},
+		Provider{Name: "TestProviderYaaaas", Url: "http://example.com/pX", ChecksumType: "TestChecksum", Checksum: "0xB00B135"},
+		Provider{Name: "TestProviderX", Url: "http://example.com/pother", ChecksumType: "TestChecksum", Checksum: "0xB00B135"},
+		Provider{Name: "TestProviderX", Url: "http://example.com/pX", ChecksumType: "DifferentChecksum", Checksum: "0xB00B135"},
+		Provider{Name: "TestProviderX", Url: "http://example.com/pX", ChecksumType: "TestChecksum", Checksum: "0xDECAFBADxxxxx"},
 	}
 	if !matchingp1.Equals(&matchingp2) {
 		t.Fatal("Providers that should have matched do not match")
@@ -73,15 +76,15 @@ func TestProviderEquals(t *testing.T) {
 }
 
 func TestVersionEquals(t *testing.T) {
-	p1 := Provider{"TestProviderOne", "http://example.com/One", "TestChecksum", "0xB00B135"}
-	p2 := Provider{"TestProviderTwo", "http://example.com/Two", "TestChecksum", "0xB00B135"}
+	p1 := Provider{Name: "TestProviderOne", Url: "http://example.com/One", ChecksumType: "TestChecksum", Checksum: "0xB00B135"}
+	p2 := Provider{Name: "TestProviderTwo", Url: "http://example.com/Two", ChecksumType: "TestChecksum", Checksum: "0xB00B135"}
 
-	matchingv1 := Version{"1.2.3", []Provider{p1, p2}}
-	matchingv2 := Version{"1.2.3", []Provider{p1, p2}}
+	matchingv1 := Version{Version: "1.2.3", Providers: []Provider{p1, p2}}
+	matchingv2 := Version{Version: "1.2.3", Providers: []Provider{p1, p2}}
 	unmatchingv := []Version{
-		Version{"1.2.3", []Provider{p2}},
-		Version{"1.2.4", []Provider{p1}},
-		Version{"1.2.3", []Provider{p1, p2, p2}},
+		Version{Version: "1.2.3", Providers: []Provider{p2}},
+		Version{Version: "1.2.4", Providers: []Provider{p1}},
+		Version{Version: "1.2.3", Providers: []Provider{p1, p2, p2}},
 	}
 	if !matchingv1.Equals(&matchingv2) {
 		t.Fatal("Versions that should have matched did not match")
@@ -94,17 +97,17 @@ func TestVersionEquals(t *testing.T) {
 }
 
 func TestCatalogEquals(t *testing.T) {
-	p1 := Provider{"TestProvider", "http://example.com/Provider", "TestChecksum", "0xB00B135"}
-	v1 := Version{"1.2.3", []Provider{p1}}
-	v2 := Version{"1.2.4", []Provider{p1}}
-	matchingc1 := Catalog{"SomeName", "This is a desc", []Version{v1, v2}}
-	matchingc2 := Catalog{"SomeName", "This is a desc", []Version{v1, v2}}
+	p1 := Provider{Name: "TestProvider", Url: "http://example.com/Provider", ChecksumType: "TestChecksum", Checksum: "0xB00B135"}
+	v1 := Version{Version: "1.2.3", Providers: []Provider{p1}}
+	v2 := Version{Version: "1.2.4", Providers: []Provider{p1}}
+	matchingc1 := Catalog{Name: "SomeName", Description: "This is a desc", Versions: []Version{v1, v2}}
+	matchingc2 := Catalog{Name: "SomeName", Description: "This is a desc", Versions: []Version{v1, v2}}
 	unmatchingc := []Catalog{
-		Catalog{"SomeOtherName", "This is a desc", []Version{v1, v2}},
-		Catalog{"SomeName", "This is a completely different desc", []Version{v1, v2}},
-		Catalog{"SomeName", "This is a desc", []Version{v1}},
-		Catalog{"SomeName", "This is a desc", []Version{v1, v2, v2}},
-		Catalog{"SomeName", "This is a desc", []Version{v2, v1}},
+		Catalog{Name: "SomeOtherName", Description: "This is a desc", Versions: []Version{v1, v2}},
+		Catalog{Name: "SomeName", Description: "This is a completely different desc", Versions: []Version{v1, v2}},
+		Catalog{Name: "SomeName", Description: "This is a desc", Versions: []Version{v1}},
+		Catalog{Name: "SomeName", Description: "This is a desc", Versions: []Version{v1, v2, v2}},
+		Catalog{Name: "SomeName", Description: "This is a desc", Versions: []Version{v2, v1}},
 	}
 
 	if !matchingc1.Equals(&matchingc2) {
@@ -129,7 +132,7 @@ func TestCatalogAddBox(t *testing.T) {
 	addBoxChecksum := "0xDECAFBAD"
 
 	addAndCompareCata := func(description string, initial *Catalog, expected *Catalog, boxName string, boxDesc string, boxVers string, boxProv string, boxCheckType string, boxCheck string) {
-		if err := initial.AddBox(addBoxCataUri, boxName, boxDesc, boxVers, boxProv, boxCheckType, boxCheck); err != nil {
+		if err := initial.AddBox(addBoxCataUri, BoxArtifact{Name: boxName, Description: boxDesc, Version: boxVers, Provider: boxProv, ChecksumType: boxCheckType, Checksum: boxCheck}); err != nil {
 			t.Fatal(fmt.Sprintf("Error calling AddBox in test '%v': %v", description, err))
 		}
 		if !initial.Equals(expected) {
@@ -140,9 +143,9 @@ func TestCatalogAddBox(t *testing.T) {
 	addAndCompareCata(
 		"Add box to empty catalog",
 		&Catalog{},
-		&Catalog{addBoxName, addBoxDesc, []Version{
-			Version{addBoxVers, []Provider{
-				Provider{addBoxProv, addBoxExpectedUrl, addBoxCheckType, addBoxChecksum},
+		&Catalog{Name: addBoxName, Description: addBoxDesc, Versions: []Version{
+			Version{Version: addBoxVers, Providers: []Provider{
+				Provider{Name: addBoxProv, Url: addBoxExpectedUrl, ChecksumType: addBoxCheckType, Checksum: addBoxChecksum},
 			}},
 		}},
 		addBoxName, addBoxDesc, addBoxVers, addBoxProv, addBoxCheckType, addBoxChecksum,
@@ -150,14 +153,14 @@ func TestCatalogAddBox(t *testing.T) {
 
 	addAndCompareCata(
 		"Add box to catalog where it's already present",
-		&Catalog{addBoxName, addBoxDesc, []Version{
-			Version{addBoxVers, []Provider{
-				Provider{addBoxProv, addBoxExpectedUrl, addBoxCheckType, addBoxChecksum},
+		&Catalog{Name: addBoxName, Description: addBoxDesc, Versions: []Version{
+			Version{Version: addBoxVers, Providers: []Provider{
+				Provider{Name: addBoxProv, Url: addBoxExpectedUrl, ChecksumType: addBoxCheckType, Checksum: addBoxChecksum},
 			}},
 		}},
-		&Catalog{addBoxName, addBoxDesc, []Version{
-			Version{addBoxVers, []Provider{
-				Provider{addBoxProv, addBoxExpectedUrl, addBoxCheckType, addBoxChecksum},
+		&Catalog{Name: addBoxName, Description: addBoxDesc, Versions: []Version{
+			Version{Version: addBoxVers, Providers: []Provider{
+				Provider{Name: addBoxProv, Url: addBoxExpectedUrl, ChecksumType: addBoxCheckType, Checksum: addBoxChecksum},
 			}},
 		}},
 		addBoxName, addBoxDesc, addBoxVers, addBoxProv, addBoxCheckType, addBoxChecksum,
@@ -165,10 +168,10 @@ func TestCatalogAddBox(t *testing.T) {
 
 	addAndCompareCata(
 		"Add box to catalog with empty version",
-		&Catalog{addBoxName, addBoxDesc, []Version{}},
-		&Catalog{addBoxName, addBoxDesc, []Version{
-			Version{addBoxVers, []Provider{
-				Provider{addBoxProv, addBoxExpectedUrl, addBoxCheckType, addBoxChecksum},
+		&Catalog{Name: addBoxName, Description: addBoxDesc, Versions: []Version{}},
+		&Catalog{Name: addBoxName, Description: addBoxDesc, Versions: []Version{
+			Version{Version: addBoxVers, Providers: []Provider{
+				Provider{Name: addBoxProv, Url: addBoxExpectedUrl, ChecksumType: addBoxCheckType, Checksum: addBoxChecksum},
 			}},
 		}},
 		addBoxName, addBoxDesc, addBoxVers, addBoxProv, addBoxCheckType, addBoxChecksum,
@@ -176,17 +179,17 @@ func TestCatalogAddBox(t *testing.T) {
 
 	addAndCompareCata(
 		"Add box to catalog with different version",
-		&Catalog{addBoxName, addBoxDesc, []Version{
-			Version{"2.3.0", []Provider{
-				Provider{addBoxProv, addBoxExpectedUrl, addBoxCheckType, addBoxChecksum},
+		&Catalog{Name: addBoxName, Description: addBoxDesc, Versions: []Version{
+			Version{Version: "2.3.0", Providers: []Provider{
+				Provider{Name: addBoxProv, Url: addBoxExpectedUrl, ChecksumType: addBoxCheckType, Checksum: addBoxChecksum},
 			}},
 		}},
-		&Catalog{addBoxName, addBoxDesc, []Version{
-			Version{"2.3.0", []Provider{
-				Provider{addBoxProv, addBoxExpectedUrl, addBoxCheckType, addBoxChecksum},
+		&Catalog{Name: addBoxName, Description: addBoxDesc, Versions: []Version{
+			Version{Version: "2.3.0", Providers: []Provider{
+				Provider{Name: addBoxProv, Url: addBoxExpectedUrl, ChecksumType: addBoxCheckType, Checksum: addBoxChecksum},
 			}},
-			Version{addBoxVers, []Provider{
-				Provider{addBoxProv, addBoxExpectedUrl, addBoxCheckType, addBoxChecksum},
+			Version{Version: addBoxVers, Providers: []Provider{
+				Provider{Name: addBoxProv, Url: addBoxExpectedUrl, ChecksumType: addBoxCheckType, Checksum: addBoxChecksum},
 			}},
 		}},
 		addBoxName, addBoxDesc, addBoxVers, addBoxProv, addBoxCheckType, addBoxChecksum,
@@ -194,15 +197,15 @@ func TestCatalogAddBox(t *testing.T) {
 
 	addAndCompareCata(
 		"Add box to catalog with empty provider",
-		&Catalog{addBoxName, addBoxDesc, []Version{
-			Version{addBoxVers, []Provider{
+		&Catalog{Name: addBoxName, Description: addBoxDesc, Versions: []Version{
+			Version{Version: addBoxVers, Providers: []Provider{
 				Provider{},
 			}},
 		}},
-		&Catalog{addBoxName, addBoxDesc, []Version{
-			Version{addBoxVers, []Provider{
+		&Catalog{Name: addBoxName, Description: addBoxDesc, Versions: []Version{
+			Version{Version: addBoxVers, Providers: []Provider{
 				Provider{},
-				Provider{addBoxProv, addBoxExpectedUrl, addBoxCheckType, addBoxChecksum},
+				Provider{Name: addBoxProv, Url: addBoxExpectedUrl, ChecksumType: addBoxCheckType, Checksum: addBoxChecksum},
 			}},
 		}},
 		addBoxName, addBoxDesc, addBoxVers, addBoxProv, addBoxCheckType, addBoxChecksum,
@@ -210,15 +213,15 @@ func TestCatalogAddBox(t *testing.T) {
 
 	addAndCompareCata(
 		"Add box to catalog with different provider",
-		&Catalog{addBoxName, addBoxDesc, []Version{
-			Version{addBoxVers, []Provider{
-				Provider{"differentProvider", addBoxExpectedUrl, addBoxCheckType, addBoxChecksum},
+		&Catalog{Name: addBoxName, Description: addBoxDesc, Versions: []Version{
+			Version{Version: addBoxVers, Providers: []Provider{
+				Provider{Name: "differentProvider", Url: addBoxExpectedUrl, ChecksumType: addBoxCheckType, Checksum: addBoxChecksum},
 			}},
 		}},
-		&Catalog{addBoxName, addBoxDesc, []Version{
-			Version{addBoxVers, []Provider{
-				Provider{"differentProvider", addBoxExpectedUrl, addBoxCheckType, addBoxChecksum},
-				Provider{addBoxProv, addBoxExpectedUrl, addBoxCheckType, addBoxChecksum},
+		&Catalog{Name: addBoxName, Description: addBoxDesc, Versions: []Version{
+			Version{Version: addBoxVers, Providers: []Provider{
+				Provider{Name: "differentProvider", Url: addBoxExpectedUrl, ChecksumType: addBoxCheckType, Checksum: addBoxChecksum},
+				Provider{Name: addBoxProv, Url: addBoxExpectedUrl, ChecksumType: addBoxCheckType, Checksum: addBoxChecksum},
 			}},
 		}},
 		addBoxName, addBoxDesc, addBoxVers, addBoxProv, addBoxCheckType, addBoxChecksum,
@@ -243,35 +246,35 @@ var tParams = TestParameters{
 	"0xB00B1E5",
 }
 
-var testCatalog = Catalog{tParams.BoxName, tParams.BoxDesc, []Version{
-	Version{"0.3.5", []Provider{
-		Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+var testCatalog = Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+	Version{Version: "0.3.5", Providers: []Provider{
+		Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 	}},
-	Version{"0.3.4", []Provider{
-		Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	Version{Version: "0.3.4", Providers: []Provider{
+		Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 	}},
-	Version{"0.3.5-BETA", []Provider{
-		Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
-		Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	Version{Version: "0.3.5-BETA", Providers: []Provider{
+		Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 	}},
-	Version{"1.0.0", []Provider{
-		Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	Version{Version: "1.0.0", Providers: []Provider{
+		Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 	}},
-	Version{"1.0.1", []Provider{
-		Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	Version{Version: "1.0.1", Providers: []Provider{
+		Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 	}},
-	Version{"1.4.5", []Provider{
-		Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	Version{Version: "1.4.5", Providers: []Provider{
+		Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 	}},
-	Version{"1.2.3", []Provider{
-		Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
-		Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	Version{Version: "1.2.3", Providers: []Provider{
+		Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 	}},
-	Version{"1.2.4", []Provider{
-		Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	Version{Version: "1.2.4", Providers: []Provider{
+		Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 	}},
-	Version{"2.11.1", []Provider{
-		Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	Version{Version: "2.11.1", Providers: []Provider{
+		Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 	}},
 }}
 
@@ -285,38 +288,184 @@ func TestQueryCatalogVersions(t *testing.T) {
 		}
 	}
 
-	testQueryVers(&testCatalog, ">2", &Catalog{tParams.BoxName, tParams.BoxDesc, []Version{
-		Version{"2.11.1", []Provider{
-			Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	testQueryVers(&testCatalog, ">2", &Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+		Version{Version: "2.11.1", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
 	}})
-	testQueryVers(&testCatalog, "<=0.3.5", &Catalog{tParams.BoxName, tParams.BoxDesc, []Version{
-		Version{"0.3.5", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	testQueryVers(&testCatalog, "<=0.3.5", &Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+		Version{Version: "0.3.5", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"0.3.4", []Provider{
-			Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "0.3.4", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"0.3.5-BETA", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
-			Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "0.3.5-BETA", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
 	}})
-	testQueryVers(&testCatalog, "0.3.5", &Catalog{tParams.BoxName, tParams.BoxDesc, []Version{
-		Version{"0.3.5", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	testQueryVers(&testCatalog, "0.3.5", &Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+		Version{Version: "0.3.5", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"0.3.5-BETA", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
-			Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "0.3.5-BETA", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
 	}})
-	testQueryVers(&testCatalog, "=0.3.5", &Catalog{tParams.BoxName, tParams.BoxDesc, []Version{
-		Version{"0.3.5", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	testQueryVers(&testCatalog, "=0.3.5", &Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+		Version{Version: "0.3.5", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
 	}})
-	testQueryVers(&testCatalog, "=0.3.6", &Catalog{tParams.BoxName, tParams.BoxDesc, []Version{}})
+	testQueryVers(&testCatalog, "=0.3.6", &Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{}})
+	testQueryVers(&testCatalog, "~> 0.3", &Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+		Version{Version: "0.3.5", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		}},
+		Version{Version: "0.3.4", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		}},
+		Version{Version: "0.3.5-BETA", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		}},
+	}})
+	testQueryVers(&testCatalog, ">=1.0.0, <2.0.0", &Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		}},
+		Version{Version: "1.0.1", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		}},
+		Version{Version: "1.4.5", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		}},
+		Version{Version: "1.2.3", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		}},
+		Version{Version: "1.2.4", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		}},
+	}})
+	testQueryVers(&testCatalog, "~>1.0.0", &Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		}},
+		Version{Version: "1.0.1", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		}},
+	}})
+}
+
+func TestQueryCatalogExactVersion(t *testing.T) {
+	release := Version{Version: "1.0.0", Providers: []Provider{
+		Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+	}}
+	prerelease := Version{Version: "1.0.0-PRE", Providers: []Provider{
+		Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+	}}
+	catalog := Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{release, prerelease}}
+
+	type TestCase struct {
+		Query    string
+		Expected []Version
+	}
+	testCases := []TestCase{
+		TestCase{"1.0.0", []Version{release}},
+		TestCase{" 1.0.0 ", []Version{release}},
+		TestCase{"v1.0.0", []Version{release}},
+		TestCase{"1.0.0-PRE", []Version{prerelease}},
+		TestCase{"1.0", []Version{}},
+		TestCase{"2.0.0", []Version{}},
+	}
+	for _, tc := range testCases {
+		result, err := catalog.QueryCatalogExactVersion(tc.Query)
+		if err != nil {
+			t.Fatalf("QueryCatalogExactVersion(%v) returned an error: %v\n", tc.Query, err)
+		}
+		expected := Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: tc.Expected}
+		if !expected.Equals(&result) {
+			t.Fatalf("QueryCatalogExactVersion(%v) returned unexpected value(s). Actual:\n%v\nExpected:\n%v\n", tc.Query, result, expected)
+		}
+	}
+
+	for _, invalid := range []string{"", "=1.0.0", ">=1.0.0", "~> 1.0", "1.0.0, 1.0.1"} {
+		if _, err := catalog.QueryCatalogExactVersion(invalid); err == nil {
+			t.Fatalf("Expected QueryCatalogExactVersion(%v) to return an error, but it did not\n", invalid)
+		}
+	}
+
+	// QueryCatalog() honors ExactVersion; without it, the same query also matches the prerelease
+	params := CatalogQueryParams{Version: "1.0.0", ExactVersion: true}
+	result, err := catalog.QueryCatalog(params)
+	if err != nil {
+		t.Fatalf("QueryCatalog(%+v) returned an error: %v\n", params, err)
+	} else if len(result.Versions) != 1 || result.Versions[0].Version != "1.0.0" {
+		t.Fatalf("Expected QueryCatalog(%+v) to return only 1.0.0, but got:\n%v\n", params, result)
+	}
+	params.ExactVersion = false
+	if result, err = catalog.QueryCatalog(params); err != nil {
+		t.Fatalf("QueryCatalog(%+v) returned an error: %v\n", params, err)
+	} else if len(result.Versions) != 2 {
+		t.Fatalf("Expected QueryCatalog(%+v) to return 1.0.0 and 1.0.0-PRE, but got:\n%v\n", params, result)
+	}
+}
+
+func TestParseVersionQueryString(t *testing.T) {
+	type TestCase struct {
+		Query       string
+		Matches     []string
+		NonMatches  []string
+		ExpectedErr bool
+	}
+	testCases := []TestCase{
+		TestCase{"~> 1.2", []string{"1.2.0", "1.2.9", "1.9.0"}, []string{"1.1.9", "2.0.0", "2.0.0-BETA"}, false},
+		TestCase{"~> 1.2.3", []string{"1.2.3", "1.2.10"}, []string{"1.2.2", "1.3.0", "2.0.0"}, false},
+		TestCase{"~>1", []string{"1.0.0", "1.9.9"}, []string{"0.9.0", "2.0.0"}, false},
+		TestCase{">= 1.2", []string{"1.2.0", "3.0.0"}, []string{"1.1.0"}, false},
+		TestCase{">=1.0.0,<2.0.0", []string{"1.0.0", "1.9.9"}, []string{"0.3.5", "2.0.0", "2.11.1"}, false},
+		TestCase{" >= 1.0.0 , < 2.0.0 ", []string{"1.0.0", "1.9.9"}, []string{"0.3.5", "2.0.0"}, false},
+		TestCase{"~> 1.2, !=1.2.3", []string{}, []string{}, true},
+		TestCase{">=1.0.0,", []string{}, []string{}, true},
+		TestCase{"", []string{"0.0.1", "99.0.0-PRE"}, []string{}, false},
+		TestCase{"~>", []string{}, []string{}, true},
+		TestCase{"~> one", []string{}, []string{}, true},
+	}
+
+	for _, tc := range testCases {
+		constraints, err := parseVersionQueryString(tc.Query)
+		if tc.ExpectedErr {
+			if err == nil {
+				t.Fatalf("parseVersionQueryString(%v) was expected to return an error\n", tc.Query)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("parseVersionQueryString(%v) returned an unexpected error: %v\n", tc.Query, err)
+		}
+
+		matchesAll := func(semver string) bool {
+			cvers, _ := NewComparableVersion(semver)
+			for _, constraint := range constraints {
+				if !constraint.Matches(&cvers) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, semver := range tc.Matches {
+			if !matchesAll(semver) {
+				t.Fatalf("Expected query '%v' to match version '%v'\n", tc.Query, semver)
+			}
+		}
+		for _, semver := range tc.NonMatches {
+			if matchesAll(semver) {
+				t.Fatalf("Expected query '%v' not to match version '%v'\n", tc.Query, semver)
+			}
+		}
+	}
 }
 
 func TestQueryCatalogProviders(t *testing.T) {
@@ -328,65 +477,117 @@ func TestQueryCatalogProviders(t *testing.T) {
 			t.Fatalf("QueryCatalogProviders() returned unexpected value(s). Actual:\n%v\nExpected:\n%v\n", result, expectedResult)
 		}
 	}
-	testQueryProv(testCatalog, "^Strong", Catalog{tParams.BoxName, tParams.BoxDesc, []Version{
-		Version{"0.3.5", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	testQueryProv(testCatalog, "^Strong", Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+		Version{Version: "0.3.5", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"0.3.5-BETA", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "0.3.5-BETA", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"1.0.0", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "1.0.0", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"1.4.5", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "1.4.5", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"1.2.3", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "1.2.3", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"1.2.4", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "1.2.4", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
 	}})
-	testQueryProv(testCatalog, "Sapling$", Catalog{tParams.BoxName, tParams.BoxDesc, []Version{
-		Version{"0.3.5", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	testQueryProv(testCatalog, "Sapling$", Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+		Version{Version: "0.3.5", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"0.3.5-BETA", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "0.3.5-BETA", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"1.0.0", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "1.0.0", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"1.4.5", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "1.4.5", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"1.2.3", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "1.2.3", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"1.2.4", []Provider{
-			Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "1.2.4", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
 	}})
-	testQueryProv(testCatalog, "F", Catalog{tParams.BoxName, tParams.BoxDesc, []Version{
-		Version{"0.3.4", []Provider{
-			Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+	testQueryProv(testCatalog, "F", Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+		Version{Version: "0.3.4", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"0.3.5-BETA", []Provider{
-			Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "0.3.5-BETA", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"1.0.1", []Provider{
-			Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "1.0.1", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"1.2.3", []Provider{
-			Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "1.2.3", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
-		Version{"2.11.1", []Provider{
-			Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Version{Version: "2.11.1", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 		}},
 	}})
 }
 
+func TestQueryCatalogProvidersByMode(t *testing.T) {
+	type TestCase struct {
+		Query             string
+		Mode              ProviderMatchMode
+		ExpectedProviders []string
+		ExpectedErr       bool
+	}
+	testCases := []TestCase{
+		TestCase{"rongSap", ProviderMatchRegex, []string{"StrongSapling"}, false},
+		TestCase{".*rongSap.*", ProviderMatchRegex, []string{"StrongSapling"}, false},
+		TestCase{"^F.*s$", ProviderMatchRegex, []string{"FeebleFungus"}, false},
+		TestCase{"*-iso", ProviderMatchRegex, []string{}, true},
+		TestCase{"rongSap", "", []string{"StrongSapling"}, false},
+		TestCase{"*Sapling", ProviderMatchGlob, []string{"StrongSapling"}, false},
+		TestCase{"*n*", ProviderMatchGlob, []string{"StrongSapling", "FeebleFungus"}, false},
+		TestCase{"rongSap", ProviderMatchGlob, []string{}, false},
+		TestCase{"Strong.apling", ProviderMatchGlob, []string{}, false},
+		TestCase{"StrongSapling", ProviderMatchGlob, []string{"StrongSapling"}, false},
+		TestCase{"rongSap", ProviderMatchSubstring, []string{"StrongSapling"}, false},
+		TestCase{".*rongSap.*", ProviderMatchSubstring, []string{}, false},
+		TestCase{"", ProviderMatchGlob, []string{"StrongSapling", "FeebleFungus"}, false},
+		TestCase{"rongSap", "fuzzy", []string{}, true},
+	}
+
+	for _, tc := range testCases {
+		result, err := testCatalog.QueryCatalogProvidersByMode(tc.Query, tc.Mode)
+		if tc.ExpectedErr {
+			if err == nil {
+				t.Fatalf("QueryCatalogProvidersByMode(%v, %v) was expected to return an error\n", tc.Query, tc.Mode)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("QueryCatalogProvidersByMode(%v, %v) returned an error: %v\n", tc.Query, tc.Mode, err)
+		}
+
+		found := map[string]bool{}
+		for _, v := range result.Versions {
+			for _, p := range v.Providers {
+				found[p.Name] = true
+			}
+		}
+		if len(found) != len(tc.ExpectedProviders) {
+			t.Fatalf("QueryCatalogProvidersByMode(%v, %v) found providers %v, but expected %v\n", tc.Query, tc.Mode, found, tc.ExpectedProviders)
+		}
+		for _, name := range tc.ExpectedProviders {
+			if !found[name] {
+				t.Fatalf("QueryCatalogProvidersByMode(%v, %v) found providers %v, but expected %v\n", tc.Query, tc.Mode, found, tc.ExpectedProviders)
+			}
+		}
+	}
+}
+
 func TestDeleteReferences(t *testing.T) {
 	tDelRef := func(initial Catalog, refs []BoxReference, expectedResult Catalog) {
 		result := initial.DeleteReferences(refs)
@@ -402,28 +603,28 @@ func TestDeleteReferences(t *testing.T) {
 			BoxReference{Version: "0.3.5-BETA", ProviderName: tParams.ProviderNames[0]},
 			BoxReference{Version: "0.3.4", ProviderName: tParams.ProviderNames[1]},
 		},
-		Catalog{tParams.BoxName, tParams.BoxDesc, []Version{
-			Version{"0.3.5-BETA", []Provider{
-				Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+			Version{Version: "0.3.5-BETA", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.0.0", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.0.0", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.0.1", []Provider{
-				Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.0.1", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.4.5", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.4.5", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.2.3", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
-				Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.2.3", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+				Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.2.4", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.2.4", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"2.11.1", []Provider{
-				Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "2.11.1", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
 		}},
 	)
@@ -440,64 +641,1092 @@ func TestDelete(t *testing.T) {
 	}
 
 	testDelete(testCatalog, CatalogQueryParams{Version: "", Provider: ""}, Catalog{
-		tParams.BoxName, tParams.BoxDesc, []Version{},
+		Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{},
 	})
 	testDelete(testCatalog, CatalogQueryParams{Version: "<=1", Provider: "Feeb"}, Catalog{
-		tParams.BoxName, tParams.BoxDesc, []Version{
-			Version{"0.3.5", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+			Version{Version: "0.3.5", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"0.3.5-BETA", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "0.3.5-BETA", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.0.0", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.0.0", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.0.1", []Provider{
-				Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.0.1", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.4.5", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.4.5", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.2.3", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
-				Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.2.3", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+				Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.2.4", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.2.4", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"2.11.1", []Provider{
-				Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "2.11.1", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
 		},
 	})
 	testDelete(testCatalog, CatalogQueryParams{Version: "<=1.0.0", Provider: "Feeb"}, Catalog{
-		tParams.BoxName, tParams.BoxDesc, []Version{
-			Version{"0.3.5", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+		Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: []Version{
+			Version{Version: "0.3.5", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"0.3.5-BETA", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "0.3.5-BETA", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.0.0", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.0.0", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.0.1", []Provider{
-				Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.0.1", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.4.5", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.4.5", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.2.3", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
-				Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.2.3", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+				Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"1.2.4", []Provider{
-				Provider{tParams.ProviderNames[0], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "1.2.4", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
-			Version{"2.11.1", []Provider{
-				Provider{tParams.ProviderNames[1], tParams.BoxUri, tParams.DigestType, tParams.Digest},
+			Version{Version: "2.11.1", Providers: []Provider{
+				Provider{Name: tParams.ProviderNames[1], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
 			}},
 		},
 	})
 }
+
+func TestCatalogSorted(t *testing.T) {
+	versionStrings := func(versions []Version) (result []string) {
+		for _, v := range versions {
+			result = append(result, v.Version)
+		}
+		return
+	}
+
+	catalog := Catalog{Name: "SortBox", Description: "a box for sorting", Versions: []Version{
+		Version{Version: "2.11.1"},
+		Version{Version: "1.0.0"},
+		Version{Version: "NOT_A_VERSION"},
+		Version{Version: "2.10.0"},
+		Version{Version: "1.0.0-PRE"},
+		Version{Version: "1.10.0"},
+		Version{Version: "1.2.0+build.7"},
+	}}
+	original := versionStrings(catalog.Versions)
+	expected := []string{"1.0.0-PRE", "1.0.0", "1.2.0+build.7", "1.10.0", "2.10.0", "2.11.1", "NOT_A_VERSION"}
+
+	sorted := versionStrings(catalog.Sorted().Versions)
+	if fmt.Sprintf("%v", sorted) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("Expected sorted versions to be %v, but got %v\n", expected, sorted)
+	}
+	if now := versionStrings(catalog.Versions); fmt.Sprintf("%v", now) != fmt.Sprintf("%v", original) {
+		t.Fatalf("Sorted() modified the original catalog: %v\n", now)
+	}
+}
+
+func TestCatalogVersionPrefix(t *testing.T) {
+	versionStrings := func(versions []Version) (result []string) {
+		for _, v := range versions {
+			result = append(result, v.Version)
+		}
+		return
+	}
+
+	type NormalizeTestCase struct {
+		Version  string
+		Expected string
+	}
+	for _, tc := range []NormalizeTestCase{
+		NormalizeTestCase{"v1.2.3", "1.2.3"},
+		NormalizeTestCase{"V1.0.0-PRE", "1.0.0-PRE"},
+		NormalizeTestCase{"1.2.3", "1.2.3"},
+		NormalizeTestCase{"v", "v"},
+		NormalizeTestCase{"very", "very"},
+		NormalizeTestCase{"vv1.2.3", "vv1.2.3"},
+	} {
+		if result := NormalizeVersion(tc.Version); result != tc.Expected {
+			t.Fatalf("Expected NormalizeVersion('%v') to be '%v', but got '%v'\n", tc.Version, tc.Expected, result)
+		}
+	}
+
+	catalogUri := "file:///catalog/root/PrefixBox.json"
+	catalog := Catalog{Name: "PrefixBox", Description: "a box with mixed versions", Versions: []Version{
+		Version{Version: "1.2.4", Providers: []Provider{Provider{Name: "StrongSapling", Url: "file:///catalog/root/PrefixBox/PrefixBox_1.2.4_StrongSapling.box", ChecksumType: "sha256", Checksum: "0xB00B1E5"}}},
+		Version{Version: "v1.2.3", Providers: []Provider{Provider{Name: "StrongSapling", Url: "file:///catalog/root/PrefixBox/PrefixBox_v1.2.3_StrongSapling.box", ChecksumType: "sha256", Checksum: "0xB00B1E5"}}},
+		Version{Version: "1.2.2", Providers: []Provider{Provider{Name: "StrongSapling", Url: "file:///catalog/root/PrefixBox/PrefixBox_1.2.2_StrongSapling.box", ChecksumType: "sha256", Checksum: "0xB00B1E5"}}},
+	}}
+
+	expectedOrder := []string{"1.2.2", "v1.2.3", "1.2.4"}
+	if sorted := versionStrings(catalog.Sorted().Versions); fmt.Sprintf("%v", sorted) != fmt.Sprintf("%v", expectedOrder) {
+		t.Fatalf("Expected a 'v' prefix to be ignored when sorting, so the versions are %v, but got %v\n", expectedOrder, sorted)
+	}
+
+	for _, query := range []string{"1.2.3", "=1.2.3", "v1.2.3", "=v1.2.3"} {
+		result, err := catalog.QueryCatalog(CatalogQueryParams{Version: query})
+		if err != nil {
+			t.Fatalf("QueryCatalog() for version '%v' returned an error: %v\n", query, err)
+		}
+		if versions := versionStrings(result.Versions); len(versions) != 1 || versions[0] != "v1.2.3" {
+			t.Fatalf("Expected querying for version '%v' to find only 'v1.2.3', but got %v\n", query, versions)
+		}
+	}
+	result, err := catalog.QueryCatalog(CatalogQueryParams{Version: ">v1.2.2, <1.2.4"})
+	if err != nil {
+		t.Fatalf("QueryCatalog() for a version range returned an error: %v\n", err)
+	}
+	if versions := versionStrings(result.Versions); len(versions) != 1 || versions[0] != "v1.2.3" {
+		t.Fatalf("Expected querying for a version range to find only 'v1.2.3', but got %v\n", versions)
+	}
+
+	// A box for the plain version joins the version that is stored with a 'v'
+	if err = catalog.AddBox(catalogUri, BoxArtifact{Name: "PrefixBox", Description: "a box with mixed versions", Version: "1.2.3", Provider: "FeebleSapling", ChecksumType: "sha256", Checksum: "0xDEC0DE"}); err != nil {
+		t.Fatalf("AddBox() returned an error: %v\n", err)
+	}
+	if len(catalog.Versions) != 3 {
+		t.Fatalf("Expected adding a box for '1.2.3' not to add a version next to 'v1.2.3', but got %v\n", versionStrings(catalog.Versions))
+	}
+	for _, v := range catalog.Versions {
+		if v.Version == "v1.2.3" && len(v.Providers) != 2 {
+			t.Fatalf("Expected the box for '1.2.3' to be added to 'v1.2.3', but it has %v providers\n", len(v.Providers))
+		}
+	}
+}
+
+func TestQueryCatalogLatest(t *testing.T) {
+	type TestCase struct {
+		Params          CatalogQueryParams
+		ExpectedVersion string
+	}
+
+	prereleaseCatalog := Catalog{Name: tParams.BoxName, Description: tParams.BoxDesc, Versions: append([]Version{
+		Version{Version: "3.0.0-BETA", Providers: []Provider{
+			Provider{Name: tParams.ProviderNames[0], Url: tParams.BoxUri, ChecksumType: tParams.DigestType, Checksum: tParams.Digest},
+		}},
+	}, testCatalog.Versions...)}
+
+	testCases := []TestCase{
+		TestCase{CatalogQueryParams{Version: "latest"}, "2.11.1"},
+		TestCase{CatalogQueryParams{Version: "newest"}, "2.11.1"},
+		TestCase{CatalogQueryParams{Version: "latest", Provider: tParams.ProviderNames[0]}, "1.4.5"},
+		TestCase{CatalogQueryParams{Version: "latest", Provider: "NoSuchProvider"}, ""},
+	}
+	for _, tc := range testCases {
+		result, err := testCatalog.QueryCatalog(tc.Params)
+		if err != nil {
+			t.Fatalf("QueryCatalog(%v) returned an error: %v\n", tc.Params, err)
+		}
+		if tc.ExpectedVersion == "" {
+			if len(result.Versions) != 0 {
+				t.Fatalf("QueryCatalog(%v) was expected to return no versions, but returned:\n%v\n", tc.Params, result.DisplayString())
+			}
+		} else if len(result.Versions) != 1 || result.Versions[0].Version != tc.ExpectedVersion {
+			t.Fatalf("QueryCatalog(%v) was expected to return only %v, but returned:\n%v\n", tc.Params, tc.ExpectedVersion, result.DisplayString())
+		}
+	}
+
+	// The prerelease is the highest version, but "latest" skips it unless it is asked for
+	prereleaseCases := []TestCase{
+		TestCase{CatalogQueryParams{Version: "latest"}, "2.11.1"},
+		TestCase{CatalogQueryParams{Version: "latest", Prerelease: PrereleaseInclude}, "3.0.0-BETA"},
+		TestCase{CatalogQueryParams{Version: "latest", Prerelease: PrereleaseExclude}, "2.11.1"},
+	}
+	for _, tc := range prereleaseCases {
+		result, err := prereleaseCatalog.QueryCatalog(tc.Params)
+		if err != nil || len(result.Versions) != 1 || result.Versions[0].Version != tc.ExpectedVersion {
+			t.Fatalf("QueryCatalog(%v) was expected to return only %v, but returned:\n%v\nerror: %v\n", tc.Params, tc.ExpectedVersion, result.DisplayString(), err)
+		}
+	}
+}
+
+func TestQueryCatalogPage(t *testing.T) {
+	type TestCase struct {
+		Params   CatalogQueryParams
+		Expected []string
+	}
+	testCases := []TestCase{
+		TestCase{CatalogQueryParams{}, []string{"0.3.4", "0.3.5-BETA", "0.3.5", "1.0.0", "1.0.1", "1.2.3", "1.2.4", "1.4.5", "2.11.1"}},
+		TestCase{CatalogQueryParams{Limit: 3}, []string{"0.3.4", "0.3.5-BETA", "0.3.5"}},
+		TestCase{CatalogQueryParams{Offset: 3, Limit: 3}, []string{"1.0.0", "1.0.1", "1.2.3"}},
+		TestCase{CatalogQueryParams{Offset: 6, Limit: 5}, []string{"1.2.4", "1.4.5", "2.11.1"}},
+		TestCase{CatalogQueryParams{Offset: 7}, []string{"1.4.5", "2.11.1"}},
+		TestCase{CatalogQueryParams{Offset: 20, Limit: 3}, []string{}},
+		TestCase{CatalogQueryParams{Provider: tParams.ProviderNames[1], Offset: 1, Limit: 2}, []string{"0.3.5-BETA", "1.0.1"}},
+		TestCase{CatalogQueryParams{Version: ">=1", Limit: 2}, []string{"1.0.0", "1.0.1"}},
+	}
+	for _, tc := range testCases {
+		result, err := testCatalog.QueryCatalog(tc.Params)
+		if err != nil {
+			t.Fatalf("QueryCatalog(%+v) returned an error: %v\n", tc.Params, err)
+		}
+		versions := []string{}
+		for _, v := range result.Sorted().Versions {
+			versions = append(versions, v.Version)
+		}
+		if strings.Join(versions, " ") != strings.Join(tc.Expected, " ") {
+			t.Fatalf("QueryCatalog(%+v) returned versions %v, but we expected %v\n", tc.Params, versions, tc.Expected)
+		}
+	}
+}
+
+func TestQueryCatalogName(t *testing.T) {
+	type TestCase struct {
+		NameQuery   string
+		ExpectMatch bool
+	}
+	testCases := []TestCase{
+		TestCase{"", true},
+		TestCase{tParams.BoxName, true},
+		TestCase{"*catalog*", true},
+		TestCase{"vagrant_*", true},
+		TestCase{"*_box", true},
+		TestCase{"*", true},
+		TestCase{"catalog", false},
+		TestCase{"*asdf*", false},
+		TestCase{"vagrant.catalog.test.box", false},
+	}
+	for _, tc := range testCases {
+		result, err := testCatalog.QueryCatalog(CatalogQueryParams{Name: tc.NameQuery})
+		if err != nil {
+			t.Fatalf("QueryCatalog() with name query '%v' returned an error: %v\n", tc.NameQuery, err)
+		}
+		if tc.ExpectMatch && !result.Equals(&testCatalog) {
+			t.Fatalf("Expected name query '%v' to match catalog '%v', but got:\n%v\n", tc.NameQuery, testCatalog.Name, result.DisplayString())
+		} else if !tc.ExpectMatch && (result.Name != "" || len(result.Versions) != 0) {
+			t.Fatalf("Expected name query '%v' to return an empty result, but got:\n%v\n", tc.NameQuery, result.DisplayString())
+		}
+	}
+}
+
+func TestCatalogAddBoxArchitecture(t *testing.T) {
+	catalogUri := "file:///catalog/root/ArchBox.json"
+	artifact := BoxArtifact{Name: "ArchBox", Description: "desc", Version: "1.0.0", Provider: "virtualbox", ChecksumType: "sha256", Checksum: "0xDECAFBAD"}
+	catalog := Catalog{}
+
+	for _, arch := range []string{"", "amd64", "arm64", "arm64"} {
+		artifact.Architecture = arch
+		if err := catalog.AddBox(catalogUri, artifact); err != nil {
+			t.Fatalf("AddBox() for architecture '%v' returned an error: %v\n", arch, err)
+		}
+	}
+
+	expected := Catalog{Name: "ArchBox", Description: "desc", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{
+			Provider{Name: "virtualbox", Url: "file:///catalog/root/ArchBox/ArchBox_1.0.0_virtualbox.box", ChecksumType: "sha256", Checksum: "0xDECAFBAD"},
+			Provider{Name: "virtualbox", Url: "file:///catalog/root/ArchBox/ArchBox_1.0.0_virtualbox_amd64.box", ChecksumType: "sha256", Checksum: "0xDECAFBAD", Architecture: "amd64"},
+			Provider{Name: "virtualbox", Url: "file:///catalog/root/ArchBox/ArchBox_1.0.0_virtualbox_arm64.box", ChecksumType: "sha256", Checksum: "0xDECAFBAD", Architecture: "arm64"},
+		}},
+	}}
+	if !catalog.Equals(&expected) {
+		t.Fatalf("Expected catalog:\n%v\nBut got:\n%v\n", expected.DisplayString(), catalog.DisplayString())
+	}
+
+	archQuery, err := catalog.QueryCatalog(CatalogQueryParams{Architecture: "arm64"})
+	if err != nil {
+		t.Fatalf("QueryCatalog() returned an error: %v\n", err)
+	}
+	refs := archQuery.BoxReferences()
+	if len(refs) != 1 || refs[0].Architecture != "arm64" {
+		t.Fatalf("Expected only the arm64 box from an architecture query, but got %+v\n", refs)
+	}
+
+	deleted := catalog.DeleteReferences(refs)
+	if len(deleted.Versions) != 1 || len(deleted.Versions[0].Providers) != 2 {
+		t.Fatalf("Expected deleting the arm64 box to leave the others, but got:\n%v\n", deleted.DisplayString())
+	}
+	for _, p := range deleted.Versions[0].Providers {
+		if p.Architecture == "arm64" {
+			t.Fatalf("Expected the arm64 box to be deleted, but got:\n%v\n", deleted.DisplayString())
+		}
+	}
+}
+
+func TestCatalogVersionDescription(t *testing.T) {
+	catalogUri := "file:///catalog/root/DescBox.json"
+	oldJson := `{"name":"DescBox","description":"desc","versions":[{"version":"1.0.0","providers":[{"name":"virtualbox","url":"file:///catalog/root/DescBox/DescBox_1.0.0_virtualbox.box","checksum_type":"sha256","checksum":"0xDECAFBAD"}]}]}`
+
+	var catalog Catalog
+	if err := json.Unmarshal([]byte(oldJson), &catalog); err != nil {
+		t.Fatalf("Error unmarshalling JSON: %v\n", err)
+	}
+	roundTripped, err := json.Marshal(catalog)
+	if err != nil {
+		t.Fatalf("Error marshalling JSON: %v\n", err)
+	}
+	if string(roundTripped) != oldJson {
+		t.Fatalf("Expected a catalog without version descriptions to round-trip unchanged, but got:\n%v\n", string(roundTripped))
+	}
+
+	artifact := BoxArtifact{Name: "DescBox", Description: "desc", Version: "1.1.0", VersionDescription: "Patched for CVE-1", Provider: "virtualbox", ChecksumType: "sha256", Checksum: "0xDECAFBAD"}
+	if err = catalog.AddBox(catalogUri, artifact); err != nil {
+		t.Fatalf("AddBox() returned an error: %v\n", err)
+	}
+
+	// Adding another box to the version without a description keeps the one it has
+	artifact.VersionDescription = ""
+	artifact.Provider = "libvirt"
+	if err = catalog.AddBox(catalogUri, artifact); err != nil {
+		t.Fatalf("AddBox() returned an error: %v\n", err)
+	}
+
+	display := catalog.DisplayString()
+	if !strings.Contains(display, "  v1.1.0 (Patched for CVE-1)\n") || !strings.Contains(display, "  v1.0.0\n") {
+		t.Fatalf("Expected only v1.1.0 to show a version description, but DisplayString() returned\n%v\n", display)
+	}
+
+	query, err := catalog.QueryCatalog(CatalogQueryParams{Version: "1.1.0", Provider: "libvirt"})
+	if err != nil {
+		t.Fatalf("QueryCatalog() returned an error: %v\n", err)
+	}
+	if len(query.Versions) != 1 || query.Versions[0].Description != "Patched for CVE-1" {
+		t.Fatalf("Expected a query to keep the version description, but got:\n%v\n", query.DisplayString())
+	}
+}
+
+func TestCatalogDisplayName(t *testing.T) {
+	catalogUri := "file:///catalog/root/example/testbox.json"
+	oldJson := `{"name":"example/testbox","description":"desc","versions":[{"version":"1.0.0","providers":[{"name":"virtualbox","url":"file:///catalog/root/example/testbox/testbox_1.0.0_virtualbox.box","checksum_type":"sha256","checksum":"0xDECAFBAD"}]}]}`
+
+	var catalog Catalog
+	if err := json.Unmarshal([]byte(oldJson), &catalog); err != nil {
+		t.Fatalf("Error unmarshalling JSON: %v\n", err)
+	}
+	roundTripped, err := json.Marshal(catalog)
+	if err != nil {
+		t.Fatalf("Error marshalling JSON: %v\n", err)
+	}
+	if string(roundTripped) != oldJson {
+		t.Fatalf("Expected a catalog without a display name to round-trip unchanged, but got:\n%v\n", string(roundTripped))
+	}
+	if display := catalog.DisplayString(); !strings.HasPrefix(display, "example/testbox (desc)\n") {
+		t.Fatalf("Expected a catalog without a display name to display its name, but DisplayString() returned\n%v\n", display)
+	}
+
+	artifact := BoxArtifact{Name: "example/testbox", DisplayName: "Test Box", Description: "desc", Version: "1.1.0", Provider: "virtualbox", ChecksumType: "sha256", Checksum: "0xDECAFBAD"}
+	if err = catalog.AddBox(catalogUri, artifact); err != nil {
+		t.Fatalf("AddBox() returned an error: %v\n", err)
+	}
+
+	// Adding another box without a display name keeps the one the catalog has
+	artifact.DisplayName = ""
+	artifact.Provider = "libvirt"
+	if err = catalog.AddBox(catalogUri, artifact); err != nil {
+		t.Fatalf("AddBox() returned an error: %v\n", err)
+	}
+	if catalog.Name != "example/testbox" || catalog.DisplayName != "Test Box" {
+		t.Fatalf("Expected name 'example/testbox' and display name 'Test Box', but got '%v' and '%v'\n", catalog.Name, catalog.DisplayName)
+	}
+	if display := catalog.DisplayString(); !strings.HasPrefix(display, "Test Box [example/testbox] (desc)\n") {
+		t.Fatalf("Expected the display name and the name, but DisplayString() returned\n%v\n", display)
+	}
+
+	serialized, err := json.Marshal(catalog)
+	if err != nil {
+		t.Fatalf("Error marshalling JSON: %v\n", err)
+	}
+	if !strings.HasPrefix(string(serialized), `{"name":"example/testbox",`) || !strings.HasSuffix(string(serialized), `,"display_name":"Test Box"}`) {
+		t.Fatalf("Expected both the name and the display name to be serialized, but got:\n%v\n", string(serialized))
+	}
+	var decoded Catalog
+	if err = json.Unmarshal(serialized, &decoded); err != nil {
+		t.Fatalf("Error unmarshalling JSON: %v\n", err)
+	}
+	if !decoded.Equals(&catalog) {
+		t.Fatalf("Expected the catalog to round-trip, but got:\n%v\n", decoded.DisplayString())
+	}
+	if violations := ValidateCatalogJSONSchema(serialized); len(violations) > 0 {
+		t.Fatalf("Expected a catalog with a display name to match the JSON schema, but got: %v\n", violations)
+	}
+
+	query, err := catalog.QueryCatalog(CatalogQueryParams{Provider: "libvirt"})
+	if err != nil {
+		t.Fatalf("QueryCatalog() returned an error: %v\n", err)
+	}
+	if query.DisplayName != "Test Box" {
+		t.Fatalf("Expected a query to keep the display name, but got '%v'\n", query.DisplayName)
+	}
+}
+
+func TestCatalogTagVersions(t *testing.T) {
+	oldJson := `{"name":"testbox","description":"desc","versions":[{"version":"1.0.0","providers":[{"name":"virtualbox","url":"file:///catalog/root/testbox_1.0.0_virtualbox.box","checksum_type":"sha256","checksum":"0xDECAFBAD"}]},{"version":"1.1.0","providers":[{"name":"virtualbox","url":"file:///catalog/root/testbox_1.1.0_virtualbox.box","checksum_type":"sha256","checksum":"0xDECAFBAD"}]}]}`
+
+	var catalog Catalog
+	if err := json.Unmarshal([]byte(oldJson), &catalog); err != nil {
+		t.Fatalf("Error unmarshalling JSON: %v\n", err)
+	}
+	roundTripped, err := json.Marshal(catalog)
+	if err != nil {
+		t.Fatalf("Error marshalling JSON: %v\n", err)
+	}
+	if string(roundTripped) != oldJson {
+		t.Fatalf("Expected a catalog without tags to round-trip unchanged, but got:\n%v\n", string(roundTripped))
+	}
+
+	type TestCase struct {
+		Versions        []string
+		Add             []string
+		Remove          []string
+		ExpectedChanged []string
+		ExpectedTags    map[string][]string
+	}
+	testCases := []TestCase{
+		TestCase{[]string{"1.0.0"}, []string{"stable", "canary", "stable"}, nil, []string{"1.0.0"}, map[string][]string{"1.0.0": []string{"canary", "stable"}, "1.1.0": nil}},
+		TestCase{[]string{"1.0.0"}, []string{"stable"}, nil, nil, map[string][]string{"1.0.0": []string{"canary", "stable"}, "1.1.0": nil}},
+		TestCase{[]string{"1.0.0", "1.1.0"}, []string{"canary"}, []string{"stable"}, []string{"1.0.0", "1.1.0"}, map[string][]string{"1.0.0": []string{"canary"}, "1.1.0": []string{"canary"}}},
+		TestCase{[]string{"1.1.0"}, nil, []string{"canary", "nonexistent"}, []string{"1.1.0"}, map[string][]string{"1.0.0": []string{"canary"}, "1.1.0": nil}},
+	}
+	for idx, tc := range testCases {
+		result, changed, err := catalog.TagVersions(tc.Versions, tc.Add, tc.Remove)
+		if err != nil {
+			t.Fatalf("TagVersions() test case %v returned an error: %v\n", idx, err)
+		}
+		if !reflect.DeepEqual(changed, tc.ExpectedChanged) {
+			t.Fatalf("Expected TagVersions() test case %v to change %v, but it changed %v\n", idx, tc.ExpectedChanged, changed)
+		}
+		for _, v := range result.Versions {
+			if !reflect.DeepEqual(v.Tags, tc.ExpectedTags[v.Version]) {
+				t.Fatalf("Expected version %v to have tags %v after test case %v, but it had %v\n", v.Version, tc.ExpectedTags[v.Version], idx, v.Tags)
+			}
+		}
+		catalog = result
+	}
+
+	if _, _, err = catalog.TagVersions([]string{"1.0.0"}, []string{"not a tag"}, nil); err == nil {
+		t.Fatalf("Expected TagVersions() to reject an invalid tag\n")
+	}
+
+	serialized, err := json.Marshal(catalog)
+	if err != nil {
+		t.Fatalf("Error marshalling JSON: %v\n", err)
+	}
+	if !strings.Contains(string(serialized), `"version":"1.0.0","providers":[`) || !strings.Contains(string(serialized), `}],"tags":["canary"]}`) {
+		t.Fatalf("Expected only the tagged version to serialize its tags, but got:\n%v\n", string(serialized))
+	}
+	if violations := ValidateCatalogJSONSchema(serialized); len(violations) > 0 {
+		t.Fatalf("Expected a catalog with tags to match the JSON schema, but got: %v\n", violations)
+	}
+	var decoded Catalog
+	if err = json.Unmarshal(serialized, &decoded); err != nil {
+		t.Fatalf("Error unmarshalling JSON: %v\n", err)
+	}
+	if !decoded.Equals(&catalog) {
+		t.Fatalf("Expected the catalog to round-trip, but got:\n%v\n", decoded.DisplayString())
+	}
+	if display := catalog.DisplayString(); !strings.Contains(display, "  v1.0.0 tags canary\n") {
+		t.Fatalf("Expected DisplayString() to show the tags of a version, but it returned\n%v\n", display)
+	}
+
+	duplicated := catalog.clone()
+	duplicated.Versions[0].Tags = []string{"canary", "canary"}
+	if problems := duplicated.Check(); len(problems) != 1 || problems[0].Version != "1.0.0" {
+		t.Fatalf("Expected Check() to report the duplicate tag, but got: %v\n", problems)
+	}
+}
+
+func TestQueryCatalogTag(t *testing.T) {
+	provider := Provider{Name: "virtualbox", Url: "FAKEURI", ChecksumType: "sha1", Checksum: "0xB00B1E5"}
+	catalog := Catalog{Name: "testbox", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{provider}, Tags: []string{"stable"}},
+		Version{Version: "1.1.0", Providers: []Provider{provider}, Tags: []string{"canary", "stable"}},
+		Version{Version: "1.2.0", Providers: []Provider{provider}, Tags: []string{"canary"}},
+		Version{Version: "1.3.0", Providers: []Provider{provider}},
+	}}
+
+	type TestCase struct {
+		Params           CatalogQueryParams
+		ExpectedVersions []string
+	}
+	testCases := []TestCase{
+		TestCase{CatalogQueryParams{}, []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0"}},
+		TestCase{CatalogQueryParams{Tag: "stable"}, []string{"1.0.0", "1.1.0"}},
+		TestCase{CatalogQueryParams{Tag: "canary"}, []string{"1.1.0", "1.2.0"}},
+		TestCase{CatalogQueryParams{Tag: "stable", Version: "latest"}, []string{"1.1.0"}},
+		TestCase{CatalogQueryParams{Tag: "stable", Version: "<1.1.0"}, []string{"1.0.0"}},
+		TestCase{CatalogQueryParams{Tag: "nonexistent"}, []string{}},
+	}
+	for _, tc := range testCases {
+		result, err := catalog.QueryCatalog(tc.Params)
+		if err != nil {
+			t.Fatalf("QueryCatalog(%+v) returned an error: %v\n", tc.Params, err)
+		}
+		versions := []string{}
+		for _, v := range result.Versions {
+			versions = append(versions, v.Version)
+		}
+		if !reflect.DeepEqual(versions, tc.ExpectedVersions) {
+			t.Fatalf("Expected QueryCatalog(%+v) to return versions %v, but it returned %v\n", tc.Params, tc.ExpectedVersions, versions)
+		}
+		for _, v := range result.Versions {
+			if tc.Params.Tag != "" && !v.HasTag(tc.Params.Tag) {
+				t.Fatalf("Expected QueryCatalog(%+v) to keep the tags of version %v, but it had %v\n", tc.Params, v.Version, v.Tags)
+			}
+		}
+	}
+}
+
+func TestCatalogLabels(t *testing.T) {
+	catalogUri := "file:///catalog/root/LabelBox.json"
+	catalog := Catalog{}
+	for _, box := range []struct {
+		Version string
+		Labels  map[string]string
+	}{
+		{"1.0.0", map[string]string{"env": "prod", "build": "100"}},
+		{"1.1.0", map[string]string{"env": "staging", "build": "110"}},
+		{"1.2.0", nil},
+	} {
+		artifact := BoxArtifact{Name: "LabelBox", Description: "desc", Version: box.Version, Provider: "virtualbox", ChecksumType: "sha256", Checksum: "0xDECAFBAD", Labels: box.Labels}
+		if err := catalog.AddBox(catalogUri, artifact); err != nil {
+			t.Fatalf("AddBox() for version '%v' returned an error: %v\n", box.Version, err)
+		}
+	}
+
+	type TestCase struct {
+		Selector         string
+		ExpectedVersions []string
+	}
+	testCases := []TestCase{
+		TestCase{"", []string{"1.0.0", "1.1.0", "1.2.0"}},
+		TestCase{"env=prod", []string{"1.0.0"}},
+		TestCase{"env=prod,build=100", []string{"1.0.0"}},
+		TestCase{"env=prod,build=110", []string{}},
+		TestCase{"build=110", []string{"1.1.0"}},
+		TestCase{"team=infra", []string{}},
+	}
+	for _, tc := range testCases {
+		result, err := catalog.QueryCatalog(CatalogQueryParams{LabelSelector: tc.Selector})
+		if err != nil {
+			t.Fatalf("QueryCatalog() with label selector '%v' returned an error: %v\n", tc.Selector, err)
+		}
+		versions := []string{}
+		for _, v := range result.Versions {
+			versions = append(versions, v.Version)
+		}
+		if !reflect.DeepEqual(versions, tc.ExpectedVersions) {
+			t.Fatalf("Expected label selector '%v' to match versions %v, but got %v\n", tc.Selector, tc.ExpectedVersions, versions)
+		}
+	}
+
+	// The label selector applies before "latest", so it finds the latest version with matching labels
+	latest, err := catalog.QueryCatalog(CatalogQueryParams{Version: "latest", LabelSelector: "env=prod"})
+	if err != nil || len(latest.Versions) != 1 || latest.Versions[0].Version != "1.0.0" {
+		t.Fatalf("Expected the latest version labeled env=prod to be 1.0.0, but got %+v (error %v)\n", latest.Versions, err)
+	}
+
+	for _, invalid := range []string{"env", "=prod", "env=prod,,build=100"} {
+		if _, err := catalog.QueryCatalog(CatalogQueryParams{LabelSelector: invalid}); err == nil {
+			t.Fatalf("Expected an error from the invalid label selector '%v'\n", invalid)
+		}
+	}
+
+	labeled := Provider{Name: "virtualbox", Labels: map[string]string{"env": "prod"}}
+	relabeled := Provider{Name: "virtualbox", Labels: map[string]string{"env": "staging"}}
+	unlabeled := Provider{Name: "virtualbox", Labels: map[string]string{}}
+	if labeled.Equals(&relabeled) || labeled.Equals(&unlabeled) || !unlabeled.Equals(&Provider{Name: "virtualbox"}) {
+		t.Fatalf("Expected providers to be equal only if their labels are\n")
+	}
+	if display := catalog.DisplayString(); !strings.Contains(display, " labels build=100,env=prod\n") {
+		t.Fatalf("Expected labels in the display string, but got:\n%v\n", display)
+	}
+}
+
+// Optional fields are omitted from providers that do not have them, so older catalogs round trip unchanged
+func TestJsonProviderOptionalFieldsRoundTrip(t *testing.T) {
+	for _, jstring := range []string{
+		`{"name":"testname","url":"http://example.com/whatever","checksum_type":"dummy","checksum":"dummy"}`,
+		`{"name":"testname","url":"http://example.com/whatever","checksum_type":"dummy","checksum":"dummy","architecture":"arm64"}`,
+		`{"name":"testname","url":"http://example.com/whatever","checksum_type":"dummy","checksum":"dummy","size":1073741824}`,
+		`{"name":"testname","url":"http://example.com/whatever","checksum_type":"dummy","checksum":"dummy","released_at":"2024-01-01T12:00:00Z"}`,
+		`{"name":"testname","url":"http://example.com/whatever","checksum_type":"dummy","checksum":"dummy","labels":{"build":"1234","env":"prod"}}`,
+	} {
+		var prov Provider
+		if err := json.Unmarshal([]byte(jstring), &prov); err != nil {
+			t.Fatalf("Error unmarshalling JSON: %v\n", err)
+		}
+		result, err := json.Marshal(prov)
+		if err != nil {
+			t.Fatalf("Error marshalling JSON: %v\n", err)
+		}
+		if string(result) != jstring {
+			t.Fatalf("Expected JSON to round trip unchanged as:\n%v\nBut got:\n%v\n", jstring, string(result))
+		}
+	}
+}
+
+func TestCatalogPruneCandidates(t *testing.T) {
+	type TestCase struct {
+		Keep             int
+		PrunePrereleases bool
+		Expected         []string
+	}
+	testCases := []TestCase{
+		TestCase{3, false, []string{"1.2.3", "1.0.1", "1.0.0", "0.3.5", "0.3.5-BETA", "0.3.4"}},
+		TestCase{3, true, []string{"1.2.3", "1.0.1", "1.0.0", "0.3.5", "0.3.5-BETA", "0.3.4"}},
+		TestCase{7, false, []string{"0.3.5-BETA", "0.3.4"}},
+		TestCase{7, true, []string{"0.3.5-BETA", "0.3.4"}},
+		TestCase{8, true, []string{"0.3.5-BETA"}},
+		TestCase{9, false, []string{}},
+		TestCase{9, true, []string{"0.3.5-BETA"}},
+		TestCase{0, false, []string{"2.11.1", "1.4.5", "1.2.4", "1.2.3", "1.0.1", "1.0.0", "0.3.5", "0.3.5-BETA", "0.3.4"}},
+	}
+	for _, tc := range testCases {
+		result, err := testCatalog.PruneCandidates(tc.Keep, tc.PrunePrereleases)
+		if err != nil {
+			t.Fatalf("PruneCandidates(%v, %v) returned an error: %v\n", tc.Keep, tc.PrunePrereleases, err)
+		}
+		resultVersions := []string{}
+		for _, v := range result.Versions {
+			resultVersions = append(resultVersions, v.Version)
+		}
+		if fmt.Sprintf("%v", resultVersions) != fmt.Sprintf("%v", tc.Expected) {
+			t.Fatalf("PruneCandidates(%v, %v): expected %v but got %v\n", tc.Keep, tc.PrunePrereleases, tc.Expected, resultVersions)
+		}
+	}
+
+	if _, err := testCatalog.PruneCandidates(-1, false); err == nil {
+		t.Fatalf("PruneCandidates() did not fail when asked to keep a negative number of versions\n")
+	}
+}
+
+func TestCatalogPruneToSize(t *testing.T) {
+	sized := func(name string, size int64) Provider {
+		return Provider{Name: name, Url: "FAKEURI", ChecksumType: "sha1", Checksum: "0xB00B1E5", Size: size}
+	}
+	// Versions are out of order, so that they must be sorted semantically; 1.10.0 is the newest
+	catalog := Catalog{Name: "SizedBox", Versions: []Version{
+		Version{Version: "1.9.0", Providers: []Provider{sized("virtualbox", 30), sized("libvirt", 10)}},
+		Version{Version: "1.10.0", Providers: []Provider{sized("virtualbox", 50)}},
+		Version{Version: "1.2.0", Providers: []Provider{sized("virtualbox", 20)}},
+		Version{Version: "1.11.0-BETA", Providers: []Provider{sized("virtualbox", 5)}},
+		Version{Version: "1.0.0", Providers: []Provider{sized("virtualbox", 1)}},
+	}}
+
+	type TestCase struct {
+		MaxTotalSize      int64
+		PrunePrereleases  bool
+		Expected          []string
+		ExpectedRemaining int64
+	}
+	testCases := []TestCase{
+		TestCase{1000, false, []string{}, 116},
+		TestCase{116, false, []string{}, 116},
+		TestCase{115, false, []string{"1.0.0"}, 115},
+		// 1.0.0 would fit on its own, but it is older than 1.2.0, which does not
+		TestCase{100, false, []string{"1.2.0", "1.0.0"}, 95},
+		TestCase{94, false, []string{"1.9.0", "1.2.0", "1.0.0"}, 55},
+		TestCase{94, true, []string{"1.11.0-BETA", "1.2.0", "1.0.0"}, 90},
+		TestCase{4, false, []string{"1.11.0-BETA", "1.10.0", "1.9.0", "1.2.0", "1.0.0"}, 0},
+		TestCase{0, false, []string{"1.11.0-BETA", "1.10.0", "1.9.0", "1.2.0", "1.0.0"}, 0},
+	}
+	for _, tc := range testCases {
+		result, remaining, err := catalog.PruneToSize(tc.MaxTotalSize, tc.PrunePrereleases)
+		if err != nil {
+			t.Fatalf("PruneToSize(%v, %v) returned an error: %v\n", tc.MaxTotalSize, tc.PrunePrereleases, err)
+		}
+		resultVersions := []string{}
+		for _, v := range result.Versions {
+			resultVersions = append(resultVersions, v.Version)
+		}
+		if fmt.Sprintf("%v", resultVersions) != fmt.Sprintf("%v", tc.Expected) || remaining != tc.ExpectedRemaining {
+			t.Fatalf("PruneToSize(%v, %v): expected %v leaving %v bytes, but got %v leaving %v bytes\n", tc.MaxTotalSize, tc.PrunePrereleases, tc.Expected, tc.ExpectedRemaining, resultVersions, remaining)
+		}
+	}
+
+	if _, _, err := catalog.PruneToSize(-1, false); err == nil {
+		t.Fatalf("PruneToSize() did not fail when asked to keep a negative total size\n")
+	}
+	catalog.Versions[2].Providers[0].Size = 0
+	if _, _, err := catalog.PruneToSize(1000, false); !errors.Is(err, ErrUnknownSize) {
+		t.Fatalf("Expected PruneToSize() to fail with ErrUnknownSize for a box without a size, but got: %v\n", err)
+	}
+}
+
+func TestCatalogMerge(t *testing.T) {
+	pDest := Provider{Name: "StrongSapling", Url: "file:///dest/box", ChecksumType: "sha256", Checksum: "0xDEC0DE"}
+	pSrc := Provider{Name: "StrongSapling", Url: "file:///src/box", ChecksumType: "sha256", Checksum: "0xB00B1E5"}
+	pSrcArm := Provider{Name: "StrongSapling", Url: "file:///src/box_arm64", ChecksumType: "sha256", Checksum: "0xB00B1E5", Architecture: "arm64"}
+	pOther := Provider{Name: "FeebleFungus", Url: "file:///src/other", ChecksumType: "sha256", Checksum: "0xB00B1E5"}
+
+	dest := Catalog{Name: "DestBox", Description: "Destination box", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{pDest, pDest}},
+		Version{Version: "1.1.0", Providers: []Provider{pDest}},
+	}}
+	src := Catalog{Name: "SourceBox", Description: "Source box", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{pSrc, pSrcArm, pOther}},
+		Version{Version: "2.0.0", Providers: []Provider{pSrc, pSrc}},
+	}}
+
+	expected := Catalog{Name: "DestBox", Description: "Destination box", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{pDest, pSrcArm, pOther}},
+		Version{Version: "1.1.0", Providers: []Provider{pDest}},
+		Version{Version: "2.0.0", Providers: []Provider{pSrc}},
+	}}
+	if result := dest.Merge(&src, false); !result.Equals(&expected) {
+		t.Fatalf("Expected merged catalog:\n%v\nBut got:\n%v\n", expected.DisplayString(), result.DisplayString())
+	}
+
+	expected.Versions[0].Providers[0] = pSrc
+	if result := dest.Merge(&src, true); !result.Equals(&expected) {
+		t.Fatalf("Expected overwritten merged catalog:\n%v\nBut got:\n%v\n", expected.DisplayString(), result.DisplayString())
+	}
+
+	empty := Catalog{}
+	if result := empty.Merge(&src, false); result.Name != src.Name || result.Description != src.Description {
+		t.Fatalf("Expected merging into an empty catalog to take the source name and description, but got %v\n", result)
+	}
+
+	// A v-prefixed version is the same version as its plain form
+	prefixed := Catalog{Name: "SourceBox", Description: "Source box", Versions: []Version{Version{Version: "v1.1.0", Providers: []Provider{pOther}}}}
+	expected = Catalog{Name: "DestBox", Description: "Destination box", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{pDest}},
+		Version{Version: "1.1.0", Providers: []Provider{pDest, pOther}},
+	}}
+	if result := dest.Merge(&prefixed, false); !result.Equals(&expected) {
+		t.Fatalf("Expected merged catalog:\n%v\nBut got:\n%v\n", expected.DisplayString(), result.DisplayString())
+	}
+}
+
+func TestCatalogDiff(t *testing.T) {
+	pOld := Provider{Name: "StrongSapling", Url: "file:///old/box", ChecksumType: "sha256", Checksum: "0xB00B1E5"}
+	pNew := Provider{Name: "StrongSapling", Url: "s3://new/box", ChecksumType: "sha256", Checksum: "0xB00B1E5"}
+	pNewArm := Provider{Name: "StrongSapling", Url: "s3://new/box_arm64", ChecksumType: "sha256", Checksum: "0xB00B1E5", Architecture: "arm64"}
+	pChanged := Provider{Name: "StrongSapling", Url: "s3://new/box", ChecksumType: "sha256", Checksum: "0xDEC0DE"}
+
+	oldCatalog := Catalog{Name: "DiffBox", Description: "A box", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{pOld}},
+		Version{Version: "1.1.0", Providers: []Provider{pOld}},
+	}}
+	movedCatalog := Catalog{Name: "DiffBox", Description: "A box", Versions: []Version{
+		Version{Version: "1.1.0", Providers: []Provider{pNew}},
+		Version{Version: "1.0.0", Providers: []Provider{pNew}},
+	}}
+	prefixedCatalog := Catalog{Name: "DiffBox", Description: "A box", Versions: []Version{
+		Version{Version: "v1.0.0", Providers: []Provider{pOld}},
+		Version{Version: "v1.1.0", Providers: []Provider{pOld}},
+	}}
+	changedCatalog := Catalog{Name: "DiffBox", Description: "A box", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{pChanged, pNewArm}},
+		Version{Version: "2.0.0", Providers: []Provider{pNew}},
+	}}
+
+	type TestCase struct {
+		Other    Catalog
+		Params   CatalogFuzzyEqualsParams
+		Expected []string
+	}
+	testCases := []TestCase{
+		TestCase{oldCatalog, CatalogFuzzyEqualsParams{}, nil},
+		TestCase{prefixedCatalog, CatalogFuzzyEqualsParams{}, nil},
+		TestCase{movedCatalog, CatalogFuzzyEqualsParams{}, []string{
+			"1.0.0 StrongSapling: URL differs: 'file:///old/box' in the first catalog, 's3://new/box' in the second",
+			"1.1.0 StrongSapling: URL differs: 'file:///old/box' in the first catalog, 's3://new/box' in the second",
+		}},
+		TestCase{movedCatalog, CatalogFuzzyEqualsParams{SkipProviderUrl: true}, nil},
+		TestCase{changedCatalog, CatalogFuzzyEqualsParams{SkipProviderUrl: true}, []string{
+			"1.0.0 StrongSapling: Checksum differs: '0xB00B1E5' in the first catalog, '0xDEC0DE' in the second",
+			"1.0.0 StrongSapling/arm64: Only in the second catalog",
+			"1.1.0: Only in the first catalog",
+			"2.0.0: Only in the second catalog",
+		}},
+	}
+	for idx, tc := range testCases {
+		var result []string
+		for _, difference := range oldCatalog.Diff(&tc.Other, tc.Params) {
+			result = append(result, difference.String())
+		}
+		if len(result) != len(tc.Expected) {
+			t.Fatalf("Test case %v: expected differences %v, but got %v\n", idx, tc.Expected, result)
+		}
+		for ridx := range result {
+			if result[ridx] != tc.Expected[ridx] {
+				t.Fatalf("Test case %v: expected difference '%v', but got '%v'\n", idx, tc.Expected[ridx], result[ridx])
+			}
+		}
+	}
+}
+
+func TestCatalogStats(t *testing.T) {
+	feebleCatalog, err := testCatalog.QueryCatalog(CatalogQueryParams{Provider: tParams.ProviderNames[1]})
+	if err != nil {
+		t.Fatalf("QueryCatalog() returned an error: %v\n", err)
+	}
+	sizedCatalog := Catalog{Name: "SizedBox", Description: "A box", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{
+			Provider{Name: "StrongSapling", Size: 1024},
+			Provider{Name: "StrongSapling", Architecture: "arm64"},
+		}},
+		Version{Version: "1.1.0", Providers: []Provider{Provider{Name: "StrongSapling", Size: 2048}}},
+		Version{Version: "not-a-version", Providers: []Provider{Provider{Name: "StrongSapling", Size: 4096}}},
+	}}
+
+	type TestCase struct {
+		Catalog  Catalog
+		Expected CatalogStats
+		Display  string
+	}
+	testCases := []TestCase{
+		TestCase{
+			testCatalog,
+			CatalogStats{Versions: 9, Providers: 11, ProviderNames: []string{"FeebleFungus", "StrongSapling"}, OldestVersion: "0.3.4", NewestVersion: "2.11.1", UnsizedProviders: 11},
+			"Versions: 9\nProviders: 11\nProvider names: FeebleFungus, StrongSapling\nOldest version: 0.3.4\nNewest version: 2.11.1\nTotal size: unknown\n",
+		},
+		TestCase{
+			feebleCatalog,
+			CatalogStats{Versions: 5, Providers: 5, ProviderNames: []string{"FeebleFungus"}, OldestVersion: "0.3.4", NewestVersion: "2.11.1", UnsizedProviders: 5},
+			"",
+		},
+		TestCase{
+			sizedCatalog,
+			CatalogStats{Versions: 3, Providers: 4, ProviderNames: []string{"StrongSapling"}, OldestVersion: "1.0.0", NewestVersion: "1.1.0", TotalSize: 7168, UnsizedProviders: 1},
+			"Versions: 3\nProviders: 4\nProvider names: StrongSapling\nOldest version: 1.0.0\nNewest version: 1.1.0\nTotal size: 7.0 KiB, not counting 1 providers of unknown size\n",
+		},
+		TestCase{
+			Catalog{},
+			CatalogStats{ProviderNames: []string{}},
+			"Versions: 0\nProviders: 0\nProvider names: \nOldest version: \nNewest version: \nTotal size: unknown\n",
+		},
+	}
+	for _, tc := range testCases {
+		result := tc.Catalog.Stats()
+		if !reflect.DeepEqual(result, tc.Expected) {
+			t.Fatalf("Expected stats for catalog '%v' to be %+v, but got %+v\n", tc.Catalog.Name, tc.Expected, result)
+		}
+		if tc.Display != "" && result.DisplayString() != tc.Display {
+			t.Fatalf("Expected stats for catalog '%v' to display as:\n%v\nBut got:\n%v\n", tc.Catalog.Name, tc.Display, result.DisplayString())
+		}
+	}
+}
+
+func TestCatalogDeduplicate(t *testing.T) {
+	catalogUri := "file:///catalog/root/DedupBox.json"
+	artifact := BoxArtifact{Name: "DedupBox", Description: "desc", Version: "1.0.0", Provider: "virtualbox", ChecksumType: "sha256", Checksum: "0xOLD"}
+
+	// Adding the same version and provider twice, as a retried build would, replaces the first entry
+	catalog := Catalog{}
+	for _, checksum := range []string{"0xOLD", "0xNEW"} {
+		artifact.Checksum = checksum
+		if err := catalog.AddBox(catalogUri, artifact); err != nil {
+			t.Fatalf("AddBox() returned an error: %v\n", err)
+		}
+	}
+	if len(catalog.Versions) != 1 || len(catalog.Versions[0].Providers) != 1 || catalog.Versions[0].Providers[0].Checksum != "0xNEW" {
+		t.Fatalf("Expected a single provider with the latest checksum, but got:\n%v\n", catalog.DisplayString())
+	}
+
+	// Catalogs written before AddBox() replaced entries may already have duplicates
+	pOld := Provider{Name: "virtualbox", Url: "file:///catalog/root/DedupBox/DedupBox_1.0.0_virtualbox.box", ChecksumType: "sha256", Checksum: "0xOLD"}
+	pNew := Provider{Name: "virtualbox", Url: "file:///catalog/root/DedupBox/DedupBox_1.0.0_virtualbox.box", ChecksumType: "sha256", Checksum: "0xNEW"}
+	pArm := Provider{Name: "virtualbox", Url: "file:///catalog/root/DedupBox/DedupBox_1.0.0_virtualbox_arm64.box", ChecksumType: "sha256", Checksum: "0xNEW", Architecture: "arm64"}
+	duplicated := Catalog{Name: "DedupBox", Description: "desc", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{pOld, pArm, pNew}},
+		Version{Version: "1.1.0", Providers: []Provider{pNew}},
+		Version{Version: "1.0.0", Providers: []Provider{pNew}},
+	}}
+	expected := Catalog{Name: "DedupBox", Description: "desc", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{pNew, pArm}},
+		Version{Version: "1.1.0", Providers: []Provider{pNew}},
+	}}
+	result, removed := duplicated.Deduplicate()
+	if !result.Equals(&expected) || removed != 2 {
+		t.Fatalf("Expected 2 duplicates removed, leaving:\n%v\nBut removed %v, leaving:\n%v\n", expected.DisplayString(), removed, result.DisplayString())
+	}
+	if _, removed = expected.Deduplicate(); removed != 0 {
+		t.Fatalf("Expected no duplicates in a deduplicated catalog, but removed %v\n", removed)
+	}
+	duplicates := Catalog{Name: "DedupBox", Description: "desc", Versions: []Version{Version{Version: "1.0.0", Providers: []Provider{pOld, pNew}}}}
+	if result = duplicated.duplicateProviders(); !result.Equals(&duplicates) {
+		t.Fatalf("Expected the removed duplicates to be:\n%v\nBut got:\n%v\n", duplicates.DisplayString(), result.DisplayString())
+	}
+
+	// A v-prefixed version is a duplicate of its plain form
+	prefixed := Catalog{Name: "DedupBox", Description: "desc", Versions: []Version{
+		Version{Version: "v1.0.0", Providers: []Provider{pOld}},
+		Version{Version: "1.0.0", Providers: []Provider{pNew}},
+	}}
+	expected = Catalog{Name: "DedupBox", Description: "desc", Versions: []Version{Version{Version: "v1.0.0", Providers: []Provider{pNew}}}}
+	if result, removed = prefixed.Deduplicate(); !result.Equals(&expected) || removed != 1 {
+		t.Fatalf("Expected 1 duplicate removed, leaving:\n%v\nBut removed %v, leaving:\n%v\n", expected.DisplayString(), removed, result.DisplayString())
+	}
+}
+
+func TestCatalogCheck(t *testing.T) {
+	good := Provider{Name: "StrongSapling", Url: "file:///catalog/box.box", ChecksumType: "sha256", Checksum: "0xB00B1E5"}
+
+	type TestCase struct {
+		Description string
+		Catalog     Catalog
+		Expected    []string
+	}
+	testCases := []TestCase{
+		TestCase{"valid catalog", testCatalog, []string{}},
+		TestCase{"empty catalog", Catalog{}, []string{"The catalog has no name"}},
+		TestCase{
+			"version without providers",
+			Catalog{Name: "CheckBox", Versions: []Version{Version{Version: "1.0.0", Providers: []Provider{}}}},
+			[]string{"1.0.0: No providers"},
+		},
+		TestCase{
+			"invalid version",
+			Catalog{Name: "CheckBox", Versions: []Version{Version{Version: "1.0.x", Providers: []Provider{good}}}},
+			[]string{"1.0.x: Invalid version"},
+		},
+		TestCase{
+			"duplicate provider across duplicate versions",
+			Catalog{Name: "CheckBox", Versions: []Version{Version{Version: "1.0.0", Providers: []Provider{good}}, Version{Version: "1.0.0", Providers: []Provider{good}}}},
+			[]string{"1.0.0 StrongSapling: Duplicate provider"},
+		},
+		TestCase{
+			"URL relative to the catalog",
+			Catalog{Name: "CheckBox", Versions: []Version{Version{Version: "1.0.0", Providers: []Provider{
+				Provider{Name: "StrongSapling", Url: "CheckBox/CheckBox_1.0.0_StrongSapling.box", ChecksumType: "sha256", Checksum: "0xB00B1E5"},
+			}}}},
+			[]string{},
+		},
+		TestCase{
+			"malformed URLs",
+			Catalog{Name: "CheckBox", Versions: []Version{Version{Version: "1.0.0", Providers: []Provider{
+				Provider{Name: "NoScheme", Url: "/catalog/box.box"},
+				Provider{Name: "BadUrl", Url: "file://%zz"},
+				Provider{Name: "", Url: "file:///catalog/box.box"},
+			}}}},
+			[]string{"1.0.0 NoScheme: Invalid URL", "1.0.0 BadUrl: Invalid URL", "1.0.0: Provider has no name"},
+		},
+	}
+
+	for _, tc := range testCases {
+		problems := tc.Catalog.Check()
+		if len(problems) != len(tc.Expected) {
+			t.Fatalf("Test '%v': expected %v problems but got %v: %v\n", tc.Description, len(tc.Expected), len(problems), problems)
+		}
+		for idx, problem := range problems {
+			if !strings.HasPrefix(problem.String(), tc.Expected[idx]) {
+				t.Fatalf("Test '%v': expected problem '%v' but got '%v'\n", tc.Description, tc.Expected[idx], problem)
+			}
+		}
+	}
+}
+
+func TestCatalogSortedByRelease(t *testing.T) {
+	catalog := Catalog{
+		Name: "ReleaseBox",
+		Versions: []Version{
+			Version{Version: "1.0.0", Providers: []Provider{
+				Provider{Name: "virtualbox", ReleasedAt: "2024-03-01T00:00:00Z"},
+			}},
+			Version{Version: "2.0.0", Providers: []Provider{
+				Provider{Name: "hyperv", ReleasedAt: "2024-02-02T00:00:00Z"},
+				Provider{Name: "virtualbox", ReleasedAt: "2024-02-01T00:00:00Z"},
+			}},
+			Version{Version: "0.9.0", Providers: []Provider{
+				Provider{Name: "virtualbox"},
+			}},
+		},
+	}
+
+	result := catalog.SortedByRelease()
+	order := []string{}
+	for _, v := range result.Versions {
+		for _, p := range v.Providers {
+			order = append(order, fmt.Sprintf("%v/%v", v.Version, p.Name))
+		}
+	}
+	expected := []string{"0.9.0/virtualbox", "2.0.0/virtualbox", "2.0.0/hyperv", "1.0.0/virtualbox"}
+	if strings.Join(order, " ") != strings.Join(expected, " ") {
+		t.Fatalf("Expected boxes sorted by release as %v, but got %v\n", expected, order)
+	}
+	if catalog.Versions[0].Version != "1.0.0" || catalog.Versions[1].Providers[0].Name != "hyperv" {
+		t.Fatalf("SortedByRelease() modified the original catalog\n")
+	}
+}
+
+func TestQueryCatalogSort(t *testing.T) {
+	type TestCase struct {
+		Params   CatalogQueryParams
+		Expected []string
+	}
+
+	// The mixed version set, with release times that do not follow the semantic versions
+	catalog := testCatalog.clone()
+	released := map[string]string{
+		"0.3.4":      "2024-01-05T00:00:00Z",
+		"0.3.5-BETA": "2024-01-01T00:00:00Z",
+		"0.3.5":      "2024-01-02T00:00:00Z",
+		"1.0.0":      "2024-01-09T00:00:00Z",
+		"1.0.1":      "2024-01-03T00:00:00Z",
+		"1.2.3":      "2024-01-08T00:00:00Z",
+		"1.2.4":      "2024-01-04T00:00:00Z",
+		"1.4.5":      "2024-01-07T00:00:00Z",
+		"2.11.1":     "2024-01-06T00:00:00Z",
+	}
+	for vidx := range catalog.Versions {
+		for pidx := range catalog.Versions[vidx].Providers {
+			catalog.Versions[vidx].Providers[pidx].ReleasedAt = released[catalog.Versions[vidx].Version]
+		}
+	}
+
+	testCases := []TestCase{
+		TestCase{CatalogQueryParams{Sort: SortVersionAsc}, []string{"0.3.4", "0.3.5-BETA", "0.3.5", "1.0.0", "1.0.1", "1.2.3", "1.2.4", "1.4.5", "2.11.1"}},
+		TestCase{CatalogQueryParams{Sort: SortVersionDesc}, []string{"2.11.1", "1.4.5", "1.2.4", "1.2.3", "1.0.1", "1.0.0", "0.3.5", "0.3.5-BETA", "0.3.4"}},
+		TestCase{CatalogQueryParams{Sort: SortDateAsc}, []string{"0.3.5-BETA", "0.3.5", "1.0.1", "1.2.4", "0.3.4", "2.11.1", "1.4.5", "1.2.3", "1.0.0"}},
+		TestCase{CatalogQueryParams{Sort: SortDateDesc}, []string{"1.0.0", "1.2.3", "1.4.5", "2.11.1", "0.3.4", "1.2.4", "1.0.1", "0.3.5", "0.3.5-BETA"}},
+		TestCase{CatalogQueryParams{Limit: 2}, []string{"0.3.4", "0.3.5-BETA"}},
+		TestCase{CatalogQueryParams{Sort: SortVersionDesc, Limit: 3}, []string{"2.11.1", "1.4.5", "1.2.4"}},
+		TestCase{CatalogQueryParams{Sort: SortDateDesc, Offset: 2, Limit: 2}, []string{"1.4.5", "2.11.1"}},
+		TestCase{CatalogQueryParams{Sort: SortDateAsc, Version: ">=1", Offset: 1}, []string{"1.2.4", "2.11.1", "1.4.5", "1.2.3", "1.0.0"}},
+	}
+	for _, tc := range testCases {
+		result, err := catalog.QueryCatalog(tc.Params)
+		if err != nil {
+			t.Fatalf("QueryCatalog(%+v) returned an error: %v\n", tc.Params, err)
+		}
+		versions := []string{}
+		for _, v := range result.Versions {
+			versions = append(versions, v.Version)
+		}
+		if strings.Join(versions, " ") != strings.Join(tc.Expected, " ") {
+			t.Fatalf("QueryCatalog(%+v) returned versions %v, but we expected %v\n", tc.Params, versions, tc.Expected)
+		}
+	}
+
+	if _, err := catalog.QueryCatalog(CatalogQueryParams{Sort: "sideways"}); err == nil {
+		t.Fatalf("Expected an unknown sort order to return an error\n")
+	}
+	for name, expected := range map[string]SortOrder{"version": SortVersionAsc, "released": SortDateAsc, "date-desc": SortDateDesc} {
+		if order, err := ParseSortOrder(name); err != nil || order != expected {
+			t.Fatalf("ParseSortOrder('%v') returned '%v' and error %v, but we expected '%v'\n", name, order, err, expected)
+		}
+	}
+}
+
+func TestCatalogTableString(t *testing.T) {
+	catalog := Catalog{Name: "TableBox", Description: "A box in a table", Versions: []Version{
+		Version{Version: "1.0.0", Providers: []Provider{
+			Provider{Name: "virtualbox", Url: "file:///b/1.box", ChecksumType: "sha256", Checksum: "0123456789abcdef0123456789abcdef"},
+			Provider{Name: "箱子", Architecture: "arm64", Url: "file:///b/2.box", ChecksumType: "md5", Checksum: "0xB00B1E5"},
+		}},
+		Version{Version: "1.10.0", Providers: []Provider{
+			Provider{Name: "libvirt", Url: "file:///b/3.box"},
+		}},
+	}}
+
+	expected := "" +
+		"Version  Provider    Architecture  Checksum Type  Checksum          URL\n" +
+		"1.0.0    virtualbox                sha256         0123456789abcde…  file:///b/1.box\n" +
+		"1.0.0    箱子        arm64         md5            0xB00B1E5         file:///b/2.box\n" +
+		"1.10.0   libvirt                                                    file:///b/3.box\n"
+	if result := catalog.TableString(); result != expected {
+		t.Fatalf("TableString() returned\n%v\nbut expected\n%v\n", result, expected)
+	}
+
+	empty := Catalog{Name: "EmptyBox"}
+	if result := empty.TableString(); result != "Version  Provider  Architecture  Checksum Type  Checksum  URL\n" {
+		t.Fatalf("TableString() for an empty catalog returned\n%v\n", result)
+	}
+}
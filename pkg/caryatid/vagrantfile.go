@@ -0,0 +1,75 @@
+/*
+Vagrantfile snippets that consume a Vagrant catalog
+*/
+
+package caryatid
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// shellSafeArgument matches arguments that can be passed to a shell without quoting
+var shellSafeArgument = regexp.MustCompile(`^[A-Za-z0-9_./:@%+=,-]+$`)
+
+// shellQuote quotes an argument for a POSIX shell, unless it doesn't need it
+func shellQuote(arg string) string {
+	if shellSafeArgument.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// VagrantfileSnippet returns a Vagrantfile that uses the box in catalog, as served from catalogUrl,
+// followed by the equivalent 'vagrant box add' command
+// It pins the highest version in catalog, which is usually the result of a query; see QueryCatalog()
+// If every provider of that version has the same name, or the same architecture, the command also selects it
+// If the catalog has no versions, the result is an error
+func VagrantfileSnippet(catalog *Catalog, catalogUrl string) (snippet string, err error) {
+	latest, err := catalog.LatestVersion()
+	if err != nil {
+		return
+	} else if len(latest.Versions) == 0 {
+		err = fmt.Errorf("No versions of box '%v' matched", catalog.Name)
+		return
+	}
+	version := latest.Versions[0]
+
+	providerName := ""
+	architecture := ""
+	for idx, provider := range version.Providers {
+		if idx == 0 {
+			providerName, architecture = provider.Name, provider.Architecture
+			continue
+		}
+		if provider.Name != providerName {
+			providerName = ""
+		}
+		if provider.Architecture != architecture {
+			architecture = ""
+		}
+	}
+
+	snippet += "# In a Vagrantfile:\n"
+	snippet += "Vagrant.configure(\"2\") do |config|\n"
+	snippet += fmt.Sprintf("  config.vm.box = %q\n", catalog.Name)
+	snippet += fmt.Sprintf("  config.vm.box_url = %q\n", catalogUrl)
+	snippet += fmt.Sprintf("  config.vm.box_version = %q\n", version.Version)
+	if architecture != "" {
+		snippet += fmt.Sprintf("  config.vm.box_architecture = %q\n", architecture)
+	}
+	snippet += "end\n"
+	snippet += "\n"
+	snippet += "# Or, to add the box by hand:\n"
+	command := []string{"vagrant", "box", "add", "--box-version", shellQuote(version.Version)}
+	if providerName != "" {
+		command = append(command, "--provider", shellQuote(providerName))
+	}
+	if architecture != "" {
+		command = append(command, "--architecture", shellQuote(architecture))
+	}
+	command = append(command, shellQuote(catalogUrl))
+	snippet += strings.Join(command, " ") + "\n"
+	return
+}
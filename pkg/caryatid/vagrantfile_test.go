@@ -0,0 +1,69 @@
+package caryatid
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVagrantfileSnippet(t *testing.T) {
+	catalog := Catalog{Name: "SnippetBox", Description: "desc", Versions: []Version{
+		Version{Version: "1.10.0", Providers: []Provider{
+			Provider{Name: "virtualbox", Url: "file:///catalog/SnippetBox_1.10.0_virtualbox.box", ChecksumType: "sha256", Checksum: "0xB00B1E5", Architecture: "amd64"},
+		}},
+		Version{Version: "1.2.0", Providers: []Provider{
+			Provider{Name: "virtualbox", Url: "file:///catalog/SnippetBox_1.2.0_virtualbox.box", ChecksumType: "sha256", Checksum: "0xDECAFBAD"},
+			Provider{Name: "libvirt", Url: "file:///catalog/SnippetBox_1.2.0_libvirt.box", ChecksumType: "sha256", Checksum: "0xC0FFEE"},
+		}},
+	}}
+
+	type TestCase struct {
+		Catalog    Catalog
+		CatalogUrl string
+		Expected   []string
+		Unexpected []string
+	}
+	testCases := []TestCase{
+		TestCase{
+			catalog,
+			"https://boxes.example.com/SnippetBox.json",
+			[]string{
+				`config.vm.box = "SnippetBox"`,
+				`config.vm.box_url = "https://boxes.example.com/SnippetBox.json"`,
+				`config.vm.box_version = "1.10.0"`,
+				`config.vm.box_architecture = "amd64"`,
+				"vagrant box add --box-version 1.10.0 --provider virtualbox --architecture amd64 https://boxes.example.com/SnippetBox.json\n",
+			},
+			[]string{"1.2.0"},
+		},
+		TestCase{
+			Catalog{Name: "SnippetBox", Description: "desc", Versions: catalog.Versions[1:]},
+			"file:///srv/my boxes/SnippetBox.json",
+			[]string{
+				`config.vm.box_version = "1.2.0"`,
+				"vagrant box add --box-version 1.2.0 'file:///srv/my boxes/SnippetBox.json'\n",
+			},
+			[]string{"--provider", "box_architecture"},
+		},
+	}
+
+	for _, tc := range testCases {
+		snippet, err := VagrantfileSnippet(&tc.Catalog, tc.CatalogUrl)
+		if err != nil {
+			t.Fatalf("VagrantfileSnippet() returned an error: %v\n", err)
+		}
+		for _, expected := range tc.Expected {
+			if !strings.Contains(snippet, expected) {
+				t.Fatalf("Expected the snippet to contain '%v', but got:\n%v", expected, snippet)
+			}
+		}
+		for _, unexpected := range tc.Unexpected {
+			if strings.Contains(snippet, unexpected) {
+				t.Fatalf("Expected the snippet not to contain '%v', but got:\n%v", unexpected, snippet)
+			}
+		}
+	}
+
+	if _, err := VagrantfileSnippet(&Catalog{Name: "SnippetBox"}, "file:///SnippetBox.json"); err == nil {
+		t.Fatalf("Expected VagrantfileSnippet() of a catalog without versions to return an error\n")
+	}
+}
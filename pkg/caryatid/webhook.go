@@ -0,0 +1,61 @@
+/*
+Notifications sent to a URL when the catalog changes
+*/
+
+package caryatid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultWebhookTimeout is the WebhookTimeout of a new BackendManager
+const DefaultWebhookTimeout = 10 * time.Second
+
+// ValidateWebhookUrl returns an error if webhookUrl is not an http:// or https:// URL
+func ValidateWebhookUrl(webhookUrl string) (err error) {
+	parsed, err := url.Parse(webhookUrl)
+	if err != nil {
+		return fmt.Errorf("Invalid webhook URL '%v': %v", webhookUrl, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("Invalid webhook URL '%v': must be an http:// or https:// URL", webhookUrl)
+	}
+	return
+}
+
+// postWebhook POSTs each entry to bm.WebhookUrl, if it is set, as a JSON object in the same format as the audit log
+// Methods that change the catalog call it after saving the catalog, so a failure is only logged
+func (bm *BackendManager) postWebhook(entries []AuditEntry) {
+	if bm.WebhookUrl == "" || len(entries) == 0 {
+		return
+	}
+
+	client := &http.Client{Timeout: bm.WebhookTimeout}
+	for _, entry := range entries {
+		if err := postWebhookEntry(client, bm.WebhookUrl, entry); err != nil {
+			bm.log().Warnf("postWebhook(): Could not notify the webhook at '%v' of the %v of %v %v: %v; continuing anyway\n", bm.WebhookUrl, entry.Action, entry.Name, entry.Version, err)
+		}
+	}
+}
+
+// postWebhookEntry POSTs one entry to webhookUrl, and returns an error if the server does not respond with a 2xx status
+func postWebhookEntry(client *http.Client, webhookUrl string, entry AuditEntry) (err error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	response, err := client.Post(webhookUrl, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		err = fmt.Errorf("Webhook responded with status %v", response.Status)
+	}
+	return
+}
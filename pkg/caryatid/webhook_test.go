@@ -0,0 +1,136 @@
+package caryatid
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackendManagerWebhook(t *testing.T) {
+	var (
+		boxName     = "WebhookBox"
+		boxProvider = "StrongSapling"
+		boxPath     = path.Join(integrationTestDir, "incoming-TestBackendManagerWebhook.box")
+		catalogUri  = fmt.Sprintf("mem://TestBackendManagerWebhook/%v.json", boxName)
+
+		payloads     []map[string]interface{}
+		payloadsLock sync.Mutex
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected a POST of application/json, but got a %v of '%v'\n", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var payload map[string]interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("Webhook payload '%v' is not a JSON object: %v\n", string(body), err)
+		}
+		payloadsLock.Lock()
+		payloads = append(payloads, payload)
+		payloadsLock.Unlock()
+	}))
+	defer server.Close()
+
+	if err := CreateTestBoxFile(boxPath, boxProvider, true); err != nil {
+		t.Fatalf("Error creating test box file: %v\n", err)
+	}
+	backend, err := NewBackendFromUri(catalogUri)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v\n", err)
+	}
+	manager := NewBackendManager(catalogUri, &backend)
+	manager.WebhookUrl = server.URL
+
+	before := time.Now().UTC().Add(-time.Second)
+	for _, version := range []string{"1.0.0", "2.0.0", "3.0.0"} {
+		if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: version, Provider: boxProvider, ChecksumType: "sha256", Checksum: "0xB00B1E5"}); err != nil {
+			t.Fatalf("AddBox() returned an unexpected error: %v\n", err)
+		}
+	}
+	if _, err = manager.DeleteBox(CatalogQueryParams{Version: "1.0.0"}); err != nil {
+		t.Fatalf("DeleteBox() returned an unexpected error: %v\n", err)
+	}
+	if _, err = manager.PruneVersions(CatalogQueryParams{}, 1, false); err != nil {
+		t.Fatalf("PruneVersions() returned an unexpected error: %v\n", err)
+	}
+
+	type TestCase struct {
+		Action  string
+		Version string
+	}
+	expected := []TestCase{
+		TestCase{AuditActionAdd, "1.0.0"},
+		TestCase{AuditActionAdd, "2.0.0"},
+		TestCase{AuditActionAdd, "3.0.0"},
+		TestCase{AuditActionDelete, "1.0.0"},
+		TestCase{AuditActionPrune, "2.0.0"},
+	}
+	if len(payloads) != len(expected) {
+		t.Fatalf("Expected %v webhook payloads, but got %v: %v\n", len(expected), len(payloads), payloads)
+	}
+	for idx, payload := range payloads {
+		if payload["action"] != expected[idx].Action || payload["version"] != expected[idx].Version {
+			t.Fatalf("Expected webhook payload %v to be for the %v of %v, but got %v\n", idx, expected[idx].Action, expected[idx].Version, payload)
+		}
+		if payload["name"] != boxName || payload["provider"] != boxProvider || payload["checksum_type"] != "sha256" || payload["checksum"] != "0xB00B1E5" || payload["catalog_uri"] != catalogUri {
+			t.Fatalf("Webhook payload %v does not describe the box: %v\n", idx, payload)
+		}
+		timestamp, err := time.Parse(time.RFC3339, fmt.Sprintf("%v", payload["timestamp"]))
+		if err != nil || timestamp.Before(before) {
+			t.Fatalf("Webhook payload %v has an unexpected timestamp '%v'\n", idx, payload["timestamp"])
+		}
+	}
+
+	// A webhook that fails, or takes longer than WebhookTimeout, does not stop the catalog from changing
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Nope", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	manager.WebhookUrl = failing.URL
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: "4.0.0", Provider: boxProvider}); err != nil {
+		t.Fatalf("AddBox() failed because of a failing webhook: %v\n", err)
+	}
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	manager.WebhookUrl = slow.URL
+	manager.WebhookTimeout = 100 * time.Millisecond
+	started := time.Now()
+	if err = manager.AddBox(BoxArtifact{Path: boxPath, Name: boxName, Description: "A test box", Version: "5.0.0", Provider: boxProvider}); err != nil {
+		t.Fatalf("AddBox() failed because of a slow webhook: %v\n", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("Expected WebhookTimeout to bound the webhook request, but AddBox() took %v\n", elapsed)
+	}
+}
+
+func TestValidateWebhookUrl(t *testing.T) {
+	type TestCase struct {
+		Url   string
+		Valid bool
+	}
+	testCases := []TestCase{
+		TestCase{"https://hooks.example.com/services/T000/B000", true},
+		TestCase{"http://localhost:8080/hook", true},
+		TestCase{"ftp://example.com/hook", false},
+		TestCase{"hooks.example.com/hook", false},
+		TestCase{"https://", false},
+		TestCase{"://", false},
+	}
+	for _, tc := range testCases {
+		if err := ValidateWebhookUrl(tc.Url); (err == nil) != tc.Valid {
+			t.Fatalf("ValidateWebhookUrl('%v') returned error %v, but we expected valid to be %v\n", tc.Url, err, tc.Valid)
+		}
+	}
+}